@@ -1,10 +1,115 @@
 package api
 
-import "context"
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
 
 type LotusProvider interface {
 	Version(context.Context) (Version, error) //perm:admin
 
+	// Quiesce stops this node from claiming new tasks. Running tasks are left to finish.
+	Quiesce(context.Context) error //perm:admin
+	// Unquiesce resumes claiming new tasks.
+	Unquiesce(context.Context) error //perm:admin
+
+	// ComputeWindowPoSt schedules WindowPoSt compute for all partitions of a
+	// deadline right away, outside of the regular schedule. Unless submit is
+	// set, proofs are recorded in harmony_test instead of being sent. The
+	// deadline challenge must be on chain, and when submitting the deadline
+	// must not have closed yet. Fails if the deadline already has compute
+	// tasks in progress.
+	ComputeWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64, submit bool) (WdPoStCompute, error) //perm:admin
+	// WindowPoStComputeStatus returns the current state of the tasks started
+	// by ComputeWindowPoSt.
+	WindowPoStComputeStatus(ctx context.Context, c WdPoStCompute) (WdPoStCompute, error) //perm:admin
+
+	// PauseWindowPoSt stops scheduling WindowPoSt for a deadline of a miner,
+	// cluster-wide and across restarts, until ResumeWindowPoSt is called.
+	PauseWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64, reason string) error //perm:admin
+	// ResumeWindowPoSt resumes WindowPoSt for a paused deadline, scheduling
+	// compute for it right away if it is due.
+	ResumeWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64) error //perm:admin
+	// PausedWindowPoSt lists the deadlines WindowPoSt is paused for.
+	PausedWindowPoSt(ctx context.Context) ([]WdPoStPause, error) //perm:read
+
+	// StorageSetReadOnly switches the read-only mode of a storage path of
+	// this node. Read-only paths keep serving their sectors, but no new files
+	// are placed in them. The mode is persisted in the path metadata.
+	StorageSetReadOnly(ctx context.Context, id storiface.ID, readOnly bool) error //perm:admin
+	// StorageBench measures the sequential and random read performance of a
+	// local storage path of this node, reading its sealed and update files.
+	// It refuses to run while proving tasks are pending, unless forced, and
+	// is aborted when the node claims a proving task while it runs.
+	StorageBench(ctx context.Context, id storiface.ID, opts StorageBenchOpts) (storiface.PathBench, error) //perm:admin
+
+	// Config returns the effective config of this node encoded as "toml" or
+	// "json", with secrets redacted.
+	Config(ctx context.Context, format string) (string, error) //perm:admin
+
+	// MetricsSnapshot returns the metrics of this node, as served at
+	// /debug/metrics, in the Prometheus text format.
+	MetricsSnapshot(ctx context.Context) (string, error) //perm:read
+
 	// Trigger shutdown
 	Shutdown(context.Context) error //perm:admin
 }
+
+// WdPoStCompute is a handle to WindowPoSt compute started with ComputeWindowPoSt.
+type WdPoStCompute struct {
+	Miner       address.Address
+	Deadline    uint64
+	PeriodStart abi.ChainEpoch
+	Submit      bool
+
+	Tasks []WdPoStComputeTask
+}
+
+type WdPoStComputeTask struct {
+	Partition uint64
+	TaskID    int64
+
+	// State is one of "queued", "running", "done", "failed" or "unknown"
+	State string
+	Error string
+	// Result is the proof as recorded in harmony_test, when not submitting
+	Result string
+}
+
+// Done returns true when none of the tasks is queued or running anymore.
+func (c WdPoStCompute) Done() bool {
+	for _, t := range c.Tasks {
+		if t.State == "queued" || t.State == "running" {
+			return false
+		}
+	}
+	return true
+}
+
+// WdPoStPause is a deadline WindowPoSt is paused for, see PauseWindowPoSt.
+type WdPoStPause struct {
+	Miner    address.Address
+	Deadline uint64
+	Reason   string
+	// PausedBy is the host the pause was requested from
+	PausedBy string
+	PausedAt time.Time
+}
+
+// StorageBenchOpts configures StorageBench.
+type StorageBenchOpts struct {
+	// Duration of each of the sequential and the random read phases
+	Duration time.Duration
+	// BlockSize of the random reads
+	BlockSize int64
+	// Force runs the benchmark while proving tasks are pending in the cluster,
+	// it is still aborted when this node claims one
+	Force bool
+	// Record keeps the result in the storage_path_bench table
+	Record bool
+}
@@ -836,9 +836,31 @@ type LotusProviderStruct struct {
 }
 
 type LotusProviderMethods struct {
+	ComputeWindowPoSt func(p0 context.Context, p1 address.Address, p2 uint64, p3 bool) (WdPoStCompute, error) `perm:"admin"`
+
+	Config func(p0 context.Context, p1 string) (string, error) `perm:"admin"`
+
+	MetricsSnapshot func(p0 context.Context) (string, error) `perm:"read"`
+
+	PauseWindowPoSt func(p0 context.Context, p1 address.Address, p2 uint64, p3 string) error `perm:"admin"`
+
+	PausedWindowPoSt func(p0 context.Context) ([]WdPoStPause, error) `perm:"read"`
+
+	Quiesce func(p0 context.Context) error `perm:"admin"`
+
+	ResumeWindowPoSt func(p0 context.Context, p1 address.Address, p2 uint64) error `perm:"admin"`
+
 	Shutdown func(p0 context.Context) error `perm:"admin"`
 
+	StorageBench func(p0 context.Context, p1 storiface.ID, p2 StorageBenchOpts) (storiface.PathBench, error) `perm:"admin"`
+
+	StorageSetReadOnly func(p0 context.Context, p1 storiface.ID, p2 bool) error `perm:"admin"`
+
+	Unquiesce func(p0 context.Context) error `perm:"admin"`
+
 	Version func(p0 context.Context) (Version, error) `perm:"admin"`
+
+	WindowPoStComputeStatus func(p0 context.Context, p1 WdPoStCompute) (WdPoStCompute, error) `perm:"admin"`
 }
 
 type LotusProviderStub struct {
@@ -5227,6 +5249,83 @@ func (s *GatewayStub) Web3ClientVersion(p0 context.Context) (string, error) {
 	return "", ErrNotSupported
 }
 
+func (s *LotusProviderStruct) ComputeWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64, p3 bool) (WdPoStCompute, error) {
+	if s.Internal.ComputeWindowPoSt == nil {
+		return *new(WdPoStCompute), ErrNotSupported
+	}
+	return s.Internal.ComputeWindowPoSt(p0, p1, p2, p3)
+}
+
+func (s *LotusProviderStub) ComputeWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64, p3 bool) (WdPoStCompute, error) {
+	return *new(WdPoStCompute), ErrNotSupported
+}
+
+func (s *LotusProviderStruct) Config(p0 context.Context, p1 string) (string, error) {
+	if s.Internal.Config == nil {
+		return "", ErrNotSupported
+	}
+	return s.Internal.Config(p0, p1)
+}
+
+func (s *LotusProviderStub) Config(p0 context.Context, p1 string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *LotusProviderStruct) MetricsSnapshot(p0 context.Context) (string, error) {
+	if s.Internal.MetricsSnapshot == nil {
+		return "", ErrNotSupported
+	}
+	return s.Internal.MetricsSnapshot(p0)
+}
+
+func (s *LotusProviderStub) MetricsSnapshot(p0 context.Context) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (s *LotusProviderStruct) PauseWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64, p3 string) error {
+	if s.Internal.PauseWindowPoSt == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.PauseWindowPoSt(p0, p1, p2, p3)
+}
+
+func (s *LotusProviderStub) PauseWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64, p3 string) error {
+	return ErrNotSupported
+}
+
+func (s *LotusProviderStruct) PausedWindowPoSt(p0 context.Context) ([]WdPoStPause, error) {
+	if s.Internal.PausedWindowPoSt == nil {
+		return *new([]WdPoStPause), ErrNotSupported
+	}
+	return s.Internal.PausedWindowPoSt(p0)
+}
+
+func (s *LotusProviderStub) PausedWindowPoSt(p0 context.Context) ([]WdPoStPause, error) {
+	return *new([]WdPoStPause), ErrNotSupported
+}
+
+func (s *LotusProviderStruct) Quiesce(p0 context.Context) error {
+	if s.Internal.Quiesce == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.Quiesce(p0)
+}
+
+func (s *LotusProviderStub) Quiesce(p0 context.Context) error {
+	return ErrNotSupported
+}
+
+func (s *LotusProviderStruct) ResumeWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64) error {
+	if s.Internal.ResumeWindowPoSt == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.ResumeWindowPoSt(p0, p1, p2)
+}
+
+func (s *LotusProviderStub) ResumeWindowPoSt(p0 context.Context, p1 address.Address, p2 uint64) error {
+	return ErrNotSupported
+}
+
 func (s *LotusProviderStruct) Shutdown(p0 context.Context) error {
 	if s.Internal.Shutdown == nil {
 		return ErrNotSupported
@@ -5238,6 +5337,39 @@ func (s *LotusProviderStub) Shutdown(p0 context.Context) error {
 	return ErrNotSupported
 }
 
+func (s *LotusProviderStruct) StorageBench(p0 context.Context, p1 storiface.ID, p2 StorageBenchOpts) (storiface.PathBench, error) {
+	if s.Internal.StorageBench == nil {
+		return *new(storiface.PathBench), ErrNotSupported
+	}
+	return s.Internal.StorageBench(p0, p1, p2)
+}
+
+func (s *LotusProviderStub) StorageBench(p0 context.Context, p1 storiface.ID, p2 StorageBenchOpts) (storiface.PathBench, error) {
+	return *new(storiface.PathBench), ErrNotSupported
+}
+
+func (s *LotusProviderStruct) StorageSetReadOnly(p0 context.Context, p1 storiface.ID, p2 bool) error {
+	if s.Internal.StorageSetReadOnly == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.StorageSetReadOnly(p0, p1, p2)
+}
+
+func (s *LotusProviderStub) StorageSetReadOnly(p0 context.Context, p1 storiface.ID, p2 bool) error {
+	return ErrNotSupported
+}
+
+func (s *LotusProviderStruct) Unquiesce(p0 context.Context) error {
+	if s.Internal.Unquiesce == nil {
+		return ErrNotSupported
+	}
+	return s.Internal.Unquiesce(p0)
+}
+
+func (s *LotusProviderStub) Unquiesce(p0 context.Context) error {
+	return ErrNotSupported
+}
+
 func (s *LotusProviderStruct) Version(p0 context.Context) (Version, error) {
 	if s.Internal.Version == nil {
 		return *new(Version), ErrNotSupported
@@ -5249,6 +5381,17 @@ func (s *LotusProviderStub) Version(p0 context.Context) (Version, error) {
 	return *new(Version), ErrNotSupported
 }
 
+func (s *LotusProviderStruct) WindowPoStComputeStatus(p0 context.Context, p1 WdPoStCompute) (WdPoStCompute, error) {
+	if s.Internal.WindowPoStComputeStatus == nil {
+		return *new(WdPoStCompute), ErrNotSupported
+	}
+	return s.Internal.WindowPoStComputeStatus(p0, p1)
+}
+
+func (s *LotusProviderStub) WindowPoStComputeStatus(p0 context.Context, p1 WdPoStCompute) (WdPoStCompute, error) {
+	return *new(WdPoStCompute), ErrNotSupported
+}
+
 func (s *NetStruct) ID(p0 context.Context) (peer.ID, error) {
 	if s.Internal.ID == nil {
 		return *new(peer.ID), ErrNotSupported
@@ -0,0 +1,64 @@
+package verifreg
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// RootKeyChange is a change of the verified registry root key.
+type RootKeyChange struct {
+	From address.Address
+	To   address.Address
+}
+
+// DiffRootKey compares the root keys of two verified registry states,
+// returning nil if the root key didn't change. A nil pre state is compared
+// as an undefined root key.
+func DiffRootKey(pre, cur State) (*RootKeyChange, error) {
+	from := address.Undef
+	if pre != nil {
+		var err error
+		from, err = pre.RootKey()
+		if err != nil {
+			return nil, xerrors.Errorf("getting previous root key: %w", err)
+		}
+	}
+
+	rk, err := cur.RootKey()
+	if err != nil {
+		return nil, xerrors.Errorf("getting root key: %w", err)
+	}
+
+	if rk == from {
+		return nil, nil
+	}
+
+	return &RootKeyChange{From: from, To: rk}, nil
+}
+
+// CheckRootKey compares the root key of a verified registry state against an
+// expected value, returning nil if they match. Both keys are resolved to ID
+// addresses with lookupID before comparing, as the expected key is usually
+// configured as a robust address, which the state may not hold.
+func CheckRootKey(cur State, expected address.Address, lookupID func(address.Address) (address.Address, error)) (*RootKeyChange, error) {
+	rk, err := cur.RootKey()
+	if err != nil {
+		return nil, xerrors.Errorf("getting root key: %w", err)
+	}
+
+	rkID, err := lookupID(rk)
+	if err != nil {
+		return nil, xerrors.Errorf("resolving root key %s: %w", rk, err)
+	}
+	expectedID, err := lookupID(expected)
+	if err != nil {
+		return nil, xerrors.Errorf("resolving expected root key %s: %w", expected, err)
+	}
+
+	if rkID == expectedID {
+		return nil, nil
+	}
+
+	return &RootKeyChange{From: expected, To: rk}, nil
+}
@@ -0,0 +1,79 @@
+package verifreg
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+func TestDiffRootKey(t *testing.T) {
+	store := adt.WrapStore(context.Background(), cbor.NewCborStore(blockstore.NewMemory()))
+
+	k1, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	k2, err := address.NewIDAddress(81)
+	require.NoError(t, err)
+
+	s1, err := MakeState(store, actorstypes.Version12, k1)
+	require.NoError(t, err)
+	s1b, err := MakeState(store, actorstypes.Version12, k1)
+	require.NoError(t, err)
+	s2, err := MakeState(store, actorstypes.Version12, k2)
+	require.NoError(t, err)
+
+	ch, err := DiffRootKey(s1, s1b)
+	require.NoError(t, err)
+	require.Nil(t, ch)
+
+	ch, err = DiffRootKey(s1, s2)
+	require.NoError(t, err)
+	require.Equal(t, &RootKeyChange{From: k1, To: k2}, ch)
+
+	ch, err = DiffRootKey(nil, s1)
+	require.NoError(t, err)
+	require.Equal(t, &RootKeyChange{From: address.Undef, To: k1}, ch)
+
+	// the expected key is resolved before comparing
+	r1, err := address.NewActorAddress([]byte("root key 1"))
+	require.NoError(t, err)
+	r2, err := address.NewActorAddress([]byte("root key 2"))
+	require.NoError(t, err)
+	ids := map[address.Address]address.Address{r1: k1, r2: k2, k1: k1, k2: k2}
+	lookupID := func(a address.Address) (address.Address, error) {
+		id, ok := ids[a]
+		if !ok {
+			return address.Undef, xerrors.Errorf("actor %s not found", a)
+		}
+		return id, nil
+	}
+
+	ch, err = CheckRootKey(s2, k1, lookupID)
+	require.NoError(t, err)
+	require.Equal(t, &RootKeyChange{From: k1, To: k2}, ch)
+
+	ch, err = CheckRootKey(s2, k2, lookupID)
+	require.NoError(t, err)
+	require.Nil(t, ch)
+
+	ch, err = CheckRootKey(s2, r2, lookupID)
+	require.NoError(t, err)
+	require.Nil(t, ch)
+
+	ch, err = CheckRootKey(s2, r1, lookupID)
+	require.NoError(t, err)
+	require.Equal(t, &RootKeyChange{From: r1, To: k2}, ch)
+
+	unknown, err := address.NewActorAddress([]byte("unknown"))
+	require.NoError(t, err)
+	_, err = CheckRootKey(s2, unknown, lookupID)
+	require.Error(t, err)
+}
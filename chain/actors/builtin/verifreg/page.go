@@ -0,0 +1,197 @@
+package verifreg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	hamt "github.com/filecoin-project/go-hamt-ipld/v3"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/cbor"
+
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+// DefaultPageSize is the page size used by GetAllocationsPage and
+// GetClaimsPage when the limit isn't positive.
+const DefaultPageSize = 1000
+
+// PageCursor is the position of a paged enumeration of allocations or claims.
+// Entries are returned in HAMT traversal order, which is fixed for a given
+// state; the cursor is the key of the last entry of the previous page. A nil
+// cursor starts at the first entry, and a nil cursor is returned with the
+// last page.
+//
+// A page resumes the traversal at the cursor: it only loads the nodes on the
+// path to the cursor's key and the nodes holding the entries of the page.
+//
+// A cursor is only valid against the state it was returned from: an
+// enumeration must be completed on the same State, and started over after
+// loading a newer one.
+type PageCursor []byte
+
+var (
+	errPageFull       = errors.New("page full")
+	errCursorNotFound = errors.New("page cursor not found in state")
+)
+
+// forEachPage calls cb for up to limit entries of the HAMT at root following
+// the cursor, with out holding the value of the entry. It returns the cursor
+// of the next page.
+//
+// The HAMT is walked directly rather than through adt.Map, which can only
+// iterate from the first entry. Pointers of a node are ordered by the bits of
+// the key hash they stand for, and buckets by key, so the entries following
+// the cursor are those past the cursor's key in its bucket, then those of the
+// pointers past the cursor's path at each level on the way back up.
+func forEachPage(store adt.Store, root cid.Cid, out cbor.Unmarshaler, cursor PageCursor, limit int, cb func(key string) error) (PageCursor, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+
+	w := &pageWalk{store: store, out: out, cursor: cursor, limit: limit, cb: cb}
+	if cursor != nil {
+		h := sha256.Sum256(cursor)
+		w.hash = h[:]
+	}
+
+	var nd hamt.Node
+	if err := store.Get(store.Context(), root, &nd); err != nil {
+		return nil, xerrors.Errorf("loading map root: %w", err)
+	}
+
+	err := w.walk(&nd, 0, cursor != nil)
+	switch {
+	case errors.Is(err, errPageFull):
+		return PageCursor(w.last), nil
+	case err != nil:
+		return nil, xerrors.Errorf("iterating: %w", err)
+	}
+
+	return nil, nil
+}
+
+type pageWalk struct {
+	store  adt.Store
+	out    cbor.Unmarshaler
+	cursor PageCursor
+	// hash of the cursor, which leads to it through the HAMT levels
+	hash  []byte
+	limit int
+	cb    func(key string) error
+
+	n    int
+	last string
+}
+
+// walk visits the entries of nd. While resuming, the pointers before the
+// cursor's path are skipped, and so are the entries up to the cursor.
+func (w *pageWalk) walk(nd *hamt.Node, depth int, resuming bool) error {
+	if nd.Bitfield == nil {
+		if resuming {
+			return errCursorNotFound
+		}
+		return nil
+	}
+
+	var at int
+	if resuming {
+		var err error
+		if at, err = hashIndex(w.hash, depth); err != nil {
+			return err
+		}
+		if nd.Bitfield.Bit(at) == 0 {
+			return errCursorNotFound
+		}
+	}
+
+	// Pointers are compacted, the i-th pointer is the one of the i-th set bit
+	var i int
+	for idx := 0; idx < 1<<builtin.DefaultHamtBitwidth; idx++ {
+		if nd.Bitfield.Bit(idx) == 0 {
+			continue
+		}
+		if i >= len(nd.Pointers) {
+			return xerrors.Errorf("malformed HAMT node: %d pointers for bitfield %x", len(nd.Pointers), nd.Bitfield)
+		}
+		p := nd.Pointers[i]
+		i++
+
+		if resuming && idx < at {
+			continue
+		}
+		onPath := resuming && idx == at
+
+		if p.Link.Defined() {
+			var child hamt.Node
+			if err := w.store.Get(w.store.Context(), p.Link, &child); err != nil {
+				return xerrors.Errorf("loading map node: %w", err)
+			}
+			if err := w.walk(&child, depth+1, onPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		kvs := p.KVs
+		if onPath {
+			pos := -1
+			for j, kv := range kvs {
+				if bytes.Equal(kv.Key, w.cursor) {
+					pos = j
+					break
+				}
+			}
+			if pos < 0 {
+				return errCursorNotFound
+			}
+			kvs = kvs[pos+1:]
+		}
+
+		for _, kv := range kvs {
+			if err := w.visit(kv); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (w *pageWalk) visit(kv *hamt.KV) error {
+	if w.n == w.limit {
+		// only stop once there is an entry past the page, so that the last
+		// page is returned with a nil cursor
+		return errPageFull
+	}
+
+	if err := w.out.UnmarshalCBOR(bytes.NewReader(kv.Value.Raw)); err != nil {
+		return xerrors.Errorf("decoding entry: %w", err)
+	}
+	if err := w.cb(string(kv.Key)); err != nil {
+		return err
+	}
+
+	w.n++
+	w.last = string(kv.Key)
+	return nil
+}
+
+// hashIndex returns the pointer index of the key with the given hash at a
+// depth of the HAMT: the depth-th group of bitwidth bits of the hash, most
+// significant bit first.
+func hashIndex(hash []byte, depth int) (int, error) {
+	const bw = builtin.DefaultHamtBitwidth
+	if (depth+1)*bw > len(hash)*8 {
+		return 0, xerrors.Errorf("HAMT deeper than the key hash")
+	}
+
+	var idx int
+	for b := depth * bw; b < (depth+1)*bw; b++ {
+		idx = idx<<1 | int(hash[b/8]>>(7-b%8)&1)
+	}
+	return idx, nil
+}
@@ -0,0 +1,167 @@
+package verifreg
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin"
+	adt10 "github.com/filecoin-project/go-state-types/builtin/v10/util/adt"
+	verifreg10 "github.com/filecoin-project/go-state-types/builtin/v10/verifreg"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+func TestAllocationsPagesV10(t *testing.T) {
+	store := adt.WrapStore(context.Background(), cbor.NewCborStore(blockstore.NewMemory()))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	vrs, err := MakeState(store, actorstypes.Version10, rootKey)
+	require.NoError(t, err)
+	st := vrs.GetState().(*verifreg10.State)
+
+	// enough entries for the HAMT to have several levels
+	const count = 1500
+
+	allocs, err := adt10.MakeEmptyMap(store, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	for id := uint64(1); id <= count; id++ {
+		a := verifreg10.Allocation{Client: 1000, Provider: 2000, Data: st.Verifiers, Size: abi.PaddedPieceSize(id)}
+		require.NoError(t, allocs.Put(abi.UIntKey(id), &a))
+	}
+	allocsRoot, err := allocs.Root()
+	require.NoError(t, err)
+
+	clients, err := adt10.AsMap(store, st.Allocations, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	require.NoError(t, clients.Put(abi.IdAddrKey(client), cbg.CborCid(allocsRoot)))
+	st.Allocations, err = clients.Root()
+	require.NoError(t, err)
+
+	all, err := vrs.GetAllocations(client)
+	require.NoError(t, err)
+	require.Len(t, all, count)
+
+	for _, limit := range []int{7, 333, 1000, count, count + 1} {
+		got := map[AllocationId]Allocation{}
+		var cursor PageCursor
+		pages := 0
+		for {
+			page, next, err := vrs.GetAllocationsPage(client, cursor, limit)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), limit)
+			pages++
+
+			for id, a := range page {
+				_, dup := got[id]
+				require.False(t, dup, "allocation %d returned twice", id)
+				got[id] = a
+			}
+
+			if next == nil {
+				break
+			}
+			require.Len(t, page, limit)
+			cursor = next
+		}
+
+		require.Equal(t, all, got, "limit %d", limit)
+		require.Equal(t, (count+limit-1)/limit, pages, "limit %d", limit)
+	}
+
+	// default page size
+	page, next, err := vrs.GetAllocationsPage(client, nil, 0)
+	require.NoError(t, err)
+	require.Len(t, page, DefaultPageSize)
+	require.NotNil(t, next)
+
+	// a cursor from another enumeration
+	_, _, err = vrs.GetAllocationsPage(client, PageCursor(abi.UIntKey(count+1).Key()), 10)
+	require.Error(t, err)
+
+	page, next, err = vrs.GetAllocationsPage(other, nil, 10)
+	require.NoError(t, err)
+	require.Empty(t, page)
+	require.Nil(t, next)
+
+	// claims are paged the same way
+	claims, next, err := vrs.GetClaimsPage(client, nil, 10)
+	require.NoError(t, err)
+	require.Empty(t, claims)
+	require.Nil(t, next)
+}
+
+func TestAllocationsPagesResume(t *testing.T) {
+	bs := &countingBlockstore{Blockstore: blockstore.NewMemory()}
+	store := adt.WrapStore(context.Background(), cbor.NewCborStore(bs))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	vrs, err := MakeState(store, actorstypes.Version10, rootKey)
+	require.NoError(t, err)
+	st := vrs.GetState().(*verifreg10.State)
+
+	const count = 5000
+
+	allocs, err := adt10.MakeEmptyMap(store, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	for id := uint64(1); id <= count; id++ {
+		a := verifreg10.Allocation{Client: 1000, Provider: 2000, Data: st.Verifiers, Size: abi.PaddedPieceSize(id)}
+		require.NoError(t, allocs.Put(abi.UIntKey(id), &a))
+	}
+	allocsRoot, err := allocs.Root()
+	require.NoError(t, err)
+
+	clients, err := adt10.AsMap(store, st.Allocations, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	require.NoError(t, clients.Put(abi.IdAddrKey(client), cbg.CborCid(allocsRoot)))
+	st.Allocations, err = clients.Root()
+	require.NoError(t, err)
+
+	bs.gets = 0
+	all, err := vrs.GetAllocations(client)
+	require.NoError(t, err)
+	require.Len(t, all, count)
+	full := bs.gets
+
+	var cursor PageCursor
+	var pages, maxGets int
+	got := map[AllocationId]Allocation{}
+	for {
+		bs.gets = 0
+		page, next, err := vrs.GetAllocationsPage(client, cursor, 10)
+		require.NoError(t, err)
+		pages++
+		if bs.gets > maxGets {
+			maxGets = bs.gets
+		}
+		for id, a := range page {
+			got[id] = a
+		}
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+	require.Equal(t, all, got)
+	require.Equal(t, count/10, pages)
+
+	// pages resume at the cursor, later pages don't walk the entries before
+	require.Less(t, maxGets, full/10, "a page loaded %d blocks, a full enumeration %d", maxGets, full)
+}
@@ -0,0 +1,22 @@
+package verifreg
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/cbor"
+
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+// forEachEntry calls cb for each entry of m, with out holding the value of
+// the entry. Entries are decoded one at a time as the HAMT is walked, so
+// memory use doesn't grow with the size of the map. It stops with the error
+// of ctx once ctx is done.
+func forEachEntry(ctx context.Context, m adt.Map, out cbor.Unmarshaler, cb func(key string) error) error {
+	return m.ForEach(out, func(key string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return cb(key)
+	})
+}
@@ -0,0 +1,123 @@
+package verifreg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/builtin"
+	adt10 "github.com/filecoin-project/go-state-types/builtin/v10/util/adt"
+	verifreg10 "github.com/filecoin-project/go-state-types/builtin/v10/verifreg"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+)
+
+// countingBlockstore counts block reads, to tell how much of the state an
+// enumeration loaded.
+type countingBlockstore struct {
+	blockstore.Blockstore
+	gets int
+}
+
+func (bs *countingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	bs.gets++
+	return bs.Blockstore.Get(ctx, c)
+}
+
+func TestForEachAllocationV10(t *testing.T) {
+	ctx := context.Background()
+	bs := &countingBlockstore{Blockstore: blockstore.NewMemory()}
+	store := adt.WrapStore(ctx, cbor.NewCborStore(bs))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	vrs, err := MakeState(store, actorstypes.Version10, rootKey)
+	require.NoError(t, err)
+	st := vrs.GetState().(*verifreg10.State)
+
+	const count = 5000
+
+	allocs, err := adt10.MakeEmptyMap(store, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	for id := uint64(1); id <= count; id++ {
+		a := verifreg10.Allocation{Client: 1000, Provider: 2000, Data: st.Verifiers, Size: abi.PaddedPieceSize(id)}
+		require.NoError(t, allocs.Put(abi.UIntKey(id), &a))
+	}
+	allocsRoot, err := allocs.Root()
+	require.NoError(t, err)
+
+	clients, err := adt10.AsMap(store, st.Allocations, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	require.NoError(t, clients.Put(abi.IdAddrKey(client), cbg.CborCid(allocsRoot)))
+	st.Allocations, err = clients.Root()
+	require.NoError(t, err)
+
+	// every allocation is passed to the callback once
+	seen := make(map[AllocationId]struct{}, count)
+	bs.gets = 0
+	err = vrs.ForEachAllocation(ctx, client, func(id AllocationId, a Allocation) error {
+		_, dup := seen[id]
+		require.False(t, dup, "allocation %d passed twice", id)
+		require.Equal(t, abi.PaddedPieceSize(id), a.Size)
+		seen[id] = struct{}{}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, seen, count)
+	fullGets := bs.gets
+
+	// stopping early only loads the part of the HAMT walked so far
+	stop := errors.New("stop")
+	var n int
+	bs.gets = 0
+	err = vrs.ForEachAllocation(ctx, client, func(AllocationId, Allocation) error {
+		n++
+		if n == 10 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 10, n)
+	require.Less(t, bs.gets*10, fullGets)
+
+	// cancelling the context stops the enumeration too
+	cctx, cancel := context.WithCancel(ctx)
+	n = 0
+	err = vrs.ForEachAllocation(cctx, client, func(AllocationId, Allocation) error {
+		n++
+		if n == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 10, n)
+
+	err = vrs.ForEachAllocation(ctx, other, func(AllocationId, Allocation) error {
+		t.Fatal("client without allocations")
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = vrs.ForEachClaim(ctx, client, func(ClaimId, Claim) error {
+		t.Fatal("provider without claims")
+		return nil
+	})
+	require.NoError(t, err)
+}
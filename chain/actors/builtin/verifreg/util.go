@@ -5,13 +5,31 @@ import (
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/specs-actors/v7/actors/builtin/verifreg"
 
 	"github.com/filecoin-project/lotus/chain/actors"
 	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/datacap"
 )
 
+// VerifiedClientDataCap returns the datacap of a verified client. Since actors
+// v9, client datacap is no longer tracked by the verified registry, but as
+// a token balance in the datacap actor ledger, so dcs must be provided for
+// those versions. dcs is ignored for earlier actor versions.
+func VerifiedClientDataCap(vrs State, dcs datacap.State, addr address.Address) (bool, abi.StoragePower, error) {
+	if vrs.ActorVersion() < actorstypes.Version9 {
+		return vrs.VerifiedClientDataCap(addr)
+	}
+
+	if dcs == nil {
+		return false, big.Zero(), xerrors.Errorf("datacap actor state required for verifreg actors v%d", vrs.ActorVersion())
+	}
+
+	return dcs.VerifiedClientDataCap(addr)
+}
+
 // taking this as a function instead of asking the caller to call it helps reduce some of the error
 // checking boilerplate.
 //
@@ -78,3 +96,10 @@ func getRemoveDataCapProposalID(store adt.Store, ver actors.Version, root rootFu
 
 	return true, id.ProposalID, nil
 }
+
+// ClaimRemovableAt returns the first epoch at which a claim can be removed
+// with RemoveExpiredClaims, which requires the maximum term to have fully
+// elapsed.
+func ClaimRemovableAt(c Claim) abi.ChainEpoch {
+	return c.TermStart + c.TermMax + 1
+}
@@ -0,0 +1,121 @@
+package verifreg
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+	cbg "github.com/whyrusleeping/cbor-gen"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	datacap10 "github.com/filecoin-project/go-state-types/builtin/v10/datacap"
+	adt10 "github.com/filecoin-project/go-state-types/builtin/v10/util/adt"
+	verifreg10 "github.com/filecoin-project/go-state-types/builtin/v10/verifreg"
+	verifreg9 "github.com/filecoin-project/go-state-types/builtin/v9/verifreg"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/datacap"
+)
+
+func TestVerifiedClientDataCapV10(t *testing.T) {
+	store := adt.WrapStore(context.Background(), cbor.NewCborStore(blockstore.NewMemory()))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	client, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	vrs, err := MakeState(store, actorstypes.Version10, rootKey)
+	require.NoError(t, err)
+
+	// the v10 verified registry doesn't know about client datacap
+	_, _, err = vrs.VerifiedClientDataCap(client)
+	require.Error(t, err)
+
+	dcs, err := datacap.MakeState(store, actorstypes.Version10, builtin.VerifiedRegistryActorAddr, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+
+	// give the client 1MiB of datacap in the token ledger
+	dcap := abi.NewStoragePower(1 << 20)
+	st := dcs.GetState().(*datacap10.State)
+	balances, err := adt10.AsMap(store, st.Token.Balances, int(st.Token.HamtBitWidth))
+	require.NoError(t, err)
+	tokens := big.Mul(dcap, verifreg9.DataCapGranularity)
+	require.NoError(t, balances.Put(abi.IdAddrKey(client), &tokens))
+	st.Token.Balances, err = balances.Root()
+	require.NoError(t, err)
+
+	found, got, err := VerifiedClientDataCap(vrs, dcs, client)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, dcap, got)
+
+	found, _, err = VerifiedClientDataCap(vrs, dcs, other)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	_, _, err = VerifiedClientDataCap(vrs, nil, client)
+	require.Error(t, err)
+}
+
+func TestGetRemovableClaimsV10(t *testing.T) {
+	store := adt.WrapStore(context.Background(), cbor.NewCborStore(blockstore.NewMemory()))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+	provider, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	vrs, err := MakeState(store, actorstypes.Version10, rootKey)
+	require.NoError(t, err)
+
+	st := vrs.GetState().(*verifreg10.State)
+
+	claims, err := adt10.MakeEmptyMap(store, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	// removable at 1000+500+1, 2000+500+1 and 1000+1500+1
+	for id, c := range map[verifreg10.ClaimId]verifreg10.Claim{
+		1: {Provider: 1000, TermStart: 1000, TermMin: 100, TermMax: 500},
+		2: {Provider: 1000, TermStart: 2000, TermMin: 100, TermMax: 500},
+		3: {Provider: 1000, TermStart: 1000, TermMin: 100, TermMax: 1500},
+	} {
+		c := c
+		c.Data = st.Verifiers // any valid CID
+		require.NoError(t, claims.Put(abi.UIntKey(uint64(id)), &c))
+	}
+	claimsRoot, err := claims.Root()
+	require.NoError(t, err)
+
+	providers, err := adt10.AsMap(store, st.Claims, builtin.DefaultHamtBitwidth)
+	require.NoError(t, err)
+	require.NoError(t, providers.Put(abi.IdAddrKey(provider), cbg.CborCid(claimsRoot)))
+	st.Claims, err = providers.Root()
+	require.NoError(t, err)
+
+	removable, err := vrs.GetRemovableClaims(provider, 1500)
+	require.NoError(t, err)
+	require.Empty(t, removable)
+
+	// the term must have fully elapsed, so the first removable epoch is one after the term end
+	removable, err = vrs.GetRemovableClaims(provider, 1501)
+	require.NoError(t, err)
+	require.Equal(t, map[ClaimId]abi.ChainEpoch{1: 1501}, removable)
+
+	removable, err = vrs.GetRemovableClaims(provider, 2501)
+	require.NoError(t, err)
+	require.Equal(t, map[ClaimId]abi.ChainEpoch{1: 1501, 2: 2501, 3: 2501}, removable)
+
+	removable, err = vrs.GetRemovableClaims(other, 10000)
+	require.NoError(t, err)
+	require.Empty(t, removable)
+}
@@ -1,9 +1,11 @@
 package verifreg
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -152,6 +154,145 @@ func (s *state11) GetClaimIdsBySector(providerIdAddr address.Address) (map[abi.S
 
 }
 
+func (s *state11) GetRemovableClaims(providerIdAddr address.Address, epoch abi.ChainEpoch) (map[ClaimId]abi.ChainEpoch, error) {
+
+	v11Map, err := s.LoadClaimsToMap(s.store, providerIdAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	retMap := make(map[ClaimId]abi.ChainEpoch)
+	for k, v := range v11Map {
+		if at := ClaimRemovableAt(Claim(v)); at <= epoch {
+			retMap[ClaimId(k)] = at
+		}
+	}
+
+	return retMap, nil
+
+}
+
+func (s *state11) GetAllocationsPage(clientIdAddr address.Address, cursor PageCursor, limit int) (map[AllocationId]Allocation, PageCursor, error) {
+
+	inner, found, err := s.innerRoot(s.Allocations, clientIdAddr)
+	if err != nil || !found {
+		return map[AllocationId]Allocation{}, nil, err
+	}
+
+	retMap := make(map[AllocationId]Allocation)
+	var alloc verifreg11.Allocation
+	next, err := forEachPage(s.store, inner, &alloc, cursor, limit, func(key string) error {
+		id, err := abi.ParseUIntKey(key)
+		if err != nil {
+			return xerrors.Errorf("parsing allocation id: %w", err)
+		}
+		retMap[AllocationId(id)] = Allocation(alloc)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return retMap, next, nil
+
+}
+
+func (s *state11) GetClaimsPage(providerIdAddr address.Address, cursor PageCursor, limit int) (map[ClaimId]Claim, PageCursor, error) {
+
+	inner, found, err := s.innerRoot(s.Claims, providerIdAddr)
+	if err != nil || !found {
+		return map[ClaimId]Claim{}, nil, err
+	}
+
+	retMap := make(map[ClaimId]Claim)
+	var claim verifreg11.Claim
+	next, err := forEachPage(s.store, inner, &claim, cursor, limit, func(key string) error {
+		id, err := abi.ParseUIntKey(key)
+		if err != nil {
+			return xerrors.Errorf("parsing claim id: %w", err)
+		}
+		retMap[ClaimId(id)] = Claim(claim)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return retMap, next, nil
+
+}
+
+func (s *state11) ForEachAllocation(ctx context.Context, clientIdAddr address.Address, cb func(AllocationId, Allocation) error) error {
+
+	m, err := s.innerMap(s.Allocations, clientIdAddr)
+	if err != nil || m == nil {
+		return err
+	}
+
+	var alloc verifreg11.Allocation
+	return forEachEntry(ctx, m, &alloc, func(key string) error {
+		id, err := abi.ParseUIntKey(key)
+		if err != nil {
+			return xerrors.Errorf("parsing allocation id: %w", err)
+		}
+		return cb(AllocationId(id), Allocation(alloc))
+	})
+
+}
+
+func (s *state11) ForEachClaim(ctx context.Context, providerIdAddr address.Address, cb func(ClaimId, Claim) error) error {
+
+	m, err := s.innerMap(s.Claims, providerIdAddr)
+	if err != nil || m == nil {
+		return err
+	}
+
+	var claim verifreg11.Claim
+	return forEachEntry(ctx, m, &claim, func(key string) error {
+		id, err := abi.ParseUIntKey(key)
+		if err != nil {
+			return xerrors.Errorf("parsing claim id: %w", err)
+		}
+		return cb(ClaimId(id), Claim(claim))
+	})
+
+}
+
+// innerRoot returns the root of the allocations or claims map of a client or
+// provider in the map of maps at root, and whether it has one.
+func (s *state11) innerRoot(root cid.Cid, idAddr address.Address) (cid.Cid, bool, error) {
+	if idAddr.Protocol() != address.ID {
+		return cid.Undef, false, xerrors.Errorf("can only look up ID addresses")
+	}
+
+	outer, err := adt11.AsMap(s.store, root, builtin11.DefaultHamtBitwidth)
+	if err != nil {
+		return cid.Undef, false, xerrors.Errorf("loading map: %w", err)
+	}
+
+	var inner cbg.CborCid
+	found, err := outer.Get(abi.IdAddrKey(idAddr), &inner)
+	if err != nil {
+		return cid.Undef, false, xerrors.Errorf("looking up %s: %w", idAddr, err)
+	}
+	return cid.Cid(inner), found, nil
+}
+
+// innerMap returns the allocations or claims map of a client or provider in
+// the map of maps at root, or nil if it has none.
+func (s *state11) innerMap(root cid.Cid, idAddr address.Address) (adt.Map, error) {
+	inner, found, err := s.innerRoot(root, idAddr)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	m, err := adt11.AsMap(s.store, inner, builtin11.DefaultHamtBitwidth)
+	if err != nil {
+		return nil, xerrors.Errorf("loading map of %s: %w", idAddr, err)
+	}
+	return m, nil
+}
+
 func (s *state11) ActorKey() string {
 	return manifest.VerifregKey
 }
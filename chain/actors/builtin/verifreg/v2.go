@@ -1,6 +1,7 @@
 package verifreg
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ipfs/go-cid"
@@ -124,6 +125,36 @@ func (s *state2) GetClaimIdsBySector(providerIdAddr address.Address) (map[abi.Se
 
 }
 
+func (s *state2) GetRemovableClaims(providerIdAddr address.Address, epoch abi.ChainEpoch) (map[ClaimId]abi.ChainEpoch, error) {
+
+	return nil, xerrors.Errorf("unsupported in actors v2")
+
+}
+
+func (s *state2) GetAllocationsPage(clientIdAddr address.Address, cursor PageCursor, limit int) (map[AllocationId]Allocation, PageCursor, error) {
+
+	return nil, nil, xerrors.Errorf("unsupported in actors v2")
+
+}
+
+func (s *state2) GetClaimsPage(providerIdAddr address.Address, cursor PageCursor, limit int) (map[ClaimId]Claim, PageCursor, error) {
+
+	return nil, nil, xerrors.Errorf("unsupported in actors v2")
+
+}
+
+func (s *state2) ForEachAllocation(ctx context.Context, clientIdAddr address.Address, cb func(AllocationId, Allocation) error) error {
+
+	return xerrors.Errorf("unsupported in actors v2")
+
+}
+
+func (s *state2) ForEachClaim(ctx context.Context, providerIdAddr address.Address, cb func(ClaimId, Claim) error) error {
+
+	return xerrors.Errorf("unsupported in actors v2")
+
+}
+
 func (s *state2) ActorKey() string {
 	return manifest.VerifregKey
 }
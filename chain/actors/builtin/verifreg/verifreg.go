@@ -1,6 +1,8 @@
 package verifreg
 
 import (
+	"context"
+
 	"github.com/ipfs/go-cid"
 	"golang.org/x/xerrors"
 
@@ -144,6 +146,24 @@ type State interface {
 	GetClaim(providerIdAddr address.Address, claimId ClaimId) (*Claim, bool, error)
 	GetClaims(providerIdAddr address.Address) (map[ClaimId]Claim, error)
 	GetClaimIdsBySector(providerIdAddr address.Address) (map[abi.SectorNumber][]ClaimId, error)
+	// GetRemovableClaims returns the claims of a provider whose maximum term has
+	// elapsed as of epoch, along with the first epoch at which each of them
+	// could be removed with RemoveExpiredClaims.
+	GetRemovableClaims(providerIdAddr address.Address, epoch abi.ChainEpoch) (map[ClaimId]abi.ChainEpoch, error)
+	// GetAllocationsPage returns up to limit allocations of a client starting
+	// at cursor, and the cursor of the next page, see PageCursor.
+	GetAllocationsPage(clientIdAddr address.Address, cursor PageCursor, limit int) (map[AllocationId]Allocation, PageCursor, error)
+	// GetClaimsPage returns up to limit claims of a provider starting at
+	// cursor, and the cursor of the next page, see PageCursor.
+	GetClaimsPage(providerIdAddr address.Address, cursor PageCursor, limit int) (map[ClaimId]Claim, PageCursor, error)
+	// ForEachAllocation calls cb for each allocation of a client, without
+	// loading all of them in memory. It stops at the first error returned by
+	// cb, or once ctx is done.
+	ForEachAllocation(ctx context.Context, clientIdAddr address.Address, cb func(AllocationId, Allocation) error) error
+	// ForEachClaim calls cb for each claim of a provider, without loading all
+	// of them in memory. It stops at the first error returned by cb, or once
+	// ctx is done.
+	ForEachClaim(ctx context.Context, providerIdAddr address.Address, cb func(ClaimId, Claim) error) error
 	GetState() interface{}
 }
 
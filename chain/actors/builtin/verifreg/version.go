@@ -0,0 +1,79 @@
+package verifreg
+
+import (
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/manifest"
+	builtin0 "github.com/filecoin-project/specs-actors/actors/builtin"
+	builtin2 "github.com/filecoin-project/specs-actors/v2/actors/builtin"
+	builtin3 "github.com/filecoin-project/specs-actors/v3/actors/builtin"
+	builtin4 "github.com/filecoin-project/specs-actors/v4/actors/builtin"
+	builtin5 "github.com/filecoin-project/specs-actors/v5/actors/builtin"
+	builtin6 "github.com/filecoin-project/specs-actors/v6/actors/builtin"
+	builtin7 "github.com/filecoin-project/specs-actors/v7/actors/builtin"
+
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// VersionForCode returns the actors version of a verified registry actor code.
+func VersionForCode(code cid.Cid) (actorstypes.Version, error) {
+	if name, av, ok := actors.GetActorMetaByCode(code); ok {
+		if name != manifest.VerifregKey {
+			return -1, xerrors.Errorf("actor code is not verifreg: %s", name)
+		}
+		return av, nil
+	}
+
+	switch code {
+	case builtin0.VerifiedRegistryActorCodeID:
+		return actorstypes.Version0, nil
+	case builtin2.VerifiedRegistryActorCodeID:
+		return actorstypes.Version2, nil
+	case builtin3.VerifiedRegistryActorCodeID:
+		return actorstypes.Version3, nil
+	case builtin4.VerifiedRegistryActorCodeID:
+		return actorstypes.Version4, nil
+	case builtin5.VerifiedRegistryActorCodeID:
+		return actorstypes.Version5, nil
+	case builtin6.VerifiedRegistryActorCodeID:
+		return actorstypes.Version6, nil
+	case builtin7.VerifiedRegistryActorCodeID:
+		return actorstypes.Version7, nil
+	}
+
+	return -1, xerrors.Errorf("unknown actor code %s", code)
+}
+
+// LoadVersioned loads the verified registry state of act, and returns the
+// actors version it was loaded at.
+func LoadVersioned(store adt.Store, act *types.Actor) (State, actorstypes.Version, error) {
+	av, err := VersionForCode(act.Code)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	st, err := Load(store, act)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return st, av, nil
+}
+
+// Reload loads the current verified registry state of act for a caller which
+// holds on to a state across tipsets. The implementation behind a State is
+// fixed to the actors version it was loaded at, so it must be loaded again
+// after every network upgrade changing the actor code; upgraded is set when
+// the version of act differs from the one of prev. prev may be nil.
+func Reload(store adt.Store, prev State, act *types.Actor) (st State, upgraded bool, err error) {
+	st, av, err := LoadVersioned(store, act)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return st, prev != nil && prev.ActorVersion() != av, nil
+}
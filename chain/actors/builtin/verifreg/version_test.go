@@ -0,0 +1,80 @@
+package verifreg
+
+import (
+	"context"
+	"testing"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	actorstypes "github.com/filecoin-project/go-state-types/actors"
+	"github.com/filecoin-project/go-state-types/manifest"
+	builtin7 "github.com/filecoin-project/specs-actors/v7/actors/builtin"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestLoadAcrossUpgrade(t *testing.T) {
+	ctx := context.Background()
+	store := adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewMemory()))
+
+	rootKey, err := address.NewIDAddress(80)
+	require.NoError(t, err)
+
+	mkActor := func(av actorstypes.Version) *types.Actor {
+		st, err := MakeState(store, av, rootKey)
+		require.NoError(t, err)
+		head, err := store.Put(ctx, st.GetState())
+		require.NoError(t, err)
+
+		code, ok := actors.GetActorCodeID(av, manifest.VerifregKey)
+		require.True(t, ok)
+		return &types.Actor{Code: code, Head: head}
+	}
+
+	act10 := mkActor(actorstypes.Version10)
+	act11 := mkActor(actorstypes.Version11)
+
+	st10, av, err := LoadVersioned(store, act10)
+	require.NoError(t, err)
+	require.Equal(t, actorstypes.Version10, av)
+	require.Equal(t, actorstypes.Version10, st10.ActorVersion())
+	require.IsType(t, &state10{}, st10)
+
+	// same version, no upgrade
+	st, upgraded, err := Reload(store, st10, act10)
+	require.NoError(t, err)
+	require.False(t, upgraded)
+	require.IsType(t, &state10{}, st)
+
+	// the actor code changed under a state loaded at v10
+	st, upgraded, err = Reload(store, st10, act11)
+	require.NoError(t, err)
+	require.True(t, upgraded)
+	require.Equal(t, actorstypes.Version11, st.ActorVersion())
+	require.IsType(t, &state11{}, st)
+
+	rk, err := st.RootKey()
+	require.NoError(t, err)
+	require.Equal(t, rootKey, rk)
+
+	// first load, nothing to upgrade from
+	_, upgraded, err = Reload(store, nil, act11)
+	require.NoError(t, err)
+	require.False(t, upgraded)
+
+	// legacy code IDs
+	av, err = VersionForCode(builtin7.VerifiedRegistryActorCodeID)
+	require.NoError(t, err)
+	require.Equal(t, actorstypes.Version7, av)
+
+	// not a verifreg actor
+	mcode, ok := actors.GetActorCodeID(actorstypes.Version10, manifest.MinerKey)
+	require.True(t, ok)
+	_, err = VersionForCode(mcode)
+	require.Error(t, err)
+}
@@ -434,6 +434,9 @@ var storageListCmd = &cli.Command{
 				if si.CanStore {
 					fmt.Print(color.CyanString("Store"))
 				}
+				if si.ReadOnly {
+					fmt.Print(color.HiYellowString(" (ReadOnly)"))
+				}
 			} else {
 				fmt.Print(color.HiYellowString("Use: ReadOnly"))
 			}
@@ -460,6 +463,14 @@ var storageListCmd = &cli.Command{
 				}
 			}
 
+			if len(si.FileCosts) > 0 {
+				var costs []string
+				for _, ft := range storiface.PathTypes {
+					costs = append(costs, fmt.Sprintf("%s=%d", ft, ft.Cost(si.FileCosts)))
+				}
+				fmt.Printf("\tFile Costs: %s\n", strings.Join(costs, " "))
+			}
+
 			if localPath, ok := local[s.ID]; ok {
 				fmt.Printf("\tLocal: %s\n", color.GreenString(localPath))
 			}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var addressAuditCmd = &cli.Command{
+	Name:  "address-audit",
+	Usage: "Show which control addresses sent messages, and why they were picked",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "since",
+			Usage: "only count messages sent in this period",
+			Value: 7 * 24 * time.Hour,
+		},
+		&cli.BoolFlag{
+			Name:  "messages",
+			Usage: "list individual messages instead of totals per address",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		since := time.Now().Add(-cctx.Duration("since"))
+
+		if cctx.Bool("messages") {
+			var rows []struct {
+				Addr       string    `db:"addr"`
+				PickReason string    `db:"pick_reason"`
+				SendReason string    `db:"send_reason"`
+				SendTaskID *int64    `db:"send_task_id"`
+				SignedCid  *string   `db:"signed_cid"`
+				MaxFee     string    `db:"max_fee"`
+				SentAt     time.Time `db:"sent_at"`
+			}
+			err = db.Select(ctx, &rows, `SELECT addr, pick_reason, send_reason, send_task_id, signed_cid, max_fee::text AS max_fee, sent_at
+				FROM message_address_audit WHERE sent_at > $1 ORDER BY sent_at`, since)
+			if err != nil {
+				return xerrors.Errorf("reading address audit: %w", err)
+			}
+
+			tw := tablewriter.New(
+				tablewriter.Col("Sent"),
+				tablewriter.Col("Address"),
+				tablewriter.Col("Picked"),
+				tablewriter.Col("Reason"),
+				tablewriter.Col("Task"),
+				tablewriter.Col("Message"),
+				tablewriter.Col("MaxFee"),
+			)
+			for _, r := range rows {
+				maxFee, err := types.BigFromString(r.MaxFee)
+				if err != nil {
+					return xerrors.Errorf("parsing max fee: %w", err)
+				}
+				m := map[string]interface{}{
+					"Sent":    r.SentAt.Format(time.DateTime),
+					"Address": r.Addr,
+					"Picked":  r.PickReason,
+					"Reason":  r.SendReason,
+					"MaxFee":  types.FIL(maxFee).Short(),
+				}
+				if r.SendTaskID != nil {
+					m["Task"] = *r.SendTaskID
+				}
+				if r.SignedCid != nil {
+					m["Message"] = *r.SignedCid
+				}
+				tw.Write(m)
+			}
+			return tw.Flush(os.Stdout)
+		}
+
+		var rows []struct {
+			Addr     string    `db:"addr"`
+			Messages int64     `db:"messages"`
+			Fallback int64     `db:"fallback"`
+			MaxFee   string    `db:"max_fee"`
+			LastSent time.Time `db:"last_sent"`
+		}
+		err = db.Select(ctx, &rows, `SELECT addr, COUNT(*) AS messages,
+				COUNT(*) FILTER (WHERE pick_reason <> 'funded') AS fallback,
+				SUM(max_fee)::text AS max_fee, MAX(sent_at) AS last_sent
+			FROM message_address_audit WHERE sent_at > $1 GROUP BY addr ORDER BY addr`, since)
+		if err != nil {
+			return xerrors.Errorf("reading address audit: %w", err)
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Address"),
+			tablewriter.Col("Messages"),
+			tablewriter.Col("Underfunded"),
+			tablewriter.Col("MaxFees"),
+			tablewriter.Col("LastSent"),
+		)
+		for _, r := range rows {
+			maxFee, err := types.BigFromString(r.MaxFee)
+			if err != nil {
+				return xerrors.Errorf("parsing max fee: %w", err)
+			}
+			tw.Write(map[string]interface{}{
+				"Address":     r.Addr,
+				"Messages":    r.Messages,
+				"Underfunded": r.Fallback,
+				"MaxFees":     types.FIL(maxFee).Short(),
+				"LastSent":    r.LastSent.Format(time.DateTime),
+			})
+		}
+		if err := tw.Flush(os.Stdout); err != nil {
+			return err
+		}
+
+		fmt.Println("\nMaxFees is the sum of GasFeeCap*GasLimit+Value, an upper bound on spend.")
+		return nil
+	},
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var clusterCmd = &cli.Command{
+	Name:  "cluster",
+	Usage: "View the nodes of the provider cluster",
+	Subcommands: []*cli.Command{
+		clusterNodesCmd,
+	},
+}
+
+var clusterNodesCmd = &cli.Command{
+	Name:  "nodes",
+	Usage: "List the nodes of the cluster with their heartbeat, tasks and miners",
+	Description: `Nodes heartbeat every minute. A node which missed heartbeats for longer than
+--stale-after is flagged as stale, it is removed from the cluster once it missed
+them for 10 minutes. Miners are the ones of the tasks the node is running, and
+those it is the WinningPoSt leader of.`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "stale-after",
+			Usage: "flag nodes without a heartbeat for this long as stale",
+			Value: 3 * time.Minute,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := clusterNodes(ctx, db.ReadReplica())
+		if err != nil {
+			return err
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("ID"),
+			tablewriter.Col("Name"),
+			tablewriter.Col("Host"),
+			tablewriter.Col("LastContact"),
+			tablewriter.Col("State"),
+			tablewriter.Col("CPU"),
+			tablewriter.Col("RAM"),
+			tablewriter.Col("GPU"),
+			tablewriter.Col("Weight"),
+			tablewriter.Col("Running"),
+			tablewriter.Col("Miners"),
+			tablewriter.Col("Labels"),
+			tablewriter.NewLineCol("Tasks"),
+		)
+		for _, n := range nodes {
+			age := time.Duration(n.AgeSecs) * time.Second
+
+			state := "ok"
+			switch {
+			case age > cctx.Duration("stale-after"):
+				state = "stale"
+			case n.Draining:
+				state = "draining"
+			}
+
+			tw.Write(map[string]interface{}{
+				"ID":          n.ID,
+				"Name":        n.Name,
+				"Host":        n.HostAndPort,
+				"LastContact": fmt.Sprintf("%s ago", age),
+				"State":       state,
+				"CPU":         n.CPU,
+				"RAM":         humanize.IBytes(uint64(n.RAM)),
+				"GPU":         n.GPU,
+				"Weight":      n.Weight,
+				"Running":     formatCounts(n.Running),
+				"Miners":      strings.Join(n.Miners, " "),
+				"Labels":      formatLabels(n.Labels),
+				"Tasks":       strings.Join(n.Tasks, " "),
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+type clusterNode struct {
+	ID          int64   `db:"id"`
+	HostAndPort string  `db:"host_and_port"`
+	Name        string  `db:"name"`
+	LabelsJSON  string  `db:"labels"`
+	CPU         int64   `db:"cpu"`
+	RAM         int64   `db:"ram"`
+	GPU         float64 `db:"gpu"`
+	Draining    bool    `db:"draining"`
+	Weight      int64   `db:"weight"`
+	AgeSecs     int64   `db:"age_secs"`
+
+	// task types the node runs
+	Tasks []string
+	// running task count per type
+	Running map[string]int64
+	Miners  []string
+	Labels  map[string]string
+}
+
+func clusterNodes(ctx context.Context, db harmonydb.Reader) ([]*clusterNode, error) {
+	var nodes []*clusterNode
+	err := db.Select(ctx, &nodes, `SELECT id, host_and_port, COALESCE(name, '') AS name, COALESCE(labels::text, '{}') AS labels,
+			cpu, ram, gpu, draining, weight,
+			EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - last_contact)::bigint AS age_secs
+		FROM harmony_machines ORDER BY id`)
+	if err != nil {
+		return nil, xerrors.Errorf("reading machines: %w", err)
+	}
+
+	byID := make(map[int64]*clusterNode, len(nodes))
+	for _, n := range nodes {
+		n.Running = map[string]int64{}
+		if err := json.Unmarshal([]byte(n.LabelsJSON), &n.Labels); err != nil {
+			return nil, xerrors.Errorf("decoding labels of machine %d: %w", n.ID, err)
+		}
+		byID[n.ID] = n
+	}
+
+	var impls []struct {
+		OwnerID int64  `db:"owner_id"`
+		Name    string `db:"name"`
+	}
+	err = db.Select(ctx, &impls, `SELECT owner_id, name FROM harmony_task_impl ORDER BY name`)
+	if err != nil {
+		return nil, xerrors.Errorf("reading task types: %w", err)
+	}
+	for _, i := range impls {
+		if n, ok := byID[i.OwnerID]; ok {
+			n.Tasks = append(n.Tasks, i.Name)
+		}
+	}
+
+	var running []struct {
+		OwnerID int64  `db:"owner_id"`
+		Name    string `db:"name"`
+		Count   int64  `db:"count"`
+	}
+	err = db.Select(ctx, &running, `SELECT owner_id, name, COUNT(*) AS count FROM harmony_task
+		WHERE owner_id IS NOT NULL GROUP BY owner_id, name`)
+	if err != nil {
+		return nil, xerrors.Errorf("reading running tasks: %w", err)
+	}
+	for _, r := range running {
+		if n, ok := byID[r.OwnerID]; ok {
+			n.Running[r.Name] = r.Count
+		}
+	}
+
+	var miners []struct {
+		OwnerID int64  `db:"owner_id"`
+		SpID    uint64 `db:"sp_id"`
+	}
+	err = db.Select(ctx, &miners, `SELECT DISTINCT owner_id, sp_id FROM (
+			SELECT t.owner_id, x.sp_id FROM harmony_task t JOIN (
+					SELECT task_id, sp_id FROM wdpost_partition_tasks
+					UNION ALL SELECT task_id, sp_id FROM wdpost_recovery_tasks
+					UNION ALL SELECT task_id, sp_id FROM wdpost_spot_check_tasks
+					UNION ALL SELECT task_id, sp_id FROM wdpost_canary_tasks
+					UNION ALL SELECT task_id, sp_id FROM wdpost_warm_tasks
+					UNION ALL SELECT task_id, sp_id FROM mining_tasks
+				) x ON x.task_id = t.id
+				WHERE t.owner_id IS NOT NULL
+			UNION ALL
+			SELECT m.id AS owner_id, l.sp_id FROM winpost_leaders l
+				JOIN harmony_machines m ON m.host_and_port = l.leader_host
+				WHERE l.lease_expires > CURRENT_TIMESTAMP
+		) handled ORDER BY sp_id`)
+	if err != nil {
+		return nil, xerrors.Errorf("reading miners: %w", err)
+	}
+	for _, m := range miners {
+		n, ok := byID[m.OwnerID]
+		if !ok {
+			continue
+		}
+		maddr, err := address.NewIDAddress(m.SpID)
+		if err != nil {
+			return nil, err
+		}
+		n.Miners = append(n.Miners, maddr.String())
+	}
+
+	return nodes, nil
+}
+
+// formatLabels prints labels as "a=x b=y", sorted by name.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = name + "=" + labels[name]
+	}
+	return strings.Join(out, " ")
+}
+
+// formatCounts prints counts by name as "a:1 b:2", sorted by name.
+func formatCounts(counts map[string]int64) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s:%d", name, counts[name]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +16,8 @@ import (
 	"github.com/urfave/cli/v2"
 	"golang.org/x/xerrors"
 
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/node/config"
 )
@@ -29,6 +33,8 @@ var configCmd = &cli.Command{
 		configViewCmd,
 		configRmCmd,
 		configMigrateCmd,
+		configRunningCmd,
+		configFindMinerCmd,
 	},
 }
 
@@ -209,9 +215,13 @@ var configViewCmd = &cli.Command{
 		},
 	},
 	Action: func(cctx *cli.Context) error {
-		db, err := makeDB(cctx)
-		if err != nil {
-			return err
+		var db *harmonydb.DB
+		if len(cctx.StringSlice("config-file")) == 0 {
+			var err error
+			db, err = makeDB(cctx)
+			if err != nil {
+				return err
+			}
 		}
 		lp, err := getConfig(cctx, db)
 		if err != nil {
@@ -226,7 +236,43 @@ var configViewCmd = &cli.Command{
 	},
 }
 
+var configRunningCmd = &cli.Command{
+	Name:  "running",
+	Usage: "Print the effective config of a running lotus provider, with secrets redacted",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format: toml or json",
+			Value: "toml",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		cfg, err := api.Config(lcli.ReqContext(cctx), cctx.String("format"))
+		if err != nil {
+			return err
+		}
+		fmt.Println(cfg)
+		return nil
+	},
+}
+
+// getConfig stacks the config layers selected with --layers from HarmonyDB
+// atop the defaults. When --config-file is set, config is read from local
+// files instead and db isn't used.
 func getConfig(cctx *cli.Context, db *harmonydb.DB) (*config.LotusProviderConfig, error) {
+	if files := cctx.StringSlice("config-file"); len(files) > 0 {
+		if cctx.IsSet("layers") {
+			log.Warnw("--layers is ignored when reading config from local files", "files", files)
+		}
+		return getConfigFromFiles(files)
+	}
+
 	lp := config.DefaultLotusProvider()
 	have := []string{}
 	layers := cctx.StringSlice("layers")
@@ -257,3 +303,42 @@ func getConfig(cctx *cli.Context, db *harmonydb.DB) (*config.LotusProviderConfig
 	// validate the config. Because of layering, we must validate @ startup.
 	return lp, nil
 }
+
+// getConfigFromFiles applies local TOML files in order atop the defaults, the
+// same way DB layers are stacked, followed by LOTUS_* environment overrides.
+// Unlike DB layers, local files aren't shared between the nodes of a cluster,
+// so this is only appropriate for a single node in development or CI.
+func getConfigFromFiles(files []string) (*config.LotusProviderConfig, error) {
+	lp := config.DefaultLotusProvider()
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			return nil, xerrors.Errorf("reading config file: %w", err)
+		}
+
+		_, err := config.FromFile(file, config.SetDefault(func() (interface{}, error) { return lp, nil }))
+		if err != nil {
+			return nil, xerrors.Errorf("loading config file %s: %w", file, err)
+		}
+	}
+	return lp, nil
+}
+
+// renderConfig encodes the config as toml (the default) or json.
+func renderConfig(lp *config.LotusProviderConfig, format string) (string, error) {
+	switch format {
+	case "", "toml":
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(lp); err != nil {
+			return "", xerrors.Errorf("encoding config: %w", err)
+		}
+		return buf.String(), nil
+	case "json":
+		b, err := json.MarshalIndent(lp, "", "  ")
+		if err != nil {
+			return "", xerrors.Errorf("encoding config: %w", err)
+		}
+		return string(b), nil
+	default:
+		return "", xerrors.Errorf("unknown config format %q, expected toml or json", format)
+	}
+}
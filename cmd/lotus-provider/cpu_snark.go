@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/provider"
+	"github.com/filecoin-project/lotus/storage/sealer/ffiwrapper"
+)
+
+// cpuSnarkCmd is started by the node when proving falls back to the CPU, see
+// Subsystems.ProvingCPUFallback.
+var cpuSnarkCmd = &cli.Command{
+	Name:   "cpu-snark",
+	Usage:  "Compute one PoSt snark read from stdin on the CPU",
+	Hidden: true,
+	Action: func(cctx *cli.Context) error {
+		if os.Getenv("BELLMAN_NO_GPU") == "" {
+			return xerrors.Errorf("BELLMAN_NO_GPU must be set, the command is run by the node")
+		}
+
+		sb, err := ffiwrapper.New(nil)
+		if err != nil {
+			return xerrors.Errorf("creating prover: %w", err)
+		}
+		return provider.ServeSnarkRequest(cctx.Context, os.Stdin, os.Stdout, sb)
+	},
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// clusterExportVersion is the version of the export file format.
+const clusterExportVersion = 1
+
+// clusterExport is the file format written by `db export`.
+type clusterExport struct {
+	Version  int
+	Exported time.Time
+	// Schema lists the applied HarmonyDB migrations. An export can only be
+	// imported into a database with the same migrations.
+	Schema []string
+	Tables []clusterTable
+}
+
+type clusterTable struct {
+	Name string
+	Rows []map[string]any
+}
+
+// migratedTable describes how one table is carried between clusters. Queries
+// are spelled out per table, harmonydb only runs constant SQL.
+type migratedTable struct {
+	name string
+	// clear lists columns which refer to machines of the old cluster, and
+	// are reset on import
+	clear []string
+
+	// export returns all rows as a JSON array
+	export func(ctx context.Context, db *harmonydb.DB) harmonydb.Row
+	load   func(tx *harmonydb.Tx, rows string) (int, error)
+	// reseed moves the table's id sequence past the imported rows
+	reseed func(tx *harmonydb.Tx) error
+}
+
+// decodeJSON decodes numbers as json.Number, so that bigint and numeric
+// values survive the round trip exactly.
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// unmigratedTables are the tables which aren't carried between clusters, with
+// the reason. Every table of the migrations must either be in
+// migratedTables or here.
+var unmigratedTables = map[string]string{
+	"harmony_machines":     "nodes register on start",
+	"harmony_task_impl":    "nodes register on start",
+	"harmony_task_follow":  "nodes register on start",
+	"storage_path":         "nodes attach their paths on start",
+	"sector_location":      "nodes declare their sectors when attaching paths",
+	"harmony_task_history": "history, summarized in harmony_task_history_summary",
+	"message_send_locks":   "lease of a sender, held by nodes of the old cluster",
+	"winpost_leaders":      "lease of a WinningPoSt leader, held by nodes of the old cluster",
+	"wdpost_dispute_watch": "chain position of the dispute watcher, which starts at the head without it",
+	"wdpost_verify_holds":  "proofs held for past deadlines, cleared once their partition verifies again",
+	"harmony_test":         "tests only",
+	"itest_scratch":        "tests only",
+}
+
+// migratedTables are the tables needed to pick up work where the old cluster
+// left it, and the results and records of the cluster, in an order which
+// satisfies foreign keys. See unmigratedTables for the others.
+var migratedTables = []migratedTable{
+	{
+		name: "harmony_config",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_config t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_config SELECT * FROM json_populate_recordset(NULL::harmony_config, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('harmony_config', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM harmony_config`)
+			return err
+		},
+	},
+	{
+		name:  "harmony_task",
+		clear: []string{"owner_id"},
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_task t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_task SELECT * FROM json_populate_recordset(NULL::harmony_task, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('harmony_task', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM harmony_task`)
+			return err
+		},
+	},
+	{
+		name: "harmony_task_cluster_claim",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_task_cluster_claim t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_task_cluster_claim SELECT * FROM json_populate_recordset(NULL::harmony_task_cluster_claim, $1::json)`, rows)
+		},
+	},
+	{
+		name: "harmony_task_release",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_task_release t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_task_release SELECT * FROM json_populate_recordset(NULL::harmony_task_release, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('harmony_task_release', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM harmony_task_release`)
+			return err
+		},
+	},
+	{
+		name: "harmony_prune_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_prune_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_prune_tasks SELECT * FROM json_populate_recordset(NULL::harmony_prune_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "message_sends",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM message_sends t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO message_sends SELECT * FROM json_populate_recordset(NULL::message_sends, $1::json)`, rows)
+		},
+	},
+	{
+		name: "harmony_task_history_summary",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM harmony_task_history_summary t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO harmony_task_history_summary SELECT * FROM json_populate_recordset(NULL::harmony_task_history_summary, $1::json)`, rows)
+		},
+	},
+	{
+		name: "message_waits",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM message_waits t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO message_waits SELECT * FROM json_populate_recordset(NULL::message_waits, $1::json)`, rows)
+		},
+	},
+	{
+		name: "message_address_audit",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM message_address_audit t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO message_address_audit SELECT * FROM json_populate_recordset(NULL::message_address_audit, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('message_address_audit', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM message_address_audit`)
+			return err
+		},
+	},
+	{
+		name: "wdpost_partition_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_partition_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_partition_tasks SELECT * FROM json_populate_recordset(NULL::wdpost_partition_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_partition_affinity",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_partition_affinity t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_partition_affinity SELECT * FROM json_populate_recordset(NULL::wdpost_partition_affinity, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_proofs",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_proofs t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_proofs SELECT * FROM json_populate_recordset(NULL::wdpost_proofs, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_direct_submits",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_direct_submits t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_direct_submits SELECT * FROM json_populate_recordset(NULL::wdpost_direct_submits, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('wdpost_direct_submits', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM wdpost_direct_submits`)
+			return err
+		},
+	},
+	{
+		name: "wdpost_paused_deadlines",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_paused_deadlines t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_paused_deadlines SELECT * FROM json_populate_recordset(NULL::wdpost_paused_deadlines, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_recovery_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_recovery_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_recovery_tasks SELECT * FROM json_populate_recordset(NULL::wdpost_recovery_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_disputes",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_disputes t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_disputes SELECT * FROM json_populate_recordset(NULL::wdpost_disputes, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_vanilla_cache",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_vanilla_cache t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_vanilla_cache SELECT * FROM json_populate_recordset(NULL::wdpost_vanilla_cache, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_spot_check_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_spot_check_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_spot_check_tasks SELECT * FROM json_populate_recordset(NULL::wdpost_spot_check_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_spot_checks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_spot_checks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_spot_checks SELECT * FROM json_populate_recordset(NULL::wdpost_spot_checks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_warm_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_warm_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_warm_tasks SELECT * FROM json_populate_recordset(NULL::wdpost_warm_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_warm_missing",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_warm_missing t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_warm_missing SELECT * FROM json_populate_recordset(NULL::wdpost_warm_missing, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_canary_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_canary_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_canary_tasks SELECT * FROM json_populate_recordset(NULL::wdpost_canary_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "wdpost_canary_checks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM wdpost_canary_checks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO wdpost_canary_checks SELECT * FROM json_populate_recordset(NULL::wdpost_canary_checks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "mining_tasks",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM mining_tasks t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO mining_tasks SELECT * FROM json_populate_recordset(NULL::mining_tasks, $1::json)`, rows)
+		},
+	},
+	{
+		name: "mining_base_block",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM mining_base_block t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO mining_base_block SELECT * FROM json_populate_recordset(NULL::mining_base_block, $1::json)`, rows)
+		},
+		reseed: func(tx *harmonydb.Tx) error {
+			_, err := tx.Exec(`SELECT setval(pg_get_serial_sequence('mining_base_block', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM mining_base_block`)
+			return err
+		},
+	},
+	{
+		name: "storage_path_bench",
+		export: func(ctx context.Context, db *harmonydb.DB) harmonydb.Row {
+			return db.QueryRow(ctx, `SELECT COALESCE(json_agg(t), '[]')::text FROM storage_path_bench t`)
+		},
+		load: func(tx *harmonydb.Tx, rows string) (int, error) {
+			return tx.Exec(`INSERT INTO storage_path_bench SELECT * FROM json_populate_recordset(NULL::storage_path_bench, $1::json)`, rows)
+		},
+	},
+}
+
+var dbCmd = &cli.Command{
+	Name:  "db",
+	Usage: "Move provider state between HarmonyDB clusters",
+	Description: `Export the state needed to continue pending work (config layers, tasks, message sends and
+proving progress) from one Postgres cluster and import it into a fresh one. This is meant for
+planned migrations, with all providers quiesced, not for live replication.`,
+	Subcommands: []*cli.Command{
+		dbExportCmd,
+		dbImportCmd,
+	},
+}
+
+var dbExportCmd = &cli.Command{
+	Name:      "export",
+	Usage:     "Write provider state to a file",
+	ArgsUsage: "<file>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "export even if providers are still claiming or running tasks",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+		ctx := context.Background()
+
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		if !cctx.Bool("force") {
+			if err := checkQuiesced(ctx, db); err != nil {
+				return err
+			}
+		}
+
+		schema, err := appliedSchema(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		out := clusterExport{
+			Version:  clusterExportVersion,
+			Exported: time.Now(),
+			Schema:   schema,
+		}
+
+		for _, t := range migratedTables {
+			var raw string
+			if err := t.export(ctx, db).Scan(&raw); err != nil {
+				return xerrors.Errorf("exporting %s: %w", t.name, err)
+			}
+
+			var rows []map[string]any
+			if err := decodeJSON([]byte(raw), &rows); err != nil {
+				return xerrors.Errorf("decoding %s rows: %w", t.name, err)
+			}
+
+			out.Tables = append(out.Tables, clusterTable{Name: t.name, Rows: rows})
+			fmt.Printf("%s: %d rows\n", t.name, len(rows))
+		}
+
+		f, err := os.Create(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			_ = f.Close()
+			return xerrors.Errorf("writing export: %w", err)
+		}
+
+		return f.Close()
+	},
+}
+
+var dbImportCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Load provider state exported with 'db export' into a fresh database",
+	ArgsUsage: "<file>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("expected 1 argument")
+		}
+		ctx := context.Background()
+
+		data, err := os.ReadFile(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		var in clusterExport
+		if err := decodeJSON(data, &in); err != nil {
+			return xerrors.Errorf("decoding export: %w", err)
+		}
+		if in.Version != clusterExportVersion {
+			return xerrors.Errorf("unsupported export version %d, expected %d", in.Version, clusterExportVersion)
+		}
+
+		// opening the database applies this binary's migrations
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		schema, err := appliedSchema(ctx, db)
+		if err != nil {
+			return err
+		}
+		if strings.Join(schema, ",") != strings.Join(in.Schema, ",") {
+			return xerrors.Errorf("schema mismatch: export has migrations %v, this database has %v; import with the lotus-provider version that made the export",
+				in.Schema, schema)
+		}
+
+		tables := map[string]migratedTable{}
+		for _, t := range migratedTables {
+			tables[t.name] = t
+		}
+
+		_, err = db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			var tasks int
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM harmony_task`).Scan(&tasks); err != nil {
+				return false, err
+			}
+			if tasks > 0 {
+				return false, xerrors.Errorf("target database already has %d tasks, import only into a fresh database", tasks)
+			}
+
+			for _, it := range in.Tables {
+				t, ok := tables[it.Name]
+				if !ok {
+					return false, xerrors.Errorf("export contains unknown table %s", it.Name)
+				}
+
+				for _, row := range it.Rows {
+					for _, c := range t.clear {
+						row[c] = nil
+					}
+				}
+
+				rows, err := json.Marshal(it.Rows)
+				if err != nil {
+					return false, err
+				}
+
+				n, err := t.load(tx, string(rows))
+				if err != nil {
+					return false, xerrors.Errorf("importing %s: %w", t.name, err)
+				}
+
+				if t.reseed != nil {
+					if err := t.reseed(tx); err != nil {
+						return false, xerrors.Errorf("moving %s id sequence: %w", t.name, err)
+					}
+				}
+
+				fmt.Printf("%s: %d rows\n", t.name, n)
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Import done, providers can now be started against the new database")
+		return nil
+	},
+}
+
+// checkQuiesced errors if any live provider could still claim tasks, or if
+// tasks are still being worked on.
+func checkQuiesced(ctx context.Context, db *harmonydb.DB) error {
+	var active []struct {
+		HostAndPort string `db:"host_and_port"`
+	}
+	err := db.Select(ctx, &active, `SELECT host_and_port FROM harmony_machines
+		WHERE draining = FALSE AND last_contact > CURRENT_TIMESTAMP - INTERVAL '5 MINUTES'`)
+	if err != nil {
+		return xerrors.Errorf("listing machines: %w", err)
+	}
+	if len(active) > 0 {
+		var hosts []string
+		for _, a := range active {
+			hosts = append(hosts, a.HostAndPort)
+		}
+		return xerrors.Errorf("providers %s are not quiesced, run 'lotus-provider quiesce' on them first (or pass --force)", strings.Join(hosts, ", "))
+	}
+
+	var running int
+	err = db.QueryRow(ctx, `SELECT COUNT(*) FROM harmony_task WHERE owner_id IS NOT NULL`).Scan(&running)
+	if err != nil {
+		return xerrors.Errorf("counting running tasks: %w", err)
+	}
+	if running > 0 {
+		return xerrors.Errorf("%d tasks are still running, wait for them to finish (or pass --force)", running)
+	}
+
+	return nil
+}
+
+func appliedSchema(ctx context.Context, db *harmonydb.DB) ([]string, error) {
+	var entries []struct {
+		Entry string
+	}
+	if err := db.Select(ctx, &entries, `SELECT entry FROM base`); err != nil {
+		return nil, xerrors.Errorf("reading applied migrations: %w", err)
+	}
+
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, strings.TrimSpace(e.Entry))
+	}
+	sort.Strings(out)
+	return out, nil
+}
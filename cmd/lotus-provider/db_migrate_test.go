@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	createTableRe = regexp.MustCompile(`(?i)create\s+table\s+(?:if\s+not\s+exists\s+)?(\w+)`)
+	dropTableRe   = regexp.MustCompile(`(?i)drop\s+table\s+(?:if\s+exists\s+)?(\w+)`)
+	renameTableRe = regexp.MustCompile(`(?i)alter\s+table\s+(?:if\s+exists\s+)?(\w+)\s+rename\s+to\s+(\w+)`)
+)
+
+// TestMigratedTablesCoverSchema fails when a migration adds a table which is
+// neither exported by `db export` nor listed in unmigratedTables.
+func TestMigratedTablesCoverSchema(t *testing.T) {
+	files, err := filepath.Glob("../../lib/harmony/harmonydb/sql/*.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	sort.Strings(files) // migrations are applied in name order
+
+	type op struct {
+		at   int
+		kind string
+		m    []string
+	}
+
+	tables := map[string]bool{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		require.NoError(t, err)
+		s := string(b)
+
+		var ops []op
+		for kind, re := range map[string]*regexp.Regexp{"create": createTableRe, "drop": dropTableRe, "rename": renameTableRe} {
+			for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+				m := make([]string, 0, len(loc)/2)
+				for i := 2; i < len(loc); i += 2 {
+					m = append(m, s[loc[i]:loc[i+1]])
+				}
+				ops = append(ops, op{at: loc[0], kind: kind, m: m})
+			}
+		}
+		sort.Slice(ops, func(i, j int) bool { return ops[i].at < ops[j].at })
+
+		for _, o := range ops {
+			switch o.kind {
+			case "create":
+				tables[o.m[0]] = true
+			case "drop":
+				delete(tables, o.m[0])
+			case "rename":
+				delete(tables, o.m[0])
+				tables[o.m[1]] = true
+			}
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, mt := range migratedTables {
+		require.False(t, listed[mt.name], "%s is listed twice", mt.name)
+		listed[mt.name] = true
+	}
+	for name := range unmigratedTables {
+		require.False(t, listed[name], "%s is both migrated and unmigrated", name)
+		listed[name] = true
+	}
+
+	for name := range tables {
+		require.True(t, listed[name], "table %s must be added to migratedTables or unmigratedTables", name)
+	}
+	for name := range listed {
+		require.True(t, tables[name], "table %s doesn't exist in the migrations", name)
+	}
+}
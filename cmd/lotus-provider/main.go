@@ -42,8 +42,19 @@ func main() {
 		//initCmd,
 		runCmd,
 		stopCmd,
+		quiesceCmd,
+		unquiesceCmd,
+		metricsCmd,
+		addressAuditCmd,
+		messageCmd,
+		provingCmd,
+		clusterCmd,
+		storageCmd,
+		tasksCmd,
+		dbCmd,
 		configCmd,
 		testCmd,
+		cpuSnarkCmd,
 		//backupCmd,
 		//lcli.WithCategory("chain", actorCmd),
 		//lcli.WithCategory("storage", sectorsCmd),
@@ -63,15 +74,22 @@ func main() {
 		cmd := cmd
 		originBefore := cmd.Before
 		cmd.Before = func(cctx *cli.Context) error {
+			if cctx.IsSet("color") {
+				color.NoColor = !cctx.Bool("color")
+			}
+
+			// before anything else logs, so that all output has the same format
+			if cctx.IsSet("log-format") {
+				if err := lotuslog.SetupLogFormat(cctx.String("log-format"), !color.NoColor); err != nil {
+					return err
+				}
+			}
+
 			if jaeger != nil {
 				_ = jaeger.Shutdown(cctx.Context)
 			}
 			jaeger = tracing.SetupJaegerTracing("lotus/" + cmd.Name)
 
-			if cctx.IsSet("color") {
-				color.NoColor = !cctx.Bool("color")
-			}
-
 			if originBefore != nil {
 				return originBefore(cctx)
 			}
@@ -92,6 +110,13 @@ func main() {
 				Usage:       "use color in display output",
 				DefaultText: "depends on output being a TTY",
 			},
+			&cli.StringFlag{
+				// examined in the Before above
+				Name:        "log-format",
+				EnvVars:     []string{"LOTUS_PROVIDER_LOG_FORMAT"},
+				Usage:       "log output format, 'console' for human-readable or 'json' for structured logs",
+				DefaultText: "console, or the GOLOG_LOG_FMT format",
+			},
 			&cli.StringFlag{
 				Name:    "panic-reports",
 				EnvVars: []string{"LOTUS_PANIC_REPORT_PATH"},
@@ -104,6 +129,11 @@ func main() {
 				Usage:   "Command separated list of hostnames for yugabyte cluster",
 				Value:   "yugabyte",
 			},
+			&cli.StringFlag{
+				Name:    "db-read-replicas",
+				EnvVars: []string{"LOTUS_DB_READ_REPLICAS", "LOTUS_HARMONYDB_READREPLICAS"},
+				Usage:   "Comma separated list of hostnames of read replicas, serving listings and history queries",
+			},
 			&cli.StringFlag{
 				Name:    "db-name",
 				EnvVars: []string{"LOTUS_DB_NAME", "LOTUS_HARMONYDB_HOSTS"},
@@ -130,6 +160,13 @@ func main() {
 				EnvVars: []string{"LOTUS_LAYERS", "LOTUS_CONFIG_LAYERS"},
 				Value:   "base",
 			},
+			&cli.StringSliceFlag{
+				Name:    "config-file",
+				EnvVars: []string{"LOTUS_PROVIDER_CONFIG_FILE"},
+				Usage: "read config from local TOML files, applied in order atop defaults, instead of layers stored in HarmonyDB. " +
+					"LOTUS_* environment variables override values from the files. Meant for local development and CI with a " +
+					"single node; clusters should share config through DB layers. The database is still required for tasks.",
+			},
 			&cli.StringFlag{
 				Name:    FlagRepoPath,
 				EnvVars: []string{"LOTUS_REPO_PATH"},
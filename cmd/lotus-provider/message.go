@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/consensus"
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+)
+
+var messageCmd = &cli.Command{
+	Name:  "message",
+	Usage: "Inspect messages sent by the cluster",
+	Subcommands: []*cli.Command{
+		messageShowCmd,
+	},
+}
+
+// messageSend is a send recorded in message_sends, decoded.
+type messageSend struct {
+	TaskID         int64
+	Reason         string
+	IdempotencyKey string `json:",omitempty"`
+
+	UnsignedCid cid.Cid
+	SignedCid   *cid.Cid `json:",omitempty"`
+
+	From       string
+	To         string
+	Nonce      *uint64 `json:",omitempty"`
+	Value      types.FIL
+	Method     abi.MethodNum
+	MethodName string `json:",omitempty"`
+	Params     string `json:",omitempty"`
+	GasLimit   int64
+	GasFeeCap  types.FIL
+	GasPremium types.FIL
+	MaxFee     types.FIL
+
+	SendTime  *time.Time `json:",omitempty"`
+	SendError string     `json:",omitempty"`
+	// Status is one of unsent, send-failed, pending, executed or missing
+	Status string
+	// SearchedEpochs is how far back the chain was searched for the message
+	SearchedEpochs abi.ChainEpoch `json:",omitempty"`
+
+	Executed *messageExecution `json:",omitempty"`
+}
+
+type messageExecution struct {
+	Height   abi.ChainEpoch
+	Message  cid.Cid // differs from SignedCid when the message was replaced
+	ExitCode exitcode.ExitCode
+	GasUsed  int64
+	Return   string `json:",omitempty"`
+}
+
+var messageShowCmd = &cli.Command{
+	Name:      "show",
+	Usage:     "Decode a message from the sends recorded by the cluster and show its chain status",
+	ArgsUsage: "<signed or unsigned message cid>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.IntFlag{
+			Name:  "max-lookback",
+			Usage: "maximum number of epochs searched back for the execution of a message, the search starts from when it was sent",
+			Value: 2 * builtin.EpochsInDay,
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		c, err := cid.Parse(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing message cid: %w", err)
+		}
+
+		ctx := context.Background()
+
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		var rows []struct {
+			TaskID         int64      `db:"send_task_id"`
+			Reason         string     `db:"send_reason"`
+			IdempotencyKey *string    `db:"idempotency_key"`
+			UnsignedData   []byte     `db:"unsigned_data"`
+			UnsignedCid    string     `db:"unsigned_cid"`
+			Nonce          *uint64    `db:"nonce"`
+			SignedCid      *string    `db:"signed_cid"`
+			SendTime       *time.Time `db:"send_time"`
+			SendSuccess    *bool      `db:"send_success"`
+			SendError      *string    `db:"send_error"`
+		}
+		err = deps.db.Select(ctx, &rows, `SELECT send_task_id, send_reason, idempotency_key, unsigned_data, unsigned_cid,
+				nonce, signed_cid, send_time, send_success, send_error
+			FROM message_sends WHERE unsigned_cid = $1 OR signed_cid = $1 ORDER BY send_task_id`, c.String())
+		if err != nil {
+			return xerrors.Errorf("reading message sends: %w", err)
+		}
+		if len(rows) == 0 {
+			return xerrors.Errorf("no send of message %s is recorded", c)
+		}
+
+		var sends []*messageSend
+		for _, r := range rows {
+			msg, err := types.DecodeMessage(r.UnsignedData)
+			if err != nil {
+				return xerrors.Errorf("decoding message of send task %d: %w", r.TaskID, err)
+			}
+
+			s := &messageSend{
+				TaskID:     r.TaskID,
+				Reason:     r.Reason,
+				From:       msg.From.String(),
+				To:         msg.To.String(),
+				Nonce:      r.Nonce,
+				Value:      types.FIL(msg.Value),
+				Method:     msg.Method,
+				GasLimit:   msg.GasLimit,
+				GasFeeCap:  types.FIL(msg.GasFeeCap),
+				GasPremium: types.FIL(msg.GasPremium),
+				MaxFee:     types.FIL(big.Mul(msg.GasFeeCap, big.NewInt(msg.GasLimit))),
+				SendTime:   r.SendTime,
+			}
+			if s.UnsignedCid, err = cid.Parse(r.UnsignedCid); err != nil {
+				return xerrors.Errorf("parsing unsigned cid: %w", err)
+			}
+			if r.IdempotencyKey != nil {
+				s.IdempotencyKey = *r.IdempotencyKey
+			}
+			if r.SendError != nil {
+				s.SendError = *r.SendError
+			}
+
+			to, err := deps.full.StateGetActor(ctx, msg.To, types.EmptyTSK)
+			if err == nil {
+				s.MethodName = consensus.NewActorRegistry().Methods[to.Code][msg.Method].Name
+				if len(msg.Params) > 0 {
+					if s.Params, err = lcli.JsonParams(to.Code, msg.Method, msg.Params); err != nil {
+						s.Params = fmt.Sprintf("raw:%x (decoding failed: %s)", msg.Params, err)
+					}
+				}
+			} else if len(msg.Params) > 0 {
+				s.Params = fmt.Sprintf("raw:%x", msg.Params)
+			}
+
+			switch {
+			case r.SendSuccess == nil:
+				s.Status = "unsent"
+			case !*r.SendSuccess:
+				s.Status = "send-failed"
+			default:
+				sc, err := cid.Parse(*r.SignedCid)
+				if err != nil {
+					return xerrors.Errorf("parsing signed cid: %w", err)
+				}
+				s.SignedCid = &sc
+
+				if err := fillMessageStatus(ctx, deps.full, s, to, abi.ChainEpoch(cctx.Int("max-lookback"))); err != nil {
+					return err
+				}
+			}
+
+			sends = append(sends, s)
+		}
+
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(sends)
+		}
+
+		for i, s := range sends {
+			if i > 0 {
+				fmt.Println()
+			}
+			printMessageSend(s)
+		}
+		return nil
+	},
+}
+
+// fillMessageStatus looks the sent message up on chain and in the message pool.
+// fillMessageStatus looks for the signed message in the message pool, then
+// in the chain back to when it was sent, searching at most maxLookback
+// epochs.
+func fillMessageStatus(ctx context.Context, full api.FullNode, s *messageSend, to *types.Actor, maxLookback abi.ChainEpoch) error {
+	pending, err := full.MpoolPending(ctx, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("getting message pool: %w", err)
+	}
+	for _, sm := range pending {
+		if sm.Cid() == *s.SignedCid {
+			s.Status = "pending"
+			return nil
+		}
+	}
+
+	lookback := maxLookback
+	if s.SendTime != nil {
+		// a few epochs of margin for clock skew and null rounds
+		sinceSend := abi.ChainEpoch(time.Since(*s.SendTime)/(time.Duration(build.BlockDelaySecs)*time.Second)) + 10
+		if sinceSend < lookback {
+			lookback = sinceSend
+		}
+	}
+	s.SearchedEpochs = lookback
+
+	lookup, err := full.StateSearchMsg(ctx, types.EmptyTSK, *s.SignedCid, lookback, true)
+	if err != nil {
+		return xerrors.Errorf("searching for message: %w", err)
+	}
+	if lookup != nil {
+		s.Status = "executed"
+		s.Executed = &messageExecution{
+			Height:   lookup.Height,
+			Message:  lookup.Message,
+			ExitCode: lookup.Receipt.ExitCode,
+			GasUsed:  lookup.Receipt.GasUsed,
+		}
+		if len(lookup.Receipt.Return) > 0 && to != nil {
+			if ret, err := lcli.JsonReturn(to.Code, s.Method, lookup.Receipt.Return); err == nil {
+				s.Executed.Return = ret
+			}
+		}
+		return nil
+	}
+
+	// neither executed in the searched epochs nor in the pool, e.g.
+	// dropped, or the nonce was used by another message
+	s.Status = "missing"
+	return nil
+}
+
+func printMessageSend(s *messageSend) {
+	fmt.Printf("Send task:   %d\n", s.TaskID)
+	fmt.Printf("Reason:      %s\n", s.Reason)
+	if s.IdempotencyKey != "" {
+		fmt.Printf("Idempotency: %s\n", s.IdempotencyKey)
+	}
+	fmt.Printf("Unsigned:    %s\n", s.UnsignedCid)
+	if s.SignedCid != nil {
+		fmt.Printf("Signed:      %s\n", s.SignedCid)
+	}
+	fmt.Println()
+
+	fmt.Printf("From:        %s\n", s.From)
+	fmt.Printf("To:          %s\n", s.To)
+	if s.Nonce != nil {
+		fmt.Printf("Nonce:       %d\n", *s.Nonce)
+	} else {
+		fmt.Printf("Nonce:       not assigned\n")
+	}
+	fmt.Printf("Value:       %s\n", s.Value)
+	if s.MethodName != "" {
+		fmt.Printf("Method:      %s (%d)\n", s.MethodName, s.Method)
+	} else {
+		fmt.Printf("Method:      %d\n", s.Method)
+	}
+	if s.Params != "" {
+		fmt.Printf("Params:      %s\n", s.Params)
+	}
+	fmt.Printf("Gas limit:   %d\n", s.GasLimit)
+	fmt.Printf("Fee cap:     %s\n", s.GasFeeCap)
+	fmt.Printf("Premium:     %s\n", s.GasPremium)
+	fmt.Printf("Max fee:     %s\n", s.MaxFee)
+	fmt.Println()
+
+	fmt.Printf("Status:      %s\n", s.Status)
+	if s.SendTime != nil {
+		fmt.Printf("Sent at:     %s\n", s.SendTime.Format(time.DateTime))
+	}
+	if s.SendError != "" {
+		fmt.Printf("Send error:  %s\n", s.SendError)
+	}
+	if s.Status == "missing" {
+		fmt.Printf("Searched:    last %d epochs\n", s.SearchedEpochs)
+	}
+	if e := s.Executed; e != nil {
+		fmt.Printf("Height:      %d\n", e.Height)
+		if s.SignedCid != nil && e.Message != *s.SignedCid {
+			fmt.Printf("Replaced by: %s\n", e.Message)
+		}
+		fmt.Printf("Exit code:   %d (%s)\n", e.ExitCode, e.ExitCode)
+		fmt.Printf("Gas used:    %d\n", e.GasUsed)
+		if e.Return != "" {
+			fmt.Printf("Return:      %s\n", e.Return)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+)
+
+var metricsCmd = &cli.Command{
+	Name:  "metrics",
+	Usage: "Inspect the metrics of a running lotus provider",
+	Subcommands: []*cli.Command{
+		metricsExportCmd,
+	},
+}
+
+var metricsExportCmd = &cli.Command{
+	Name:      "export",
+	Usage:     "Write a snapshot of the metrics of a running lotus provider to a file",
+	ArgsUsage: "<file>",
+	Description: `The snapshot has the metrics served at /debug/metrics, in the Prometheus text format.
+It is gathered over the API, so it works when the metrics endpoint isn't scraped or reachable,
+e.g. to ship metrics out of a restricted network for analysis.`,
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+
+		api, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		snapshot, err := api.MetricsSnapshot(lcli.ReqContext(cctx))
+		if err != nil {
+			return xerrors.Errorf("getting metrics snapshot: %w", err)
+		}
+
+		if err := os.WriteFile(cctx.Args().First(), []byte(snapshot), 0644); err != nil {
+			return xerrors.Errorf("writing metrics snapshot: %w", err)
+		}
+
+		fmt.Printf("Wrote metrics snapshot to %s\n", cctx.Args().First())
+		return nil
+	},
+}
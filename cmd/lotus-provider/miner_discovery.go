@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/filecoin-project/lotus/provider"
+)
+
+var configFindMinerCmd = &cli.Command{
+	Name:      "find-miner",
+	Usage:     "Find the miner actors an owner or worker address is used by, to set in Addresses.MinerAddresses",
+	ArgsUsage: "[owner or worker address]",
+	Description: `Scans the info of every miner actor on chain, which can take several
+minutes on mainnet. Miners are never looked up this way at startup, the
+node works for the miners of Addresses.MinerAddresses.`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("expected an owner or worker address")
+		}
+		owner, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return xerrors.Errorf("parsing address: %w", err)
+		}
+
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+		cfg, err := getConfig(cctx, db)
+		if err != nil {
+			return err
+		}
+
+		full, closer, err := cliutil.GetFullNodeAPIV1LotusProvider(cctx, cfg.Apis.ChainApiInfo)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		found, skipped, err := provider.DiscoverMiners(cctx.Context, full, owner)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			fmt.Printf("skipped %d miners whose info couldn't be read\n", skipped)
+		}
+		if len(found) == 0 {
+			return xerrors.Errorf("no miner actor found for %s", owner)
+		}
+
+		for _, m := range found {
+			fmt.Println(m)
+		}
+		return nil
+	},
+}
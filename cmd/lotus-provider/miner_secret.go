@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mitchellh/go-homedir"
+	"github.com/multiformats/go-base32"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/filecoin-project/lotus/node/modules"
+	"github.com/filecoin-project/lotus/storage/sealer"
+)
+
+// minerStorageSecret reads the storage RPC secret, the JWT key of the miner
+// base64 encoded, from a lotus-miner keystore.
+func minerStorageSecret(keystore string) (string, error) {
+	keystore, err := homedir.Expand(keystore)
+	if err != nil {
+		return "", xerrors.Errorf("expanding keystore path: %w", err)
+	}
+
+	kpath := filepath.Join(keystore, base32.RawStdEncoding.EncodeToString([]byte(modules.JWTSecretName)))
+	kb, err := os.ReadFile(kpath)
+	if err != nil {
+		return "", xerrors.Errorf("reading miner JWT key: %w", err)
+	}
+
+	var ki types.KeyInfo
+	if err := json.Unmarshal(kb, &ki); err != nil {
+		return "", xerrors.Errorf("parsing miner JWT key %s: %w", kpath, err)
+	}
+	if len(ki.PrivateKey) == 0 {
+		return "", xerrors.Errorf("miner JWT key %s is empty", kpath)
+	}
+
+	return base64.StdEncoding.EncodeToString(ki.PrivateKey), nil
+}
+
+// checkMinerStorageAuth checks that the miner owning the keystore accepts sa
+// on its storage endpoint. The endpoint is found from the api file of the
+// miner repo; without one, or when the miner can't be reached, the check is
+// skipped with a warning, as the miner may not be running.
+func checkMinerStorageAuth(ctx context.Context, keystore string, sa sealer.StorageAuth) error {
+	keystore, err := homedir.Expand(keystore)
+	if err != nil {
+		return xerrors.Errorf("expanding keystore path: %w", err)
+	}
+
+	ab, err := os.ReadFile(filepath.Join(filepath.Dir(keystore), "api"))
+	if err != nil {
+		log.Warnw("not checking the storage secret against the miner, no api file in its repo", "error", err)
+		return nil
+	}
+	host, err := cliutil.APIInfo{Addr: strings.TrimSpace(string(ab))}.Host()
+	if err != nil {
+		return xerrors.Errorf("parsing miner api address: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// any path ID will do, authentication is checked before the path is
+	// looked up
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/remote/stat/"+uuid.New().String(), nil)
+	if err != nil {
+		return xerrors.Errorf("creating request: %w", err)
+	}
+	req.Header = http.Header(sa).Clone()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Warnw("not checking the storage secret against the miner, it isn't reachable", "host", host, "error", err)
+		return nil
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return xerrors.Errorf("the miner at %s rejected the storage secret read from %s (status %d)", host, keystore, resp.StatusCode)
+	}
+
+	log.Infow("storage secret accepted by the miner", "host", host)
+	return nil
+}
@@ -15,8 +15,14 @@ import (
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/dline"
 
+	"github.com/filecoin-project/lotus/chain/types"
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/provider"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
 )
 
 var testCmd = &cli.Command{
@@ -35,6 +41,8 @@ var wdPostCmd = &cli.Command{
 	Subcommands: []*cli.Command{
 		wdPostHereCmd,
 		wdPostTaskCmd,
+		wdPostComputeCmd,
+		wdPostGasCmd,
 	},
 }
 
@@ -122,6 +130,99 @@ var wdPostTaskCmd = &cli.Command{
 	},
 }
 
+// wdPostComputeCmd asks a running lotus-provider with WindowPoSt enabled to
+// compute all partitions of a deadline right away, and waits for the tasks.
+var wdPostComputeCmd = &cli.Command{
+	Name:  "compute",
+	Usage: "Compute WindowPoSt for a deadline now, outside of the regular schedule",
+	Description: `Schedules compute tasks for every partition of a deadline in the current proving period
+through the API of a running lotus-provider. The deadline challenge must already be on chain.
+Without --submit proofs are only written to harmony_test; with --submit they are sent to the
+chain by the submit task, which requires the deadline to still be open (or about to open).
+Fails when the cluster already has compute tasks for the deadline.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "miner",
+			Usage:    "miner address to compute WindowPoSt for",
+			Required: true,
+		},
+		&cli.Uint64Flag{
+			Name:     "deadline",
+			Usage:    "deadline index to compute WindowPoSt for",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "submit",
+			Usage: "submit the proofs to the chain",
+		},
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "wait for the compute tasks to finish",
+			Value: true,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		maddr, err := address.NewFromString(cctx.String("miner"))
+		if err != nil {
+			return xerrors.Errorf("parsing miner address: %w", err)
+		}
+
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		ctx := lcli.ReqContext(cctx)
+
+		c, err := papi.ComputeWindowPoSt(ctx, maddr, cctx.Uint64("deadline"), cctx.Bool("submit"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Scheduled %d partitions of deadline %d (period start %d)\n", len(c.Tasks), c.Deadline, c.PeriodStart)
+		for _, t := range c.Tasks {
+			fmt.Printf("  partition %d: task %d\n", t.Partition, t.TaskID)
+		}
+		if !cctx.Bool("wait") {
+			return nil
+		}
+
+		for !c.Done() {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+
+			c, err = papi.WindowPoStComputeStatus(ctx, c)
+			if err != nil {
+				return err
+			}
+		}
+
+		var failed int
+		for _, t := range c.Tasks {
+			switch t.State {
+			case "failed":
+				failed++
+				fmt.Printf("partition %d: failed: %s\n", t.Partition, t.Error)
+			case "done":
+				fmt.Printf("partition %d: done\n", t.Partition)
+				if t.Result != "" {
+					fmt.Println(t.Result)
+				}
+			default:
+				fmt.Printf("partition %d: %s\n", t.Partition, t.State)
+			}
+		}
+		if failed > 0 {
+			return xerrors.Errorf("%d of %d partitions failed", failed, len(c.Tasks))
+		}
+		return nil
+	},
+}
+
 // This command is intended to be used to verify PoSt compute performance.
 // It will not send any messages to the chain. Since it can compute any deadline, output may be incorrectly timed for the chain.
 // The entire processing happens in this process while you wait. It does not use the scheduler.
@@ -162,8 +263,9 @@ It will not send any messages to the chain. Since it can compute any deadline, o
 			return err
 		}
 
-		wdPostTask, wdPoStSubmitTask, derlareRecoverTask, err := provider.WindowPostScheduler(ctx, deps.cfg.Fees, deps.cfg.Proving, deps.full, deps.verif, deps.lw, nil,
-			deps.as, deps.maddrs, deps.db, deps.stor, deps.si, deps.cfg.Subsystems.WindowPostMaxTasks)
+		// the chain scheduler isn't run, proofs are computed directly below
+		wdPostTask, wdPoStSubmitTask, derlareRecoverTask, err := provider.WindowPostScheduler(chainsched.New(deps.full), deps.cfg.Fees, deps.cfg.Proving, deps.full, deps.verif, deps.lw, nil,
+			deps.as, deps.maddrs, deps.db, provider.FaultTracker(deps.stor, deps.si, deps.j, deps.cfg.Proving), deps.j, deps.al, deps.cfg.Subsystems.WindowPostMaxTasks, deps.cfg.Subsystems.WindowPostClusterMaxTasks, deps.cfg.Subsystems.WindowPostMaxFetches, nil, lpmessage.WaitMempool)
 		if err != nil {
 			return err
 		}
@@ -196,3 +298,176 @@ It will not send any messages to the chain. Since it can compute any deadline, o
 		return nil
 	},
 }
+
+// wdPostGasCmd estimates what submitting WindowPoSt for a deadline would cost right now.
+// It builds the message through the same path as the submit task, but only runs gas estimation.
+var wdPostGasCmd = &cli.Command{
+	Name:  "gas",
+	Usage: "Estimate the gas cost of submitting WindowPoSt for a deadline, without sending anything.",
+	Description: `The message is built the same way the WdPostSubmit task builds it, with placeholder proofs.
+The miner actor only accepts a submission for the currently open deadline, so estimates for
+other deadlines will fail.`,
+	Flags: []cli.Flag{
+		&cli.Uint64Flag{
+			Name:  "deadline",
+			Usage: "deadline to estimate WindowPoSt submission for",
+			Value: 0,
+		},
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address. Default: the first configured miner address",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		maddr, err := minerFromFlagOrConfig(cctx, deps)
+		if err != nil {
+			return err
+		}
+
+		_, wdPoStSubmitTask, _, err := provider.WindowPostScheduler(chainsched.New(deps.full), deps.cfg.Fees, deps.cfg.Proving, deps.full, deps.verif, deps.lw, nil,
+			deps.as, deps.maddrs, deps.db, provider.FaultTracker(deps.stor, deps.si, deps.j, deps.cfg.Proving), deps.j, deps.al, deps.cfg.Subsystems.WindowPostMaxTasks, deps.cfg.Subsystems.WindowPostClusterMaxTasks, deps.cfg.Subsystems.WindowPostMaxFetches, nil, lpmessage.WaitMempool)
+		if err != nil {
+			return err
+		}
+
+		est, err := wdPoStSubmitTask.EstimateSubmit(ctx, maddr, cctx.Uint64("deadline"))
+		if err != nil {
+			return xerrors.Errorf("estimating submission: %w", err)
+		}
+
+		bal, err := deps.full.WalletBalance(ctx, est.Msg.From)
+		if err != nil {
+			return xerrors.Errorf("getting balance of %s: %w", est.Msg.From, err)
+		}
+
+		fmt.Printf("Miner:        %s\n", maddr)
+		fmt.Printf("Deadline:     %d\n", cctx.Uint64("deadline"))
+		fmt.Printf("Messages:     %d (up to %d partitions each, estimate is for the first)\n", est.Messages, est.PartitionsPerMsg)
+		fmt.Printf("From:         %s (balance %s)\n", est.Msg.From, types.FIL(bal))
+		fmt.Printf("Gas limit:    %d\n", est.Msg.GasLimit)
+		fmt.Printf("Gas fee cap:  %s\n", types.FIL(est.Msg.GasFeeCap))
+		fmt.Printf("Gas premium:  %s\n", types.FIL(est.Msg.GasPremium))
+		fmt.Printf("Max fee:      %s (capped)\n", types.FIL(est.Msg.RequiredFunds()))
+		fmt.Printf("Network fee:  %s (uncapped)\n", types.FIL(est.UncappedFee))
+		fmt.Printf("Fee cap:      %s (MaxWindowPoStGasFee)\n", types.FIL(est.MaxFee))
+
+		if est.UncappedFee.GreaterThan(est.MaxFee) {
+			fmt.Printf("WARNING: estimated fee exceeds MaxWindowPoStGasFee; the message may be slow to land\n")
+		}
+		if bal.LessThan(est.Msg.RequiredFunds()) {
+			fmt.Printf("WARNING: %s does not have enough funds to cover the max fee\n", est.Msg.From)
+		}
+
+		return nil
+	},
+}
+
+// submitDirectCmd is the emergency path for a wedged Sender: the proof is
+// pushed to the message pool from this process, without going through
+// message_sends and the SendMessage task.
+var submitDirectCmd = &cli.Command{
+	Name:  "submit-direct",
+	Usage: "UNSAFE: Push a WindowPoSt proof straight to the message pool, bypassing the message Sender",
+	Description: `Emergency operation for when the Sender is stuck and a deadline is about to be missed.
+The proof computed by the cluster for the partition is used unless --recompute is set or there
+is none, in which case it is computed in this process. The proof is verified locally before
+anything is sent. The deadline must currently be open.
+
+The message is signed and pushed from this process with the nonce of the message pool. It isn't
+tracked in message_sends, so the submit task may still send its own message for the partition
+if it recovers; the submission is recorded in wdpost_direct_submits and on the proof.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address. Default: the first configured miner address",
+		},
+		&cli.Uint64Flag{
+			Name:     "deadline",
+			Usage:    "deadline index to submit WindowPoSt for",
+			Required: true,
+		},
+		&cli.Uint64Flag{
+			Name:     "partition",
+			Usage:    "partition index to submit WindowPoSt for",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "recompute",
+			Usage: "compute the proof in this process, even if the cluster already computed it",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+		&cli.BoolFlag{
+			Name:  "really-do-it",
+			Usage: "acknowledge that this bypasses the Sender",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if !cctx.Bool("really-do-it") {
+			return xerrors.Errorf("this bypasses the message Sender and should only be used in emergencies, pass --really-do-it to proceed")
+		}
+
+		ctx := context.Background()
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		maddr, err := minerFromFlagOrConfig(cctx, deps)
+		if err != nil {
+			return err
+		}
+
+		signer, err := messageSigner(ctx, deps.cfg.Addresses.ExternalSigner, deps.full)
+		if err != nil {
+			return err
+		}
+
+		wdPostTask, wdPoStSubmitTask, _, err := provider.WindowPostScheduler(chainsched.New(deps.full), deps.cfg.Fees, deps.cfg.Proving, deps.full, deps.verif, deps.lw, nil,
+			deps.as, deps.maddrs, deps.db, provider.FaultTracker(deps.stor, deps.si, deps.j, deps.cfg.Proving), deps.j, deps.al, deps.cfg.Subsystems.WindowPostMaxTasks, deps.cfg.Subsystems.WindowPostClusterMaxTasks, deps.cfg.Subsystems.WindowPostMaxFetches, nil, lpmessage.WaitMempool)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("WARNING: submitting WindowPoSt directly to the message pool, bypassing the message Sender")
+
+		res, err := lpwindow.NewDirectSubmitter(wdPostTask, wdPoStSubmitTask, deps.full, signer, deps.db).
+			Submit(ctx, maddr, cctx.Uint64("deadline"), cctx.Uint64("partition"), cctx.Bool("recompute"))
+		if err != nil {
+			return err
+		}
+
+		source := "cluster"
+		if res.Recomputed {
+			source = "computed here"
+		}
+		fmt.Printf("Pushed message %s (from %s, nonce %d)\n", res.Msg.Cid(), res.Msg.Message.From, res.Msg.Message.Nonce)
+		fmt.Printf("Miner %s, deadline %d, partition %d, period start %d, proof: %s\n",
+			maddr, cctx.Uint64("deadline"), cctx.Uint64("partition"), res.PeriodStart, source)
+		return nil
+	},
+}
+
+func minerFromFlagOrConfig(cctx *cli.Context, deps *Deps) (address.Address, error) {
+	if cctx.IsSet("miner") {
+		return address.NewFromString(cctx.String("miner"))
+	}
+	if len(deps.maddrs) == 0 {
+		return address.Undef, errors.New("no miner addresses configured")
+	}
+	return address.Address(deps.maddrs[0]), nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+// provingDeadlineEntry is the state of one deadline in the current proving
+// period.
+type provingDeadlineEntry struct {
+	Deadline   uint64
+	Open       abi.ChainEpoch
+	Close      abi.ChainEpoch
+	Current    bool
+	Partitions int
+	Sectors    uint64
+
+	// Submitted is the number of partitions with a WindowPoSt message sent
+	// by the cluster this period.
+	Submitted int
+	// Proven is the number of proven partitions, from the chain for the
+	// current deadline, from the executions recorded by the cluster for
+	// closed ones.
+	Proven int
+	// Status is one of empty, upcoming, open, proven, submitted or not-proven.
+	Status string
+}
+
+var provingDeadlinesCmd = &cli.Command{
+	Name:  "deadlines",
+	Usage: "Show the deadlines of the current proving period and whether they were proven",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address, defaults to the first configured miner",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		maddr, err := minerFromFlagOrConfig(cctx, deps)
+		if err != nil {
+			return err
+		}
+		spID, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return xerrors.Errorf("getting miner ID: %w", err)
+		}
+
+		head, err := deps.full.ChainHead(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting chain head: %w", err)
+		}
+
+		di, err := deps.full.StateMinerProvingDeadline(ctx, maddr, head.Key())
+		if err != nil {
+			return xerrors.Errorf("getting proving deadline: %w", err)
+		}
+		deadlines, err := deps.full.StateMinerDeadlines(ctx, maddr, head.Key())
+		if err != nil {
+			return xerrors.Errorf("getting deadlines: %w", err)
+		}
+
+		var rows []struct {
+			Deadline  uint64 `db:"deadline"`
+			Submitted int    `db:"submitted"`
+			Executed  int    `db:"executed"`
+		}
+		err = deps.db.Select(ctx, &rows, `SELECT p.deadline,
+				COUNT(p.message_cid) AS submitted,
+				COUNT(*) FILTER (WHERE w.executed_rcpt_exitcode = 0) AS executed
+			FROM wdpost_proofs p LEFT JOIN message_waits w ON w.signed_message_cid = p.message_cid
+			WHERE p.sp_id = $1 AND p.proving_period_start = $2 AND p.test_task_id IS NULL
+			GROUP BY p.deadline`, spID, di.PeriodStart)
+		if err != nil {
+			return xerrors.Errorf("reading submissions: %w", err)
+		}
+		local := map[uint64]int{}
+		executed := map[uint64]int{}
+		for _, r := range rows {
+			local[r.Deadline] = r.Submitted
+			executed[r.Deadline] = r.Executed
+		}
+
+		entries := make([]provingDeadlineEntry, 0, len(deadlines))
+		for idx, d := range deadlines {
+			dl := dline.NewInfo(di.PeriodStart, uint64(idx), head.Height(), di.WPoStPeriodDeadlines,
+				di.WPoStProvingPeriod, di.WPoStChallengeWindow, di.WPoStChallengeLookback, di.FaultDeclarationCutoff)
+
+			parts, err := deps.full.StateMinerPartitions(ctx, maddr, uint64(idx), head.Key())
+			if err != nil {
+				return xerrors.Errorf("getting partitions of deadline %d: %w", idx, err)
+			}
+
+			e := provingDeadlineEntry{
+				Deadline:   uint64(idx),
+				Open:       dl.Open,
+				Close:      dl.Close,
+				Current:    uint64(idx) == di.Index,
+				Partitions: len(parts),
+				Submitted:  local[uint64(idx)],
+				Proven:     executed[uint64(idx)],
+			}
+			for _, p := range parts {
+				n, err := p.AllSectors.Count()
+				if err != nil {
+					return xerrors.Errorf("counting sectors of deadline %d: %w", idx, err)
+				}
+				e.Sectors += n
+			}
+
+			switch {
+			case e.Partitions == 0:
+				e.Status = "empty"
+			case e.Current:
+				// post submissions are only kept on chain while the deadline is open
+				n, err := d.PostSubmissions.Count()
+				if err != nil {
+					return xerrors.Errorf("counting proven partitions of deadline %d: %w", idx, err)
+				}
+				e.Proven = int(n)
+				e.Status = "open"
+				if e.Proven >= e.Partitions {
+					e.Status = "proven"
+				}
+			case !dl.HasElapsed():
+				e.Status = "upcoming"
+			case e.Proven >= e.Partitions:
+				e.Status = "proven"
+			case e.Submitted >= e.Partitions:
+				e.Status = "submitted"
+			default:
+				e.Status = "not-proven"
+			}
+			entries = append(entries, e)
+		}
+
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+
+		fmt.Printf("Miner: %s\n", maddr)
+		fmt.Printf("Chain head: %d, proving period start: %d, current deadline: %d\n\n", head.Height(), di.PeriodStart, di.Index)
+
+		tw := tablewriter.New(
+			tablewriter.Col("Deadline"),
+			tablewriter.Col("Open"),
+			tablewriter.Col("Close"),
+			tablewriter.Col("Partitions"),
+			tablewriter.Col("Sectors"),
+			tablewriter.Col("Submitted"),
+			tablewriter.Col("Proven"),
+			tablewriter.Col("Status"),
+		)
+		for _, e := range entries {
+			name := fmt.Sprint(e.Deadline)
+			if e.Current {
+				name += " (current)"
+			}
+			tw.Write(map[string]interface{}{
+				"Deadline":   name,
+				"Open":       e.Open,
+				"Close":      e.Close,
+				"Partitions": e.Partitions,
+				"Sectors":    e.Sectors,
+				"Submitted":  e.Submitted,
+				"Proven":     e.Proven,
+				"Status":     e.Status,
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var provingCmd = &cli.Command{
+	Name:  "proving",
+	Usage: "View proving information",
+	Subcommands: []*cli.Command{
+		provingDeadlinesCmd,
+		provingRecoveriesCmd,
+		provingHistoryCmd,
+		winningLeadersCmd,
+		submitDirectCmd,
+		provingPauseCmd,
+		provingResumeCmd,
+		provingPausedCmd,
+		provingLoadCmd,
+		provingReplayCmd,
+	},
+}
+
+var winningLeadersCmd = &cli.Command{
+	Name:  "winning-leaders",
+	Usage: "Show which node submits WinningPoSt blocks for each miner",
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		var rows []struct {
+			SpID         uint64    `db:"sp_id"`
+			LeaderHost   string    `db:"leader_host"`
+			LeaseExpires time.Time `db:"lease_expires"`
+			Expired      bool      `db:"expired"`
+		}
+		err = db.Select(ctx, &rows, `SELECT sp_id, leader_host, lease_expires, lease_expires < CURRENT_TIMESTAMP AS expired
+			FROM winpost_leaders ORDER BY sp_id`)
+		if err != nil {
+			return xerrors.Errorf("reading leaders: %w", err)
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Miner"),
+			tablewriter.Col("Leader"),
+			tablewriter.Col("LeaseExpires"),
+			tablewriter.Col("State"),
+		)
+		for _, r := range rows {
+			maddr, err := address.NewIDAddress(r.SpID)
+			if err != nil {
+				return err
+			}
+
+			state := "active"
+			if r.Expired {
+				state = "expired"
+			}
+			tw.Write(map[string]interface{}{
+				"Miner":        maddr.String(),
+				"Leader":       r.LeaderHost,
+				"LeaseExpires": r.LeaseExpires.Format(time.DateTime),
+				"State":        state,
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+type provingHistoryEntry struct {
+	ProvingPeriodStart abi.ChainEpoch
+	Deadline           uint64
+	Partitions         []uint64
+	WindowOpen         abi.ChainEpoch // epoch the deadline opened at
+
+	Message *cid.Cid       `json:",omitempty"`
+	Proven  bool           // message landed on chain and executed successfully
+	Height  abi.ChainEpoch `json:",omitempty"` // execution height
+	GasUsed int64          `json:",omitempty"`
+	GasCost big.Int        // total cost of the message, zero if not found on chain
+	Latency time.Duration  `json:",omitempty"` // from the deadline opening to execution
+	Error   string         `json:",omitempty"`
+}
+
+var provingHistoryCmd = &cli.Command{
+	Name:  "history",
+	Usage: "Show the outcome of WindowPoSt submissions in recent deadlines",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address, defaults to the first configured miner",
+		},
+		&cli.IntFlag{
+			Name:  "deadlines",
+			Usage: "number of recent deadlines to show",
+			Value: 48,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		maddr, err := minerFromFlagOrConfig(cctx, deps)
+		if err != nil {
+			return err
+		}
+		spID, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return xerrors.Errorf("getting miner ID: %w", err)
+		}
+
+		var rows []struct {
+			ProvingPeriodStart abi.ChainEpoch `db:"proving_period_start"`
+			Deadline           uint64         `db:"deadline"`
+			Partition          uint64         `db:"partition"`
+			MessageCid         *string        `db:"message_cid"`
+		}
+		err = deps.db.ReadReplica().Select(ctx, &rows, `SELECT proving_period_start, deadline, partition, message_cid
+			FROM wdpost_proofs
+			WHERE sp_id = $1 AND test_task_id IS NULL AND (proving_period_start, deadline) IN (
+				SELECT DISTINCT proving_period_start, deadline FROM wdpost_proofs
+					WHERE sp_id = $1 AND test_task_id IS NULL
+					ORDER BY proving_period_start DESC, deadline DESC LIMIT $2)
+			ORDER BY proving_period_start DESC, deadline DESC, partition`, spID, cctx.Int("deadlines"))
+		if err != nil {
+			return xerrors.Errorf("reading proofs: %w", err)
+		}
+
+		// one entry per deadline and message
+		var entries []*provingHistoryEntry
+		byMsg := map[string]*provingHistoryEntry{}
+		for _, r := range rows {
+			msg := "none"
+			if r.MessageCid != nil {
+				msg = *r.MessageCid
+			}
+			key := fmt.Sprintf("%d/%d/%s", r.ProvingPeriodStart, r.Deadline, msg)
+
+			e, ok := byMsg[key]
+			if !ok {
+				e = &provingHistoryEntry{
+					ProvingPeriodStart: r.ProvingPeriodStart,
+					Deadline:           r.Deadline,
+					WindowOpen:         r.ProvingPeriodStart + abi.ChainEpoch(r.Deadline)*miner.WPoStChallengeWindow(),
+					GasCost:            big.Zero(),
+				}
+				if r.MessageCid != nil {
+					c, err := cid.Parse(*r.MessageCid)
+					if err != nil {
+						return xerrors.Errorf("parsing message cid: %w", err)
+					}
+					e.Message = &c
+				}
+
+				byMsg[key] = e
+				entries = append(entries, e)
+			}
+			e.Partitions = append(e.Partitions, r.Partition)
+		}
+
+		for _, e := range entries {
+			if e.Message == nil {
+				continue
+			}
+			if err := fillProvingOutcome(ctx, deps.full, e); err != nil {
+				return err
+			}
+		}
+
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("PeriodStart"),
+			tablewriter.Col("Deadline"),
+			tablewriter.Col("Partitions"),
+			tablewriter.Col("Proven"),
+			tablewriter.Col("Message"),
+			tablewriter.Col("GasUsed"),
+			tablewriter.Col("GasCost"),
+			tablewriter.Col("Latency"),
+			tablewriter.NewLineCol("Error"),
+		)
+		for _, e := range entries {
+			parts := make([]string, len(e.Partitions))
+			for i, p := range e.Partitions {
+				parts[i] = fmt.Sprint(p)
+			}
+
+			m := map[string]interface{}{
+				"PeriodStart": e.ProvingPeriodStart,
+				"Deadline":    e.Deadline,
+				"Partitions":  strings.Join(parts, ","),
+				"Proven":      e.Proven,
+			}
+			if e.Message != nil {
+				m["Message"] = e.Message.String()
+			}
+			if e.Height != 0 {
+				m["GasUsed"] = e.GasUsed
+				m["GasCost"] = types.FIL(e.GasCost).Short()
+				m["Latency"] = e.Latency
+			}
+			if e.Error != "" {
+				m["Error"] = e.Error
+			}
+			tw.Write(m)
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+// fillProvingOutcome looks up the on-chain result of the entry's message.
+func fillProvingOutcome(ctx context.Context, full api.FullNode, e *provingHistoryEntry) error {
+	lookup, err := full.StateSearchMsg(ctx, types.EmptyTSK, *e.Message, api.LookbackNoLimit, true)
+	if err != nil {
+		return xerrors.Errorf("searching for message %s: %w", e.Message, err)
+	}
+	if lookup == nil {
+		e.Error = "message not found on chain"
+		return nil
+	}
+
+	e.Height = lookup.Height
+	e.GasUsed = lookup.Receipt.GasUsed
+	e.Latency = time.Duration(lookup.Height-e.WindowOpen) * time.Duration(build.BlockDelaySecs) * time.Second
+	e.Proven = lookup.Receipt.ExitCode.IsSuccess()
+	if !e.Proven {
+		e.Error = lookup.Receipt.ExitCode.String()
+	}
+
+	res, err := full.StateReplay(ctx, types.EmptyTSK, lookup.Message)
+	if err != nil {
+		return xerrors.Errorf("replaying message %s: %w", lookup.Message, err)
+	}
+	e.GasCost = res.GasCost.TotalCost
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
+)
+
+var provingLoadCmd = &cli.Command{
+	Name:  "load",
+	Usage: "Show how many deadlines of the configured miners are proven at once",
+	Description: `Works out, for each span of epochs in the next proving period, the deadlines of the configured
+miners which are in their proving windows, from the compute epoch to the deadline close, and their
+partitions. Deadlines of different miners which overlap are proven at the same time, so a node serving
+miners with aligned deadlines needs enough task concurrency for the peak, or the miners should be
+proven by different nodes. Only deadlines with live partitions are counted.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "epochs",
+			Usage: "number of epochs to analyse, from the chain head. Default: one proving period",
+		},
+		&cli.BoolFlag{
+			Name:  "collisions",
+			Usage: "only show the spans in which deadlines of more than one miner are proven",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		epochs := miner.WPoStProvingPeriod()
+		if cctx.IsSet("epochs") {
+			epochs = abi.ChainEpoch(cctx.Int64("epochs"))
+		}
+
+		load, head, err := provingLoad(ctx, deps, epochs)
+		if err != nil {
+			return err
+		}
+
+		segments := load.Segments
+		if cctx.Bool("collisions") {
+			segments = load.Collisions()
+		}
+
+		if cctx.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(struct {
+				Head           abi.ChainEpoch
+				PeakDeadlines  int
+				PeakPartitions int
+				PeakAt         abi.ChainEpoch
+				Advice         []string
+				Segments       []lpwindow.LoadSegment
+			}{head, load.PeakDeadlines, load.PeakPartitions, load.PeakAt, provingLoadAdvice(load, deps.cfg.Subsystems.WindowPostMaxTasks), segments})
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("From"),
+			tablewriter.Col("To"),
+			tablewriter.Col("Deadlines"),
+			tablewriter.Col("Partitions"),
+			tablewriter.Col("Proving"),
+		)
+		for _, s := range segments {
+			proving := make([]string, len(s.Deadlines))
+			for i, d := range s.Deadlines {
+				proving[i] = fmt.Sprintf("%s/%d(%d)", d.Miner, d.Deadline, d.Partitions)
+			}
+			tw.Write(map[string]interface{}{
+				"From":       s.From,
+				"To":         s.To,
+				"Deadlines":  len(s.Deadlines),
+				"Partitions": s.Partitions(),
+				"Proving":    strings.Join(proving, " "),
+			})
+		}
+		if err := tw.Flush(os.Stdout); err != nil {
+			return err
+		}
+
+		fmt.Printf("\nMiners: %d, epochs %d to %d\n", len(deps.maddrs), head, head+epochs)
+		fmt.Printf("Peak: %d deadlines, %d partitions at epoch %d\n", load.PeakDeadlines, load.PeakPartitions, load.PeakAt)
+		for _, a := range provingLoadAdvice(load, deps.cfg.Subsystems.WindowPostMaxTasks) {
+			fmt.Println(a)
+		}
+		return nil
+	},
+}
+
+func provingLoad(ctx context.Context, deps *Deps, epochs abi.ChainEpoch) (lpwindow.ProvingLoad, abi.ChainEpoch, error) {
+	head, err := deps.full.ChainHead(ctx)
+	if err != nil {
+		return lpwindow.ProvingLoad{}, 0, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	scheds, err := lpwindow.MinerSchedules(ctx, deps.full, deps.maddrs, safetyMarginFunc(&deps.cfg.Proving))
+	if err != nil {
+		return lpwindow.ProvingLoad{}, 0, err
+	}
+
+	return lpwindow.ComputeProvingLoad(scheds, head.Height(), epochs), head.Height(), nil
+}
+
+func safetyMarginFunc(pc *config.ProvingConfig) lpwindow.SafetyMarginFunc {
+	return func(maddr address.Address) abi.ChainEpoch {
+		return abi.ChainEpoch(pc.SafetyMarginFor(maddr))
+	}
+}
+
+// provingLoadAdvice returns recommendations for the task concurrency or the
+// placement of miners, given the WindowPostMaxTasks of the node.
+func provingLoadAdvice(load lpwindow.ProvingLoad, maxTasks int) []string {
+	coll := load.Collisions()
+	if len(coll) == 0 {
+		return []string{"No proving windows of different miners overlap."}
+	}
+
+	overlapping := map[address.Address]struct{}{}
+	var epochs abi.ChainEpoch
+	for _, s := range coll {
+		epochs += s.To - s.From
+		for _, d := range s.Deadlines {
+			overlapping[d.Miner] = struct{}{}
+		}
+	}
+	miners := make([]string, 0, len(overlapping))
+	for m := range overlapping {
+		miners = append(miners, m.String())
+	}
+	sort.Strings(miners)
+
+	out := []string{fmt.Sprintf("Proving windows of miners %s overlap for %d epochs.", strings.Join(miners, ", "), epochs)}
+	if maxTasks > 0 && load.PeakPartitions > maxTasks {
+		out = append(out, fmt.Sprintf("Up to %d partitions are due at once, but WindowPostMaxTasks is %d: raise it if the node has the resources, enable WindowPoSt on more nodes, or prove some of the miners from other nodes.",
+			load.PeakPartitions, maxTasks))
+	} else {
+		out = append(out, fmt.Sprintf("Up to %d partitions are due at once, make sure the node can prove them within a deadline or prove some of the miners from other nodes.", load.PeakPartitions))
+	}
+	return out
+}
+
+// warnProvingLoad logs a warning when the proving windows of the miners
+// served by the node overlap in the next proving period.
+func warnProvingLoad(ctx context.Context, deps *Deps) {
+	if len(deps.maddrs) < 2 {
+		return
+	}
+
+	load, _, err := provingLoad(ctx, deps, miner.WPoStProvingPeriod())
+	if err != nil {
+		log.Warnw("analysing proving load", "error", err)
+		return
+	}
+	if len(load.Collisions()) == 0 {
+		return
+	}
+
+	log.Warnw("proving windows of miners served by this node overlap, see 'lotus-provider proving load'",
+		"peakDeadlines", load.PeakDeadlines, "peakPartitions", load.PeakPartitions, "peakAt", load.PeakAt,
+		"advice", strings.Join(provingLoadAdvice(load, deps.cfg.Subsystems.WindowPostMaxTasks), " "))
+}
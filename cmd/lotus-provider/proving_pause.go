@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+)
+
+var provingPauseCmd = &cli.Command{
+	Name:  "pause",
+	Usage: "Stop proving a deadline of a miner until it is resumed",
+	Description: `Pauses scheduling WindowPoSt for the deadline across the cluster, e.g. during maintenance of
+the storage holding its sectors. The pause is kept across restarts. Compute tasks already scheduled
+for the deadline are left to run. A deadline which stays paused through its window is faulted; an
+alert is raised on the nodes proving the miner for as long as the pause is in place.`,
+	ArgsUsage: "<miner> <deadline>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "reason",
+			Usage: "why the deadline is paused, shown in alerts and listings",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		maddr, dl, err := minerDeadlineArgs(cctx)
+		if err != nil {
+			return err
+		}
+
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := papi.PauseWindowPoSt(lcli.ReqContext(cctx), maddr, dl, cctx.String("reason")); err != nil {
+			return err
+		}
+
+		fmt.Printf("WindowPoSt paused for deadline %d of %s\n", dl, maddr)
+		return nil
+	},
+}
+
+var provingResumeCmd = &cli.Command{
+	Name:      "resume",
+	Usage:     "Resume proving a paused deadline of a miner",
+	ArgsUsage: "<miner> <deadline>",
+	Action: func(cctx *cli.Context) error {
+		maddr, dl, err := minerDeadlineArgs(cctx)
+		if err != nil {
+			return err
+		}
+
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := papi.ResumeWindowPoSt(lcli.ReqContext(cctx), maddr, dl); err != nil {
+			return err
+		}
+
+		fmt.Printf("WindowPoSt resumed for deadline %d of %s\n", dl, maddr)
+		return nil
+	},
+}
+
+var provingPausedCmd = &cli.Command{
+	Name:  "paused",
+	Usage: "List the deadlines WindowPoSt is paused for",
+	Action: func(cctx *cli.Context) error {
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		pauses, err := papi.PausedWindowPoSt(lcli.ReqContext(cctx))
+		if err != nil {
+			return err
+		}
+
+		tw := tablewriter.New(
+			tablewriter.Col("Miner"),
+			tablewriter.Col("Deadline"),
+			tablewriter.Col("PausedAt"),
+			tablewriter.Col("PausedBy"),
+			tablewriter.Col("Reason"),
+		)
+		for _, p := range pauses {
+			tw.Write(map[string]interface{}{
+				"Miner":    p.Miner.String(),
+				"Deadline": p.Deadline,
+				"PausedAt": p.PausedAt.Format(time.DateTime),
+				"PausedBy": p.PausedBy,
+				"Reason":   p.Reason,
+			})
+		}
+		return tw.Flush(os.Stdout)
+	},
+}
+
+func minerDeadlineArgs(cctx *cli.Context) (address.Address, uint64, error) {
+	if cctx.NArg() != 2 {
+		return address.Undef, 0, lcli.IncorrectNumArgs(cctx)
+	}
+
+	maddr, err := address.NewFromString(cctx.Args().Get(0))
+	if err != nil {
+		return address.Undef, 0, xerrors.Errorf("parsing miner address: %w", err)
+	}
+
+	var dl uint64
+	if _, err := fmt.Sscan(cctx.Args().Get(1), &dl); err != nil {
+		return address.Undef, 0, xerrors.Errorf("parsing deadline index: %w", err)
+	}
+
+	return maddr, dl, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
+)
+
+var provingRecoveriesCmd = &cli.Command{
+	Name:  "recoveries",
+	Usage: "Show the faulty sectors of the miners and the recoveries declared for them",
+	Description: `Faulty and recovering sectors of each partition are read from chain, along with the
+recovery declarations made by the cluster for the current and next proving period. The state
+of a declaration is one of queued, running, no-message (nothing could be declared), pending
+(message sent), executed or failed.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address, defaults to all configured miners",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		var maddrs []address.Address
+		if cctx.IsSet("miner") {
+			maddr, err := address.NewFromString(cctx.String("miner"))
+			if err != nil {
+				return xerrors.Errorf("parsing miner address: %w", err)
+			}
+			maddrs = append(maddrs, maddr)
+		} else {
+			for _, m := range deps.maddrs {
+				maddrs = append(maddrs, address.Address(m))
+			}
+		}
+
+		var out []*lpwindow.MinerRecoveries
+		for _, maddr := range maddrs {
+			st, err := lpwindow.RecoveryStatus(ctx, deps.full, deps.db, maddr)
+			if err != nil {
+				return xerrors.Errorf("getting recovery status of %s: %w", maddr, err)
+			}
+			out = append(out, st)
+		}
+
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(out)
+		}
+
+		for i, st := range out {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("Miner: %s (height %d)\n", st.Miner, st.Height)
+			fmt.Printf("Faulty sectors: %d, recovering: %d\n", st.Faulty, st.Recovering)
+			if len(st.Partitions) == 0 {
+				continue
+			}
+			fmt.Println()
+
+			tw := tablewriter.New(
+				tablewriter.Col("Deadline"),
+				tablewriter.Col("Partition"),
+				tablewriter.Col("Faulty"),
+				tablewriter.Col("Recovering"),
+				tablewriter.Col("Task"),
+				tablewriter.Col("State"),
+				tablewriter.Col("Message"),
+				tablewriter.Col("Executed"),
+			)
+			for _, p := range st.Partitions {
+				row := map[string]interface{}{
+					"Deadline":   p.Deadline,
+					"Partition":  p.Partition,
+					"Faulty":     len(p.FaultySectors),
+					"Recovering": len(p.RecoveringSectors),
+				}
+				if len(p.Declarations) == 0 {
+					row["State"] = "not-declared"
+					tw.Write(row)
+					continue
+				}
+				// one row per declaration, the last one is the latest
+				for _, d := range p.Declarations {
+					row["Task"] = d.TaskID
+					row["State"] = d.State
+					row["Message"] = d.Message
+					if d.ExecutedAt > 0 {
+						row["Executed"] = d.ExecutedAt
+					} else {
+						row["Executed"] = ""
+					}
+					tw.Write(row)
+				}
+			}
+			if err := tw.Flush(os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/tablewriter"
+	"github.com/filecoin-project/lotus/provider"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
+)
+
+var provingReplayCmd = &cli.Command{
+	Name:  "replay",
+	Usage: "Recompute and verify the proofs of a past deadline, without submitting",
+	Description: `Proves the partitions of a past deadline again in this process, with the challenge randomness of
+that deadline and the sectors as they were on chain at its challenge epoch, then verifies the proofs
+locally. Nothing is written to the database or sent to the chain. Use it after a suspected bad proof
+to check whether the storage would produce valid proofs for the deadline.
+
+The deadline replayed is the last one with the given index which opened at or before --epoch.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "miner",
+			Usage: "miner address, defaults to the first configured miner",
+		},
+		&cli.Uint64Flag{
+			Name:     "deadline",
+			Usage:    "deadline index to replay",
+			Required: true,
+		},
+		&cli.Int64Flag{
+			Name:     "epoch",
+			Usage:    "an epoch at or after the deadline opened",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+		&cli.StringSliceFlag{
+			Name:  "layers",
+			Usage: "list of layers to be interpreted (atop defaults). Default: base",
+			Value: cli.NewStringSlice("base"),
+		},
+		&cli.StringFlag{
+			Name:  "storage-json",
+			Usage: "path to json file containing storage config",
+			Value: "~/.lotus-provider/storage.json",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		deps, err := getDeps(ctx, cctx)
+		if err != nil {
+			return err
+		}
+
+		maddr, err := minerFromFlagOrConfig(cctx, deps)
+		if err != nil {
+			return err
+		}
+
+		wdPostTask, _, _, err := provider.WindowPostScheduler(chainsched.New(deps.full), deps.cfg.Fees, deps.cfg.Proving, deps.full, deps.verif, deps.lw, nil,
+			deps.as, deps.maddrs, deps.db, provider.FaultTracker(deps.stor, deps.si, deps.j, deps.cfg.Proving), deps.j, deps.al, deps.cfg.Subsystems.WindowPostMaxTasks, deps.cfg.Subsystems.WindowPostClusterMaxTasks, deps.cfg.Subsystems.WindowPostMaxFetches, nil, lpmessage.WaitMempool)
+		if err != nil {
+			return err
+		}
+
+		di, results, err := wdPostTask.ReplayDeadline(ctx, maddr, cctx.Uint64("deadline"), abi.ChainEpoch(cctx.Int64("epoch")))
+		if err != nil {
+			return xerrors.Errorf("replaying deadline: %w", err)
+		}
+
+		var failed int
+		for _, r := range results {
+			if !r.Valid {
+				failed++
+			}
+		}
+
+		if cctx.Bool("json") {
+			if err := json.NewEncoder(os.Stdout).Encode(struct {
+				Miner       string
+				Deadline    uint64
+				PeriodStart abi.ChainEpoch
+				Challenge   abi.ChainEpoch
+				Partitions  []lpwindow.ReplayResult
+			}{maddr.String(), di.Index, di.PeriodStart, di.Challenge, results}); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Miner %s, deadline %d of the period starting at %d (open %d, challenge %d)\n\n",
+				maddr, di.Index, di.PeriodStart, di.Open, di.Challenge)
+
+			tw := tablewriter.New(
+				tablewriter.Col("Partition"),
+				tablewriter.Col("Sectors"),
+				tablewriter.Col("Skipped"),
+				tablewriter.Col("Result"),
+				tablewriter.Col("Took"),
+				tablewriter.NewLineCol("Error"),
+			)
+			for _, r := range results {
+				result := "pass"
+				if !r.Valid {
+					result = "FAIL"
+				}
+				row := map[string]interface{}{
+					"Partition": r.Partition,
+					"Sectors":   r.Sectors,
+					"Skipped":   len(r.Skipped),
+					"Result":    result,
+					"Took":      r.Elapsed.Round(time.Millisecond),
+				}
+				if r.Error != "" {
+					row["Error"] = r.Error
+				}
+				if len(r.Skipped) > 0 {
+					row["Skipped"] = fmt.Sprintf("%d %v", len(r.Skipped), r.Skipped)
+				}
+				tw.Write(row)
+			}
+			if err := tw.Flush(os.Stdout); err != nil {
+				return err
+			}
+		}
+
+		if failed > 0 {
+			return xerrors.Errorf("%d of %d partitions didn't produce a valid proof", failed, len(results))
+		}
+		return nil
+	},
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+)
+
+var quiesceCmd = &cli.Command{
+	Name:  "quiesce",
+	Usage: "Stop a running lotus provider from claiming new tasks, letting running tasks finish",
+	Description: `Use this before host maintenance. The node is marked as draining in harmony_machines
+and the harmonytask_active_tasks metric can be watched to know when it is safe to stop.`,
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := api.Quiesce(lcli.ReqContext(cctx)); err != nil {
+			return err
+		}
+
+		fmt.Println("Quiesced: no new tasks will be claimed")
+		return nil
+	},
+}
+
+var unquiesceCmd = &cli.Command{
+	Name:  "unquiesce",
+	Usage: "Resume claiming new tasks on a quiesced lotus provider",
+	Action: func(cctx *cli.Context) error {
+		api, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := api.Unquiesce(lcli.ReqContext(cctx)); err != nil {
+			return err
+		}
+
+		fmt.Println("Resumed claiming tasks")
+		return nil
+	},
+}
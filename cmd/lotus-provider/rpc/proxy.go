@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// NormalizePathPrefix turns a configured path prefix into the "/a/b" form,
+// returning an empty string for root mounting.
+func NormalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// WithPathPrefix serves h under the given (normalized) path prefix. Requests
+// outside the prefix get a 404.
+func WithPathPrefix(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+
+	m := mux.NewRouter()
+	m.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, h))
+	return m
+}
+
+// ForwardedHeaders rewrites the request remote address and URL scheme from the
+// X-Forwarded-For and X-Forwarded-Proto headers set by a reverse proxy.
+func ForwardedHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			// the left-most entry is the original client
+			client := strings.TrimSpace(strings.Split(fwd, ",")[0])
+			if ip := net.ParseIP(client); ip != nil {
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+			}
+		}
+
+		switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+		case "http", "https":
+			r.URL.Scheme = proto
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,128 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// RequestIDHeader carries the request correlation ID. An ID supplied by the
+// client (or a proxy) is kept, otherwise a new one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// RequestID returns the correlation ID of the HTTP request ctx belongs to, or
+// an empty string when ctx doesn't come from a logged request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns a copy of ctx carrying the correlation ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDTransport sets the X-Request-ID header of outgoing requests to the
+// correlation ID of their context, so that a request to another node (e.g. a
+// sector fetch) can be matched with the request which triggered it. Requests
+// without an ID in their context, or which set the header themselves, are
+// sent as they are.
+type RequestIDTransport struct {
+	// Base is the transport requests are sent with, http.DefaultTransport
+	// when nil.
+	Base http.RoundTripper
+}
+
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	id := RequestID(req.Context())
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return base.RoundTrip(req)
+}
+
+// RequestLogger logs every request handled by h with its method, path, status,
+// duration and remote address. Each request gets a correlation ID which is
+// returned in the X-Request-ID header and made available to handlers through
+// RequestID, so that work triggered by the request can be logged with it, and
+// passed on to other nodes with RequestIDTransport.
+func RequestLogger(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r.WithContext(WithRequestID(r.Context(), id)))
+
+		log.Infow("http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+			"request_id", id)
+	})
+}
+
+// statusWriter records the status of a response. It keeps the optional
+// interfaces of the wrapped writer which handlers rely on: http.Flusher and
+// http.Hijacker, and io.ReaderFrom for sendfile when serving sector files.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is needed for websocket RPC connections.
+func (s *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, xerrors.Errorf("response writer does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
+
+func (s *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := s.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	// hide ReadFrom of s from io.Copy, which would call it again
+	return io.Copy(struct{ io.Writer }{s.ResponseWriter}, r)
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	// the node serving the fetch
+	var fetchID string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetchID = r.Header.Get(RequestIDHeader)
+	}))
+	defer remote.Close()
+
+	client := &http.Client{Transport: &RequestIDTransport{}}
+	var handlerID string
+	h := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = RequestID(r.Context())
+
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, remote.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}))
+
+	// a new ID is generated, and passed on
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/remote/sealed/s-t01000-1", nil))
+	require.NotEmpty(t, handlerID)
+	require.Equal(t, handlerID, rec.Header().Get(RequestIDHeader))
+	require.Equal(t, handlerID, fetchID)
+
+	// the ID of the client is kept
+	req := httptest.NewRequest(http.MethodGet, "/remote/sealed/s-t01000-1", nil)
+	req.Header.Set(RequestIDHeader, "client-id")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, "client-id", handlerID)
+	require.Equal(t, "client-id", fetchID)
+}
+
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (w *readerFromWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, r)
+}
+
+func TestStatusWriterInterfaces(t *testing.T) {
+	h := RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Implements(t, (*http.Flusher)(nil), w)
+		require.Implements(t, (*http.Hijacker)(nil), w)
+
+		// sector files are served with io.Copy, which uses ReadFrom
+		_, err := io.Copy(w, struct{ io.Reader }{strings.NewReader("sector data")})
+		require.NoError(t, err)
+	}))
+
+	w := &readerFromWriter{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/remote/sealed/s-t01000-1", nil))
+	require.True(t, w.readFrom)
+	require.Equal(t, "sector data", w.Body.String())
+
+	// without ReadFrom on the wrapped writer the copy still works
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/remote/sealed/s-t01000-1", nil))
+	require.Equal(t, "sector data", rec.Body.String())
+}
@@ -6,16 +6,18 @@ import (
 
 	"github.com/gorilla/mux"
 
-	// logging "github.com/ipfs/go-log/v2"
+	logging "github.com/ipfs/go-log/v2"
+
 	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-jsonrpc/auth"
 
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/lib/rpcenc"
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/metrics/proxy"
 )
 
-//var log = logging.Logger("lp/rpc")
+var log = logging.Logger("lp/rpc")
 
 func LotusProviderHandler(
 	authv func(ctx context.Context, token string) ([]auth.Permission, error),
@@ -37,6 +39,8 @@ func LotusProviderHandler(
 	mux.Handle("/rpc/v0", rpcServer)
 	mux.Handle("/rpc/streams/v0/push/{uuid}", readerHandler)
 	mux.PathPrefix("/remote").HandlerFunc(remote)
+	mux.Handle("/debug/metrics", metrics.Exporter())
+	mux.HandleFunc("/debug/config", configHandler(a, permissioned))
 	mux.PathPrefix("/").Handler(http.DefaultServeMux) // pprof
 
 	if !permissioned {
@@ -49,3 +53,35 @@ func LotusProviderHandler(
 	}
 	return ah
 }
+
+// configHandler serves the effective config of the node, as returned by the
+// Config API method. The format is picked with the format query parameter,
+// toml by default. When permissioned, an admin token is required.
+func configHandler(a api.LotusProvider, permissioned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if permissioned && !auth.HasPerm(r.Context(), nil, api.PermAdmin) {
+			http.Error(w, "admin permission required", http.StatusUnauthorized)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "toml"
+		}
+
+		cfg, err := a.Config(r.Context(), format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if format == "json" {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "application/toml")
+		}
+		if _, err := w.Write([]byte(cfg)); err != nil {
+			log.Warnw("writing config response", "error", err)
+		}
+	}
+}
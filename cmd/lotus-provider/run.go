@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/gbrlsnchs/jwt/v3"
 	"github.com/gorilla/mux"
 	ds "github.com/ipfs/go-datastore"
@@ -18,16 +20,21 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"github.com/urfave/cli/v2"
+	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
 	"github.com/filecoin-project/go-jsonrpc/auth"
 	"github.com/filecoin-project/go-statestore"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/api/client"
 	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
 	lcli "github.com/filecoin-project/lotus/cli"
 	cliutil "github.com/filecoin-project/lotus/cli/util"
 	"github.com/filecoin-project/lotus/cmd/lotus-provider/rpc"
@@ -36,6 +43,8 @@ import (
 	"github.com/filecoin-project/lotus/journal/fsjournal"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+	"github.com/filecoin-project/lotus/lib/tracing"
 	"github.com/filecoin-project/lotus/lib/ulimit"
 	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/node"
@@ -43,7 +52,15 @@ import (
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/node/repo"
 	"github.com/filecoin-project/lotus/provider"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+	"github.com/filecoin-project/lotus/provider/lpbalance"
+	"github.com/filecoin-project/lotus/provider/lpbreaker"
 	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/provider/lpprune"
+	"github.com/filecoin-project/lotus/provider/lprand"
+	"github.com/filecoin-project/lotus/provider/lpsync"
+	"github.com/filecoin-project/lotus/provider/lpverifreg"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
 	"github.com/filecoin-project/lotus/provider/lpwinning"
 	"github.com/filecoin-project/lotus/storage/ctladdr"
 	"github.com/filecoin-project/lotus/storage/paths"
@@ -70,6 +87,10 @@ var runCmd = &cli.Command{
 			Name:  "nosync",
 			Usage: "don't check full-node sync status",
 		},
+		&cli.DurationFlag{
+			Name:  "sync-timeout",
+			Usage: "fail startup if the full node isn't in sync after this long, 0 to wait forever",
+		},
 		&cli.BoolFlag{
 			Name:   "halt-after-init",
 			Usage:  "only run init, then return",
@@ -95,6 +116,25 @@ var runCmd = &cli.Command{
 			Usage: "path to journal files",
 			Value: "~/.lotus-provider/",
 		},
+		&cli.DurationFlag{
+			Name:  "storage-metrics-interval",
+			Usage: "how often to record local storage path usage metrics, 0 to disable",
+			Value: time.Minute,
+		},
+		&cli.IntFlag{
+			Name:    "weight",
+			Usage:   "capacity of this node relative to others in the cluster; a node with weight 2 takes about twice the tasks of a node with weight 1",
+			Value:   1,
+			EnvVars: []string{"LOTUS_PROVIDER_WEIGHT"},
+		},
+		&cli.BoolFlag{
+			Name:  "check-sector-storage",
+			Usage: "on startup, warn about live sectors which have no sealed or cache files in any declared storage path; looks up every live sector, which can take a while on large miners",
+		},
+		&cli.BoolFlag{
+			Name:  "strict-sector-storage",
+			Usage: "fail startup if the sector storage check finds sectors without files",
+		},
 	},
 	Action: func(cctx *cli.Context) (err error) {
 		defer func() {
@@ -128,15 +168,12 @@ var runCmd = &cli.Command{
 			}()
 		}
 		// Register all metric views
-		/*
-			if err := view.Register(
-				metrics.MinerNodeViews...,
-			); err != nil {
-				log.Fatalf("Cannot register the view: %v", err)
-			}
-		*/
-		// Set the metric to one so it is published to the exporter
-		stats.Record(ctx, metrics.LotusInfo.M(1))
+		providerViews := append(metrics.RegisteredViews(), metrics.ProviderNodeViews...)
+		if err := view.Register(
+			providerViews...,
+		); err != nil {
+			log.Fatalf("Cannot register the view: %v", err)
+		}
 
 		if cctx.Bool("manage-fdlimit") {
 			if _, _, err := ulimit.ManageFdLimit(); err != nil {
@@ -144,38 +181,198 @@ var runCmd = &cli.Command{
 			}
 		}
 
-		deps, err := getDeps(ctx, cctx)
+		deps, err := getRunDeps(ctx, cctx)
 
 		if err != nil {
 			return err
 		}
+
+		ctx, _ = tag.New(ctx, tag.Insert(metrics.NodeName, deps.identity.Name))
+		// Set the metric to one so it is published to the exporter
+		stats.Record(ctx, metrics.LotusInfo.M(1))
+		deps.identity.RecordMetrics(ctx)
+
+		if tc := deps.cfg.Tracing; tc.Enabled {
+			if tc.JaegerCollectorEndpoint == "" {
+				return xerrors.Errorf("tracing enabled, but Tracing.JaegerCollectorEndpoint isn't set")
+			}
+			tp, err := tracing.SetupJaegerCollectorTracing(tc.ServiceName, tc.JaegerCollectorEndpoint, tc.SampleRatio)
+			if err != nil {
+				return xerrors.Errorf("setting up tracing: %w", err)
+			}
+			defer func() {
+				_ = tp.Shutdown(context.Background())
+			}()
+		}
+
+		pushViews := providerViews
+		if deps.cfg.Metrics.LegacyAggregates {
+			var n int
+			pushViews, n, err = metrics.RegisterAggregateViews(pushViews, metrics.MinerID)
+			if err != nil {
+				return xerrors.Errorf("registering aggregate metric views: %w", err)
+			}
+			log.Warnw("exporting aggregates in place of metrics tagged by miner, Metrics.LegacyAggregates will be removed in the 1.27 release", "views", n)
+		}
+
+		if mc := deps.cfg.Metrics; mc.PushProtocol != "" {
+			pusher, err := metrics.NewStatsdPusher(mc.PushEndpoint, mc.PushPrefix, pushViews)
+			if err != nil {
+				return xerrors.Errorf("setting up metrics push: %w", err)
+			}
+			go pusher.Run(ctx, time.Duration(mc.PushInterval))
+		}
+
 		cfg, db, full, verif, lw, as, maddrs, stor, si, localStore := deps.cfg, deps.db, deps.full, deps.verif, deps.lw, deps.as, deps.maddrs, deps.stor, deps.si, deps.localStore
 
+		if cctx.Bool("check-sector-storage") || cctx.Bool("strict-sector-storage") {
+			if err := checkSectorStorage(ctx, full, si, maddrs, cctx.Bool("strict-sector-storage")); err != nil {
+				return err
+			}
+		}
+
+		if cfg.Subsystems.EnableWindowPost {
+			go warnProvingLoad(ctx, deps)
+		}
+
+		if iv := cctx.Duration("storage-metrics-interval"); iv > 0 {
+			go localStore.ReportMetrics(ctx, iv)
+		}
+
+		for _, o := range deps.objectStores {
+			go o.Run(ctx, si)
+		}
+
 		var activeTasks []harmonytask.TaskInterface
 
-		sender, sendTask := lpmessage.NewSender(full, full, db)
+		signer, err := messageSigner(ctx, cfg.Addresses.ExternalSigner, full)
+		if err != nil {
+			return err
+		}
+
+		sender, sendTask := lpmessage.NewSender(ctx, full, signer, db)
 		activeTasks = append(activeTasks, sendTask)
+		fallbackAlerts := lpbalance.NewFallbackAlerts(deps.al)
+		as.OnFallback = fallbackAlerts.Fallback
+		as.OnSelect = func(sel ctladdr.Selection) {
+			sender.RecordSelection(sel)
+			fallbackAlerts.Selected(sel)
+		}
+
+		balanceMonitor := lpbalance.NewMonitor(full, as, deps.al, maddrs,
+			cfg.Addresses.LowBalanceThreshold, time.Duration(cfg.Addresses.BalanceCheckInterval))
+		go balanceMonitor.Run(ctx)
+
+		if cfg.Subsystems.ExpectedVerifregRootKey != "" {
+			rootKey, err := address.NewFromString(cfg.Subsystems.ExpectedVerifregRootKey)
+			if err != nil {
+				return xerrors.Errorf("parsing expected verifreg root key: %w", err)
+			}
+			go lpverifreg.NewMonitor(full, deps.al, rootKey, time.Duration(cfg.Subsystems.VerifregCheckInterval)).Run(ctx)
+		}
+
+		var wdPostTask *lpwindow.WdPostTask
+
+		prover := lw
+		ft := provider.FaultTracker(stor, si, deps.j, cfg.Proving)
+
+		if checks := cfg.Subsystems.SafeModeChecks; len(checks) > 0 {
+			if !cfg.Subsystems.EnableWindowPost && !cfg.Subsystems.EnableWinningPost {
+				// this node doesn't prove, its storage may not have any sectors
+				checks = lo.Without(checks, "proof")
+			}
+			safeChecks, err := provider.SafeModeChecks(checks, full, db, prover, verif, maddrs)
+			if err != nil {
+				return xerrors.Errorf("Subsystems.SafeModeChecks: %w", err)
+			}
+			safeMode := provider.NewSafeMode(sender, deps.al, safeChecks, time.Duration(cfg.Subsystems.SafeModeRetryInterval))
+			go safeMode.Run(ctx)
+		}
 
 		///////////////////////////////////////////////////////////////////////
 		///// Task Selection
 		///////////////////////////////////////////////////////////////////////
 		{
+			// all tasks following the chain share one scheduler
+			chainSched := chainsched.New(full)
 
 			if cfg.Subsystems.EnableWindowPost {
-				wdPostTask, wdPoStSubmitTask, derlareRecoverTask, err := provider.WindowPostScheduler(ctx, cfg.Fees, cfg.Proving, full, verif, lw, sender,
-					as, maddrs, db, stor, si, cfg.Subsystems.WindowPostMaxTasks)
+				submitWait, err := lpmessage.ParseWaitStrategy(cfg.Subsystems.WindowPostSubmitWait)
+				if err != nil {
+					return xerrors.Errorf("Subsystems.WindowPostSubmitWait: %w", err)
+				}
+
+				var affinity *lpwindow.StorageAffinity
+				if cfg.Subsystems.WindowPostStorageAffinity {
+					affinity = lpwindow.NewStorageAffinity(db, localStore, time.Duration(cfg.Subsystems.WindowPostAffinityGrace))
+				}
+
+				var wdPoStSubmitTask *lpwindow.WdPostSubmitTask
+				var derlareRecoverTask *lpwindow.WdPostRecoverDeclareTask
+				wdPostTask, wdPoStSubmitTask, derlareRecoverTask, err = provider.WindowPostScheduler(chainSched, cfg.Fees, cfg.Proving, full, verif, prover, sender,
+					as, maddrs, db, ft, deps.j, deps.al, cfg.Subsystems.WindowPostMaxTasks, cfg.Subsystems.WindowPostClusterMaxTasks, cfg.Subsystems.WindowPostMaxFetches, affinity, submitWait)
 				if err != nil {
 					return err
 				}
 				activeTasks = append(activeTasks, wdPostTask, wdPoStSubmitTask, derlareRecoverTask)
+
+				if iv := time.Duration(cfg.Subsystems.SectorSyncInterval); iv > 0 {
+					go lpwindow.NewSectorSync(full, si, localStore, maddrs).Run(ctx, iv)
+				}
 			}
 
 			if cfg.Subsystems.EnableWinningPost {
-				winPoStTask := lpwinning.NewWinPostTask(cfg.Subsystems.WinningPostMaxTasks, db, lw, verif, full, maddrs)
+				winPoStTask := lpwinning.NewWinPostTask(ctx, cfg.Subsystems.WinningPostMaxTasks, db, prover, verif, lprand.Node(full), full, maddrs, deps.listenAddr)
 				activeTasks = append(activeTasks, winPoStTask)
+
+				if _, err := lpwinning.NewInclusionTracker(chainSched, full, db, maddrs); err != nil {
+					return err
+				}
+			}
+
+			if cfg.Subsystems.EnableSpotCheck {
+				spotCheckTask := provider.SpotCheckScheduler(ctx, full, db, ft, deps.al, maddrs,
+					time.Duration(cfg.Subsystems.SpotCheckInterval), cfg.Subsystems.SpotCheckSampleSize)
+				activeTasks = append(activeTasks, spotCheckTask)
 			}
+
+			if len(cfg.Subsystems.CanarySectors) > 0 {
+				canaries, err := lpwindow.ParseCanarySectors(cfg.Subsystems.CanarySectors)
+				if err != nil {
+					return xerrors.Errorf("Subsystems.CanarySectors: %w", err)
+				}
+				for _, c := range canaries {
+					if !lo.Contains(maddrs, dtypes.MinerAddress(c.Miner)) {
+						return xerrors.Errorf("Subsystems.CanarySectors: canary %s of a miner not in Addresses", c)
+					}
+				}
+				canaryTask, err := provider.CanaryScheduler(chainSched, full, db, ft, deps.al, canaries)
+				if err != nil {
+					return err
+				}
+				activeTasks = append(activeTasks, canaryTask)
+			}
+
+			if cfg.Subsystems.EnableProvingWarmup {
+				warmTask := provider.WarmScheduler(ctx, full, db, localStore, stor, si, deps.al, maddrs, cfg.Subsystems.ProvingWarmupFetch)
+				activeTasks = append(activeTasks, warmTask)
+			}
+
+			if cfg.Subsystems.EnableHistoryPruning {
+				pruneTask := lpprune.NewPruneTask(ctx, db, time.Duration(cfg.Subsystems.HistoryRetention))
+				activeTasks = append(activeTasks, pruneTask)
+			}
+
+			go chainSched.Run(ctx)
 		}
+		activeTasks = provider.GuardTasks(deps.breaker, activeTasks)
+		activeTasks, err = provider.RestrictTaskTypes(activeTasks, cfg.Subsystems.AllowTaskTypes, cfg.Subsystems.DenyTaskTypes)
+		if err != nil {
+			return xerrors.Errorf("Subsystems.AllowTaskTypes: %w", err)
+		}
+
 		log.Infow("This lotus_provider instance handles",
+			"node", deps.identity.Name,
 			"miner_addresses", minerAddressesToStrings(maddrs),
 			"tasks", lo.Map(activeTasks, func(t harmonytask.TaskInterface, _ int) string { return t.TypeDetails().Name }))
 
@@ -186,9 +383,47 @@ var runCmd = &cli.Command{
 
 		defer taskEngine.GracefullyTerminate(time.Hour)
 
-		fh := &paths.FetchHandler{Local: localStore, PfHandler: &paths.DefaultPartialFileHandler{}}
+		if err := taskEngine.SetIdentity(ctx, deps.identity.Name, deps.identity.Labels); err != nil {
+			return err
+		}
+
+		if err := taskEngine.SetWeight(ctx, cctx.Int("weight")); err != nil {
+			return err
+		}
+		{
+			res := resources.Resources{
+				Cpu: cfg.Subsystems.ResourceCPUs,
+				Gpu: cfg.Subsystems.ResourceGPUs,
+			}
+			if cfg.Subsystems.ResourceRAM != "" {
+				ram, err := units.RAMInBytes(cfg.Subsystems.ResourceRAM)
+				if err != nil {
+					return xerrors.Errorf("Subsystems.ResourceRAM: %w", err)
+				}
+				res.Ram = uint64(ram)
+			}
+			if err := taskEngine.SetResources(ctx, res); err != nil {
+				return xerrors.Errorf("declaring node resources: %w", err)
+			}
+		}
+		taskEngine.SetAlerting(deps.al)
+		if err := taskEngine.SetPollInterval(time.Duration(cfg.Subsystems.TaskPollInterval)); err != nil {
+			return xerrors.Errorf("Subsystems.TaskPollInterval: %w", err)
+		}
+		if cfg.Subsystems.EnableTaskNotify {
+			if err := taskEngine.EnableNotify(); err != nil {
+				log.Warnw("task notifications unavailable, claiming tasks by polling only", "error", err)
+			}
+		}
+
+		compress, err := compressTypes(cfg.Storage.FetchCompressTypes)
+		if err != nil {
+			return err
+		}
+		fh := &paths.FetchHandler{Local: localStore, PfHandler: deps.pfHandler, Compress: compress}
 		remoteHandler := func(w http.ResponseWriter, r *http.Request) {
 			if !auth.HasPerm(r.Context(), nil, api.PermAdmin) {
+				log.Warnw("unauthorized remote storage request", "path", r.URL.Path, "request_id", rpc.RequestID(r.Context()))
 				w.WriteHeader(401)
 				_ = json.NewEncoder(w).Encode(struct{ Error string }{"unauthorized: missing admin permission"})
 				return
@@ -226,12 +461,22 @@ var runCmd = &cli.Command{
 			}
 		}
 		// Serve the RPC.
+		var handler http.Handler = rpc.LotusProviderHandler(
+			authVerify,
+			remoteHandler,
+			&ProviderAPI{deps, taskEngine, wdPostTask, shutdownChan},
+			true)
+		handler = rpc.WithPathPrefix(rpc.NormalizePathPrefix(deps.cfg.Apis.HTTPPathPrefix), handler)
+		if deps.cfg.Apis.RequestLogging {
+			handler = rpc.RequestLogger(handler)
+		}
+		if deps.cfg.Apis.TrustForwardedHeaders {
+			handler = rpc.ForwardedHeaders(handler)
+		}
+		handler = &ochttp.Handler{Handler: handler}
+
 		srv := &http.Server{
-			Handler: rpc.LotusProviderHandler(
-				authVerify,
-				remoteHandler,
-				&ProviderAPI{deps, shutdownChan},
-				true),
+			Handler:           handler,
 			ReadHeaderTimeout: time.Minute * 3,
 			BaseContext: func(listener net.Listener) context.Context {
 				ctx, _ := tag.New(context.Background(), tag.Upsert(metrics.APIInterface, "lotus-worker"))
@@ -250,8 +495,10 @@ var runCmd = &cli.Command{
 
 		// Monitor for shutdown.
 		// TODO provide a graceful shutdown API on shutdownChan
-		finishCh := node.MonitorShutdown(shutdownChan) //node.ShutdownHandler{Component: "rpc server", StopFunc: rpcStopper},
-		//node.ShutdownHandler{Component: "provider", StopFunc: stop},
+		finishCh := node.MonitorShutdown(shutdownChan, //node.ShutdownHandler{Component: "rpc server", StopFunc: rpcStopper},
+			//node.ShutdownHandler{Component: "provider", StopFunc: stop},
+			node.ShutdownHandler{Component: "address audit", StopFunc: sender.Close},
+		)
 
 		<-finishCh
 		return nil
@@ -266,6 +513,9 @@ func makeDB(cctx *cli.Context) (*harmonydb.DB, error) {
 		Database: cctx.String("db-name"),
 		Port:     cctx.String("db-port"),
 	}
+	if replicas := cctx.String("db-read-replicas"); replicas != "" {
+		dbConfig.ReadReplicas = strings.Split(replicas, ",")
+	}
 	return harmonydb.NewFromConfig(dbConfig)
 }
 
@@ -310,10 +560,54 @@ type Deps struct {
 	stor       *paths.Remote
 	si         *paths.DBIndex
 	localStore *paths.Local
+	pfHandler  paths.PartialFileHandler
 	listenAddr string
+	al         *alerting.Alerting
+	j          journal.Journal
+	breaker    *lpbreaker.Breaker
+	// object store paths configured in storage.json, fetched from by stor
+	objectStores []*paths.ObjectStore
+	identity     provider.NodeIdentity
 }
 
+// getDeps opens the dependencies of commands which run alongside a node of
+// the repo.
 func getDeps(ctx context.Context, cctx *cli.Context) (*Deps, error) {
+	return openDeps(ctx, cctx, nil)
+}
+
+// getRunDeps opens the dependencies of the node. Unless --nosync is set, it
+// waits for the full node to be in sync before setting up anything which
+// depends on it.
+func getRunDeps(ctx context.Context, cctx *cli.Context) (*Deps, error) {
+	var syncCfg *lpsync.Config
+	if !cctx.Bool("nosync") {
+		scfg := lpsync.DefaultConfig
+		scfg.Timeout = cctx.Duration("sync-timeout")
+		syncCfg = &scfg
+	}
+	return openDeps(ctx, cctx, syncCfg)
+}
+
+// connectFullNode connects to the chain node of cfg. With syncCfg set, it
+// only returns once the node is in sync.
+func connectFullNode(ctx context.Context, cctx *cli.Context, cfg *config.LotusProviderConfig, syncCfg *lpsync.Config) (api.FullNode, jsonrpc.ClientCloser, error) {
+	full, fullCloser, err := cliutil.GetFullNodeAPIV1LotusProvider(cctx, cfg.Apis.ChainApiInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if syncCfg != nil {
+		if err := lpsync.Wait(ctx, full, *syncCfg); err != nil {
+			fullCloser()
+			return nil, nil, xerrors.Errorf("sync wait: %w", err)
+		}
+	}
+
+	return full, fullCloser, nil
+}
+
+func openDeps(ctx context.Context, cctx *cli.Context, syncCfg *lpsync.Config) (_ *Deps, err error) {
 	// Open repo
 
 	repoPath := cctx.String(FlagRepoPath)
@@ -347,6 +641,12 @@ func getDeps(ctx context.Context, cctx *cli.Context) (*Deps, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := cfg.Storage.ValidateHeartbeat(paths.SkippedHeartbeatThresh); err != nil {
+		return nil, xerrors.Errorf("storage config: %w", err)
+	}
+	if err := cfg.Metrics.ValidatePush(); err != nil {
+		return nil, xerrors.Errorf("metrics config: %w", err)
+	}
 
 	log.Debugw("config", "config", cfg)
 
@@ -361,16 +661,26 @@ func getDeps(ctx context.Context, cctx *cli.Context) (*Deps, error) {
 	if err != nil {
 		return nil, err
 	}
-	j, err := fsjournal.OpenFSJournalPath(cctx.String("journal"), de)
+	identity, err := provider.Identity(cfg.Node)
 	if err != nil {
 		return nil, err
 	}
 
-	full, fullCloser, err := cliutil.GetFullNodeAPIV1LotusProvider(cctx, cfg.Apis.ChainApiInfo)
+	j, err := fsjournal.OpenFSJournalPathNode(cctx.String("journal"), de, identity.Name)
 	if err != nil {
 		return nil, err
 	}
 
+	// before the storage paths are attached, so that other nodes don't send
+	// work here while the chain node is catching up
+	full, fullCloser, err := connectFullNode(ctx, cctx, cfg, syncCfg)
+	if err != nil {
+		_ = j.Close()
+		return nil, err
+	}
+	breaker := lpbreaker.New(cfg.Apis.ChainApiBreakerThreshold, time.Duration(cfg.Apis.ChainApiBreakerCooldown))
+	full = breaker.Wrap(full)
+
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -378,12 +688,25 @@ func getDeps(ctx context.Context, cctx *cli.Context) (*Deps, error) {
 			_ = j.Close()
 		}
 	}()
-	sa, err := StorageAuth(cfg.Apis.StorageRPCSecret)
+	secret := cfg.Apis.StorageRPCSecret
+	if secret == "" && cfg.Apis.MinerKeystorePath != "" {
+		secret, err = minerStorageSecret(cfg.Apis.MinerKeystorePath)
+		if err != nil {
+			return nil, xerrors.Errorf("reading StorageRPCSecret from MinerKeystorePath: %w", err)
+		}
+	}
+	sa, err := StorageAuth(secret)
 	if err != nil {
 		return nil, xerrors.Errorf(`'%w' while parsing the config toml's 
 	[Apis]
 	StorageRPCSecret=%v
-Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU`, err, cfg.Apis.StorageRPCSecret)
+Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU
+or set MinerKeystorePath to the keystore of the miner`, err, cfg.Apis.StorageRPCSecret)
+	}
+	if cfg.Apis.StorageRPCSecret == "" && cfg.Apis.MinerKeystorePath != "" {
+		if err := checkMinerStorageAuth(ctx, cfg.Apis.MinerKeystorePath, sa); err != nil {
+			return nil, err
+		}
 	}
 
 	al := alerting.NewAlertingSystem(j)
@@ -404,19 +727,70 @@ Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU`,
 			listenAddr = rip + ":" + addressSlice[1]
 		}
 	}
-	localStore, err := paths.NewLocal(ctx, bls, si, []string{"http://" + listenAddr + "/remote"})
+	localStore, err := provider.LocalStorage(ctx, cfg.Storage, bls, si, []string{"http://" + listenAddr + rpc.NormalizePathPrefix(cfg.Apis.HTTPPathPrefix) + "/remote"})
 	if err != nil {
 		return nil, err
 	}
+	if iv := time.Duration(cfg.Storage.HeartbeatInterval); iv > 0 {
+		localStore.SetHeartbeatInterval(iv)
+	}
 
-	stor := paths.NewRemote(localStore, si, http.Header(sa), 10, &paths.DefaultPartialFileHandler{})
+	pfHandler, err := paths.NewPartialFileHandler(cfg.Storage.PartialFileHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	stor := paths.NewRemote(localStore, si, http.Header(sa), 10, pfHandler)
+	stor.SetHTTPClient(fetchClient(cfg.Storage))
+
+	compress, err := compressTypes(cfg.Storage.FetchCompressTypes)
+	if err != nil {
+		return nil, err
+	}
+	stor.SetCompressTypes(compress)
+
+	scfg, err := bls.GetStorage()
+	if err != nil {
+		return nil, xerrors.Errorf("reading storage config: %w", err)
+	}
+	var objectStores []*paths.ObjectStore
+	for _, oc := range scfg.ObjectStores {
+		o, err := paths.NewObjectStore(oc)
+		if err != nil {
+			return nil, err
+		}
+		stor.AddObjectStore(o)
+		objectStores = append(objectStores, o)
+	}
 
 	wstates := statestore.New(dssync.MutexWrap(ds.NewMapDatastore()))
 
+	// vanilla proofs generated by lw for WindowPoSt go through the cache,
+	// sector checks of the fault tracker read stor directly
+	var lwStor paths.Store = stor
+	if cfg.Subsystems.WindowPostVanillaCache {
+		lwStor = lpwindow.NewVanillaCache(db, stor, time.Duration(cfg.Subsystems.WindowPostVanillaCacheTTL))
+	}
+
 	// todo localWorker isn't the abstraction layer we want to use here, we probably want to go straight to ffiwrapper
 	//  maybe with a lotus-provider specific abstraction. LocalWorker does persistent call tracking which we probably
 	//  don't need (ehh.. maybe we do, the async callback system may actually work decently well with harmonytask)
-	lw := sealer.NewLocalWorker(sealer.WorkerConfig{}, stor, localStore, si, nil, wstates)
+	exec, err := remoteSnarkExec(ctx, cfg.Subsystems)
+	if err != nil {
+		return nil, err
+	}
+	var cpuProver provider.SnarkProver
+	if cfg.Subsystems.ProvingCPUFallback {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, xerrors.Errorf("finding the cpu prover binary: %w", err)
+		}
+		cpuProver = &provider.ChildSnarkProver{Path: self, Args: []string{cpuSnarkCmd.Name}}
+	}
+	exec = provider.NewGPUFallback(cpuProver, al).Exec(exec)
+	// only the synchronous proving methods of lw are used, they neither track
+	// calls in wstates nor return results, so lw has no WorkerReturn
+	lw := sealer.NewLocalWorkerWithExecutor(exec, sealer.WorkerConfig{}, os.LookupEnv, lwStor, localStore, si, nil, wstates)
 
 	var maddrs []dtypes.MinerAddress
 	for _, s := range cfg.Addresses.MinerAddresses {
@@ -427,6 +801,20 @@ Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU`,
 		maddrs = append(maddrs, dtypes.MinerAddress(addr))
 	}
 
+	addrs := lo.Map(maddrs, func(m dtypes.MinerAddress, _ int) address.Address { return address.Address(m) })
+	if err := cfg.Fees.ValidateMinerOverrides(addrs); err != nil {
+		return nil, xerrors.Errorf("fee config: %w", err)
+	}
+	if err := cfg.Fees.ValidateFeeSchedule(); err != nil {
+		return nil, xerrors.Errorf("fee config: %w", err)
+	}
+	if err := cfg.Fees.ValidateGasLimits(); err != nil {
+		return nil, xerrors.Errorf("fee config: %w", err)
+	}
+	if err := cfg.Proving.ValidateSafetyMargins(addrs); err != nil {
+		return nil, xerrors.Errorf("proving config: %w", err)
+	}
+
 	return &Deps{ // lint: intentionally not-named so it will fail if one is forgotten
 		cfg,
 		db,
@@ -438,13 +826,21 @@ Get it with: jq .PrivateKey ~/.lotus-miner/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU`,
 		stor,
 		si,
 		localStore,
+		pfHandler,
 		listenAddr,
+		al,
+		j,
+		breaker,
+		objectStores,
+		identity,
 	}, nil
 
 }
 
 type ProviderAPI struct {
 	*Deps
+	TaskEngine   *harmonytask.TaskEngine
+	WdPost       *lpwindow.WdPostTask // nil unless EnableWindowPost is set
 	ShutdownChan chan struct{}
 }
 
@@ -452,6 +848,133 @@ func (p *ProviderAPI) Version(context.Context) (api.Version, error) {
 	return api.ProviderAPIVersion0, nil
 }
 
+func (p *ProviderAPI) Quiesce(ctx context.Context) error {
+	return p.TaskEngine.Quiesce(ctx)
+}
+
+func (p *ProviderAPI) Unquiesce(ctx context.Context) error {
+	return p.TaskEngine.Unquiesce(ctx)
+}
+
+func (p *ProviderAPI) ComputeWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64, submit bool) (api.WdPoStCompute, error) {
+	if p.WdPost == nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("WindowPoSt is not enabled on this node")
+	}
+	return p.WdPost.ComputeNow(ctx, maddr, deadline, submit)
+}
+
+func (p *ProviderAPI) WindowPoStComputeStatus(ctx context.Context, c api.WdPoStCompute) (api.WdPoStCompute, error) {
+	if p.WdPost == nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("WindowPoSt is not enabled on this node")
+	}
+	return p.WdPost.ComputeStatus(ctx, c)
+}
+
+func (p *ProviderAPI) PauseWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64, reason string) error {
+	if p.WdPost == nil {
+		return xerrors.Errorf("WindowPoSt is not enabled on this node")
+	}
+	return p.WdPost.PauseDeadline(ctx, maddr, deadline, reason)
+}
+
+func (p *ProviderAPI) ResumeWindowPoSt(ctx context.Context, maddr address.Address, deadline uint64) error {
+	if p.WdPost == nil {
+		return xerrors.Errorf("WindowPoSt is not enabled on this node")
+	}
+	return p.WdPost.ResumeDeadline(ctx, maddr, deadline)
+}
+
+func (p *ProviderAPI) PausedWindowPoSt(ctx context.Context) ([]api.WdPoStPause, error) {
+	if p.WdPost == nil {
+		return nil, xerrors.Errorf("WindowPoSt is not enabled on this node")
+	}
+	return p.WdPost.PausedDeadlines(ctx)
+}
+
+func (p *ProviderAPI) StorageSetReadOnly(ctx context.Context, id storiface.ID, readOnly bool) error {
+	return p.localStore.SetReadOnly(ctx, id, readOnly)
+}
+
+// maxStorageBenchDuration bounds each phase of StorageBench, so that a
+// benchmark can't keep loading a drive for long.
+const maxStorageBenchDuration = time.Minute
+
+// benchProvingTasks are the task types StorageBench competes with for the
+// drives: proving, and the checks reading sectors like proving does.
+var benchProvingTasks = []string{"WdPost", "WdPostSubmit", "WdPostRecover", "WinPost", "WdPostSpotChk", "WdPostCanary", "WdPostWarm"}
+
+// benchAbortCheckInterval is how often StorageBench checks for proving tasks
+// claimed by this node while it runs.
+const benchAbortCheckInterval = 100 * time.Millisecond
+
+func (p *ProviderAPI) StorageBench(ctx context.Context, id storiface.ID, opts api.StorageBenchOpts) (storiface.PathBench, error) {
+	if opts.Duration <= 0 || opts.Duration > maxStorageBenchDuration {
+		return storiface.PathBench{}, xerrors.Errorf("bench duration must be between 0 and %s", maxStorageBenchDuration)
+	}
+
+	if !opts.Force {
+		// the benchmark competes with proving for the drive, like the spot check
+		var pending int
+		err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM harmony_task WHERE name = ANY($1)`, benchProvingTasks).Scan(&pending)
+		if err != nil {
+			return storiface.PathBench{}, xerrors.Errorf("counting pending proving tasks: %w", err)
+		}
+		if pending > 0 {
+			return storiface.PathBench{}, xerrors.Errorf("%d proving tasks pending, not benchmarking while proving (force to run anyway)", pending)
+		}
+	}
+
+	// even when forced, proving on this node comes first
+	benchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		ticker := time.NewTicker(benchAbortCheckInterval)
+		defer ticker.Stop()
+		for {
+			if n := p.TaskEngine.RunningCountOf(benchProvingTasks...); n > 0 {
+				cancel(xerrors.Errorf("%d proving tasks claimed by this node, benchmark aborted", n))
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-benchCtx.Done():
+				return
+			}
+		}
+	}()
+
+	res, err := p.localStore.Bench(benchCtx, id, paths.BenchOpts{Duration: opts.Duration, BlockSize: opts.BlockSize})
+	if err != nil {
+		return storiface.PathBench{}, err
+	}
+
+	if opts.Record {
+		_, err := p.db.Exec(ctx, `INSERT INTO storage_path_bench (storage_id, host_and_port, machine_name, files, seq_bytes, seq_ms,
+				rand_reads, rand_block_size, rand_ms, latency_avg_us, latency_p50_us, latency_p99_us, latency_max_us)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+			string(id), p.listenAddr, p.identity.Name, res.Files, res.SeqBytes, res.SeqTime.Milliseconds(),
+			res.RandReads, res.RandBlockSize, res.RandTime.Milliseconds(), res.LatencyAvg.Microseconds(),
+			res.LatencyP50.Microseconds(), res.LatencyP99.Microseconds(), res.LatencyMax.Microseconds())
+		if err != nil {
+			return storiface.PathBench{}, xerrors.Errorf("recording bench result: %w", err)
+		}
+	}
+
+	return res, nil
+}
+
+func (p *ProviderAPI) Config(ctx context.Context, format string) (string, error) {
+	return renderConfig(p.cfg.Redacted(), format)
+}
+
+func (p *ProviderAPI) MetricsSnapshot(ctx context.Context) (string, error) {
+	var buf bytes.Buffer
+	if err := metrics.WriteSnapshot(&buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
 // Trigger shutdown
 func (p *ProviderAPI) Shutdown(context.Context) error {
 	close(p.ShutdownChan)
@@ -465,3 +988,140 @@ func minerAddressesToStrings(maddrs []dtypes.MinerAddress) []string {
 	}
 	return strs
 }
+
+// checkSectorStorage reports the live sectors of the miners which can't be
+// found in the storage index, before their deadlines fail. It only fails
+// when strict is set.
+func checkSectorStorage(ctx context.Context, full api.FullNode, si paths.SectorIndex, maddrs []dtypes.MinerAddress, strict bool) error {
+	missing, err := lpwindow.CheckSectorStorage(ctx, full, si, maddrs)
+	if err != nil {
+		if strict {
+			return xerrors.Errorf("checking sector storage: %w", err)
+		}
+		log.Errorw("checking sector storage", "error", err)
+		return nil
+	}
+
+	var total int
+	for maddr, sectors := range missing {
+		total += len(sectors)
+		log.Warnw("live sectors not found in any storage path, they can't be proven", "miner", maddr, "count", len(sectors), "sectors", sectors)
+	}
+	if total == 0 {
+		log.Infow("all live sectors found in storage", "miners", len(maddrs))
+		return nil
+	}
+	if strict {
+		return xerrors.Errorf("%d live sectors not found in any storage path (see log for sector numbers; start without --strict-sector-storage to ignore)", total)
+	}
+	return nil
+}
+
+// messageSigner returns the signer used for outgoing messages: the full node
+// wallet, or an external signer when one is configured.
+func messageSigner(ctx context.Context, cfg config.ExternalSignerConfig, full api.FullNode) (lpmessage.SignerAPI, error) {
+	if cfg.ApiInfo == "" {
+		return full, nil
+	}
+
+	ai := cliutil.ParseApiInfo(cfg.ApiInfo)
+	url, err := ai.DialArgs("v0")
+	if err != nil {
+		return nil, xerrors.Errorf("parsing external signer api info: %w", err)
+	}
+
+	wapi, closer, err := client.NewWalletRPCV0(ctx, url, ai.AuthHeader())
+	if err != nil {
+		return nil, xerrors.Errorf("connecting to external signer: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		closer()
+	}()
+
+	var addrs []address.Address
+	for _, s := range cfg.Addresses {
+		a, err := address.NewFromString(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing external signer address %q: %w", s, err)
+		}
+
+		// messages are signed with key addresses
+		ka, err := full.StateAccountKey(ctx, a, types.EmptyTSK)
+		if err != nil {
+			return nil, xerrors.Errorf("resolving external signer address %s: %w", a, err)
+		}
+		addrs = append(addrs, ka)
+	}
+
+	return lpmessage.NewRoutingSigner(&lpmessage.WalletSigner{Wallet: wapi}, full, addrs), nil
+}
+
+// remoteSnarkExec returns the executor of the local worker, offloading the
+// snarks of the proof types with a remote prover configured.
+func remoteSnarkExec(ctx context.Context, cfg config.ProviderSubsystemsConfig) (sealer.ExecutorFunc, error) {
+	if cfg.RemoteWindowPostProver == "" && cfg.RemoteWinningPostProver == "" {
+		return nil, nil
+	}
+
+	connect := func(name, apiInfo string) (provider.SnarkProver, error) {
+		if apiInfo == "" {
+			return nil, nil
+		}
+
+		ai := cliutil.ParseApiInfo(apiInfo)
+		url, err := ai.DialArgs("v0")
+		if err != nil {
+			return nil, xerrors.Errorf("parsing %s api info: %w", name, err)
+		}
+
+		p, closer, err := provider.NewRemoteSnarkProver(ctx, url, ai.AuthHeader())
+		if err != nil {
+			return nil, xerrors.Errorf("connecting to %s: %w", name, err)
+		}
+		go func() {
+			<-ctx.Done()
+			closer()
+		}()
+		return p, nil
+	}
+
+	window, err := connect("RemoteWindowPostProver", cfg.RemoteWindowPostProver)
+	if err != nil {
+		return nil, err
+	}
+	winning, err := connect("RemoteWinningPostProver", cfg.RemoteWinningPostProver)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.RemoteSnarkExec(window, winning, time.Duration(cfg.RemoteWindowPostTimeout), time.Duration(cfg.RemoteWinningPostTimeout)), nil
+}
+
+// fetchClient builds the HTTP client used for requests to other storage nodes.
+func fetchClient(cfg config.LotusProviderStorageConfig) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = (&net.Dialer{
+		Timeout:   time.Duration(cfg.FetchDialTimeout),
+		KeepAlive: time.Duration(cfg.FetchKeepAlive),
+	}).DialContext
+	tr.ResponseHeaderTimeout = time.Duration(cfg.FetchResponseHeaderTimeout)
+	tr.IdleConnTimeout = time.Duration(cfg.FetchIdleConnTimeout)
+	tr.MaxIdleConns = cfg.FetchMaxIdleConns
+	tr.MaxIdleConnsPerHost = cfg.FetchMaxIdleConnsPerHost
+
+	// propagates the trace and request ID of fetches to the serving node
+	return &http.Client{Transport: &ochttp.Transport{Base: &rpc.RequestIDTransport{Base: tr}}}
+}
+
+func compressTypes(names []string) (storiface.SectorFileType, error) {
+	var ft storiface.SectorFileType
+	for _, t := range names {
+		typ, err := paths.FileTypeFromString(t)
+		if err != nil {
+			return 0, xerrors.Errorf("parsing FetchCompressTypes: %w", err)
+		}
+		ft |= typ
+	}
+	return ft, nil
+}
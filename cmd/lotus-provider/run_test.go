@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/provider/lpsync"
+)
+
+// catchingUpNode is a full node whose head is old for the first behind calls
+// of ChainHead.
+type catchingUpNode struct {
+	api.FullNodeStub
+
+	lk     sync.Mutex
+	behind int
+	calls  int
+}
+
+func (n *catchingUpNode) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	n.calls++
+	ts := time.Now()
+	if n.calls <= n.behind {
+		ts = ts.Add(-time.Hour)
+	}
+
+	b := mock.MkBlock(nil, 1, uint64(n.calls))
+	b.Height = abi.ChainEpoch(n.calls)
+	b.Timestamp = uint64(ts.Unix())
+	return mock.TipSet(b), nil
+}
+
+func (n *catchingUpNode) SyncState(ctx context.Context) (*api.SyncState, error) {
+	return &api.SyncState{}, nil
+}
+
+func (n *catchingUpNode) headCalls() int {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	return n.calls
+}
+
+func testNodeContext(node api.FullNode) *cli.Context {
+	app := cli.NewApp()
+	app.Metadata = map[string]interface{}{"testnode-full": node}
+	return cli.NewContext(app, flag.NewFlagSet("test", flag.ContinueOnError), nil)
+}
+
+func TestConnectFullNodeWaitsForSync(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultLotusProvider()
+	scfg := lpsync.Config{MinInterval: time.Millisecond, MaxInterval: 4 * time.Millisecond}
+
+	// the node is only handed to the rest of the startup once in sync
+	node := &catchingUpNode{behind: 3}
+	full, closer, err := connectFullNode(ctx, testNodeContext(node), cfg, &scfg)
+	require.NoError(t, err)
+	closer()
+	require.Equal(t, api.FullNode(node), full)
+	require.Equal(t, 4, node.headCalls())
+
+	// with --nosync it is returned right away
+	node = &catchingUpNode{behind: 1 << 30}
+	_, closer, err = connectFullNode(ctx, testNodeContext(node), cfg, nil)
+	require.NoError(t, err)
+	closer()
+	require.Zero(t, node.headCalls())
+
+	// a node which doesn't catch up fails startup
+	scfg.Timeout = 50 * time.Millisecond
+	_, _, err = connectFullNode(ctx, testNodeContext(node), cfg, &scfg)
+	require.ErrorContains(t, err, "sync wait")
+	require.ErrorContains(t, err, "not in sync")
+}
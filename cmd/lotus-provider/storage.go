@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/api"
+	lcli "github.com/filecoin-project/lotus/cli"
+	cliutil "github.com/filecoin-project/lotus/cli/util"
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+var storageCmd = &cli.Command{
+	Name:  "storage",
+	Usage: "Manage the storage paths of a running node",
+	Subcommands: []*cli.Command{
+		storageReadOnlyCmd,
+		storageBenchCmd,
+		storageValidateCmd,
+	},
+}
+
+var storageReadOnlyCmd = &cli.Command{
+	Name:  "read-only",
+	Usage: "Stop placing new files in a storage path of the node",
+	Description: `Marks a local storage path of the node as read-only, e.g. while migrating its data elsewhere.
+Sectors in a read-only path are still read locally and served to other nodes, but no new sector
+files are placed in it. The mode is saved in the sectorstore.json of the path and applies across
+the cluster right away, without a restart. Use --off to allow new files again.`,
+	ArgsUsage: "<path id>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "off",
+			Usage: "leave read-only mode",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		id := storiface.ID(cctx.Args().First())
+		readOnly := !cctx.Bool("off")
+
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		if err := papi.StorageSetReadOnly(lcli.ReqContext(cctx), id, readOnly); err != nil {
+			return err
+		}
+
+		if readOnly {
+			fmt.Printf("Storage path %s is now read-only\n", id)
+		} else {
+			fmt.Printf("Storage path %s accepts new files again\n", id)
+		}
+		return nil
+	},
+}
+
+var storageBenchCmd = &cli.Command{
+	Name:  "bench",
+	Usage: "Measure the read performance of a storage path of the node",
+	Description: `Reads the sealed and update files of a local storage path of the node, first sequentially and then
+in small blocks at random offsets like WindowPoSt challenges do, and prints the throughput, IOPS and
+read latencies. The benchmark only reads, but it competes with proving for the drive, so it refuses
+to run while proving tasks are pending unless --force is set, and it is aborted when the node claims
+a proving task while it runs. Reads of recently read files may be
+served from the page cache, which overstates the throughput. With --record the result is saved in
+the database, to compare paths and track a drive over time.`,
+	ArgsUsage: "<path id>",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "duration of each of the sequential and random read phases",
+			Value: 10 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "block-size",
+			Usage: "size of the random reads",
+			Value: "4KiB",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "run even when proving tasks are pending",
+		},
+		&cli.BoolFlag{
+			Name:  "record",
+			Usage: "save the result in the database",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		id := storiface.ID(cctx.Args().First())
+
+		blockSize, err := units.RAMInBytes(cctx.String("block-size"))
+		if err != nil {
+			return xerrors.Errorf("parsing block size: %w", err)
+		}
+		if blockSize <= 0 {
+			return xerrors.Errorf("block size must be positive")
+		}
+
+		papi, closer, err := cliutil.GetProviderAPI(cctx)
+		if err != nil {
+			return err
+		}
+		defer closer()
+
+		res, err := papi.StorageBench(lcli.ReqContext(cctx), id, api.StorageBenchOpts{
+			Duration:  cctx.Duration("duration"),
+			BlockSize: blockSize,
+			Force:     cctx.Bool("force"),
+			Record:    cctx.Bool("record"),
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Storage path %s, %d files\n", res.ID, res.Files)
+		fmt.Printf("Sequential: %s in %s, %s/s\n", units.BytesSize(float64(res.SeqBytes)), res.SeqTime.Round(time.Millisecond), units.BytesSize(res.SeqThroughput()))
+		fmt.Printf("Random:     %d reads of %s in %s, %.0f IOPS\n", res.RandReads, units.BytesSize(float64(res.RandBlockSize)), res.RandTime.Round(time.Millisecond), res.RandIOPS())
+		fmt.Printf("Latency:    avg %s, p50 %s, p99 %s, max %s\n", res.LatencyAvg, res.LatencyP50, res.LatencyP99, res.LatencyMax)
+		if cctx.Bool("record") {
+			fmt.Println("Result recorded")
+		}
+		return nil
+	},
+}
+
+var storageValidateCmd = &cli.Command{
+	Name:  "validate",
+	Usage: "Check a storage config file without starting the node",
+	Description: `Parses the storage.json, and checks that each of its paths exists and is accessible, has a valid
+sectorstore.json, and that no path ID is used twice. All problems are listed, and the command exits
+with an error if there are any, so it can be run before deploying a config change.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "storage-json",
+			Usage: "path to json file containing storage config",
+			Value: "~/.lotus-provider/storage.json",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		file, err := homedir.Expand(cctx.String("storage-json"))
+		if err != nil {
+			return xerrors.Errorf("expanding storage config path: %w", err)
+		}
+
+		npaths, problems := validateStorageConfig(file)
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			return xerrors.Errorf("%d problem(s) found in %s", len(problems), file)
+		}
+
+		fmt.Printf("%s: OK, %d path(s)\n", file, npaths)
+		return nil
+	},
+}
+
+// validateStorageConfig checks the storage config at file and the paths it
+// lists, returning the number of paths and the problems found.
+func validateStorageConfig(file string) (int, []string) {
+	// a missing file would silently be taken as a config without paths
+	if _, err := os.Stat(file); err != nil {
+		return 0, []string{fmt.Sprintf("%s: %s", file, err)}
+	}
+
+	cfg, err := config.StorageFromFile(file, nil)
+	if err != nil {
+		return 0, []string{fmt.Sprintf("%s: parsing storage config: %s", file, err)}
+	}
+
+	var problems []string
+	report := func(path, format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	seenPaths := map[string]struct{}{}
+	seenIDs := map[storiface.ID]string{}
+	for i, lp := range cfg.StoragePaths {
+		if lp.Path == "" {
+			report(file, "path %d is empty", i)
+			continue
+		}
+
+		p, err := filepath.Abs(lp.Path)
+		if err != nil {
+			report(lp.Path, "resolving path: %s", err)
+			continue
+		}
+		if _, ok := seenPaths[p]; ok {
+			report(lp.Path, "listed more than once")
+			continue
+		}
+		seenPaths[p] = struct{}{}
+
+		st, err := os.Stat(p)
+		if err != nil {
+			report(lp.Path, "%s", err)
+			continue
+		}
+		if !st.IsDir() {
+			report(lp.Path, "not a directory")
+			continue
+		}
+
+		mb, err := os.ReadFile(filepath.Join(p, paths.MetaFile))
+		if err != nil {
+			report(lp.Path, "reading %s: %s", paths.MetaFile, err)
+			continue
+		}
+		var meta storiface.LocalStorageMeta
+		if err := json.Unmarshal(mb, &meta); err != nil {
+			report(lp.Path, "parsing %s: %s", paths.MetaFile, err)
+			continue
+		}
+
+		// read-only paths don't get new files, but everything else writes
+		mode := uint32(unix.R_OK | unix.X_OK)
+		if !meta.ReadOnly {
+			mode |= unix.W_OK
+		}
+		if err := unix.Access(p, mode); err != nil {
+			report(lp.Path, "not accessible: %s", err)
+		}
+
+		if meta.ID == "" {
+			report(lp.Path, "%s has no ID", paths.MetaFile)
+		} else if other, ok := seenIDs[meta.ID]; ok {
+			report(lp.Path, "ID %s is also used by %s", meta.ID, other)
+		} else {
+			seenIDs[meta.ID] = lp.Path
+		}
+
+		for _, typ := range append(append([]string{}, meta.AllowTypes...), meta.DenyTypes...) {
+			if _, err := storiface.TypeFromString(typ); err != nil {
+				report(lp.Path, "bad file type %q in AllowTypes or DenyTypes", typ)
+			}
+		}
+		for typ := range meta.FileCosts {
+			if _, err := storiface.TypeFromString(typ); err != nil {
+				report(lp.Path, "bad file type %q in FileCosts", typ)
+			}
+		}
+	}
+
+	return len(cfg.StoragePaths), problems
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+
+	lcli "github.com/filecoin-project/lotus/cli"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
+)
+
+var tasksCmd = &cli.Command{
+	Name:  "tasks",
+	Usage: "Inspect the tasks of the cluster",
+	Subcommands: []*cli.Command{
+		tasksLogCmd,
+		tasksWhyCmd,
+		tasksReleaseCmd,
+	},
+}
+
+var tasksLogCmd = &cli.Command{
+	Name:  "log",
+	Usage: "Show the execution log of every run of a task, whichever node ran it",
+	Description: `Each run stores the events logged by the task, and how it ended, with its task history.
+Logs are capped in size, events past the cap are counted but not kept. A run in progress
+only shows once it ended.`,
+	ArgsUsage: "<task id>",
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		id, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing task id: %w", err)
+		}
+
+		ctx := context.Background()
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+		// a run which just ended may be missing on a lagging replica
+		rdb := db.ReadReplica()
+
+		var runs []struct {
+			Name      string    `db:"name"`
+			Host      string    `db:"completed_by_host_and_port"`
+			WorkStart time.Time `db:"work_start"`
+			WorkEnd   time.Time `db:"work_end"`
+			Result    bool      `db:"result"`
+			Err       *string   `db:"err"`
+			RunLog    *string   `db:"run_log"`
+		}
+		err = rdb.Select(ctx, &runs, `SELECT name, completed_by_host_and_port, work_start, work_end, result, err, run_log::text AS run_log
+			FROM harmony_task_history WHERE task_id = $1 ORDER BY work_start`, id)
+		if err != nil {
+			return xerrors.Errorf("reading task history: %w", err)
+		}
+
+		var running []struct {
+			Name string  `db:"name"`
+			Host *string `db:"host_and_port"`
+		}
+		err = rdb.Select(ctx, &running, `SELECT t.name, m.host_and_port FROM harmony_task t
+			LEFT JOIN harmony_machines m ON m.id = t.owner_id WHERE t.id = $1`, id)
+		if err != nil {
+			return xerrors.Errorf("reading task: %w", err)
+		}
+
+		if len(runs) == 0 && len(running) == 0 {
+			return xerrors.Errorf("task %d not found (its history may have been pruned)", id)
+		}
+
+		for i, r := range runs {
+			result := "done"
+			if !r.Result {
+				result = "failed"
+			}
+			fmt.Printf("Run %d: %s task %d on %s, %s, %s -> %s (%s)\n", i+1, r.Name, id, r.Host, result,
+				r.WorkStart.Format(time.DateTime), r.WorkEnd.Format(time.DateTime), r.WorkEnd.Sub(r.WorkStart).Round(time.Millisecond))
+
+			if r.RunLog == nil {
+				// runs from before run logs, or whose log couldn't be encoded
+				if r.Err != nil && *r.Err != "" {
+					fmt.Printf("  %s\n", *r.Err)
+				}
+				fmt.Println("  no run log recorded")
+				continue
+			}
+
+			var entries []harmonytask.RunLogEntry
+			if err := decodeJSON([]byte(*r.RunLog), &entries); err != nil {
+				return xerrors.Errorf("decoding run log: %w", err)
+			}
+			for _, e := range entries {
+				fmt.Printf("  %s %s%s\n", e.Time.Format("15:04:05.000"), e.Msg, formatRunLogFields(e.Fields))
+			}
+		}
+
+		for _, r := range running {
+			switch {
+			case r.Host != nil:
+				fmt.Printf("%s task %d is running on %s\n", r.Name, id, *r.Host)
+			default:
+				fmt.Printf("%s task %d is waiting to be claimed\n", r.Name, id)
+			}
+		}
+		return nil
+	},
+}
+
+var tasksWhyCmd = &cli.Command{
+	Name:  "why",
+	Usage: "Explain why a task isn't being executed",
+	Description: `Evaluates the conditions nodes claim tasks under for every node running the task
+type: heartbeat, draining, resources free next to the running tasks, the concurrency cap
+of the type and the weighted share backoff. Blocking reasons are listed per node. A node
+without any is listed as claimable, the task type's own checks may still refuse the task
+there, which the node logs.`,
+	ArgsUsage: "<task id>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as JSON",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		id, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing task id: %w", err)
+		}
+
+		ctx := context.Background()
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		reasons, err := harmonytask.Diagnose(ctx, db, harmonytask.TaskID(id))
+		if err != nil {
+			return err
+		}
+		wdReasons, err := lpwindow.DiagnoseTask(ctx, db, harmonytask.TaskID(id))
+		if err != nil {
+			return err
+		}
+		reasons = append(reasons, wdReasons...)
+
+		if cctx.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(reasons)
+		}
+
+		for _, r := range reasons {
+			kind := "note"
+			if r.Blocking {
+				kind = "blocking"
+			}
+			where := "cluster"
+			if r.Machine != "" {
+				where = r.Machine
+			}
+			fmt.Printf("%-8s %-16s %-20s %s\n", kind, r.Code, where, r.Detail)
+		}
+		return nil
+	},
+}
+
+var tasksReleaseCmd = &cli.Command{
+	Name:  "release",
+	Usage: "Clear the owner of a task stuck on a dead node, so that another node claims it",
+	Description: `Nodes release the tasks of nodes which stopped heartbeating on their own, once the owner
+has been gone for long enough. This releases a task right away, when its owner has missed its
+heartbeats for a few minutes or has left the cluster. Releasing a task of a node which is still
+heartbeating needs --force: the task may then run on two nodes at once, the old owner only notices
+when it next checks that it still owns the task. Releases are recorded with who ran them.`,
+	ArgsUsage: "<task id>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "release the task even though its owner is heartbeating",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		if cctx.NArg() != 1 {
+			return lcli.IncorrectNumArgs(cctx)
+		}
+		id, err := strconv.ParseInt(cctx.Args().First(), 10, 64)
+		if err != nil {
+			return xerrors.Errorf("parsing task id: %w", err)
+		}
+
+		ctx := context.Background()
+		db, err := makeDB(cctx)
+		if err != nil {
+			return err
+		}
+
+		rel, err := harmonytask.Release(ctx, db, harmonytask.TaskID(id), cctx.Bool("force"), operatorName())
+		if err != nil {
+			return err
+		}
+
+		contact := "it left the cluster"
+		if rel.OwnerContact > 0 {
+			contact = fmt.Sprintf("last heartbeat %s ago", rel.OwnerContact.Round(time.Second))
+		}
+		owner := rel.Owner
+		if rel.OwnerName != "" {
+			owner = rel.OwnerName + " at " + rel.Owner
+		}
+		fmt.Printf("Released %s task %d from %s (%s)\n", rel.Name, id, owner, contact)
+		if rel.Forced {
+			fmt.Println("The owner is still heartbeating, it may keep running the task until it checks ownership")
+		}
+		return nil
+	},
+}
+
+// operatorName identifies who runs a command in records of manual actions,
+// as user@host.
+func operatorName() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+func formatRunLogFields(fields map[string]any) string {
+	if len(fields) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		v := fields[k]
+		if s, ok := v.(string); ok && strings.ContainsAny(s, " \t\n\"") {
+			v = strconv.Quote(s)
+		}
+		fmt.Fprintf(&sb, " %s=%v", k, v)
+	}
+	return sb.String()
+}
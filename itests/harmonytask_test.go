@@ -2,10 +2,12 @@ package itests
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,6 +15,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/filecoin-project/lotus/itests/kit"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
@@ -264,3 +268,465 @@ func TestTaskRetry(t *testing.T) {
 			{2, false, "error: intentional 'error'"}}, res)
 	})
 }
+
+func TestTaskTerminalError(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		senderParty := fooLetterAdder(t, cdb)
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		sender, err := harmonytask.New(cdb, []harmonytask.TaskInterface{senderParty}, "test:1")
+		require.NoError(t, err)
+
+		var dest []string
+		failsA := &passthru{
+			dtl: dtl,
+			canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+				return &list[0], nil
+			},
+			do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+				var content string
+				err = cdb.QueryRow(context.Background(),
+					"SELECT content FROM itest_scratch WHERE some_int=$1", tID).Scan(&content)
+				require.NoError(t, err)
+				if content == "A" {
+					return false, harmonytask.Terminal(errors.New("bad proof"))
+				}
+				lettersMutex.Lock()
+				defer lettersMutex.Unlock()
+				dest = append(dest, content)
+				return true, nil
+			},
+		}
+		rcv, err := harmonytask.New(cdb, []harmonytask.TaskInterface{failsA}, "test:2")
+		require.NoError(t, err)
+		time.Sleep(time.Second)
+		sender.GracefullyTerminate(time.Hour)
+		rcv.GracefullyTerminate(time.Hour)
+		require.Equal(t, []string{"B"}, dest)
+
+		// the terminal failure was not retried, and the task is gone
+		type hist struct {
+			TaskID   int
+			Result   bool
+			ErrClass *string
+		}
+		var res []hist
+		require.NoError(t, cdb.Select(context.Background(), &res,
+			`SELECT task_id, result, err_class FROM harmony_task_history
+			 ORDER BY result DESC, task_id`))
+
+		terminal := string(harmonytask.ErrorTerminal)
+		require.Equal(t, []hist{
+			{2, true, nil},
+			{1, false, &terminal}}, res)
+
+		var left int
+		require.NoError(t, cdb.QueryRow(context.Background(), `SELECT COUNT(*) FROM harmony_task`).Scan(&left))
+		require.Zero(t, left)
+	})
+}
+
+func TestTaskPickupLatency(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		ctx := context.Background()
+
+		// a poll interval long enough that only wakeups can explain prompt pickup
+		harmonytask.POLL_DURATION = time.Minute
+		defer func() { harmonytask.POLL_DURATION = time.Millisecond * 100 }()
+
+		addNow := make(chan struct{})
+		started := make(chan string, 2)
+		party := &passthru{
+			dtl: dtl,
+			canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+				return &list[0], nil
+			},
+			adder: func(add harmonytask.AddTaskFunc) {
+				<-addNow
+				add(func(tID harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+					_, err := tx.Exec("INSERT INTO itest_scratch (some_int, content) VALUES ($1, 'A')", tID)
+					require.NoError(t, err)
+					return true, nil
+				})
+			},
+			do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+				var content string
+				err = cdb.QueryRow(context.Background(),
+					"SELECT content FROM itest_scratch WHERE some_int=$1", tID).Scan(&content)
+				require.NoError(t, err)
+				started <- content
+				return true, nil
+			},
+		}
+		e, err := harmonytask.New(cdb, []harmonytask.TaskInterface{party}, "test:1")
+		require.NoError(t, err)
+		defer e.GracefullyTerminate(time.Minute)
+
+		waitStart := func(expect string, within time.Duration) {
+			select {
+			case content := <-started:
+				require.Equal(t, expect, content)
+			case <-time.After(within):
+				t.Fatalf("task %s not picked up within %s", expect, within)
+			}
+		}
+
+		// added by this process: claimed right away, not on the next poll
+		close(addNow)
+		waitStart("A", 5*time.Second)
+
+		// added by another machine: claimed on the next poll
+		interval := 200 * time.Millisecond
+		require.NoError(t, e.SetPollInterval(interval))
+		time.Sleep(interval) // let the wakeup from SetPollInterval pass
+
+		_, err = cdb.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+			var tID int
+			if err := tx.QueryRow(`INSERT INTO harmony_task (name, posted_time, added_by)
+				VALUES ('foo', CURRENT_TIMESTAMP, 300) RETURNING id`).Scan(&tID); err != nil {
+				return false, err
+			}
+			_, err := tx.Exec("INSERT INTO itest_scratch (some_int, content) VALUES ($1, 'B')", tID)
+			return err == nil, err
+		})
+		require.NoError(t, err)
+		waitStart("B", interval+time.Second)
+	})
+}
+
+func TestTaskNotifyLatency(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+
+		interval := 2 * time.Second
+		harmonytask.POLL_DURATION = interval
+		defer func() { harmonytask.POLL_DURATION = time.Millisecond * 100 }()
+
+		addCh := make(chan string)
+		sender := fooLetterAdder(t, cdb)
+		sender.adder = func(add harmonytask.AddTaskFunc) {
+			for v := range addCh {
+				v := v
+				add(func(tID harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+					_, err := tx.Exec("INSERT INTO itest_scratch (some_int, content) VALUES ($1,$2)", tID, v)
+					require.NoError(t, err)
+					return true, nil
+				})
+			}
+		}
+		started := make(chan string, 2)
+		worker := &passthru{
+			dtl: dtl,
+			canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+				return &list[0], nil
+			},
+			do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+				var content string
+				err = cdb.QueryRow(context.Background(),
+					"SELECT content FROM itest_scratch WHERE some_int=$1", tID).Scan(&content)
+				require.NoError(t, err)
+				started <- content
+				return true, nil
+			},
+		}
+
+		se, err := harmonytask.New(cdb, []harmonytask.TaskInterface{sender}, "test:1")
+		require.NoError(t, err)
+		defer se.GracefullyTerminate(time.Minute)
+		we, err := harmonytask.New(cdb, []harmonytask.TaskInterface{worker}, "test:2")
+		require.NoError(t, err)
+		defer we.GracefullyTerminate(time.Minute)
+		defer close(addCh)
+
+		pickup := func(v string) time.Duration {
+			start := time.Now()
+			addCh <- v
+			select {
+			case content := <-started:
+				require.Equal(t, v, content)
+			case <-time.After(interval + 5*time.Second):
+				t.Fatalf("task %s not picked up", v)
+			}
+			return time.Since(start)
+		}
+
+		// polling only: the worker finds the task on its next poll
+		polled := pickup("A")
+		require.Less(t, polled, interval+time.Second)
+
+		if err := se.EnableNotify(); err != nil {
+			t.Skipf("database doesn't support notifications: %s", err)
+		}
+		require.NoError(t, we.EnableNotify())
+
+		notified := pickup("B")
+		t.Logf("pickup latency: polling %s, notify %s", polled, notified)
+		require.Less(t, notified, interval/4)
+	})
+}
+
+func TestTaskRunLog(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		harmonytask.RUN_LOG_MAX_BYTES = 2048
+		defer func() { harmonytask.RUN_LOG_MAX_BYTES = 16 << 10 }()
+
+		sender, err := harmonytask.New(cdb, []harmonytask.TaskInterface{fooLetterAdder(t, cdb)}, "test:1")
+		require.NoError(t, err)
+
+		var failed sync.Map
+		chatty := &passthru{
+			dtl: dtl,
+			canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+				return &list[0], nil
+			},
+			do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+				harmonytask.Logw(tID, "reading input", "task", tID)
+				if _, ok := failed.LoadOrStore(tID, true); !ok {
+					for i := 0; i < 100; i++ {
+						harmonytask.Logw(tID, "retrying", "attempt", i)
+					}
+					return false, errors.New("intentional 'error'")
+				}
+				return true, nil
+			},
+		}
+		rcv, err := harmonytask.New(cdb, []harmonytask.TaskInterface{chatty}, "test:2")
+		require.NoError(t, err)
+		time.Sleep(time.Second)
+		sender.GracefullyTerminate(time.Hour)
+		rcv.GracefullyTerminate(time.Hour)
+
+		var runs []struct {
+			Result bool
+			RunLog string `db:"run_log"`
+		}
+		require.NoError(t, cdb.Select(context.Background(), &runs,
+			`SELECT result, run_log::text AS run_log FROM harmony_task_history WHERE task_id = 1 ORDER BY id`))
+		require.Len(t, runs, 2)
+
+		msgs := func(runLog string) []string {
+			var entries []harmonytask.RunLogEntry
+			require.NoError(t, json.Unmarshal([]byte(runLog), &entries))
+			var out []string
+			for _, e := range entries {
+				out = append(out, e.Msg)
+			}
+			return out
+		}
+
+		// the failed run kept its first events, and how it ended
+		failedRun := msgs(runs[0].RunLog)
+		require.False(t, runs[0].Result)
+		require.Equal(t, []string{"claimed", "reading input", "retrying"}, failedRun[:3])
+		require.Regexp(t, `^log truncated, \d+ events dropped$`, failedRun[len(failedRun)-2])
+		require.Equal(t, "failed", failedRun[len(failedRun)-1])
+		require.Contains(t, runs[0].RunLog, "intentional 'error'")
+
+		require.True(t, runs[1].Result)
+		require.Equal(t, []string{"claimed", "reading input", "finished"}, msgs(runs[1].RunLog))
+	})
+}
+
+func TestTaskTooBigForAnyMachine(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		harmonytask.FIT_CHECK_FREQUENCY = 0
+		defer func() { harmonytask.FIT_CHECK_FREQUENCY = harmonytask.CLEANUP_FREQUENCY }()
+
+		var ran atomic.Int32
+		big := func(add bool) *passthru {
+			p := &passthru{
+				dtl: harmonytask.TaskTypeDetails{Name: "big", Max: -1, Cost: resources.Resources{Cpu: 1 << 20}},
+				canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+					return &list[0], nil
+				},
+				do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+					ran.Add(1)
+					return true, nil
+				},
+			}
+			if add {
+				p.adder = func(add harmonytask.AddTaskFunc) {
+					add(func(tID harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+						return true, nil
+					})
+				}
+			}
+			return p
+		}
+
+		al := alerting.NewAlertingSystem(journal.NilJournal())
+		small, err := harmonytask.New(cdb, []harmonytask.TaskInterface{big(true)}, "test:1")
+		require.NoError(t, err)
+		small.SetAlerting(al)
+
+		unfittable := func() bool {
+			for _, a := range al.GetAlerts() {
+				if a.Type.System == "harmonytask" && a.Type.Subsystem == "unfittable-big" {
+					return a.Active
+				}
+			}
+			return false
+		}
+		require.Eventually(t, unfittable, 5*time.Second, 50*time.Millisecond)
+		require.Zero(t, ran.Load())
+
+		// a machine declaring enough resources takes the task
+		large, err := harmonytask.New(cdb, []harmonytask.TaskInterface{big(false)}, "test:2")
+		require.NoError(t, err)
+		require.NoError(t, large.SetResources(context.Background(), resources.Resources{Cpu: 1 << 21}))
+
+		require.Eventually(t, func() bool { return ran.Load() == 1 }, 5*time.Second, 50*time.Millisecond)
+		require.Eventually(t, func() bool { return !unfittable() }, 5*time.Second, 50*time.Millisecond)
+
+		small.GracefullyTerminate(time.Minute)
+		large.GracefullyTerminate(time.Minute)
+	})
+}
+
+func TestTaskClusterMax(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+
+		const tasks, clusterMax = 8, 2
+		var running, peak, done atomic.Int32
+		capped := func(add bool) *passthru {
+			p := &passthru{
+				// each machine alone could run all of the tasks at once
+				dtl: harmonytask.TaskTypeDetails{Name: "capped", Max: tasks, ClusterMax: clusterMax, Cost: resources.Resources{}},
+				canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+					return &list[0], nil
+				},
+				do: func(tID harmonytask.TaskID, stillOwned func() bool) (bool, error) {
+					n := running.Add(1)
+					for {
+						p := peak.Load()
+						if n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					time.Sleep(300 * time.Millisecond)
+					running.Add(-1)
+					done.Add(1)
+					return true, nil
+				},
+			}
+			if add {
+				p.adder = func(add harmonytask.AddTaskFunc) {
+					for i := 0; i < tasks; i++ {
+						add(func(tID harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+							return true, nil
+						})
+					}
+				}
+			}
+			return p
+		}
+
+		var engines []*harmonytask.TaskEngine
+		for i := 0; i < 3; i++ {
+			e, err := harmonytask.New(cdb, []harmonytask.TaskInterface{capped(i == 0)}, fmt.Sprintf("test:%d", i+1))
+			require.NoError(t, err)
+			engines = append(engines, e)
+		}
+
+		require.Eventually(t, func() bool { return done.Load() == tasks }, 30*time.Second, 50*time.Millisecond)
+		for _, e := range engines {
+			e.GracefullyTerminate(time.Minute)
+		}
+		require.LessOrEqual(t, peak.Load(), int32(clusterMax))
+		require.Equal(t, int32(clusterMax), peak.Load(), "the cap should still let the cluster run tasks in parallel")
+	})
+}
+
+func TestTaskAddOnly(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		harmonytask.FIT_CHECK_FREQUENCY = 0
+		defer func() { harmonytask.FIT_CHECK_FREQUENCY = harmonytask.CLEANUP_FREQUENCY }()
+
+		var ranOnAdder, ranOnWorker atomic.Int32
+		task := func(ran *atomic.Int32, add bool) *passthru {
+			p := &passthru{
+				dtl: harmonytask.TaskTypeDetails{Name: "split", Max: -1, Cost: resources.Resources{}},
+				canAccept: func(list []harmonytask.TaskID, e *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+					return &list[0], nil
+				},
+				do: func(tID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+					ran.Add(1)
+					return true, nil
+				},
+			}
+			if add {
+				p.adder = func(add harmonytask.AddTaskFunc) {
+					add(func(tID harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+						return true, nil
+					})
+				}
+			}
+			return p
+		}
+
+		al := alerting.NewAlertingSystem(journal.NilJournal())
+		adder, err := harmonytask.New(cdb, []harmonytask.TaskInterface{harmonytask.AddOnly(task(&ranOnAdder, true))}, "test:1")
+		require.NoError(t, err)
+		adder.SetAlerting(al)
+
+		uncovered := func() bool {
+			for _, a := range al.GetAlerts() {
+				if a.Type.System == "harmonytask" && a.Type.Subsystem == "uncovered-split" {
+					return a.Active
+				}
+			}
+			return false
+		}
+		require.Eventually(t, uncovered, 5*time.Second, 50*time.Millisecond)
+
+		worker, err := harmonytask.New(cdb, []harmonytask.TaskInterface{task(&ranOnWorker, false)}, "test:2")
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return ranOnWorker.Load() == 1 }, 5*time.Second, 50*time.Millisecond)
+		require.Eventually(t, func() bool { return !uncovered() }, 5*time.Second, 50*time.Millisecond)
+		require.Zero(t, ranOnAdder.Load())
+
+		adder.GracefullyTerminate(time.Minute)
+		worker.GracefullyTerminate(time.Minute)
+	})
+}
+
+func TestMachineLoadsOnlyRunners(t *testing.T) {
+	//t.Parallel()
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		ctx := context.Background()
+
+		// 400 runs the type, 401 only seals, 402 is dead and 403 is draining
+		_, err := cdb.Exec(ctx, `INSERT INTO harmony_machines (id, last_contact, host_and_port, cpu, ram, gpu, weight, draining) VALUES
+			(400, CURRENT_TIMESTAMP, 'test:400', 4, 400000, 1, 2, FALSE),
+			(401, CURRENT_TIMESTAMP, 'test:401', 4, 400000, 1, 1, FALSE),
+			(402, DATE '2000-01-01', 'test:402', 4, 400000, 1, 1, FALSE),
+			(403, CURRENT_TIMESTAMP, 'test:403', 4, 400000, 1, 1, TRUE)`)
+		require.NoError(t, err)
+		_, err = cdb.Exec(ctx, `INSERT INTO harmony_task_impl (owner_id, name) VALUES (400, 'weighted'), (401, 'sealing'), (402, 'weighted'), (403, 'weighted')`)
+		require.NoError(t, err)
+		_, err = cdb.Exec(ctx, `INSERT INTO harmony_task (id, name, owner_id, posted_time, added_by) VALUES (4000, 'weighted', 400, CURRENT_TIMESTAMP, 400)`)
+		require.NoError(t, err)
+
+		loads, err := harmonytask.NewPostgresStore(cdb).MachineLoads(ctx, "weighted", time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []harmonytask.MachineLoad{{ID: 400, Weight: 2, Count: 1}}, loads)
+	})
+}
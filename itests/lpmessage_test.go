@@ -0,0 +1,171 @@
+package itests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/itests/kit"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/node/impl"
+	"github.com/filecoin-project/lotus/provider/lpmessage"
+)
+
+// fakeSenderAPI accepts every message and counts mpool pushes.
+type fakeSenderAPI struct {
+	pushes atomic.Int64
+}
+
+func (f *fakeSenderAPI) StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func (f *fakeSenderAPI) GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error) {
+	msg.GasLimit = 1000
+	msg.GasFeeCap = big.NewInt(100)
+	msg.GasPremium = big.NewInt(10)
+	return msg, nil
+}
+
+func (f *fakeSenderAPI) WalletBalance(ctx context.Context, addr address.Address) (big.Int, error) {
+	return types.FromFil(1000), nil
+}
+
+func (f *fakeSenderAPI) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return 0, nil
+}
+
+func (f *fakeSenderAPI) MpoolPush(ctx context.Context, sm *types.SignedMessage) (cid.Cid, error) {
+	f.pushes.Add(1)
+	return sm.Cid(), nil
+}
+
+func (f *fakeSenderAPI) StateWaitMsg(ctx context.Context, msg cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error) {
+	return &api.MsgLookup{
+		Message: msg,
+		Receipt: types.MessageReceipt{ExitCode: exitcode.Ok, GasUsed: 1000},
+		TipSet:  types.NewTipSetKey(msg),
+		Height:  10,
+	}, nil
+}
+
+func (f *fakeSenderAPI) WalletSignMessage(ctx context.Context, from address.Address, msg *types.Message) (*types.SignedMessage, error) {
+	return &types.SignedMessage{
+		Message:   *msg,
+		Signature: crypto.Signature{Type: crypto.SigTypeSecp256k1, Data: make([]byte, 65)},
+	}, nil
+}
+
+func TestSenderIdempotencyKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+
+		fapi := &fakeSenderAPI{}
+		sender, sendTask := lpmessage.NewSender(ctx, fapi, fapi, cdb)
+
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		e, err := harmonytask.New(cdb, []harmonytask.TaskInterface{sendTask}, "test:1")
+		require.NoError(t, err)
+		defer e.GracefullyTerminate(time.Minute)
+
+		from, err := address.NewSecp256k1Address([]byte("idempotency-test-sender"))
+		require.NoError(t, err)
+		to, err := address.NewIDAddress(1000)
+		require.NoError(t, err)
+
+		mkMsg := func() *types.Message {
+			return &types.Message{
+				From:  from,
+				To:    to,
+				Value: big.Zero(),
+			}
+		}
+
+		key := lpmessage.IdempotencyKey(42, "test")
+
+		c1, err := sender.Send(ctx, key, mkMsg(), &api.MessageSendSpec{}, "test")
+		require.NoError(t, err)
+
+		// a retried send with the same key returns the original message
+		c2, err := sender.Send(ctx, key, mkMsg(), &api.MessageSendSpec{}, "test")
+		require.NoError(t, err)
+		require.Equal(t, c1, c2)
+		require.EqualValues(t, 1, fapi.pushes.Load())
+
+		// a different key sends a new message
+		c3, err := sender.Send(ctx, lpmessage.IdempotencyKey(43, "test"), mkMsg(), &api.MessageSendSpec{}, "test")
+		require.NoError(t, err)
+		require.NotEqual(t, c1, c3)
+		require.EqualValues(t, 2, fapi.pushes.Load())
+	})
+}
+
+func TestSenderWaitStrategies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+
+		fapi := &fakeSenderAPI{}
+		sender, sendTask := lpmessage.NewSender(ctx, fapi, fapi, cdb)
+
+		harmonytask.POLL_DURATION = time.Millisecond * 100
+		e, err := harmonytask.New(cdb, []harmonytask.TaskInterface{sendTask}, "test:1")
+		require.NoError(t, err)
+		defer e.GracefullyTerminate(time.Minute)
+
+		from, err := address.NewSecp256k1Address([]byte("wait-strategy-test-sender"))
+		require.NoError(t, err)
+		to, err := address.NewIDAddress(1000)
+		require.NoError(t, err)
+
+		mkMsg := func() *types.Message {
+			return &types.Message{
+				From:  from,
+				To:    to,
+				Value: big.Zero(),
+			}
+		}
+
+		type wait struct {
+			Epoch    *int64 `db:"executed_tsk_epoch"`
+			ExitCode *int64 `db:"executed_rcpt_exitcode"`
+		}
+		getWait := func(c cid.Cid) wait {
+			var waits []wait
+			require.NoError(t, cdb.Select(ctx, &waits, `SELECT executed_tsk_epoch, executed_rcpt_exitcode FROM message_waits WHERE signed_message_cid = $1`, c.String()))
+			require.Len(t, waits, 1)
+			return waits[0]
+		}
+
+		// Send returns with the message in the mempool, left for the watcher
+		c1, err := sender.Send(ctx, lpmessage.IdempotencyKey(50, "test"), mkMsg(), &api.MessageSendSpec{}, "test")
+		require.NoError(t, err)
+		require.Nil(t, getWait(c1).Epoch)
+
+		// SendAndWait returns with the message executed, and records it
+		c2, lookup, err := sender.SendAndWait(ctx, lpmessage.IdempotencyKey(51, "test"), mkMsg(), &api.MessageSendSpec{}, "test")
+		require.NoError(t, err)
+		require.Equal(t, c2, lookup.Message)
+		w := getWait(c2)
+		require.NotNil(t, w.Epoch)
+		require.EqualValues(t, 10, *w.Epoch)
+		require.EqualValues(t, 0, *w.ExitCode)
+	})
+}
@@ -0,0 +1,70 @@
+package itests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/itests/kit"
+	"github.com/filecoin-project/lotus/node/impl"
+	"github.com/filecoin-project/lotus/provider/lpwindow"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// slowVanillaStore stands in for the challenged reads of sector files
+type slowVanillaStore struct {
+	paths.Store
+	latency time.Duration
+	reads   int
+}
+
+func (s *slowVanillaStore) GenerateSingleVanillaProof(ctx context.Context, minerID abi.ActorID, si storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
+	s.reads++
+	time.Sleep(s.latency)
+	return make([]byte, 20<<10), nil
+}
+
+func TestWdPostVanillaCache(t *testing.T) {
+	withDbSetup(t, func(m *kit.TestMiner) {
+		cdb := m.BaseAPI.(*impl.StorageMinerAPI).HarmonyDB
+		ctx := context.Background()
+
+		stor := &slowVanillaStore{latency: 50 * time.Millisecond}
+		c := lpwindow.NewVanillaCache(cdb, stor, time.Minute)
+
+		const sectors = 20
+		prove := func(challenge uint64) time.Duration {
+			start := time.Now()
+			for i := 0; i < sectors; i++ {
+				_, err := c.GenerateSingleVanillaProof(ctx, 1000, storiface.PostSectorChallenge{
+					SealProof:    abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+					SectorNumber: abi.SectorNumber(i),
+					Challenge:    []uint64{challenge},
+				}, abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1)
+				require.NoError(t, err)
+			}
+			return time.Since(start)
+		}
+
+		first := prove(1)
+		require.Equal(t, sectors, stor.reads)
+
+		recompute := prove(1)
+		require.Equal(t, sectors, stor.reads, "recompute for the same challenge read storage")
+		t.Logf("vanilla proofs of %d sectors: %s from storage, %s cached (%.1fx)", sectors, first, recompute, float64(first)/float64(recompute))
+		require.Less(t, recompute, first)
+
+		// new randomness invalidates the cached proofs
+		prove(2)
+		require.Equal(t, 2*sectors, stor.reads)
+
+		var cached int
+		require.NoError(t, cdb.QueryRow(ctx, `SELECT COUNT(*) FROM wdpost_vanilla_cache WHERE sp_id = 1000`).Scan(&cached))
+		require.Equal(t, sectors, cached)
+	})
+}
@@ -24,6 +24,7 @@ type fsJournal struct {
 
 	dir       string
 	sizeLimit int64
+	node      string
 
 	fi    *os.File
 	fSize int64
@@ -41,6 +42,13 @@ func OpenFSJournal(lr repo.LockedRepo, disabled journal.DisabledEvents) (journal
 }
 
 func OpenFSJournalPath(path string, disabled journal.DisabledEvents) (journal.Journal, error) {
+	return OpenFSJournalPathNode(path, disabled, "")
+}
+
+// OpenFSJournalPathNode is OpenFSJournalPath with the events recorded as
+// coming from the named node, for journals of nodes in a cluster which are
+// collected together.
+func OpenFSJournalPathNode(path string, disabled journal.DisabledEvents, node string) (journal.Journal, error) {
 	dir := filepath.Join(path, "journal")
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to mk directory %s for file journal: %w", dir, err)
@@ -50,6 +58,7 @@ func OpenFSJournalPath(path string, disabled journal.DisabledEvents) (journal.Jo
 		EventTypeRegistry: journal.NewEventTypeRegistry(disabled),
 		dir:               dir,
 		sizeLimit:         1 << 30,
+		node:              node,
 		incoming:          make(chan *journal.Event, 32),
 		closing:           make(chan struct{}),
 		closed:            make(chan struct{}),
@@ -78,6 +87,7 @@ func (f *fsJournal) RecordEvent(evtType journal.EventType, supplier func() inter
 	je := &journal.Event{
 		EventType: evtType,
 		Timestamp: build.Clock.Now(),
+		Node:      f.node,
 		Data:      supplier(),
 	}
 	select {
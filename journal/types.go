@@ -99,5 +99,8 @@ type Event struct {
 	EventType
 
 	Timestamp time.Time
-	Data      interface{}
+	// Node is the node recording the event, set by journals of nodes which
+	// run in a cluster
+	Node string `json:",omitempty"`
+	Data interface{}
 }
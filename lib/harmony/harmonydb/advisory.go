@@ -0,0 +1,62 @@
+package harmonydb
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/xerrors"
+)
+
+// LockSession holds session-level advisory locks (pg_try_advisory_lock). It
+// owns a connection taken out of the pool, as the locks belong to the
+// session which took them: they are released when the session ends, be it
+// through Close or because the connection failed. Holders must check the
+// session with Ping before acting on a lock, a failed Ping means that other
+// sessions may have taken the locks since.
+//
+// A LockSession isn't safe for concurrent use.
+type LockSession struct {
+	conn *pgx.Conn
+}
+
+// LockSession takes a connection out of the pool for advisory locks.
+func (db *DB) LockSession(ctx context.Context) (*LockSession, error) {
+	pc, err := db.pgx.Acquire(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("acquiring connection: %w", err)
+	}
+	return &LockSession{conn: pc.Hijack()}, nil
+}
+
+// TryLock takes the advisory lock key without waiting, returning false when
+// another session holds it. Taking a lock the session already holds
+// succeeds, and stacks: it must be unlocked as many times.
+func (s *LockSession) TryLock(ctx context.Context, key int64) (bool, error) {
+	var ok bool
+	if err := s.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		return false, xerrors.Errorf("taking advisory lock: %w", err)
+	}
+	return ok, nil
+}
+
+// Unlock releases the advisory lock key held by the session.
+func (s *LockSession) Unlock(ctx context.Context, key int64) error {
+	var ok bool
+	if err := s.conn.QueryRow(ctx, `SELECT pg_advisory_unlock($1)`, key).Scan(&ok); err != nil {
+		return xerrors.Errorf("releasing advisory lock: %w", err)
+	}
+	if !ok {
+		return xerrors.Errorf("advisory lock %d isn't held by the session", key)
+	}
+	return nil
+}
+
+// Ping checks that the session, and so its locks, are still alive.
+func (s *LockSession) Ping(ctx context.Context) error {
+	return s.conn.Ping(ctx)
+}
+
+// Close ends the session, releasing all of its locks.
+func (s *LockSession) Close() error {
+	return s.conn.Close(context.Background())
+}
@@ -4,6 +4,7 @@
 # Features
 
 	Rolling to secondary database servers on connection failure
+	Sending reads which tolerate stale data to read replicas
 	Convenience features for Go + SQL
 	Prevention of SQL injection vulnerabilities
 	Monitors behavior via Prometheus stats and logging of errors.
@@ -31,5 +32,23 @@ Write SQL with context, raw strings, and args:
 	fmt.Println(ID)
 
 Note: Scan() is column-oriented, while Select() & StructScan() is field name/tag oriented.
+
+# Read replicas
+
+With HarmonyDB.ReadReplicas configured, ReadReplica() returns a Reader querying the replicas.
+Replicas apply the primary's changes with a lag, usually under a second and more under load,
+so only queries which tolerate reading data that old may use it. Per query type:
+
+	Task claims, heartbeats, task creation and completion, and any write: primary only.
+	Reads deciding a write or an action (does the task still exist, is a lease held, are
+	proving tasks pending): primary only, a stale answer leads to a wrong decision.
+	Cluster and node listings, task listings: replica. A task claimed or a node joined in
+	the last moments may be missing or show its previous state.
+	Task history, run logs and proving history: replica. The latest runs and proofs may
+	be missing until the replica catches up.
+	Diagnostics (tasks why, release): primary, they reason about the current claim state.
+
+When a replica can't be reached, its reads go to the primary instead, and the replica is
+skipped for a while. Errors of the statement itself are returned as they are.
 */
 package harmonydb
@@ -33,6 +33,9 @@ type DB struct {
 	cfg       *pgxpool.Config
 	schema    string
 	hostnames []string
+
+	// replicas serves ReadReplica, nil without read replicas
+	replicas *replicaSet
 }
 
 var logger = logging.Logger("harmonydb")
@@ -42,26 +45,12 @@ var logger = logging.Logger("harmonydb")
 //
 //	db, err := NewFromConfig(config.HarmonyDB)  // in binary init
 func NewFromConfig(cfg config.HarmonyDB) (*DB, error) {
-	return New(
-		cfg.Hosts,
-		cfg.Username,
-		cfg.Password,
-		cfg.Database,
-		cfg.Port,
-		"",
-	)
+	return newDB(cfg, "")
 }
 
 func NewFromConfigWithITestID(cfg config.HarmonyDB) func(id ITestID) (*DB, error) {
 	return func(id ITestID) (*DB, error) {
-		return New(
-			cfg.Hosts,
-			cfg.Username,
-			cfg.Password,
-			cfg.Database,
-			cfg.Port,
-			id,
-		)
+		return newDB(cfg, id)
 	}
 }
 
@@ -69,16 +58,23 @@ func NewFromConfigWithITestID(cfg config.HarmonyDB) func(id ITestID) (*DB, error
 // log() is for errors. It returns an upgraded database's connection.
 // This entry point serves both production and integration tests, so it's more DI.
 func New(hosts []string, username, password, database, port string, itestID ITestID) (*DB, error) {
+	return newDB(config.HarmonyDB{
+		Hosts:    hosts,
+		Username: username,
+		Password: password,
+		Database: database,
+		Port:     port,
+	}, itestID)
+}
+
+func newDB(dbCfg config.HarmonyDB, itestID ITestID) (*DB, error) {
 	itest := string(itestID)
-	connString := ""
+	hosts := dbCfg.Hosts
+	host := ""
 	if len(hosts) > 0 {
-		connString = "host=" + hosts[0] + " "
-	}
-	for k, v := range map[string]string{"user": username, "password": password, "dbname": database, "port": port} {
-		if strings.TrimSpace(v) != "" {
-			connString += k + "=" + v + " "
-		}
+		host = hosts[0]
 	}
+	connString := makeConnString(host, dbCfg)
 
 	schema := "lotus"
 	if itest != "" {
@@ -108,7 +104,29 @@ func New(hosts []string, username, password, database, port string, itestID ITes
 		return nil, err
 	}
 
-	return &db, db.upgrade()
+	if err := db.upgrade(); err != nil {
+		return &db, err
+	}
+
+	if err := db.addReplicas(dbCfg); err != nil {
+		return &db, err
+	}
+	return &db, nil
+}
+
+// makeConnString builds the connection string of host, which is left out
+// when empty.
+func makeConnString(host string, cfg config.HarmonyDB) string {
+	connString := ""
+	if host != "" {
+		connString = "host=" + host + " "
+	}
+	for k, v := range map[string]string{"user": cfg.Username, "password": cfg.Password, "dbname": cfg.Database, "port": cfg.Port} {
+		if strings.TrimSpace(v) != "" {
+			connString += k + "=" + v + " "
+		}
+	}
+	return connString
 }
 
 type tracer struct {
@@ -0,0 +1,87 @@
+package harmonydb
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/xerrors"
+)
+
+// listenRetry is how long Listen waits before re-subscribing after its
+// connection failed.
+var listenRetry = 5 * time.Second
+
+// Notify sends payload to the listeners of channel when the transaction it
+// runs in commits, right away outside of one.
+func (db *DB) Notify(ctx context.Context, channel, payload string) error {
+	_, err := db.Exec(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	return err
+}
+
+// Listen subscribes to the notifications sent on channel, and calls cb with
+// the payload of each from a separate goroutine, until ctx is done. The
+// subscription holds a connection of its own. It fails right away when the
+// first subscription can't be made, e.g. on databases without LISTEN
+// support such as YugabyteDB. After a connection error it re-subscribes;
+// notifications sent in between are lost, so listeners must not rely on
+// seeing every one of them.
+func (db *DB) Listen(ctx context.Context, channel string, cb func(payload string)) error {
+	conn, err := db.listenConn(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			err := waitNotifications(ctx, conn, cb)
+			_ = conn.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warnw("listen connection failed, re-subscribing", "channel", channel, "error", err)
+
+			for {
+				select {
+				case <-time.After(listenRetry):
+				case <-ctx.Done():
+					return
+				}
+
+				conn, err = db.listenConn(ctx, channel)
+				if err == nil {
+					break
+				}
+				logger.Warnw("re-subscribing to notifications", "channel", channel, "error", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// listenConn takes a connection out of the pool and subscribes it to
+// channel.
+func (db *DB) listenConn(ctx context.Context, channel string) (*pgx.Conn, error) {
+	pc, err := db.pgx.Acquire(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("acquiring connection: %w", err)
+	}
+	conn := pc.Hijack()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, xerrors.Errorf("listening on %s: %w", channel, err)
+	}
+	return conn, nil
+}
+
+func waitNotifications(ctx context.Context, conn *pgx.Conn, cb func(payload string)) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		cb(n.Payload)
+	}
+}
@@ -18,12 +18,14 @@ var (
 
 // DBMeasures groups all db metrics.
 var DBMeasures = struct {
-	Hits            *stats.Int64Measure
-	TotalWait       *stats.Int64Measure
-	Waits           prometheus.Histogram
-	OpenConnections *stats.Int64Measure
-	Errors          *stats.Int64Measure
-	WhichHost       prometheus.Histogram
+	Hits             *stats.Int64Measure
+	TotalWait        *stats.Int64Measure
+	Waits            prometheus.Histogram
+	OpenConnections  *stats.Int64Measure
+	Errors           *stats.Int64Measure
+	WhichHost        prometheus.Histogram
+	ReplicaReads     *stats.Int64Measure
+	ReplicaFallbacks *stats.Int64Measure
 }{
 	Hits:      stats.Int64(pre+"hits", "Total number of uses.", stats.UnitDimensionless),
 	TotalWait: stats.Int64(pre+"total_wait", "Total delay. A numerator over hits to get average wait.", stats.UnitMilliseconds),
@@ -39,6 +41,8 @@ var DBMeasures = struct {
 		Buckets: whichHostBuckets,
 		Help:    "The index of the hostname being used",
 	}),
+	ReplicaReads:     stats.Int64(pre+"replica_reads", "Total reads served by read replicas.", stats.UnitDimensionless),
+	ReplicaFallbacks: stats.Int64(pre+"replica_fallbacks", "Total reads sent to the primary as a read replica was unreachable.", stats.UnitDimensionless),
 }
 
 // CacheViews groups all cache-related default views.
@@ -64,6 +68,16 @@ func init() {
 			Aggregation: view.Sum(),
 			TagKeys:     []tag.Key{dbTag},
 		},
+		&view.View{
+			Measure:     DBMeasures.ReplicaReads,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{dbTag},
+		},
+		&view.View{
+			Measure:     DBMeasures.ReplicaFallbacks,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{dbTag},
+		},
 	)
 	err := prometheus.Register(DBMeasures.Waits)
 	if err != nil {
@@ -0,0 +1,403 @@
+package harmonydb
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/xerrors"
+)
+
+// Interface is the subset of *DB used by task implementations. Tasks which
+// depend on it instead of *DB can have their logic tested against a Mock,
+// without a running Postgres.
+type Interface interface {
+	Exec(ctx context.Context, sql rawStringOnly, arguments ...any) (count int, err error)
+	Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*Query, error)
+	QueryRow(ctx context.Context, sql rawStringOnly, arguments ...any) Row
+	Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error
+	BeginTransaction(ctx context.Context, f func(*Tx) (commit bool, err error)) (didCommit bool, retErr error)
+	ReadReplica() Reader
+}
+
+var _ Interface = &DB{}
+var _ Interface = &Mock{}
+
+// MockAnyArg matches any argument value in MockExpect.WithArgs.
+var MockAnyArg = &struct{ anyArg bool }{true}
+
+// Mock is an Interface implementation for unit tests. Statements must be
+// executed in the order they were expected, and are answered with the
+// results configured on their expectation. Statements inside of
+// BeginTransaction consume expectations the same way; the mock keeps no
+// state, so nothing is rolled back when a transaction isn't committed.
+// Reads made through ReadReplica only match expectations marked OnReplica,
+// and other statements only match expectations which aren't.
+//
+// StructScan isn't supported on rows returned by the mock, use Select instead.
+type Mock struct {
+	lk       sync.Mutex
+	expected []*MockExpect
+	err      error
+}
+
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+type mockKind string
+
+const (
+	mockExec     mockKind = "Exec"
+	mockQuery    mockKind = "Query"
+	mockQueryRow mockKind = "QueryRow"
+	mockSelect   mockKind = "Select"
+)
+
+// MockExpect is an expected statement, see the Mock.Expect* methods.
+type MockExpect struct {
+	kind mockKind
+	sql  string
+	args []any
+
+	// replica is set for reads expected through ReadReplica
+	replica bool
+
+	count  int
+	rows   [][]any
+	result any
+	err    error
+}
+
+// ExpectExec expects an Exec of a statement containing sql (whitespace is
+// ignored when comparing).
+func (m *Mock) ExpectExec(sql string) *MockExpect {
+	return m.expect(mockExec, sql)
+}
+
+// ExpectQuery expects a Query of a statement containing sql.
+func (m *Mock) ExpectQuery(sql string) *MockExpect {
+	return m.expect(mockQuery, sql)
+}
+
+// ExpectQueryRow expects a QueryRow of a statement containing sql.
+func (m *Mock) ExpectQueryRow(sql string) *MockExpect {
+	return m.expect(mockQueryRow, sql)
+}
+
+// ExpectSelect expects a Select of a statement containing sql.
+func (m *Mock) ExpectSelect(sql string) *MockExpect {
+	return m.expect(mockSelect, sql)
+}
+
+func (m *Mock) expect(kind mockKind, sql string) *MockExpect {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	e := &MockExpect{kind: kind, sql: normalizeSQL(sql)}
+	m.expected = append(m.expected, e)
+	return e
+}
+
+// WithArgs makes the expectation only match statements executed with exactly
+// these arguments. Values are compared deeply, falling back on their printed
+// form so that e.g. 1 matches an int64(1). MockAnyArg matches any value.
+func (e *MockExpect) WithArgs(args ...any) *MockExpect {
+	e.args = args
+	return e
+}
+
+// OnReplica makes the expectation only match reads made through
+// ReadReplica.
+func (e *MockExpect) OnReplica() *MockExpect {
+	e.replica = true
+	return e
+}
+
+// WillReturnCount sets the affected row count returned by Exec.
+func (e *MockExpect) WillReturnCount(n int) *MockExpect {
+	e.count = n
+	return e
+}
+
+// WillReturnRows sets the rows returned by Query, or the row returned by
+// QueryRow (only the first row is used). QueryRow without rows returns
+// pgx.ErrNoRows from Scan.
+func (e *MockExpect) WillReturnRows(rows ...[]any) *MockExpect {
+	e.rows = rows
+	return e
+}
+
+// WillReturnSelect sets the slice stored into the destination of Select.
+func (e *MockExpect) WillReturnSelect(slice any) *MockExpect {
+	e.result = slice
+	return e
+}
+
+// WillReturnError makes the statement fail with err.
+func (e *MockExpect) WillReturnError(err error) *MockExpect {
+	e.err = err
+	return e
+}
+
+// ExpectationsWereMet returns an error if any statement didn't match its
+// expectation, or if expected statements were never executed.
+func (m *Mock) ExpectationsWereMet() error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	if len(m.expected) > 0 {
+		e := m.expected[0]
+		return xerrors.Errorf("%d expected statements not executed, next: %s %q", len(m.expected), e.kind, e.sql)
+	}
+	return nil
+}
+
+func (m *Mock) next(kind mockKind, replica bool, sql rawStringOnly, args []any) (*MockExpect, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	err := func() error {
+		if len(m.expected) == 0 {
+			return xerrors.Errorf("unexpected %s %q", kind, string(sql))
+		}
+
+		e := m.expected[0]
+		if e.kind != kind || !strings.Contains(normalizeSQL(string(sql)), e.sql) {
+			return xerrors.Errorf("unexpected %s %q, expected %s %q", kind, string(sql), e.kind, e.sql)
+		}
+		if e.args != nil && !argsMatch(e.args, args) {
+			return xerrors.Errorf("%s %q: unexpected arguments %v, expected %v", kind, e.sql, args, e.args)
+		}
+		if e.replica != replica {
+			return xerrors.Errorf("%s %q: %s, expected %s", kind, e.sql, mockTarget(replica), mockTarget(e.replica))
+		}
+		return nil
+	}()
+	if err != nil {
+		// keep the first mismatch around so that ExpectationsWereMet reports
+		// it even if the code under test swallows the error
+		m.err = err
+		return nil, err
+	}
+
+	e := m.expected[0]
+	m.expected = m.expected[1:]
+	return e, nil
+}
+
+func mockTarget(replica bool) string {
+	if replica {
+		return "read from a replica"
+	}
+	return "run on the primary"
+}
+
+func (m *Mock) Exec(ctx context.Context, sql rawStringOnly, arguments ...any) (count int, err error) {
+	e, err := m.next(mockExec, false, sql, arguments)
+	if err != nil {
+		return 0, err
+	}
+	return e.count, e.err
+}
+
+func (m *Mock) Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*Query, error) {
+	return m.query(false, sql, arguments)
+}
+
+func (m *Mock) query(replica bool, sql rawStringOnly, arguments []any) (*Query, error) {
+	e, err := m.next(mockQuery, replica, sql, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &Query{&mockRows{rows: e.rows, cur: -1}}, nil
+}
+
+func (m *Mock) QueryRow(ctx context.Context, sql rawStringOnly, arguments ...any) Row {
+	return m.queryRow(false, sql, arguments)
+}
+
+func (m *Mock) queryRow(replica bool, sql rawStringOnly, arguments []any) Row {
+	e, err := m.next(mockQueryRow, replica, sql, arguments)
+	if err != nil {
+		return &mockRow{err: err}
+	}
+	if e.err != nil {
+		return &mockRow{err: e.err}
+	}
+	if len(e.rows) == 0 {
+		return &mockRow{err: pgx.ErrNoRows}
+	}
+	return &mockRow{vals: e.rows[0]}
+}
+
+func (m *Mock) Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error {
+	return m.selectInto(false, sliceOfStructPtr, sql, arguments)
+}
+
+func (m *Mock) selectInto(replica bool, sliceOfStructPtr any, sql rawStringOnly, arguments []any) error {
+	e, err := m.next(mockSelect, replica, sql, arguments)
+	if err != nil {
+		return err
+	}
+	if e.err != nil {
+		return e.err
+	}
+	if e.result == nil {
+		return nil
+	}
+
+	dst := reflect.ValueOf(sliceOfStructPtr)
+	if dst.Kind() != reflect.Pointer || dst.Elem().Kind() != reflect.Slice {
+		return xerrors.Errorf("select destination must be a pointer to a slice, got %T", sliceOfStructPtr)
+	}
+	src := reflect.ValueOf(e.result)
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return xerrors.Errorf("select result %T can't be stored in %T", e.result, sliceOfStructPtr)
+	}
+	dst.Elem().Set(src)
+	return nil
+}
+
+func (m *Mock) BeginTransaction(ctx context.Context, f func(*Tx) (commit bool, err error)) (didCommit bool, retErr error) {
+	commit, err := f(&Tx{ctx: ctx, mock: m})
+	if err != nil {
+		return false, err
+	}
+	return commit, nil
+}
+
+// ReadReplica returns a Reader whose statements match the expectations
+// marked OnReplica.
+func (m *Mock) ReadReplica() Reader {
+	return &mockReplica{m: m}
+}
+
+type mockReplica struct {
+	m *Mock
+}
+
+func (r *mockReplica) Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*Query, error) {
+	return r.m.query(true, sql, arguments)
+}
+
+func (r *mockReplica) QueryRow(ctx context.Context, sql rawStringOnly, arguments ...any) Row {
+	return r.m.queryRow(true, sql, arguments)
+}
+
+func (r *mockReplica) Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error {
+	return r.m.selectInto(true, sliceOfStructPtr, sql, arguments)
+}
+
+type mockRow struct {
+	vals []any
+	err  error
+}
+
+func (r *mockRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return scanValues(r.vals, dest)
+}
+
+type mockRows struct {
+	rows [][]any
+	cur  int
+}
+
+func (r *mockRows) Next() bool {
+	r.cur++
+	return r.cur < len(r.rows)
+}
+
+func (r *mockRows) Err() error {
+	return nil
+}
+
+func (r *mockRows) Close() {}
+
+func (r *mockRows) Scan(dest ...any) error {
+	if r.cur < 0 || r.cur >= len(r.rows) {
+		return xerrors.Errorf("scan called without a current row")
+	}
+	return scanValues(r.rows[r.cur], dest)
+}
+
+func (r *mockRows) Values() ([]any, error) {
+	if r.cur < 0 || r.cur >= len(r.rows) {
+		return nil, xerrors.Errorf("values called without a current row")
+	}
+	return r.rows[r.cur], nil
+}
+
+// scanValues stores vals into the dest pointers, converting between
+// compatible types and allocating pointer destinations when needed.
+func scanValues(vals []any, dest []any) error {
+	if len(vals) != len(dest) {
+		return xerrors.Errorf("row has %d values, scanning into %d destinations", len(vals), len(dest))
+	}
+
+	for i, d := range dest {
+		dv := reflect.ValueOf(d)
+		if dv.Kind() != reflect.Pointer || dv.IsNil() {
+			return xerrors.Errorf("scan destination %d is not a pointer: %T", i, d)
+		}
+		target := dv.Elem()
+
+		if vals[i] == nil {
+			target.Set(reflect.Zero(target.Type()))
+			continue
+		}
+
+		v := reflect.ValueOf(vals[i])
+		if target.Kind() == reflect.Pointer && v.Kind() != reflect.Pointer {
+			if !v.Type().ConvertibleTo(target.Type().Elem()) {
+				return xerrors.Errorf("can't scan %T into %T", vals[i], d)
+			}
+			p := reflect.New(target.Type().Elem())
+			p.Elem().Set(v.Convert(target.Type().Elem()))
+			target.Set(p)
+			continue
+		}
+
+		if !v.Type().ConvertibleTo(target.Type()) {
+			return xerrors.Errorf("can't scan %T into %T", vals[i], d)
+		}
+		target.Set(v.Convert(target.Type()))
+	}
+
+	return nil
+}
+
+func argsMatch(expected, actual []any) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := range expected {
+		if expected[i] == MockAnyArg {
+			continue
+		}
+		if !reflect.DeepEqual(expected[i], actual[i]) && fmt.Sprint(expected[i]) != fmt.Sprint(actual[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
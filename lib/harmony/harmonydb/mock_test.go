@@ -0,0 +1,93 @@
+package harmonydb
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock(t *testing.T) {
+	ctx := context.Background()
+	m := NewMock()
+
+	m.ExpectQueryRow(`SELECT name, count FROM things WHERE id = $1`).WithArgs(1).WillReturnRows([]any{"a", 3})
+	m.ExpectQueryRow(`SELECT name`).WillReturnRows()
+	m.ExpectExec(`UPDATE things`).WithArgs(MockAnyArg, 1).WillReturnCount(1)
+	m.ExpectExec(`DELETE FROM things`).WillReturnCount(0)
+
+	var name string
+	var count *int64
+	require.NoError(t, m.QueryRow(ctx, `SELECT name, count
+		FROM things WHERE id = $1`, 1).Scan(&name, &count))
+	require.Equal(t, "a", name)
+	require.EqualValues(t, 3, *count)
+
+	err := m.QueryRow(ctx, `SELECT name FROM things WHERE id = $1`, 2).Scan(&name)
+	require.True(t, errors.Is(err, pgx.ErrNoRows))
+
+	commit, err := m.BeginTransaction(ctx, func(tx *Tx) (bool, error) {
+		n, err := tx.Exec(`UPDATE things SET name = $1 WHERE id = $2`, "b", 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		n, err = tx.Exec(`DELETE FROM things WHERE id = 5`)
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+		return true, nil
+	})
+	require.NoError(t, err)
+	require.True(t, commit)
+
+	require.NoError(t, m.ExpectationsWereMet())
+}
+
+func TestMockMismatch(t *testing.T) {
+	ctx := context.Background()
+	m := NewMock()
+
+	m.ExpectExec(`DELETE FROM things`).WithArgs(1)
+	m.ExpectSelect(`SELECT id FROM things`).WillReturnSelect([]int{1, 2})
+
+	_, err := m.Exec(ctx, `DELETE FROM things WHERE id = $1`, 2)
+	require.Error(t, err)
+
+	// the first mismatch sticks
+	var ids []int
+	require.Error(t, m.Select(ctx, &ids, `SELECT id FROM things`))
+	require.Error(t, m.ExpectationsWereMet())
+
+	m = NewMock()
+	m.ExpectSelect(`SELECT id FROM things`).WillReturnSelect([]int{1, 2})
+	require.Error(t, m.ExpectationsWereMet())
+	require.NoError(t, m.Select(ctx, &ids, `SELECT id FROM things`))
+	require.Equal(t, []int{1, 2}, ids)
+	require.NoError(t, m.ExpectationsWereMet())
+}
+
+func TestMockReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	m := NewMock()
+
+	m.ExpectSelect(`SELECT id FROM things`).OnReplica().WillReturnSelect([]int{1})
+	m.ExpectExec(`UPDATE things`).WillReturnCount(1)
+	m.ExpectQueryRow(`SELECT name FROM things`).WillReturnRows([]any{"a"})
+
+	var ids []int
+	require.NoError(t, m.ReadReplica().Select(ctx, &ids, `SELECT id FROM things`))
+	require.Equal(t, []int{1}, ids)
+	_, err := m.Exec(ctx, `UPDATE things SET name = 'b'`)
+	require.NoError(t, err)
+
+	// a read expected on the primary fails on a replica
+	var name string
+	require.Error(t, m.ReadReplica().QueryRow(ctx, `SELECT name FROM things`).Scan(&name))
+	require.Error(t, m.ExpectationsWereMet())
+
+	// and the other way around
+	m = NewMock()
+	m.ExpectQueryRow(`SELECT name FROM things`).OnReplica().WillReturnRows([]any{"a"})
+	require.Error(t, m.QueryRow(ctx, `SELECT name FROM things`).Scan(&name))
+}
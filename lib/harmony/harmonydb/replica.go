@@ -0,0 +1,179 @@
+package harmonydb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opencensus.io/stats"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/node/config"
+)
+
+// replicaConnectTimeout bounds connecting to a read replica, so that reads
+// fall back to the primary quickly when a replica is down.
+var replicaConnectTimeout = 3 * time.Second
+
+// replicaRetry is how long an unreachable read replica is skipped before
+// reads are sent to it again.
+var replicaRetry = 30 * time.Second
+
+// Reader is the read-only part of Interface. Code which tolerates stale
+// data takes a Reader, so that it can be given ReadReplica(). There is no
+// Exec or BeginTransaction: writes, and reads that writes depend on, always
+// go to the primary. See the package doc for which queries tolerate stale
+// reads.
+type Reader interface {
+	Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*Query, error)
+	QueryRow(ctx context.Context, sql rawStringOnly, arguments ...any) Row
+	Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error
+}
+
+var _ Reader = &DB{}
+
+// ReadReplica returns a Reader sending its queries to the read replicas of
+// HarmonyDB.ReadReplicas, in turns. Without replicas it reads from the
+// primary. Replicas lag behind the primary, so the data read may be stale.
+// When a replica can't be reached, the query is sent to the primary
+// instead, and the replica is skipped for a while.
+func (db *DB) ReadReplica() Reader {
+	if db.replicas == nil {
+		return db
+	}
+	return db.replicas
+}
+
+// addReplicas creates the pools of the read replicas of cfg. The pools
+// connect lazily, so replicas which are down don't keep the node from
+// starting.
+func (db *DB) addReplicas(cfg config.HarmonyDB) error {
+	var replicas []*replica
+	for _, h := range cfg.ReadReplicas {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		pcfg, err := pgxpool.ParseConfig(makeConnString(h, cfg) + "search_path=" + db.schema)
+		if err != nil {
+			return xerrors.Errorf("parsing config of read replica %s: %w", h, err)
+		}
+		pcfg.ConnConfig.ConnectTimeout = replicaConnectTimeout
+		pcfg.ConnConfig.Tracer = tracer{}
+
+		pool, err := pgxpool.NewWithConfig(context.Background(), pcfg)
+		if err != nil {
+			return xerrors.Errorf("creating pool of read replica %s: %w", h, err)
+		}
+		replicas = append(replicas, &replica{host: h, pool: pool})
+	}
+
+	if len(replicas) > 0 {
+		db.replicas = &replicaSet{primary: db.pgx, replicas: replicas}
+	}
+	return nil
+}
+
+// querier is the part of a connection pool reads use.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type replica struct {
+	host string
+	pool querier
+
+	// downUntil is when the replica is tried again after it was found
+	// unreachable, in unix nanoseconds
+	downUntil atomic.Int64
+}
+
+// replicaSet is the Reader of DB.ReadReplica.
+type replicaSet struct {
+	primary  querier
+	replicas []*replica
+
+	next atomic.Uint32
+}
+
+// pick returns the next replica which isn't known to be down, nil when all
+// are.
+func (s *replicaSet) pick() *replica {
+	now := time.Now().UnixNano()
+	start := int(s.next.Add(1))
+	for i := range s.replicas {
+		r := s.replicas[(start+i)%len(s.replicas)]
+		if r.downUntil.Load() <= now {
+			return r
+		}
+	}
+	return nil
+}
+
+// read runs f on a replica, or on the primary when no replica can be
+// reached.
+func (s *replicaSet) read(ctx context.Context, f func(q querier) error) error {
+	if r := s.pick(); r != nil {
+		err := f(r.pool)
+		if err == nil || ctx.Err() != nil || !replicaUnreachable(err) {
+			stats.Record(ctx, DBMeasures.ReplicaReads.M(1))
+			return err
+		}
+
+		r.downUntil.Store(time.Now().Add(replicaRetry).UnixNano())
+		logger.Warnw("read replica unreachable, reading from the primary", "replica", r.host, "retry", replicaRetry, "error", err)
+		stats.Record(ctx, DBMeasures.ReplicaFallbacks.M(1))
+	}
+
+	return f(s.primary)
+}
+
+// replicaUnreachable tells whether err means that the replica couldn't be
+// reached, as opposed to an error of the statement itself, which the
+// primary would return too.
+func replicaUnreachable(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || pgconn.SafeToRetry(err) || pgconn.Timeout(err)
+}
+
+func (s *replicaSet) Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*Query, error) {
+	var rows pgx.Rows
+	err := s.read(ctx, func(q querier) (err error) {
+		rows, err = q.Query(ctx, string(sql), arguments...)
+		return err
+	})
+	return &Query{rows}, err
+}
+
+func (s *replicaSet) QueryRow(ctx context.Context, sql rawStringOnly, arguments ...any) Row {
+	return &replicaRow{set: s, ctx: ctx, sql: string(sql), args: arguments}
+}
+
+func (s *replicaSet) Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error {
+	return s.read(ctx, func(q querier) error {
+		return pgxscan.Select(ctx, q, sliceOfStructPtr, string(sql), arguments...)
+	})
+}
+
+// replicaRow runs its query on Scan, as pgx rows only fail then.
+type replicaRow struct {
+	set  *replicaSet
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (r *replicaRow) Scan(dest ...any) error {
+	return r.set.read(r.ctx, func(q querier) error {
+		return q.QueryRow(r.ctx, r.sql, r.args...).Scan(dest...)
+	})
+}
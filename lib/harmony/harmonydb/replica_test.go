@@ -0,0 +1,110 @@
+package harmonydb
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQuerier records the hosts reads are sent to, and fails them with err.
+type fakeQuerier struct {
+	host  string
+	err   error
+	reads *[]string
+}
+
+func (f *fakeQuerier) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	*f.reads = append(*f.reads, f.host)
+	return nil, f.err
+}
+
+func (f *fakeQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	*f.reads = append(*f.reads, f.host)
+	return &mockRow{vals: []any{f.host}, err: f.err}
+}
+
+func TestReadReplicaRouting(t *testing.T) {
+	ctx := context.Background()
+	var reads []string
+
+	primary := &fakeQuerier{host: "primary", reads: &reads}
+	r1 := &fakeQuerier{host: "r1", reads: &reads}
+	r2 := &fakeQuerier{host: "r2", reads: &reads}
+	set := &replicaSet{primary: primary, replicas: []*replica{{host: "r1", pool: r1}, {host: "r2", pool: r2}}}
+	db := &DB{replicas: set}
+
+	// reads are spread over the replicas
+	var host string
+	for i := 0; i < 4; i++ {
+		require.NoError(t, db.ReadReplica().QueryRow(ctx, `SELECT host`).Scan(&host))
+	}
+	_, err := db.ReadReplica().Query(ctx, `SELECT host`)
+	require.NoError(t, err)
+	require.NotContains(t, reads, "primary")
+	require.Contains(t, reads, "r1")
+	require.Contains(t, reads, "r2")
+
+	// errors of the statement are returned as they are
+	reads = nil
+	stmtErr := &pgconn.PgError{Code: "42P01", Message: "relation does not exist"}
+	r1.err, r2.err = stmtErr, stmtErr
+	err = db.ReadReplica().QueryRow(ctx, `SELECT host`).Scan(&host)
+	require.ErrorIs(t, err, stmtErr)
+	require.Len(t, reads, 1)
+	require.NotEqual(t, "primary", reads[0])
+
+	// an unreachable replica falls back to the primary, and is skipped after
+	reads = nil
+	r1.err = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	r2.err = nil
+	var hosts []string
+	for i := 0; i < 4; i++ {
+		require.NoError(t, db.ReadReplica().QueryRow(ctx, `SELECT host`).Scan(&host))
+		hosts = append(hosts, host)
+	}
+	require.Contains(t, hosts, "primary")
+	require.Equal(t, 1, countOf(reads, "r1"))
+	require.Equal(t, 1, countOf(hosts, "primary"))
+
+	// all replicas down, reads go to the primary
+	reads = nil
+	set.replicas[1].downUntil.Store(set.replicas[0].downUntil.Load())
+	require.NoError(t, db.ReadReplica().QueryRow(ctx, `SELECT host`).Scan(&host))
+	require.Equal(t, "primary", host)
+	require.Equal(t, []string{"primary"}, reads)
+
+	// the replica is tried again after replicaRetry
+	reads = nil
+	set.replicas[0].downUntil.Store(0)
+	r1.err = nil
+	require.NoError(t, db.ReadReplica().QueryRow(ctx, `SELECT host`).Scan(&host))
+	require.Equal(t, "r1", host)
+
+	// a canceled read isn't retried on the primary (r2 is still down)
+	reads = nil
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	r1.err = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	require.Error(t, db.ReadReplica().QueryRow(cctx, `SELECT host`).Scan(&host))
+	require.Equal(t, []string{"r1"}, reads)
+}
+
+func TestReadReplicaWithoutReplicas(t *testing.T) {
+	db := &DB{}
+	require.Equal(t, Reader(db), db.ReadReplica())
+}
+
+func countOf(s []string, v string) int {
+	var n int
+	for _, e := range s {
+		if e == v {
+			n++
+		}
+	}
+	return n
+}
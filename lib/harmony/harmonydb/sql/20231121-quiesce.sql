@@ -0,0 +1 @@
+ALTER TABLE harmony_machines ADD COLUMN draining BOOLEAN NOT NULL DEFAULT FALSE;
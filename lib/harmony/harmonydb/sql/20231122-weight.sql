@@ -0,0 +1 @@
+ALTER TABLE harmony_machines ADD COLUMN weight INTEGER NOT NULL DEFAULT 1;
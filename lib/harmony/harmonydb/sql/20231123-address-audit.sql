@@ -0,0 +1,17 @@
+create table message_address_audit
+(
+    id           bigserial not null
+        constraint message_address_audit_pk
+            primary key,
+    addr         text      not null, -- address as picked by the AddressSelector
+    from_key     text      not null,
+    pick_reason  text      not null,
+    send_reason  text      not null,
+    send_task_id bigint,
+    signed_cid   text,
+    max_fee      numeric   not null, -- attoFIL, GasFeeCap*GasLimit+Value
+    sent_at      timestamp not null default current_timestamp
+);
+
+create index message_address_audit_addr_index
+    on message_address_audit (addr, sent_at);
@@ -0,0 +1,23 @@
+create table harmony_prune_tasks
+(
+    task_id bigint    not null
+        constraint harmony_prune_tasks_pk
+            primary key,
+    period  timestamp not null
+        constraint harmony_prune_tasks_period_key
+            unique
+);
+
+comment on column harmony_prune_tasks.period is 'start of the prune period, at most one prune task is created per period';
+
+create table harmony_task_history_summary
+(
+    name   varchar(16) not null,
+    result boolean     not null,
+    day    date        not null,
+    count  bigint      not null,
+    constraint harmony_task_history_summary_pk
+        primary key (name, result, day)
+);
+
+comment on table harmony_task_history_summary is 'per-day counts of harmony_task_history rows removed by history pruning';
@@ -0,0 +1,24 @@
+create table wdpost_spot_check_tasks
+(
+    task_id bigint    not null
+        constraint wdpost_spot_check_tasks_pk
+            primary key,
+    sp_id   bigint    not null,
+    period  timestamp not null,
+    constraint wdpost_spot_check_tasks_identity_key
+        unique (sp_id, period)
+);
+
+comment on column wdpost_spot_check_tasks.period is 'start of the spot-check period, at most one check per miner is created per period';
+
+create table wdpost_spot_checks
+(
+    sp_id         bigint    not null,
+    sector_number bigint    not null,
+    checked_at    timestamp not null default current_timestamp,
+    ok            boolean   not null,
+    err           text
+);
+
+create index wdpost_spot_checks_sp_id_checked_at_index
+    on wdpost_spot_checks (sp_id, checked_at);
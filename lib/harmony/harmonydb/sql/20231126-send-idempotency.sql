@@ -0,0 +1,8 @@
+alter table message_sends
+    add column idempotency_key text;
+
+comment on column message_sends.idempotency_key is 'optional caller-provided key, a send with a key which was already sent returns the existing message instead of sending again';
+
+create unique index message_sends_idempotency_key_index
+    on message_sends (idempotency_key)
+    where send_success is not false;
@@ -0,0 +1,16 @@
+create table winpost_leaders
+(
+    sp_id         bigint    not null
+        constraint winpost_leaders_pk
+            primary key,
+    leader_id     text      not null, -- random per-process identifier
+    leader_host   text      not null, -- host:port of the leader, for display
+    lease_expires timestamp not null
+);
+
+comment on table winpost_leaders is 'the node which submits mined blocks for each miner, leadership is held by renewing the lease before it expires';
+
+alter table mining_tasks
+    add column block_msg bytea;
+
+comment on column mining_tasks.block_msg is 'cbor-encoded BlockMsg, for blocks computed by a node which is not the submitting leader';
@@ -0,0 +1,4 @@
+alter table wdpost_proofs
+    add column challenge_rand bytea;
+
+comment on column wdpost_proofs.challenge_rand is 'challenge randomness the proof was computed with, used to detect reorgs before submitting';
@@ -0,0 +1,26 @@
+create table wdpost_warm_tasks
+(
+    task_id              bigint not null
+        constraint wdpost_warm_tasks_pk
+            primary key,
+    sp_id                bigint not null,
+    proving_period_start bigint not null,
+    deadline_index       bigint not null,
+    constraint wdpost_warm_tasks_identity_key
+        unique (sp_id, proving_period_start, deadline_index)
+);
+
+comment on table wdpost_warm_tasks is 'prefetch of sector files ahead of a WindowPoSt deadline, at most one per miner deadline';
+
+create table wdpost_warm_missing
+(
+    sp_id                bigint    not null,
+    proving_period_start bigint    not null,
+    deadline_index       bigint    not null,
+    sector_number        bigint    not null,
+    file_type            text      not null,
+    reported_at          timestamp not null default current_timestamp
+);
+
+create index wdpost_warm_missing_sp_id_reported_at_index
+    on wdpost_warm_missing (sp_id, reported_at);
@@ -0,0 +1,3 @@
+ALTER TABLE harmony_task_history ADD COLUMN err_class varchar(16);
+
+COMMENT ON COLUMN harmony_task_history.err_class IS 'retryable, terminal or unclassified for failed runs, null for successful ones.';
@@ -0,0 +1,14 @@
+create table wdpost_partition_affinity
+(
+    task_id    bigint  not null
+        constraint wdpost_partition_affinity_task_id_fk
+            references wdpost_partition_tasks (task_id)
+            on delete cascade,
+    storage_id varchar not null,
+    sectors    bigint  not null,
+    constraint wdpost_partition_affinity_pk
+        primary key (task_id, storage_id)
+);
+
+comment on table wdpost_partition_affinity is 'storage paths holding the sealed sectors of a partition task, per the index when the task was scheduled';
+comment on column wdpost_partition_affinity.sectors is 'number of live sectors of the partition with sealed files in the storage path';
@@ -0,0 +1,11 @@
+alter table mining_tasks
+    add column included bool;
+
+alter table mining_tasks
+    add column included_checked_at timestamp;
+
+comment on column mining_tasks.included is 'whether the submitted block made it into the canonical chain, NULL until it is deep enough to tell; false means it was orphaned';
+
+create index mining_tasks_unreconciled_index
+    on mining_tasks (sp_id, epoch)
+    where won = true and submitted_at is not null and included is null;
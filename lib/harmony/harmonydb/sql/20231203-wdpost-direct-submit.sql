@@ -0,0 +1,20 @@
+create table wdpost_direct_submits
+(
+    id                   bigserial not null
+        constraint wdpost_direct_submits_pk
+            primary key,
+    sp_id                bigint    not null,
+    proving_period_start bigint    not null,
+    deadline             bigint    not null,
+    partitions           text      not null, -- comma separated partition indexes
+    from_key             text      not null,
+    nonce                bigint    not null,
+    signed_cid           text      not null,
+    recomputed           boolean   not null,
+    submitted_by         text      not null,
+    submitted_at         timestamp not null default current_timestamp
+);
+
+comment on table wdpost_direct_submits is 'WindowPoSt messages pushed straight to the message pool, bypassing the Sender and message_sends, see lotus-provider proving submit-direct';
+comment on column wdpost_direct_submits.recomputed is 'false when the proof was read from wdpost_proofs, true when it was computed for the submission';
+comment on column wdpost_direct_submits.submitted_by is 'host the submission was made from';
@@ -0,0 +1,13 @@
+create table wdpost_paused_deadlines
+(
+    sp_id          bigint    not null,
+    deadline_index bigint    not null,
+    reason         text      not null default '',
+    paused_by      text      not null,
+    paused_at      timestamp not null default current_timestamp,
+    constraint wdpost_paused_deadlines_pk
+        primary key (sp_id, deadline_index)
+);
+
+comment on table wdpost_paused_deadlines is 'deadlines for which no WindowPoSt compute tasks are scheduled until resumed';
+comment on column wdpost_paused_deadlines.paused_by is 'host the pause was requested from';
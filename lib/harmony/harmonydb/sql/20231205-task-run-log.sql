@@ -0,0 +1,3 @@
+ALTER TABLE harmony_task_history ADD COLUMN run_log jsonb;
+
+COMMENT ON COLUMN harmony_task_history.run_log IS 'events logged during the run with harmonytask.Logw, as an array of {t, msg, fields}; size-capped, see RUN_LOG_MAX_BYTES.';
@@ -0,0 +1,3 @@
+ALTER TABLE storage_path ADD COLUMN read_only bool NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN storage_path.read_only IS 'no new files are placed in the path; sectors it holds can still be read and fetched.';
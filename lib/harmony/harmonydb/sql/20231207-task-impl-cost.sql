@@ -0,0 +1,7 @@
+ALTER TABLE harmony_task_impl ADD COLUMN cpu INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE harmony_task_impl ADD COLUMN ram BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE harmony_task_impl ADD COLUMN gpu FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE harmony_task_impl ADD COLUMN max_tasks INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN harmony_task_impl.cpu IS 'the cost of one task of the type on the machine, with ram and gpu.';
+COMMENT ON COLUMN harmony_task_impl.max_tasks IS 'how many tasks of the type the machine runs at once, 0 or less for unrestricted.';
@@ -0,0 +1,18 @@
+create table message_waits
+(
+    signed_message_cid     text      not null
+        constraint message_waits_pk
+            primary key,
+    waiting_since          timestamp not null default current_timestamp,
+
+    executed_tsk_cid       text,
+    executed_tsk_epoch     bigint,
+    executed_msg_cid       text,
+    executed_rcpt_exitcode bigint,
+    executed_rcpt_return   bytea,
+    executed_rcpt_gas_used bigint
+);
+
+comment on table message_waits is 'sent messages whose execution is tracked, by SendAndWait or in the background by the message watcher';
+comment on column message_waits.executed_tsk_cid is 'key of the tipset the message was executed in, null until executed with enough confidence';
+comment on column message_waits.executed_msg_cid is 'cid of the executed message, differs from signed_message_cid when the message was replaced';
@@ -0,0 +1,19 @@
+create table wdpost_disputes
+(
+    message_cid text      not null
+        constraint wdpost_disputes_pk
+            primary key,
+    sp_id       bigint    not null,
+    deadline    bigint    not null,
+    post_index  bigint    not null,
+    disputer    text      not null,
+    epoch       bigint    not null,
+    seen_at     timestamp not null default current_timestamp
+);
+
+create index wdpost_disputes_sp_id_epoch_index
+    on wdpost_disputes (sp_id, epoch desc);
+
+comment on table wdpost_disputes is 'successful disputes of WindowPoSt proofs of the cluster miners';
+comment on column wdpost_disputes.post_index is 'index of the disputed proof in the optimistic submissions of the deadline';
+comment on column wdpost_disputes.epoch is 'epoch of the tipset in which the execution of the dispute was seen';
@@ -0,0 +1,3 @@
+ALTER TABLE storage_path ADD COLUMN file_costs varchar NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN storage_path.file_costs IS 'cost hints of sector file types in the path as type:cost pairs separated by commas, types not listed use the defaults; expensive files are kept local, cheap ones evicted first.';
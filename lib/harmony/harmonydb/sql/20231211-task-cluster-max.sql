@@ -0,0 +1,10 @@
+ALTER TABLE harmony_task_impl ADD COLUMN cluster_max INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN harmony_task_impl.cluster_max IS 'how many tasks of the type the machine lets run at once across the cluster, 0 or less for unrestricted.';
+
+CREATE TABLE harmony_task_cluster_claim (
+    name varchar(16) PRIMARY KEY,
+    last_claim TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE harmony_task_cluster_claim IS 'one row per task type with a cluster-wide cap, updated by every claim of the type so that claims across machines are serialized.';
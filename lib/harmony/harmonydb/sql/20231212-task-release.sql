@@ -0,0 +1,15 @@
+CREATE TABLE harmony_task_release (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL,
+    name VARCHAR(16) NOT NULL,
+    owner_id INTEGER NOT NULL,
+    owner_host_and_port VARCHAR(300),
+    owner_contact_age_ms BIGINT,
+    forced BOOLEAN NOT NULL,
+    released_by TEXT NOT NULL,
+    released_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+COMMENT ON TABLE harmony_task_release IS 'tasks whose owner was cleared by an operator with lotus-provider tasks release.';
+COMMENT ON COLUMN harmony_task_release.owner_contact_age_ms IS 'time since the last heartbeat of the owner when released, null when the owner had left the cluster.';
+COMMENT ON COLUMN harmony_task_release.forced IS 'the owner was heartbeating when released, the release was forced.';
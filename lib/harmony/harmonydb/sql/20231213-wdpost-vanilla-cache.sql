@@ -0,0 +1,16 @@
+create table wdpost_vanilla_cache
+(
+    sp_id         bigint    not null,
+    sector_number bigint    not null,
+    challenge_key bytea     not null,
+    proof         bytea     not null,
+    expires_at    timestamp not null,
+    constraint wdpost_vanilla_cache_pk
+        primary key (sp_id, sector_number)
+);
+
+create index wdpost_vanilla_cache_expires_at_index
+    on wdpost_vanilla_cache (expires_at);
+
+comment on table wdpost_vanilla_cache is 'vanilla proofs of sectors for their last WindowPoSt challenges, reused when a partition is proven again for the same challenges';
+comment on column wdpost_vanilla_cache.challenge_key is 'hash of the proof type, sector and challenges the proof answers, challenges change with the randomness';
@@ -0,0 +1,8 @@
+ALTER TABLE harmony_machines ADD COLUMN name TEXT;
+ALTER TABLE harmony_machines ADD COLUMN labels JSONB;
+
+COMMENT ON COLUMN harmony_machines.name IS 'the name the node reports itself as, Node.Name in its config or its hostname; not unique.';
+COMMENT ON COLUMN harmony_machines.labels IS 'the Node.Labels of the node, as a {label: value} object.';
+
+ALTER TABLE harmony_task_history ADD COLUMN completed_by_name TEXT;
+ALTER TABLE harmony_task_release ADD COLUMN owner_name TEXT;
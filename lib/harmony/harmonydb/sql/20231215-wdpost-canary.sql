@@ -0,0 +1,26 @@
+create table wdpost_canary_tasks
+(
+    task_id              bigint not null
+        constraint wdpost_canary_tasks_pk
+            primary key,
+    sp_id                bigint not null,
+    proving_period_start bigint not null,
+    constraint wdpost_canary_tasks_identity_key
+        unique (sp_id, proving_period_start)
+);
+
+comment on column wdpost_canary_tasks.proving_period_start is 'the canary sectors of a miner are checked once per proving period';
+
+create table wdpost_canary_checks
+(
+    task_id              bigint    not null,
+    sp_id                bigint    not null,
+    sector_number        bigint    not null,
+    proving_period_start bigint    not null,
+    checked_at           timestamp not null default current_timestamp,
+    ok                   boolean   not null,
+    err                  text
+);
+
+create index wdpost_canary_checks_sp_id_checked_at_index
+    on wdpost_canary_checks (sp_id, checked_at);
@@ -0,0 +1,22 @@
+create table storage_path_bench
+(
+    storage_id      varchar   not null,
+    host_and_port   varchar   not null,
+    machine_name    text,
+    measured_at     timestamp not null default current_timestamp,
+    files           int       not null,
+    seq_bytes       bigint    not null,
+    seq_ms          bigint    not null,
+    rand_reads      bigint    not null,
+    rand_block_size bigint    not null,
+    rand_ms         bigint    not null,
+    latency_avg_us  bigint    not null,
+    latency_p50_us  bigint    not null,
+    latency_p99_us  bigint    not null,
+    latency_max_us  bigint    not null
+);
+
+comment on table storage_path_bench is 'results of lotus-provider storage bench --record, to follow the read performance of paths over time';
+
+create index storage_path_bench_storage_id_measured_at_index
+    on storage_path_bench (storage_id, measured_at);
@@ -0,0 +1 @@
+comment on table winpost_leaders is 'the node holding the WinningPoSt leader advisory lock of each miner, for display; the leader refreshes its row while it holds the lock';
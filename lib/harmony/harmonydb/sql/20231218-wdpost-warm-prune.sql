@@ -0,0 +1,29 @@
+-- a file missing for a deadline is recorded once, warming the deadline again
+-- only updates reported_at
+create table wdpost_warm_missing_dedup
+(
+    sp_id                bigint    not null,
+    proving_period_start bigint    not null,
+    deadline_index       bigint    not null,
+    sector_number        bigint    not null,
+    file_type            text      not null,
+    reported_at          timestamp not null default current_timestamp,
+    constraint wdpost_warm_missing_pk
+        primary key (sp_id, proving_period_start, deadline_index, sector_number, file_type)
+);
+
+insert into wdpost_warm_missing_dedup (sp_id, proving_period_start, deadline_index, sector_number, file_type, reported_at)
+    select sp_id, proving_period_start, deadline_index, sector_number, file_type, max(reported_at)
+    from wdpost_warm_missing
+    group by sp_id, proving_period_start, deadline_index, sector_number, file_type;
+
+drop table wdpost_warm_missing;
+
+alter table wdpost_warm_missing_dedup rename to wdpost_warm_missing;
+
+create index wdpost_warm_missing_sp_id_reported_at_index
+    on wdpost_warm_missing (sp_id, reported_at);
+
+-- lets the history pruner remove old tasks
+alter table wdpost_warm_tasks
+    add column created_at timestamp not null default current_timestamp;
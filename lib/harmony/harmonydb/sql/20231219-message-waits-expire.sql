@@ -0,0 +1,4 @@
+alter table message_waits
+    add column expired boolean not null default false;
+
+comment on column message_waits.expired is 'true when the message was not found executed within the watch lookback of when it was sent, the message watcher stops searching for it';
@@ -0,0 +1,14 @@
+alter table wdpost_disputes
+    add column recovered_epoch bigint;
+
+comment on column wdpost_disputes.recovered_epoch is 'epoch at which the disputed deadline had no faulty sectors left, null while it is pending recovery';
+
+create table wdpost_dispute_watch
+(
+    sp_id      bigint not null
+        constraint wdpost_dispute_watch_pk
+            primary key,
+    last_epoch bigint not null
+);
+
+comment on table wdpost_dispute_watch is 'last epoch of which the executed messages were searched for disputes of the miner';
@@ -0,0 +1,3 @@
+-- lets the history pruner remove old canary tasks
+alter table wdpost_canary_tasks
+    add column created_at timestamp not null default current_timestamp;
@@ -0,0 +1,13 @@
+create table wdpost_verify_holds
+(
+    sp_id           bigint    not null,
+    deadline_index  bigint    not null,
+    partition_index bigint    not null,
+    challenge_epoch bigint    not null,
+    err             text      not null,
+    held_at         timestamp not null default current_timestamp,
+    constraint wdpost_verify_holds_pk
+        primary key (sp_id, deadline_index, partition_index, challenge_epoch)
+);
+
+comment on table wdpost_verify_holds is 'proofs held after failing local verification, until a proof of the miner verifies again';
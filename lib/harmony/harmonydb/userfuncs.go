@@ -59,7 +59,11 @@ func (db *DB) Query(ctx context.Context, sql rawStringOnly, arguments ...any) (*
 	return &Query{q}, err
 }
 func (q *Query) StructScan(s any) error {
-	return pgxscan.ScanRow(s, q.Qry.(pgx.Rows))
+	rows, ok := q.Qry.(pgx.Rows)
+	if !ok {
+		return errors.New("StructScan is not supported on these rows")
+	}
+	return pgxscan.ScanRow(s, rows)
 }
 
 type Row interface {
@@ -98,6 +102,9 @@ func (db *DB) Select(ctx context.Context, sliceOfStructPtr any, sql rawStringOnl
 type Tx struct {
 	pgx.Tx
 	ctx context.Context
+
+	// mock, when set, answers the transaction's statements instead of pgx
+	mock *Mock
 }
 
 // BeginTransaction is how you can access transactions using this library.
@@ -116,7 +123,7 @@ func (db *DB) BeginTransaction(ctx context.Context, f func(*Tx) (commit bool, er
 			}
 		}
 	}()
-	commit, err = f(&Tx{tx, ctx})
+	commit, err = f(&Tx{Tx: tx, ctx: ctx})
 	if err != nil {
 		return false, err
 	}
@@ -132,23 +139,35 @@ func (db *DB) BeginTransaction(ctx context.Context, f func(*Tx) (commit bool, er
 
 // Exec in a transaction.
 func (t *Tx) Exec(sql rawStringOnly, arguments ...any) (count int, err error) {
+	if t.mock != nil {
+		return t.mock.Exec(t.ctx, sql, arguments...)
+	}
 	res, err := t.Tx.Exec(t.ctx, string(sql), arguments...)
 	return int(res.RowsAffected()), err
 }
 
 // Query in a transaction.
 func (t *Tx) Query(sql rawStringOnly, arguments ...any) (*Query, error) {
+	if t.mock != nil {
+		return t.mock.Query(t.ctx, sql, arguments...)
+	}
 	q, err := t.Tx.Query(t.ctx, string(sql), arguments...)
 	return &Query{q}, err
 }
 
 // QueryRow in a transaction.
 func (t *Tx) QueryRow(sql rawStringOnly, arguments ...any) Row {
+	if t.mock != nil {
+		return t.mock.QueryRow(t.ctx, sql, arguments...)
+	}
 	return t.Tx.QueryRow(t.ctx, string(sql), arguments...)
 }
 
 // Select in a transaction.
 func (t *Tx) Select(sliceOfStructPtr any, sql rawStringOnly, arguments ...any) error {
+	if t.mock != nil {
+		return t.mock.Select(t.ctx, sliceOfStructPtr, sql, arguments...)
+	}
 	return pgxscan.Select(t.ctx, t.Tx, sliceOfStructPtr, string(sql), arguments...)
 }
 
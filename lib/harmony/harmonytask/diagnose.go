@@ -0,0 +1,243 @@
+package harmonytask
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+)
+
+// BlockCode identifies why a task isn't being executed, see Diagnose.
+type BlockCode string
+
+const (
+	// BlockNotFound: the task isn't queued, it completed or was dropped.
+	BlockNotFound BlockCode = "not-found"
+	// BlockRunning: the task is owned by a machine, which runs it.
+	BlockRunning BlockCode = "running"
+	// BlockNoEligibleNode: no machine of the cluster runs the task type.
+	BlockNoEligibleNode BlockCode = "no-eligible-node"
+	// BlockUnresponsive: the machine missed its heartbeats.
+	BlockUnresponsive BlockCode = "unresponsive"
+	// BlockQuiesced: the machine is draining, it claims no new tasks.
+	BlockQuiesced BlockCode = "quiesced"
+	// BlockTooBig: the task costs more than the machine has in total.
+	BlockTooBig BlockCode = "too-big"
+	// BlockResources: the tasks running on the machine leave too little free.
+	BlockResources BlockCode = "resources"
+	// BlockConcurrencyCap: the machine runs its Max of tasks of the type.
+	BlockConcurrencyCap BlockCode = "concurrency-cap"
+	// BlockClusterCap: the cluster runs the machine's ClusterMax of tasks of
+	// the type.
+	BlockClusterCap BlockCode = "cluster-cap"
+	// BlockWeightBackoff: the machine is over its weighted share, it leaves
+	// the task to the others for a while after it was queued or last failed.
+	BlockWeightBackoff BlockCode = "weight-backoff"
+	// BlockPriorFailures notes earlier failed runs of the task. Failed
+	// tasks are queued again right away, this doesn't block claiming.
+	BlockPriorFailures BlockCode = "prior-failures"
+	// BlockClaimable: none of the conditions apply on the machine, it claims
+	// the task unless the task type's own CanAccept refuses it, or other
+	// task types are ahead of it. CanAccept refusals are logged by the
+	// machine.
+	BlockClaimable BlockCode = "claimable"
+)
+
+// BlockReason is one reason a task isn't being executed.
+type BlockReason struct {
+	Code BlockCode
+	// Machine is the host_and_port the reason applies to, empty when it
+	// applies to the whole cluster.
+	Machine string `json:",omitempty"`
+	Detail  string
+	// Blocking is false for notes which don't keep the task from being
+	// claimed, like BlockRunning or BlockClaimable.
+	Blocking bool
+}
+
+type diagTask struct {
+	Name  string  `db:"name"`
+	Owner *string `db:"host_and_port"`
+	AgeMs int64   `db:"age_ms"`
+}
+
+type diagFailure struct {
+	Err *string `db:"err"`
+}
+
+type diagMachine struct {
+	ID           int     `db:"id"`
+	Host         string  `db:"host_and_port"`
+	Cpu          int     `db:"cpu"`
+	Ram          uint64  `db:"ram"`
+	Gpu          float64 `db:"gpu"`
+	Draining     bool    `db:"draining"`
+	ContactAgeMs int64   `db:"contact_age_ms"`
+	CostCpu      int     `db:"cost_cpu"`
+	CostRam      uint64  `db:"cost_ram"`
+	CostGpu      float64 `db:"cost_gpu"`
+	MaxTasks     int     `db:"max_tasks"`
+	ClusterMax   int     `db:"cluster_max"`
+}
+
+// diagRunning is what the tasks running on a machine use, and how many of
+// them are of the diagnosed type.
+type diagRunning struct {
+	Owner int     `db:"owner_id"`
+	Cpu   int     `db:"cpu"`
+	Ram   uint64  `db:"ram"`
+	Gpu   float64 `db:"gpu"`
+	Count int     `db:"count"`
+}
+
+// unresponsiveAfter is how long a machine which missed its heartbeats, sent
+// every minute, is reported as unresponsive. It leaves the cluster, and its
+// tasks are released, after resources.LOOKS_DEAD_TIMEOUT.
+const unresponsiveAfter = 3 * time.Minute
+
+// Diagnose explains why the task isn't being executed, evaluating the
+// conditions machines claim tasks under against the state of the cluster in
+// the database. Machines are expected to poll at POLL_DURATION. Conditions
+// specific to a task type, checked by its CanAccept, can't be evaluated
+// from here.
+//
+// Diagnose reads the HarmonyDB tables, so it only applies to clusters using
+// the PostgresStore. The weighted shares of the machines are read through it
+// as the TaskStore, the way the engine reads them.
+func Diagnose(ctx context.Context, db harmonydb.Interface, id TaskID) ([]BlockReason, error) {
+	store := NewPostgresStore(db)
+
+	var tasks []diagTask
+	err := db.Select(ctx, &tasks, `SELECT t.name, m.host_and_port,
+			(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - t.update_time) * 1000)::bigint AS age_ms
+		FROM harmony_task t LEFT JOIN harmony_machines m ON m.id = t.owner_id WHERE t.id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("reading task: %w", err)
+	}
+	if len(tasks) == 0 {
+		return []BlockReason{{
+			Code:   BlockNotFound,
+			Detail: "the task isn't queued, it completed or was dropped, see its history",
+		}}, nil
+	}
+	task := tasks[0]
+	if task.Owner != nil {
+		return []BlockReason{{
+			Code:    BlockRunning,
+			Machine: *task.Owner,
+			Detail:  "the task is claimed and running",
+		}}, nil
+	}
+
+	var out []BlockReason
+
+	var failures []diagFailure
+	err = db.Select(ctx, &failures, `SELECT err FROM harmony_task_history
+		WHERE task_id = $1 AND result = FALSE ORDER BY work_end DESC`, id)
+	if err != nil {
+		return nil, fmt.Errorf("reading task history: %w", err)
+	}
+	if len(failures) > 0 {
+		last := ""
+		if failures[0].Err != nil {
+			last = *failures[0].Err
+		}
+		out = append(out, BlockReason{
+			Code:   BlockPriorFailures,
+			Detail: fmt.Sprintf("failed %d time(s), last with: %s", len(failures), last),
+		})
+	}
+
+	var machines []diagMachine
+	err = db.Select(ctx, &machines, `SELECT m.id, m.host_and_port, m.cpu, m.ram, m.gpu, m.draining,
+			(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - m.last_contact) * 1000)::bigint AS contact_age_ms,
+			i.cpu AS cost_cpu, i.ram AS cost_ram, i.gpu AS cost_gpu, i.max_tasks, i.cluster_max
+		FROM harmony_machines m JOIN harmony_task_impl i ON i.owner_id = m.id
+		WHERE i.name = $1 ORDER BY m.id`, task.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading machines: %w", err)
+	}
+	if len(machines) == 0 {
+		return append(out, BlockReason{
+			Code:     BlockNoEligibleNode,
+			Detail:   fmt.Sprintf("no machine runs %s tasks, enable the task type on a node", task.Name),
+			Blocking: true,
+		}), nil
+	}
+
+	var running []diagRunning
+	err = db.Select(ctx, &running, `SELECT t.owner_id,
+			COALESCE(SUM(i.cpu), 0) AS cpu, COALESCE(SUM(i.ram), 0) AS ram, COALESCE(SUM(i.gpu), 0) AS gpu,
+			COUNT(*) FILTER (WHERE t.name = $1) AS count
+		FROM harmony_task t JOIN harmony_task_impl i ON i.owner_id = t.owner_id AND i.name = t.name
+		GROUP BY t.owner_id`, task.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading running tasks: %w", err)
+	}
+	used := map[int]resources.Resources{}
+	counts := map[int]int{}
+	var clusterCount int
+	for _, r := range running {
+		used[r.Owner] = resources.Resources{Cpu: r.Cpu, Ram: r.Ram, Gpu: r.Gpu}
+		counts[r.Owner] = r.Count
+		clusterCount += r.Count
+	}
+
+	over, err := overWeightedShares(ctx, store, task.Name)
+	if err != nil {
+		return nil, fmt.Errorf("reading cluster load: %w", err)
+	}
+	age := time.Duration(task.AgeMs) * time.Millisecond
+
+	for _, m := range machines {
+		total := resources.Resources{Cpu: m.Cpu, Ram: m.Ram, Gpu: m.Gpu}
+		cost := resources.Resources{Cpu: m.CostCpu, Ram: m.CostRam, Gpu: m.CostGpu}
+		u := used[m.ID]
+		free := resources.Resources{Cpu: total.Cpu - u.Cpu, Gpu: total.Gpu - u.Gpu}
+		if u.Ram < total.Ram {
+			free.Ram = total.Ram - u.Ram
+		}
+
+		block := func(code BlockCode, format string, args ...any) {
+			out = append(out, BlockReason{
+				Code:     code,
+				Machine:  m.Host,
+				Detail:   fmt.Sprintf(format, args...),
+				Blocking: true,
+			})
+		}
+
+		contact := time.Duration(m.ContactAgeMs) * time.Millisecond
+		switch {
+		case contact > unresponsiveAfter:
+			block(BlockUnresponsive, "no heartbeat for %s", contact.Round(time.Second))
+		case m.Draining:
+			block(BlockQuiesced, "the machine is draining, resume it to claim tasks again")
+		case !total.Fits(cost):
+			block(BlockTooBig, "the task needs %s, the machine has %s in total", formatResources(cost), formatResources(total))
+		case !free.Fits(cost):
+			block(BlockResources, "the task needs %s, running tasks leave %s free", formatResources(cost), formatResources(free))
+		case m.MaxTasks > 0 && counts[m.ID] >= m.MaxTasks:
+			block(BlockConcurrencyCap, "running %d of at most %d %s tasks", counts[m.ID], m.MaxTasks, task.Name)
+		case m.ClusterMax > 0 && clusterCount >= m.ClusterMax:
+			block(BlockClusterCap, "the cluster runs %d of at most %d %s tasks", clusterCount, m.ClusterMax, task.Name)
+		case over[m.ID] && age < weightBackoff(POLL_DURATION):
+			block(BlockWeightBackoff, "over its weighted share of %s tasks, leaving the task to others for %s more",
+				task.Name, (weightBackoff(POLL_DURATION) - age).Round(time.Second))
+		default:
+			out = append(out, BlockReason{
+				Code:    BlockClaimable,
+				Machine: m.Host,
+				Detail:  "no condition keeps the machine from claiming the task, unless the task type's CanAccept refuses it (logged by the machine)",
+			})
+		}
+	}
+
+	return out, nil
+}
+
+func formatResources(r resources.Resources) string {
+	return fmt.Sprintf("%d cpu, %d MiB ram, %g gpu", r.Cpu, r.Ram>>20, r.Gpu)
+}
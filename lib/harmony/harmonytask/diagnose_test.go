@@ -0,0 +1,104 @@
+package harmonytask
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+func TestDiagnose(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	e := "error: out of disk"
+	db.ExpectSelect(`FROM harmony_task t LEFT JOIN harmony_machines m`).WithArgs(TaskID(7)).
+		WillReturnSelect([]diagTask{{Name: "WdPost", AgeMs: 1000}})
+	db.ExpectSelect(`FROM harmony_task_history`).WillReturnSelect([]diagFailure{{Err: &e}, {}})
+
+	machine := func(id int, host string) diagMachine {
+		return diagMachine{ID: id, Host: host, Cpu: 8, Ram: 64 << 30, CostCpu: 2, CostRam: 16 << 30, MaxTasks: 2}
+	}
+	gone, draining, small, busy, capped, heavy, free := machine(1, "gone"), machine(2, "draining"),
+		machine(3, "small"), machine(4, "busy"), machine(5, "capped"), machine(6, "heavy"), machine(7, "free")
+	gone.ContactAgeMs = 10 * 60 * 1000
+	draining.Draining = true
+	small.Ram = 8 << 30
+	db.ExpectSelect(`FROM harmony_machines m JOIN harmony_task_impl i`).
+		WillReturnSelect([]diagMachine{gone, draining, small, busy, capped, heavy, free})
+	db.ExpectSelect(`GROUP BY t.owner_id`).WillReturnSelect([]diagRunning{
+		{Owner: 4, Cpu: 7, Ram: 8 << 30},
+		{Owner: 5, Cpu: 4, Ram: 32 << 30, Count: 2},
+		{Owner: 6, Cpu: 2, Ram: 16 << 30, Count: 1},
+	})
+	// heavy has twice its weighted share
+	db.ExpectSelect(`LEFT JOIN harmony_task t ON t.owner_id = m.id`).WillReturnSelect([]MachineLoad{
+		{ID: 6, Weight: 1, Count: 1},
+		{ID: 7, Weight: 3, Count: 0},
+	})
+
+	reasons, err := Diagnose(ctx, db, 7)
+	require.NoError(t, err)
+	require.NoError(t, db.ExpectationsWereMet())
+
+	var codes []BlockCode
+	for _, r := range reasons {
+		codes = append(codes, r.Code)
+		require.Equal(t, r.Code != BlockPriorFailures && r.Code != BlockClaimable, r.Blocking, r.Code)
+	}
+	require.Equal(t, []BlockCode{BlockPriorFailures, BlockUnresponsive, BlockQuiesced, BlockTooBig,
+		BlockResources, BlockConcurrencyCap, BlockWeightBackoff, BlockClaimable}, codes)
+	require.Contains(t, reasons[0].Detail, "failed 2 time(s), last with: error: out of disk")
+	require.Equal(t, "free", reasons[len(reasons)-1].Machine)
+}
+
+func TestDiagnoseNoEligibleNode(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	db.ExpectSelect(`FROM harmony_task t`).WillReturnSelect([]diagTask{{Name: "WinPost"}})
+	db.ExpectSelect(`FROM harmony_task_history`).WillReturnSelect([]diagFailure{})
+	db.ExpectSelect(`FROM harmony_machines m JOIN harmony_task_impl i`).WillReturnSelect([]diagMachine{})
+
+	reasons, err := Diagnose(ctx, db, 1)
+	require.NoError(t, err)
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Len(t, reasons, 1)
+	require.Equal(t, BlockNoEligibleNode, reasons[0].Code)
+	require.True(t, reasons[0].Blocking)
+
+	// the task completed
+	db.ExpectSelect(`FROM harmony_task t`).WillReturnSelect([]diagTask{})
+	reasons, err = Diagnose(ctx, db, 1)
+	require.NoError(t, err)
+	require.Equal(t, BlockNotFound, reasons[0].Code)
+}
+
+func TestDiagnoseClusterCap(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	db.ExpectSelect(`FROM harmony_task t`).WillReturnSelect([]diagTask{{Name: "WdPost"}})
+	db.ExpectSelect(`FROM harmony_task_history`).WillReturnSelect([]diagFailure{})
+	db.ExpectSelect(`FROM harmony_machines m JOIN harmony_task_impl i`).WillReturnSelect([]diagMachine{
+		{ID: 1, Host: "a", Cpu: 8, Ram: 64 << 30, CostCpu: 1, MaxTasks: 4, ClusterMax: 2},
+		{ID: 2, Host: "b", Cpu: 8, Ram: 64 << 30, CostCpu: 1, MaxTasks: 4, ClusterMax: 2},
+	})
+	// each machine is below its own cap, together they are at the cluster's
+	db.ExpectSelect(`GROUP BY t.owner_id`).WillReturnSelect([]diagRunning{
+		{Owner: 1, Cpu: 1, Count: 1},
+		{Owner: 2, Cpu: 1, Count: 1},
+	})
+	db.ExpectSelect(`LEFT JOIN harmony_task t ON t.owner_id = m.id`).WillReturnSelect([]MachineLoad{})
+
+	reasons, err := Diagnose(ctx, db, 3)
+	require.NoError(t, err)
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Len(t, reasons, 2)
+	for _, r := range reasons {
+		require.Equal(t, BlockClusterCap, r.Code)
+		require.Equal(t, "the cluster runs 2 of at most 2 WdPost tasks", r.Detail)
+	}
+}
@@ -16,8 +16,9 @@ Mental Model:
 		- resource exhaustion
 		- CanAccept() interface (per-task implmentation) does not accept it.
 	Ways tasks start:
-		- DB Read every 3 seconds
-		- Task was added (to db) by this process
+		- DB Read every poll interval (3 seconds by default, SetPollInterval)
+		- Task was added (to db) by this process, or a task finished here
+		- Task was added by another machine, with EnableNotify
 	Ways tasks get added:
 	    - Async Listener task (for chain, etc)
 		- Followers: Tasks get added because another task completed
@@ -41,6 +42,35 @@ clean-up for after the task data could never be acted upon.
 but the design **requires** extraInfo tables to grow until the task's
 info could not possibly be used by a following task, including slow
 release rollout. This would normally be in the order of months old.
+*
+Weighted distribution:
+
+	Machines in harmony_machines carry a weight (default 1). When weights
+	differ, a machine owning more than weight/total_weight of the running
+	tasks of a type leaves fresh tasks of that type to others for
+	WEIGHT_BACKOFF before claiming them. Equal weights disable the bias.
+	To verify the distribution, sample the owners of running tasks a few
+	times over a proving period and compare against the weights:
+		SELECT m.host_and_port, m.weight, t.name, COUNT(t.id)
+		FROM harmony_machines m LEFT JOIN harmony_task t ON t.owner_id = m.id
+		GROUP BY m.host_and_port, m.weight, t.name;
+	harmony_task_history.completed_by_host_and_port gives the same picture
+	for completed work.
+
+*
+Poll interval:
+
+	Tasks added by this process are claimed right away, the poll interval
+	only bounds the latency of tasks added by other machines (e.g. a
+	WindowPoSt task scheduled by the node following the chain, but run by a
+	GPU machine). Each poll runs a few queries per task type, so halving the
+	interval doubles the idle query load of every machine on the database;
+	lengthening it delays cross-machine work by up to the interval.
+	On PostgreSQL, EnableNotify announces added tasks with NOTIFY so idle
+	machines claim them without waiting for a poll; polling remains the
+	fallback for missed notifications. YugabyteDB doesn't support LISTEN,
+	so there polling is the only cross-machine signal.
+
 *
 Other possible enhancements include more collaborative coordination
 to assign a task to machines closer to the data.
@@ -67,5 +97,12 @@ harmony_task_machines
 	anything, but serves as a discovery mechanism. Paths are hostnames + ports
 	which are presumed to support http, but this assumption is only used by
 	the task system.
+
+__Task_Stores__
+The tables above are those of PostgresStore, the default TaskStore. The
+engine only reaches them through the TaskStore interface, so that another
+backend can be given to NewWithStore. AddTaskFunc callbacks still record
+extra info in a *harmonydb.Tx, which the store passes them from within the
+transaction adding the task.
 */
 package harmonytask
@@ -0,0 +1,77 @@
+package harmonytask
+
+import (
+	"errors"
+)
+
+// ErrorClass tells the engine how to treat a failed task.
+type ErrorClass string
+
+const (
+	// ErrorUnclassified errors follow the task type's retry policy.
+	ErrorUnclassified ErrorClass = "unclassified"
+	// ErrorRetryable errors are transient (network, busy resources), the
+	// task goes back to the queue according to MaxFailures.
+	ErrorRetryable ErrorClass = "retryable"
+	// ErrorTerminal errors won't go away by retrying (bad proof, invalid
+	// input), the task is dropped immediately.
+	ErrorTerminal ErrorClass = "terminal"
+)
+
+// ErrorClassifier can be implemented by a TaskInterface to classify errors
+// returned from Do which weren't wrapped with Retryable or Terminal.
+type ErrorClassifier interface {
+	ClassifyError(error) ErrorClass
+}
+
+type classifiedError struct {
+	class ErrorClass
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// Retryable marks err as transient, to be returned from Do.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrorRetryable, err: err}
+}
+
+// Terminal marks err as permanent, to be returned from Do. The task is
+// dropped without further retries.
+func Terminal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrorTerminal, err: err}
+}
+
+// Classify returns the class of an error returned from Do. Explicit
+// Retryable / Terminal wrapping anywhere in the chain takes precedence over
+// the classifier, which may be nil.
+func Classify(err error, classifier ErrorClassifier) ErrorClass {
+	if err == nil {
+		return ErrorUnclassified
+	}
+
+	var ce *classifiedError
+	if errors.As(err, &ce) {
+		return ce.class
+	}
+
+	if classifier != nil {
+		if c := classifier.ClassifyError(err); c != "" {
+			return c
+		}
+	}
+
+	return ErrorUnclassified
+}
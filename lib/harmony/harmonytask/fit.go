@@ -0,0 +1,169 @@
+package harmonytask
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+)
+
+// FIT_CHECK_FREQUENCY is how often queued tasks are checked for a machine
+// able to run them, see SetAlerting.
+var FIT_CHECK_FREQUENCY = CLEANUP_FREQUENCY
+
+// SetResources declares the resources of this machine, overriding the ones
+// detected at startup. Zero fields keep the detected value. Tasks are only
+// claimed while their Cost fits in what isn't used by the tasks running here,
+// and other machines read the declaration to tell if any machine can run a
+// task at all.
+func (e *TaskEngine) SetResources(ctx context.Context, r resources.Resources) error {
+	if r.Cpu < 0 || r.Gpu < 0 {
+		return fmt.Errorf("resources must not be negative, got %d cpus and %f gpus", r.Cpu, r.Gpu)
+	}
+
+	e.resLk.Lock()
+	res := e.reg.Resources
+	if r.Cpu > 0 {
+		res.Cpu = r.Cpu
+	}
+	if r.Gpu > 0 {
+		res.Gpu = r.Gpu
+	}
+	if r.Ram > 0 {
+		res.Ram = r.Ram
+	}
+	e.resLk.Unlock()
+
+	err := e.store.SetResources(ctx, e.ownerID, res)
+	if err != nil {
+		return fmt.Errorf("could not set resources: %w", err)
+	}
+
+	e.resLk.Lock()
+	e.reg.Resources = res
+	e.resLk.Unlock()
+
+	log.Infow("machine resources declared", "cpu", res.Cpu, "ram", res.Ram, "gpu", res.Gpu)
+	for _, h := range e.handlers {
+		if !h.addOnly && !res.Fits(h.Cost) {
+			log.Warnw("task type can never run on this machine, its cost exceeds the machine's resources",
+				"name", h.Name, "cpu", h.Cost.Cpu, "ram", h.Cost.Ram, "gpu", h.Cost.Gpu)
+		}
+	}
+	return nil
+}
+
+// SetAlerting makes the engine raise an alert for each task type with tasks
+// queued which no machine running the type has the resources for, and for
+// each AddOnly type no live machine runs. Without it, such tasks are only
+// reported in the log.
+func (e *TaskEngine) SetAlerting(al *alerting.Alerting) {
+	fa := &fitAlerts{
+		al:        al,
+		types:     make(map[string]alerting.AlertType, len(e.handlers)),
+		uncovered: map[string]alerting.AlertType{},
+	}
+	for _, h := range e.handlers {
+		fa.types[h.Name] = al.AddAlertType("harmonytask", "unfittable-"+h.Name)
+		if h.addOnly {
+			fa.uncovered[h.Name] = al.AddAlertType("harmonytask", "uncovered-"+h.Name)
+		}
+	}
+	e.fitAlerts.Store(fa)
+}
+
+type fitAlerts struct {
+	al        *alerting.Alerting
+	types     map[string]alerting.AlertType
+	uncovered map[string]alerting.AlertType
+}
+
+// checkCoverage tells if a live machine runs the AddOnly task type, raising
+// or resolving its alert.
+func (e *TaskEngine) checkCoverage(fa *fitAlerts, h *taskTypeHandler) (bool, error) {
+	machines, err := e.store.ImplMachines(e.ctx, h.Name, resources.Resources{})
+	if err != nil {
+		return false, fmt.Errorf("counting machines running %s tasks: %w", h.Name, err)
+	}
+
+	covered := machines > 0
+	if !covered {
+		log.Warnw("no machine runs a task type added by this machine, its tasks won't be executed",
+			"name", h.Name)
+	}
+	if fa == nil {
+		return covered, nil
+	}
+	at := fa.uncovered[h.Name]
+	switch raised := fa.al.IsRaised(at); {
+	case !covered && !raised:
+		fa.al.Raise(at, map[string]interface{}{
+			"name": h.Name,
+		})
+	case covered && raised:
+		fa.al.Resolve(at, map[string]interface{}{
+			"machines": machines,
+		})
+	}
+	return covered, nil
+}
+
+// checkFit looks for task types with unclaimed tasks which don't fit on any
+// live machine running the type. Those tasks would otherwise wait in the
+// queue forever without anything pointing at the cause.
+func (e *TaskEngine) checkFit() {
+	fa := e.fitAlerts.Load()
+	for _, h := range e.handlers {
+		if h.addOnly {
+			covered, err := e.checkCoverage(fa, h)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if !covered {
+				// not running anywhere is reported instead of not fitting
+				continue
+			}
+		}
+
+		queued, err := e.store.CountUnclaimed(e.ctx, h.Name)
+		if err != nil {
+			log.Error("Unable to count queued tasks ", err)
+			return
+		}
+
+		var fitting int
+		if queued > 0 {
+			fitting, err = e.store.ImplMachines(e.ctx, h.Name, h.Cost)
+			if err != nil {
+				log.Error("Unable to find machines fitting tasks ", err)
+				return
+			}
+		}
+
+		unfittable := queued > 0 && fitting == 0
+		if unfittable {
+			log.Warnw("no machine has the resources for queued tasks",
+				"name", h.Name, "queued", queued, "cpu", h.Cost.Cpu, "ram", h.Cost.Ram, "gpu", h.Cost.Gpu)
+		}
+		if fa == nil {
+			continue
+		}
+		at := fa.types[h.Name]
+		switch raised := fa.al.IsRaised(at); {
+		case unfittable && !raised:
+			fa.al.Raise(at, map[string]interface{}{
+				"queued": queued,
+				"cpu":    h.Cost.Cpu,
+				"ram":    h.Cost.Ram,
+				"gpu":    h.Cost.Gpu,
+			})
+		case !unfittable && raised:
+			fa.al.Resolve(at, map[string]interface{}{
+				"queued":   queued,
+				"machines": fitting,
+			})
+		}
+	}
+}
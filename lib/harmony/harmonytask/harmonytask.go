@@ -4,23 +4,38 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"go.opencensus.io/stats"
+
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 )
 
 // Consts (except for unit test)
-var POLL_DURATION = time.Second * 3     // Poll for Work this frequently
+var POLL_DURATION = time.Second * 3     // Poll for Work this frequently, see SetPollInterval
 var CLEANUP_FREQUENCY = 5 * time.Minute // Check for dead workers this often * everyone
 var FOLLOW_FREQUENCY = 1 * time.Minute  // Check for work to follow this often
+var WEIGHT_BACKOFF = 4 * POLL_DURATION  // Over-share machines leave work this long for others
+
+// notifyChannel carries the names of added tasks, see EnableNotify.
+const notifyChannel = "harmony_task"
 
 type TaskTypeDetails struct {
 	// Max returns how many tasks this machine can run of this type.
 	// Zero (default) or less means unrestricted.
 	Max int
 
+	// ClusterMax is how many tasks of this type may run at once across all
+	// machines of the cluster. Machines check the count of claimed tasks of
+	// the type when claiming one, so the cap holds regardless of how many
+	// machines run the type; Max still limits each machine. Machines enforce
+	// the value they are configured with, so it should be the same on all of
+	// them. Zero (default) or less means unrestricted.
+	ClusterMax int
+
 	// Name is the task name to be added to the task list.
 	Name string
 
@@ -29,6 +44,7 @@ type TaskTypeDetails struct {
 
 	// Max Failure count before the job is dropped.
 	// 0 = retry forever
+	// Terminal errors drop the job regardless.
 	MaxFailures uint
 
 	// Follow another task's completion via this task's creation.
@@ -48,6 +64,8 @@ type TaskInterface interface {
 	// ONLY be called by harmonytask.
 	// Indicate if the task no-longer needs scheduling with done=true including
 	// cases where it's past the deadline.
+	// Wrap errors with Retryable or Terminal (or implement ErrorClassifier)
+	// to tell transient failures from ones which retrying won't fix.
 	Do(taskID TaskID, stillOwned func() bool) (done bool, err error)
 
 	// CanAccept should return if the task can run on this machine. It should
@@ -98,7 +116,7 @@ type AddTaskFunc func(extraInfo func(TaskID, *harmonydb.Tx) (shouldCommit bool,
 type TaskEngine struct {
 	ctx            context.Context
 	handlers       []*taskTypeHandler
-	db             *harmonydb.DB
+	store          TaskStore
 	reg            *resources.Reg
 	grace          context.CancelFunc
 	taskMap        map[string]*taskTypeHandler
@@ -106,7 +124,15 @@ type TaskEngine struct {
 	follows        map[string][]followStruct
 	lastFollowTime time.Time
 	lastCleanup    atomic.Value
+	lastFitCheck   time.Time
+	fitAlerts      atomic.Pointer[fitAlerts]
+	resLk          sync.Mutex // guards reg.Resources, see SetResources
 	hostAndPort    string
+	quiesced       atomic.Bool
+	terminating    atomic.Bool
+	pollInterval   atomic.Int64 // time.Duration
+	wakeup         chan struct{}
+	notify         atomic.Bool
 }
 type followStruct struct {
 	f    func(TaskID, AddTaskFunc) (bool, error)
@@ -116,6 +142,20 @@ type followStruct struct {
 
 type TaskID int
 
+// AddOnly makes the engine run the Adder and Follows of the task, so that
+// this machine keeps adding tasks of the type, but never claim them; other
+// machines running the type do. It is how a machine is kept from running a
+// task type while the subsystem adding the tasks is enabled on it. The
+// machine raises an alert while no live machine runs the type, see
+// SetAlerting.
+func AddOnly(t TaskInterface) TaskInterface {
+	return &addOnlyTask{TaskInterface: t}
+}
+
+type addOnlyTask struct {
+	TaskInterface
+}
+
 // New creates all the task definitions. Note that TaskEngine
 // knows nothing about the tasks themselves and serves to be a
 // generic container for common work
@@ -123,8 +163,18 @@ func New(
 	db *harmonydb.DB,
 	impls []TaskInterface,
 	hostnameAndPort string) (*TaskEngine, error) {
+	return NewWithStore(NewPostgresStore(db), impls, hostnameAndPort)
+}
+
+// NewWithStore is New with the tasks kept in store rather than HarmonyDB.
+// Extra info is recorded in the transaction the store passes to AddTaskFunc
+// callbacks regardless, see TaskStore.AddTask.
+func NewWithStore(
+	store TaskStore,
+	impls []TaskInterface,
+	hostnameAndPort string) (*TaskEngine, error) {
 
-	reg, err := resources.Register(db, hostnameAndPort)
+	reg, err := resources.Register(store, hostnameAndPort)
 	if err != nil {
 		return nil, fmt.Errorf("cannot get resources: %w", err)
 	}
@@ -132,20 +182,26 @@ func New(
 	e := &TaskEngine{
 		ctx:         ctx,
 		grace:       grace,
-		db:          db,
+		store:       store,
 		reg:         reg,
 		ownerID:     reg.Resources.MachineID, // The current number representing "hostAndPort"
 		taskMap:     make(map[string]*taskTypeHandler, len(impls)),
 		follows:     make(map[string][]followStruct),
 		hostAndPort: hostnameAndPort,
+		wakeup:      make(chan struct{}, 1),
 	}
 	e.lastCleanup.Store(time.Now())
+	e.pollInterval.Store(int64(POLL_DURATION))
 	for _, c := range impls {
 		h := taskTypeHandler{
 			TaskInterface:   c,
 			TaskTypeDetails: c.TypeDetails(),
 			TaskEngine:      e,
 		}
+		if ao, ok := c.(*addOnlyTask); ok {
+			h.TaskInterface = ao.TaskInterface
+			h.addOnly = true
+		}
 
 		if len(h.Name) > 16 {
 			return nil, fmt.Errorf("task name too long: %s, max 16 characters", h.Name)
@@ -155,26 +211,25 @@ func New(
 		e.taskMap[h.TaskTypeDetails.Name] = &h
 	}
 
+	if err := e.registerImpls(); err != nil {
+		return nil, err
+	}
+
 	// resurrect old work
 	{
-		var taskRet []struct {
-			ID   int
-			Name string
-		}
-
-		err := db.Select(e.ctx, &taskRet, `SELECT id, name from harmony_task WHERE owner_id=$1`, e.ownerID)
+		taskRet, err := store.OwnedTasks(e.ctx, e.ownerID)
 		if err != nil {
 			return nil, err
 		}
 		for _, w := range taskRet {
 			// edge-case: if old assignments are not available tasks, unlock them.
 			h := e.taskMap[w.Name]
-			if h == nil {
-				_, err := db.Exec(e.ctx, `UPDATE harmony_task SET owner=NULL WHERE id=$1`, w.ID)
+			if h == nil || h.addOnly {
+				err := store.Disown(e.ctx, TaskID(w.ID))
 				if err != nil {
-					log.Errorw("Cannot remove self from owner field", "error", err)
-					continue // not really fatal, but not great
+					log.Errorw("Cannot remove self from owner field", "error", err) // not really fatal, but not great
 				}
+				continue
 			}
 			if !h.considerWork("recovered", []TaskID{TaskID(w.ID)}) {
 				log.Error("Strange: Unable to accept previously owned task: ", w.ID, w.Name)
@@ -189,18 +244,43 @@ func New(
 	return e, nil
 }
 
+// registerImpls records the task types this machine runs in
+// harmony_task_impl, with their cost and limit here, so that the cluster can
+// be listed with them and queued tasks can be diagnosed, see Diagnose.
+func (e *TaskEngine) registerImpls() error {
+	var impls []TaskImpl
+	for _, h := range e.handlers {
+		if h.addOnly {
+			// other machines claim these, see AddOnly
+			continue
+		}
+		impls = append(impls, TaskImpl{Name: h.Name, Cost: h.Cost, Max: h.Max, ClusterMax: h.ClusterMax})
+	}
+	if err := e.store.RegisterImpls(e.ctx, e.ownerID, impls); err != nil {
+		return fmt.Errorf("registering task types: %w", err)
+	}
+	return nil
+}
+
 // GracefullyTerminate hangs until all present tasks have completed.
 // Call this to cleanly exit the process. As some processes are long-running,
 // passing a deadline will ignore those still running (to be picked-up later).
+// While waiting, the harmonytask_active_tasks metric tracks the number of
+// tasks left, and each task completion is logged.
 func (e *TaskEngine) GracefullyTerminate(deadline time.Duration) {
+	e.terminating.Store(true)
 	e.grace()
 	e.reg.Shutdown()
+	e.recordState()
+	log.Infow("Shutting down, waiting for running tasks", "running", e.RunningCount(), "deadline", deadline)
+
 	deadlineChan := time.NewTimer(deadline).C
 top:
 	for _, h := range e.handlers {
 		if h.Count.Load() > 0 {
 			select {
 			case <-deadlineChan:
+				log.Warnw("Shutdown deadline reached, leaving tasks to be picked up later", "running", e.RunningCount())
 				return
 			default:
 				time.Sleep(time.Millisecond)
@@ -208,22 +288,237 @@ top:
 			}
 		}
 	}
+
+	log.Infow("All tasks completed, shutdown finished")
 }
 
 func (e *TaskEngine) poller() {
 	for {
+		timer := time.NewTimer(e.PollInterval())
 		select {
-		case <-time.NewTicker(POLL_DURATION).C: // Find work periodically
+		case <-timer.C: // Find work periodically
+		case <-e.wakeup: // Work was added or finished in this process
 		case <-e.ctx.Done(): ///////////////////// Graceful exit
+			timer.Stop()
 			return
 		}
-		e.pollerTryAllWork()
+		timer.Stop()
+		e.recordState()
+		if !e.quiesced.Load() { // when draining, in-flight work finishes but nothing new is claimed
+			e.pollerTryAllWork()
+		}
 		if time.Since(e.lastFollowTime) > FOLLOW_FREQUENCY {
 			e.followWorkInDB()
 		}
 	}
 }
 
+// wake makes the poller look for work right away, without waiting for the
+// poll interval.
+func (e *TaskEngine) wake() {
+	select {
+	case e.wakeup <- struct{}{}:
+	default: // a wakeup is already pending
+	}
+}
+
+// SetPollInterval sets how often the database is checked for unclaimed
+// tasks (default POLL_DURATION). Tasks added or finished by this process wake
+// the poller right away, so the interval mostly bounds how long tasks added by
+// other machines wait to be claimed here. A short interval costs a few
+// queries per task type on every poll, across every machine in the cluster.
+func (e *TaskEngine) SetPollInterval(d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("poll interval must be positive, got %s", d)
+	}
+	e.pollInterval.Store(int64(d))
+	e.wake()
+	return nil
+}
+
+// EnableNotify makes the engine claim tasks added by other machines as soon
+// as they are added, rather than on its next poll. Added tasks are announced
+// with NOTIFY, and the machines running their type wake up to claim them;
+// claiming stays a conditional update, so only one of the woken machines
+// gets each task and the others go back to waiting. Announcements are only
+// sent by machines with notify enabled, so it should be enabled on all of
+// them. Polling carries on as the fallback for missed notifications. Fails
+// when the database doesn't support LISTEN, e.g. YugabyteDB.
+func (e *TaskEngine) EnableNotify() error {
+	err := e.store.Listen(e.ctx, func(name string) {
+		if _, ok := e.taskMap[name]; ok {
+			e.wake()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscribing to task notifications: %w", err)
+	}
+	e.notify.Store(true)
+	return nil
+}
+
+// PollInterval returns how often the database is checked for unclaimed tasks.
+func (e *TaskEngine) PollInterval() time.Duration {
+	return time.Duration(e.pollInterval.Load())
+}
+
+// Quiesce stops this machine from claiming new tasks. Tasks already running
+// are left to complete, and the machine is marked as draining in harmony_machines
+// so that orchestration can wait for it to go idle before taking it offline.
+func (e *TaskEngine) Quiesce(ctx context.Context) error {
+	return e.setQuiesced(ctx, true)
+}
+
+// Unquiesce resumes claiming new tasks after Quiesce.
+func (e *TaskEngine) Unquiesce(ctx context.Context) error {
+	return e.setQuiesced(ctx, false)
+}
+
+func (e *TaskEngine) setQuiesced(ctx context.Context, q bool) error {
+	err := e.store.SetDraining(ctx, e.ownerID, q)
+	if err != nil {
+		return fmt.Errorf("could not update draining state: %w", err)
+	}
+	e.quiesced.Store(q)
+	e.recordState()
+	log.Infow("task claiming state changed", "quiesced", q, "running", e.RunningCount())
+	return nil
+}
+
+// SetWeight declares this machine's capacity relative to the rest of the cluster.
+// A machine with weight 2 aims to own twice as many tasks of each type as a
+// machine with weight 1. When every machine has the same weight (default 1)
+// claiming is not biased at all.
+func (e *TaskEngine) SetWeight(ctx context.Context, weight int) error {
+	if weight < 1 {
+		return fmt.Errorf("weight must be at least 1, got %d", weight)
+	}
+	err := e.store.SetWeight(ctx, e.ownerID, weight)
+	if err != nil {
+		return fmt.Errorf("could not set weight: %w", err)
+	}
+	return nil
+}
+
+// SetIdentity records the name and labels of this machine in its
+// harmony_machines row, so that operators and dashboards can refer to it by
+// something more meaningful than its address. The name is recorded along
+// with the address in the history of the tasks the machine completes. Names
+// aren't required to be unique, another live machine with the same name is
+// only logged.
+func (e *TaskEngine) SetIdentity(ctx context.Context, name string, labels map[string]string) error {
+	err := e.store.SetIdentity(ctx, e.ownerID, name, labels)
+	if err != nil {
+		return fmt.Errorf("could not set identity: %w", err)
+	}
+
+	others, err := e.store.NamedMachines(ctx, name, e.ownerID, unresponsiveAfter)
+	if err != nil {
+		log.Warnw("could not check for other machines with the same name", "error", err)
+	} else if len(others) > 0 {
+		log.Warnw("other live machines report the same name", "name", name, "others", others)
+	}
+	return nil
+}
+
+// overWeightedShare reports if this machine already owns more than its
+// weighted share of the cluster's running tasks of the given type. Only the
+// machines which could take the task instead share it: the live ones running
+// the type which aren't draining.
+func (e *TaskEngine) overWeightedShare(name string) (bool, error) {
+	over, err := overWeightedShares(e.ctx, e.store, name)
+	if err != nil {
+		return false, err
+	}
+	return over[e.ownerID], nil
+}
+
+// overWeightedShares returns the machines which own more than their weighted
+// share of the cluster's running tasks of the given type.
+func overWeightedShares(ctx context.Context, store TaskStore, name string) (map[int]bool, error) {
+	loads, err := store.MachineLoads(ctx, name, unresponsiveAfter)
+	if err != nil {
+		return nil, err
+	}
+	return weightedOverShares(loads), nil
+}
+
+// weightedOverShares returns the machines which own more than their
+// weighted share of the tasks in loads.
+func weightedOverShares(loads []MachineLoad) map[int]bool {
+	var totalWeight, totalCount int
+	uniform := true
+	for _, l := range loads {
+		if l.Weight != loads[0].Weight {
+			uniform = false
+		}
+		totalWeight += l.Weight
+		totalCount += l.Count
+	}
+	over := map[int]bool{}
+	if uniform || totalWeight == 0 {
+		return over
+	}
+
+	for _, l := range loads {
+		// l.Count/totalCount > l.Weight/totalWeight, without the division
+		if l.Count*totalWeight > l.Weight*totalCount {
+			over[l.ID] = true
+		}
+	}
+	return over
+}
+
+// weightBackoff is how long a machine over its weighted share leaves tasks
+// to the others, given its poll interval: a few polls for them to take it.
+func weightBackoff(poll time.Duration) time.Duration {
+	if b := 4 * poll; b > WEIGHT_BACKOFF {
+		return b
+	}
+	return WEIGHT_BACKOFF
+}
+
+// IsQuiesced reports if this machine has stopped claiming new tasks.
+func (e *TaskEngine) IsQuiesced() bool {
+	return e.quiesced.Load()
+}
+
+// RunningCount returns the number of tasks currently running on this machine.
+func (e *TaskEngine) RunningCount() int {
+	var ct int
+	for _, h := range e.handlers {
+		ct += int(h.Count.Load())
+	}
+	return ct
+}
+
+// RunningCountOf returns the number of tasks of the given types currently
+// running on this machine.
+func (e *TaskEngine) RunningCountOf(names ...string) int {
+	var ct int
+	for _, h := range e.handlers {
+		for _, name := range names {
+			if h.Name == name {
+				ct += int(h.Count.Load())
+			}
+		}
+	}
+	return ct
+}
+
+func (e *TaskEngine) recordState() {
+	var q int64
+	if e.quiesced.Load() {
+		q = 1
+	}
+	var t int64
+	if e.terminating.Load() {
+		t = 1
+	}
+	stats.Record(e.ctx, TaskMeasures.Quiesced.M(q), TaskMeasures.ActiveTasks.M(int64(e.RunningCount())),
+		TaskMeasures.ShuttingDown.M(t))
+}
+
 // followWorkInDB implements "Follows"
 func (e *TaskEngine) followWorkInDB() {
 	// Step 1: What are we following?
@@ -231,18 +526,15 @@ func (e *TaskEngine) followWorkInDB() {
 	lastFollowTime, e.lastFollowTime = e.lastFollowTime, time.Now()
 
 	for fromName, srcs := range e.follows {
-		var cList []int // Which work is done (that we follow) since we last checked?
-		err := e.db.Select(e.ctx, &cList, `SELECT h.task_id FROM harmony_task_history 
-   		WHERE h.work_end>$1 AND h.name=$2`, lastFollowTime, fromName)
+		// Which work is done (that we follow) since we last checked?
+		cList, err := e.store.CompletedSince(e.ctx, fromName, lastFollowTime)
 		if err != nil {
 			log.Error("Could not query DB: ", err)
 			return
 		}
 		for _, src := range srcs {
 			for _, workAlreadyDone := range cList { // Were any tasks made to follow these tasks?
-				var ct int
-				err := e.db.QueryRow(e.ctx, `SELECT COUNT(*) FROM harmony_task 
-					WHERE name=$1 AND previous_task=$2`, src.h.Name, workAlreadyDone).Scan(&ct)
+				ct, err := e.store.CountFollowers(e.ctx, src.h.Name, workAlreadyDone)
 				if err != nil {
 					log.Error("Could not query harmony_task: ", err)
 					return // not recoverable here
@@ -251,7 +543,7 @@ func (e *TaskEngine) followWorkInDB() {
 					continue
 				}
 				// we need to create this task
-				b, err := src.h.Follows[fromName](TaskID(workAlreadyDone), src.h.AddTask)
+				b, err := src.h.Follows[fromName](workAlreadyDone, src.h.AddTask)
 				if err != nil {
 					log.Errorw("Could not follow: ", "error", err)
 					continue
@@ -269,17 +561,27 @@ func (e *TaskEngine) followWorkInDB() {
 func (e *TaskEngine) pollerTryAllWork() {
 	if time.Since(e.lastCleanup.Load().(time.Time)) > CLEANUP_FREQUENCY {
 		e.lastCleanup.Store(time.Now())
-		resources.CleanupMachines(e.ctx, e.db)
+		resources.CleanupMachines(e.ctx, e.store)
+	}
+	if time.Since(e.lastFitCheck) > FIT_CHECK_FREQUENCY {
+		e.lastFitCheck = time.Now()
+		e.checkFit()
 	}
 	for _, v := range e.handlers {
-		if v.AssertMachineHasCapacity() != nil {
+		if v.addOnly || v.AssertMachineHasCapacity() != nil {
+			continue
+		}
+		over, err := e.overWeightedShare(v.Name)
+		if err != nil {
+			log.Error("Unable to read cluster load ", err)
 			continue
 		}
-		var unownedTasks []TaskID
-		err := e.db.Select(e.ctx, &unownedTasks, `SELECT id 
-			FROM harmony_task
-			WHERE owner_id IS NULL AND name=$1
-			ORDER BY update_time`, v.Name)
+		// A machine over its weighted share only takes work nobody else took in time.
+		var backoff time.Duration
+		if over {
+			backoff = weightBackoff(e.PollInterval())
+		}
+		unownedTasks, err := e.store.UnclaimedTasks(e.ctx, v.Name, backoff)
 		if err != nil {
 			log.Error("Unable to read work ", err)
 			continue
@@ -296,12 +598,18 @@ func (e *TaskEngine) pollerTryAllWork() {
 
 // ResourcesAvailable determines what resources are still unassigned.
 func (e *TaskEngine) ResourcesAvailable() resources.Resources {
+	e.resLk.Lock()
 	tmp := e.reg.Resources
+	e.resLk.Unlock()
 	for _, t := range e.handlers {
 		ct := t.Count.Load()
 		tmp.Cpu -= int(ct) * t.Cost.Cpu
 		tmp.Gpu -= float64(ct) * t.Cost.Gpu
-		tmp.Ram -= uint64(ct) * t.Cost.Ram
+		if ram := uint64(ct) * t.Cost.Ram; ram < tmp.Ram {
+			tmp.Ram -= ram
+		} else {
+			tmp.Ram = 0 // lowered by SetResources below what is running
+		}
 	}
 	return tmp
 }
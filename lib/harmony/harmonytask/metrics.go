@@ -0,0 +1,38 @@
+package harmonytask
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "harmonytask_"
+
+// TaskMeasures groups all harmonytask metrics.
+var TaskMeasures = struct {
+	Quiesced     *stats.Int64Measure
+	ActiveTasks  *stats.Int64Measure
+	ShuttingDown *stats.Int64Measure
+}{
+	Quiesced:     stats.Int64(pre+"quiesced", "1 if this node has stopped claiming new tasks, 0 otherwise.", stats.UnitDimensionless),
+	ActiveTasks:  stats.Int64(pre+"active_tasks", "Number of tasks currently running on this node.", stats.UnitDimensionless),
+	ShuttingDown: stats.Int64(pre+"shutting_down", "1 if this node is waiting for running tasks to finish before exiting, 0 otherwise.", stats.UnitDimensionless),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     TaskMeasures.Quiesced,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Measure:     TaskMeasures.ActiveTasks,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Measure:     TaskMeasures.ShuttingDown,
+			Aggregation: view.LastValue(),
+		},
+	)
+}
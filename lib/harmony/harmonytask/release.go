@@ -0,0 +1,98 @@
+package harmonytask
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+// ErrOwnerAlive is returned by Release when the owner of the task is still
+// heartbeating and the release isn't forced.
+var ErrOwnerAlive = errors.New("the owner of the task is heartbeating")
+
+// Released describes a task released by Release.
+type Released struct {
+	Name  string
+	Owner string
+	// OwnerName is the name the owner reported, see SetIdentity, empty when
+	// it had left the cluster
+	OwnerName string
+	// OwnerContact is the time since the last heartbeat of the owner, zero
+	// when the owner had left the cluster
+	OwnerContact time.Duration
+	Forced       bool
+}
+
+// Release clears the owner of a claimed task, so that any machine running
+// its type can claim it again, without waiting for the owner to be reaped.
+// The owner must look dead, having missed its heartbeats for
+// unresponsiveAfter or having left the cluster, unless force is set. A forced
+// release of a live owner lets the task run twice; the owner's stillOwned
+// turns false, but work the owner already did isn't undone. The release is
+// recorded in harmony_task_release, with by as the operator releasing it.
+func Release(ctx context.Context, db harmonydb.Interface, id TaskID, force bool, by string) (Released, error) {
+	var out Released
+	_, err := db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		var owner *int
+		var host, name *string
+		var contactMs *int64
+		err := tx.QueryRow(`SELECT t.name, t.owner_id, m.host_and_port, m.name,
+				(EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - m.last_contact) * 1000)::bigint
+			FROM harmony_task t LEFT JOIN harmony_machines m ON m.id = t.owner_id WHERE t.id = $1`, id).
+			Scan(&out.Name, &owner, &host, &name, &contactMs)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, fmt.Errorf("task %d isn't queued, it completed or was dropped", id)
+		}
+		if err != nil {
+			return false, fmt.Errorf("reading task: %w", err)
+		}
+		if owner == nil {
+			return false, fmt.Errorf("%s task %d isn't claimed by any machine", out.Name, id)
+		}
+
+		out.Owner = fmt.Sprintf("machine %d", *owner)
+		if host != nil {
+			out.Owner = *host
+		}
+		if name != nil {
+			out.OwnerName = *name
+		}
+		if contactMs != nil {
+			out.OwnerContact = time.Duration(*contactMs) * time.Millisecond
+		}
+
+		alive := contactMs != nil && out.OwnerContact <= unresponsiveAfter
+		if alive && !force {
+			return false, fmt.Errorf("%s task %d: %w (last heartbeat %s ago), use --force to release it anyway",
+				out.Name, id, ErrOwnerAlive, out.OwnerContact.Round(time.Second))
+		}
+		out.Forced = alive
+
+		// only release the owner read above, the task may have finished or
+		// been reaped since
+		ct, err := tx.Exec(`UPDATE harmony_task SET owner_id=NULL WHERE id=$1 AND owner_id=$2`, id, *owner)
+		if err != nil {
+			return false, fmt.Errorf("releasing task: %w", err)
+		}
+		if ct == 0 {
+			return false, fmt.Errorf("%s task %d changed owner while releasing it, check it again", out.Name, id)
+		}
+
+		_, err = tx.Exec(`INSERT INTO harmony_task_release
+				(task_id, name, owner_id, owner_host_and_port, owner_name, owner_contact_age_ms, forced, released_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, id, out.Name, *owner, host, name, contactMs, out.Forced, by)
+		if err != nil {
+			return false, fmt.Errorf("recording release: %w", err)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return Released{}, err
+	}
+	return out, nil
+}
@@ -0,0 +1,66 @@
+package harmonytask
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	const readTask = `FROM harmony_task t LEFT JOIN harmony_machines m ON m.id = t.owner_id WHERE t.id = $1`
+
+	// the owner missed its heartbeats
+	db.ExpectQueryRow(readTask).WithArgs(TaskID(5)).WillReturnRows([]any{"WdPost", 3, "node-a:12300", "node-a", int64(5 * 60 * 1000)})
+	db.ExpectExec(`UPDATE harmony_task SET owner_id=NULL WHERE id=$1 AND owner_id=$2`).WithArgs(TaskID(5), 3).WillReturnCount(1)
+	db.ExpectExec(`INSERT INTO harmony_task_release`).
+		WithArgs(TaskID(5), "WdPost", 3, harmonydb.MockAnyArg, harmonydb.MockAnyArg, harmonydb.MockAnyArg, false, "alice@ops").WillReturnCount(1)
+
+	rel, err := Release(ctx, db, 5, false, "alice@ops")
+	require.NoError(t, err)
+	require.Equal(t, Released{Name: "WdPost", Owner: "node-a:12300", OwnerName: "node-a", OwnerContact: 5 * time.Minute}, rel)
+
+	// the owner is heartbeating
+	db.ExpectQueryRow(readTask).WillReturnRows([]any{"WdPost", 4, "node-b:12300", nil, int64(20 * 1000)})
+	_, err = Release(ctx, db, 6, false, "alice@ops")
+	require.ErrorIs(t, err, ErrOwnerAlive)
+
+	db.ExpectQueryRow(readTask).WillReturnRows([]any{"WdPost", 4, "node-b:12300", nil, int64(20 * 1000)})
+	db.ExpectExec(`UPDATE harmony_task SET owner_id=NULL`).WillReturnCount(1)
+	db.ExpectExec(`INSERT INTO harmony_task_release`).
+		WithArgs(TaskID(6), "WdPost", 4, harmonydb.MockAnyArg, harmonydb.MockAnyArg, harmonydb.MockAnyArg, true, "alice@ops").WillReturnCount(1)
+	rel, err = Release(ctx, db, 6, true, "alice@ops")
+	require.NoError(t, err)
+	require.True(t, rel.Forced)
+
+	// the owner left the cluster, its machine row is gone
+	db.ExpectQueryRow(readTask).WillReturnRows([]any{"WinPost", 9, nil, nil, nil})
+	db.ExpectExec(`UPDATE harmony_task SET owner_id=NULL`).WillReturnCount(1)
+	db.ExpectExec(`INSERT INTO harmony_task_release`).WillReturnCount(1)
+	rel, err = Release(ctx, db, 7, false, "alice@ops")
+	require.NoError(t, err)
+	require.Equal(t, Released{Name: "WinPost", Owner: "machine 9"}, rel)
+
+	// the task finished while releasing it
+	db.ExpectQueryRow(readTask).WillReturnRows([]any{"WinPost", 9, nil, nil, nil})
+	db.ExpectExec(`UPDATE harmony_task SET owner_id=NULL`).WillReturnCount(0)
+	_, err = Release(ctx, db, 7, false, "alice@ops")
+	require.ErrorContains(t, err, "changed owner")
+
+	// unclaimed and missing tasks
+	db.ExpectQueryRow(readTask).WillReturnRows([]any{"WdPost", nil, nil, nil, nil})
+	_, err = Release(ctx, db, 8, false, "alice@ops")
+	require.ErrorContains(t, err, "isn't claimed")
+
+	db.ExpectQueryRow(readTask).WillReturnRows()
+	_, err = Release(ctx, db, 9, false, "alice@ops")
+	require.ErrorContains(t, err, "isn't queued")
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
@@ -0,0 +1,142 @@
+package harmonytask
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Consts (except for unit test)
+var RUN_LOG_MAX_BYTES = 16 << 10   // Cap on the stored execution log of one task run
+var RUN_LOG_MAX_VALUE_BYTES = 1024 // Messages and values are cut to this length
+
+// RunLogEntry is an event in the execution log of a task run.
+type RunLogEntry struct {
+	Time   time.Time      `json:"t"`
+	Msg    string         `json:"msg"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// runLog collects the execution log of a task run on this node, until it is
+// stored with the run's harmony_task_history row.
+type runLog struct {
+	lk      sync.Mutex
+	entries []RunLogEntry
+	size    int
+	dropped int
+}
+
+// runningLogs holds the execution log of each task running on this node.
+var runningLogs sync.Map // TaskID -> *runLog
+
+// Logw records an event in the execution log of the running task id. The
+// log is stored with the task history when the run ends, so that the runs of
+// every machine can be inspected from one place (lotus-provider tasks log).
+// Key-value pairs are given as with zap's Infow. Task implementations should
+// log the few events which explain a failure, not progress; once a run's log
+// is full further events are only counted. Calls for tasks not running on
+// this node are ignored.
+func Logw(id TaskID, msg string, keysAndValues ...any) {
+	if rl, ok := runningLogs.Load(id); ok {
+		rl.(*runLog).add(newRunLogEntry(msg, keysAndValues), false)
+	}
+}
+
+func startRunLog(id TaskID) *runLog {
+	rl := &runLog{}
+	runningLogs.Store(id, rl)
+	return rl
+}
+
+// finish records the final event, which is always kept, and returns the log
+// as JSON.
+func (rl *runLog) finish(id TaskID, final RunLogEntry) []byte {
+	runningLogs.Delete(id)
+
+	rl.lk.Lock()
+	if rl.dropped > 0 {
+		rl.entries = append(rl.entries, RunLogEntry{
+			Time: final.Time,
+			Msg:  fmt.Sprintf("log truncated, %d events dropped", rl.dropped),
+		})
+	}
+	rl.lk.Unlock()
+	rl.add(final, true)
+
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	out, err := json.Marshal(rl.entries)
+	if err != nil {
+		log.Errorw("marshaling task run log", "id", id, "error", err)
+		return nil
+	}
+	return out
+}
+
+func (rl *runLog) add(e RunLogEntry, always bool) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		// a value which doesn't marshal despite being flattened, keep the event
+		e.Fields = map[string]any{"error": "unencodable fields: " + err.Error()}
+		b, _ = json.Marshal(e)
+	}
+
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	if !always && rl.size+len(b) > RUN_LOG_MAX_BYTES {
+		rl.dropped++
+		return
+	}
+	rl.size += len(b)
+	rl.entries = append(rl.entries, e)
+}
+
+func newRunLogEntry(msg string, keysAndValues []any) RunLogEntry {
+	e := RunLogEntry{Time: time.Now(), Msg: truncateRunLog(msg)}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if e.Fields == nil {
+			e.Fields = map[string]any{}
+		}
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 >= len(keysAndValues) {
+			e.Fields[key] = nil
+			break
+		}
+		e.Fields[key] = runLogValue(keysAndValues[i+1])
+	}
+	return e
+}
+
+// runLogValue keeps values which encode as JSON scalars, and flattens the
+// rest to strings.
+func runLogValue(v any) any {
+	switch v := v.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case string:
+		return truncateRunLog(v)
+	case error:
+		return truncateRunLog(v.Error())
+	case fmt.Stringer:
+		return truncateRunLog(v.String())
+	default:
+		return truncateRunLog(fmt.Sprintf("%v", v))
+	}
+}
+
+func truncateRunLog(s string) string {
+	if len(s) <= RUN_LOG_MAX_VALUE_BYTES {
+		return s
+	}
+	return s[:RUN_LOG_MAX_VALUE_BYTES] + "...(truncated)"
+}
+
+// runLogText is the run_log column value of a marshaled log.
+func runLogText(b []byte) *string {
+	if b == nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
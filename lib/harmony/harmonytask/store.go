@@ -0,0 +1,135 @@
+package harmonytask
+
+import (
+	"context"
+	"time"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+)
+
+// TaskStore persists the tasks of the cluster and the machines running them.
+// The engine reaches its backend only through it, so that a backend other
+// than Postgres can be plugged in with NewWithStore. PostgresStore, which New
+// uses, is the default.
+//
+// For now the interface is still bound to Postgres in one place: AddTask
+// passes a *harmonydb.Tx to the extra info callbacks, as the task types
+// record their extra info in HarmonyDB tables in the transaction adding the
+// task. Other backends have to provide one.
+//
+// Operations are called from multiple goroutines and of multiple machines
+// at once; the ones changing the owner of a task must be atomic, as they
+// are what keeps a task from running on two machines.
+type TaskStore interface {
+	resources.Registrar
+
+	// RegisterImpls replaces the task types recorded for the machine with
+	// impls, see TaskEngine.registerImpls.
+	RegisterImpls(ctx context.Context, machineID int, impls []TaskImpl) error
+	// SetResources records the resources declared by the machine.
+	SetResources(ctx context.Context, machineID int, res resources.Resources) error
+	// SetDraining records if the machine stopped claiming tasks.
+	SetDraining(ctx context.Context, machineID int, draining bool) error
+	// SetWeight records the capacity of the machine relative to the others.
+	SetWeight(ctx context.Context, machineID, weight int) error
+	// SetIdentity records the name and labels of the machine.
+	SetIdentity(ctx context.Context, machineID int, name string, labels map[string]string) error
+	// NamedMachines returns the host and port of the machines other than
+	// except named name, which were in contact within liveWithin.
+	NamedMachines(ctx context.Context, name string, except int, liveWithin time.Duration) ([]string, error)
+	// MachineLoads returns the weight of each machine which runs the task
+	// type, isn't draining and was in contact within liveWithin, along with
+	// how many tasks of the type it owns.
+	MachineLoads(ctx context.Context, name string, liveWithin time.Duration) ([]MachineLoad, error)
+	// ImplMachines counts the machines running the task type with at least
+	// the given resources; zero resources count all of them.
+	ImplMachines(ctx context.Context, name string, min resources.Resources) (int, error)
+
+	// AddTask adds an unclaimed task of the type, and calls extra in the
+	// same transaction to record the extra info of the task. It reports
+	// false when the task wasn't added, because extra didn't commit or the
+	// task exists already. The transaction is a HarmonyDB one, this is
+	// Postgres-bound, see TaskStore.
+	AddTask(ctx context.Context, name string, addedBy int, extra func(TaskID, *harmonydb.Tx) (bool, error)) (bool, error)
+	// Notify announces an added task of the type to the machines listening.
+	Notify(ctx context.Context, name string) error
+	// Listen calls cb with the type of each task announced with Notify until
+	// ctx is done. It fails when the backend can't announce tasks.
+	Listen(ctx context.Context, cb func(name string)) error
+	// Claim makes the machine the owner of the unclaimed task. With a
+	// clusterMax above zero, the task is only claimed while less than
+	// clusterMax tasks of the type are claimed across the cluster.
+	Claim(ctx context.Context, id TaskID, name string, owner, clusterMax int) (ClaimResult, error)
+	// Owner returns the machine owning the task, 0 when it's unclaimed.
+	Owner(ctx context.Context, id TaskID) (int, error)
+	// Disown makes the task unclaimed.
+	Disown(ctx context.Context, id TaskID) error
+	// Complete records the outcome of running a task in its history, and
+	// removes the task or makes it unclaimed again, see Completion.
+	Complete(ctx context.Context, c Completion) error
+
+	// OwnedTasks returns the tasks the machine owns.
+	OwnedTasks(ctx context.Context, owner int) ([]OwnedTask, error)
+	// UnclaimedTasks returns the unclaimed tasks of the type which weren't
+	// updated within minAge, oldest first.
+	UnclaimedTasks(ctx context.Context, name string, minAge time.Duration) ([]TaskID, error)
+	// CountUnclaimed counts the unclaimed tasks of the type.
+	CountUnclaimed(ctx context.Context, name string) (int, error)
+	// CompletedSince returns the tasks of the type completed after since.
+	CompletedSince(ctx context.Context, name string, since time.Time) ([]TaskID, error)
+	// CountFollowers counts the tasks of the type added to follow previous.
+	CountFollowers(ctx context.Context, name string, previous TaskID) (int, error)
+}
+
+// TaskImpl is a task type as a machine runs it.
+type TaskImpl struct {
+	Name       string
+	Cost       resources.Resources
+	Max        int
+	ClusterMax int
+}
+
+// OwnedTask is a task claimed by a machine.
+type OwnedTask struct {
+	ID   int
+	Name string
+}
+
+// MachineLoad is the weight of a machine with the count of the tasks of a
+// type it owns.
+type MachineLoad struct {
+	ID     int
+	Weight int
+	Count  int
+}
+
+type ClaimResult int
+
+const (
+	ClaimOK ClaimResult = iota
+	ClaimTaken
+	ClaimAtClusterMax
+)
+
+// Completion is the outcome of running a task. A done task is removed. A
+// task which isn't done is removed when Drop is set, or when it had failed
+// MaxFailures times before this run, or else made unclaimed to be retried.
+type Completion struct {
+	ID   TaskID
+	Name string
+
+	By            int
+	ByHostAndPort string
+
+	Start, End time.Time
+
+	Done        bool
+	Drop        bool
+	MaxFailures uint
+
+	// Result is empty for done tasks, the error otherwise
+	Result   string
+	ErrClass *string
+	RunLog   []byte
+}
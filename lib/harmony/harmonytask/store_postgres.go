@@ -0,0 +1,327 @@
+package harmonytask
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+)
+
+// PostgresStore is the TaskStore keeping tasks in the harmony_* tables of
+// HarmonyDB. Notify and Listen need a *harmonydb.DB, with another
+// harmonydb.Interface they fail.
+type PostgresStore struct {
+	db harmonydb.Interface
+}
+
+func NewPostgresStore(db harmonydb.Interface) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+var _ TaskStore = &PostgresStore{}
+
+type notifier interface {
+	Notify(ctx context.Context, channel, payload string) error
+	Listen(ctx context.Context, channel string, cb func(payload string)) error
+}
+
+func (s *PostgresStore) RegisterMachine(ctx context.Context, hostAndPort string, res resources.Resources) (int, error) {
+	var ownerID *int
+
+	// Upsert query with last_contact update, fetch the machine ID
+	// (note this isn't a simple insert .. on conflict because host_and_port isn't unique)
+	err := s.db.QueryRow(ctx, `
+		WITH upsert AS (
+			UPDATE harmony_machines
+			SET cpu = $2, ram = $3, gpu = $4, last_contact = CURRENT_TIMESTAMP, draining = FALSE
+			WHERE host_and_port = $1
+			RETURNING id
+		),
+		inserted AS (
+			INSERT INTO harmony_machines (host_and_port, cpu, ram, gpu, last_contact)
+			SELECT $1, $2, $3, $4, CURRENT_TIMESTAMP
+			WHERE NOT EXISTS (SELECT id FROM upsert)
+			RETURNING id
+		)
+		SELECT id FROM upsert
+		UNION ALL
+		SELECT id FROM inserted;
+	`, hostAndPort, res.Cpu, res.Ram, res.Gpu).Scan(&ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if ownerID == nil {
+		return 0, fmt.Errorf("no owner id")
+	}
+	return *ownerID, nil
+}
+
+func (s *PostgresStore) Heartbeat(ctx context.Context, machineID int) error {
+	_, err := s.db.Exec(ctx, `UPDATE harmony_machines SET last_contact=CURRENT_TIMESTAMP WHERE id=$1`, machineID)
+	return err
+}
+
+func (s *PostgresStore) CleanupMachines(ctx context.Context, deadAfter time.Duration) (int, error) {
+	return s.db.Exec(ctx,
+		`DELETE FROM harmony_machines WHERE last_contact < CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $1 `,
+		deadAfter.Milliseconds()) // ms enables unit testing to change timeout.
+}
+
+func (s *PostgresStore) RegisterImpls(ctx context.Context, machineID int, impls []TaskImpl) error {
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		if _, err := tx.Exec(`DELETE FROM harmony_task_impl WHERE owner_id=$1`, machineID); err != nil {
+			return false, fmt.Errorf("clearing task types: %w", err)
+		}
+		for _, i := range impls {
+			if _, err := tx.Exec(`INSERT INTO harmony_task_impl (owner_id, name, cpu, ram, gpu, max_tasks, cluster_max) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				machineID, i.Name, i.Cost.Cpu, i.Cost.Ram, i.Cost.Gpu, i.Max, i.ClusterMax); err != nil {
+				return false, fmt.Errorf("inserting task type %s: %w", i.Name, err)
+			}
+			if i.ClusterMax > 0 {
+				if _, err := tx.Exec(`INSERT INTO harmony_task_cluster_claim (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, i.Name); err != nil {
+					return false, fmt.Errorf("inserting cluster claim row of %s: %w", i.Name, err)
+				}
+			}
+		}
+		return true, nil
+	})
+	return err
+}
+
+func (s *PostgresStore) SetResources(ctx context.Context, machineID int, res resources.Resources) error {
+	_, err := s.db.Exec(ctx, `UPDATE harmony_machines SET cpu=$1, ram=$2, gpu=$3 WHERE id=$4`, res.Cpu, res.Ram, res.Gpu, machineID)
+	return err
+}
+
+func (s *PostgresStore) SetDraining(ctx context.Context, machineID int, draining bool) error {
+	_, err := s.db.Exec(ctx, `UPDATE harmony_machines SET draining=$1 WHERE id=$2`, draining, machineID)
+	return err
+}
+
+func (s *PostgresStore) SetWeight(ctx context.Context, machineID, weight int) error {
+	_, err := s.db.Exec(ctx, `UPDATE harmony_machines SET weight=$1 WHERE id=$2`, weight, machineID)
+	return err
+}
+
+func (s *PostgresStore) SetIdentity(ctx context.Context, machineID int, name string, labels map[string]string) error {
+	lb, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("encoding labels: %w", err)
+	}
+	_, err = s.db.Exec(ctx, `UPDATE harmony_machines SET name=$1, labels=$2 WHERE id=$3`, name, string(lb), machineID)
+	return err
+}
+
+func (s *PostgresStore) NamedMachines(ctx context.Context, name string, except int, liveWithin time.Duration) ([]string, error) {
+	var others []string
+	err := s.db.Select(ctx, &others, `SELECT host_and_port FROM harmony_machines
+		WHERE name=$1 AND id<>$2 AND last_contact > CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $3`,
+		name, except, liveWithin.Milliseconds())
+	return others, err
+}
+
+func (s *PostgresStore) MachineLoads(ctx context.Context, name string, liveWithin time.Duration) ([]MachineLoad, error) {
+	var loads []MachineLoad
+	err := s.db.Select(ctx, &loads, `SELECT m.id, m.weight, COUNT(t.id) AS count
+		FROM harmony_machines m
+		JOIN harmony_task_impl i ON i.owner_id = m.id AND i.name = $1
+		LEFT JOIN harmony_task t ON t.owner_id = m.id AND t.name = $1
+		WHERE NOT m.draining AND m.last_contact > CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $2
+		GROUP BY m.id, m.weight`, name, liveWithin.Milliseconds())
+	return loads, err
+}
+
+func (s *PostgresStore) ImplMachines(ctx context.Context, name string, min resources.Resources) (int, error) {
+	var machines int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*)
+		FROM harmony_machines m
+		JOIN harmony_task_impl i ON i.owner_id = m.id
+		WHERE i.name = $1 AND m.cpu >= $2 AND m.ram >= $3 AND m.gpu >= $4`,
+		name, min.Cpu, min.Ram, min.Gpu).Scan(&machines)
+	return machines, err
+}
+
+func (s *PostgresStore) AddTask(ctx context.Context, name string, addedBy int, extra func(TaskID, *harmonydb.Tx) (bool, error)) (bool, error) {
+	var tID TaskID
+	added, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		// create taskID (from DB)
+		_, err := tx.Exec(`INSERT INTO harmony_task (name, added_by, posted_time)
+			VALUES ($1, $2, CURRENT_TIMESTAMP) `, name, addedBy)
+		if err != nil {
+			return false, fmt.Errorf("could not insert into harmonyTask: %w", err)
+		}
+		err = tx.QueryRow("SELECT id FROM harmony_task ORDER BY update_time DESC LIMIT 1").Scan(&tID)
+		if err != nil {
+			return false, fmt.Errorf("Could not select ID: %v", err)
+		}
+		return extra(tID, tx)
+	})
+	if harmonydb.IsErrUniqueContraint(err) {
+		return false, nil
+	}
+	return added, err
+}
+
+func (s *PostgresStore) Notify(ctx context.Context, name string) error {
+	n, ok := s.db.(notifier)
+	if !ok {
+		return fmt.Errorf("database doesn't support notifications")
+	}
+	return n.Notify(ctx, notifyChannel, name)
+}
+
+func (s *PostgresStore) Listen(ctx context.Context, cb func(name string)) error {
+	n, ok := s.db.(notifier)
+	if !ok {
+		return fmt.Errorf("database doesn't support notifications")
+	}
+	return n.Listen(ctx, notifyChannel, cb)
+}
+
+// Claim with a clusterMax counts the claimed tasks of the type in the same
+// transaction as the claim. The transaction first updates the row of the
+// type in harmony_task_cluster_claim, which makes concurrent claims of the
+// type wait on each other, or fail to commit where the database doesn't wait
+// on row locks, so two machines can't both see the last free slot.
+func (s *PostgresStore) Claim(ctx context.Context, id TaskID, name string, owner, clusterMax int) (ClaimResult, error) {
+	if clusterMax <= 0 {
+		ct, err := s.db.Exec(ctx, "UPDATE harmony_task SET owner_id=$1 WHERE id=$2 AND owner_id IS NULL", owner, id)
+		if err != nil {
+			return 0, err
+		}
+		if ct == 0 {
+			return ClaimTaken, nil
+		}
+		return ClaimOK, nil
+	}
+
+	res := ClaimTaken
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		if _, err := tx.Exec(`UPDATE harmony_task_cluster_claim SET last_claim=CURRENT_TIMESTAMP WHERE name=$1`, name); err != nil {
+			return false, fmt.Errorf("locking cluster claims of %s: %w", name, err)
+		}
+		var running int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM harmony_task WHERE name=$1 AND owner_id IS NOT NULL`, name).Scan(&running); err != nil {
+			return false, fmt.Errorf("counting claimed %s tasks: %w", name, err)
+		}
+		if running >= clusterMax {
+			res = ClaimAtClusterMax
+			return false, nil
+		}
+		ct, err := tx.Exec(`UPDATE harmony_task SET owner_id=$1 WHERE id=$2 AND owner_id IS NULL`, owner, id)
+		if err != nil {
+			return false, err
+		}
+		if ct == 0 {
+			return false, nil
+		}
+		res = ClaimOK
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res, nil
+}
+
+func (s *PostgresStore) Owner(ctx context.Context, id TaskID) (int, error) {
+	var owner *int
+	err := s.db.QueryRow(ctx, `SELECT owner_id FROM harmony_task WHERE id=$1`, id).Scan(&owner)
+	if err != nil || owner == nil {
+		return 0, err
+	}
+	return *owner, nil
+}
+
+func (s *PostgresStore) Disown(ctx context.Context, id TaskID) error {
+	_, err := s.db.Exec(ctx, `UPDATE harmony_task SET owner_id=NULL WHERE id=$1`, id)
+	return err
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, c Completion) error {
+	_, err := s.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (bool, error) {
+		var postedTime time.Time
+		err := tx.QueryRow(`SELECT posted_time FROM harmony_task WHERE id=$1`, c.ID).Scan(&postedTime)
+		if err != nil {
+			return false, fmt.Errorf("could not log completion: %w ", err)
+		}
+		if c.Done {
+			_, err = tx.Exec("DELETE FROM harmony_task WHERE id=$1", c.ID)
+			if err != nil {
+				return false, fmt.Errorf("could not log completion: %w", err)
+			}
+		} else {
+			deleteTask := c.Drop
+			if !deleteTask && c.MaxFailures > 0 {
+				ct := uint(0)
+				err = tx.QueryRow(`SELECT count(*) FROM harmony_task_history
+				WHERE task_id=$1 AND result=FALSE`, c.ID).Scan(&ct)
+				if err != nil {
+					return false, fmt.Errorf("could not read task history: %w", err)
+				}
+				if ct >= c.MaxFailures {
+					deleteTask = true
+				}
+			}
+			if deleteTask {
+				_, err = tx.Exec("DELETE FROM harmony_task WHERE id=$1", c.ID)
+				if err != nil {
+					return false, fmt.Errorf("could not delete failed job: %w", err)
+				}
+				// Note: Extra Info is left laying around for later review & clean-up
+			} else {
+				_, err := tx.Exec(`UPDATE harmony_task SET owner_id=NULL WHERE id=$1`, c.ID)
+				if err != nil {
+					return false, fmt.Errorf("could not disown failed task: %v %v", c.ID, err)
+				}
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO harmony_task_history
+									 (task_id,   name, posted,    work_start, work_end, result, completed_by_host_and_port,      err, err_class, run_log, completed_by_name)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, (SELECT name FROM harmony_machines WHERE id=$11))`, c.ID, c.Name, postedTime, c.Start, c.End, c.Done, c.ByHostAndPort, c.Result, c.ErrClass, runLogText(c.RunLog), c.By)
+		if err != nil {
+			return false, fmt.Errorf("could not write history: %w", err)
+		}
+		return true, nil
+	})
+	return err
+}
+
+func (s *PostgresStore) OwnedTasks(ctx context.Context, owner int) ([]OwnedTask, error) {
+	var tasks []OwnedTask
+	err := s.db.Select(ctx, &tasks, `SELECT id, name from harmony_task WHERE owner_id=$1`, owner)
+	return tasks, err
+}
+
+func (s *PostgresStore) UnclaimedTasks(ctx context.Context, name string, minAge time.Duration) ([]TaskID, error) {
+	var ids []TaskID
+	err := s.db.Select(ctx, &ids, `SELECT id
+		FROM harmony_task
+		WHERE owner_id IS NULL AND name=$1
+		  AND update_time <= CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $2
+		ORDER BY update_time`, name, minAge.Milliseconds())
+	return ids, err
+}
+
+func (s *PostgresStore) CountUnclaimed(ctx context.Context, name string) (int, error) {
+	var queued int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM harmony_task WHERE owner_id IS NULL AND name=$1`, name).Scan(&queued)
+	return queued, err
+}
+
+func (s *PostgresStore) CompletedSince(ctx context.Context, name string, since time.Time) ([]TaskID, error) {
+	var ids []TaskID
+	err := s.db.Select(ctx, &ids, `SELECT h.task_id FROM harmony_task_history h
+		WHERE h.work_end>$1 AND h.name=$2`, since, name)
+	return ids, err
+}
+
+func (s *PostgresStore) CountFollowers(ctx context.Context, name string, previous TaskID) (int, error) {
+	var ct int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM harmony_task
+		WHERE name=$1 AND previous_task=$2`, name, previous).Scan(&ct)
+	return ct, err
+}
@@ -0,0 +1,221 @@
+package harmonytask
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+func TestPostgresStoreClaim(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	var s TaskStore = NewPostgresStore(db)
+
+	const claim = `UPDATE harmony_task SET owner_id=$1 WHERE id=$2 AND owner_id IS NULL`
+
+	db.ExpectExec(claim).WithArgs(3, TaskID(10)).WillReturnCount(1)
+	res, err := s.Claim(ctx, 10, "WdPost", 3, 0)
+	require.NoError(t, err)
+	require.Equal(t, ClaimOK, res)
+
+	db.ExpectExec(claim).WithArgs(3, TaskID(11)).WillReturnCount(0)
+	res, err = s.Claim(ctx, 11, "WdPost", 3, 0)
+	require.NoError(t, err)
+	require.Equal(t, ClaimTaken, res)
+
+	// with a cluster max, claimed tasks are counted first
+	const lock = `UPDATE harmony_task_cluster_claim SET last_claim=CURRENT_TIMESTAMP WHERE name=$1`
+	const count = `SELECT COUNT(*) FROM harmony_task WHERE name=$1 AND owner_id IS NOT NULL`
+
+	db.ExpectExec(lock).WithArgs("WdPost").WillReturnCount(1)
+	db.ExpectQueryRow(count).WithArgs("WdPost").WillReturnRows([]any{2})
+	res, err = s.Claim(ctx, 12, "WdPost", 3, 2)
+	require.NoError(t, err)
+	require.Equal(t, ClaimAtClusterMax, res)
+
+	db.ExpectExec(lock).WillReturnCount(1)
+	db.ExpectQueryRow(count).WillReturnRows([]any{1})
+	db.ExpectExec(claim).WithArgs(3, TaskID(12)).WillReturnCount(1)
+	res, err = s.Claim(ctx, 12, "WdPost", 3, 2)
+	require.NoError(t, err)
+	require.Equal(t, ClaimOK, res)
+
+	db.ExpectExec(lock).WillReturnCount(1)
+	db.ExpectQueryRow(count).WillReturnRows([]any{1})
+	db.ExpectExec(claim).WithArgs(3, TaskID(13)).WillReturnCount(0)
+	res, err = s.Claim(ctx, 13, "WdPost", 3, 2)
+	require.NoError(t, err)
+	require.Equal(t, ClaimTaken, res)
+
+	// ownership
+	db.ExpectQueryRow(`SELECT owner_id FROM harmony_task WHERE id=$1`).WithArgs(TaskID(12)).WillReturnRows([]any{3})
+	owner, err := s.Owner(ctx, 12)
+	require.NoError(t, err)
+	require.Equal(t, 3, owner)
+
+	db.ExpectQueryRow(`SELECT owner_id FROM harmony_task WHERE id=$1`).WillReturnRows([]any{nil})
+	owner, err = s.Owner(ctx, 14)
+	require.NoError(t, err)
+	require.Zero(t, owner)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestPostgresStoreComplete(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	var s TaskStore = NewPostgresStore(db)
+
+	const posted = `SELECT posted_time FROM harmony_task WHERE id=$1`
+	const failures = `SELECT count(*) FROM harmony_task_history WHERE task_id=$1 AND result=FALSE`
+	const history = `INSERT INTO harmony_task_history`
+
+	postedAt := time.Now().Add(-time.Hour)
+	c := Completion{
+		ID:            5,
+		Name:          "WdPost",
+		By:            3,
+		ByHostAndPort: "node-a:12300",
+		Start:         postedAt.Add(time.Minute),
+		End:           postedAt.Add(2 * time.Minute),
+		MaxFailures:   2,
+	}
+
+	// done tasks are removed
+	done := c
+	done.Done = true
+	db.ExpectQueryRow(posted).WithArgs(TaskID(5)).WillReturnRows([]any{postedAt})
+	db.ExpectExec(`DELETE FROM harmony_task WHERE id=$1`).WithArgs(TaskID(5)).WillReturnCount(1)
+	db.ExpectExec(history).WithArgs(TaskID(5), "WdPost", postedAt, done.Start, done.End, true, "node-a:12300",
+		"", harmonydb.MockAnyArg, harmonydb.MockAnyArg, 3).WillReturnCount(1)
+	require.NoError(t, s.Complete(ctx, done))
+
+	// failed tasks are retried until they failed MaxFailures times
+	failed := c
+	failed.Result = "error: boom"
+	db.ExpectQueryRow(posted).WillReturnRows([]any{postedAt})
+	db.ExpectQueryRow(failures).WithArgs(TaskID(5)).WillReturnRows([]any{uint(1)})
+	db.ExpectExec(`UPDATE harmony_task SET owner_id=NULL WHERE id=$1`).WithArgs(TaskID(5)).WillReturnCount(1)
+	db.ExpectExec(history).WillReturnCount(1)
+	require.NoError(t, s.Complete(ctx, failed))
+
+	db.ExpectQueryRow(posted).WillReturnRows([]any{postedAt})
+	db.ExpectQueryRow(failures).WillReturnRows([]any{uint(2)})
+	db.ExpectExec(`DELETE FROM harmony_task WHERE id=$1`).WillReturnCount(1)
+	db.ExpectExec(history).WillReturnCount(1)
+	require.NoError(t, s.Complete(ctx, failed))
+
+	// dropped tasks are removed without counting failures
+	dropped := failed
+	dropped.Drop = true
+	db.ExpectQueryRow(posted).WillReturnRows([]any{postedAt})
+	db.ExpectExec(`DELETE FROM harmony_task WHERE id=$1`).WillReturnCount(1)
+	db.ExpectExec(history).WillReturnCount(1)
+	require.NoError(t, s.Complete(ctx, dropped))
+
+	// the task is gone, e.g. released and completed by another machine
+	db.ExpectQueryRow(posted).WillReturnRows()
+	require.ErrorContains(t, s.Complete(ctx, done), "could not log completion")
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestPostgresStoreAddTask(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	var s TaskStore = NewPostgresStore(db)
+
+	const insert = `INSERT INTO harmony_task (name, added_by, posted_time)`
+	const lastID = `SELECT id FROM harmony_task ORDER BY update_time DESC LIMIT 1`
+
+	db.ExpectExec(insert).WithArgs("WdPost", 3).WillReturnCount(1)
+	db.ExpectQueryRow(lastID).WillReturnRows([]any{7})
+	db.ExpectExec(`INSERT INTO wdpost_partition_tasks`).WithArgs(TaskID(7)).WillReturnCount(1)
+	added, err := s.AddTask(ctx, "WdPost", 3, func(id TaskID, tx *harmonydb.Tx) (bool, error) {
+		_, err := tx.Exec(`INSERT INTO wdpost_partition_tasks (task_id) VALUES ($1)`, id)
+		return err == nil, err
+	})
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// the extra info exists already
+	db.ExpectExec(insert).WillReturnCount(1)
+	db.ExpectQueryRow(lastID).WillReturnRows([]any{8})
+	db.ExpectExec(`INSERT INTO wdpost_partition_tasks`).WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	added, err = s.AddTask(ctx, "WdPost", 3, func(id TaskID, tx *harmonydb.Tx) (bool, error) {
+		_, err := tx.Exec(`INSERT INTO wdpost_partition_tasks (task_id) VALUES ($1)`, id)
+		return err == nil, err
+	})
+	require.NoError(t, err)
+	require.False(t, added)
+
+	// the adder found the task added already
+	db.ExpectExec(insert).WillReturnCount(1)
+	db.ExpectQueryRow(lastID).WillReturnRows([]any{9})
+	added, err = s.AddTask(ctx, "WdPost", 3, func(id TaskID, tx *harmonydb.Tx) (bool, error) {
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.False(t, added)
+
+	// notifications need a *harmonydb.DB
+	require.Error(t, s.Notify(ctx, "WdPost"))
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestPostgresStoreUnclaimedTasks(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	var s TaskStore = NewPostgresStore(db)
+
+	db.ExpectSelect(`WHERE owner_id IS NULL AND name=$1`).WithArgs("WdPost", int64(12000)).
+		WillReturnSelect([]TaskID{4, 2})
+	ids, err := s.UnclaimedTasks(ctx, "WdPost", 12*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []TaskID{4, 2}, ids)
+
+	db.ExpectSelect(`SELECT id, name from harmony_task WHERE owner_id=$1`).WithArgs(3).
+		WillReturnSelect([]OwnedTask{{ID: 4, Name: "WdPost"}})
+	owned, err := s.OwnedTasks(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, []OwnedTask{{ID: 4, Name: "WdPost"}}, owned)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestWeightedOverShares(t *testing.T) {
+	// equal weights never bias claiming
+	require.Empty(t, weightedOverShares([]MachineLoad{{ID: 1, Weight: 1, Count: 5}, {ID: 2, Weight: 1, Count: 0}}))
+
+	over := weightedOverShares([]MachineLoad{
+		{ID: 1, Weight: 1, Count: 2},
+		{ID: 2, Weight: 2, Count: 4},
+		{ID: 3, Weight: 1, Count: 0},
+	})
+	require.Equal(t, map[int]bool{1: true, 2: true}, over)
+}
+
+func TestPostgresStoreMachineLoads(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	var s TaskStore = NewPostgresStore(db)
+
+	// only live machines running the type, and not draining, are loaded
+	db.ExpectSelect(`JOIN harmony_task_impl i ON i.owner_id = m.id AND i.name = $1`).WithArgs("WdPost", int64(180000)).
+		WillReturnSelect([]MachineLoad{{ID: 1, Weight: 2, Count: 1}})
+	loads, err := s.MachineLoads(ctx, "WdPost", 3*time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// a sealing node of another weight doesn't put the only PoSt runner over
+	// its share, it could never take the task anyway
+	require.Empty(t, weightedOverShares(loads))
+	require.Equal(t, map[int]bool{1: true}, weightedOverShares(append(loads, MachineLoad{ID: 2, Weight: 1, Count: 0})))
+}
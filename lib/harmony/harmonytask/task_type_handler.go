@@ -21,32 +21,27 @@ type taskTypeHandler struct {
 	TaskTypeDetails
 	TaskEngine *TaskEngine
 	Count      atomic.Int32
+	// addOnly handlers add tasks but don't claim them, see AddOnly
+	addOnly bool
 }
 
 func (h *taskTypeHandler) AddTask(extra func(TaskID, *harmonydb.Tx) (bool, error)) {
-	var tID TaskID
-	_, err := h.TaskEngine.db.BeginTransaction(h.TaskEngine.ctx, func(tx *harmonydb.Tx) (bool, error) {
-		// create taskID (from DB)
-		_, err := tx.Exec(`INSERT INTO harmony_task (name, added_by, posted_time) 
-			VALUES ($1, $2, CURRENT_TIMESTAMP) `, h.Name, h.TaskEngine.ownerID)
-		if err != nil {
-			return false, fmt.Errorf("could not insert into harmonyTask: %w", err)
-		}
-		err = tx.QueryRow("SELECT id FROM harmony_task ORDER BY update_time DESC LIMIT 1").Scan(&tID)
-		if err != nil {
-			return false, fmt.Errorf("Could not select ID: %v", err)
-		}
-		return extra(tID, tx)
-	})
-
+	added, err := h.TaskEngine.store.AddTask(h.TaskEngine.ctx, h.Name, h.TaskEngine.ownerID, extra)
 	if err != nil {
-		if harmonydb.IsErrUniqueContraint(err) {
-			log.Debugf("addtask(%s) saw unique constraint, so it's added already.", h.Name)
-			return
-		}
 		log.Error("Could not add task. AddTasFunc failed: %v", err)
 		return
 	}
+	if !added {
+		log.Debugf("addtask(%s) didn't add the task, so it's added already.", h.Name)
+		return
+	}
+
+	if h.TaskEngine.notify.Load() {
+		if err := h.TaskEngine.store.Notify(h.TaskEngine.ctx, h.Name); err != nil {
+			log.Warnw("could not announce added task, other machines will find it by polling", "name", h.Name, "error", err)
+		}
+	}
+	h.TaskEngine.wake()
 }
 
 // considerWork is called to attempt to start work on a task-id of this task type.
@@ -88,12 +83,16 @@ top:
 	}
 
 	// 4. Can we claim the work for our hostname?
-	ct, err := h.TaskEngine.db.Exec(h.TaskEngine.ctx, "UPDATE harmony_task SET owner_id=$1 WHERE id=$2 AND owner_id IS NULL", h.TaskEngine.ownerID, *tID)
+	claimed, err := h.claim(*tID)
 	if err != nil {
 		log.Error(err)
 		return false
 	}
-	if ct == 0 {
+	if claimed == ClaimAtClusterMax {
+		log.Debugw("did not accept task", "name", h.Name, "reason", "at cluster max already")
+		return false
+	}
+	if claimed == ClaimTaken {
 		log.Infow("did not accept task", "task_id", strconv.Itoa(int(*tID)), "reason", "already Taken", "name", h.Name)
 		var tryAgain = make([]TaskID, 0, len(ids)-1)
 		for _, id := range ids {
@@ -113,6 +112,14 @@ top:
 		var doErr error
 		workStart := time.Now()
 
+		span := h.startSpan(*tID, from)
+		defer func() {
+			endSpan(*tID, span, done, doErr)
+		}()
+
+		runLog := startRunLog(*tID)
+		runLog.add(newRunLogEntry("claimed", []any{"from", from, "host", h.TaskEngine.hostAndPort}), true)
+
 		defer func() {
 			if r := recover(); r != nil {
 				stackSlice := make([]byte, 4092)
@@ -120,10 +127,22 @@ top:
 				log.Error("Recovered from a serious error "+
 					"while processing "+h.Name+" task "+strconv.Itoa(int(*tID))+": ", r,
 					" Stack: ", string(stackSlice[:sz]))
+				Logw(*tID, "panic", "value", r)
 			}
 			h.Count.Add(-1)
 
-			h.recordCompletion(*tID, workStart, done, doErr)
+			final := newRunLogEntry("finished", []any{"done", done, "took", time.Since(workStart)})
+			if doErr != nil {
+				final.Msg = "failed"
+				final.Fields["error"] = runLogValue(doErr)
+			}
+			h.recordCompletion(*tID, workStart, done, doErr, runLog.finish(*tID, final))
+			h.TaskEngine.wake() // capacity freed up, and retries are claimable again
+			if h.TaskEngine.terminating.Load() {
+				h.TaskEngine.recordState()
+				log.Infow("Task completed during shutdown", "id", *tID, "name", h.Name, "done", done,
+					"remaining", h.TaskEngine.RunningCount())
+			}
 			if done {
 				for _, fs := range h.TaskEngine.follows[h.Name] { // Do we know of any follows for this task type?
 					if _, err := fs.f(*tID, fs.h.AddTask); err != nil {
@@ -134,10 +153,8 @@ top:
 		}()
 
 		done, doErr = h.Do(*tID, func() bool {
-			var owner int
 			// Background here because we don't want GracefulRestart to block this save.
-			err := h.TaskEngine.db.QueryRow(context.Background(),
-				`SELECT owner_id FROM harmony_task WHERE id=$1`, *tID).Scan(&owner)
+			owner, err := h.TaskEngine.store.Owner(context.Background(), *tID)
 			if err != nil {
 				log.Error("Cannot determine ownership: ", err)
 				return false
@@ -151,66 +168,47 @@ top:
 	return true
 }
 
-func (h *taskTypeHandler) recordCompletion(tID TaskID, workStart time.Time, done bool, doErr error) {
-	workEnd := time.Now()
+// claim makes this machine the owner of the task, see TaskStore.Claim.
+func (h *taskTypeHandler) claim(tID TaskID) (ClaimResult, error) {
+	res, err := h.TaskEngine.store.Claim(h.TaskEngine.ctx, tID, h.Name, h.TaskEngine.ownerID, h.ClusterMax)
+	if err != nil {
+		// a claim which raced another one fails to commit on databases
+		// which don't wait on row locks, the task is tried again next poll
+		return 0, fmt.Errorf("claiming %s task %d: %w", h.Name, tID, err)
+	}
+	return res, nil
+}
 
-	cm, err := h.TaskEngine.db.BeginTransaction(h.TaskEngine.ctx, func(tx *harmonydb.Tx) (bool, error) {
-		var postedTime time.Time
-		err := tx.QueryRow(`SELECT posted_time FROM harmony_task WHERE id=$1`, tID).Scan(&postedTime)
-		if err != nil {
-			return false, fmt.Errorf("could not log completion: %w ", err)
+func (h *taskTypeHandler) recordCompletion(tID TaskID, workStart time.Time, done bool, doErr error, runLog []byte) {
+	c := Completion{
+		ID:            tID,
+		Name:          h.Name,
+		By:            h.TaskEngine.ownerID,
+		ByHostAndPort: h.TaskEngine.hostAndPort,
+		Start:         workStart,
+		End:           time.Now(),
+		Done:          done,
+		MaxFailures:   h.MaxFailures,
+		RunLog:        runLog,
+	}
+	if !done {
+		c.Result = "unspecified error"
+		if doErr != nil {
+			c.Result = "error: " + doErr.Error()
 		}
-		result := "unspecified error"
-		if done {
-			_, err = tx.Exec("DELETE FROM harmony_task WHERE id=$1", tID)
-			if err != nil {
 
-				return false, fmt.Errorf("could not log completion: %w", err)
-			}
-			result = ""
-		} else {
-			if doErr != nil {
-				result = "error: " + doErr.Error()
-			}
-			var deleteTask bool
-			if h.MaxFailures > 0 {
-				ct := uint(0)
-				err = tx.QueryRow(`SELECT count(*) FROM harmony_task_history 
-				WHERE task_id=$1 AND result=FALSE`, tID).Scan(&ct)
-				if err != nil {
-					return false, fmt.Errorf("could not read task history: %w", err)
-				}
-				if ct >= h.MaxFailures {
-					deleteTask = true
-				}
-			}
-			if deleteTask {
-				_, err = tx.Exec("DELETE FROM harmony_task WHERE id=$1", tID)
-				if err != nil {
-					return false, fmt.Errorf("could not delete failed job: %w", err)
-				}
-				// Note: Extra Info is left laying around for later review & clean-up
-			} else {
-				_, err := tx.Exec(`UPDATE harmony_task SET owner_id=NULL WHERE id=$1`, tID)
-				if err != nil {
-					return false, fmt.Errorf("could not disown failed task: %v %v", tID, err)
-				}
-			}
-		}
-		_, err = tx.Exec(`INSERT INTO harmony_task_history 
-									 (task_id,   name, posted,    work_start, work_end, result, completed_by_host_and_port,      err)
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, tID, h.Name, postedTime, workStart, workEnd, done, h.TaskEngine.hostAndPort, result)
-		if err != nil {
-			return false, fmt.Errorf("could not write history: %w", err)
+		classifier, _ := h.TaskInterface.(ErrorClassifier)
+		class := Classify(doErr, classifier)
+		c.ErrClass = (*string)(&class)
+
+		if class == ErrorTerminal {
+			log.Warnw("dropping task after terminal error", "type", h.Name, "id", tID, "error", doErr)
+			c.Drop = true
 		}
-		return true, nil
-	})
-	if err != nil {
-		log.Error("Could not record transaction: ", err)
-		return
 	}
-	if !cm {
-		log.Error("Committing the task records failed")
+
+	if err := h.TaskEngine.store.Complete(h.TaskEngine.ctx, c); err != nil {
+		log.Error("Could not record transaction: ", err)
 	}
 }
 
@@ -0,0 +1,40 @@
+package harmonytask
+
+import (
+	"context"
+	"sync"
+
+	"go.opencensus.io/trace"
+)
+
+// runningSpans holds the trace span of each task running on this node.
+var runningSpans sync.Map // TaskID -> *trace.Span
+
+// TaskContext returns ctx carrying the trace span of the running task id, so
+// that spans started below it are recorded as children of the task run. Task
+// implementations should derive the contexts they use in Do from it.
+func TaskContext(ctx context.Context, id TaskID) context.Context {
+	if span, ok := runningSpans.Load(id); ok {
+		return trace.NewContext(ctx, span.(*trace.Span))
+	}
+	return ctx
+}
+
+func (h *taskTypeHandler) startSpan(id TaskID, from string) *trace.Span {
+	_, span := trace.StartSpan(context.Background(), "harmonytask."+h.Name)
+	span.AddAttributes(
+		trace.Int64Attribute("task_id", int64(id)),
+		trace.StringAttribute("from", from),
+	)
+	runningSpans.Store(id, span)
+	return span
+}
+
+func endSpan(id TaskID, span *trace.Span, done bool, err error) {
+	runningSpans.Delete(id)
+	span.AddAttributes(trace.BoolAttribute("done", done))
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
@@ -13,8 +13,6 @@ import (
 	"github.com/pbnjay/memory"
 	"golang.org/x/sys/unix"
 	"golang.org/x/xerrors"
-
-	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 )
 
 var LOOKS_DEAD_TIMEOUT = 10 * time.Minute // Time w/o minute heartbeats
@@ -25,6 +23,12 @@ type Resources struct {
 	Ram       uint64
 	MachineID int
 }
+
+// Fits reports if a task costing cost can run within r.
+func (r Resources) Fits(cost Resources) bool {
+	return cost.Cpu <= r.Cpu && cost.Ram <= r.Ram && cost.Gpu <= r.Gpu
+}
+
 type Reg struct {
 	Resources
 	shutdown atomic.Bool
@@ -34,7 +38,21 @@ var logger = logging.Logger("harmonytask")
 
 var lotusRE = regexp.MustCompile("lotus-worker|lotus-harmony|yugabyted|yb-master|yb-tserver")
 
-func Register(db *harmonydb.DB, hostnameAndPort string) (*Reg, error) {
+// Registrar persists the machines of the cluster with their heartbeats, see
+// Register.
+type Registrar interface {
+	// RegisterMachine records the machine at hostAndPort with its resources,
+	// and returns its ID. A machine registering an address known already
+	// takes over the ID of that address.
+	RegisterMachine(ctx context.Context, hostAndPort string, res Resources) (machineID int, err error)
+	// Heartbeat records that the machine is still in contact.
+	Heartbeat(ctx context.Context, machineID int) error
+	// CleanupMachines removes the machines without contact for longer than
+	// deadAfter, and returns how many were removed.
+	CleanupMachines(ctx context.Context, deadAfter time.Duration) (int, error)
+}
+
+func Register(r Registrar, hostnameAndPort string) (*Reg, error) {
 	var reg Reg
 	var err error
 	reg.Resources, err = getResources()
@@ -43,37 +61,12 @@ func Register(db *harmonydb.DB, hostnameAndPort string) (*Reg, error) {
 	}
 	ctx := context.Background()
 	{ // Learn our owner_id while updating harmony_machines
-		var ownerID *int
-
-		// Upsert query with last_contact update, fetch the machine ID
-		// (note this isn't a simple insert .. on conflict because host_and_port isn't unique)
-		err := db.QueryRow(ctx, `
-			WITH upsert AS (
-				UPDATE harmony_machines
-				SET cpu = $2, ram = $3, gpu = $4, last_contact = CURRENT_TIMESTAMP
-				WHERE host_and_port = $1
-				RETURNING id
-			),
-			inserted AS (
-				INSERT INTO harmony_machines (host_and_port, cpu, ram, gpu, last_contact)
-				SELECT $1, $2, $3, $4, CURRENT_TIMESTAMP
-				WHERE NOT EXISTS (SELECT id FROM upsert)
-				RETURNING id
-			)
-			SELECT id FROM upsert
-			UNION ALL
-			SELECT id FROM inserted;
-		`, hostnameAndPort, reg.Cpu, reg.Ram, reg.Gpu).Scan(&ownerID)
+		reg.MachineID, err = r.RegisterMachine(ctx, hostnameAndPort, reg.Resources)
 		if err != nil {
 			return nil, xerrors.Errorf("inserting machine entry: %w", err)
 		}
-		if ownerID == nil {
-			return nil, xerrors.Errorf("no owner id")
-		}
-
-		reg.MachineID = *ownerID
 
-		cleaned := CleanupMachines(context.Background(), db)
+		cleaned := CleanupMachines(context.Background(), r)
 		logger.Infow("Cleaned up machines", "count", cleaned)
 	}
 	go func() {
@@ -82,7 +75,7 @@ func Register(db *harmonydb.DB, hostnameAndPort string) (*Reg, error) {
 			if reg.shutdown.Load() {
 				return
 			}
-			_, err := db.Exec(ctx, `UPDATE harmony_machines SET last_contact=CURRENT_TIMESTAMP`)
+			err := r.Heartbeat(ctx, reg.MachineID)
 			if err != nil {
 				logger.Error("Cannot keepalive ", err)
 			}
@@ -92,10 +85,8 @@ func Register(db *harmonydb.DB, hostnameAndPort string) (*Reg, error) {
 	return &reg, nil
 }
 
-func CleanupMachines(ctx context.Context, db *harmonydb.DB) int {
-	ct, err := db.Exec(ctx,
-		`DELETE FROM harmony_machines WHERE last_contact < CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $1 `,
-		LOOKS_DEAD_TIMEOUT.Milliseconds()) // ms enables unit testing to change timeout.
+func CleanupMachines(ctx context.Context, r Registrar) int {
+	ct, err := r.CleanupMachines(ctx, LOOKS_DEAD_TIMEOUT)
 	if err != nil {
 		logger.Warn("unable to delete old machines: ", err)
 	}
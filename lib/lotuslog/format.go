@@ -0,0 +1,31 @@
+package lotuslog
+
+import (
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+)
+
+// SetupLogFormat switches the output of all loggers, including ones created
+// before the call, to format: "json" for structured output meant for log
+// ingestion, or "console" for human-readable output, colorized when color is
+// set. go-log resets log levels when its output changes, so the default
+// levels are applied again.
+func SetupLogFormat(format string, color bool) error {
+	cfg := logging.GetConfig()
+
+	switch format {
+	case "json":
+		cfg.Format = logging.JSONOutput
+	case "console":
+		cfg.Format = logging.PlaintextOutput
+		if color {
+			cfg.Format = logging.ColorizedOutput
+		}
+	default:
+		return xerrors.Errorf("unknown log format %q, expected 'console' or 'json'", format)
+	}
+
+	logging.SetupLogging(cfg)
+	SetupLogLevels()
+	return nil
+}
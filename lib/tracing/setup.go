@@ -74,15 +74,31 @@ func SetupJaegerTracing(serviceName string) *tracesdk.TracerProvider {
 		log.Errorw("failed to create the jaeger exporter", "error", err)
 		return nil
 	}
+	return setupTracerProvider(serviceName, je, tracesdk.AlwaysSample())
+}
+
+// SetupJaegerCollectorTracing sends traces to the Jaeger collector at
+// endpoint, sampling the given ratio of new traces. Unlike
+// SetupJaegerTracing, it doesn't read the environment.
+func SetupJaegerCollectorTracing(serviceName, endpoint string, sampleRatio float64) (*tracesdk.TracerProvider, error) {
+	je, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint)))
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("jaeger traces will send to collector %s", endpoint)
+	return setupTracerProvider(serviceName, je, tracesdk.ParentBased(tracesdk.TraceIDRatioBased(sampleRatio))), nil
+}
+
+func setupTracerProvider(serviceName string, exp tracesdk.SpanExporter, sampler tracesdk.Sampler) *tracesdk.TracerProvider {
 	tp := tracesdk.NewTracerProvider(
 		// Always be sure to batch in production.
-		tracesdk.WithBatcher(je),
+		tracesdk.WithBatcher(exp),
 		// Record information about this application in an Resource.
 		tracesdk.WithResource(resource.NewWithAttributes(
 			semconv.SchemaURL,
 			semconv.ServiceNameKey.String(serviceName),
 		)),
-		tracesdk.WithSampler(tracesdk.AlwaysSample()),
+		tracesdk.WithSampler(sampler),
 	)
 	otel.SetTracerProvider(tp)
 	tracer := tp.Tracer(serviceName)
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// AggregateViews returns a copy of each of the views which is tagged with any
+// of keys, without those tags. The copies record the same measures, so they
+// hold the aggregate over the dropped tags, for dashboards built before the
+// tags were added. They keep the names of the views they are copied from, so
+// they can only be registered in place of those, see RegisterAggregateViews.
+//
+// Last value views are left out, the last value of any of the dropped tag
+// values isn't an aggregate.
+func AggregateViews(views []*view.View, keys ...tag.Key) []*view.View {
+	drop := make(map[tag.Key]struct{}, len(keys))
+	for _, k := range keys {
+		drop[k] = struct{}{}
+	}
+
+	var out []*view.View
+	for _, v := range views {
+		if v.Aggregation == nil || v.Aggregation.Type == view.AggTypeLastValue {
+			continue
+		}
+
+		var kept []tag.Key
+		for _, k := range v.TagKeys {
+			if _, ok := drop[k]; !ok {
+				kept = append(kept, k)
+			}
+		}
+		if len(kept) == len(v.TagKeys) {
+			continue
+		}
+
+		name := v.Name
+		if name == "" {
+			name = v.Measure.Name()
+		}
+		out = append(out, &view.View{
+			Name:        name,
+			Description: v.Description,
+			Measure:     v.Measure,
+			Aggregation: v.Aggregation,
+			TagKeys:     kept,
+		})
+	}
+	return out
+}
+
+// RegisterAggregateViews unregisters each of the views which is tagged with
+// any of keys, and registers its aggregate from AggregateViews under the same
+// name, so that existing dashboards keep working unchanged. It returns views
+// with the aggregates in place of the views they replace, and the number of
+// views replaced.
+func RegisterAggregateViews(views []*view.View, keys ...tag.Key) ([]*view.View, int, error) {
+	agg := AggregateViews(views, keys...)
+	byName := make(map[string]*view.View, len(agg))
+	for _, v := range agg {
+		byName[v.Name] = v
+	}
+
+	// views are unregistered by name, which the aggregates share
+	view.Unregister(agg...)
+	if err := view.Register(agg...); err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]*view.View, 0, len(views))
+	for _, v := range views {
+		name := v.Name
+		if name == "" {
+			name = v.Measure.Name()
+		}
+		if a, ok := byName[name]; ok {
+			v = a
+		}
+		out = append(out, v)
+	}
+	return out, len(agg), nil
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestAggregateViews(t *testing.T) {
+	kind, _ := tag.NewKey("kind")
+	m := stats.Int64("test/aggregate_proofs", "test", stats.UnitDimensionless)
+	gauge := stats.Int64("test/aggregate_gauge", "test", stats.UnitDimensionless)
+
+	labeled := &view.View{Measure: m, Aggregation: view.Sum(), TagKeys: []tag.Key{MinerID, kind}}
+	lastValue := &view.View{Measure: gauge, Aggregation: view.LastValue(), TagKeys: []tag.Key{MinerID}}
+	untagged := &view.View{Name: "test/aggregate_untagged", Measure: m, Aggregation: view.Count()}
+	views := []*view.View{labeled, lastValue, untagged}
+
+	agg := AggregateViews(views, MinerID)
+	require.Len(t, agg, 1)
+	require.Equal(t, "test/aggregate_proofs", agg[0].Name)
+	require.Equal(t, []tag.Key{kind}, agg[0].TagKeys)
+
+	require.NoError(t, view.Register(views...))
+	defer view.Unregister(views...)
+
+	out, n, err := RegisterAggregateViews(views, MinerID)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Len(t, out, 3)
+	require.Equal(t, "test/aggregate_proofs", out[0].Name)
+	require.Equal(t, []tag.Key{kind}, out[0].TagKeys)
+	require.Equal(t, lastValue, out[1])
+	require.Equal(t, untagged, out[2])
+
+	for _, miner := range []string{"f01000", "f01001"} {
+		require.NoError(t, stats.RecordWithTags(context.Background(),
+			[]tag.Mutator{tag.Upsert(MinerID, miner), tag.Upsert(kind, "a")}, m.M(2), gauge.M(1)))
+	}
+
+	// the aggregate is exported under the name of the tagged view
+	rows, err := view.RetrieveData("test/aggregate_proofs")
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, []tag.Tag{{Key: kind, Value: "a"}}, rows[0].Tags)
+	require.Equal(t, 4.0, rows[0].Data.(*view.SumData).Value)
+
+	// gauges stay per miner
+	rows, err = view.RetrieveData(lastValue.Name)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+}
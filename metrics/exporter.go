@@ -1,32 +1,73 @@
 package metrics
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
+	"sync"
+	"time"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
 	logging "github.com/ipfs/go-log/v2"
 	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"golang.org/x/xerrors"
 )
 
 var log = logging.Logger("metrics")
 
+var (
+	exporterOnce sync.Once
+	exporter     *prometheus.Exporter
+	gatherer     promclient.Gatherer
+)
+
 func Exporter() http.Handler {
-	// Prometheus globals are exposed as interfaces, but the prometheus
-	// OpenCensus exporter expects a concrete *Registry. The concrete type of
-	// the globals are actually *Registry, so we downcast them, staying
-	// defensive in case things change under the hood.
-	registry, ok := promclient.DefaultRegisterer.(*promclient.Registry)
-	if !ok {
-		log.Warnf("failed to export default prometheus registry; some metrics will be unavailable; unexpected type: %T", promclient.DefaultRegisterer)
-	}
-	exporter, err := prometheus.NewExporter(prometheus.Options{
-		Registry:  registry,
-		Namespace: "lotus",
+	exporterOnce.Do(func() {
+		// Prometheus globals are exposed as interfaces, but the prometheus
+		// OpenCensus exporter expects a concrete *Registry. The concrete type of
+		// the globals are actually *Registry, so we downcast them, staying
+		// defensive in case things change under the hood.
+		registry, ok := promclient.DefaultRegisterer.(*promclient.Registry)
+		if !ok {
+			log.Warnf("failed to export default prometheus registry; some metrics will be unavailable; unexpected type: %T", promclient.DefaultRegisterer)
+			registry = promclient.NewRegistry()
+		}
+		var err error
+		exporter, err = prometheus.NewExporter(prometheus.Options{
+			Registry:  registry,
+			Namespace: "lotus",
+		})
+		if err != nil {
+			log.Errorf("could not create the prometheus stats exporter: %v", err)
+		}
+		gatherer = registry
 	})
+
+	return exporter
+}
+
+// WriteSnapshot writes the metrics served by Exporter to w in the Prometheus
+// text format, preceded by a comment with the time they were gathered at.
+// The snapshot is taken in-process, so it doesn't need the metrics endpoint
+// to be reachable.
+func WriteSnapshot(w io.Writer) error {
+	Exporter()
+
+	mfs, err := gatherer.Gather()
 	if err != nil {
-		log.Errorf("could not create the prometheus stats exporter: %v", err)
+		return xerrors.Errorf("gathering metrics: %w", err)
 	}
 
-	return exporter
+	if _, err := fmt.Fprintf(w, "# snapshot taken at %s\n", time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return xerrors.Errorf("encoding metric %s: %w", mf.GetName(), err)
+		}
+	}
+	return nil
 }
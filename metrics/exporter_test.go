@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+func TestWriteSnapshot(t *testing.T) {
+	m := stats.Int64("test/snapshot_events", "", stats.UnitDimensionless)
+	v := &view.View{Measure: m, Aggregation: view.Sum()}
+	require.NoError(t, view.Register(v))
+	defer view.Unregister(v)
+
+	stats.Record(context.Background(), m.M(3))
+
+	// views are updated asynchronously
+	var snapshot string
+	require.Eventually(t, func() bool {
+		var buf bytes.Buffer
+		require.NoError(t, WriteSnapshot(&buf))
+		snapshot = buf.String()
+		return strings.Contains(snapshot, "lotus_test_snapshot_events 3\n")
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, strings.HasPrefix(snapshot, "# snapshot taken at "))
+
+	// the snapshot has the metrics served at /debug/metrics
+	rec := httptest.NewRecorder()
+	Exporter().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/metrics", nil))
+	served, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(served), "lotus_test_snapshot_events 3\n")
+}
@@ -30,10 +30,15 @@ var (
 	Version, _     = tag.NewKey("version")
 	Commit, _      = tag.NewKey("commit")
 	NodeType, _    = tag.NewKey("node_type")
+	NodeName, _    = tag.NewKey("node_name")
 	PeerID, _      = tag.NewKey("peer_id")
 	MinerID, _     = tag.NewKey("miner_id")
 	FailureType, _ = tag.NewKey("failure_type")
 
+	// provider node labels, see NodeLabelInfo
+	NodeLabel, _      = tag.NewKey("node_label")
+	NodeLabelValue, _ = tag.NewKey("node_label_value")
+
 	// chain
 	Local, _        = tag.NewKey("local")
 	MessageFrom, _  = tag.NewKey("message_from")
@@ -48,10 +53,12 @@ var (
 	TaskType, _       = tag.NewKey("task_type")
 	WorkerHostname, _ = tag.NewKey("worker_hostname")
 	StorageID, _      = tag.NewKey("storage_id")
+	StorageGroup, _   = tag.NewKey("storage_group")
 	SectorState, _    = tag.NewKey("sector_state")
 
 	PathSeal, _    = tag.NewKey("path_seal")
 	PathStorage, _ = tag.NewKey("path_storage")
+	FileType, _    = tag.NewKey("file_type")
 
 	// rcmgr
 	ServiceID, _  = tag.NewKey("svc")
@@ -71,6 +78,7 @@ var (
 var (
 	// common
 	LotusInfo          = stats.Int64("info", "Arbitrary counter to tag lotus info to", stats.UnitDimensionless)
+	NodeLabelInfo      = stats.Int64("node_label", "Arbitrary counter to tag each configured label of a provider node to", stats.UnitDimensionless)
 	PeerCount          = stats.Int64("peer/count", "Current number of FIL peers", stats.UnitDimensionless)
 	APIRequestDuration = stats.Float64("api/request_duration_ms", "Duration of API requests", stats.UnitMilliseconds)
 
@@ -151,6 +159,18 @@ var (
 	StorageReservedBytes    = stats.Int64("storage/path_reserved_bytes", "reserved storage bytes", stats.UnitBytes)
 	StorageLimitUsedBytes   = stats.Int64("storage/path_limit_used_bytes", "used optional storage limit bytes", stats.UnitBytes)
 	StorageLimitMaxBytes    = stats.Int64("storage/path_limit_max_bytes", "optional storage limit", stats.UnitBytes)
+	StorageLastHeartbeat    = stats.Int64("storage/path_last_heartbeat_seconds", "unix time of the last successful health report of a local storage path to the index", stats.UnitSeconds)
+
+	LocalPathCapacityBytes  = stats.Int64("storage/local_path_capacity_bytes", "local storage path filesystem capacity", stats.UnitBytes)
+	LocalPathUsedBytes      = stats.Int64("storage/local_path_used_bytes", "local storage path filesystem used bytes", stats.UnitBytes)
+	LocalPathAvailableBytes = stats.Int64("storage/local_path_available_bytes", "local storage path bytes available for new sectors", stats.UnitBytes)
+	LocalPathReservedBytes  = stats.Int64("storage/local_path_reserved_bytes", "local storage path bytes reserved by running tasks", stats.UnitBytes)
+	LocalPathReservations   = stats.Int64("storage/local_path_reservations", "number of sectors with reservations in a local storage path", stats.UnitDimensionless)
+
+	StorageFetchBytes  = stats.Int64("storage/fetch_bytes", "bytes of sector files fetched from remote storage", stats.UnitBytes)
+	StorageTaskFetches = stats.Int64("storage/task_fetches", "fetches in flight for the task starting a remote fetch, under a per-task fetch limit", stats.UnitDimensionless)
+
+	ChainClockSkew = stats.Float64("chain/clock_skew_seconds", "estimated offset of the local clock from the chain's clock, positive when the local clock is ahead", stats.UnitSeconds)
 
 	SchedAssignerCycleDuration           = stats.Float64("sched/assigner_cycle_ms", "Duration of scheduler assigner cycle", stats.UnitMilliseconds)
 	SchedAssignerCandidatesDuration      = stats.Float64("sched/assigner_cycle_candidates_ms", "Duration of scheduler assigner candidate matching step", stats.UnitMilliseconds)
@@ -208,7 +228,12 @@ var (
 		Description: "Lotus node information",
 		Measure:     LotusInfo,
 		Aggregation: view.LastValue(),
-		TagKeys:     []tag.Key{Version, Commit, NodeType},
+		TagKeys:     []tag.Key{Version, Commit, NodeType, NodeName},
+	}
+	NodeLabelView = &view.View{
+		Measure:     NodeLabelInfo,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{NodeName, NodeLabel, NodeLabelValue},
 	}
 	ChainNodeHeightView = &view.View{
 		Measure:     ChainNodeHeight,
@@ -475,6 +500,49 @@ var (
 		Aggregation: view.LastValue(),
 		TagKeys:     []tag.Key{StorageID, PathStorage, PathSeal},
 	}
+	StorageLastHeartbeatView = &view.View{
+		Measure:     StorageLastHeartbeat,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID},
+	}
+	LocalPathCapacityBytesView = &view.View{
+		Measure:     LocalPathCapacityBytes,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID, StorageGroup},
+	}
+	LocalPathUsedBytesView = &view.View{
+		Measure:     LocalPathUsedBytes,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID, StorageGroup},
+	}
+	LocalPathAvailableBytesView = &view.View{
+		Measure:     LocalPathAvailableBytes,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID, StorageGroup},
+	}
+	LocalPathReservedBytesView = &view.View{
+		Measure:     LocalPathReservedBytes,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID, StorageGroup},
+	}
+	LocalPathReservationsView = &view.View{
+		Measure:     LocalPathReservations,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{StorageID, StorageGroup},
+	}
+	StorageFetchBytesView = &view.View{
+		Measure:     StorageFetchBytes,
+		Aggregation: view.Sum(),
+		TagKeys:     []tag.Key{FileType},
+	}
+	StorageTaskFetchesView = &view.View{
+		Measure:     StorageTaskFetches,
+		Aggregation: view.Distribution(1, 2, 4, 8, 16, 32, 64, 128),
+	}
+	ChainClockSkewView = &view.View{
+		Measure:     ChainClockSkew,
+		Aggregation: view.LastValue(),
+	}
 
 	SchedAssignerCycleDurationView = &view.View{
 		Measure:     SchedAssignerCycleDuration,
@@ -749,6 +817,13 @@ func RegisterViews(v ...*view.View) {
 	views = append(views, v...)
 }
 
+// RegisteredViews returns the views added with RegisterViews after
+// DefaultViews was built, e.g. from init functions of other packages, which
+// DefaultViews and the node view lists don't include.
+func RegisteredViews() []*view.View {
+	return append([]*view.View{}, views[len(DefaultViews):]...)
+}
+
 func init() {
 	RegisterViews(blockstore.DefaultViews...)
 	RegisterViews(rpcmetrics.DefaultViews...)
@@ -819,6 +894,14 @@ var MinerNodeViews = append([]*view.View{
 	StorageReservedBytesView,
 	StorageLimitUsedBytesView,
 	StorageLimitMaxBytesView,
+	StorageLastHeartbeatView,
+	LocalPathCapacityBytesView,
+	LocalPathUsedBytesView,
+	LocalPathAvailableBytesView,
+	LocalPathReservedBytesView,
+	LocalPathReservationsView,
+	StorageFetchBytesView,
+	StorageTaskFetchesView,
 
 	SchedAssignerCycleDurationView,
 	SchedAssignerCandidatesDurationView,
@@ -842,6 +925,29 @@ var MinerNodeViews = append([]*view.View{
 	DagStorePRAtReadCountView,
 }, DefaultViews...)
 
+var ProviderNodeViews = append([]*view.View{
+	StorageFSAvailableView,
+	StorageAvailableView,
+	StorageReservedView,
+	StorageLimitUsedView,
+	StorageCapacityBytesView,
+	StorageFSAvailableBytesView,
+	StorageAvailableBytesView,
+	StorageReservedBytesView,
+	StorageLimitUsedBytesView,
+	StorageLimitMaxBytesView,
+	StorageLastHeartbeatView,
+	LocalPathCapacityBytesView,
+	LocalPathUsedBytesView,
+	LocalPathAvailableBytesView,
+	LocalPathReservedBytesView,
+	LocalPathReservationsView,
+	StorageFetchBytesView,
+	StorageTaskFetchesView,
+	ChainClockSkewView,
+	NodeLabelView,
+}, DefaultViews...)
+
 var GatewayNodeViews = append([]*view.View{
 	RateLimitedView,
 }, ChainNodeViews...)
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"golang.org/x/xerrors"
+)
+
+// maxStatsdPacket keeps packets within the UDP payload which fits in a
+// typical 1500 byte MTU.
+const maxStatsdPacket = 1432
+
+// StatsdPusher periodically pushes the data of OpenCensus views to a statsd
+// collector, for nodes which can't be scraped. It works alongside the
+// Prometheus exporter, both read the same views.
+//
+// Metrics are sent in the DogStatsD format, with view tags as statsd tags.
+// Count and sum views are sent as counters of the change since the last
+// push, last value views as gauges, and distributions as the count and sum
+// counters of their samples.
+type StatsdPusher struct {
+	conn   net.Conn
+	prefix string
+	views  []*view.View
+
+	// cumulative values sent on the last push, by metric line key
+	last map[string]float64
+}
+
+// NewStatsdPusher creates a pusher sending the data of views to the statsd
+// collector at addr (host:port) over UDP. Metric names are prefixed with
+// prefix, with slashes replaced by dots.
+func NewStatsdPusher(addr, prefix string, views []*view.View) (*StatsdPusher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, xerrors.Errorf("dialing statsd collector: %w", err)
+	}
+
+	return &StatsdPusher{
+		conn:   conn,
+		prefix: prefix,
+		views:  views,
+		last:   map[string]float64{},
+	}, nil
+}
+
+// Run pushes metrics every interval until ctx is done.
+func (p *StatsdPusher) Run(ctx context.Context, interval time.Duration) {
+	defer p.conn.Close() //nolint:errcheck
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := p.Push(); err != nil {
+			log.Warnw("pushing metrics to statsd", "error", err)
+		}
+	}
+}
+
+// Push sends the current data of all views.
+func (p *StatsdPusher) Push() error {
+	var lines []string
+	for _, v := range p.views {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			// not registered
+			continue
+		}
+		lines = append(lines, p.lines(v, rows)...)
+	}
+
+	return p.send(lines)
+}
+
+func (p *StatsdPusher) lines(v *view.View, rows []*view.Row) []string {
+	name := p.prefix + strings.ReplaceAll(v.Name, "/", ".")
+
+	var out []string
+	for _, r := range rows {
+		tags := statsdTags(r)
+
+		switch d := r.Data.(type) {
+		case *view.CountData:
+			out = p.counter(out, name, tags, float64(d.Value))
+		case *view.SumData:
+			out = p.counter(out, name, tags, d.Value)
+		case *view.LastValueData:
+			out = append(out, name+":"+formatFloat(d.Value)+"|g"+tags)
+		case *view.DistributionData:
+			out = p.counter(out, name+".count", tags, float64(d.Count))
+			out = p.counter(out, name+".sum", tags, d.Sum())
+		}
+	}
+	return out
+}
+
+// counter appends the line of a counter, with the change of the cumulative
+// value since the last push. Unchanged counters aren't sent.
+func (p *StatsdPusher) counter(out []string, name, tags string, cumulative float64) []string {
+	key := name + tags
+	delta := cumulative - p.last[key]
+	p.last[key] = cumulative
+	if delta < 0 {
+		// the view was reset
+		delta = cumulative
+	}
+	if delta == 0 {
+		return out
+	}
+	return append(out, name+":"+formatFloat(delta)+"|c"+tags)
+}
+
+func (p *StatsdPusher) send(lines []string) error {
+	var buf bytes.Buffer
+	flush := func() error {
+		if buf.Len() == 0 {
+			return nil
+		}
+		_, err := p.conn.Write(buf.Bytes())
+		buf.Reset()
+		return err
+	}
+
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > maxStatsdPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	return flush()
+}
+
+func statsdTags(r *view.Row) string {
+	if len(r.Tags) == 0 {
+		return ""
+	}
+
+	tags := make([]string, 0, len(r.Tags))
+	for _, t := range r.Tags {
+		tags = append(tags, statsdEscape(t.Key.Name())+":"+statsdEscape(t.Value))
+	}
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// statsdEscape replaces the characters which delimit parts of a line.
+func statsdEscape(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
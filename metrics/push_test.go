@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestStatsdPusher(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	key := tag.MustNewKey("kind")
+	m := stats.Int64("test/push_events", "", stats.UnitDimensionless)
+	g := stats.Float64("test/push_level", "", stats.UnitDimensionless)
+	views := []*view.View{
+		{Measure: m, Aggregation: view.Count(), TagKeys: []tag.Key{key}},
+		{Measure: g, Aggregation: view.LastValue()},
+	}
+	require.NoError(t, view.Register(views...))
+	defer view.Unregister(views...)
+
+	p, err := NewStatsdPusher(conn.LocalAddr().String(), "lotus.", views)
+	require.NoError(t, err)
+
+	read := func() []string {
+		buf := make([]byte, maxStatsdPacket)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return strings.Split(string(buf[:n]), "\n")
+	}
+
+	ctx, err := tag.New(context.Background(), tag.Upsert(key, "a:b"))
+	require.NoError(t, err)
+	stats.Record(ctx, m.M(1), m.M(1), m.M(1))
+	stats.Record(context.Background(), g.M(2.5))
+
+	require.NoError(t, p.Push())
+	require.ElementsMatch(t, []string{
+		"lotus.test.push_events:3|c|#kind:a_b",
+		"lotus.test.push_level:2.5|g",
+	}, read())
+
+	// counters are sent as the change since the last push, unchanged ones are left out
+	stats.Record(ctx, m.M(1))
+	require.NoError(t, p.Push())
+	require.ElementsMatch(t, []string{
+		"lotus.test.push_events:1|c|#kind:a_b",
+		"lotus.test.push_level:2.5|g",
+	}, read())
+
+	require.NoError(t, p.Push())
+	require.Equal(t, []string{"lotus.test.push_level:2.5|g"}, read())
+}
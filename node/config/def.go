@@ -351,7 +351,28 @@ func DefaultUserRaftConfig() *UserRaftConfig {
 
 func DefaultLotusProvider() *LotusProviderConfig {
 	return &LotusProviderConfig{
-		Subsystems: ProviderSubsystemsConfig{},
+		Subsystems: ProviderSubsystemsConfig{
+			WindowPostSubmitWait:    "mempool",
+			SafeModeChecks:          []string{"deadlines", "proof"},
+			SafeModeRetryInterval:   Duration(time.Minute),
+			TaskPollInterval:        Duration(3 * time.Second),
+			WindowPostAffinityGrace: Duration(30 * time.Second),
+			AllowTaskTypes:          []string{},
+			DenyTaskTypes:           []string{},
+
+			WindowPostVanillaCacheTTL: Duration(30 * time.Minute),
+
+			RemoteWindowPostTimeout:  Duration(10 * time.Minute),
+			RemoteWinningPostTimeout: Duration(10 * time.Second),
+
+			SectorSyncInterval:  Duration(5 * time.Minute),
+			HistoryRetention:    Duration(30 * 24 * time.Hour),
+			SpotCheckInterval:   Duration(time.Hour),
+			SpotCheckSampleSize: 16,
+			CanarySectors:       []string{},
+
+			VerifregCheckInterval: Duration(10 * time.Minute),
+		},
 		Fees: LotusProviderFees{
 			DefaultMaxFee:      DefaultDefaultMaxFee,
 			MaxPreCommitGasFee: types.MustParseFIL("0.025"),
@@ -369,16 +390,51 @@ func DefaultLotusProvider() *LotusProviderConfig {
 			MaxTerminateGasFee:  types.MustParseFIL("0.5"),
 			MaxWindowPoStGasFee: types.MustParseFIL("5"),
 			MaxPublishDealsFee:  types.MustParseFIL("0.05"),
+
+			WindowPoStGasLimit: LotusProviderGasLimit{
+				Multiplier: 1,
+			},
 		},
 		Addresses: LotusProviderAddresses{
 			PreCommitControl: []string{},
 			CommitControl:    []string{},
 			TerminateControl: []string{},
+			FallbackPolicy:   "worker",
+
+			LowBalanceThreshold:  types.MustParseFIL("1"),
+			BalanceCheckInterval: Duration(5 * time.Minute),
 		},
 		Proving: ProvingConfig{
 			ParallelCheckLimit:    32,
 			PartitionCheckTimeout: Duration(20 * time.Minute),
 			SingleCheckTimeout:    Duration(10 * time.Minute),
+
+			VerifyFailurePolicy: "recompute",
+		},
+		Storage: LotusProviderStorageConfig{
+			HeartbeatInterval:   Duration(10 * time.Second),
+			DeclareRetries:      5,
+			DeclareRetryTimeout: Duration(2 * time.Minute),
+			PartialFileHandler:  "default",
+
+			FetchDialTimeout:     Duration(30 * time.Second),
+			FetchKeepAlive:       Duration(30 * time.Second),
+			FetchIdleConnTimeout: Duration(90 * time.Second),
+			FetchMaxIdleConns:    100,
+
+			FetchCompressTypes: []string{"cache", "update-cache"},
+		},
+		Apis: ApisConfig{
+			ChainApiBreakerThreshold: 5,
+			ChainApiBreakerCooldown:  Duration(30 * time.Second),
+		},
+		Tracing: LotusProviderTracingConfig{
+			ServiceName: "lotus-provider",
+			SampleRatio: 1,
+		},
+		Metrics: LotusProviderMetricsConfig{
+			PushInterval: Duration(10 * time.Second),
+			PushPrefix:   "lotus.",
 		},
 	}
 }
@@ -36,6 +36,22 @@ var Doc = map[string][]DocField{
 
 			Comment: `ChainApiInfo is the API endpoint for the Lotus daemon.`,
 		},
+		{
+			Name: "ChainApiBreakerThreshold",
+			Type: "int",
+
+			Comment: `ChainApiBreakerThreshold is the number of consecutive calls to the Lotus
+daemon which may fail with a connection error or time out before calls
+start failing fast, to give an overloaded daemon time to recover. Tasks
+aren't started while calls fail fast. Set to 0 to disable.`,
+		},
+		{
+			Name: "ChainApiBreakerCooldown",
+			Type: "Duration",
+
+			Comment: `ChainApiBreakerCooldown is how long calls fail fast before a single
+probe call is let through to check whether the daemon recovered.`,
+		},
 		{
 			Name: "StorageRPCSecret",
 			Type: "string",
@@ -44,6 +60,40 @@ var Doc = map[string][]DocField{
 If integrating with lotus-miner this must match the value from
 cat ~/.lotusminer/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU | jq -r .PrivateKey`,
 		},
+		{
+			Name: "MinerKeystorePath",
+			Type: "string",
+
+			Comment: `MinerKeystorePath is the keystore of a lotus-miner repo, e.g.
+~/.lotusminer/keystore, to read the storage RPC secret from when
+StorageRPCSecret isn't set. When the repo has an api file, the secret is
+checked against the storage endpoint of the miner at startup.`,
+		},
+		{
+			Name: "HTTPPathPrefix",
+			Type: "string",
+
+			Comment: `HTTPPathPrefix mounts all HTTP routes (/rpc, /remote, /debug/metrics,
+pprof) under the given path, e.g. "/provider1", for deployments behind
+a reverse proxy which routes on a path prefix. Empty mounts at root.`,
+		},
+		{
+			Name: "TrustForwardedHeaders",
+			Type: "bool",
+
+			Comment: `TrustForwardedHeaders makes the HTTP server take the client address and
+scheme from the X-Forwarded-For and X-Forwarded-Proto headers. Only
+enable this when the provider is reachable exclusively through a proxy
+which sets these headers.`,
+		},
+		{
+			Name: "RequestLogging",
+			Type: "bool",
+
+			Comment: `RequestLogging enables an access log entry (method, path, status,
+duration, remote address and request ID) for every HTTP request.
+Off by default, as busy nodes serve many requests.`,
+		},
 	},
 	"Backup": {
 		{
@@ -410,6 +460,23 @@ the database must already exist and be writeable. If a relative path is provided
 relative to the CWD (current working directory).`,
 		},
 	},
+	"ExternalSignerConfig": {
+		{
+			Name: "ApiInfo",
+			Type: "string",
+
+			Comment: `ApiInfo of a lotus-wallet compatible signer endpoint, in the
+"token:multiaddr" form. Empty disables the external signer, and all
+messages are signed by the full node's wallet.`,
+		},
+		{
+			Name: "Addresses",
+			Type: "[]string",
+
+			Comment: `Addresses (key addresses) whose messages are signed by the external
+signer. When empty, the external signer is used for all messages.`,
+		},
+	},
 	"FaultReporterConfig": {
 		{
 			Name: "EnableConsensusFaultReporter",
@@ -526,6 +593,18 @@ Set to 0 to keep all mappings`,
 
 			Comment: `HOSTS is a list of hostnames to nodes running YugabyteDB
 in a cluster. Only 1 is required`,
+		},
+		{
+			Name: "ReadReplicas",
+			Type: "[]string",
+
+			Comment: `ReadReplicas is a list of hostnames of read replicas of the database.
+Reads which tolerate stale data, like listings of the cluster's nodes
+and tasks and history queries, are sent to the replicas in turns, while
+writes such as task claims and heartbeats, and the reads they depend on,
+always go to Hosts. Replicas which can't be reached are skipped for a
+while, their reads go to Hosts. Empty to read from Hosts only. Only used
+by lotus-provider, which sets it with --db-read-replicas.`,
 		},
 		{
 			Name: "Username",
@@ -723,6 +802,22 @@ sent automatically`,
 sent automatically, if control addresses are configured.
 A control address that doesn't have enough funds will still be chosen
 over the worker address if this flag is set.`,
+		},
+		{
+			Name: "FallbackPolicy",
+			Type: "string",
+
+			Comment: `FallbackPolicy is what to do when none of the control addresses for a
+message has more than the minimum funds for it, or a key in the wallet:
+"error" fails sending the message, "worker" sends it from the worker
+address and "owner" from the owner address. Falling back raises an
+alert. With an empty policy the owner and worker addresses are
+candidates like the control addresses, and the one with the most funds
+is used, subject to DisableOwnerFallback and DisableWorkerFallback
+which are ignored otherwise; using the owner or worker still raises
+the alert. The empty policy is how lotus-miner selects addresses, and
+is kept for setups moved from it; it sends from the owner address
+unless DisableOwnerFallback is set.`,
 		},
 		{
 			Name: "MinerAddresses",
@@ -730,6 +825,27 @@ over the worker address if this flag is set.`,
 
 			Comment: `MinerAddresses are the addresses of the miner actors to use for sending messages`,
 		},
+		{
+			Name: "LowBalanceThreshold",
+			Type: "types.FIL",
+
+			Comment: `LowBalanceThreshold raises an alert when the balance of a worker or
+control address of any MinerAddresses falls below it. Balances are
+exported as metrics either way. Set to 0 to disable the alert.`,
+		},
+		{
+			Name: "BalanceCheckInterval",
+			Type: "Duration",
+
+			Comment: `BalanceCheckInterval is how often address balances are checked.`,
+		},
+		{
+			Name: "ExternalSigner",
+			Type: "ExternalSignerConfig",
+
+			Comment: `ExternalSigner delegates message signing for some or all sending
+addresses to a remote signer instead of the full node wallet.`,
+		},
 	},
 	"LotusProviderConfig": {
 		{
@@ -756,6 +872,12 @@ over the worker address if this flag is set.`,
 
 			Comment: ``,
 		},
+		{
+			Name: "Storage",
+			Type: "LotusProviderStorageConfig",
+
+			Comment: ``,
+		},
 		{
 			Name: "Journal",
 			Type: "JournalConfig",
@@ -766,6 +888,92 @@ over the worker address if this flag is set.`,
 			Name: "Apis",
 			Type: "ApisConfig",
 
+			Comment: ``,
+		},
+		{
+			Name: "Tracing",
+			Type: "LotusProviderTracingConfig",
+
+			Comment: ``,
+		},
+		{
+			Name: "Metrics",
+			Type: "LotusProviderMetricsConfig",
+
+			Comment: ``,
+		},
+		{
+			Name: "Node",
+			Type: "LotusProviderNodeConfig",
+
+			Comment: ``,
+		},
+	},
+	"LotusProviderFeeWindow": {
+		{
+			Name: "FromEpoch",
+			Type: "int64",
+
+			Comment: `FromEpoch and ToEpoch make the window cover the chain epochs from
+FromEpoch up to, but not including, ToEpoch. Leave both at 0 for a
+window repeating every day.`,
+		},
+		{
+			Name: "ToEpoch",
+			Type: "int64",
+
+			Comment: ``,
+		},
+		{
+			Name: "DayStart",
+			Type: "int64",
+
+			Comment: `DayStart and DayEnd make the window cover the epochs of every day from
+DayStart up to, but not including, DayEnd. The epoch of the day is the
+epoch modulo 2880, the epochs in a day, so days start at the time of day
+of the genesis of the network. A window with DayEnd below DayStart
+wraps around the start of the day.`,
+		},
+		{
+			Name: "DayEnd",
+			Type: "int64",
+
+			Comment: ``,
+		},
+		{
+			Name: "DefaultMaxFee",
+			Type: "string",
+
+			Comment: `Fee caps in FIL, e.g. "10 FIL". Empty values don't change the cap.`,
+		},
+		{
+			Name: "MaxPreCommitGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxCommitGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxTerminateGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxWindowPoStGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxPublishDealsFee",
+			Type: "string",
+
 			Comment: ``,
 		},
 	},
@@ -818,6 +1026,287 @@ over the worker address if this flag is set.`,
 
 			Comment: ``,
 		},
+		{
+			Name: "WindowPoStGasLimit",
+			Type: "LotusProviderGasLimit",
+
+			Comment: `WindowPoStGasLimit adjusts the gas limit estimated for
+SubmitWindowedPoSt messages, to guard against estimates which are off
+during congestion.`,
+		},
+		{
+			Name: "MinerOverrides",
+			Type: "[]LotusProviderMinerFees",
+
+			Comment: `MinerOverrides replace the fee caps above for individual miners. Fields
+left unset in an override fall back to the values above. Every Address
+must be one of the miners the provider is configured for.`,
+		},
+		{
+			Name: "FeeSchedule",
+			Type: "[]LotusProviderFeeWindow",
+
+			Comment: `FeeSchedule replaces the fee caps above while a window of epochs is
+current, e.g. to bid higher during hours of known congestion. Caps are
+resolved at the epoch messages are sent at. The first window listed
+which covers the epoch applies, fields left unset in it fall back to
+the miner override, then to the values above. Empty keeps the caps
+static.`,
+		},
+	},
+	"LotusProviderGasLimit": {
+		{
+			Name: "Multiplier",
+			Type: "float64",
+
+			Comment: `Multiplier scales the estimated gas limit, e.g. 1.1 for 10% more
+headroom on top of the overestimation of the chain node.`,
+		},
+		{
+			Name: "Min",
+			Type: "int64",
+
+			Comment: `Min and Max bound the scaled gas limit, 0 leaves it unbounded. The fee
+of the message stays within the fee cap: when the adjusted limit
+would exceed it, the gas fee cap of the message is lowered instead.`,
+		},
+		{
+			Name: "Max",
+			Type: "int64",
+
+			Comment: ``,
+		},
+	},
+	"LotusProviderMetricsConfig": {
+		{
+			Name: "PushProtocol",
+			Type: "string",
+
+			Comment: `PushProtocol enables pushing metrics to a collector, for nodes which
+can't be scraped. Metrics are still served at /debug/metrics. The only
+supported protocol is "statsd", which sends the metrics over UDP in the
+DogStatsD format, with tags. Empty disables pushing.`,
+		},
+		{
+			Name: "PushEndpoint",
+			Type: "string",
+
+			Comment: `PushEndpoint is the host:port of the collector.`,
+		},
+		{
+			Name: "PushInterval",
+			Type: "Duration",
+
+			Comment: `PushInterval is how often metrics are pushed, must be positive.`,
+		},
+		{
+			Name: "PushPrefix",
+			Type: "string",
+
+			Comment: `PushPrefix is prepended to the names of pushed metrics.`,
+		},
+		{
+			Name: "LegacyAggregates",
+			Type: "bool",
+
+			Comment: `LegacyAggregates exports the metrics which are tagged by miner without
+the miner_id tag, summed over all miners, under their existing names, for
+dashboards built before the tag was added. The per-miner series aren't
+exported then. Gauges aren't aggregated and stay per miner.
+
+This option is transitional. It will be removed in the 1.27 release, so
+dashboards should be migrated to aggregate over miner_id themselves,
+e.g. with "sum without (miner_id)", before then.`,
+		},
+	},
+	"LotusProviderMinerFees": {
+		{
+			Name: "Address",
+			Type: "string",
+
+			Comment: `Address of the miner actor the override applies to`,
+		},
+		{
+			Name: "DefaultMaxFee",
+			Type: "string",
+
+			Comment: `Fee caps in FIL, e.g. "10 FIL". Empty values use the global cap.`,
+		},
+		{
+			Name: "MaxPreCommitGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxCommitGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxTerminateGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxWindowPoStGasFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "MaxPublishDealsFee",
+			Type: "string",
+
+			Comment: ``,
+		},
+	},
+	"LotusProviderNodeConfig": {
+		{
+			Name: "Name",
+			Type: "string",
+
+			Comment: `Name is the name of the node, recorded in its harmony_machines row and
+the history of the tasks it runs, in the node_name tag of the info
+metric and in its journal events. Defaults to the hostname.`,
+		},
+		{
+			Name: "Labels",
+			Type: "map[string]string",
+
+			Comment: `Labels are exported in the node_label metric, one series per label
+tagged with node_name, node_label and node_label_value, and recorded in
+the harmony_machines row of the node, e.g. rack = "r12".`,
+		},
+	},
+	"LotusProviderStorageConfig": {
+		{
+			Name: "HeartbeatInterval",
+			Type: "Duration",
+
+			Comment: `HeartbeatInterval is how often the health of local storage paths is
+reported to the storage index. A path which stopped being reported, or
+was detached from the index, e.g. after a database outage, is attached
+again on the next successful heartbeat. Must be shorter than the 50s
+after which paths missing heartbeats are considered down.`,
+		},
+		{
+			Name: "DeclareRetries",
+			Type: "int",
+
+			Comment: `DeclareRetries is how many times declaring the local storage paths in
+the storage index is retried at startup, so that a database or storage
+outage during a rolling restart doesn't fail startup. Retries back off
+from 1s up to 30s between attempts. Set to 0 to fail on the first error.`,
+		},
+		{
+			Name: "DeclareRetryTimeout",
+			Type: "Duration",
+
+			Comment: `DeclareRetryTimeout bounds the time spent retrying the declaration;
+startup fails with the last error once it has passed.`,
+		},
+		{
+			Name: "PartialFileHandler",
+			Type: "string",
+
+			Comment: `PartialFileHandler selects the implementation used to access unsealed
+(partial) sector files, both locally and when serving them to other nodes.
+Implementations are registered with paths.RegisterPartialFileHandler;
+"default" is the built-in handler.`,
+		},
+		{
+			Name: "FetchDialTimeout",
+			Type: "Duration",
+
+			Comment: `FetchDialTimeout limits the time spent establishing a TCP connection to
+another storage node, when fetching sector data or querying it. The
+Fetch* settings default to the values of Go's default HTTP transport.`,
+		},
+		{
+			Name: "FetchKeepAlive",
+			Type: "Duration",
+
+			Comment: `FetchKeepAlive is the TCP keep-alive period of fetch connections.`,
+		},
+		{
+			Name: "FetchResponseHeaderTimeout",
+			Type: "Duration",
+
+			Comment: `FetchResponseHeaderTimeout limits the time spent waiting for a remote
+node to start responding after a request was sent. This doesn't limit
+the time to read the response body. 0 means no limit.
+Remote reads are on the critical path of WindowPoSt for sectors stored
+on other nodes; a limit helps detect dead peers early, but must be
+generous enough for the slowest link under full proving load.`,
+		},
+		{
+			Name: "FetchIdleConnTimeout",
+			Type: "Duration",
+
+			Comment: `FetchIdleConnTimeout is how long idle connections are kept open for
+reuse.`,
+		},
+		{
+			Name: "FetchMaxIdleConns",
+			Type: "int",
+
+			Comment: `FetchMaxIdleConns limits the number of idle connections kept open
+across all remote nodes. 0 means no limit.`,
+		},
+		{
+			Name: "FetchMaxIdleConnsPerHost",
+			Type: "int",
+
+			Comment: `FetchMaxIdleConnsPerHost limits the number of idle connections kept
+open to each remote node. Raise this when fetching many files from
+the same node concurrently. 0 uses Go's default (2).`,
+		},
+		{
+			Name: "FetchCompressTypes",
+			Type: "[]string",
+
+			Comment: `FetchCompressTypes lists the sector file types ("cache", "update-cache",
+"unsealed", ...) which are compressed in transit, both when this node
+fetches them and when it serves them to other nodes. Compression is
+only used when both sides support it, and is skipped for files which
+don't compress well. Sealed and update files are effectively random
+data and gain nothing from it. Set to an empty list to disable.`,
+		},
+	},
+	"LotusProviderTracingConfig": {
+		{
+			Name: "Enabled",
+			Type: "bool",
+
+			Comment: `Enabled sends OpenTelemetry traces of task runs, chain head
+processing, message sends and HTTP requests to a Jaeger collector.
+The LOTUS_JAEGER_* environment variables also enable tracing, this
+section takes precedence when enabled.`,
+		},
+		{
+			Name: "JaegerCollectorEndpoint",
+			Type: "string",
+
+			Comment: `JaegerCollectorEndpoint is the HTTP(S) URL of the collector, e.g.
+"http://localhost:14268/api/traces".`,
+		},
+		{
+			Name: "ServiceName",
+			Type: "string",
+
+			Comment: `ServiceName is the service traces are reported under.`,
+		},
+		{
+			Name: "SampleRatio",
+			Type: "float64",
+
+			Comment: `SampleRatio is the fraction of traces which are recorded, between 0
+and 1. Spans of a sampled parent, e.g. from another node, are always
+recorded, as are WindowPoSt runs.`,
+		},
 	},
 	"MinerAddressConfig": {
 		{
@@ -1001,6 +1490,39 @@ block rewards will be missed!`,
 
 			Comment: ``,
 		},
+		{
+			Name: "WindowPostClusterMaxTasks",
+			Type: "int",
+
+			Comment: `WindowPostClusterMaxTasks is the most WindowPoSt compute tasks running at
+once across all nodes of the cluster, so that adding nodes can't overload
+the infrastructure they share, like the full node or shared storage. Nodes
+count the running tasks in the database before claiming one, a node takes
+a task only while both its own WindowPostMaxTasks and this cap leave
+room, so the fleet runs at most the lower of this and the sum of the
+per-node caps. Set the same value on all nodes, each node enforces the
+value it has. 0 removes the cluster-wide cap.`,
+		},
+		{
+			Name: "WindowPostMaxFetches",
+			Type: "int",
+
+			Comment: `WindowPostMaxFetches is the most fetches of whole sector files a
+single WindowPoSt task runs at once, so that proving one partition can't
+take all fetch slots of the node or overload the storage it reads from.
+Remote vanilla proofs and reads aren't capped. 0, the default, removes
+the per-task cap.`,
+		},
+		{
+			Name: "WindowPostSubmitWait",
+			Type: "string",
+
+			Comment: `WindowPostSubmitWait is how WindowPoSt submit tasks wait for their
+message. With "mempool" the task completes once the message is in the
+message pool, and its execution is recorded in the background. With
+"confirmed" the task runs until the message is executed, a message
+which failed to execute fails the task.`,
+		},
 		{
 			Name: "EnableWinningPost",
 			Type: "bool",
@@ -1013,6 +1535,264 @@ block rewards will be missed!`,
 
 			Comment: ``,
 		},
+		{
+			Name: "ProvingCPUFallback",
+			Type: "bool",
+
+			Comment: `ProvingCPUFallback makes WindowPoSt and WinningPoSt switch to proving
+on the CPU when proving fails with a GPU error, e.g. the driver crashed
+or the device went away, instead of failing every proof until the node
+is restarted. CPU proofs are computed in a child process, the GPU setup
+of the node is unchanged. The switch lasts until the restart and raises
+an alert, CPU proving of large partitions may be too slow to meet the
+deadline, so it is off by default and proving fails fast.`,
+		},
+		{
+			Name: "RemoteWindowPostProver",
+			Type: "string",
+
+			Comment: `RemoteWindowPostProver and RemoteWinningPostProver are the API info,
+as token:multiaddr or token:URL, of proving services which compute the
+snarks of WindowPoSt and WinningPoSt from the vanilla proofs read by
+this node, for operators who keep their GPUs behind such a service.
+Services implement GenerateWindowPoStWithVanilla and
+GenerateWinningPoStWithVanilla over JSON-RPC on /rpc/v0. Proofs are
+computed locally when empty, or when the service fails or can't be
+reached; the proving_remote_fallbacks metric counts those.`,
+		},
+		{
+			Name: "RemoteWinningPostProver",
+			Type: "string",
+
+			Comment: ``,
+		},
+		{
+			Name: "RemoteWindowPostTimeout",
+			Type: "Duration",
+
+			Comment: `RemoteWindowPostTimeout and RemoteWinningPostTimeout bound each request
+to the remote provers; a request taking longer is abandoned and the
+proof is computed locally. A WinningPoSt must be computed within the
+epoch it was won, so its timeout leaves time to prove locally.`,
+		},
+		{
+			Name: "RemoteWinningPostTimeout",
+			Type: "Duration",
+
+			Comment: ``,
+		},
+		{
+			Name: "SafeModeChecks",
+			Type: "[]string",
+
+			Comment: `SafeModeChecks are the health checks which must pass after startup
+before this node sends any messages, so that a node restarted in a bad
+state doesn't submit proofs or declarations based on stale local state.
+"proof" computes and verifies a WinningPoSt over a live sector, it only
+runs on nodes with EnableWindowPost or EnableWinningPost. "deadlines"
+checks that the chain head is current, and that the partitions of the
+open deadline recorded as proven are proven on chain. An alert is
+raised while sending is held. Empty disables the gate.`,
+		},
+		{
+			Name: "SafeModeRetryInterval",
+			Type: "Duration",
+
+			Comment: `SafeModeRetryInterval is how often failing safe mode checks are retried.`,
+		},
+		{
+			Name: "AllowTaskTypes",
+			Type: "[]string",
+
+			Comment: `AllowTaskTypes, when not empty, lists the only task types this node
+claims, e.g. ["WdPost"] for a node with a GPU which only computes proofs.
+DenyTaskTypes lists task types this node doesn't claim, e.g.
+["WdPost", "WinPost"] for a node which only submits. The Enable flags
+still decide which subsystems run here: a node with EnableWindowPost keeps
+scheduling the WindowPoSt tasks of its miners, whichever of the types it
+claims, and raises an alert while no live node claims a type it adds.
+Types are WdPost, WdPostSubmit, WdPostRecover, WinPost, SendMessage,
+WdPostSpotChk, WdPostCanary, WdPostWarm and HistoryPrune.`,
+		},
+		{
+			Name: "DenyTaskTypes",
+			Type: "[]string",
+
+			Comment: ``,
+		},
+		{
+			Name: "TaskPollInterval",
+			Type: "Duration",
+
+			Comment: `TaskPollInterval is how often the database is checked for tasks to
+claim. Tasks added or finished on this node are claimed right away,
+so the interval bounds how long tasks added by other nodes wait before
+this node picks them up. Each poll runs a few queries per enabled task
+type, so shorter intervals add database load from every node in the
+cluster, longer ones add latency to work handed between nodes.`,
+		},
+		{
+			Name: "EnableTaskNotify",
+			Type: "bool",
+
+			Comment: `EnableTaskNotify makes nodes announce added tasks with Postgres
+LISTEN/NOTIFY, so that idle nodes claim them right away instead of on
+their next poll, e.g. for low latency WinningPoSt. Polling remains as
+the fallback for missed notifications. Only PostgreSQL supports it, on
+YugabyteDB the node logs a warning and keeps polling. Enable on all
+nodes, only nodes with it enabled announce their tasks.`,
+		},
+		{
+			Name: "ResourceCPUs",
+			Type: "int",
+
+			Comment: `ResourceCPUs, ResourceRAM and ResourceGPUs declare the resources of
+this node for running tasks, overriding the detected CPU count, free
+memory at startup and GPU count. 0 or empty keeps the detected value.
+Tasks are only claimed while their declared cost fits in what the
+tasks running on the node leave free. Queued tasks which fit on no
+node running their type raise an alert.`,
+		},
+		{
+			Name: "ResourceRAM",
+			Type: "string",
+
+			Comment: `ResourceRAM is a size like 256GiB.`,
+		},
+		{
+			Name: "ResourceGPUs",
+			Type: "float64",
+
+			Comment: `ResourceGPUs is the number of GPUs, tasks may declare fractions of one.`,
+		},
+		{
+			Name: "WindowPostStorageAffinity",
+			Type: "bool",
+
+			Comment: `WindowPostStorageAffinity makes WindowPoSt partitions prefer the nodes
+which have the sealed files of their sectors in local storage paths,
+so that proving doesn't fetch them over the network. The paths holding
+each partition are read from the storage index when it is scheduled.
+A path is local to every node listing it in its storage.json, so a
+storage group shared by several nodes gives all of them affinity.
+Nodes without local access take a partition once WindowPostAffinityGrace
+has passed, or right away when none of its paths is heartbeating.
+Enable on all nodes with EnableWindowPost; the wdpost_task_locality
+metric counts proven partitions by locality to verify the effect.`,
+		},
+		{
+			Name: "WindowPostAffinityGrace",
+			Type: "Duration",
+
+			Comment: `WindowPostAffinityGrace is how long nodes without local access to the
+storage of a partition leave it to the co-located nodes.`,
+		},
+		{
+			Name: "WindowPostVanillaCache",
+			Type: "bool",
+
+			Comment: `WindowPostVanillaCache keeps the vanilla proofs read from sector files
+for WindowPoSt challenges in the database for WindowPostVanillaCacheTTL,
+so that proving a partition again for the same challenges, e.g. after
+the snark failed or a reorg, doesn't read the sectors again. Each sector
+keeps the proof for the challenges it was last proven for, which new
+randomness replaces. Uses database space for every proven sector; the
+wdpost_vanilla_proof_ms metric compares the cached and uncached reads.`,
+		},
+		{
+			Name: "WindowPostVanillaCacheTTL",
+			Type: "Duration",
+
+			Comment: `WindowPostVanillaCacheTTL is how long cached vanilla proofs are used.`,
+		},
+		{
+			Name: "SectorSyncInterval",
+			Type: "Duration",
+
+			Comment: `SectorSyncInterval is how often nodes with EnableWindowPost read the
+live sector sets of the miners from chain, and rescan local storage
+when newly committed sectors, e.g. sealed by a separate lotus-miner,
+aren't in the storage index yet. Set to 0 to disable.`,
+		},
+		{
+			Name: "EnableHistoryPruning",
+			Type: "bool",
+
+			Comment: `EnableHistoryPruning periodically deletes task history, and settled
+message sends, older than HistoryRetention. Only one node in the
+cluster prunes at a time.`,
+		},
+		{
+			Name: "HistoryRetention",
+			Type: "Duration",
+
+			Comment: `HistoryRetention is how long task and message history is kept when
+history pruning is enabled.`,
+		},
+		{
+			Name: "EnableSpotCheck",
+			Type: "bool",
+
+			Comment: `EnableSpotCheck periodically checks a random sample of sectors for
+readability, independently of WindowPoSt. The check yields to any
+pending WindowPoSt work.`,
+		},
+		{
+			Name: "SpotCheckInterval",
+			Type: "Duration",
+
+			Comment: `SpotCheckInterval is how often each miner's sectors are sampled.`,
+		},
+		{
+			Name: "SpotCheckSampleSize",
+			Type: "int",
+
+			Comment: `SpotCheckSampleSize is the number of sectors checked per miner in
+each interval.`,
+		},
+		{
+			Name: "CanarySectors",
+			Type: "[]string",
+
+			Comment: `CanarySectors are sectors, as <miner>:<sector number> e.g. "f01234:42",
+read-checked through the fault tracker at the start of every proving
+period of their miner, regardless of their deadline. A failed check
+raises an alert right away, well before the deadline of a sector
+affected by the same storage problem fails. Nodes with canary sectors
+set schedule and run the checks; results are kept in
+wdpost_canary_checks. Pick sectors spread over the storage paths.`,
+		},
+		{
+			Name: "EnableProvingWarmup",
+			Type: "bool",
+
+			Comment: `EnableProvingWarmup checks ahead of each WindowPoSt deadline that the
+sealed and cache files of its sectors are present on this node, and
+reports files which can't be found anywhere. The check yields to any
+pending WindowPoSt work.`,
+		},
+		{
+			Name: "ProvingWarmupFetch",
+			Type: "bool",
+
+			Comment: `ProvingWarmupFetch makes the warmup fetch files which are only stored
+on other nodes. Fetches use network bandwidth and local storage space.`,
+		},
+		{
+			Name: "ExpectedVerifregRootKey",
+			Type: "string",
+
+			Comment: `ExpectedVerifregRootKey, when set, makes the provider monitor the root
+key of the verified registry actor, logging any change of it and
+raising an alert while it doesn't match this address.`,
+		},
+		{
+			Name: "VerifregCheckInterval",
+			Type: "Duration",
+
+			Comment: `VerifregCheckInterval is how often the verified registry root key is
+checked.`,
+		},
 	},
 	"ProvingConfig": {
 		{
@@ -1137,6 +1917,51 @@ with recovering sectors in the post message
 Note that setting this value lower may result in less efficient gas use - more messages will be sent,
 to prove each deadline, resulting in more total gas use (but each message will have lower gas limit)`,
 		},
+		{
+			Name: "DeadlineSafetyMargin",
+			Type: "int",
+
+			Comment: `Number of epochs by which WindowPoSt for a deadline is started ahead of the deadline opening. Only used by
+lotus-provider.
+
+A positive margin starts computing proofs before the deadline opens, as soon as its challenge is known (at most
+20 epochs ahead); proofs are still submitted once the deadline opens. A negative margin waits for the challenge to
+get deeper in the chain, wasting less work on reorgs, and delays both computing and submitting proofs by that
+many epochs after the deadline opens.
+
+The margin is clamped so that proofs are always submitted at least 10 epochs before the deadline closes.`,
+		},
+		{
+			Name: "MinerDeadlineSafetyMargins",
+			Type: "[]ProvingMinerSafetyMargin",
+
+			Comment: `Per-miner overrides of DeadlineSafetyMargin. Only used by lotus-provider.`,
+		},
+		{
+			Name: "VerifyFailurePolicy",
+			Type: "string",
+
+			Comment: `Action taken when a WindowPoSt proof fails local verification after being computed. Only used by lotus-provider.
+
+"recompute" fails the task, which is retried and computes the proof again. "hold" keeps the proof from being
+submitted and raises an alert, leaving the partition to the operator. "submit" submits the proof anyway, which
+only helps when local verification is wrong, as an invalid proof fails on chain. Each policy records its own
+wdpost_verify_fail_* metric and wdpost:verify_failed_* journal event.`,
+		},
+	},
+	"ProvingMinerSafetyMargin": {
+		{
+			Name: "Address",
+			Type: "string",
+
+			Comment: `Address of the miner actor the margin applies to`,
+		},
+		{
+			Name: "Margin",
+			Type: "int",
+
+			Comment: `Deadline safety margin in epochs, see DeadlineSafetyMargin`,
+		},
 	},
 	"Pubsub": {
 		{
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ForMiner returns the fee caps which apply to maddr, with its entry from
+// MinerOverrides applied on top of the global values. Overrides are expected
+// to have been checked with ValidateMinerOverrides, values which don't parse
+// are ignored.
+func (f *LotusProviderFees) ForMiner(maddr address.Address) LotusProviderFees {
+	out := *f
+	out.MinerOverrides = nil
+	out.FeeSchedule = nil
+
+	for _, o := range f.MinerOverrides {
+		addr, err := address.NewFromString(o.Address)
+		if err != nil || addr != maddr {
+			continue
+		}
+
+		applyFees(o.fees(&out))
+	}
+
+	return out
+}
+
+// FeesAt returns the fee caps which apply to maddr at epoch: the first window
+// of FeeSchedule covering the epoch, applied on top of ForMiner. The schedule
+// is expected to have been checked with ValidateFeeSchedule.
+func (f *LotusProviderFees) FeesAt(maddr address.Address, epoch abi.ChainEpoch) LotusProviderFees {
+	out := f.ForMiner(maddr)
+
+	for i := range f.FeeSchedule {
+		w := &f.FeeSchedule[i]
+		if w.covers(epoch) {
+			applyFees(w.fees(&out))
+			break
+		}
+	}
+
+	return out
+}
+
+// covers tells if the window includes epoch.
+func (w *LotusProviderFeeWindow) covers(epoch abi.ChainEpoch) bool {
+	if w.FromEpoch != 0 || w.ToEpoch != 0 {
+		return int64(epoch) >= w.FromEpoch && int64(epoch) < w.ToEpoch
+	}
+
+	day := int64(epoch) % int64(builtin.EpochsInDay)
+	if w.DayStart <= w.DayEnd {
+		return day >= w.DayStart && day < w.DayEnd
+	}
+	// wraps around the start of the day
+	return day >= w.DayStart || day < w.DayEnd
+}
+
+// ValidateFeeSchedule checks that every window of FeeSchedule covers a
+// non-empty range given either as epochs or as epochs of the day, and that
+// all values parse.
+func (f *LotusProviderFees) ValidateFeeSchedule() error {
+	for i, w := range f.FeeSchedule {
+		epochs := w.FromEpoch != 0 || w.ToEpoch != 0
+		daily := w.DayStart != 0 || w.DayEnd != 0
+		switch {
+		case epochs && daily:
+			return xerrors.Errorf("fee schedule window %d: set either FromEpoch and ToEpoch, or DayStart and DayEnd", i)
+		case epochs:
+			if w.FromEpoch < 0 || w.ToEpoch <= w.FromEpoch {
+				return xerrors.Errorf("fee schedule window %d: ToEpoch (%d) must be after FromEpoch (%d)", i, w.ToEpoch, w.FromEpoch)
+			}
+		case daily:
+			if w.DayStart < 0 || w.DayStart >= int64(builtin.EpochsInDay) || w.DayEnd < 0 || w.DayEnd > int64(builtin.EpochsInDay) {
+				return xerrors.Errorf("fee schedule window %d: DayStart and DayEnd must be epochs of the day, between 0 and %d", i, builtin.EpochsInDay)
+			}
+			if w.DayStart == w.DayEnd {
+				return xerrors.Errorf("fee schedule window %d: DayStart and DayEnd are both %d, the window would be empty", i, w.DayStart)
+			}
+		default:
+			return xerrors.Errorf("fee schedule window %d: no epochs set", i)
+		}
+
+		for _, fee := range w.fees(&LotusProviderFees{}) {
+			if fee.value == "" {
+				continue
+			}
+			if _, err := types.ParseFIL(fee.value); err != nil {
+				return xerrors.Errorf("fee schedule window %d: parsing %s '%s': %w", i, fee.name, fee.value, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateGasLimits checks that WindowPoStGasLimit scales the estimates by a
+// positive factor, into a non-empty range.
+func (f *LotusProviderFees) ValidateGasLimits() error {
+	gl := f.WindowPoStGasLimit
+	if gl.Multiplier <= 0 {
+		return xerrors.Errorf("WindowPoStGasLimit.Multiplier must be positive, got %g", gl.Multiplier)
+	}
+	if gl.Min < 0 || gl.Max < 0 {
+		return xerrors.Errorf("WindowPoStGasLimit bounds can't be negative")
+	}
+	if gl.Max != 0 && gl.Min > gl.Max {
+		return xerrors.Errorf("WindowPoStGasLimit.Min (%d) is above WindowPoStGasLimit.Max (%d)", gl.Min, gl.Max)
+	}
+	return nil
+}
+
+// ValidateMinerOverrides checks that every entry in MinerOverrides is for one
+// of maddrs, that no miner is overridden twice and that all values parse.
+func (f *LotusProviderFees) ValidateMinerOverrides(maddrs []address.Address) error {
+	known := map[address.Address]bool{}
+	for _, maddr := range maddrs {
+		known[maddr] = true
+	}
+
+	seen := map[address.Address]bool{}
+	for i, o := range f.MinerOverrides {
+		addr, err := address.NewFromString(o.Address)
+		if err != nil {
+			return xerrors.Errorf("parsing fee override %d address '%s': %w", i, o.Address, err)
+		}
+		if !known[addr] {
+			return xerrors.Errorf("fee override for %s, which isn't a configured miner address", addr)
+		}
+		if seen[addr] {
+			return xerrors.Errorf("duplicate fee override for %s", addr)
+		}
+		seen[addr] = true
+
+		for _, fee := range o.fees(&LotusProviderFees{}) {
+			if fee.value == "" {
+				continue
+			}
+			if _, err := types.ParseFIL(fee.value); err != nil {
+				return xerrors.Errorf("fee override for %s: parsing %s '%s': %w", addr, fee.name, fee.value, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+type feeOverride struct {
+	name  string
+	value string
+	dst   *types.FIL
+}
+
+// applyFees sets the values which are set and parse.
+func applyFees(fees []feeOverride) {
+	for _, fee := range fees {
+		if fee.value == "" {
+			continue
+		}
+		v, err := types.ParseFIL(fee.value)
+		if err != nil {
+			continue
+		}
+		*fee.dst = v
+	}
+}
+
+func (o *LotusProviderMinerFees) fees(dst *LotusProviderFees) []feeOverride {
+	return []feeOverride{
+		{"DefaultMaxFee", o.DefaultMaxFee, &dst.DefaultMaxFee},
+		{"MaxPreCommitGasFee", o.MaxPreCommitGasFee, &dst.MaxPreCommitGasFee},
+		{"MaxCommitGasFee", o.MaxCommitGasFee, &dst.MaxCommitGasFee},
+		{"MaxTerminateGasFee", o.MaxTerminateGasFee, &dst.MaxTerminateGasFee},
+		{"MaxWindowPoStGasFee", o.MaxWindowPoStGasFee, &dst.MaxWindowPoStGasFee},
+		{"MaxPublishDealsFee", o.MaxPublishDealsFee, &dst.MaxPublishDealsFee},
+	}
+}
+
+func (w *LotusProviderFeeWindow) fees(dst *LotusProviderFees) []feeOverride {
+	return []feeOverride{
+		{"DefaultMaxFee", w.DefaultMaxFee, &dst.DefaultMaxFee},
+		{"MaxPreCommitGasFee", w.MaxPreCommitGasFee, &dst.MaxPreCommitGasFee},
+		{"MaxCommitGasFee", w.MaxCommitGasFee, &dst.MaxCommitGasFee},
+		{"MaxTerminateGasFee", w.MaxTerminateGasFee, &dst.MaxTerminateGasFee},
+		{"MaxWindowPoStGasFee", w.MaxWindowPoStGasFee, &dst.MaxWindowPoStGasFee},
+		{"MaxPublishDealsFee", w.MaxPublishDealsFee, &dst.MaxPublishDealsFee},
+	}
+}
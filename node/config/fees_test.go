@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestProviderFeesForMiner(t *testing.T) {
+	m1, err := address.NewFromString("f01000")
+	require.NoError(t, err)
+	m2, err := address.NewFromString("f01001")
+	require.NoError(t, err)
+
+	fees := DefaultLotusProvider().Fees
+	fees.MinerOverrides = []LotusProviderMinerFees{
+		{Address: "f01000", MaxWindowPoStGasFee: "20 FIL"},
+	}
+
+	require.NoError(t, fees.ValidateMinerOverrides([]address.Address{m1, m2}))
+
+	f1 := fees.ForMiner(m1)
+	require.Equal(t, types.MustParseFIL("20"), f1.MaxWindowPoStGasFee)
+	require.Equal(t, fees.MaxTerminateGasFee, f1.MaxTerminateGasFee)
+
+	f2 := fees.ForMiner(m2)
+	require.Equal(t, fees.MaxWindowPoStGasFee, f2.MaxWindowPoStGasFee)
+
+	require.Error(t, fees.ValidateMinerOverrides([]address.Address{m2}))
+
+	fees.MinerOverrides = append(fees.MinerOverrides, LotusProviderMinerFees{Address: "f01000"})
+	require.Error(t, fees.ValidateMinerOverrides([]address.Address{m1, m2}))
+
+	fees.MinerOverrides = []LotusProviderMinerFees{{Address: "f01001", MaxTerminateGasFee: "lots"}}
+	require.Error(t, fees.ValidateMinerOverrides([]address.Address{m1, m2}))
+}
+
+func TestProviderFeesOverrideDecode(t *testing.T) {
+	cfg, err := FromReader(bytes.NewReader([]byte(`
+[Fees]
+  MaxWindowPoStGasFee = "5 FIL"
+
+  [[Fees.MinerOverrides]]
+    Address = "f01000"
+    MaxWindowPoStGasFee = "12 FIL"
+`)), DefaultLotusProvider())
+	require.NoError(t, err)
+
+	fees := cfg.(*LotusProviderConfig).Fees
+	require.Len(t, fees.MinerOverrides, 1)
+	require.Empty(t, fees.MinerOverrides[0].MaxCommitGasFee)
+
+	m1, err := address.NewFromString("f01000")
+	require.NoError(t, err)
+	require.Equal(t, types.MustParseFIL("12"), fees.ForMiner(m1).MaxWindowPoStGasFee)
+}
+
+func TestProviderFeeSchedule(t *testing.T) {
+	m1, err := address.NewFromString("f01000")
+	require.NoError(t, err)
+	m2, err := address.NewFromString("f01001")
+	require.NoError(t, err)
+
+	fees := DefaultLotusProvider().Fees
+	fees.MaxWindowPoStGasFee = types.MustParseFIL("5")
+	fees.MinerOverrides = []LotusProviderMinerFees{
+		{Address: "f01001", MaxWindowPoStGasFee: "7 FIL", MaxTerminateGasFee: "3 FIL"},
+	}
+	fees.FeeSchedule = []LotusProviderFeeWindow{
+		// a one-off window, listed first so it wins over the daily ones
+		{FromEpoch: 10000, ToEpoch: 10010, MaxWindowPoStGasFee: "50 FIL"},
+		// peak hours
+		{DayStart: 1200, DayEnd: 1440, MaxWindowPoStGasFee: "20 FIL"},
+		// across the start of the day
+		{DayStart: 2800, DayEnd: 100, MaxWindowPoStGasFee: "2 FIL"},
+	}
+	require.NoError(t, fees.ValidateFeeSchedule())
+
+	at := func(maddr address.Address, epoch abi.ChainEpoch) string {
+		return fees.FeesAt(maddr, epoch).MaxWindowPoStGasFee.String()
+	}
+	day := abi.ChainEpoch(3 * 2880)
+
+	// daily window boundaries: start included, end excluded
+	require.Equal(t, "5 FIL", at(m1, day+1199))
+	require.Equal(t, "20 FIL", at(m1, day+1200))
+	require.Equal(t, "20 FIL", at(m1, day+1439))
+	require.Equal(t, "5 FIL", at(m1, day+1440))
+
+	// wrapping window
+	require.Equal(t, "5 FIL", at(m1, day+2799))
+	require.Equal(t, "2 FIL", at(m1, day+2800))
+	require.Equal(t, "2 FIL", at(m1, day+2879))
+	require.Equal(t, "2 FIL", at(m1, day+2880))
+	require.Equal(t, "2 FIL", at(m1, day+2880+99))
+	require.Equal(t, "5 FIL", at(m1, day+2880+100))
+
+	// epoch range, 10000 is also in the peak hours of its day
+	require.Equal(t, "20 FIL", at(m1, 9999))
+	require.Equal(t, "50 FIL", at(m1, 10000))
+	require.Equal(t, "50 FIL", at(m1, 10009))
+	require.Equal(t, "20 FIL", at(m1, 10010))
+
+	// the schedule applies on top of miner overrides, unset caps keep them
+	require.Equal(t, "7 FIL", at(m2, day+500))
+	require.Equal(t, "20 FIL", at(m2, day+1200))
+	require.Equal(t, types.MustParseFIL("3"), fees.FeesAt(m2, day+1200).MaxTerminateGasFee)
+
+	for _, w := range []LotusProviderFeeWindow{
+		{},
+		{FromEpoch: 10, ToEpoch: 10},
+		{FromEpoch: 10, ToEpoch: 20, DayStart: 5, DayEnd: 6},
+		{DayStart: 100, DayEnd: 100},
+		{DayStart: 100, DayEnd: 2881},
+		{DayStart: 1, DayEnd: 2, MaxWindowPoStGasFee: "lots"},
+	} {
+		fees.FeeSchedule = []LotusProviderFeeWindow{w}
+		require.Error(t, fees.ValidateFeeSchedule(), "%+v", w)
+	}
+}
+
+func TestProviderGasLimits(t *testing.T) {
+	fees := DefaultLotusProvider().Fees
+	require.NoError(t, fees.ValidateGasLimits())
+
+	fees.WindowPoStGasLimit = LotusProviderGasLimit{Multiplier: 1.2, Min: 10_000_000, Max: 500_000_000}
+	require.NoError(t, fees.ValidateGasLimits())
+
+	fees.WindowPoStGasLimit = LotusProviderGasLimit{}
+	require.ErrorContains(t, fees.ValidateGasLimits(), "Multiplier")
+
+	fees.WindowPoStGasLimit = LotusProviderGasLimit{Multiplier: 1, Min: 500_000_000, Max: 10_000_000}
+	require.ErrorContains(t, fees.ValidateGasLimits(), "above")
+}
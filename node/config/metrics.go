@@ -0,0 +1,20 @@
+package config
+
+import "golang.org/x/xerrors"
+
+// ValidatePush checks the metrics push settings when pushing is enabled.
+func (c *LotusProviderMetricsConfig) ValidatePush() error {
+	if c.PushProtocol == "" {
+		return nil
+	}
+	if c.PushProtocol != "statsd" {
+		return xerrors.Errorf("unknown Metrics.PushProtocol %q, expected statsd", c.PushProtocol)
+	}
+	if c.PushEndpoint == "" {
+		return xerrors.Errorf("metrics push enabled, but Metrics.PushEndpoint isn't set")
+	}
+	if c.PushInterval <= 0 {
+		return xerrors.Errorf("Metrics.PushInterval must be positive, got %s", c.PushInterval)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePush(t *testing.T) {
+	c := DefaultLotusProvider().Metrics
+	require.NoError(t, c.ValidatePush())
+
+	// nothing is checked while pushing is disabled
+	c.PushInterval = 0
+	require.NoError(t, c.ValidatePush())
+
+	c.PushProtocol = "statsd"
+	c.PushEndpoint = "127.0.0.1:8125"
+	require.ErrorContains(t, c.ValidatePush(), "PushInterval")
+
+	c.PushInterval = Duration(-1)
+	require.ErrorContains(t, c.ValidatePush(), "PushInterval")
+
+	c.PushInterval = DefaultLotusProvider().Metrics.PushInterval
+	require.NoError(t, c.ValidatePush())
+
+	c.PushEndpoint = ""
+	require.ErrorContains(t, c.ValidatePush(), "PushEndpoint")
+
+	c.PushProtocol = "graphite"
+	require.ErrorContains(t, c.ValidatePush(), "PushProtocol")
+}
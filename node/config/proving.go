@@ -0,0 +1,48 @@
+package config
+
+import (
+	"github.com/filecoin-project/go-address"
+	"golang.org/x/xerrors"
+)
+
+// SafetyMarginFor returns the deadline safety margin which applies to maddr,
+// either from MinerDeadlineSafetyMargins or the global DeadlineSafetyMargin.
+// Entries are expected to have been checked with ValidateSafetyMargins,
+// addresses which don't parse are ignored.
+func (c *ProvingConfig) SafetyMarginFor(maddr address.Address) int {
+	for _, m := range c.MinerDeadlineSafetyMargins {
+		addr, err := address.NewFromString(m.Address)
+		if err != nil || addr != maddr {
+			continue
+		}
+		return m.Margin
+	}
+
+	return c.DeadlineSafetyMargin
+}
+
+// ValidateSafetyMargins checks that every entry in MinerDeadlineSafetyMargins
+// is for one of maddrs, and that no miner is listed twice.
+func (c *ProvingConfig) ValidateSafetyMargins(maddrs []address.Address) error {
+	known := map[address.Address]bool{}
+	for _, maddr := range maddrs {
+		known[maddr] = true
+	}
+
+	seen := map[address.Address]bool{}
+	for i, m := range c.MinerDeadlineSafetyMargins {
+		addr, err := address.NewFromString(m.Address)
+		if err != nil {
+			return xerrors.Errorf("parsing safety margin %d address '%s': %w", i, m.Address, err)
+		}
+		if !known[addr] {
+			return xerrors.Errorf("deadline safety margin for %s, which isn't a configured miner address", addr)
+		}
+		if seen[addr] {
+			return xerrors.Errorf("duplicate deadline safety margin for %s", addr)
+		}
+		seen[addr] = true
+	}
+
+	return nil
+}
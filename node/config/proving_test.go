@@ -0,0 +1,38 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+)
+
+func TestProvingSafetyMargin(t *testing.T) {
+	cfg, err := FromReader(bytes.NewReader([]byte(`
+[Proving]
+  DeadlineSafetyMargin = 5
+
+  [[Proving.MinerDeadlineSafetyMargins]]
+    Address = "f01000"
+    Margin = -10
+`)), DefaultLotusProvider())
+	require.NoError(t, err)
+
+	pc := cfg.(*LotusProviderConfig).Proving
+
+	m1, err := address.NewFromString("f01000")
+	require.NoError(t, err)
+	m2, err := address.NewFromString("f01001")
+	require.NoError(t, err)
+
+	require.NoError(t, pc.ValidateSafetyMargins([]address.Address{m1, m2}))
+	require.Equal(t, -10, pc.SafetyMarginFor(m1))
+	require.Equal(t, 5, pc.SafetyMarginFor(m2))
+
+	require.Error(t, pc.ValidateSafetyMargins([]address.Address{m2}))
+
+	pc.MinerDeadlineSafetyMargins = append(pc.MinerDeadlineSafetyMargins, ProvingMinerSafetyMargin{Address: "f01000"})
+	require.Error(t, pc.ValidateSafetyMargins([]address.Address{m1, m2}))
+}
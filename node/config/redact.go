@@ -0,0 +1,39 @@
+package config
+
+import "strings"
+
+// RedactedValue replaces secrets in configs returned by Redacted.
+const RedactedValue = "[redacted]"
+
+// Redacted returns a copy of the config which can be shown to operators:
+// the storage RPC secret and the tokens of API info strings, of the chain
+// API endpoints, the external signer and the remote provers, are replaced
+// with RedactedValue.
+func (c *LotusProviderConfig) Redacted() *LotusProviderConfig {
+	out := *c
+
+	if out.Apis.StorageRPCSecret != "" {
+		out.Apis.StorageRPCSecret = RedactedValue
+	}
+
+	out.Apis.ChainApiInfo = make([]string, len(c.Apis.ChainApiInfo))
+	for i, info := range c.Apis.ChainApiInfo {
+		out.Apis.ChainApiInfo[i] = redactApiInfo(info)
+	}
+
+	out.Addresses.ExternalSigner.ApiInfo = redactApiInfo(c.Addresses.ExternalSigner.ApiInfo)
+	out.Subsystems.RemoteWindowPostProver = redactApiInfo(c.Subsystems.RemoteWindowPostProver)
+	out.Subsystems.RemoteWinningPostProver = redactApiInfo(c.Subsystems.RemoteWinningPostProver)
+
+	return &out
+}
+
+// redactApiInfo redacts the token of a "token:address" API info string.
+func redactApiInfo(info string) string {
+	token, addr, ok := strings.Cut(info, ":")
+	// tokens are JWTs, addresses start with a multiaddr or a URL scheme
+	if !ok || strings.Count(token, ".") != 2 {
+		return info
+	}
+	return RedactedValue + ":" + addr
+}
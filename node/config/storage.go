@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"time"
 
 	"golang.org/x/xerrors"
 
@@ -68,3 +69,14 @@ func WriteStorageFile(filePath string, config storiface.StorageConfig) error {
 
 	return nil
 }
+
+// ValidateHeartbeat checks that local paths are reported more often than
+// skippedThresh, the time after which paths which missed heartbeats aren't
+// used by other nodes. Otherwise healthy paths would be seen as down between
+// heartbeats.
+func (c *LotusProviderStorageConfig) ValidateHeartbeat(skippedThresh time.Duration) error {
+	if iv := time.Duration(c.HeartbeatInterval); iv >= skippedThresh {
+		return xerrors.Errorf("HeartbeatInterval (%s) must be shorter than the %s after which paths missing heartbeats are considered down", iv, skippedThresh)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateHeartbeat(t *testing.T) {
+	const thresh = 50 * time.Second
+
+	c := DefaultLotusProvider().Storage
+	require.NoError(t, c.ValidateHeartbeat(thresh))
+
+	// unset uses the default interval
+	c.HeartbeatInterval = 0
+	require.NoError(t, c.ValidateHeartbeat(thresh))
+
+	c.HeartbeatInterval = Duration(thresh - time.Second)
+	require.NoError(t, c.ValidateHeartbeat(thresh))
+
+	c.HeartbeatInterval = Duration(thresh)
+	require.Error(t, c.ValidateHeartbeat(thresh))
+
+	c.HeartbeatInterval = Duration(2 * thresh)
+	require.Error(t, c.ValidateHeartbeat(thresh))
+}
@@ -72,18 +72,163 @@ type LotusProviderConfig struct {
 	Fees      LotusProviderFees
 	Addresses LotusProviderAddresses
 	Proving   ProvingConfig
+	Storage   LotusProviderStorageConfig
 	Journal   JournalConfig
 	Apis      ApisConfig
+	Tracing   LotusProviderTracingConfig
+	Metrics   LotusProviderMetricsConfig
+	Node      LotusProviderNodeConfig
+}
+
+// LotusProviderNodeConfig identifies a node in the cluster. As the base layer
+// is shared by all nodes, set it in a layer of each node.
+type LotusProviderNodeConfig struct {
+	// Name is the name of the node, recorded in its harmony_machines row and
+	// the history of the tasks it runs, in the node_name tag of the info
+	// metric and in its journal events. Defaults to the hostname.
+	Name string
+	// Labels are exported in the node_label metric, one series per label
+	// tagged with node_name, node_label and node_label_value, and recorded in
+	// the harmony_machines row of the node, e.g. rack = "r12".
+	Labels map[string]string
+}
+
+type LotusProviderTracingConfig struct {
+	// Enabled sends OpenTelemetry traces of task runs, chain head
+	// processing, message sends and HTTP requests to a Jaeger collector.
+	// The LOTUS_JAEGER_* environment variables also enable tracing, this
+	// section takes precedence when enabled.
+	Enabled bool
+	// JaegerCollectorEndpoint is the HTTP(S) URL of the collector, e.g.
+	// "http://localhost:14268/api/traces".
+	JaegerCollectorEndpoint string
+	// ServiceName is the service traces are reported under.
+	ServiceName string
+	// SampleRatio is the fraction of traces which are recorded, between 0
+	// and 1. Spans of a sampled parent, e.g. from another node, are always
+	// recorded, as are WindowPoSt runs.
+	SampleRatio float64
+}
+
+type LotusProviderMetricsConfig struct {
+	// PushProtocol enables pushing metrics to a collector, for nodes which
+	// can't be scraped. Metrics are still served at /debug/metrics. The only
+	// supported protocol is "statsd", which sends the metrics over UDP in the
+	// DogStatsD format, with tags. Empty disables pushing.
+	PushProtocol string
+	// PushEndpoint is the host:port of the collector.
+	PushEndpoint string
+	// PushInterval is how often metrics are pushed, must be positive.
+	PushInterval Duration
+	// PushPrefix is prepended to the names of pushed metrics.
+	PushPrefix string
+
+	// LegacyAggregates exports the metrics which are tagged by miner without
+	// the miner_id tag, summed over all miners, under their existing names, for
+	// dashboards built before the tag was added. The per-miner series aren't
+	// exported then. Gauges aren't aggregated and stay per miner.
+	//
+	// This option is transitional. It will be removed in the 1.27 release, so
+	// dashboards should be migrated to aggregate over miner_id themselves,
+	// e.g. with "sum without (miner_id)", before then.
+	LegacyAggregates bool
+}
+
+type LotusProviderStorageConfig struct {
+	// HeartbeatInterval is how often the health of local storage paths is
+	// reported to the storage index. A path which stopped being reported, or
+	// was detached from the index, e.g. after a database outage, is attached
+	// again on the next successful heartbeat. Must be shorter than the 50s
+	// after which paths missing heartbeats are considered down.
+	HeartbeatInterval Duration
+
+	// DeclareRetries is how many times declaring the local storage paths in
+	// the storage index is retried at startup, so that a database or storage
+	// outage during a rolling restart doesn't fail startup. Retries back off
+	// from 1s up to 30s between attempts. Set to 0 to fail on the first error.
+	DeclareRetries int
+	// DeclareRetryTimeout bounds the time spent retrying the declaration;
+	// startup fails with the last error once it has passed.
+	DeclareRetryTimeout Duration
+
+	// PartialFileHandler selects the implementation used to access unsealed
+	// (partial) sector files, both locally and when serving them to other nodes.
+	// Implementations are registered with paths.RegisterPartialFileHandler;
+	// "default" is the built-in handler.
+	PartialFileHandler string
+
+	// FetchDialTimeout limits the time spent establishing a TCP connection to
+	// another storage node, when fetching sector data or querying it. The
+	// Fetch* settings default to the values of Go's default HTTP transport.
+	FetchDialTimeout Duration
+	// FetchKeepAlive is the TCP keep-alive period of fetch connections.
+	FetchKeepAlive Duration
+	// FetchResponseHeaderTimeout limits the time spent waiting for a remote
+	// node to start responding after a request was sent. This doesn't limit
+	// the time to read the response body. 0 means no limit.
+	// Remote reads are on the critical path of WindowPoSt for sectors stored
+	// on other nodes; a limit helps detect dead peers early, but must be
+	// generous enough for the slowest link under full proving load.
+	FetchResponseHeaderTimeout Duration
+	// FetchIdleConnTimeout is how long idle connections are kept open for
+	// reuse.
+	FetchIdleConnTimeout Duration
+	// FetchMaxIdleConns limits the number of idle connections kept open
+	// across all remote nodes. 0 means no limit.
+	FetchMaxIdleConns int
+	// FetchMaxIdleConnsPerHost limits the number of idle connections kept
+	// open to each remote node. Raise this when fetching many files from
+	// the same node concurrently. 0 uses Go's default (2).
+	FetchMaxIdleConnsPerHost int
+
+	// FetchCompressTypes lists the sector file types ("cache", "update-cache",
+	// "unsealed", ...) which are compressed in transit, both when this node
+	// fetches them and when it serves them to other nodes. Compression is
+	// only used when both sides support it, and is skipped for files which
+	// don't compress well. Sealed and update files are effectively random
+	// data and gain nothing from it. Set to an empty list to disable.
+	FetchCompressTypes []string
 }
 
 type ApisConfig struct {
 	// ChainApiInfo is the API endpoint for the Lotus daemon.
 	ChainApiInfo []string
 
+	// ChainApiBreakerThreshold is the number of consecutive calls to the Lotus
+	// daemon which may fail with a connection error or time out before calls
+	// start failing fast, to give an overloaded daemon time to recover. Tasks
+	// aren't started while calls fail fast. Set to 0 to disable.
+	ChainApiBreakerThreshold int
+	// ChainApiBreakerCooldown is how long calls fail fast before a single
+	// probe call is let through to check whether the daemon recovered.
+	ChainApiBreakerCooldown Duration
+
 	// RPC Secret for the storage subsystem.
 	// If integrating with lotus-miner this must match the value from
 	// cat ~/.lotusminer/keystore/MF2XI2BNNJ3XILLQOJUXMYLUMU | jq -r .PrivateKey
 	StorageRPCSecret string
+
+	// MinerKeystorePath is the keystore of a lotus-miner repo, e.g.
+	// ~/.lotusminer/keystore, to read the storage RPC secret from when
+	// StorageRPCSecret isn't set. When the repo has an api file, the secret is
+	// checked against the storage endpoint of the miner at startup.
+	MinerKeystorePath string
+
+	// HTTPPathPrefix mounts all HTTP routes (/rpc, /remote, /debug/metrics,
+	// pprof) under the given path, e.g. "/provider1", for deployments behind
+	// a reverse proxy which routes on a path prefix. Empty mounts at root.
+	HTTPPathPrefix string
+
+	// TrustForwardedHeaders makes the HTTP server take the client address and
+	// scheme from the X-Forwarded-For and X-Forwarded-Proto headers. Only
+	// enable this when the provider is reachable exclusively through a proxy
+	// which sets these headers.
+	TrustForwardedHeaders bool
+
+	// RequestLogging enables an access log entry (method, path, status,
+	// duration, remote address and request ID) for every HTTP request.
+	// Off by default, as busy nodes serve many requests.
+	RequestLogging bool
 }
 
 type JournalConfig struct {
@@ -92,10 +237,185 @@ type JournalConfig struct {
 }
 
 type ProviderSubsystemsConfig struct {
-	EnableWindowPost    bool
-	WindowPostMaxTasks  int
+	EnableWindowPost   bool
+	WindowPostMaxTasks int
+	// WindowPostClusterMaxTasks is the most WindowPoSt compute tasks running at
+	// once across all nodes of the cluster, so that adding nodes can't overload
+	// the infrastructure they share, like the full node or shared storage. Nodes
+	// count the running tasks in the database before claiming one, a node takes
+	// a task only while both its own WindowPostMaxTasks and this cap leave
+	// room, so the fleet runs at most the lower of this and the sum of the
+	// per-node caps. Set the same value on all nodes, each node enforces the
+	// value it has. 0 removes the cluster-wide cap.
+	WindowPostClusterMaxTasks int
+	// WindowPostMaxFetches is the most fetches of whole sector files a
+	// single WindowPoSt task runs at once, so that proving one partition can't
+	// take all fetch slots of the node or overload the storage it reads from.
+	// Remote vanilla proofs and reads aren't capped. 0, the default, removes
+	// the per-task cap.
+	WindowPostMaxFetches int
+	// WindowPostSubmitWait is how WindowPoSt submit tasks wait for their
+	// message. With "mempool" the task completes once the message is in the
+	// message pool, and its execution is recorded in the background. With
+	// "confirmed" the task runs until the message is executed, a message
+	// which failed to execute fails the task.
+	WindowPostSubmitWait string
+
 	EnableWinningPost   bool
 	WinningPostMaxTasks int
+
+	// ProvingCPUFallback makes WindowPoSt and WinningPoSt switch to proving
+	// on the CPU when proving fails with a GPU error, e.g. the driver crashed
+	// or the device went away, instead of failing every proof until the node
+	// is restarted. CPU proofs are computed in a child process, the GPU setup
+	// of the node is unchanged. The switch lasts until the restart and raises
+	// an alert, CPU proving of large partitions may be too slow to meet the
+	// deadline, so it is off by default and proving fails fast.
+	ProvingCPUFallback bool
+
+	// RemoteWindowPostProver and RemoteWinningPostProver are the API info,
+	// as token:multiaddr or token:URL, of proving services which compute the
+	// snarks of WindowPoSt and WinningPoSt from the vanilla proofs read by
+	// this node, for operators who keep their GPUs behind such a service.
+	// Services implement GenerateWindowPoStWithVanilla and
+	// GenerateWinningPoStWithVanilla over JSON-RPC on /rpc/v0. Proofs are
+	// computed locally when empty, or when the service fails or can't be
+	// reached; the proving_remote_fallbacks metric counts those.
+	RemoteWindowPostProver  string
+	RemoteWinningPostProver string
+	// RemoteWindowPostTimeout and RemoteWinningPostTimeout bound each request
+	// to the remote provers; a request taking longer is abandoned and the
+	// proof is computed locally. A WinningPoSt must be computed within the
+	// epoch it was won, so its timeout leaves time to prove locally.
+	RemoteWindowPostTimeout  Duration
+	RemoteWinningPostTimeout Duration
+
+	// SafeModeChecks are the health checks which must pass after startup
+	// before this node sends any messages, so that a node restarted in a bad
+	// state doesn't submit proofs or declarations based on stale local state.
+	// "proof" computes and verifies a WinningPoSt over a live sector, it only
+	// runs on nodes with EnableWindowPost or EnableWinningPost. "deadlines"
+	// checks that the chain head is current, and that the partitions of the
+	// open deadline recorded as proven are proven on chain. An alert is
+	// raised while sending is held. Empty disables the gate.
+	SafeModeChecks []string
+	// SafeModeRetryInterval is how often failing safe mode checks are retried.
+	SafeModeRetryInterval Duration
+
+	// AllowTaskTypes, when not empty, lists the only task types this node
+	// claims, e.g. ["WdPost"] for a node with a GPU which only computes proofs.
+	// DenyTaskTypes lists task types this node doesn't claim, e.g.
+	// ["WdPost", "WinPost"] for a node which only submits. The Enable flags
+	// still decide which subsystems run here: a node with EnableWindowPost keeps
+	// scheduling the WindowPoSt tasks of its miners, whichever of the types it
+	// claims, and raises an alert while no live node claims a type it adds.
+	// Types are WdPost, WdPostSubmit, WdPostRecover, WinPost, SendMessage,
+	// WdPostSpotChk, WdPostCanary, WdPostWarm and HistoryPrune.
+	AllowTaskTypes []string
+	DenyTaskTypes  []string
+
+	// TaskPollInterval is how often the database is checked for tasks to
+	// claim. Tasks added or finished on this node are claimed right away,
+	// so the interval bounds how long tasks added by other nodes wait before
+	// this node picks them up. Each poll runs a few queries per enabled task
+	// type, so shorter intervals add database load from every node in the
+	// cluster, longer ones add latency to work handed between nodes.
+	TaskPollInterval Duration
+	// EnableTaskNotify makes nodes announce added tasks with Postgres
+	// LISTEN/NOTIFY, so that idle nodes claim them right away instead of on
+	// their next poll, e.g. for low latency WinningPoSt. Polling remains as
+	// the fallback for missed notifications. Only PostgreSQL supports it, on
+	// YugabyteDB the node logs a warning and keeps polling. Enable on all
+	// nodes, only nodes with it enabled announce their tasks.
+	EnableTaskNotify bool
+
+	// ResourceCPUs, ResourceRAM and ResourceGPUs declare the resources of
+	// this node for running tasks, overriding the detected CPU count, free
+	// memory at startup and GPU count. 0 or empty keeps the detected value.
+	// Tasks are only claimed while their declared cost fits in what the
+	// tasks running on the node leave free. Queued tasks which fit on no
+	// node running their type raise an alert.
+	ResourceCPUs int
+	// ResourceRAM is a size like 256GiB.
+	ResourceRAM string
+	// ResourceGPUs is the number of GPUs, tasks may declare fractions of one.
+	ResourceGPUs float64
+
+	// WindowPostStorageAffinity makes WindowPoSt partitions prefer the nodes
+	// which have the sealed files of their sectors in local storage paths,
+	// so that proving doesn't fetch them over the network. The paths holding
+	// each partition are read from the storage index when it is scheduled.
+	// A path is local to every node listing it in its storage.json, so a
+	// storage group shared by several nodes gives all of them affinity.
+	// Nodes without local access take a partition once WindowPostAffinityGrace
+	// has passed, or right away when none of its paths is heartbeating.
+	// Enable on all nodes with EnableWindowPost; the wdpost_task_locality
+	// metric counts proven partitions by locality to verify the effect.
+	WindowPostStorageAffinity bool
+	// WindowPostAffinityGrace is how long nodes without local access to the
+	// storage of a partition leave it to the co-located nodes.
+	WindowPostAffinityGrace Duration
+
+	// WindowPostVanillaCache keeps the vanilla proofs read from sector files
+	// for WindowPoSt challenges in the database for WindowPostVanillaCacheTTL,
+	// so that proving a partition again for the same challenges, e.g. after
+	// the snark failed or a reorg, doesn't read the sectors again. Each sector
+	// keeps the proof for the challenges it was last proven for, which new
+	// randomness replaces. Uses database space for every proven sector; the
+	// wdpost_vanilla_proof_ms metric compares the cached and uncached reads.
+	WindowPostVanillaCache bool
+	// WindowPostVanillaCacheTTL is how long cached vanilla proofs are used.
+	WindowPostVanillaCacheTTL Duration
+
+	// SectorSyncInterval is how often nodes with EnableWindowPost read the
+	// live sector sets of the miners from chain, and rescan local storage
+	// when newly committed sectors, e.g. sealed by a separate lotus-miner,
+	// aren't in the storage index yet. Set to 0 to disable.
+	SectorSyncInterval Duration
+
+	// EnableHistoryPruning periodically deletes task history, and settled
+	// message sends, older than HistoryRetention. Only one node in the
+	// cluster prunes at a time.
+	EnableHistoryPruning bool
+	// HistoryRetention is how long task and message history is kept when
+	// history pruning is enabled.
+	HistoryRetention Duration
+
+	// EnableSpotCheck periodically checks a random sample of sectors for
+	// readability, independently of WindowPoSt. The check yields to any
+	// pending WindowPoSt work.
+	EnableSpotCheck bool
+	// SpotCheckInterval is how often each miner's sectors are sampled.
+	SpotCheckInterval Duration
+	// SpotCheckSampleSize is the number of sectors checked per miner in
+	// each interval.
+	SpotCheckSampleSize int
+
+	// CanarySectors are sectors, as <miner>:<sector number> e.g. "f01234:42",
+	// read-checked through the fault tracker at the start of every proving
+	// period of their miner, regardless of their deadline. A failed check
+	// raises an alert right away, well before the deadline of a sector
+	// affected by the same storage problem fails. Nodes with canary sectors
+	// set schedule and run the checks; results are kept in
+	// wdpost_canary_checks. Pick sectors spread over the storage paths.
+	CanarySectors []string
+
+	// EnableProvingWarmup checks ahead of each WindowPoSt deadline that the
+	// sealed and cache files of its sectors are present on this node, and
+	// reports files which can't be found anywhere. The check yields to any
+	// pending WindowPoSt work.
+	EnableProvingWarmup bool
+	// ProvingWarmupFetch makes the warmup fetch files which are only stored
+	// on other nodes. Fetches use network bandwidth and local storage space.
+	ProvingWarmupFetch bool
+
+	// ExpectedVerifregRootKey, when set, makes the provider monitor the root
+	// key of the verified registry actor, logging any change of it and
+	// raising an alert while it doesn't match this address.
+	ExpectedVerifregRootKey string
+	// VerifregCheckInterval is how often the verified registry root key is
+	// checked.
+	VerifregCheckInterval Duration
 }
 
 type DAGStoreConfig struct {
@@ -376,6 +696,36 @@ type ProvingConfig struct {
 	// Note that setting this value lower may result in less efficient gas use - more messages will be sent,
 	// to prove each deadline, resulting in more total gas use (but each message will have lower gas limit)
 	SingleRecoveringPartitionPerPostMessage bool
+
+	// Number of epochs by which WindowPoSt for a deadline is started ahead of the deadline opening. Only used by
+	// lotus-provider.
+	//
+	// A positive margin starts computing proofs before the deadline opens, as soon as its challenge is known (at most
+	// 20 epochs ahead); proofs are still submitted once the deadline opens. A negative margin waits for the challenge to
+	// get deeper in the chain, wasting less work on reorgs, and delays both computing and submitting proofs by that
+	// many epochs after the deadline opens.
+	//
+	// The margin is clamped so that proofs are always submitted at least 10 epochs before the deadline closes.
+	DeadlineSafetyMargin int
+
+	// Per-miner overrides of DeadlineSafetyMargin. Only used by lotus-provider.
+	MinerDeadlineSafetyMargins []ProvingMinerSafetyMargin
+
+	// Action taken when a WindowPoSt proof fails local verification after being computed. Only used by lotus-provider.
+	//
+	// "recompute" fails the task, which is retried and computes the proof again. "hold" keeps the proof from being
+	// submitted and raises an alert, leaving the partition to the operator. "submit" submits the proof anyway, which
+	// only helps when local verification is wrong, as an invalid proof fails on chain. Each policy records its own
+	// wdpost_verify_fail_* metric and wdpost:verify_failed_* journal event.
+	VerifyFailurePolicy string
+}
+
+type ProvingMinerSafetyMargin struct {
+	// Address of the miner actor the margin applies to
+	Address string
+
+	// Deadline safety margin in epochs, see DeadlineSafetyMargin
+	Margin int
 }
 
 type SealingConfig struct {
@@ -565,6 +915,72 @@ type LotusProviderFees struct {
 	// WindowPoSt is a high-value operation, so the default fee should be high.
 	MaxWindowPoStGasFee types.FIL
 	MaxPublishDealsFee  types.FIL
+
+	// WindowPoStGasLimit adjusts the gas limit estimated for
+	// SubmitWindowedPoSt messages, to guard against estimates which are off
+	// during congestion.
+	WindowPoStGasLimit LotusProviderGasLimit
+
+	// MinerOverrides replace the fee caps above for individual miners. Fields
+	// left unset in an override fall back to the values above. Every Address
+	// must be one of the miners the provider is configured for.
+	MinerOverrides []LotusProviderMinerFees
+
+	// FeeSchedule replaces the fee caps above while a window of epochs is
+	// current, e.g. to bid higher during hours of known congestion. Caps are
+	// resolved at the epoch messages are sent at. The first window listed
+	// which covers the epoch applies, fields left unset in it fall back to
+	// the miner override, then to the values above. Empty keeps the caps
+	// static.
+	FeeSchedule []LotusProviderFeeWindow
+}
+
+type LotusProviderGasLimit struct {
+	// Multiplier scales the estimated gas limit, e.g. 1.1 for 10% more
+	// headroom on top of the overestimation of the chain node.
+	Multiplier float64
+	// Min and Max bound the scaled gas limit, 0 leaves it unbounded. The fee
+	// of the message stays within the fee cap: when the adjusted limit
+	// would exceed it, the gas fee cap of the message is lowered instead.
+	Min int64
+	Max int64
+}
+
+type LotusProviderFeeWindow struct {
+	// FromEpoch and ToEpoch make the window cover the chain epochs from
+	// FromEpoch up to, but not including, ToEpoch. Leave both at 0 for a
+	// window repeating every day.
+	FromEpoch int64
+	ToEpoch   int64
+
+	// DayStart and DayEnd make the window cover the epochs of every day from
+	// DayStart up to, but not including, DayEnd. The epoch of the day is the
+	// epoch modulo 2880, the epochs in a day, so days start at the time of day
+	// of the genesis of the network. A window with DayEnd below DayStart
+	// wraps around the start of the day.
+	DayStart int64
+	DayEnd   int64
+
+	// Fee caps in FIL, e.g. "10 FIL". Empty values don't change the cap.
+	DefaultMaxFee       string
+	MaxPreCommitGasFee  string
+	MaxCommitGasFee     string
+	MaxTerminateGasFee  string
+	MaxWindowPoStGasFee string
+	MaxPublishDealsFee  string
+}
+
+type LotusProviderMinerFees struct {
+	// Address of the miner actor the override applies to
+	Address string
+
+	// Fee caps in FIL, e.g. "10 FIL". Empty values use the global cap.
+	DefaultMaxFee       string
+	MaxPreCommitGasFee  string
+	MaxCommitGasFee     string
+	MaxTerminateGasFee  string
+	MaxWindowPoStGasFee string
+	MaxPublishDealsFee  string
 }
 type MinerAddressConfig struct {
 	// Addresses to send PreCommit messages from
@@ -600,8 +1016,43 @@ type LotusProviderAddresses struct {
 	// over the worker address if this flag is set.
 	DisableWorkerFallback bool
 
+	// FallbackPolicy is what to do when none of the control addresses for a
+	// message has more than the minimum funds for it, or a key in the wallet:
+	// "error" fails sending the message, "worker" sends it from the worker
+	// address and "owner" from the owner address. Falling back raises an
+	// alert. With an empty policy the owner and worker addresses are
+	// candidates like the control addresses, and the one with the most funds
+	// is used, subject to DisableOwnerFallback and DisableWorkerFallback
+	// which are ignored otherwise; using the owner or worker still raises
+	// the alert. The empty policy is how lotus-miner selects addresses, and
+	// is kept for setups moved from it; it sends from the owner address
+	// unless DisableOwnerFallback is set.
+	FallbackPolicy string
+
 	// MinerAddresses are the addresses of the miner actors to use for sending messages
 	MinerAddresses []string
+
+	// LowBalanceThreshold raises an alert when the balance of a worker or
+	// control address of any MinerAddresses falls below it. Balances are
+	// exported as metrics either way. Set to 0 to disable the alert.
+	LowBalanceThreshold types.FIL
+	// BalanceCheckInterval is how often address balances are checked.
+	BalanceCheckInterval Duration
+
+	// ExternalSigner delegates message signing for some or all sending
+	// addresses to a remote signer instead of the full node wallet.
+	ExternalSigner ExternalSignerConfig
+}
+
+type ExternalSignerConfig struct {
+	// ApiInfo of a lotus-wallet compatible signer endpoint, in the
+	// "token:multiaddr" form. Empty disables the external signer, and all
+	// messages are signed by the full node's wallet.
+	ApiInfo string
+
+	// Addresses (key addresses) whose messages are signed by the external
+	// signer. When empty, the external signer is used for all messages.
+	Addresses []string
 }
 
 // API contains configs for API endpoint
@@ -832,6 +1283,15 @@ type HarmonyDB struct {
 	// in a cluster. Only 1 is required
 	Hosts []string
 
+	// ReadReplicas is a list of hostnames of read replicas of the database.
+	// Reads which tolerate stale data, like listings of the cluster's nodes
+	// and tasks and history queries, are sent to the replicas in turns, while
+	// writes such as task claims and heartbeats, and the reads they depend on,
+	// always go to Hosts. Replicas which can't be reached are skipped for a
+	// while, their reads go to Hosts. Empty to read from Hosts only. Only used
+	// by lotus-provider, which sets it with --db-read-replicas.
+	ReadReplicas []string
+
 	// The Yugabyte server's username with full credentials to operate on Lotus' Database. Blank for default.
 	Username string
 
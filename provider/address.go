@@ -19,6 +19,12 @@ func AddressSelector(addrConf *config.LotusProviderAddresses) func() (*ctladdr.A
 		as.DisableOwnerFallback = addrConf.DisableOwnerFallback
 		as.DisableWorkerFallback = addrConf.DisableWorkerFallback
 
+		fallback, err := ctladdr.ParseFallbackPolicy(addrConf.FallbackPolicy)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing Addresses.FallbackPolicy: %w", err)
+		}
+		as.Fallback = fallback
+
 		for _, s := range addrConf.PreCommitControl {
 			addr, err := address.NewFromString(s)
 			if err != nil {
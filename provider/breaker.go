@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"errors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/provider/lpbreaker"
+)
+
+// GuardTasks makes tasks defer new work while the full node circuit breaker
+// is open, instead of claiming tasks which would fail on every API call.
+// Failures caused by the breaker are classified as retryable.
+func GuardTasks(b *lpbreaker.Breaker, tasks []harmonytask.TaskInterface) []harmonytask.TaskInterface {
+	out := make([]harmonytask.TaskInterface, len(tasks))
+	for i, t := range tasks {
+		out[i] = &breakerTask{TaskInterface: t, b: b}
+	}
+	return out
+}
+
+type breakerTask struct {
+	harmonytask.TaskInterface
+	b *lpbreaker.Breaker
+}
+
+func (t *breakerTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	if !t.b.Available() {
+		return nil, nil
+	}
+	return t.TaskInterface.CanAccept(ids, engine)
+}
+
+func (t *breakerTask) ClassifyError(err error) harmonytask.ErrorClass {
+	if errors.Is(err, lpbreaker.ErrUnavailable) {
+		return harmonytask.ErrorRetryable
+	}
+	if c, ok := t.TaskInterface.(harmonytask.ErrorClassifier); ok {
+		return c.ClassifyError(err)
+	}
+	return harmonytask.ErrorUnclassified
+}
+
+var _ harmonytask.ErrorClassifier = &breakerTask{}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"time"
 
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/node/config"
 	dtypes "github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/provider/chainsched"
 	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/provider/lprand"
 	"github.com/filecoin-project/lotus/provider/lpwindow"
 	"github.com/filecoin-project/lotus/storage/ctladdr"
 	"github.com/filecoin-project/lotus/storage/paths"
@@ -17,34 +26,82 @@ import (
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
-//var log = logging.Logger("provider")
-
-func WindowPostScheduler(ctx context.Context, fc config.LotusProviderFees, pc config.ProvingConfig,
-	api api.FullNode, verif storiface.Verifier, lw *sealer.LocalWorker, sender *lpmessage.Sender,
+// WindowPostScheduler creates the WindowPoSt tasks, adding their handlers to
+// chainSched. The caller runs chainSched once all handlers are added.
+func WindowPostScheduler(chainSched *chainsched.ProviderChainSched, fc config.LotusProviderFees, pc config.ProvingConfig,
+	api api.FullNode, verif storiface.Verifier, prover lpwindow.ProverPoSt, sender *lpmessage.Sender,
 	as *ctladdr.AddressSelector, addresses []dtypes.MinerAddress, db *harmonydb.DB,
-	stor paths.Store, idx paths.SectorIndex, max int) (*lpwindow.WdPostTask, *lpwindow.WdPostSubmitTask, *lpwindow.WdPostRecoverDeclareTask, error) {
+	ft sealer.FaultTracker, j journal.Journal, al *alerting.Alerting, max, clusterMax, maxFetches int, affinity *lpwindow.StorageAffinity, submitWait lpmessage.WaitStrategy) (*lpwindow.WdPostTask, *lpwindow.WdPostSubmitTask, *lpwindow.WdPostRecoverDeclareTask, error) {
 
-	chainSched := chainsched.New(api)
+	chainSched.SetAlerting(al)
 
-	// todo config
-	ft := lpwindow.NewSimpleFaultTracker(stor, idx, 32, 5*time.Second, 300*time.Second)
+	rand := lprand.Node(api)
 
-	computeTask, err := lpwindow.NewWdPostTask(db, api, ft, lw, verif, chainSched, addresses, max)
+	safetyMargin := func(maddr address.Address) abi.ChainEpoch {
+		return abi.ChainEpoch(pc.SafetyMarginFor(maddr))
+	}
+
+	verifyFailure, err := lpwindow.ParseVerifyFailurePolicy(pc.VerifyFailurePolicy)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("Proving.VerifyFailurePolicy: %w", err)
+	}
+
+	computeTask, err := lpwindow.NewWdPostTask(db, api, ft, prover, verif, rand, chainSched, addresses, max, clusterMax, maxFetches, safetyMargin, affinity, verifyFailure, j, al)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	submitTask, err := lpwindow.NewWdPostSubmitTask(chainSched, sender, db, api, fc.MaxWindowPoStGasFee, as)
+	maxWdPoStFee := func(maddr address.Address, epoch abi.ChainEpoch) types.FIL {
+		return fc.FeesAt(maddr, epoch).MaxWindowPoStGasFee
+	}
+
+	submitTask, err := lpwindow.NewWdPostSubmitTask(chainSched, sender, db, api, rand, maxWdPoStFee, lpwindow.GasLimitBounds(fc.WindowPoStGasLimit), as, submitWait)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	recoverTask, err := lpwindow.NewWdPostRecoverDeclareTask(sender, db, api, ft, as, chainSched, fc.MaxWindowPoStGasFee, addresses)
+	if sender != nil {
+		if _, err := lpmessage.NewMessageWatcher(api, db, chainSched); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	recoverTask, err := lpwindow.NewWdPostRecoverDeclareTask(sender, db, api, ft, as, chainSched, maxWdPoStFee, addresses)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	go chainSched.Run(ctx)
+	if _, err := lpwindow.NewDisputeWatcher(chainSched, api, db, al, addresses, recoverTask); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, err := lpwindow.NewProofAgeTracker(chainSched, api, db, addresses); err != nil {
+		return nil, nil, nil, err
+	}
 
 	return computeTask, submitTask, recoverTask, nil
 }
+
+// FaultTracker creates the fault tracker checking sectors before they are
+// proven. Share it between all tasks checking sectors of a node, its limit
+// on parallel checks applies to all miners and partitions checked at once.
+func FaultTracker(stor paths.Store, idx paths.SectorIndex, j journal.Journal, pc config.ProvingConfig) *lpwindow.SimpleFaultTracker {
+	return lpwindow.NewSimpleFaultTracker(stor, idx, j, pc.ParallelCheckLimit, time.Duration(pc.SingleCheckTimeout), time.Duration(pc.PartitionCheckTimeout))
+}
+
+func SpotCheckScheduler(ctx context.Context, api api.FullNode, db *harmonydb.DB, ft sealer.FaultTracker,
+	al *alerting.Alerting, addresses []dtypes.MinerAddress, interval time.Duration, sample int) *lpwindow.SpotCheckTask {
+	return lpwindow.NewSpotCheckTask(ctx, db, api, ft, al, addresses, interval, sample)
+}
+
+// CanaryScheduler creates the task checking the canary sectors, adding its
+// handler to chainSched, which the caller runs.
+func CanaryScheduler(chainSched *chainsched.ProviderChainSched, api api.FullNode, db *harmonydb.DB, ft sealer.FaultTracker,
+	al *alerting.Alerting, canaries []lpwindow.CanarySector) (*lpwindow.CanaryTask, error) {
+	return lpwindow.NewCanaryTask(chainSched, api, db, ft, al, canaries)
+}
+
+func WarmScheduler(ctx context.Context, api api.FullNode, db *harmonydb.DB, local *paths.Local, stor paths.Store, idx paths.SectorIndex,
+	al *alerting.Alerting, addresses []dtypes.MinerAddress, fetch bool) *lpwindow.WarmTask {
+	return lpwindow.NewWarmTask(ctx, db, api, local, stor, idx, al, addresses, fetch)
+}
@@ -12,10 +12,25 @@ import (
 	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/store"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
 )
 
 var log = logging.Logger("chainsched")
 
+// StaleHeadThreshold is how far behind wall-clock time the chain head can be
+// before it's considered stale. Proving against a stale head is pointless, so
+// handlers aren't called with stale heads, and provers should defer work.
+var StaleHeadThreshold = 6 * time.Duration(build.BlockDelaySecs) * time.Second
+
+// Stale returns whether the given tipset is older than StaleHeadThreshold,
+// going by the local clock. The skew of the local clock against the chain is
+// reported, see ProviderChainSched.ClockSkew, but doesn't change whether heads
+// are stale: the skew is estimated from head arrivals, so correcting for it
+// would also hide a node which delivers heads late.
+func Stale(ts *types.TipSet) bool {
+	return build.Clock.Since(time.Unix(int64(ts.MinTimestamp()), 0)) > StaleHeadThreshold
+}
+
 type NodeAPI interface {
 	ChainHead(context.Context) (*types.TipSet, error)
 	ChainNotify(context.Context) (<-chan []*api.HeadChange, error)
@@ -26,6 +41,16 @@ type ProviderChainSched struct {
 
 	callbacks []UpdateFunc
 	started   bool
+
+	al         *alerting.Alerting
+	staleAlert alerting.AlertType
+	skewAlert  alerting.AlertType
+
+	skew clockSkew
+
+	// last tipset handed to update, only accessed from Run
+	head   *types.TipSet
+	skewed bool
 }
 
 func New(api NodeAPI) *ProviderChainSched {
@@ -34,6 +59,17 @@ func New(api NodeAPI) *ProviderChainSched {
 	}
 }
 
+// SetAlerting enables raising an alert while the chain head is stale. Must be
+// called before Run. A nil al leaves alerting disabled.
+func (s *ProviderChainSched) SetAlerting(al *alerting.Alerting) {
+	if al == nil {
+		return
+	}
+	s.al = al
+	s.staleAlert = al.AddAlertType("chainsched", "stale-head")
+	s.skewAlert = al.AddAlertType("chainsched", "clock-skew")
+}
+
 type UpdateFunc func(ctx context.Context, revert, apply *types.TipSet) error
 
 func (s *ProviderChainSched) AddHandler(ch UpdateFunc) error {
@@ -54,6 +90,9 @@ func (s *ProviderChainSched) Run(ctx context.Context) {
 		gotCur bool
 	)
 
+	staleCheck := build.Clock.Ticker(time.Duration(build.BlockDelaySecs) * time.Second)
+	defer staleCheck.Stop()
+
 	// not fine to panic after this point
 	for {
 		if notifs == nil {
@@ -113,9 +152,19 @@ func (s *ProviderChainSched) Run(ctx context.Context) {
 				}
 			}
 
+			if highest != nil {
+				s.skew.observe(highest, build.Clock.Now())
+				s.checkSkew(ctx, highest)
+			}
+
 			s.update(ctx, lowest, highest)
 
 			span.End()
+		case <-staleCheck.C:
+			// catch the node not delivering head changes at all
+			if s.head != nil && Stale(s.head) {
+				s.setStale(s.head)
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -128,9 +177,37 @@ func (s *ProviderChainSched) update(ctx context.Context, revert, apply *types.Ti
 		return
 	}
 
+	s.head = apply
+	if Stale(apply) {
+		// e.g. the node is syncing; defer work until it catches up
+		s.setStale(apply)
+		return
+	}
+	s.setFresh(apply)
+
 	for _, ch := range s.callbacks {
 		if err := ch(ctx, revert, apply); err != nil {
 			log.Errorf("handling head updates in provider chain sched: %+v", err)
 		}
 	}
 }
+
+func (s *ProviderChainSched) setStale(head *types.TipSet) {
+	log.Warnw("chain head is stale, deferring work", "height", head.Height(), "age", build.Clock.Since(time.Unix(int64(head.MinTimestamp()), 0)), "clockSkew", s.ClockSkew())
+
+	if s.al != nil && !s.al.IsRaised(s.staleAlert) {
+		s.al.Raise(s.staleAlert, map[string]interface{}{
+			"height":    head.Height(),
+			"timestamp": head.MinTimestamp(),
+		})
+	}
+}
+
+func (s *ProviderChainSched) setFresh(head *types.TipSet) {
+	if s.al != nil && s.al.IsRaised(s.staleAlert) {
+		log.Infow("chain head advanced, resuming", "height", head.Height())
+		s.al.Resolve(s.staleAlert, map[string]interface{}{
+			"height": head.Height(),
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package chainsched
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// ClockSkewThreshold is how far the local clock can be off the chain's clock
+// before it's reported. Heads normally arrive within the propagation delay
+// after their timestamp, the rest covers validation on the node.
+var ClockSkewThreshold = time.Duration(build.PropagationDelaySecs)*time.Second + 5*time.Second
+
+// skewSamples is the number of head arrivals the clock skew is estimated from.
+const skewSamples = 10
+
+// minSkewSamples is the number of arrivals needed for an estimate.
+const minSkewSamples = 3
+
+// clockSkew estimates the offset of the local clock from the chain's clock,
+// from how long after their timestamp heads are delivered by the node. Heads
+// can't be produced before their timestamp, so the smallest delay of the
+// recent arrivals is the skew plus the fastest propagation; a negative delay
+// means the local clock is behind.
+//
+// Arrivals are only counted while the node follows the chain at its pace,
+// heads delivered in a burst while the node catches up say nothing about the
+// clock.
+type clockSkew struct {
+	lk sync.Mutex
+
+	samples []time.Duration
+	next    int
+
+	prevHeight abi.ChainEpoch
+	prevAt     time.Time
+}
+
+// observe records the arrival of head at the local time at.
+func (c *clockSkew) observe(head *types.TipSet, at time.Time) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	prevHeight, prevAt := c.prevHeight, c.prevAt
+	c.prevHeight, c.prevAt = head.Height(), at
+	if prevAt.IsZero() {
+		return
+	}
+
+	// more epochs passed than the local time allows for, allowing for
+	// arrivals spread between epochs
+	elapsed := abi.ChainEpoch(at.Sub(prevAt) / (time.Duration(build.BlockDelaySecs) * time.Second))
+	if head.Height()-prevHeight > elapsed+2 {
+		return
+	}
+
+	delay := at.Sub(time.Unix(int64(head.MinTimestamp()), 0))
+	if len(c.samples) < skewSamples {
+		c.samples = append(c.samples, delay)
+		return
+	}
+	c.samples[c.next] = delay
+	c.next = (c.next + 1) % skewSamples
+}
+
+// estimate returns the estimated skew, positive when the local clock is
+// ahead, and whether there were enough arrivals to tell.
+func (c *clockSkew) estimate() (time.Duration, bool) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if len(c.samples) < minSkewSamples {
+		return 0, false
+	}
+
+	est := c.samples[0]
+	for _, s := range c.samples[1:] {
+		if s < est {
+			est = s
+		}
+	}
+	return est, true
+}
+
+// ClockSkew returns the offset of the local clock from the chain's clock,
+// positive when the local clock is ahead, if it exceeds ClockSkewThreshold.
+// Smaller offsets are within what propagation accounts for, and are returned
+// as 0.
+func (s *ProviderChainSched) ClockSkew() time.Duration {
+	est, ok := s.skew.estimate()
+	if !ok || (est <= ClockSkewThreshold && est >= -ClockSkewThreshold) {
+		return 0
+	}
+	return est
+}
+
+// checkSkew records the estimated skew, and alerts when it crosses
+// ClockSkewThreshold. It only reports, staleness goes by the local clock.
+func (s *ProviderChainSched) checkSkew(ctx context.Context, head *types.TipSet) {
+	if est, ok := s.skew.estimate(); ok {
+		stats.Record(ctx, metrics.ChainClockSkew.M(est.Seconds()))
+	}
+
+	skew := s.ClockSkew()
+	if (skew != 0) == s.skewed {
+		return
+	}
+	s.skewed = skew != 0
+
+	if !s.skewed {
+		log.Infow("local clock back in line with the chain", "height", head.Height())
+		if s.al != nil {
+			s.al.Resolve(s.skewAlert, map[string]interface{}{
+				"height": head.Height(),
+			})
+		}
+		return
+	}
+
+	log.Warnw("local clock is off the chain's clock, check time sync on this node and the full node",
+		"skew", skew, "height", head.Height(), "threshold", ClockSkewThreshold)
+	if s.al != nil {
+		s.al.Raise(s.skewAlert, map[string]interface{}{
+			"skewSeconds": skew.Seconds(),
+			"height":      head.Height(),
+		})
+	}
+}
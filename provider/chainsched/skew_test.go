@@ -0,0 +1,128 @@
+package chainsched
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/raulk/clock"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var genesisTime = time.Unix(1_600_000_000, 0)
+
+func mkHead(height abi.ChainEpoch) *types.TipSet {
+	b := mock.MkBlock(nil, 1, uint64(height))
+	b.Height = height
+	b.Timestamp = uint64(genesisTime.Unix()) + uint64(height)*build.BlockDelaySecs
+	return mock.TipSet(b)
+}
+
+func headTime(height abi.ChainEpoch) time.Time {
+	return genesisTime.Add(time.Duration(height) * time.Duration(build.BlockDelaySecs) * time.Second)
+}
+
+func TestClockSkew(t *testing.T) {
+	mclk := clock.NewMock()
+	prevClock := build.Clock
+	build.Clock = mclk
+	t.Cleanup(func() { build.Clock = prevClock })
+
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+	s := New(nil)
+	s.SetAlerting(al)
+
+	// heads delivered with propagation delays of 1 to 3 seconds, by a clock
+	// which is skewed by the given offset
+	var height abi.ChainEpoch = 1000
+	deliver := func(n int, skew time.Duration) {
+		for i := 0; i < n; i++ {
+			height++
+			mclk.Set(headTime(height).Add(skew + time.Duration(1+i%3)*time.Second))
+			head := mkHead(height)
+			s.skew.observe(head, mclk.Now())
+			s.checkSkew(context.Background(), head)
+		}
+	}
+
+	require.NoError(t, view.Register(metrics.ChainClockSkewView))
+	defer view.Unregister(metrics.ChainClockSkewView)
+	reported := func() float64 {
+		rows, err := view.RetrieveData(metrics.ChainClockSkewView.Name)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		return rows[0].Data.(*view.LastValueData).Value
+	}
+
+	// an accurate clock
+	deliver(skewSamples, 0)
+	require.Zero(t, s.ClockSkew())
+	require.Equal(t, 1.0, reported())
+	require.False(t, Stale(mkHead(height)))
+	require.False(t, al.IsRaised(s.skewAlert))
+
+	// the local clock runs ahead by more than StaleHeadThreshold; the skew is
+	// reported, but heads are stale going by the local clock regardless
+	ahead := StaleHeadThreshold + time.Minute
+	deliver(skewSamples, ahead)
+	require.InDelta(t, (ahead + time.Second).Seconds(), s.ClockSkew().Seconds(), 0.001)
+	require.InDelta(t, (ahead + time.Second).Seconds(), reported(), 0.001)
+	require.True(t, Stale(mkHead(height)))
+	require.True(t, al.IsRaised(s.skewAlert))
+
+	// back in sync
+	deliver(skewSamples, 0)
+	require.Zero(t, s.ClockSkew())
+	require.False(t, Stale(mkHead(height)))
+	require.False(t, al.IsRaised(s.skewAlert))
+
+	// the local clock is behind, heads arrive before their timestamp
+	deliver(skewSamples, -time.Minute)
+	require.InDelta(t, (-time.Minute + time.Second).Seconds(), s.ClockSkew().Seconds(), 0.001)
+	require.True(t, al.IsRaised(s.skewAlert))
+}
+
+func TestClockSkewIgnoresCatchUp(t *testing.T) {
+	var c clockSkew
+
+	// the node catches up, delivering heads of many epochs at once; their
+	// delays are large but say nothing about the clock
+	at := headTime(2000)
+	for h := abi.ChainEpoch(1000); h < 2000; h += 50 {
+		at = at.Add(time.Second)
+		c.observe(mkHead(h), at)
+	}
+	_, ok := c.estimate()
+	require.False(t, ok)
+
+	// then follows the chain
+	for h := abi.ChainEpoch(2001); h < 2005; h++ {
+		c.observe(mkHead(h), headTime(h).Add(2*time.Second))
+	}
+	est, ok := c.estimate()
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, est)
+}
+
+func TestSetAlertingNil(t *testing.T) {
+	s := New(nil)
+	s.SetAlerting(nil)
+
+	// stale and skew changes only log without alerting
+	head := mkHead(1000)
+	require.NotPanics(t, func() {
+		s.setStale(head)
+		s.setFresh(head)
+		s.checkSkew(context.Background(), head)
+	})
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+)
+
+// ChildSnarkProver computes snarks on the CPU, each in a child process
+// started with BELLMAN_NO_GPU set, leaving the GPU setup of the node
+// process alone. The child is expected to serve one request with
+// ServeSnarkRequest.
+type ChildSnarkProver struct {
+	// Path and Args start the child, usually the node binary itself with
+	// the command serving the request.
+	Path string
+	Args []string
+}
+
+type snarkRequest struct {
+	Kind         string // "window" or "winning"
+	ProofType    abi.RegisteredPoStProof
+	MinerID      abi.ActorID
+	Randomness   abi.PoStRandomness
+	Proofs       [][]byte
+	PartitionIdx int
+}
+
+type snarkResponse struct {
+	Proofs []prooftypes.PoStProof
+	Error  string
+}
+
+func (c *ChildSnarkProver) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	out, err := c.run(ctx, snarkRequest{Kind: "window", ProofType: proofType, MinerID: minerID, Randomness: randomness, Proofs: proofs, PartitionIdx: partitionIdx})
+	if err != nil {
+		return prooftypes.PoStProof{}, err
+	}
+	if len(out) != 1 {
+		return prooftypes.PoStProof{}, xerrors.Errorf("cpu prover returned %d window proofs, expected 1", len(out))
+	}
+	return out[0], nil
+}
+
+func (c *ChildSnarkProver) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	return c.run(ctx, snarkRequest{Kind: "winning", ProofType: proofType, MinerID: minerID, Randomness: randomness, Proofs: proofs})
+}
+
+func (c *ChildSnarkProver) run(ctx context.Context, req snarkRequest) ([]prooftypes.PoStProof, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, xerrors.Errorf("marshaling snark request: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = append(os.Environ(), "BELLMAN_NO_GPU=1")
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, xerrors.Errorf("running cpu prover: %w (stderr: %s)", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp snarkResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, xerrors.Errorf("decoding cpu prover response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, xerrors.Errorf("cpu prover: %s", resp.Error)
+	}
+	return resp.Proofs, nil
+}
+
+var _ SnarkProver = &ChildSnarkProver{}
+
+// ServeSnarkRequest reads one request of a ChildSnarkProver from r, computes
+// it with prover and writes the response to w. Proving errors are returned
+// to the parent in the response, the returned error is only set when the
+// exchange itself failed.
+func ServeSnarkRequest(ctx context.Context, r io.Reader, w io.Writer, prover SnarkProver) error {
+	var req snarkRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return xerrors.Errorf("decoding snark request: %w", err)
+	}
+
+	var resp snarkResponse
+	var err error
+	switch req.Kind {
+	case "window":
+		var p prooftypes.PoStProof
+		p, err = prover.GenerateWindowPoStWithVanilla(ctx, req.ProofType, req.MinerID, req.Randomness, req.Proofs, req.PartitionIdx)
+		resp.Proofs = []prooftypes.PoStProof{p}
+	case "winning":
+		resp.Proofs, err = prover.GenerateWinningPoStWithVanilla(ctx, req.ProofType, req.MinerID, req.Randomness, req.Proofs)
+	default:
+		err = xerrors.Errorf("unknown proof kind %q", req.Kind)
+	}
+	if err != nil {
+		resp = snarkResponse{Error: err.Error()}
+	}
+
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		return xerrors.Errorf("encoding snark response: %w", err)
+	}
+	return nil
+}
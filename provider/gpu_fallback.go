@@ -0,0 +1,155 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/storage/sealer"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+var log = logging.Logger("provider")
+
+// gpuErrorCodes are the errors of the proofs library, bellperson and ec-gpu,
+// raised when the GPU can't be used at all, rather than because of the
+// sectors or the proof inputs. "GPU taken by a high priority process" isn't
+// one of them, the GPU works once released.
+var gpuErrorCodes = []string{
+	"No working GPUs found!",
+	"GPU accelerator is disabled!",
+	"Cuda Error: ",
+	"OpenCL Error: ",
+	"Opencl3 Error: ",
+}
+
+// isGPUError tells if proving failed because the GPU couldn't be used.
+func isGPUError(err error) bool {
+	msg := err.Error()
+	for _, code := range gpuErrorCodes {
+		if strings.Contains(msg, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// GPUFallback detects snark computations which failed because of the GPU
+// and, when it has a CPU prover, retries them on it. Once a GPU failure is
+// seen all further snarks of the process are computed by the CPU prover, a
+// GPU which failed to initialise rarely recovers without a restart. The GPU
+// use of the process itself is left unchanged, the CPU prover is expected to
+// run apart, see ChildSnarkProver.
+type GPUFallback struct {
+	cpu SnarkProver
+
+	al    *alerting.Alerting
+	alert alerting.AlertType
+
+	lk    sync.Mutex
+	onCPU bool
+}
+
+// NewGPUFallback creates a GPUFallback. With a nil cpu prover GPU failures
+// are only counted and returned, for operators who prefer to fail fast.
+func NewGPUFallback(cpu SnarkProver, al *alerting.Alerting) *GPUFallback {
+	f := &GPUFallback{
+		cpu: cpu,
+		al:  al,
+	}
+	if al != nil {
+		f.alert = al.AddAlertType("provider", "gpu-fallback")
+	}
+	return f
+}
+
+// Exec returns the executor of a LocalWorker computing snarks with the
+// executor exec, or sealer.FFIExec when nil, subject to the fallback.
+func (f *GPUFallback) Exec(exec sealer.ExecutorFunc) sealer.ExecutorFunc {
+	if exec == nil {
+		exec = sealer.FFIExec()
+	}
+	return func(l *sealer.LocalWorker) (storiface.Storage, error) {
+		s, err := exec(l)
+		if err != nil {
+			return nil, err
+		}
+		return &gpuFallbackStorage{Storage: s, f: f}, nil
+	}
+}
+
+type gpuFallbackStorage struct {
+	storiface.Storage
+
+	f *GPUFallback
+}
+
+func (s *gpuFallbackStorage) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	if !s.f.usingCPU() {
+		p, err := s.Storage.GenerateWindowPoStWithVanilla(ctx, proofType, minerID, randomness, proofs, partitionIdx)
+		if err == nil || !s.f.handleFailure(ctx, "window", err) {
+			return p, err
+		}
+	}
+	return s.f.cpu.GenerateWindowPoStWithVanilla(ctx, proofType, minerID, randomness, proofs, partitionIdx)
+}
+
+func (s *gpuFallbackStorage) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	if !s.f.usingCPU() {
+		p, err := s.Storage.GenerateWinningPoStWithVanilla(ctx, proofType, minerID, randomness, proofs)
+		if err == nil || !s.f.handleFailure(ctx, "winning", err) {
+			return p, err
+		}
+	}
+	return s.f.cpu.GenerateWinningPoStWithVanilla(ctx, proofType, minerID, randomness, proofs)
+}
+
+func (f *GPUFallback) usingCPU() bool {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.onCPU
+}
+
+// handleFailure records a failed snark and returns whether it should be
+// retried by the CPU prover.
+func (f *GPUFallback) handleFailure(ctx context.Context, kind string, err error) bool {
+	if !isGPUError(err) {
+		return false
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	ctx, _ = tag.New(ctx, tag.Upsert(ProofKindKey, kind))
+	stats.Record(ctx, ProvingMeasures.GPUFailures.M(1))
+
+	if f.cpu == nil {
+		log.Errorw("proving failed on the GPU, CPU fallback is disabled", "proof", kind, "error", err)
+		return false
+	}
+	if f.onCPU {
+		return true
+	}
+
+	f.onCPU = true
+	stats.Record(ctx, ProvingMeasures.CPUFallbacks.M(1))
+
+	log.Errorw("proving failed on the GPU, proving on the CPU until the node is restarted; CPU proofs may be too slow to meet deadlines",
+		"proof", kind, "error", err)
+	if f.al != nil {
+		f.al.Raise(f.alert, map[string]interface{}{
+			"proof": kind,
+			"error": err.Error(),
+			"note":  "proving on the CPU until restart, proofs may be too slow to meet deadlines",
+		})
+	}
+	return true
+}
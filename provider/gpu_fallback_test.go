@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// failingStorage stands for ffiwrapper failing every snark with err.
+type failingStorage struct {
+	storiface.Storage
+
+	err   error
+	calls int
+}
+
+func (f *failingStorage) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	f.calls++
+	return prooftypes.PoStProof{}, f.err
+}
+
+func (f *failingStorage) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	f.calls++
+	return nil, f.err
+}
+
+func TestGPUFallback(t *testing.T) {
+	ctx := context.Background()
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1
+	gpuErr := xerrors.New("generate_window_post: encountered a GPU error: OpenCL Error: -5")
+
+	t.Run("fail fast", func(t *testing.T) {
+		gpu := &failingStorage{err: gpuErr}
+		s := &gpuFallbackStorage{Storage: gpu, f: NewGPUFallback(nil, nil)}
+
+		_, err := s.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, nil, nil, 0)
+		require.ErrorIs(t, err, gpuErr)
+		require.Equal(t, 1, gpu.calls)
+		require.False(t, s.f.usingCPU())
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		al := alerting.NewAlertingSystem(journal.NilJournal())
+		gpu := &failingStorage{err: gpuErr}
+		cpu := &fakeSnarkProver{}
+		s := &gpuFallbackStorage{Storage: gpu, f: NewGPUFallback(cpu, al)}
+
+		_, err := s.GenerateWinningPoStWithVanilla(ctx, ppt, 1000, nil, [][]byte{{1}})
+		require.NoError(t, err)
+		require.Equal(t, 1, gpu.calls)
+		require.Equal(t, 1, cpu.calls)
+		require.True(t, al.IsRaised(s.f.alert))
+
+		// later proofs run on the CPU right away
+		p, err := s.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, nil, [][]byte{{1}}, 3)
+		require.NoError(t, err)
+		require.Equal(t, []byte{3, 1}, p.ProofBytes)
+		require.Equal(t, 1, gpu.calls)
+		require.Equal(t, 2, cpu.calls)
+
+		// the GPU setup of the process is left alone
+		require.Empty(t, os.Getenv("BELLMAN_NO_GPU"))
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		// only GPU error codes switch, not any mention of the GPU
+		for _, msg := range []string{"sector 12 is faulty", "GPU taken by a high priority process", "gpu proof of sector 3 is invalid"} {
+			cpu := &fakeSnarkProver{}
+			s := &gpuFallbackStorage{Storage: &failingStorage{err: xerrors.New(msg)}, f: NewGPUFallback(cpu, nil)}
+			_, err := s.GenerateWinningPoStWithVanilla(ctx, ppt, 1000, nil, nil)
+			require.ErrorContains(t, err, msg)
+			require.Zero(t, cpu.calls)
+			require.False(t, s.f.usingCPU())
+		}
+	})
+}
+
+func TestChildSnarkProver(t *testing.T) {
+	if os.Getenv("PROVIDER_TEST_SNARK_CHILD") != "" {
+		// running as the child of the test below
+		if os.Getenv("BELLMAN_NO_GPU") == "" {
+			os.Exit(2)
+		}
+		if err := ServeSnarkRequest(context.Background(), os.Stdin, os.Stdout, &fakeSnarkProver{}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	t.Setenv("PROVIDER_TEST_SNARK_CHILD", "1")
+	ctx := context.Background()
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1
+	c := &ChildSnarkProver{Path: os.Args[0], Args: []string{"-test.run=^TestChildSnarkProver$"}}
+
+	p, err := c.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, nil, [][]byte{{1}, {2}}, 4)
+	require.NoError(t, err)
+	require.Equal(t, []byte{4, 2}, p.ProofBytes)
+
+	ps, err := c.GenerateWinningPoStWithVanilla(ctx, ppt, 1000, nil, [][]byte{{1}})
+	require.NoError(t, err)
+	require.Len(t, ps, 1)
+	require.Equal(t, []byte{1}, ps[0].ProofBytes)
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/config"
+)
+
+// NodeIdentity is the name and labels a node reports itself with, see
+// config.LotusProviderNodeConfig.
+type NodeIdentity struct {
+	Name   string
+	Labels map[string]string
+}
+
+// Identity reads the identity of the node from its config, named after the
+// hostname when Node.Name is empty. Names and labels end up in metric tags,
+// so they must be printable ASCII.
+func Identity(cfg config.LotusProviderNodeConfig) (NodeIdentity, error) {
+	id := NodeIdentity{
+		Name:   strings.TrimSpace(cfg.Name),
+		Labels: map[string]string{},
+	}
+	if id.Name == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return NodeIdentity{}, xerrors.Errorf("Node.Name isn't set and the hostname can't be read: %w", err)
+		}
+		id.Name = host
+	}
+
+	// tag.New validates the values like the exporters expect them
+	if _, err := tag.New(context.Background(), tag.Insert(metrics.NodeName, id.Name)); err != nil {
+		return NodeIdentity{}, xerrors.Errorf("Node.Name %q: %w", id.Name, err)
+	}
+	for k, v := range cfg.Labels {
+		if strings.TrimSpace(k) == "" {
+			return NodeIdentity{}, xerrors.Errorf("Node.Labels: empty label name")
+		}
+		if _, err := tag.New(context.Background(), tag.Insert(metrics.NodeLabel, k), tag.Insert(metrics.NodeLabelValue, v)); err != nil {
+			return NodeIdentity{}, xerrors.Errorf("Node.Labels %q: %w", k, err)
+		}
+		id.Labels[k] = v
+	}
+	return id, nil
+}
+
+// RecordMetrics exports each label of the node as a node_label series,
+// tagged with the name of the node.
+func (id NodeIdentity) RecordMetrics(ctx context.Context) {
+	for k, v := range id.Labels {
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{
+			tag.Upsert(metrics.NodeName, id.Name),
+			tag.Upsert(metrics.NodeLabel, k),
+			tag.Upsert(metrics.NodeLabelValue, v),
+		}, metrics.NodeLabelInfo.M(1))
+	}
+}
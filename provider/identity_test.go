@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/node/config"
+)
+
+func TestIdentity(t *testing.T) {
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	id, err := Identity(config.LotusProviderNodeConfig{})
+	require.NoError(t, err)
+	require.Equal(t, NodeIdentity{Name: host, Labels: map[string]string{}}, id)
+
+	id, err = Identity(config.LotusProviderNodeConfig{
+		Name:   " prover-1 ",
+		Labels: map[string]string{"rack": "r12", "gpu": "a100"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, NodeIdentity{Name: "prover-1", Labels: map[string]string{"rack": "r12", "gpu": "a100"}}, id)
+
+	_, err = Identity(config.LotusProviderNodeConfig{Name: "prover\n1"})
+	require.ErrorContains(t, err, "Node.Name")
+
+	_, err = Identity(config.LotusProviderNodeConfig{Labels: map[string]string{"": "x"}})
+	require.ErrorContains(t, err, "empty label name")
+
+	_, err = Identity(config.LotusProviderNodeConfig{Labels: map[string]string{"zone": "eu\x00west"}})
+	require.ErrorContains(t, err, "zone")
+}
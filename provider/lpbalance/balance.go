@@ -0,0 +1,193 @@
+package lpbalance
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+)
+
+var log = logging.Logger("lpbalance")
+
+// DefaultCheckInterval is used when no balance check interval is configured.
+const DefaultCheckInterval = 5 * time.Minute
+
+type BalanceAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	WalletBalance(context.Context, address.Address) (types.BigInt, error)
+}
+
+// Monitor periodically exports the balance of each miner's worker and control
+// addresses, and raises an alert while any of them is below the threshold.
+// Unlike the AddressSelector, it doesn't wait for a message to be sent, so
+// operators get a chance to top up before proving is affected.
+type Monitor struct {
+	api       BalanceAPI
+	as        *ctladdr.AddressSelector
+	actors    []dtypes.MinerAddress
+	threshold types.BigInt
+	interval  time.Duration
+
+	al     *alerting.Alerting
+	alerts map[address.Address]alerting.AlertType
+}
+
+type monitoredAddr struct {
+	addr address.Address
+	role string
+}
+
+// NewMonitor creates a balance monitor. A zero threshold only exports
+// metrics, without alerting.
+func NewMonitor(api BalanceAPI, as *ctladdr.AddressSelector, al *alerting.Alerting, actors []dtypes.MinerAddress,
+	threshold types.FIL, interval time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	return &Monitor{
+		api:       api,
+		as:        as,
+		actors:    actors,
+		threshold: types.BigInt(threshold),
+		interval:  interval,
+
+		al:     al,
+		alerts: map[address.Address]alerting.AlertType{},
+	}
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+
+	for {
+		for _, act := range m.actors {
+			if err := m.check(ctx, address.Address(act)); err != nil {
+				log.Errorw("checking address balances", "miner", address.Address(act), "error", err)
+			}
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context, maddr address.Address) error {
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	mi, err := m.api.StateMinerInfo(ctx, maddr, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting miner info: %w", err)
+	}
+
+	addrs, err := m.addresses(ctx, mi, head.Key())
+	if err != nil {
+		return err
+	}
+
+	for _, a := range addrs {
+		bal, err := m.api.WalletBalance(ctx, a.addr)
+		if err != nil {
+			return xerrors.Errorf("getting balance of %s: %w", a.addr, err)
+		}
+
+		_ = stats.RecordWithTags(ctx, []tag.Mutator{
+			tag.Upsert(metrics.MinerID, maddr.String()),
+			tag.Upsert(AddressKey, a.addr.String()),
+			tag.Upsert(RoleKey, a.role),
+		}, BalanceMeasures.Balance.M(types.BigDivFloat(bal, types.FromFil(1))))
+
+		m.alert(maddr, a, bal)
+	}
+
+	return nil
+}
+
+// addresses returns the miner's worker and control addresses, along with the
+// control addresses configured for the provider, each listed once under the
+// first role it was found with.
+func (m *Monitor) addresses(ctx context.Context, mi api.MinerInfo, tsk types.TipSetKey) ([]monitoredAddr, error) {
+	var out []monitoredAddr
+	seen := map[address.Address]struct{}{}
+
+	add := func(role string, addrs ...address.Address) error {
+		for _, a := range addrs {
+			id, err := m.api.StateLookupID(ctx, a, tsk)
+			if err != nil {
+				return xerrors.Errorf("looking up %s address %s: %w", role, a, err)
+			}
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			out = append(out, monitoredAddr{addr: a, role: role})
+		}
+		return nil
+	}
+
+	if err := add("worker", mi.Worker); err != nil {
+		return nil, err
+	}
+	if err := add("control", mi.ControlAddresses...); err != nil {
+		return nil, err
+	}
+	if err := add("precommit", m.as.PreCommitControl...); err != nil {
+		return nil, err
+	}
+	if err := add("commit", m.as.CommitControl...); err != nil {
+		return nil, err
+	}
+	if err := add("terminate", m.as.TerminateControl...); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (m *Monitor) alert(maddr address.Address, a monitoredAddr, bal types.BigInt) {
+	if m.threshold.IsZero() {
+		return
+	}
+
+	at, ok := m.alerts[a.addr]
+	if !ok {
+		at = m.al.AddAlertType("lpbalance", "low-balance-"+a.addr.String())
+		m.alerts[a.addr] = at
+	}
+
+	info := map[string]interface{}{
+		"miner":     maddr.String(),
+		"address":   a.addr.String(),
+		"role":      a.role,
+		"balance":   types.FIL(bal).String(),
+		"threshold": types.FIL(m.threshold).String(),
+	}
+
+	low := types.BigCmp(bal, m.threshold) < 0
+	if low && !m.al.IsRaised(at) {
+		m.al.Raise(at, info)
+	} else if !low && m.al.IsRaised(at) {
+		m.al.Resolve(at, info)
+	}
+}
@@ -0,0 +1,90 @@
+package lpbalance
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+)
+
+// FallbackAlerts raises an alert for each message use while the
+// AddressSelector falls back from its control addresses, or fails to select
+// any. The alert is resolved once a funded control address is selected for
+// the use again.
+type FallbackAlerts struct {
+	al *alerting.Alerting
+
+	lk     sync.Mutex
+	alerts map[api.AddrUse]alerting.AlertType
+}
+
+func NewFallbackAlerts(al *alerting.Alerting) *FallbackAlerts {
+	return &FallbackAlerts{
+		al:     al,
+		alerts: map[api.AddrUse]alerting.AlertType{},
+	}
+}
+
+// Fallback is meant for AddressSelector.OnFallback.
+func (f *FallbackAlerts) Fallback(ev ctladdr.FallbackEvent) {
+	if f.al == nil {
+		return
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	at, ok := f.alerts[ev.Use]
+	if !ok {
+		at = f.al.AddAlertType("lpbalance", "address-fallback-"+ctladdr.UseName(ev.Use))
+		f.alerts[ev.Use] = at
+	}
+
+	policy := string(ev.Policy)
+	if ev.Policy == ctladdr.FallbackLeastBad {
+		policy = "least-bad"
+	}
+
+	info := map[string]interface{}{
+		"use":    ctladdr.UseName(ev.Use),
+		"policy": policy,
+	}
+	switch {
+	case ev.Err != nil:
+		info["error"] = ev.Err.Error()
+		info["message"] = "no address is usable for these messages, they can't be sent until a control address is funded"
+	case ev.Policy == ctladdr.FallbackLeastBad:
+		info["address"] = ev.Addr.String()
+		info["balance"] = types.FIL(ev.Balance).String()
+		info["message"] = "no control address is usable for these messages, sending from the owner or worker address with the most funds"
+	default:
+		info["address"] = ev.Addr.String()
+		info["balance"] = types.FIL(ev.Balance).String()
+		info["message"] = fmt.Sprintf("no control address is usable for these messages, sending from the %s address", ev.Policy)
+	}
+	// raised on every fallback, so the alert shows the latest
+	f.al.Raise(at, info)
+}
+
+// Selected is meant for AddressSelector.OnSelect.
+func (f *FallbackAlerts) Selected(sel ctladdr.Selection) {
+	if f.al == nil || sel.Reason != ctladdr.ReasonFunded {
+		return
+	}
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	at, ok := f.alerts[sel.Use]
+	if !ok || !f.al.IsRaised(at) {
+		return
+	}
+	f.al.Resolve(at, map[string]interface{}{
+		"use":     ctladdr.UseName(sel.Use),
+		"address": sel.Addr.String(),
+		"message": "a funded control address is used again",
+	})
+}
@@ -0,0 +1,33 @@
+package lpbalance
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "address_"
+
+var (
+	AddressKey, _ = tag.NewKey("address")
+	RoleKey, _    = tag.NewKey("role")
+)
+
+// BalanceMeasures groups all address balance metrics.
+var BalanceMeasures = struct {
+	Balance *stats.Float64Measure
+}{
+	Balance: stats.Float64(pre+"balance_fil", "Balance of a miner worker or control address, in FIL.", stats.UnitDimensionless),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     BalanceMeasures.Balance,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID, AddressKey, RoleKey},
+		},
+	)
+}
@@ -0,0 +1,213 @@
+package lpbreaker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/stats"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+var log = logging.Logger("lpbreaker")
+
+// ErrUnavailable is returned from full node calls while the breaker is open.
+var ErrUnavailable = xerrors.New("full node unavailable: circuit breaker open")
+
+type State int
+
+const (
+	// StateClosed passes all calls through.
+	StateClosed State = iota
+	// StateHalfOpen lets a single probe call through after the cool-down,
+	// other calls fail fast until it returns.
+	StateHalfOpen
+	// StateOpen fails all calls fast until the cool-down passes.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is a circuit breaker for full node API calls. After Threshold
+// consecutive failures (connection errors or timeouts) it opens, failing calls
+// with ErrUnavailable for Cooldown, then lets a probe call through before
+// closing again. Errors returned by the node itself, e.g. for a missing
+// actor, don't count as failures.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	lk       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	now func() time.Time
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	b := &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+	b.recordState()
+	return b
+}
+
+// State returns the current breaker state.
+func (b *Breaker) State() State {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// Available returns false while calls would fail fast. Tasks can use it to
+// defer work instead of failing on ErrUnavailable.
+func (b *Breaker) Available() bool {
+	return b.State() != StateOpen
+}
+
+// allow returns whether a call may go through, and whether it's the probe.
+func (b *Breaker) allow() (bool, bool) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		return true, false
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.state = StateHalfOpen
+		b.recordState()
+		fallthrough
+	default: // StateHalfOpen
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+}
+
+func (b *Breaker) done(probe bool, err error) {
+	b.lk.Lock()
+	defer b.lk.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if !isFailure(err) {
+		if b.state != StateClosed && probe {
+			log.Infow("full node is responding again, closing circuit breaker")
+			b.state = StateClosed
+			b.recordState()
+		}
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if probe || (b.state == StateClosed && b.failures >= b.threshold) {
+		if b.state != StateOpen {
+			log.Warnw("full node calls failing, opening circuit breaker", "failures", b.failures, "cooldown", b.cooldown, "error", err)
+			stats.Record(context.Background(), BreakerMeasures.Trips.M(1))
+		}
+		b.state = StateOpen
+		b.openedAt = b.now()
+		b.recordState()
+	}
+}
+
+func (b *Breaker) recordState() {
+	stats.Record(context.Background(), BreakerMeasures.State.M(int64(b.state)))
+}
+
+// isFailure returns whether err says something about the health of the node,
+// as opposed to errors the node returned for the specific call.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var connErr *jsonrpc.RPCConnectionError
+	var clientErr *jsonrpc.ErrClient
+	return errors.As(err, &connErr) || errors.As(err, &clientErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Wrap returns a FullNode which routes all calls to full through the breaker.
+// A threshold of 0 or less disables the breaker, returning full unchanged.
+func (b *Breaker) Wrap(full api.FullNode) api.FullNode {
+	if b.threshold <= 0 {
+		return full
+	}
+
+	var out api.FullNodeStruct
+	b.proxy(full, &out)
+	return &out
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+func (b *Breaker) proxy(in interface{}, outstr interface{}) {
+	outs := api.GetInternalStructs(outstr)
+	for _, out := range outs {
+		rint := reflect.ValueOf(out).Elem()
+		ra := reflect.ValueOf(in)
+
+		for f := 0; f < rint.NumField(); f++ {
+			field := rint.Type().Field(f)
+			fn := ra.MethodByName(field.Name)
+			ft := field.Type
+
+			rint.Field(f).Set(reflect.MakeFunc(ft, func(args []reflect.Value) (results []reflect.Value) {
+				ok, probe := b.allow()
+				if !ok {
+					results = make([]reflect.Value, ft.NumOut())
+					for i := range results {
+						results[i] = reflect.Zero(ft.Out(i))
+					}
+					if n := ft.NumOut(); n > 0 && ft.Out(n-1) == errorType {
+						results[n-1] = reflect.ValueOf(&ErrUnavailable).Elem()
+					}
+					return results
+				}
+
+				results = fn.Call(args)
+
+				var err error
+				if n := len(results); n > 0 && ft.Out(n-1) == errorType {
+					err, _ = results[n-1].Interface().(error)
+				}
+				b.done(probe, err)
+				return results
+			}))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package lpbreaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	var calls int
+	var callErr error
+
+	var node api.FullNodeStruct
+	node.Internal.ChainHead = func(context.Context) (*types.TipSet, error) {
+		calls++
+		return nil, callErr
+	}
+
+	now := time.Unix(1000, 0)
+	b := New(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	full := b.Wrap(&node)
+
+	// errors from the node itself don't trip the breaker
+	callErr = xerrors.New("actor not found")
+	for i := 0; i < 5; i++ {
+		_, err := full.ChainHead(ctx)
+		require.ErrorIs(t, err, callErr)
+	}
+	require.Equal(t, StateClosed, b.State())
+
+	callErr = &jsonrpc.RPCConnectionError{}
+	for i := 0; i < 3; i++ {
+		_, err := full.ChainHead(ctx)
+		require.ErrorAs(t, err, new(*jsonrpc.RPCConnectionError))
+	}
+	require.Equal(t, StateOpen, b.State())
+	require.False(t, b.Available())
+
+	// open: calls fail fast without reaching the node
+	calls = 0
+	_, err := full.ChainHead(ctx)
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.Equal(t, 0, calls)
+
+	// failed probe reopens the breaker
+	now = now.Add(time.Minute)
+	require.Equal(t, StateHalfOpen, b.State())
+	_, err = full.ChainHead(ctx)
+	require.ErrorAs(t, err, new(*jsonrpc.RPCConnectionError))
+	require.Equal(t, 1, calls)
+	require.Equal(t, StateOpen, b.State())
+
+	// successful probe closes it
+	now = now.Add(time.Minute)
+	callErr = nil
+	_, err = full.ChainHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, StateClosed, b.State())
+	require.True(t, b.Available())
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	var node api.FullNodeStruct
+	require.Equal(t, api.FullNode(&node), New(0, time.Minute).Wrap(&node))
+}
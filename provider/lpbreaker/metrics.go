@@ -0,0 +1,32 @@
+package lpbreaker
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "fullnode_breaker_"
+
+// BreakerMeasures groups all full node circuit breaker metrics.
+var BreakerMeasures = struct {
+	State *stats.Int64Measure
+	Trips *stats.Int64Measure
+}{
+	State: stats.Int64(pre+"state", "State of the full node circuit breaker: 0 closed, 1 half-open, 2 open.", stats.UnitDimensionless),
+	Trips: stats.Int64(pre+"trips", "Number of times the full node circuit breaker opened.", stats.UnitDimensionless),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     BreakerMeasures.State,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Measure:     BreakerMeasures.Trips,
+			Aggregation: view.Sum(),
+		},
+	)
+}
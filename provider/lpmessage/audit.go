@@ -0,0 +1,186 @@
+package lpmessage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+)
+
+var (
+	AuditFlushInterval = 10 * time.Second
+	AuditBatchSize     = 100
+)
+
+const auditQueueSize = 1024
+
+// auditDrainTimeout bounds writing the entries left when the audit stops.
+var auditDrainTimeout = 10 * time.Second
+
+const pickReasonUnknown = "unknown"
+
+type auditEntry struct {
+	addr       address.Address
+	fromKey    address.Address
+	pickReason string
+	sendReason string
+	taskID     *harmonytask.TaskID
+	signedCid  cid.Cid
+	maxFee     types.BigInt
+}
+
+// addressAudit records which control address was used for each message, with
+// the reason the AddressSelector gave for picking it. Entries are written to
+// message_address_audit in batches, off the send path, until ctx is done or
+// the audit is closed; pending entries are written before it stops.
+type addressAudit struct {
+	db harmonydb.Interface
+
+	lk      sync.Mutex
+	reasons map[address.Address]string // last pick reason by selected address
+
+	queue chan auditEntry
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newAddressAudit(ctx context.Context, db harmonydb.Interface) *addressAudit {
+	a := &addressAudit{
+		db:      db,
+		reasons: map[address.Address]string{},
+		queue:   make(chan auditEntry, auditQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run(ctx)
+	return a
+}
+
+// close stops the writer, waiting until the pending entries are written or
+// ctx is done.
+func (a *addressAudit) close(ctx context.Context) error {
+	a.stopOnce.Do(func() { close(a.stop) })
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// selected is meant to be used as ctladdr.AddressSelector.OnSelect. The
+// selection is matched to a message by the address the caller puts in
+// msg.From, so if the same address is picked concurrently for different
+// reasons, the latest reason wins.
+func (a *addressAudit) selected(sel ctladdr.Selection) {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	a.reasons[sel.Addr] = sel.Reason
+}
+
+func (a *addressAudit) pickReason(addr address.Address) string {
+	a.lk.Lock()
+	defer a.lk.Unlock()
+
+	if r, ok := a.reasons[addr]; ok {
+		return r
+	}
+	return pickReasonUnknown
+}
+
+func (a *addressAudit) record(e auditEntry) {
+	select {
+	case a.queue <- e:
+	default:
+		log.Warnw("address audit queue full, dropping entry", "addr", e.addr, "task_id", e.taskID)
+	}
+}
+
+func (a *addressAudit) run(ctx context.Context) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(AuditFlushInterval)
+	defer ticker.Stop()
+
+	var batch []auditEntry
+	for {
+		select {
+		case e := <-a.queue:
+			batch = append(batch, e)
+			if len(batch) < AuditBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-ctx.Done():
+			a.drain(batch)
+			return
+		case <-a.stop:
+			a.drain(batch)
+			return
+		}
+
+		if err := a.flush(ctx, batch); err != nil {
+			log.Errorw("writing address audit", "entries", len(batch), "error", err)
+			if len(batch) > auditQueueSize {
+				batch = batch[len(batch)-auditQueueSize:]
+			}
+			continue // keep the batch, try again on the next tick
+		}
+		batch = batch[:0]
+	}
+}
+
+// drain writes batch along with the entries still queued, when stopping.
+func (a *addressAudit) drain(batch []auditEntry) {
+	for len(a.queue) > 0 {
+		batch = append(batch, <-a.queue)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	// the node context may be done already, give the last write a deadline
+	// of its own
+	ctx, cancel := context.WithTimeout(context.Background(), auditDrainTimeout)
+	defer cancel()
+
+	if err := a.flush(ctx, batch); err != nil {
+		log.Errorw("writing address audit on shutdown, entries lost", "entries", len(batch), "error", err)
+	}
+}
+
+func (a *addressAudit) flush(ctx context.Context, batch []auditEntry) error {
+	_, err := a.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for _, e := range batch {
+			var signedCid *string
+			if e.signedCid.Defined() {
+				s := e.signedCid.String()
+				signedCid = &s
+			}
+
+			_, err := tx.Exec(`insert into message_address_audit (addr, from_key, pick_reason, send_reason, send_task_id, signed_cid, max_fee)
+				values ($1, $2, $3, $4, $5, $6, $7::numeric)`,
+				e.addr.String(), e.fromKey.String(), e.pickReason, e.sendReason, e.taskID, signedCid, e.maxFee.String())
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	return err
+}
@@ -0,0 +1,53 @@
+package lpmessage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
+)
+
+func TestAddressAuditFlushOnClose(t *testing.T) {
+	db := harmonydb.NewMock()
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	db.ExpectExec(`insert into message_address_audit`).WithArgs("f01000", "f01000", "good-funds", "wdpost", harmonydb.MockAnyArg, harmonydb.MockAnyArg, "1")
+	db.ExpectExec(`insert into message_address_audit`).WithArgs("f01000", "f01000", "unknown", "recover", harmonydb.MockAnyArg, harmonydb.MockAnyArg, "2")
+
+	// entries are batched, nothing is written before the flush interval
+	a := newAddressAudit(context.Background(), db)
+	a.selected(ctladdr.Selection{Addr: addr, Reason: "good-funds"})
+	a.record(auditEntry{addr: addr, fromKey: addr, pickReason: a.pickReason(addr), sendReason: "wdpost", signedCid: cid.Undef, maxFee: types.NewInt(1)})
+	a.record(auditEntry{addr: addr, fromKey: addr, pickReason: pickReasonUnknown, sendReason: "recover", signedCid: cid.Undef, maxFee: types.NewInt(2)})
+
+	require.NoError(t, a.close(context.Background()))
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// closing again is a no-op
+	require.NoError(t, a.close(context.Background()))
+}
+
+func TestAddressAuditFlushOnContextDone(t *testing.T) {
+	db := harmonydb.NewMock()
+	addr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	db.ExpectExec(`insert into message_address_audit`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := newAddressAudit(ctx, db)
+	a.record(auditEntry{addr: addr, fromKey: addr, pickReason: pickReasonUnknown, sendReason: "wdpost", maxFee: types.NewInt(1)})
+
+	// the entry is written even though the node context is done by then
+	cancel()
+	<-a.done
+	require.NoError(t, db.ExpectationsWereMet())
+}
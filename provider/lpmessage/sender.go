@@ -3,35 +3,46 @@ package lpmessage
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log/v2"
+	"go.opencensus.io/trace"
 	"go.uber.org/multierr"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/storage/ctladdr"
 )
 
 var log = logging.Logger("lpmessage")
 
 var SendLockedWait = 100 * time.Millisecond
 
+// ErrSendingHeld is returned by Send while sending from this node is held,
+// see Sender.Hold.
+var ErrSendingHeld = xerrors.New("message sending is held until the startup health checks pass")
+
 type SenderAPI interface {
 	StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error)
 	GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error)
 	WalletBalance(ctx context.Context, addr address.Address) (big.Int, error)
 	MpoolGetNonce(context.Context, address.Address) (uint64, error)
 	MpoolPush(context.Context, *types.SignedMessage) (cid.Cid, error)
+	StateWaitMsg(ctx context.Context, cid cid.Cid, confidence uint64, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
 }
 
 type SignerAPI interface {
@@ -46,6 +57,7 @@ type Sender struct {
 	api SenderAPI
 
 	sendTask *SendTask
+	audit    *addressAudit
 
 	db *harmonydb.DB
 }
@@ -56,6 +68,9 @@ type SendTask struct {
 	api    SenderAPI
 	signer SignerAPI
 
+	// held keeps this node from sending, see Sender.Hold
+	held atomic.Bool
+
 	db *harmonydb.DB
 }
 
@@ -230,6 +245,11 @@ func (s *SendTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskE
 		return nil, nil
 	}
 
+	if s.held.Load() {
+		// leave the send to nodes which are allowed to send
+		return nil, nil
+	}
+
 	return &ids[0], nil
 }
 
@@ -253,8 +273,9 @@ func (s *SendTask) Adder(taskFunc harmonytask.AddTaskFunc) {
 
 var _ harmonytask.TaskInterface = &SendTask{}
 
-// NewSender creates a new Sender.
-func NewSender(api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *SendTask) {
+// NewSender creates a new Sender. The address audit it records is written
+// until ctx is done or Close is called.
+func NewSender(ctx context.Context, api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *SendTask) {
 	st := &SendTask{
 		api:    api,
 		signer: signer,
@@ -266,9 +287,47 @@ func NewSender(api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *Sen
 		db:  db,
 
 		sendTask: st,
+		audit:    newAddressAudit(ctx, db),
 	}, st
 }
 
+// Close writes the pending address audit entries and stops the writer. It
+// returns early when ctx is done.
+func (s *Sender) Close(ctx context.Context) error {
+	return s.audit.close(ctx)
+}
+
+// RecordSelection remembers why an address was picked so that the next
+// message sent from it can be attributed. Set it as the OnSelect hook of
+// the AddressSelector used to pick message senders.
+func (s *Sender) RecordSelection(sel ctladdr.Selection) {
+	s.audit.selected(sel)
+}
+
+// Hold keeps this node from sending messages until Release is called. Send
+// fails with a retryable ErrSendingHeld, and send tasks queued by other
+// nodes are left to them. It is used to keep a restarted node from sending
+// anything based on possibly stale local state before its health checks pass.
+func (s *Sender) Hold() {
+	s.sendTask.held.Store(true)
+}
+
+// Release lifts a Hold.
+func (s *Sender) Release() {
+	s.sendTask.held.Store(false)
+}
+
+// Held tells if sending from this node is held.
+func (s *Sender) Held() bool {
+	return s.sendTask.held.Load()
+}
+
+// IdempotencyKey derives a Send idempotency key from the ID of the task sending
+// the message and the purpose of the message within that task.
+func IdempotencyKey(taskID harmonytask.TaskID, purpose string) string {
+	return fmt.Sprintf("%d:%s", taskID, purpose)
+}
+
 // Send atomically assigns a nonce, signs, and pushes a message
 // to mempool.
 // maxFee is only used when GasFeeCap/GasPremium fields aren't specified
@@ -280,8 +339,70 @@ func NewSender(api SenderAPI, signer SignerAPI, db *harmonydb.DB) (*Sender, *Sen
 // through HarmonyDB, making it safe to broadcast messages from multiple independent
 // API nodes
 //
-// Send is also currently more strict about required parameters than MpoolPushMessage
-func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageSendSpec, reason string) (cid.Cid, error) {
+// When key is not empty, and a message with the same key was already sent (or is
+// being sent), Send returns the CID of that message instead of sending a new one.
+// This makes it safe to retry a task which crashed after it sent its message. Keys
+// of failed sends can be reused. See IdempotencyKey.
+//
+// Send is also currently more strict about required parameters than MpoolPushMessage.
+//
+// Send returns once the message is in the message pool. Its execution is
+// recorded in message_waits by a MessageWatcher in the background, use
+// SendAndWait to wait for it instead.
+func (s *Sender) Send(ctx context.Context, key string, msg *types.Message, mss *api.MessageSendSpec, reason string) (cid.Cid, error) {
+	sigCid, err := s.send(ctx, key, msg, mss, reason)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err := s.trackExecution(ctx, sigCid); err != nil {
+		// the message is out, only its confirmation won't be recorded
+		log.Errorw("tracking message execution", "cid", sigCid, "error", err)
+	}
+	return sigCid, nil
+}
+
+// SendAndWait sends the message like Send, then waits for it to be executed
+// with build.MessageConfidence epochs on top, recording the execution in
+// message_waits. The lookup is of the message which was executed, which may
+// be a replacement of the one sent. A message executed with a non-zero exit
+// code isn't an error, check the receipt.
+func (s *Sender) SendAndWait(ctx context.Context, key string, msg *types.Message, mss *api.MessageSendSpec, reason string) (cid.Cid, *api.MsgLookup, error) {
+	sigCid, err := s.send(ctx, key, msg, mss, reason)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+
+	if err := s.trackExecution(ctx, sigCid); err != nil {
+		log.Errorw("tracking message execution", "cid", sigCid, "error", err)
+	}
+
+	lookup, err := s.api.StateWaitMsg(ctx, sigCid, build.MessageConfidence, api.LookbackNoLimit, true)
+	if err != nil {
+		return sigCid, nil, xerrors.Errorf("waiting for message %s: %w", sigCid, err)
+	}
+
+	if err := recordExecution(ctx, s.db, sigCid, lookup); err != nil {
+		log.Errorw("recording message execution", "cid", sigCid, "error", err)
+	}
+	return sigCid, lookup, nil
+}
+
+// trackExecution has the execution of a sent message recorded.
+func (s *Sender) trackExecution(ctx context.Context, sigCid cid.Cid) error {
+	_, err := s.db.Exec(ctx, `INSERT INTO message_waits (signed_message_cid) VALUES ($1) ON CONFLICT DO NOTHING`, sigCid.String())
+	return err
+}
+
+func (s *Sender) send(ctx context.Context, key string, msg *types.Message, mss *api.MessageSendSpec, reason string) (cid.Cid, error) {
+	ctx, span := trace.StartSpan(ctx, "Sender.Send")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("reason", reason), trace.StringAttribute("to", msg.To.String()))
+
+	if s.Held() {
+		return cid.Undef, harmonytask.Retryable(ErrSendingHeld)
+	}
+
 	if mss == nil {
 		return cid.Undef, xerrors.Errorf("MessageSendSpec cannot be nil")
 	}
@@ -289,6 +410,19 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("MessageSendSpec.MsgUuid must be zero")
 	}
 
+	if key != "" {
+		sent, existing, err := s.sentWithKey(ctx, key)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if sent {
+			log.Infow("message already sent with idempotency key", "key", key, "task_id", existing)
+			return s.waitForSend(ctx, existing)
+		}
+	}
+
+	picked := msg.From
+
 	fromA, err := s.api.StateAccountKey(ctx, msg.From, types.EmptyTSK)
 	if err != nil {
 		return cid.Undef, xerrors.Errorf("getting key address: %w", err)
@@ -324,13 +458,23 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		return cid.Undef, xerrors.Errorf("marshaling message: %w", err)
 	}
 
+	var idemKey *string
+	if key != "" {
+		idemKey = &key
+	}
+
 	var sendTaskID *harmonytask.TaskID
 	taskAdder(func(id harmonytask.TaskID, tx *harmonydb.Tx) (shouldCommit bool, seriousError error) {
-		_, err := tx.Exec(`insert into message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, send_task_id) values ($1, $2, $3, $4, $5, $6)`,
-			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), id)
+		n, err := tx.Exec(`insert into message_sends (from_key, to_addr, send_reason, unsigned_data, unsigned_cid, send_task_id, idempotency_key) values ($1, $2, $3, $4, $5, $6, $7)
+			on conflict (idempotency_key) where send_success is not false do nothing`,
+			msg.From.String(), msg.To.String(), reason, unsBytes.Bytes(), msg.Cid().String(), id, idemKey)
 		if err != nil {
 			return false, xerrors.Errorf("inserting message into db: %w", err)
 		}
+		if n == 0 {
+			// a concurrent Send with the same key won
+			return false, nil
+		}
 
 		sendTaskID = &id
 
@@ -338,33 +482,71 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 	})
 
 	if sendTaskID == nil {
+		if key != "" {
+			sent, existing, err := s.sentWithKey(ctx, key)
+			if err != nil {
+				return cid.Undef, err
+			}
+			if sent {
+				return s.waitForSend(ctx, existing)
+			}
+		}
+
 		return cid.Undef, xerrors.Errorf("failed to add task")
 	}
 
-	// wait for exec
+	sigCid, sendErr := s.waitForSend(ctx, *sendTaskID)
+
+	log.Infow("sent message", "cid", sigCid, "task_id", *sendTaskID, "send_error", sendErr)
+
+	s.audit.record(auditEntry{
+		addr:       picked,
+		fromKey:    msg.From,
+		pickReason: s.audit.pickReason(picked),
+		sendReason: reason,
+		taskID:     sendTaskID,
+		signedCid:  sigCid,
+		maxFee:     requiredFunds,
+	})
+
+	return sigCid, sendErr
+}
+
+// sentWithKey checks whether a message with the given idempotency key was
+// already sent, or is being sent, returning the ID of its send task.
+func (s *Sender) sentWithKey(ctx context.Context, key string) (bool, harmonytask.TaskID, error) {
+	var taskIDs []harmonytask.TaskID
+	err := s.db.Select(ctx, &taskIDs, `select send_task_id from message_sends where idempotency_key = $1 and send_success is not false`, key)
+	if err != nil {
+		return false, 0, xerrors.Errorf("looking up idempotency key: %w", err)
+	}
+	if len(taskIDs) == 0 {
+		return false, 0, nil
+	}
+
+	return true, taskIDs[0], nil
+}
+
+// waitForSend waits for the send task to push its message to the network,
+// returning the signed message CID, or the send error.
+func (s *Sender) waitForSend(ctx context.Context, sendTaskID harmonytask.TaskID) (cid.Cid, error) {
 	var (
 		pollInterval    = 50 * time.Millisecond
 		pollIntervalMul = 2
 		maxPollInterval = 5 * time.Second
-		pollLoops       = 0
-
-		sigCid  cid.Cid
-		sendErr error
 	)
 
 	for {
-		var err error
 		var sigCidStr, sendError *string
 		var sendSuccess *bool
 
-		err = s.db.QueryRow(ctx, `select signed_cid, send_success, send_error from message_sends where send_task_id = $1`, &sendTaskID).Scan(&sigCidStr, &sendSuccess, &sendError)
+		err := s.db.QueryRow(ctx, `select signed_cid, send_success, send_error from message_sends where send_task_id = $1`, sendTaskID).Scan(&sigCidStr, &sendSuccess, &sendError)
 		if err != nil {
 			return cid.Undef, xerrors.Errorf("getting cid for task: %w", err)
 		}
 
 		if sendSuccess == nil {
 			time.Sleep(pollInterval)
-			pollLoops++
 			pollInterval *= time.Duration(pollIntervalMul)
 			if pollInterval > maxPollInterval {
 				pollInterval = maxPollInterval
@@ -379,18 +561,14 @@ func (s *Sender) Send(ctx context.Context, msg *types.Message, mss *api.MessageS
 		}
 
 		if !*sendSuccess {
-			sendErr = xerrors.Errorf("send error: %s", *sendError)
-		} else {
-			sigCid, err = cid.Parse(*sigCidStr)
-			if err != nil {
-				return cid.Undef, xerrors.Errorf("parsing signed cid: %w", err)
-			}
+			return cid.Undef, xerrors.Errorf("send error: %s", *sendError)
 		}
 
-		break
-	}
-
-	log.Infow("sent message", "cid", sigCid, "task_id", taskAdder, "send_error", sendErr, "poll_loops", pollLoops)
+		sigCid, err := cid.Parse(*sigCidStr)
+		if err != nil {
+			return cid.Undef, xerrors.Errorf("parsing signed cid: %w", err)
+		}
 
-	return sigCid, sendErr
+		return sigCid, nil
+	}
 }
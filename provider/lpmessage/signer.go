@@ -0,0 +1,93 @@
+package lpmessage
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/messagesigner"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// ExternalSigner signs messages outside of the full node wallet, e.g. in
+// a remote signer or HSM holding the control address keys.
+type ExternalSigner interface {
+	// SignMessage returns a signature over the unsigned message, made with
+	// the key of the given (key) address.
+	SignMessage(ctx context.Context, from address.Address, msg *types.Message) (*crypto.Signature, error)
+}
+
+// WalletSigner is an ExternalSigner backed by a lotus-wallet compatible API.
+type WalletSigner struct {
+	Wallet api.Wallet
+}
+
+func (w *WalletSigner) SignMessage(ctx context.Context, from address.Address, msg *types.Message) (*crypto.Signature, error) {
+	sb, err := messagesigner.SigningBytes(msg, from.Protocol())
+	if err != nil {
+		return nil, xerrors.Errorf("getting signing bytes: %w", err)
+	}
+	mb, err := msg.ToStorageBlock()
+	if err != nil {
+		return nil, xerrors.Errorf("serializing message: %w", err)
+	}
+
+	return w.Wallet.WalletSign(ctx, from, sb, api.MsgMeta{
+		Type:  api.MTChainMsg,
+		Extra: mb.RawData(),
+	})
+}
+
+// RoutingSigner signs messages from the configured addresses with an
+// external signer, and all other messages with the fallback (full node)
+// wallet.
+type RoutingSigner struct {
+	external ExternalSigner
+	fallback SignerAPI
+
+	// nil means all addresses are signed externally
+	addrs map[address.Address]struct{}
+}
+
+// NewRoutingSigner creates a RoutingSigner. When addrs is empty all messages
+// are signed by the external signer.
+func NewRoutingSigner(external ExternalSigner, fallback SignerAPI, addrs []address.Address) *RoutingSigner {
+	rs := &RoutingSigner{
+		external: external,
+		fallback: fallback,
+	}
+
+	if len(addrs) > 0 {
+		rs.addrs = make(map[address.Address]struct{}, len(addrs))
+		for _, a := range addrs {
+			rs.addrs[a] = struct{}{}
+		}
+	}
+
+	return rs
+}
+
+func (r *RoutingSigner) WalletSignMessage(ctx context.Context, from address.Address, msg *types.Message) (*types.SignedMessage, error) {
+	if r.addrs != nil {
+		if _, ok := r.addrs[from]; !ok {
+			return r.fallback.WalletSignMessage(ctx, from, msg)
+		}
+	}
+
+	sig, err := r.external.SignMessage(ctx, from, msg)
+	if err != nil {
+		// most likely the signer is unreachable; the send task will be retried
+		return nil, xerrors.Errorf("external signer failed to sign message from %s: %w", from, err)
+	}
+
+	return &types.SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}, nil
+}
+
+var _ SignerAPI = &RoutingSigner{}
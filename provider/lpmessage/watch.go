@@ -0,0 +1,252 @@
+package lpmessage
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+)
+
+// WaitStrategy is how a task sending a message waits for it.
+type WaitStrategy string
+
+const (
+	// WaitMempool returns once the message is in the message pool, its
+	// execution is recorded in the background by a MessageWatcher.
+	WaitMempool WaitStrategy = "mempool"
+	// WaitConfirmed blocks until the message is executed, see SendAndWait.
+	WaitConfirmed WaitStrategy = "confirmed"
+)
+
+// ParseWaitStrategy parses a WaitStrategy, the empty string is WaitMempool.
+func ParseWaitStrategy(s string) (WaitStrategy, error) {
+	switch WaitStrategy(s) {
+	case "", WaitMempool:
+		return WaitMempool, nil
+	case WaitConfirmed:
+		return WaitConfirmed, nil
+	default:
+		return "", xerrors.Errorf("unknown message wait strategy %q, expected %q or %q", s, WaitMempool, WaitConfirmed)
+	}
+}
+
+// WatchLookback is how far back the chain is searched for a message. A
+// message not found executed within WatchLookback epochs of when it was sent
+// is marked expired, and isn't searched for any more.
+var WatchLookback = abi.ChainEpoch(policy.ChainFinality)
+
+// watchSearchMargin is added to the epochs searched for a message, for the
+// difference between the database clock, which dates the send, and the chain.
+const watchSearchMargin = abi.ChainEpoch(5)
+
+// watcherLockKey is the advisory lock held by the node recording executions.
+const watcherLockKey int64 = 0x4d736757 << 32 // "MsgW"
+
+type WatcherAPI interface {
+	StateGetActor(ctx context.Context, actor address.Address, tsk types.TipSetKey) (*types.Actor, error)
+	StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error)
+}
+
+// lockSession is the part of harmonydb.LockSession used to claim the waits.
+type lockSession interface {
+	TryLock(ctx context.Context, key int64) (bool, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// MessageWatcher records the execution of messages sent with Send on each
+// head change. All nodes run one, but only the node holding the watcher lock
+// searches for pending messages; the database releases the lock when that
+// node's session ends, and another node takes over. A message is only searched
+// for once the nonce of its sender passed its nonce, and only back to the
+// epoch it was sent at.
+type MessageWatcher struct {
+	api     WatcherAPI
+	db      harmonydb.Interface
+	connect func(ctx context.Context) (lockSession, error)
+
+	// only accessed from processHeadChange
+	session lockSession
+	claimed bool
+}
+
+// NewMessageWatcher creates a MessageWatcher, following the head changes of
+// pcs.
+func NewMessageWatcher(api WatcherAPI, db *harmonydb.DB, pcs *chainsched.ProviderChainSched) (*MessageWatcher, error) {
+	mw := &MessageWatcher{
+		api: api,
+		db:  db,
+		connect: func(ctx context.Context) (lockSession, error) {
+			return db.LockSession(ctx)
+		},
+	}
+
+	if err := pcs.AddHandler(mw.processHeadChange); err != nil {
+		return nil, err
+	}
+	return mw, nil
+}
+
+// claim takes the watcher lock, or checks that this node still holds it,
+// returning whether this node records executions.
+func (mw *MessageWatcher) claim(ctx context.Context) (bool, error) {
+	if mw.session == nil {
+		session, err := mw.connect(ctx)
+		if err != nil {
+			return false, xerrors.Errorf("opening lock session: %w", err)
+		}
+		mw.session = session
+	}
+
+	if mw.claimed {
+		// the lock is gone with the session, another node may hold it now
+		if err := mw.session.Ping(ctx); err != nil {
+			mw.closeSession()
+			return false, xerrors.Errorf("lock session failed: %w", err)
+		}
+		return true, nil
+	}
+
+	ok, err := mw.session.TryLock(ctx, watcherLockKey)
+	if err != nil {
+		mw.closeSession()
+		return false, err
+	}
+	if ok {
+		log.Infow("recording executions of sent messages on this node")
+	}
+	mw.claimed = ok
+	return ok, nil
+}
+
+func (mw *MessageWatcher) closeSession() {
+	_ = mw.session.Close()
+	mw.session = nil
+	mw.claimed = false
+}
+
+type pendingWait struct {
+	SignedCid    string    `db:"signed_message_cid"`
+	WaitingSince time.Time `db:"waiting_since"`
+	FromKey      *string   `db:"from_key"`
+	Nonce        *uint64   `db:"nonce"`
+}
+
+func (mw *MessageWatcher) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	if apply == nil {
+		return nil
+	}
+
+	claimed, err := mw.claim(ctx)
+	if err != nil {
+		return xerrors.Errorf("claiming message waits: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	var pending []pendingWait
+	err = mw.db.Select(ctx, &pending, `SELECT w.signed_message_cid, w.waiting_since, s.from_key, s.nonce
+		FROM message_waits w LEFT JOIN message_sends s ON s.signed_cid = w.signed_message_cid
+		WHERE w.executed_tsk_cid IS NULL AND NOT w.expired`)
+	if err != nil {
+		return xerrors.Errorf("getting pending messages: %w", err)
+	}
+
+	nonces := map[string]uint64{}
+	for _, p := range pending {
+		c, err := cid.Parse(p.SignedCid)
+		if err != nil {
+			return xerrors.Errorf("parsing message cid: %w", err)
+		}
+
+		// the epoch the message was sent at, going by the head timestamp
+		sentAgo := time.Unix(int64(apply.MinTimestamp()), 0).Sub(p.WaitingSince)
+		sentAt := apply.Height() - abi.ChainEpoch(sentAgo/(time.Duration(build.BlockDelaySecs)*time.Second)) - watchSearchMargin
+		if sentAt < 0 {
+			sentAt = 0
+		}
+		expired := apply.Height()-sentAt > WatchLookback
+
+		if p.FromKey != nil && p.Nonce != nil && !expired {
+			next, ok := nonces[*p.FromKey]
+			if !ok {
+				next, err = mw.senderNonce(ctx, apply, *p.FromKey)
+				if err != nil {
+					log.Warnw("getting sender nonce", "cid", c, "from", *p.FromKey, "error", err)
+					continue
+				}
+				nonces[*p.FromKey] = next
+			}
+			if next <= *p.Nonce {
+				// neither the message nor a replacement was executed yet
+				continue
+			}
+		}
+
+		lookup, err := mw.api.StateSearchMsg(ctx, apply.Key(), c, apply.Height()-sentAt, true)
+		if err != nil {
+			log.Warnw("searching for message", "cid", c, "error", err)
+			continue
+		}
+		if lookup == nil {
+			if expired {
+				if _, err := mw.db.Exec(ctx, `UPDATE message_waits SET expired = true WHERE signed_message_cid = $1 AND executed_tsk_cid IS NULL`, p.SignedCid); err != nil {
+					return xerrors.Errorf("expiring message wait: %w", err)
+				}
+				log.Warnw("message not executed within the watch lookback, no longer waiting for it", "cid", c, "since", p.WaitingSince, "lookback", WatchLookback)
+			}
+			continue
+		}
+		if apply.Height()-lookup.Height < abi.ChainEpoch(build.MessageConfidence) {
+			continue
+		}
+
+		if err := recordExecution(ctx, mw.db, c, lookup); err != nil {
+			return err
+		}
+		log.Infow("message executed", "cid", c, "executed", lookup.Message, "height", lookup.Height, "exit_code", lookup.Receipt.ExitCode)
+	}
+	return nil
+}
+
+// senderNonce returns the nonce of the next message of the sender at ts.
+func (mw *MessageWatcher) senderNonce(ctx context.Context, ts *types.TipSet, from string) (uint64, error) {
+	addr, err := address.NewFromString(from)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing sender address: %w", err)
+	}
+	act, err := mw.api.StateGetActor(ctx, addr, ts.Key())
+	if err != nil {
+		return 0, err
+	}
+	return act.Nonce, nil
+}
+
+func recordExecution(ctx context.Context, db harmonydb.Interface, sigCid cid.Cid, lookup *api.MsgLookup) error {
+	tskCid, err := lookup.TipSet.Cid()
+	if err != nil {
+		return xerrors.Errorf("getting tipset key cid: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `UPDATE message_waits SET executed_tsk_cid = $1, executed_tsk_epoch = $2, executed_msg_cid = $3,
+			executed_rcpt_exitcode = $4, executed_rcpt_return = $5, executed_rcpt_gas_used = $6
+		WHERE signed_message_cid = $7 AND executed_tsk_cid IS NULL`,
+		tskCid.String(), lookup.Height, lookup.Message.String(),
+		lookup.Receipt.ExitCode, lookup.Receipt.Return, lookup.Receipt.GasUsed, sigCid.String())
+	if err != nil {
+		return xerrors.Errorf("recording message execution: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package lpmessage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+type fakeWatcherAPI struct {
+	executed map[cid.Cid]*api.MsgLookup
+	nonces   map[address.Address]uint64
+
+	searched map[cid.Cid]abi.ChainEpoch // limit of the last search
+}
+
+func (f *fakeWatcherAPI) StateGetActor(ctx context.Context, addr address.Address, tsk types.TipSetKey) (*types.Actor, error) {
+	return &types.Actor{Nonce: f.nonces[addr]}, nil
+}
+
+func (f *fakeWatcherAPI) StateSearchMsg(ctx context.Context, from types.TipSetKey, msg cid.Cid, limit abi.ChainEpoch, allowReplaced bool) (*api.MsgLookup, error) {
+	f.searched[msg] = limit
+	return f.executed[msg], nil
+}
+
+// fakeSession is a lock session holding the locks in held.
+type fakeSession struct {
+	held  map[int64]bool
+	fail  bool
+	pings int
+}
+
+func (s *fakeSession) TryLock(ctx context.Context, key int64) (bool, error) {
+	return s.held[key], nil
+}
+
+func (s *fakeSession) Ping(ctx context.Context) error {
+	s.pings++
+	if s.fail {
+		return xerrors.New("connection lost")
+	}
+	return nil
+}
+
+func (s *fakeSession) Close() error { return nil }
+
+func TestMessageWatcher(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	from, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	fromKey := from.String()
+
+	confirmed := mock.MkBlock(nil, 1, 1).Cid()     // executed long enough ago
+	replacement := mock.MkBlock(nil, 1, 2).Cid()   // what confirmed was replaced with
+	recent := mock.MkBlock(nil, 1, 3).Cid()        // executed too recently
+	pending := mock.MkBlock(nil, 1, 4).Cid()       // still in the message pool
+	lost := mock.MkBlock(nil, 1, 7).Cid()          // never executed
+	execTs := mock.TipSet(mock.MkBlock(nil, 1, 5)) // where both were executed
+
+	fapi := &fakeWatcherAPI{
+		executed: map[cid.Cid]*api.MsgLookup{
+			confirmed: {
+				Message: replacement,
+				Receipt: types.MessageReceipt{ExitCode: exitcode.ErrForbidden, GasUsed: 1234},
+				TipSet:  execTs.Key(),
+				Height:  1990,
+			},
+			recent: {
+				Message: recent,
+				TipSet:  execTs.Key(),
+				Height:  1998,
+			},
+		},
+		nonces:   map[address.Address]uint64{from: 3},
+		searched: map[cid.Cid]abi.ChainEpoch{},
+	}
+
+	session := &fakeSession{held: map[int64]bool{}}
+	mw := &MessageWatcher{
+		api: fapi,
+		db:  db,
+		connect: func(ctx context.Context) (lockSession, error) {
+			return session, nil
+		},
+	}
+
+	head := mock.MkBlock(nil, 1, 6)
+	head.Height = 2000
+	headTs := mock.TipSet(head)
+	headTime := time.Unix(int64(head.Timestamp), 0)
+	epochsAgo := func(n int) time.Time {
+		return headTime.Add(-time.Duration(n) * time.Duration(build.BlockDelaySecs) * time.Second)
+	}
+	nonce := func(n uint64) *uint64 { return &n }
+
+	// another node holds the lock, nothing is searched
+	require.NoError(t, mw.processHeadChange(ctx, nil, headTs))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Empty(t, fapi.searched)
+
+	session.held[watcherLockKey] = true
+	db.ExpectSelect(`FROM message_waits w LEFT JOIN message_sends s`).
+		WillReturnSelect([]pendingWait{
+			{SignedCid: confirmed.String(), WaitingSince: epochsAgo(20), FromKey: &fromKey, Nonce: nonce(0)},
+			{SignedCid: recent.String(), WaitingSince: epochsAgo(5), FromKey: &fromKey, Nonce: nonce(1)},
+			{SignedCid: pending.String(), WaitingSince: epochsAgo(1), FromKey: &fromKey, Nonce: nonce(3)},
+			{SignedCid: lost.String(), WaitingSince: epochsAgo(int(WatchLookback) + 10)},
+		})
+	db.ExpectExec(`UPDATE message_waits SET executed_tsk_cid`).
+		WithArgs(harmonydb.MockAnyArg, abi.ChainEpoch(1990), replacement.String(), exitcode.ErrForbidden, harmonydb.MockAnyArg, int64(1234), confirmed.String()).
+		WillReturnCount(1)
+	db.ExpectExec(`UPDATE message_waits SET expired = true`).WithArgs(lost.String()).WillReturnCount(1)
+
+	require.NoError(t, mw.processHeadChange(ctx, nil, headTs))
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// searches only go back to when the messages were sent
+	require.Equal(t, abi.ChainEpoch(20)+watchSearchMargin, fapi.searched[confirmed])
+	require.Equal(t, abi.ChainEpoch(5)+watchSearchMargin, fapi.searched[recent])
+	// the sender nonce didn't pass the message nonce yet
+	require.NotContains(t, fapi.searched, pending)
+	require.Equal(t, WatchLookback+10+watchSearchMargin, fapi.searched[lost])
+
+	// the lock is kept while the session is alive
+	db.ExpectSelect(`FROM message_waits w LEFT JOIN message_sends s`).WillReturnSelect([]pendingWait{})
+	require.NoError(t, mw.processHeadChange(ctx, nil, headTs))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Equal(t, 1, session.pings)
+
+	// and given up with it
+	session.fail = true
+	require.Error(t, mw.processHeadChange(ctx, nil, headTs))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.False(t, mw.claimed)
+}
+
+func TestParseWaitStrategy(t *testing.T) {
+	for in, exp := range map[string]WaitStrategy{
+		"":          WaitMempool,
+		"mempool":   WaitMempool,
+		"confirmed": WaitConfirmed,
+	} {
+		ws, err := ParseWaitStrategy(in)
+		require.NoError(t, err)
+		require.Equal(t, exp, ws)
+	}
+
+	_, err := ParseWaitStrategy("eventually")
+	require.Error(t, err)
+}
@@ -0,0 +1,225 @@
+package lpprune
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+	"github.com/filecoin-project/lotus/lib/promise"
+)
+
+var log = logging.Logger("lpprune")
+
+// PrunePeriod is how often history pruning runs in the cluster.
+var PrunePeriod = time.Hour
+
+// PruneBatchSize is the number of rows deleted per transaction, keeping
+// individual transactions (and the locks they hold) short.
+var PruneBatchSize = 1000
+
+// PruneTask deletes completed task history, related message rows, spot and
+// canary check results and warmup records older than the retention period.
+// Before history rows are deleted they are folded into
+// harmony_task_history_summary, so per-day success/failure counts are kept.
+//
+// Every node running the task tries to schedule it once per PrunePeriod; the
+// unique period key in harmony_prune_tasks makes sure only one task is created
+// (and so only one node prunes) per period.
+type PruneTask struct {
+	db        harmonydb.Interface
+	retention time.Duration
+
+	pruneTF promise.Promise[harmonytask.AddTaskFunc]
+}
+
+func NewPruneTask(ctx context.Context, db harmonydb.Interface, retention time.Duration) *PruneTask {
+	t := &PruneTask{
+		db:        db,
+		retention: retention,
+	}
+
+	go t.schedule(ctx)
+
+	return t
+}
+
+func (t *PruneTask) schedule(ctx context.Context) {
+	for {
+		period := time.Now().UTC().Truncate(PrunePeriod)
+
+		t.pruneTF.Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+			n, err := tx.Exec(`INSERT INTO harmony_prune_tasks (task_id, period) VALUES ($1, $2) ON CONFLICT DO NOTHING`, id, period)
+			if err != nil {
+				return false, err
+			}
+
+			// another node already scheduled pruning for this period
+			return n == 1, nil
+		})
+
+		select {
+		case <-time.After(time.Until(period.Add(PrunePeriod))):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *PruneTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+	cutoff := time.Now().UTC().Add(-t.retention)
+
+	history, err := t.pruneBatched(ctx, stillOwned, func() (n int, err error) {
+		_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+			_, err = tx.Exec(`INSERT INTO harmony_task_history_summary (name, result, day, count)
+				SELECT name, result, work_end::date, COUNT(*) FROM harmony_task_history
+					WHERE id IN (SELECT id FROM harmony_task_history WHERE work_end < $1 ORDER BY id LIMIT $2)
+					GROUP BY name, result, work_end::date
+				ON CONFLICT (name, result, day) DO UPDATE
+					SET count = harmony_task_history_summary.count + EXCLUDED.count`, cutoff, PruneBatchSize)
+			if err != nil {
+				return false, xerrors.Errorf("summarizing task history: %w", err)
+			}
+
+			n, err = tx.Exec(`DELETE FROM harmony_task_history
+				WHERE id IN (SELECT id FROM harmony_task_history WHERE work_end < $1 ORDER BY id LIMIT $2)`, cutoff, PruneBatchSize)
+			if err != nil {
+				return false, xerrors.Errorf("deleting task history: %w", err)
+			}
+
+			return true, nil
+		})
+		return n, err
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning task history: %w", err)
+	}
+
+	sends, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		// only settled sends; pending ones are still needed for nonce assignment
+		return t.db.Exec(ctx, `DELETE FROM message_sends
+			WHERE (send_task_id, from_key) IN (SELECT send_task_id, from_key FROM message_sends
+				WHERE send_success IS NOT NULL AND send_time < $1 LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning message sends: %w", err)
+	}
+
+	waits, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		return t.db.Exec(ctx, `DELETE FROM message_waits
+			WHERE signed_message_cid IN (SELECT signed_message_cid FROM message_waits WHERE waiting_since < $1 LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning message waits: %w", err)
+	}
+
+	audit, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		return t.db.Exec(ctx, `DELETE FROM message_address_audit
+			WHERE id IN (SELECT id FROM message_address_audit WHERE sent_at < $1 ORDER BY id LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning address audit: %w", err)
+	}
+
+	spotChecks, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		return t.db.Exec(ctx, `DELETE FROM wdpost_spot_checks
+			WHERE (sp_id, sector_number, checked_at) IN (SELECT sp_id, sector_number, checked_at FROM wdpost_spot_checks
+				WHERE checked_at < $1 LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning spot checks: %w", err)
+	}
+
+	// tasks which still run keep their row
+	_, err = t.db.Exec(ctx, `DELETE FROM wdpost_spot_check_tasks
+		WHERE period < $1 AND task_id NOT IN (SELECT id FROM harmony_task)`, cutoff)
+	if err != nil {
+		return false, xerrors.Errorf("pruning old spot check tasks: %w", err)
+	}
+
+	warmMissing, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		return t.db.Exec(ctx, `DELETE FROM wdpost_warm_missing
+			WHERE (sp_id, proving_period_start, deadline_index, sector_number, file_type) IN (
+				SELECT sp_id, proving_period_start, deadline_index, sector_number, file_type FROM wdpost_warm_missing
+					WHERE reported_at < $1 LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning missing warm files: %w", err)
+	}
+
+	_, err = t.db.Exec(ctx, `DELETE FROM wdpost_warm_tasks
+		WHERE created_at < $1 AND task_id NOT IN (SELECT id FROM harmony_task)`, cutoff)
+	if err != nil {
+		return false, xerrors.Errorf("pruning old warm tasks: %w", err)
+	}
+
+	canaryChecks, err := t.pruneBatched(ctx, stillOwned, func() (int, error) {
+		return t.db.Exec(ctx, `DELETE FROM wdpost_canary_checks
+			WHERE (task_id, sector_number) IN (SELECT task_id, sector_number FROM wdpost_canary_checks
+				WHERE checked_at < $1 LIMIT $2)`, cutoff, PruneBatchSize)
+	})
+	if err != nil {
+		return false, xerrors.Errorf("pruning canary checks: %w", err)
+	}
+
+	_, err = t.db.Exec(ctx, `DELETE FROM wdpost_canary_tasks
+		WHERE created_at < $1 AND task_id NOT IN (SELECT id FROM harmony_task)`, cutoff)
+	if err != nil {
+		return false, xerrors.Errorf("pruning old canary tasks: %w", err)
+	}
+
+	_, err = t.db.Exec(ctx, `DELETE FROM harmony_prune_tasks WHERE period < $1 AND task_id != $2`, cutoff, taskID)
+	if err != nil {
+		return false, xerrors.Errorf("pruning old prune tasks: %w", err)
+	}
+
+	log.Infow("pruned history", "before", cutoff, "task_history", history, "message_sends", sends, "message_waits", waits, "address_audit", audit, "spot_checks", spotChecks, "warm_missing", warmMissing, "canary_checks", canaryChecks)
+
+	return true, nil
+}
+
+func (t *PruneTask) pruneBatched(ctx context.Context, stillOwned func() bool, del func() (int, error)) (int, error) {
+	var total int
+	for stillOwned() {
+		n, err := del()
+		if err != nil {
+			return total, err
+		}
+
+		total += n
+		if n < PruneBatchSize {
+			break
+		}
+	}
+
+	return total, nil
+}
+
+func (t *PruneTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *PruneTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Name:        "HistoryPrune",
+		Max:         1,
+		MaxFailures: 3,
+		Cost: resources.Resources{
+			Cpu: 0,
+			Gpu: 0,
+			Ram: 64 << 20,
+		},
+	}
+}
+
+func (t *PruneTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	t.pruneTF.Set(taskFunc)
+}
+
+var _ harmonytask.TaskInterface = &PruneTask{}
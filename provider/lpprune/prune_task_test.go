@@ -0,0 +1,56 @@
+package lpprune
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+func TestPruneBatches(t *testing.T) {
+	defer func(bs int) { PruneBatchSize = bs }(PruneBatchSize)
+	PruneBatchSize = 2
+
+	db := harmonydb.NewMock()
+
+	// task history goes in two batches, the second one being short
+	for _, n := range []int{2, 1} {
+		db.ExpectExec(`INSERT INTO harmony_task_history_summary`).WithArgs(harmonydb.MockAnyArg, 2)
+		db.ExpectExec(`DELETE FROM harmony_task_history`).WithArgs(harmonydb.MockAnyArg, 2).WillReturnCount(n)
+	}
+	db.ExpectExec(`DELETE FROM message_sends`).WillReturnCount(0)
+	db.ExpectExec(`DELETE FROM message_waits`).WillReturnCount(0)
+	db.ExpectExec(`DELETE FROM message_address_audit`).WillReturnCount(0)
+	db.ExpectExec(`DELETE FROM wdpost_spot_checks`).WithArgs(harmonydb.MockAnyArg, 2).WillReturnCount(1)
+	db.ExpectExec(`DELETE FROM wdpost_spot_check_tasks`)
+	db.ExpectExec(`DELETE FROM wdpost_warm_missing`).WithArgs(harmonydb.MockAnyArg, 2).WillReturnCount(0)
+	db.ExpectExec(`DELETE FROM wdpost_warm_tasks`)
+	db.ExpectExec(`DELETE FROM wdpost_canary_checks`).WithArgs(harmonydb.MockAnyArg, 2).WillReturnCount(0)
+	db.ExpectExec(`DELETE FROM wdpost_canary_tasks`)
+	db.ExpectExec(`DELETE FROM harmony_prune_tasks`).WithArgs(harmonydb.MockAnyArg, 7)
+
+	task := &PruneTask{db: db, retention: time.Hour}
+
+	done, err := task.Do(7, func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestPruneStopsWhenNotOwned(t *testing.T) {
+	db := harmonydb.NewMock()
+	db.ExpectExec(`DELETE FROM wdpost_spot_check_tasks`)
+	db.ExpectExec(`DELETE FROM wdpost_warm_tasks`)
+	db.ExpectExec(`DELETE FROM wdpost_canary_tasks`)
+	db.ExpectExec(`DELETE FROM harmony_prune_tasks`)
+
+	task := &PruneTask{db: db, retention: time.Hour}
+
+	// losing the task skips all batches
+	done, err := task.Do(7, func() bool { return false })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+}
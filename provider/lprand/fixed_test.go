@@ -0,0 +1,60 @@
+package lprand
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+func TestFixedIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+
+	mkKey := func(s string) types.TipSetKey {
+		c, err := abi.CidBuilder.Sum([]byte(s))
+		require.NoError(t, err)
+		return types.NewTipSetKey(c)
+	}
+
+	a := &Fixed{Seed: []byte("seed")}
+	b := &Fixed{Seed: []byte("seed")}
+
+	r1, err := a.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 100, []byte("miner"), mkKey("fork-a"))
+	require.NoError(t, err)
+	require.Len(t, r1, 32)
+
+	// same inputs on another source and another fork
+	r2, err := b.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 100, []byte("miner"), mkKey("fork-b"))
+	require.NoError(t, err)
+	require.Equal(t, r1, r2)
+
+	r3, err := b.DrawFromBeaconEntry(&types.BeaconEntry{Round: 1, Data: []byte("entry")}, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 100, []byte("miner"))
+	require.NoError(t, err)
+	require.Equal(t, r1, r3)
+
+	// every input changes the randomness
+	others := []func() (abi.Randomness, error){
+		func() (abi.Randomness, error) {
+			return (&Fixed{Seed: []byte("other")}).StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 100, []byte("miner"), types.EmptyTSK)
+		},
+		func() (abi.Randomness, error) {
+			return a.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WinningPoStChallengeSeed, 100, []byte("miner"), types.EmptyTSK)
+		},
+		func() (abi.Randomness, error) {
+			return a.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 101, []byte("miner"), types.EmptyTSK)
+		},
+		func() (abi.Randomness, error) {
+			return a.StateGetRandomnessFromTickets(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, 100, []byte("other"), types.EmptyTSK)
+		},
+	}
+	for i, f := range others {
+		r, err := f()
+		require.NoError(t, err)
+		require.NotEqual(t, r1, r, "input %d", i)
+	}
+}
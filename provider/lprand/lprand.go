@@ -0,0 +1,44 @@
+// Package lprand abstracts where proving tasks get their challenge randomness
+// from, so that tests can prove against fixed randomness.
+package lprand
+
+import (
+	"context"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+
+	lrand "github.com/filecoin-project/lotus/chain/rand"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// Source provides the randomness proofs are computed from. The chain and
+// beacon methods match the full node API; use Node to wrap one.
+type Source interface {
+	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+	StateGetRandomnessFromTickets(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+
+	// DrawFromBeaconEntry draws randomness from a beacon entry which isn't
+	// on chain yet, such as the one a WinningPoSt is computed for.
+	DrawFromBeaconEntry(entry *types.BeaconEntry, personalization crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) (abi.Randomness, error)
+}
+
+type NodeAPI interface {
+	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+	StateGetRandomnessFromTickets(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+}
+
+// Node returns the randomness the chain uses, read from a full node.
+func Node(api NodeAPI) Source {
+	return &node{NodeAPI: api}
+}
+
+type node struct {
+	NodeAPI
+}
+
+func (n *node) DrawFromBeaconEntry(entry *types.BeaconEntry, personalization crypto.DomainSeparationTag, round abi.ChainEpoch, entropy []byte) (abi.Randomness, error) {
+	return lrand.DrawRandomnessFromBase(entry.Data, personalization, round, entropy)
+}
+
+var _ Source = &node{}
@@ -0,0 +1,131 @@
+// Package lpsync waits for the full node to be in sync with the chain before
+// the provider starts working on tasks which depend on it.
+package lpsync
+
+import (
+	"context"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+var log = logging.Logger("lpsync")
+
+type WaitAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	SyncState(context.Context) (*api.SyncState, error)
+}
+
+// Config controls how Wait polls the node.
+type Config struct {
+	// Timeout is how long to wait for the node to sync in total, 0 to wait
+	// forever.
+	Timeout time.Duration
+	// MinInterval is the delay between polls while the node makes progress.
+	MinInterval time.Duration
+	// MaxInterval caps the delay between polls, which doubles after every
+	// error or poll without progress.
+	MaxInterval time.Duration
+}
+
+var DefaultConfig = Config{
+	MinInterval: time.Second,
+	MaxInterval: 30 * time.Second,
+}
+
+// Wait returns once the head of the node is recent, i.e. less than a block
+// delay old. Errors talking to the node are logged and retried, as the node
+// may be restarting; they only fail the wait once the timeout expires.
+func Wait(ctx context.Context, napi WaitAPI, cfg Config) error {
+	if cfg.MinInterval <= 0 {
+		cfg.MinInterval = DefaultConfig.MinInterval
+	}
+	if cfg.MaxInterval < cfg.MinInterval {
+		cfg.MaxInterval = cfg.MinInterval
+	}
+
+	var deadline <-chan time.Time
+	if cfg.Timeout > 0 {
+		timer := time.NewTimer(cfg.Timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	interval := cfg.MinInterval
+	var lastHeight abi.ChainEpoch = -1
+	var lastErr error
+
+	for {
+		done, height, err := checkSync(ctx, napi)
+		switch {
+		case err != nil:
+			log.Warnw("checking full node sync state", "error", err, "retryIn", interval)
+			lastErr = err
+			interval = backoff(interval, cfg.MaxInterval)
+		case done:
+			log.Infow("full node is in sync", "height", height)
+			return nil
+		default:
+			lastErr = nil
+			if height > lastHeight {
+				interval = cfg.MinInterval
+			} else {
+				interval = backoff(interval, cfg.MaxInterval)
+			}
+			lastHeight = height
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-deadline:
+			if lastErr != nil {
+				return xerrors.Errorf("full node not reachable after waiting %s for it to sync: %w", cfg.Timeout, lastErr)
+			}
+			return xerrors.Errorf("full node not in sync after %s, current height %d (use --nosync to start anyway)", cfg.Timeout, lastHeight)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkSync returns whether the node is in sync, and the height of its head.
+func checkSync(ctx context.Context, napi WaitAPI) (bool, abi.ChainEpoch, error) {
+	head, err := napi.ChainHead(ctx)
+	if err != nil {
+		return false, 0, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	if time.Now().Unix()-int64(head.MinTimestamp()) < int64(build.BlockDelaySecs) {
+		return true, head.Height(), nil
+	}
+
+	state, err := napi.SyncState(ctx)
+	if err != nil {
+		return false, 0, xerrors.Errorf("getting sync state: %w", err)
+	}
+
+	var target abi.ChainEpoch
+	for _, ss := range state.ActiveSyncs {
+		if ss.Target != nil && ss.Target.Height() > target {
+			target = ss.Target.Height()
+		}
+	}
+
+	log.Infow("waiting for full node to sync", "height", head.Height(), "target", target)
+	return false, head.Height(), nil
+}
+
+func backoff(interval, max time.Duration) time.Duration {
+	interval *= 2
+	if interval > max {
+		return max
+	}
+	return interval
+}
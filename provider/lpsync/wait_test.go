@@ -0,0 +1,100 @@
+package lpsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+)
+
+// flakyNode is unreachable for the first calls, then serves heads from a
+// list, the last of which is recent.
+type flakyNode struct {
+	lk          sync.Mutex
+	unreachable int
+	heads       []*types.TipSet
+	calls       int
+}
+
+func (n *flakyNode) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+
+	n.calls++
+	if n.unreachable > 0 {
+		n.unreachable--
+		return nil, xerrors.Errorf("dial tcp 127.0.0.1:1234: connect: connection refused")
+	}
+
+	head := n.heads[0]
+	if len(n.heads) > 1 {
+		n.heads = n.heads[1:]
+	}
+	return head, nil
+}
+
+func (n *flakyNode) SyncState(ctx context.Context) (*api.SyncState, error) {
+	return &api.SyncState{}, nil
+}
+
+func mkHead(t *testing.T, height int, timestamp time.Time) *types.TipSet {
+	b := mock.MkBlock(nil, 1, uint64(height))
+	b.Height = abi.ChainEpoch(height)
+	b.Timestamp = uint64(timestamp.Unix())
+	return mock.TipSet(b)
+}
+
+func testConfig(timeout time.Duration) Config {
+	return Config{
+		Timeout:     timeout,
+		MinInterval: time.Millisecond,
+		MaxInterval: 4 * time.Millisecond,
+	}
+}
+
+func TestWaitNodeBecomesReachable(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+
+	node := &flakyNode{
+		unreachable: 5,
+		heads: []*types.TipSet{
+			mkHead(t, 10, old),
+			mkHead(t, 20, old),
+			mkHead(t, 30, time.Now()),
+		},
+	}
+
+	require.NoError(t, Wait(context.Background(), node, testConfig(time.Minute)))
+	require.Equal(t, 8, node.calls)
+}
+
+func TestWaitTimeout(t *testing.T) {
+	// never reachable
+	node := &flakyNode{unreachable: 1 << 30}
+	err := Wait(context.Background(), node, testConfig(50*time.Millisecond))
+	require.ErrorContains(t, err, "not reachable")
+	require.ErrorContains(t, err, "connection refused")
+
+	// reachable, but stuck behind
+	node = &flakyNode{heads: []*types.TipSet{mkHead(t, 10, time.Now().Add(-time.Hour))}}
+	err = Wait(context.Background(), node, testConfig(50*time.Millisecond))
+	require.ErrorContains(t, err, "not in sync")
+	require.ErrorContains(t, err, "current height 10")
+}
+
+func TestWaitCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	node := &flakyNode{unreachable: 1 << 30}
+	require.ErrorIs(t, Wait(ctx, node, testConfig(0)), context.Canceled)
+}
@@ -0,0 +1,132 @@
+package lpverifreg
+
+import (
+	"context"
+	"time"
+
+	cbor "github.com/ipfs/go-ipld-cbor"
+	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/blockstore"
+	"github.com/filecoin-project/lotus/chain/actors/adt"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/verifreg"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+)
+
+var log = logging.Logger("lpverifreg")
+
+// DefaultCheckInterval is used when no root key check interval is configured.
+const DefaultCheckInterval = 10 * time.Minute
+
+type MonitorAPI interface {
+	blockstore.ChainIO
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateGetActor(context.Context, address.Address, types.TipSetKey) (*types.Actor, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+}
+
+// Monitor follows the root key of the verified registry actor. Changes of the
+// root key are logged, and an alert is raised while it doesn't match the
+// expected one.
+type Monitor struct {
+	api      MonitorAPI
+	expected address.Address
+	interval time.Duration
+
+	// last seen verified registry state, nil before the first check
+	last verifreg.State
+
+	al    *alerting.Alerting
+	alert alerting.AlertType
+}
+
+func NewMonitor(api MonitorAPI, al *alerting.Alerting, expected address.Address, interval time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	return &Monitor{
+		api:      api,
+		expected: expected,
+		interval: interval,
+
+		al:    al,
+		alert: al.AddAlertType("lpverifreg", "root-key-mismatch"),
+	}
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+
+	for {
+		if err := m.check(ctx); err != nil {
+			log.Errorw("checking verified registry root key", "error", err)
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Monitor) check(ctx context.Context) error {
+	head, err := m.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	act, err := m.api.StateGetActor(ctx, verifreg.Address, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting verified registry actor: %w", err)
+	}
+
+	store := adt.WrapStore(ctx, cbor.NewCborStore(blockstore.NewAPIBlockstore(m.api)))
+	st, upgraded, err := verifreg.Reload(store, m.last, act)
+	if err != nil {
+		return xerrors.Errorf("loading verified registry state: %w", err)
+	}
+	if upgraded {
+		log.Infow("verified registry actor upgraded", "from", m.last.ActorVersion(), "to", st.ActorVersion(), "epoch", head.Height())
+	}
+
+	if m.last != nil {
+		ch, err := verifreg.DiffRootKey(m.last, st)
+		if err != nil {
+			return err
+		}
+		if ch != nil {
+			log.Warnw("verified registry root key changed", "from", ch.From, "to", ch.To, "epoch", head.Height())
+		}
+	}
+	m.last = st
+
+	mismatch, err := verifreg.CheckRootKey(st, m.expected, func(a address.Address) (address.Address, error) {
+		return m.api.StateLookupID(ctx, a, head.Key())
+	})
+	if err != nil {
+		return err
+	}
+
+	info := map[string]interface{}{
+		"expected": m.expected.String(),
+		"epoch":    head.Height(),
+	}
+
+	if mismatch != nil {
+		info["actual"] = mismatch.To.String()
+		if !m.al.IsRaised(m.alert) {
+			m.al.Raise(m.alert, info)
+		}
+	} else if m.al.IsRaised(m.alert) {
+		m.al.Resolve(m.alert, info)
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package lpwindow
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-bitfield"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// LocalPaths lists the storage paths attached to this node, see paths.Local.
+type LocalPaths interface {
+	Local(ctx context.Context) ([]storiface.StoragePath, error)
+}
+
+// StorageAffinity makes WindowPoSt partitions prefer the nodes which have
+// their sectors in local storage paths. When a partition is scheduled, the
+// paths holding the sealed files of its live sectors are looked up in the
+// index and recorded with the task. A node with none of those paths attached
+// leaves the task to the nodes which have them, as long as one of the paths
+// is heartbeating, but only for grace after the task was posted; after that
+// any node takes it and fetches the files it needs.
+type StorageAffinity struct {
+	db    harmonydb.Interface
+	local LocalPaths
+	grace time.Duration
+}
+
+func NewStorageAffinity(db harmonydb.Interface, local LocalPaths, grace time.Duration) *StorageAffinity {
+	return &StorageAffinity{
+		db:    db,
+		local: local,
+		grace: grace,
+	}
+}
+
+// taskLocality is how the sectors of a partition task are stored relative to
+// this node.
+type taskLocality struct {
+	// Tracked is false when no affinity was recorded for the task, e.g. when
+	// it was scheduled with affinity disabled, or none of its sectors is in
+	// the index.
+	Tracked bool
+	// LocalSectors is the number of sectors with sealed files in paths
+	// attached to this node. A sector stored in multiple local paths is
+	// counted for each.
+	LocalSectors int64
+	// RemoteLive is set when paths attached only to other nodes hold some of
+	// the sectors, and at least one of them is heartbeating.
+	RemoteLive bool
+}
+
+// Local returns whether this node has at least some of the sectors locally.
+func (l taskLocality) Local() bool {
+	return l.LocalSectors > 0
+}
+
+// preferRemote returns whether another node is better placed to prove the
+// task than this one.
+func (l taskLocality) preferRemote() bool {
+	return l.Tracked && !l.Local() && l.RemoteLive
+}
+
+// locality label used in metrics
+func (l taskLocality) String() string {
+	switch {
+	case !l.Tracked:
+		return "unknown"
+	case l.Local():
+		return "local"
+	default:
+		return "remote"
+	}
+}
+
+type storageSectors struct {
+	StorageID string `db:"storage_id"`
+	Sectors   int64  `db:"sectors"`
+}
+
+type affinityRow struct {
+	TaskID    int64  `db:"task_id"`
+	StorageID string `db:"storage_id"`
+	Sectors   int64  `db:"sectors"`
+	Live      bool   `db:"live"`
+}
+
+// locate returns the number of sectors with sealed files in each storage
+// path, per the index.
+func (a *StorageAffinity) locate(ctx context.Context, spID uint64, sectors bitfield.BitField) (map[string]int64, error) {
+	nums, err := sectors.All(math.MaxUint64)
+	if err != nil {
+		return nil, xerrors.Errorf("listing sectors: %w", err)
+	}
+	if len(nums) == 0 {
+		return nil, nil
+	}
+
+	var rows []storageSectors
+	err = a.db.Select(ctx, &rows, `SELECT storage_id, COUNT(DISTINCT sector_num) AS sectors FROM sector_location
+			WHERE miner_id = $1 AND sector_num IN (SELECT unnest(string_to_array($2, ','))::bigint)
+				AND (sector_filetype & $3) != 0
+			GROUP BY storage_id`,
+		spID, strings.Join(lo.Map(nums, entToStr[uint64]), ","), int(storiface.FTSealed|storiface.FTUpdate))
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector locations: %w", err)
+	}
+
+	out := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		out[r.StorageID] = r.Sectors
+	}
+	return out, nil
+}
+
+// record stores the sector locations of a partition with its task.
+func (a *StorageAffinity) record(tx *harmonydb.Tx, taskID harmonytask.TaskID, locs map[string]int64) error {
+	for sid, n := range locs {
+		_, err := tx.Exec(`INSERT INTO wdpost_partition_affinity (task_id, storage_id, sectors) VALUES ($1, $2, $3)`, taskID, sid, n)
+		if err != nil {
+			return xerrors.Errorf("inserting partition affinity: %w", err)
+		}
+	}
+	return nil
+}
+
+// localities returns the locality of each task, tasks without recorded
+// affinity are left out.
+func (a *StorageAffinity) localities(ctx context.Context, ids []harmonytask.TaskID) (map[harmonytask.TaskID]taskLocality, error) {
+	local, err := a.local.Local(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("listing local storage paths: %w", err)
+	}
+
+	var rows []affinityRow
+	err = a.db.Select(ctx, &rows, `SELECT a.task_id, a.storage_id, a.sectors,
+				COALESCE(CURRENT_TIMESTAMP - sp.last_heartbeat < $2 AND sp.heartbeat_err IS NULL, FALSE) AS live
+			FROM wdpost_partition_affinity a LEFT JOIN storage_path sp ON sp.storage_id = a.storage_id
+			WHERE a.task_id IN (SELECT unnest(string_to_array($1, ','))::bigint)`,
+		strings.Join(lo.Map(ids, entToStr[harmonytask.TaskID]), ","), paths.SkippedHeartbeatThresh)
+	if err != nil {
+		return nil, xerrors.Errorf("getting partition affinity: %w", err)
+	}
+
+	return localitiesFromRows(rows, lo.Map(local, func(p storiface.StoragePath, _ int) storiface.ID { return p.ID })), nil
+}
+
+func localitiesFromRows(rows []affinityRow, local []storiface.ID) map[harmonytask.TaskID]taskLocality {
+	isLocal := make(map[string]struct{}, len(local))
+	for _, id := range local {
+		isLocal[string(id)] = struct{}{}
+	}
+
+	out := map[harmonytask.TaskID]taskLocality{}
+	for _, r := range rows {
+		id := harmonytask.TaskID(r.TaskID)
+		l := out[id]
+		l.Tracked = true
+		if _, ok := isLocal[r.StorageID]; ok {
+			l.LocalSectors += r.Sectors
+		} else if r.Live {
+			l.RemoteLive = true
+		}
+		out[id] = l
+	}
+	return out
+}
+
+// deferred returns the tasks this node should leave to nodes co-located with
+// their storage for now: the ones which prefer another live node and were
+// posted less than grace ago.
+func (a *StorageAffinity) deferred(ctx context.Context, locs map[harmonytask.TaskID]taskLocality) (map[harmonytask.TaskID]struct{}, error) {
+	var candidates []harmonytask.TaskID
+	for id, l := range locs {
+		if l.preferRemote() {
+			candidates = append(candidates, id)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	var young []int64
+	err := a.db.Select(ctx, &young, `SELECT id FROM harmony_task
+			WHERE id IN (SELECT unnest(string_to_array($1, ','))::bigint) AND CURRENT_TIMESTAMP - posted_time < $2`,
+		strings.Join(lo.Map(candidates, entToStr[harmonytask.TaskID]), ","), a.grace)
+	if err != nil {
+		return nil, xerrors.Errorf("getting task age: %w", err)
+	}
+
+	out := make(map[harmonytask.TaskID]struct{}, len(young))
+	for _, id := range young {
+		out[harmonytask.TaskID(id)] = struct{}{}
+	}
+	return out, nil
+}
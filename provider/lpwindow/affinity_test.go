@@ -0,0 +1,101 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-bitfield"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type localPaths []storiface.ID
+
+func (l localPaths) Local(ctx context.Context) ([]storiface.StoragePath, error) {
+	var out []storiface.StoragePath
+	for _, id := range l {
+		out = append(out, storiface.StoragePath{ID: id})
+	}
+	return out, nil
+}
+
+func TestAffinityLocate(t *testing.T) {
+	db := harmonydb.NewMock()
+	a := NewStorageAffinity(db, localPaths{}, time.Minute)
+
+	locs, err := a.locate(context.Background(), 1000, bitfield.New())
+	require.NoError(t, err)
+	require.Nil(t, locs)
+
+	db.ExpectSelect(`FROM sector_location`).WithArgs(1000, "1,2,5", int(storiface.FTSealed|storiface.FTUpdate)).
+		WillReturnSelect([]storageSectors{{StorageID: "a", Sectors: 2}, {StorageID: "b", Sectors: 1}})
+
+	locs, err = a.locate(context.Background(), 1000, bitfield.NewFromSet([]uint64{1, 2, 5}))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"a": 2, "b": 1}, locs)
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestAffinityLocalities(t *testing.T) {
+	rows := []affinityRow{
+		// both local paths hold sectors of task 1
+		{TaskID: 1, StorageID: "local1", Sectors: 3},
+		{TaskID: 1, StorageID: "local2", Sectors: 2},
+		{TaskID: 1, StorageID: "remote", Sectors: 1, Live: true},
+		// task 2 is stored on a live remote path
+		{TaskID: 2, StorageID: "remote", Sectors: 4, Live: true},
+		// task 3 is only stored on a path which isn't heartbeating
+		{TaskID: 3, StorageID: "dead", Sectors: 4},
+	}
+
+	locs := localitiesFromRows(rows, []storiface.ID{"local1", "local2"})
+	require.Equal(t, map[harmonytask.TaskID]taskLocality{
+		1: {Tracked: true, LocalSectors: 5, RemoteLive: true},
+		2: {Tracked: true, RemoteLive: true},
+		3: {Tracked: true},
+	}, locs)
+
+	require.False(t, locs[1].preferRemote())
+	require.True(t, locs[2].preferRemote())
+	require.False(t, locs[3].preferRemote())
+	// no affinity recorded
+	require.False(t, locs[4].preferRemote())
+
+	require.Equal(t, "local", locs[1].String())
+	require.Equal(t, "remote", locs[2].String())
+	require.Equal(t, "unknown", locs[4].String())
+}
+
+func TestAffinityDeferred(t *testing.T) {
+	db := harmonydb.NewMock()
+	a := NewStorageAffinity(db, localPaths{"local"}, time.Minute)
+	ctx := context.Background()
+
+	db.ExpectSelect(`FROM wdpost_partition_affinity`).WithArgs("1,2,3", harmonydb.MockAnyArg).WillReturnSelect([]affinityRow{
+		{TaskID: 1, StorageID: "local", Sectors: 3},
+		{TaskID: 2, StorageID: "remote", Sectors: 4, Live: true},
+		{TaskID: 3, StorageID: "remote", Sectors: 4, Live: true},
+	})
+
+	locs, err := a.localities(ctx, []harmonytask.TaskID{1, 2, 3})
+	require.NoError(t, err)
+
+	// tasks 2 and 3 prefer the node with the remote path, only 2 is still in its grace period
+	db.ExpectSelect(`FROM harmony_task`).WithArgs(harmonydb.MockAnyArg, time.Minute).WillReturnSelect([]int64{2})
+
+	deferred, err := a.deferred(ctx, locs)
+	require.NoError(t, err)
+	require.Equal(t, map[harmonytask.TaskID]struct{}{2: {}}, deferred)
+
+	// nothing to look up when no task prefers another node
+	deferred, err = a.deferred(ctx, map[harmonytask.TaskID]taskLocality{1: locs[1]})
+	require.NoError(t, err)
+	require.Empty(t, deferred)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
@@ -0,0 +1,376 @@
+package lpwindow
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+	"github.com/filecoin-project/lotus/storage/sealer"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type CanaryAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	CheckSectorsAPI
+}
+
+// CanarySector is a sector read-checked every proving period, see CanaryTask.
+type CanarySector struct {
+	Miner  address.Address
+	Number abi.SectorNumber
+}
+
+func (c CanarySector) String() string {
+	return c.Miner.String() + ":" + strconv.FormatUint(uint64(c.Number), 10)
+}
+
+// ParseCanarySectors parses sectors given as <miner>:<sector number>, e.g.
+// f01234:42.
+func ParseCanarySectors(sectors []string) ([]CanarySector, error) {
+	out := make([]CanarySector, 0, len(sectors))
+	for _, s := range sectors {
+		ms, ns, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, xerrors.Errorf("canary sector %q: expected <miner>:<sector number>", s)
+		}
+		maddr, err := address.NewFromString(ms)
+		if err != nil {
+			return nil, xerrors.Errorf("canary sector %q: parsing miner address: %w", s, err)
+		}
+		if maddr.Protocol() != address.ID {
+			return nil, xerrors.Errorf("canary sector %q: miner must be an ID address", s)
+		}
+		num, err := strconv.ParseUint(ns, 10, 64)
+		if err != nil {
+			return nil, xerrors.Errorf("canary sector %q: parsing sector number: %w", s, err)
+		}
+		out = append(out, CanarySector{Miner: maddr, Number: abi.SectorNumber(num)})
+	}
+	return out, nil
+}
+
+// CanaryTask read-checks the canary sectors of each miner with the fault
+// tracker once every proving period, at its start, regardless of the
+// deadlines of the sectors. A storage problem is then reported as soon as
+// it affects a canary, rather than when the deadline of an affected sector
+// fails to be proven. Results are recorded in wdpost_canary_checks. Every
+// node follows the latest results of each miner there, raising an alert
+// while canaries fail and resolving it once they are read successfully
+// again, whichever node ran the check.
+//
+// The check only reads the challenged nodes of a few sectors, it doesn't
+// compute a proof, and unlike the spot check it doesn't yield to WindowPoSt
+// work.
+type CanaryTask struct {
+	api          CanaryAPI
+	db           harmonydb.Interface
+	faultTracker sealer.FaultTracker
+	al           *alerting.Alerting
+
+	canaries map[address.Address][]abi.SectorNumber
+	alerts   map[address.Address]alerting.AlertType
+	// proving period start each miner was last scheduled for
+	scheduled map[address.Address]abi.ChainEpoch
+	// proving period start of the check results the alert of each miner
+	// was last updated from
+	alerted map[address.Address]abi.ChainEpoch
+
+	checkTF promise.Promise[harmonytask.AddTaskFunc]
+}
+
+func NewCanaryTask(pcs *chainsched.ProviderChainSched, api CanaryAPI, db harmonydb.Interface, faultTracker sealer.FaultTracker,
+	al *alerting.Alerting, canaries []CanarySector) (*CanaryTask, error) {
+	t := newCanaryTask(api, db, faultTracker, al, canaries)
+
+	if err := pcs.AddHandler(t.processHeadChange); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func newCanaryTask(api CanaryAPI, db harmonydb.Interface, faultTracker sealer.FaultTracker, al *alerting.Alerting, canaries []CanarySector) *CanaryTask {
+	t := &CanaryTask{
+		api:          api,
+		db:           db,
+		faultTracker: faultTracker,
+		al:           al,
+
+		canaries:  map[address.Address][]abi.SectorNumber{},
+		alerts:    map[address.Address]alerting.AlertType{},
+		scheduled: map[address.Address]abi.ChainEpoch{},
+		alerted:   map[address.Address]abi.ChainEpoch{},
+	}
+	for _, c := range canaries {
+		t.canaries[c.Miner] = append(t.canaries[c.Miner], c.Number)
+		if _, ok := t.alerts[c.Miner]; !ok {
+			t.alerts[c.Miner] = al.AddAlertType("lpwindow", "canary-"+c.Miner.String())
+		}
+	}
+	return t
+}
+
+func (t *CanaryTask) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	if apply == nil {
+		return nil
+	}
+
+	for maddr := range t.canaries {
+		spID, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return xerrors.Errorf("getting miner ID: %w", err)
+		}
+
+		// the check may have run on another node
+		if err := t.updateAlert(ctx, maddr, spID); err != nil {
+			return err
+		}
+
+		di, err := t.api.StateMinerProvingDeadline(ctx, maddr, apply.Key())
+		if err != nil {
+			return xerrors.Errorf("getting proving deadline of %s: %w", maddr, err)
+		}
+		if t.scheduled[maddr] == di.PeriodStart {
+			continue
+		}
+
+		t.checkTF.Val(ctx)(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+			n, err := tx.Exec(`INSERT INTO wdpost_canary_tasks (task_id, sp_id, proving_period_start) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+				id, spID, di.PeriodStart)
+			if err != nil {
+				return false, xerrors.Errorf("inserting canary task: %w", err)
+			}
+
+			// already scheduled by another node
+			return n == 1, nil
+		})
+		t.scheduled[maddr] = di.PeriodStart
+	}
+
+	return nil
+}
+
+func (t *CanaryTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := harmonytask.TaskContext(context.Background(), taskID)
+
+	var spID uint64
+	var pps abi.ChainEpoch
+	err = t.db.QueryRow(ctx, `SELECT sp_id, proving_period_start FROM wdpost_canary_tasks WHERE task_id = $1`, taskID).Scan(&spID, &pps)
+	if err != nil {
+		return false, xerrors.Errorf("getting canary task: %w", err)
+	}
+
+	maddr, err := address.NewIDAddress(spID)
+	if err != nil {
+		return false, err
+	}
+	sectors := t.canaries[maddr]
+	if len(sectors) == 0 {
+		// the canaries were removed from the config since
+		log.Warnw("canary task of a miner without canary sectors", "task", taskID, "miner", maddr)
+		return true, nil
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+	di, err := t.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+	if di.PeriodStart > pps {
+		// the check of the current period is scheduled already
+		log.Infow("skipping canary check of a past proving period", "task", taskID, "miner", maddr, "period", pps)
+		return true, nil
+	}
+
+	start := time.Now()
+	failed, err := t.checkCanaries(ctx, maddr, sectors, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("checking canary sectors: %w", err)
+	}
+	harmonytask.Logw(taskID, "checked canary sectors", "miner", maddr, "sectors", len(sectors), "failed", len(failed), "took", time.Since(start))
+
+	// the results of a check are recorded together, so that nodes never
+	// update their alert from a partial check
+	_, err = t.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		for _, s := range sectors {
+			var errStr *string
+			if reason, bad := failed[s]; bad {
+				errStr = &reason
+			}
+			_, err = tx.Exec(`INSERT INTO wdpost_canary_checks (task_id, sp_id, sector_number, proving_period_start, ok, err)
+				VALUES ($1, $2, $3, $4, $5, $6)`, taskID, spID, s, pps, errStr == nil, errStr)
+			if err != nil {
+				return false, xerrors.Errorf("recording canary check result: %w", err)
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(failed) > 0 {
+		log.Errorw("canary sectors failed to be read", "miner", maddr, "failed", failed)
+	}
+
+	if err := t.updateAlert(ctx, maddr, spID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// updateAlert raises or resolves the alert of a miner from the latest
+// canary check results recorded for it.
+func (t *CanaryTask) updateAlert(ctx context.Context, maddr address.Address, spID uint64) error {
+	var results []struct {
+		Period abi.ChainEpoch `db:"proving_period_start"`
+		Sector uint64         `db:"sector_number"`
+		OK     bool           `db:"ok"`
+	}
+	err := t.db.Select(ctx, &results, `SELECT proving_period_start, sector_number, ok FROM wdpost_canary_checks
+		WHERE sp_id = $1 AND proving_period_start = (SELECT MAX(proving_period_start) FROM wdpost_canary_checks WHERE sp_id = $1)`, spID)
+	if err != nil {
+		return xerrors.Errorf("getting canary check results of %s: %w", maddr, err)
+	}
+	if len(results) == 0 || t.alerted[maddr] == results[0].Period {
+		return nil
+	}
+	pps := results[0].Period
+
+	var bad []uint64
+	for _, r := range results {
+		if !r.OK {
+			bad = append(bad, r.Sector)
+		}
+	}
+	sort.Slice(bad, func(i, j int) bool { return bad[i] < bad[j] })
+
+	at := t.alerts[maddr]
+	if len(bad) > 0 {
+		t.al.Raise(at, map[string]interface{}{
+			"miner":  maddr.String(),
+			"period": pps,
+			"failed": bad,
+		})
+	} else if t.al.IsRaised(at) {
+		t.al.Resolve(at, map[string]interface{}{
+			"miner":   maddr.String(),
+			"period":  pps,
+			"checked": len(results),
+		})
+	}
+	t.alerted[maddr] = pps
+
+	return nil
+}
+
+// checkCanaries returns the canary sectors which can't be proven, with the
+// reason.
+func (t *CanaryTask) checkCanaries(ctx context.Context, maddr address.Address, sectors []abi.SectorNumber, tsk types.TipSetKey) (map[abi.SectorNumber]string, error) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, err
+	}
+
+	nums := make([]uint64, len(sectors))
+	for i, s := range sectors {
+		nums[i] = uint64(s)
+	}
+	bf := bitfield.NewFromSet(nums)
+	infos, err := t.api.StateMinerSectors(ctx, maddr, &bf, tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("getting sector infos: %w", err)
+	}
+
+	failed := map[abi.SectorNumber]string{}
+	for _, s := range sectors {
+		failed[s] = "sector not found on chain"
+	}
+	if len(infos) == 0 {
+		return failed, nil
+	}
+
+	type sealedInfo struct {
+		sealed cid.Cid
+		update bool
+	}
+	sealed := map[abi.SectorNumber]sealedInfo{}
+	refs := make([]storiface.SectorRef, 0, len(infos))
+	for _, info := range infos {
+		delete(failed, info.SectorNumber)
+		sealed[info.SectorNumber] = sealedInfo{sealed: info.SealedCID, update: info.SectorKeyCID != nil}
+		refs = append(refs, storiface.SectorRef{
+			ID:        abi.SectorID{Miner: abi.ActorID(mid), Number: info.SectorNumber},
+			ProofType: info.SealProof,
+		})
+	}
+
+	pp, err := refs[0].ProofType.RegisteredWindowPoStProof()
+	if err != nil {
+		return nil, xerrors.Errorf("getting window PoSt proof type: %w", err)
+	}
+	pp, err = pp.ToV1_1PostProof()
+	if err != nil {
+		return nil, xerrors.Errorf("converting to v1_1 post proof: %w", err)
+	}
+
+	bad, err := t.faultTracker.CheckProvable(ctx, pp, refs, func(ctx context.Context, id abi.SectorID) (cid.Cid, bool, error) {
+		s, ok := sealed[id.Number]
+		if !ok {
+			return cid.Undef, false, xerrors.Errorf("sealed CID not found")
+		}
+		return s.sealed, s.update, nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("checking provable sectors: %w", err)
+	}
+	for id, reason := range bad {
+		failed[id.Number] = reason
+	}
+	return failed, nil
+}
+
+func (t *CanaryTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *CanaryTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Name:        "WdPostCanary",
+		Max:         1,
+		MaxFailures: 3,
+		Cost: resources.Resources{
+			Cpu: 1,
+			Gpu: 0,
+			Ram: 256 << 20,
+		},
+	}
+}
+
+func (t *CanaryTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	t.checkTF.Set(taskFunc)
+}
+
+var _ harmonytask.TaskInterface = &CanaryTask{}
@@ -0,0 +1,177 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type fakeCanaryAPI struct {
+	head        *types.TipSet
+	periodStart abi.ChainEpoch
+	onChain     map[abi.SectorNumber]bool
+}
+
+func (f *fakeCanaryAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return f.head, nil
+}
+
+func (f *fakeCanaryAPI) StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error) {
+	return &dline.Info{PeriodStart: f.periodStart}, nil
+}
+
+func (f *fakeCanaryAPI) StateMinerSectors(ctx context.Context, maddr address.Address, bf *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	var out []*miner.SectorOnChainInfo
+	err := bf.ForEach(func(s uint64) error {
+		if f.onChain[abi.SectorNumber(s)] {
+			out = append(out, &miner.SectorOnChainInfo{
+				SectorNumber: abi.SectorNumber(s),
+				SealProof:    abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+				SealedCID:    cid.Undef,
+			})
+		}
+		return nil
+	})
+	return out, err
+}
+
+type fakeCanaryFaultTracker struct {
+	bad map[abi.SectorNumber]string
+}
+
+func (f *fakeCanaryFaultTracker) CheckProvable(ctx context.Context, pp abi.RegisteredPoStProof, sectors []storiface.SectorRef, rg storiface.RGetter) (map[abi.SectorID]string, error) {
+	out := map[abi.SectorID]string{}
+	for _, s := range sectors {
+		if reason, ok := f.bad[s.ID.Number]; ok {
+			out[s.ID] = reason
+		}
+	}
+	return out, nil
+}
+
+func TestParseCanarySectors(t *testing.T) {
+	c, err := ParseCanarySectors([]string{"f01234:42", "f01234:7"})
+	require.NoError(t, err)
+	require.Len(t, c, 2)
+	require.Equal(t, "f01234:42", c[0].String())
+	require.Equal(t, abi.SectorNumber(7), c[1].Number)
+
+	for _, bad := range []string{"f01234", "f01234:x", "nope:1", "f3vvmn62lofvhjd2ugzca6sof2j2ubwok6cj4xxbfzz4yuxfkgobpihhd2thlanmsh3w2ptld2gqkn2jvlss4a:1"} {
+		_, err := ParseCanarySectors([]string{bad})
+		require.Error(t, err, bad)
+	}
+}
+
+type canaryResult = struct {
+	Period abi.ChainEpoch `db:"proving_period_start"`
+	Sector uint64         `db:"sector_number"`
+	OK     bool           `db:"ok"`
+}
+
+func TestCanaryTask(t *testing.T) {
+	db := harmonydb.NewMock()
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	fapi := &fakeCanaryAPI{
+		head:        mock.TipSet(mock.MkBlock(nil, 1, 1)),
+		periodStart: 100,
+		onChain:     map[abi.SectorNumber]bool{1: true, 2: true},
+	}
+	ft := &fakeCanaryFaultTracker{bad: map[abi.SectorNumber]string{2: "file not found"}}
+	task := newCanaryTask(fapi, db, ft, al, []CanarySector{
+		{Miner: maddr, Number: 1},
+		{Miner: maddr, Number: 2},
+		{Miner: maddr, Number: 3},
+	})
+
+	expectRun := func(id harmonytask.TaskID, pps abi.ChainEpoch, results ...[]any) {
+		db.ExpectQueryRow(`FROM wdpost_canary_tasks`).WithArgs(id).WillReturnRows([]any{uint64(1000), pps})
+		if len(results) == 0 {
+			return
+		}
+
+		var latest []canaryResult
+		for _, r := range results {
+			db.ExpectExec(`INSERT INTO wdpost_canary_checks`).WithArgs(append([]any{id, uint64(1000)}, r...)...)
+			latest = append(latest, canaryResult{Period: r[1].(abi.ChainEpoch), Sector: uint64(r[0].(abi.SectorNumber)), OK: r[2].(bool)})
+		}
+		db.ExpectSelect(`FROM wdpost_canary_checks`).WithArgs(uint64(1000)).WillReturnSelect(latest)
+	}
+
+	// a sector read error and a sector missing on chain both fail the check
+	notFound, readErr := "sector not found on chain", "file not found"
+	expectRun(1, 100,
+		[]any{abi.SectorNumber(1), abi.ChainEpoch(100), true, (*string)(nil)},
+		[]any{abi.SectorNumber(2), abi.ChainEpoch(100), false, &readErr},
+		[]any{abi.SectorNumber(3), abi.ChainEpoch(100), false, &notFound},
+	)
+	done, err := task.Do(1, func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+	require.True(t, al.IsRaised(task.alerts[maddr]))
+
+	// another node reading all canaries successfully in the next period
+	// resolves the alert here when the head changes
+	db.ExpectSelect(`FROM wdpost_canary_checks`).WithArgs(uint64(1000)).WillReturnSelect([]canaryResult{
+		{Period: 100, Sector: 1, OK: true},
+		{Period: 100, Sector: 2, OK: false},
+		{Period: 100, Sector: 3, OK: false},
+	})
+	db.ExpectSelect(`FROM wdpost_canary_checks`).WithArgs(uint64(1000)).WillReturnSelect([]canaryResult{
+		{Period: 200, Sector: 1, OK: true},
+		{Period: 200, Sector: 2, OK: true},
+		{Period: 200, Sector: 3, OK: true},
+	})
+	otherAl := alerting.NewAlertingSystem(journal.NilJournal())
+	other := newCanaryTask(fapi, db, ft, otherAl, []CanarySector{{Miner: maddr, Number: 1}})
+	other.scheduled[maddr] = 100
+	require.NoError(t, other.processHeadChange(context.Background(), nil, fapi.head))
+	require.True(t, otherAl.IsRaised(other.alerts[maddr]))
+	require.NoError(t, other.processHeadChange(context.Background(), nil, fapi.head))
+	require.False(t, otherAl.IsRaised(other.alerts[maddr]))
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// all canaries readable again resolves the alert of the node running
+	// the check
+	ft.bad = nil
+	fapi.onChain[3] = true
+	fapi.periodStart = 200
+	expectRun(2, 200,
+		[]any{abi.SectorNumber(1), abi.ChainEpoch(200), true, (*string)(nil)},
+		[]any{abi.SectorNumber(2), abi.ChainEpoch(200), true, (*string)(nil)},
+		[]any{abi.SectorNumber(3), abi.ChainEpoch(200), true, (*string)(nil)},
+	)
+	done, err = task.Do(2, func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+	require.False(t, al.IsRaised(task.alerts[maddr]))
+
+	// a task of a past proving period completes without checking
+	fapi.periodStart = 300
+	expectRun(3, 200)
+	done, err = task.Do(3, func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+}
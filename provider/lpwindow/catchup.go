@@ -0,0 +1,68 @@
+package lpwindow
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// pendingPartitions returns the indexes of partitions in the given deadline
+// which still need a WindowPoSt. Partitions are skipped when a proof for them
+// has already landed on-chain, or when the cluster already has a task or
+// a computed proof recorded for them in HarmonyDB.
+func (t *WdPostTask) pendingPartitions(ctx context.Context, maddr address.Address, spID uint64, di *dline.Info, count int, tsk types.TipSetKey) ([]uint64, error) {
+	deadlines, err := t.api.StateMinerDeadlines(ctx, maddr, tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("getting deadlines: %w", err)
+	}
+	if di.Index >= uint64(len(deadlines)) {
+		return nil, xerrors.Errorf("deadline %d out of range (%d deadlines)", di.Index, len(deadlines))
+	}
+
+	var known []uint64
+	err = t.db.Select(ctx, &known, `SELECT partition_index FROM wdpost_partition_tasks
+			WHERE sp_id = $1 AND proving_period_start = $2 AND deadline_index = $3
+		UNION
+		SELECT partition FROM wdpost_proofs
+			WHERE sp_id = $1 AND proving_period_start = $2 AND deadline = $3`,
+		spID, di.PeriodStart, di.Index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting scheduled partitions: %w", err)
+	}
+
+	return partitionsNeedingWork(count, deadlines[di.Index].PostSubmissions, known)
+}
+
+// partitionsNeedingWork filters partition indexes [0, count) down to the ones
+// which are neither marked in the on-chain PostSubmissions bitfield nor
+// present in the known (already scheduled or proven) set.
+func partitionsNeedingWork(count int, proven bitfield.BitField, known []uint64) ([]uint64, error) {
+	done := make(map[uint64]struct{}, len(known))
+	for _, p := range known {
+		done[p] = struct{}{}
+	}
+
+	err := proven.ForEach(func(p uint64) error {
+		done[p] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("iterating post submissions: %w", err)
+	}
+
+	var out []uint64
+	for p := uint64(0); p < uint64(count); p++ {
+		if _, ok := done[p]; ok {
+			continue
+		}
+		out = append(out, p)
+	}
+
+	return out, nil
+}
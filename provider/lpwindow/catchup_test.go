@@ -0,0 +1,28 @@
+package lpwindow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-bitfield"
+)
+
+func TestPartitionsNeedingWork(t *testing.T) {
+	// fresh deadline, nothing proven or scheduled yet
+	pending, err := partitionsNeedingWork(4, bitfield.New(), nil)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 1, 2, 3}, pending)
+
+	// node joins mid-window: partitions 0 and 2 already proven on-chain,
+	// partition 3 already has a task / proof in the database
+	proven := bitfield.NewFromSet([]uint64{0, 2})
+	pending, err = partitionsNeedingWork(4, proven, []uint64{3})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1}, pending)
+
+	// everything done
+	pending, err = partitionsNeedingWork(2, bitfield.NewFromSet([]uint64{0}), []uint64{1})
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
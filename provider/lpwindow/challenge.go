@@ -0,0 +1,47 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type ChallengeRandAPI interface {
+	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+}
+
+// challengeRandomness returns the WindowPoSt challenge seed for the deadline,
+// as seen from the given tipset.
+func challengeRandomness(ctx context.Context, api ChallengeRandAPI, maddr address.Address, di *dline.Info, tsk types.TipSetKey) (abi.Randomness, error) {
+	buf := new(bytes.Buffer)
+	if err := maddr.MarshalCBOR(buf); err != nil {
+		return nil, xerrors.Errorf("failed to marshal address to cbor: %w", err)
+	}
+
+	rand, err := api.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), tsk)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to get chain randomness from beacon for window post (deadline=%d): %w", di.Index, err)
+	}
+
+	return rand, nil
+}
+
+// challengeChanged returns whether the challenge seed at the given tipset
+// differs from the one a proof was computed with, which means the chain
+// reorged across the challenge epoch and the proof would be invalid.
+func challengeChanged(ctx context.Context, api ChallengeRandAPI, maddr address.Address, di *dline.Info, computed abi.Randomness, tsk types.TipSetKey) (bool, error) {
+	current, err := challengeRandomness(ctx, api, maddr, di, tsk)
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(current, computed), nil
+}
@@ -0,0 +1,164 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/provider/lprand"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+// forkRandAPI serves beacon randomness depending on which fork the requested
+// tipset belongs to.
+type forkRandAPI struct {
+	rand map[types.TipSetKey]abi.Randomness
+}
+
+func (f *forkRandAPI) StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error) {
+	return f.rand[tsk], nil
+}
+
+func TestChallengeChangedOnReorg(t *testing.T) {
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	di := &dline.Info{Index: 3, Challenge: 100}
+
+	mkKey := func(s string) types.TipSetKey {
+		c, err := abi.CidBuilder.Sum([]byte(s))
+		require.NoError(t, err)
+		return types.NewTipSetKey(c)
+	}
+
+	computedOn := mkKey("fork-a-head")
+	sameFork := mkKey("fork-a-next")
+	reorged := mkKey("fork-b-head")
+
+	api := &forkRandAPI{rand: map[types.TipSetKey]abi.Randomness{
+		computedOn: []byte("rand-a"),
+		sameFork:   []byte("rand-a"),
+		reorged:    []byte("rand-b"),
+	}}
+
+	computed, err := challengeRandomness(ctx, api, maddr, di, computedOn)
+	require.NoError(t, err)
+
+	// chain advanced on the same fork, the proof is still good
+	changed, err := challengeChanged(ctx, api, maddr, di, computed, sameFork)
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// reorg across the challenge epoch, the proof must be recomputed
+	changed, err = challengeChanged(ctx, api, maddr, di, computed, reorged)
+	require.NoError(t, err)
+	require.True(t, changed)
+}
+
+func TestChallengeWithFixedRandomness(t *testing.T) {
+	ctx := context.Background()
+
+	mkKey := func(s string) types.TipSetKey {
+		c, err := abi.CidBuilder.Sum([]byte(s))
+		require.NoError(t, err)
+		return types.NewTipSetKey(c)
+	}
+
+	m1000, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m1001, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	di := &dline.Info{Index: 3, Challenge: 100}
+	src := &lprand.Fixed{Seed: []byte("test")}
+
+	a, err := challengeRandomness(ctx, src, m1000, di, mkKey("fork-a"))
+	require.NoError(t, err)
+
+	// the challenge doesn't depend on the chain, a reorg doesn't change it
+	changed, err := challengeChanged(ctx, src, m1000, di, a, mkKey("fork-b"))
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	// but each miner still gets its own challenge
+	b, err := challengeRandomness(ctx, src, m1001, di, mkKey("fork-a"))
+	require.NoError(t, err)
+	require.NotEqual(t, a, b)
+}
+
+// reorgSubmitAPI serves the head and network version of the submit task.
+type reorgSubmitAPI struct {
+	WdPoStSubmitTaskApi
+	head *types.TipSet
+}
+
+func (a *reorgSubmitAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return a.head, nil
+}
+
+func (a *reorgSubmitAPI) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	return network.Version21, nil
+}
+
+// forkRandSource is a forkRandAPI serving as the randomness source of tasks.
+type forkRandSource struct {
+	lprand.Source
+	*forkRandAPI
+}
+
+func (f *forkRandSource) StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error) {
+	return f.forkRandAPI.StateGetRandomnessFromBeacon(ctx, personalization, randEpoch, entropy, tsk)
+}
+
+func TestSubmitDiscardsProofOnReorg(t *testing.T) {
+	const taskID = 42
+	pps := abi.ChainEpoch(1000)
+	dl := wdpost.NewDeadlineInfo(pps, 3, pps)
+
+	b := mock.MkBlock(nil, 1, 1)
+	b.Height = dl.Open + 10
+	head := mock.TipSet(b)
+
+	params := miner.SubmitWindowedPoStParams{
+		Deadline:   3,
+		Partitions: []miner.PoStPartition{{Index: 0, Skipped: bitfield.New()}},
+		Proofs:     []proof.PoStProof{{PoStProof: abi.RegisteredPoStProof_StackedDrgWindow32GiBV1_1, ProofBytes: []byte("proof")}},
+	}
+	var pbuf bytes.Buffer
+	require.NoError(t, params.MarshalCBOR(&pbuf))
+
+	db := harmonydb.NewMock()
+	db.ExpectQueryRow(`FROM wdpost_proofs WHERE submit_task_id = $1`).WithArgs(taskID).
+		WillReturnRows([]any{uint64(1000), pps, uint64(3), uint64(0), dl.Open, dl.Close, pbuf.Bytes(), uint64(taskID), []byte("rand-a")})
+	// the proof is dropped, with its partition task so that it's computed again
+	db.ExpectExec(`DELETE FROM wdpost_proofs`).WithArgs(uint64(1000), pps, uint64(3), uint64(0))
+	db.ExpectExec(`DELETE FROM wdpost_partition_tasks`).WithArgs(uint64(1000), pps, uint64(3), uint64(0))
+
+	// the challenge seen from the new head differs from the one proven
+	rand := &forkRandSource{forkRandAPI: &forkRandAPI{rand: map[types.TipSetKey]abi.Randomness{
+		head.Key(): []byte("rand-b"),
+	}}}
+	api := &reorgSubmitAPI{head: head}
+	task := &WdPostSubmitTask{db: db, api: api, rand: rand, partLimit: newPartitionLimiter(api)}
+
+	done, err := task.Do(taskID, func() bool { return true })
+	require.NoError(t, err)
+	require.True(t, done)
+	require.NoError(t, db.ExpectationsWereMet())
+}
@@ -0,0 +1,228 @@
+package lpwindow
+
+import (
+	"context"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type ProvingLoadAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+}
+
+// MinerSchedule is what the proving load of a miner is worked out from: its
+// current deadline, safety margin, and the number of partitions with live
+// sectors in each deadline.
+type MinerSchedule struct {
+	Miner      address.Address
+	Current    *dline.Info
+	Margin     abi.ChainEpoch
+	Partitions []int // by deadline index
+}
+
+// MinerSchedules reads the proving schedules of the miners from chain.
+func MinerSchedules(ctx context.Context, api ProvingLoadAPI, actors []dtypes.MinerAddress, margin SafetyMarginFunc) ([]MinerSchedule, error) {
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	out := make([]MinerSchedule, 0, len(actors))
+	for _, act := range actors {
+		maddr := address.Address(act)
+
+		di, err := api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting proving deadline of %s: %w", maddr, err)
+		}
+
+		ms := MinerSchedule{
+			Miner:      maddr,
+			Current:    di,
+			Partitions: make([]int, miner.WPoStPeriodDeadlines),
+		}
+		if margin != nil {
+			ms.Margin = margin(maddr)
+		}
+
+		for dl := range ms.Partitions {
+			parts, err := api.StateMinerPartitions(ctx, maddr, uint64(dl), head.Key())
+			if err != nil {
+				return nil, xerrors.Errorf("getting partitions of deadline %d of %s: %w", dl, maddr, err)
+			}
+			for _, p := range parts {
+				n, err := p.LiveSectors.Count()
+				if err != nil {
+					return nil, xerrors.Errorf("counting live sectors: %w", err)
+				}
+				if n > 0 {
+					ms.Partitions[dl]++
+				}
+			}
+		}
+
+		out = append(out, ms)
+	}
+
+	return out, nil
+}
+
+// ProvingDeadline is a deadline in its proving window.
+type ProvingDeadline struct {
+	Miner      address.Address
+	Deadline   uint64
+	Partitions int
+}
+
+// LoadSegment is a span of epochs, From inclusive and To exclusive, through
+// which the same deadlines are in their proving windows.
+type LoadSegment struct {
+	From, To  abi.ChainEpoch
+	Deadlines []ProvingDeadline
+}
+
+func (s LoadSegment) Partitions() int {
+	var n int
+	for _, d := range s.Deadlines {
+		n += d.Partitions
+	}
+	return n
+}
+
+// Miners returns the number of distinct miners proving in the segment.
+func (s LoadSegment) Miners() int {
+	seen := map[address.Address]struct{}{}
+	for _, d := range s.Deadlines {
+		seen[d.Miner] = struct{}{}
+	}
+	return len(seen)
+}
+
+// ProvingLoad is the number of deadlines, and their partitions, which are in
+// their proving windows at once, over a span of epochs. A deadline is counted
+// from its compute epoch, accounting for the safety margin, until it closes,
+// so the load is an upper bound: deadlines are usually proven well before
+// they close.
+type ProvingLoad struct {
+	Segments []LoadSegment
+
+	PeakDeadlines  int
+	PeakPartitions int
+	// PeakAt is the first epoch with PeakPartitions
+	PeakAt abi.ChainEpoch
+}
+
+// Collisions returns the segments in which deadlines of more than one miner
+// are in their proving windows.
+func (l ProvingLoad) Collisions() []LoadSegment {
+	var out []LoadSegment
+	for _, s := range l.Segments {
+		if s.Miners() > 1 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+type loadWindow struct {
+	from, to abi.ChainEpoch
+	dl       ProvingDeadline
+}
+
+// ComputeProvingLoad works out the proving load of the miners in the epochs
+// from start for the given number of epochs, one proving period covering all
+// deadlines. Deadlines without live partitions are left out.
+func ComputeProvingLoad(scheds []MinerSchedule, start, epochs abi.ChainEpoch) ProvingLoad {
+	end := start + epochs
+
+	var windows []loadWindow
+	bounds := map[abi.ChainEpoch]struct{}{start: {}, end: {}}
+	for _, ms := range scheds {
+		if ms.Current == nil {
+			continue
+		}
+
+		// with a positive margin the window of the previous deadline may
+		// still be open, and with a negative one the current window may not
+		// have started
+		for di := prevDeadline(ms.Current); ; di = wdpost.NextDeadline(di) {
+			w := effectiveWindow(di, ms.Margin)
+			if w.ComputeAt >= end {
+				break
+			}
+
+			from, to := w.ComputeAt, w.SubmitBy
+			if from < start {
+				from = start
+			}
+			if to > end {
+				to = end
+			}
+			if from >= to || int(di.Index) >= len(ms.Partitions) || ms.Partitions[di.Index] == 0 {
+				continue
+			}
+
+			windows = append(windows, loadWindow{
+				from: from,
+				to:   to,
+				dl:   ProvingDeadline{Miner: ms.Miner, Deadline: di.Index, Partitions: ms.Partitions[di.Index]},
+			})
+			bounds[from] = struct{}{}
+			bounds[to] = struct{}{}
+		}
+	}
+
+	epochsAt := make([]abi.ChainEpoch, 0, len(bounds))
+	for e := range bounds {
+		epochsAt = append(epochsAt, e)
+	}
+	sort.Slice(epochsAt, func(i, j int) bool { return epochsAt[i] < epochsAt[j] })
+
+	var load ProvingLoad
+	for i := 0; i+1 < len(epochsAt); i++ {
+		seg := LoadSegment{From: epochsAt[i], To: epochsAt[i+1]}
+		for _, w := range windows {
+			if w.from <= seg.From && seg.From < w.to {
+				seg.Deadlines = append(seg.Deadlines, w.dl)
+			}
+		}
+		sort.Slice(seg.Deadlines, func(i, j int) bool {
+			a, b := seg.Deadlines[i], seg.Deadlines[j]
+			if a.Miner != b.Miner {
+				return a.Miner.String() < b.Miner.String()
+			}
+			return a.Deadline < b.Deadline
+		})
+
+		if n := len(seg.Deadlines); n > load.PeakDeadlines {
+			load.PeakDeadlines = n
+		}
+		if p := seg.Partitions(); p > load.PeakPartitions {
+			load.PeakPartitions = p
+			load.PeakAt = seg.From
+		}
+		load.Segments = append(load.Segments, seg)
+	}
+
+	return load
+}
+
+func prevDeadline(di *dline.Info) *dline.Info {
+	if di.Index == 0 {
+		return wdpost.NewDeadlineInfo(di.PeriodStart-miner.WPoStProvingPeriod(), miner.WPoStPeriodDeadlines-1, di.CurrentEpoch)
+	}
+	return wdpost.NewDeadlineInfo(di.PeriodStart, di.Index-1, di.CurrentEpoch)
+}
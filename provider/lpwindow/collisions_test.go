@@ -0,0 +1,72 @@
+package lpwindow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+func TestComputeProvingLoad(t *testing.T) {
+	m1, _ := address.NewIDAddress(1000)
+	m2, _ := address.NewIDAddress(1001)
+	m3, _ := address.NewIDAddress(1002)
+
+	window := miner.WPoStChallengeWindow()
+	period := miner.WPoStProvingPeriod()
+	parts := func(dl uint64, n int) []int {
+		p := make([]int, miner.WPoStPeriodDeadlines)
+		p[dl] = n
+		return p
+	}
+
+	start := period
+	scheds := []MinerSchedule{
+		// same deadline, same period start
+		{Miner: m1, Current: wdpost.NewDeadlineInfo(period, 0, start), Partitions: parts(2, 3)},
+		{Miner: m2, Current: wdpost.NewDeadlineInfo(period, 0, start), Partitions: parts(2, 1)},
+		// offset by half a deadline
+		{Miner: m3, Current: wdpost.NewDeadlineInfo(period+window/2, 0, start), Partitions: parts(5, 2)},
+	}
+
+	load := ComputeProvingLoad(scheds, start, period)
+	require.Equal(t, 2, load.PeakDeadlines)
+	require.Equal(t, 4, load.PeakPartitions)
+
+	dl2 := wdpost.NewDeadlineInfo(period, 2, start)
+	require.Equal(t, dl2.Open, load.PeakAt)
+
+	coll := load.Collisions()
+	require.Len(t, coll, 1)
+	require.Equal(t, dl2.Open, coll[0].From)
+	require.Equal(t, dl2.Close, coll[0].To)
+	require.Equal(t, []ProvingDeadline{{Miner: m1, Deadline: 2, Partitions: 3}, {Miner: m2, Deadline: 2, Partitions: 1}}, coll[0].Deadlines)
+
+	// segments cover the span without gaps
+	require.Equal(t, start, load.Segments[0].From)
+	require.Equal(t, start+period, load.Segments[len(load.Segments)-1].To)
+	for i := 1; i < len(load.Segments); i++ {
+		require.Equal(t, load.Segments[i-1].To, load.Segments[i].From)
+	}
+
+	// a deadline of m3 opening 10 epochs after deadline 2 of the others
+	// closes only collides with a safety margin
+	scheds[2].Current = wdpost.NewDeadlineInfo(period+10, 0, start)
+	scheds[2].Partitions = parts(3, 2)
+	load = ComputeProvingLoad(scheds, start, period)
+	require.Equal(t, 2, load.PeakDeadlines)
+	require.Len(t, load.Collisions(), 1)
+
+	scheds[2].Margin = 15
+	load = ComputeProvingLoad(scheds, start, period)
+	require.Equal(t, 3, load.PeakDeadlines)
+	require.Equal(t, 6, load.PeakPartitions)
+	coll = load.Collisions()
+	require.Len(t, coll, 2)
+	require.Equal(t, dl2.Close-5, coll[1].From)
+	require.Equal(t, dl2.Close, coll[1].To)
+}
@@ -31,6 +31,13 @@ import (
 
 const disablePreChecks = false // todo config
 
+// ErrNothingToProve is returned by DoPartition when the partition has no
+// sectors to prove, as it has no live sectors, e.g. in deadlines of newly
+// onboarded miners or after all of them were terminated, or as all of them
+// are faulty without a declared recovery. A partition without a proof
+// doesn't lose anything: its sectors are already faulty or gone.
+var ErrNothingToProve = xerrors.New("nothing to prove")
+
 func (t *WdPostTask) DoPartition(ctx context.Context, ts *types.TipSet, maddr address.Address, di *dline.Info, partIdx uint64) (out *miner2.SubmitWindowedPoStParams, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -49,7 +56,7 @@ func (t *WdPostTask) DoPartition(ctx context.Context, ts *types.TipSet, maddr ad
 		return nil, xerrors.Errorf("getting current head: %w", err)
 	}
 
-	rand, err := t.api.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), headTs.Key())
+	rand, err := t.rand.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), headTs.Key())
 	if err != nil {
 		return nil, xerrors.Errorf("failed to get chain randomness from beacon for window post (ts=%d; deadline=%d): %w", ts.Height(), di, err)
 	}
@@ -89,6 +96,14 @@ func (t *WdPostTask) DoPartition(ctx context.Context, ts *types.TipSet, maddr ad
 			return nil, xerrors.Errorf("adding recoveries to set of sectors to prove: %w", err)
 		}
 
+		proveCount, err := toProve.Count()
+		if err != nil {
+			return nil, xerrors.Errorf("counting sectors to prove: %w", err)
+		}
+		if proveCount == 0 {
+			return nil, xerrors.Errorf("partition %d of deadline %d: %w", partIdx, di.Index, ErrNothingToProve)
+		}
+
 		good, err := toProve.Copy()
 		if err != nil {
 			return nil, xerrors.Errorf("copy toProve: %w", err)
@@ -177,7 +192,7 @@ func (t *WdPostTask) DoPartition(ctx context.Context, ts *types.TipSet, maddr ad
 				return nil, xerrors.Errorf("getting current head: %w", err)
 			}
 
-			checkRand, err := t.api.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), headTs.Key())
+			checkRand, err := t.rand.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), headTs.Key())
 			if err != nil {
 				return nil, xerrors.Errorf("failed to get chain randomness from beacon for window post (ts=%d; deadline=%d): %w", ts.Height(), di, err)
 			}
@@ -197,19 +212,21 @@ func (t *WdPostTask) DoPartition(ctx context.Context, ts *types.TipSet, maddr ad
 					SealedCID:    xsi.SealedCID,
 				}
 			}
-			if correct, err := t.verifier.VerifyWindowPoSt(ctx, proof.WindowPoStVerifyInfo{
+			correct, err := t.verifier.VerifyWindowPoSt(ctx, proof.WindowPoStVerifyInfo{
 				Randomness:        abi.PoStRandomness(checkRand),
 				Proofs:            postOut,
 				ChallengedSectors: sinfos,
 				Prover:            abi.ActorID(mid),
-			}); err != nil {
-				/*log.Errorw("window post verification failed", "post", postOut, "error", err)
-				time.Sleep(5 * time.Second)
-				continue todo retry loop */
-			} else if !correct {
-				_ = correct
-				/*log.Errorw("generated incorrect window post proof", "post", postOut, "error", err)
-				continue todo retry loop*/
+			})
+			if err == nil && !correct {
+				err = xerrors.Errorf("proof is invalid")
+			}
+			if err != nil {
+				if err := t.verifyFailure.failed(ctx, maddr, di, partIdx, err); err != nil {
+					return nil, err
+				}
+			} else {
+				t.verifyFailure.verified(ctx, maddr, di, partIdx)
 			}
 
 			// Proof generation successful, stop retrying
@@ -231,14 +248,22 @@ type CheckSectorsAPI interface {
 
 func checkSectors(ctx context.Context, api CheckSectorsAPI, ft sealer.FaultTracker,
 	maddr address.Address, check bitfield.BitField, tsk types.TipSetKey) (bitfield.BitField, error) {
+	good, _, err := checkSectorsWithReasons(ctx, api, ft, maddr, check, tsk)
+	return good, err
+}
+
+// checkSectorsWithReasons is checkSectors also returning why each of the
+// sectors which were checked on storage isn't provable.
+func checkSectorsWithReasons(ctx context.Context, api CheckSectorsAPI, ft sealer.FaultTracker,
+	maddr address.Address, check bitfield.BitField, tsk types.TipSetKey) (bitfield.BitField, map[abi.SectorNumber]string, error) {
 	mid, err := address.IDFromAddress(maddr)
 	if err != nil {
-		return bitfield.BitField{}, xerrors.Errorf("failed to convert to ID addr: %w", err)
+		return bitfield.BitField{}, nil, xerrors.Errorf("failed to convert to ID addr: %w", err)
 	}
 
 	sectorInfos, err := api.StateMinerSectors(ctx, maddr, &check, tsk)
 	if err != nil {
-		return bitfield.BitField{}, xerrors.Errorf("failed to get sector infos: %w", err)
+		return bitfield.BitField{}, nil, xerrors.Errorf("failed to get sector infos: %w", err)
 	}
 
 	type checkSector struct {
@@ -263,16 +288,16 @@ func checkSectors(ctx context.Context, api CheckSectorsAPI, ft sealer.FaultTrack
 	}
 
 	if len(tocheck) == 0 {
-		return bitfield.BitField{}, nil
+		return bitfield.BitField{}, nil, nil
 	}
 
 	pp, err := tocheck[0].ProofType.RegisteredWindowPoStProof()
 	if err != nil {
-		return bitfield.BitField{}, xerrors.Errorf("failed to get window PoSt proof: %w", err)
+		return bitfield.BitField{}, nil, xerrors.Errorf("failed to get window PoSt proof: %w", err)
 	}
 	pp, err = pp.ToV1_1PostProof()
 	if err != nil {
-		return bitfield.BitField{}, xerrors.Errorf("failed to convert to v1_1 post proof: %w", err)
+		return bitfield.BitField{}, nil, xerrors.Errorf("failed to convert to v1_1 post proof: %w", err)
 	}
 
 	bad, err := ft.CheckProvable(ctx, pp, tocheck, func(ctx context.Context, id abi.SectorID) (cid.Cid, bool, error) {
@@ -283,10 +308,12 @@ func checkSectors(ctx context.Context, api CheckSectorsAPI, ft sealer.FaultTrack
 		return s.sealed, s.update, nil
 	})
 	if err != nil {
-		return bitfield.BitField{}, xerrors.Errorf("checking provable sectors: %w", err)
+		return bitfield.BitField{}, nil, xerrors.Errorf("checking provable sectors: %w", err)
 	}
-	for id := range bad {
+	reasons := make(map[abi.SectorNumber]string, len(bad))
+	for id, reason := range bad {
 		delete(sectors, id.Number)
+		reasons[id.Number] = reason
 	}
 
 	log.Warnw("Checked sectors", "checked", len(tocheck), "good", len(sectors))
@@ -296,7 +323,7 @@ func checkSectors(ctx context.Context, api CheckSectorsAPI, ft sealer.FaultTrack
 		sbf.Set(uint64(s))
 	}
 
-	return sbf, nil
+	return sbf, reasons, nil
 }
 
 func (t *WdPostTask) sectorsForProof(ctx context.Context, maddr address.Address, goodSectors, allSectors bitfield.BitField, ts *types.TipSet) ([]proof7.ExtendedSectorInfo, error) {
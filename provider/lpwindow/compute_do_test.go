@@ -0,0 +1,75 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/provider/lprand"
+)
+
+// partitionsAPI serves the partitions of a deadline, the calls made to prove
+// sectors aren't implemented
+type partitionsAPI struct {
+	WDPoStAPI
+	head  *types.TipSet
+	parts []api.Partition
+}
+
+func (p *partitionsAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return p.head, nil
+}
+
+func (p *partitionsAPI) StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error) {
+	return p.parts, nil
+}
+
+type fixedRand struct {
+	lprand.Source
+}
+
+func (fixedRand) StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error) {
+	return make(abi.Randomness, 32), nil
+}
+
+func TestDoPartitionNothingToProve(t *testing.T) {
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	ts := mock.TipSet(mock.MkBlock(nil, 1, 0))
+	di := &dline.Info{Index: 5, Challenge: 100}
+
+	bf := func(sectors ...uint64) bitfield.BitField {
+		return bitfield.NewFromSet(sectors)
+	}
+
+	for name, part := range map[string]api.Partition{
+		// a deadline of a new miner with its first partition not filled yet
+		"no sectors": {AllSectors: bf(), LiveSectors: bf(), FaultySectors: bf(), RecoveringSectors: bf(), ActiveSectors: bf()},
+		"terminated": {AllSectors: bf(1, 2), LiveSectors: bf(), FaultySectors: bf(), RecoveringSectors: bf(), ActiveSectors: bf()},
+		"all faulty": {AllSectors: bf(1, 2), LiveSectors: bf(1, 2), FaultySectors: bf(1, 2), RecoveringSectors: bf(), ActiveSectors: bf()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// no fault tracker or prover, nothing may be checked or proven
+			w := &WdPostTask{
+				api:  &partitionsAPI{head: ts, parts: []api.Partition{part}},
+				rand: fixedRand{},
+			}
+
+			out, err := w.DoPartition(ctx, ts, maddr, di, 0)
+			require.ErrorIs(t, err, ErrNothingToProve)
+			require.Nil(t, out)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package lpwindow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+// ComputeNow schedules WindowPoSt compute for the partitions of a deadline in
+// the current proving period right away, ignoring the regular compute window.
+// The deadline challenge must already be on chain. Unless submit is set the
+// tasks are test tasks, their proofs are written to harmony_test and never
+// reach the submit task.
+//
+// To avoid duplicate work, nothing is scheduled when the cluster already has
+// compute tasks for the deadline. When submitting, partitions which were
+// already proven are skipped.
+func (t *WdPostTask) ComputeNow(ctx context.Context, maddr address.Address, dlIdx uint64, submit bool) (api.WdPoStCompute, error) {
+	if dlIdx >= miner.WPoStPeriodDeadlines {
+		return api.WdPoStCompute{}, xerrors.Errorf("deadline %d out of range (%d deadlines)", dlIdx, miner.WPoStPeriodDeadlines)
+	}
+
+	aid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cur, err := t.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+	if !cur.PeriodStarted() {
+		return api.WdPoStCompute{}, xerrors.Errorf("miner %s proving period hasn't started yet", maddr)
+	}
+
+	di := wdpost.NewDeadlineInfo(cur.PeriodStart, dlIdx, head.Height())
+	if di.Challenge >= head.Height() {
+		return api.WdPoStCompute{}, xerrors.Errorf("deadline %d challenge at epoch %d isn't on chain yet (head %d)", dlIdx, di.Challenge, head.Height())
+	}
+	if submit && di.HasElapsed() {
+		return api.WdPoStCompute{}, xerrors.Errorf("deadline %d closed at epoch %d, proofs for it can't be submitted", dlIdx, di.Close)
+	}
+
+	// rows of tasks which are gone, e.g. which failed too many times, don't
+	// count as in progress
+	var scheduled int
+	err = t.db.QueryRow(ctx, `SELECT COUNT(*) FROM wdpost_partition_tasks w
+			JOIN harmony_task h ON h.id = w.task_id
+			WHERE w.sp_id = $1 AND w.proving_period_start = $2 AND w.deadline_index = $3`,
+		aid, di.PeriodStart, di.Index).Scan(&scheduled)
+	if err != nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("checking scheduled tasks: %w", err)
+	}
+	if scheduled > 0 {
+		return api.WdPoStCompute{}, xerrors.Errorf("deadline %d already has %d compute tasks in progress", dlIdx, scheduled)
+	}
+
+	partitions, err := t.api.StateMinerPartitions(ctx, maddr, di.Index, head.Key())
+	if err != nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("getting partitions: %w", err)
+	}
+	if len(partitions) == 0 {
+		return api.WdPoStCompute{}, xerrors.Errorf("deadline %d has no partitions", dlIdx)
+	}
+
+	var todo []uint64
+	if submit {
+		todo, err = t.pendingPartitions(ctx, maddr, aid, di, len(partitions), head.Key())
+		if err != nil {
+			return api.WdPoStCompute{}, xerrors.Errorf("checking pending partitions: %w", err)
+		}
+		if len(todo) == 0 {
+			return api.WdPoStCompute{}, xerrors.Errorf("all partitions in deadline %d were already proven", dlIdx)
+		}
+	} else {
+		for pidx := range partitions {
+			todo = append(todo, uint64(pidx))
+		}
+	}
+
+	tf := t.windowPoStTF.Val(ctx)
+	if tf == nil {
+		return api.WdPoStCompute{}, xerrors.Errorf("no task func")
+	}
+
+	out := api.WdPoStCompute{
+		Miner:       maddr,
+		Deadline:    di.Index,
+		PeriodStart: di.PeriodStart,
+		Submit:      submit,
+	}
+
+	for _, pidx := range todo {
+		tid := wdTaskIdentity{
+			SpID:               aid,
+			ProvingPeriodStart: di.PeriodStart,
+			DeadlineIndex:      di.Index,
+			PartitionIndex:     pidx,
+		}
+
+		locs := t.partitionAffinity(ctx, aid, partitions[pidx])
+
+		var added bool
+		var taskID harmonytask.TaskID
+		tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+			// a leftover row of a task which is gone would conflict with the new one
+			_, err := tx.Exec(`DELETE FROM wdpost_partition_tasks
+				WHERE sp_id = $1 AND proving_period_start = $2 AND deadline_index = $3 AND partition_index = $4
+				AND task_id NOT IN (SELECT id FROM harmony_task)`,
+				tid.SpID, tid.ProvingPeriodStart, tid.DeadlineIndex, tid.PartitionIndex)
+			if err != nil {
+				return false, xerrors.Errorf("deleting leftover partition task: %w", err)
+			}
+			if _, err := t.addTaskToDB(id, tid, locs, tx); err != nil {
+				return false, err
+			}
+			if !submit {
+				if _, err := tx.Exec(`INSERT INTO harmony_test (task_id) VALUES ($1)`, id); err != nil {
+					return false, xerrors.Errorf("inserting into harmony_test: %w", err)
+				}
+			}
+			added, taskID = true, id
+			return true, nil
+		})
+		if !added {
+			// raced with the regular schedule (or another call), the partition is being worked on
+			log.Warnw("partition compute wasn't scheduled", "miner", maddr, "deadline", di.Index, "partition", pidx)
+			continue
+		}
+
+		out.Tasks = append(out.Tasks, api.WdPoStComputeTask{
+			Partition: pidx,
+			TaskID:    int64(taskID),
+			State:     "queued",
+		})
+	}
+
+	log.Infow("scheduled manual WindowPoSt compute", "miner", maddr, "deadline", di.Index, "periodStart", di.PeriodStart,
+		"submit", submit, "tasks", len(out.Tasks))
+
+	return out, nil
+}
+
+// ComputeStatus refreshes the task states of a handle returned by ComputeNow.
+func (t *WdPostTask) ComputeStatus(ctx context.Context, c api.WdPoStCompute) (api.WdPoStCompute, error) {
+	tasks := make([]api.WdPoStComputeTask, 0, len(c.Tasks))
+	for _, task := range c.Tasks {
+		st, err := t.computeTaskStatus(ctx, task, c.Submit)
+		if err != nil {
+			return api.WdPoStCompute{}, xerrors.Errorf("getting status of task %d: %w", task.TaskID, err)
+		}
+		tasks = append(tasks, st)
+	}
+
+	c.Tasks = tasks
+	return c, nil
+}
+
+func (t *WdPostTask) computeTaskStatus(ctx context.Context, task api.WdPoStComputeTask, submit bool) (api.WdPoStComputeTask, error) {
+	task.Error = ""
+
+	var owner sql.NullInt64
+	err := t.db.QueryRow(ctx, `SELECT owner_id FROM harmony_task WHERE id = $1`, task.TaskID).Scan(&owner)
+	switch {
+	case err == nil:
+		task.State = "queued"
+		if owner.Valid {
+			task.State = "running"
+		}
+		return task, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		return task, xerrors.Errorf("getting task: %w", err)
+	}
+
+	var result bool
+	var taskErr sql.NullString
+	err = t.db.QueryRow(ctx, `SELECT result, err FROM harmony_task_history
+			WHERE task_id = $1 ORDER BY work_end DESC LIMIT 1`, task.TaskID).Scan(&result, &taskErr)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		task.State = "unknown"
+		return task, nil
+	case err != nil:
+		return task, xerrors.Errorf("getting task history: %w", err)
+	}
+
+	if !result {
+		task.State = "failed"
+		task.Error = taskErr.String
+		return task, nil
+	}
+	task.State = "done"
+
+	if !submit {
+		var res sql.NullString
+		err = t.db.QueryRow(ctx, `SELECT result FROM harmony_test WHERE task_id = $1`, task.TaskID).Scan(&res)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return task, xerrors.Errorf("getting test result: %w", err)
+		}
+		task.Result = res.String
+	}
+
+	return task, nil
+}
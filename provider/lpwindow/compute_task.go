@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/samber/lo"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
@@ -22,13 +26,18 @@ import (
 	"github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
 	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 	"github.com/filecoin-project/lotus/lib/harmony/taskhelp"
 	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
 	"github.com/filecoin-project/lotus/provider/chainsched"
+	"github.com/filecoin-project/lotus/provider/lprand"
+	"github.com/filecoin-project/lotus/storage/paths"
 	"github.com/filecoin-project/lotus/storage/sealer"
 	"github.com/filecoin-project/lotus/storage/sealer/sealtasks"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
@@ -51,6 +60,7 @@ type WDPoStAPI interface {
 	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
 	ChainGetTipSetAfterHeight(context.Context, abi.ChainEpoch, types.TipSetKey) (*types.TipSet, error)
 	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+	StateMinerDeadlines(context.Context, address.Address, types.TipSetKey) ([]api.Deadline, error)
 	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
 	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
 	StateMinerSectors(context.Context, address.Address, *bitfield.BitField, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
@@ -67,11 +77,29 @@ type WdPostTask struct {
 	faultTracker sealer.FaultTracker
 	prover       ProverPoSt
 	verifier     storiface.Verifier
+	rand         lprand.Source
 
 	windowPoStTF promise.Promise[harmonytask.AddTaskFunc]
 
 	actors []dtypes.MinerAddress
 	max    int
+	// WdPost tasks running at once across the cluster, see
+	// harmonytask.TaskTypeDetails.ClusterMax
+	clusterMax int
+	margin     SafetyMarginFunc
+	// most sector file fetches a single compute task runs at once, see
+	// paths.WithTaskFetchLimit
+	maxFetches int
+
+	// nil when partitions don't prefer co-located nodes
+	affinity *StorageAffinity
+
+	pauses *deadlinePauses
+
+	verifyFailure *verifyFailureHandler
+
+	// open epoch of the last deadline the effective window was logged for, per miner
+	loggedWindows map[uint64]abi.ChainEpoch
 }
 
 type wdTaskIdentity struct {
@@ -86,10 +114,18 @@ func NewWdPostTask(db *harmonydb.DB,
 	faultTracker sealer.FaultTracker,
 	prover ProverPoSt,
 	verifier storiface.Verifier,
+	rand lprand.Source,
 
 	pcs *chainsched.ProviderChainSched,
 	actors []dtypes.MinerAddress,
 	max int,
+	clusterMax int,
+	maxFetches int,
+	margin SafetyMarginFunc,
+	affinity *StorageAffinity,
+	verifyFailure VerifyFailurePolicy,
+	j journal.Journal,
+	al *alerting.Alerting,
 ) (*WdPostTask, error) {
 	t := &WdPostTask{
 		db:  db,
@@ -98,9 +134,20 @@ func NewWdPostTask(db *harmonydb.DB,
 		faultTracker: faultTracker,
 		prover:       prover,
 		verifier:     verifier,
+		rand:         rand,
+
+		actors:     actors,
+		max:        max,
+		clusterMax: clusterMax,
+		margin:     margin,
+		maxFetches: maxFetches,
 
-		actors: actors,
-		max:    max,
+		affinity: affinity,
+		pauses:   newDeadlinePauses(db, al),
+
+		verifyFailure: newVerifyFailureHandler(verifyFailure, db, j, al),
+
+		loggedWindows: map[uint64]abi.ChainEpoch{},
 	}
 
 	if err := pcs.AddHandler(t.processHeadChange); err != nil {
@@ -126,7 +173,13 @@ func (t *WdPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		return false, err
 	}
 
-	head, err := t.api.ChainHead(context.Background())
+	ctx, span := startDeadlineSpan(taskID, "WdPostTask.Do", spID, abi.ChainEpoch(pps), dlIdx, partIdx)
+	defer func() {
+		endSpan(span, err)
+	}()
+	ctx = paths.WithTaskFetchLimit(ctx, t.maxFetches)
+
+	head, err := t.api.ChainHead(ctx)
 	if err != nil {
 		log.Errorf("WdPostTask.Do() failed to get chain head: %v", err)
 		return false, err
@@ -145,17 +198,44 @@ func (t *WdPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 		return false, err
 	}
 
-	ts, err := t.api.ChainGetTipSetAfterHeight(context.Background(), deadline.Challenge, head.Key())
+	window := effectiveWindow(deadline, t.margin(maddr))
+
+	t.recordLocality(ctx, maddr, taskID)
+
+	ts, err := t.api.ChainGetTipSetAfterHeight(ctx, deadline.Challenge, head.Key())
 	if err != nil {
 		log.Errorf("WdPostTask.Do() failed to ChainGetTipSetAfterHeight: %v", err)
 		return false, err
 	}
 
-	postOut, err := t.DoPartition(context.Background(), ts, maddr, deadline, partIdx)
+	// recorded with the proof, so that the submit task can detect reorgs across the challenge epoch
+	challengeRand, err := challengeRandomness(ctx, t.rand, maddr, deadline, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("getting challenge randomness: %w", err)
+	}
+
+	harmonytask.Logw(taskID, "proving partition", "miner", maddr, "deadline", dlIdx, "partition", partIdx,
+		"challenge", deadline.Challenge, "submitBy", window.SubmitBy, "height", head.Height())
+	proveStart := time.Now()
+
+	postOut, err := t.DoPartition(ctx, ts, maddr, deadline, partIdx)
+	if errors.Is(err, ErrNothingToProve) {
+		// done without storing a proof, the submit task only sends stored proofs
+		log.Infow("WindowPoSt partition has no sectors to prove, skipping it", "task", taskID, "miner", maddr,
+			"deadline", dlIdx, "partition", partIdx, "periodStart", pps)
+		harmonytask.Logw(taskID, "nothing to prove", "reason", err.Error())
+		return true, nil
+	}
+	if errors.Is(err, ErrProofHeld) {
+		// done without storing a proof, the partition waits for the operator
+		harmonytask.Logw(taskID, "proof held", "reason", err.Error())
+		return true, nil
+	}
 	if err != nil {
 		log.Errorf("WdPostTask.Do() failed to doPartition: %v", err)
 		return false, err
 	}
+	harmonytask.Logw(taskID, "partition proven", "took", time.Since(proveStart))
 
 	var msgbuf bytes.Buffer
 	if err := postOut.MarshalCBOR(&msgbuf); err != nil {
@@ -174,18 +254,22 @@ func (t *WdPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 			"proving_period_start": pps,
 			"deadline":             deadline.Index,
 			"partition":            partIdx,
-			"submit_at_epoch":      deadline.Open,
-			"submit_by_epoch":      deadline.Close,
+			"submit_at_epoch":      window.SubmitAt,
+			"submit_by_epoch":      window.SubmitBy,
 			"proof_params":         msgbuf.Bytes(),
 		}, "", "  ")
 		if err != nil {
 			return false, xerrors.Errorf("marshaling message: %w", err)
 		}
-		ctx := context.Background()
 		_, err = t.db.Exec(ctx, `UPDATE harmony_test SET result=$1 WHERE task_id=$2`, string(data), taskID)
 		if err != nil {
 			return false, xerrors.Errorf("updating harmony_test: %w", err)
 		}
+		// test tasks may use a real deadline identity, don't let them block regular scheduling
+		_, err = t.db.Exec(ctx, `DELETE FROM wdpost_partition_tasks WHERE task_id=$1`, taskID)
+		if err != nil {
+			return false, xerrors.Errorf("deleting test partition task: %w", err)
+		}
 		log.Infof("SKIPPED sending test message to chain. SELECT * FROM harmony_test WHERE task_id= %v", taskID)
 		return true, nil // nothing committed
 	}
@@ -198,15 +282,17 @@ func (t *WdPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done
 	                           partition,
 	                           submit_at_epoch,
 	                           submit_by_epoch,
-                               proof_params)
-	    			 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+                               proof_params,
+                               challenge_rand)
+	    			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
 		spID,
 		pps,
 		deadline.Index,
 		partIdx,
-		deadline.Open,
-		deadline.Close,
+		window.SubmitAt,
+		window.SubmitBy,
 		msgbuf.Bytes(),
+		[]byte(challengeRand),
 	)
 
 	if err != nil {
@@ -233,6 +319,11 @@ func (t *WdPostTask) CanAccept(ids []harmonytask.TaskID, te *harmonytask.TaskEng
 		return nil, err
 	}
 
+	if chainsched.Stale(ts) {
+		// the node is lagging, proofs against its head would be wasted
+		return nil, nil
+	}
+
 	// GetData for tasks
 	type wdTaskDef struct {
 		TaskID             harmonytask.TaskID
@@ -241,8 +332,9 @@ func (t *WdPostTask) CanAccept(ids []harmonytask.TaskID, te *harmonytask.TaskEng
 		DeadlineIndex      uint64
 		PartitionIndex     uint64
 
-		dlInfo *dline.Info `pgx:"-"`
-		openTs *types.TipSet
+		dlInfo       *dline.Info `pgx:"-"`
+		openTs       *types.TipSet
+		localSectors int64
 	}
 	var tasks []wdTaskDef
 
@@ -267,12 +359,38 @@ func (t *WdPostTask) CanAccept(ids []harmonytask.TaskID, te *harmonytask.TaskEng
 			return &tasks[i].TaskID, nil
 		}
 
-		tasks[i].openTs, err = t.api.ChainGetTipSetAfterHeight(context.Background(), tasks[i].dlInfo.Open, ts.Key())
+		// with a positive safety margin the deadline may not be open yet, the
+		// challenge epoch is always in the chain by the time tasks are added
+		tasks[i].openTs, err = t.api.ChainGetTipSetAfterHeight(context.Background(), tasks[i].dlInfo.Challenge, ts.Key())
 		if err != nil {
 			return nil, xerrors.Errorf("getting task open tipset: %w", err)
 		}
 	}
 
+	if t.affinity != nil {
+		locs, err := t.affinity.localities(context.Background(), ids)
+		if err != nil {
+			return nil, xerrors.Errorf("getting task localities: %w", err)
+		}
+		deferred, err := t.affinity.deferred(context.Background(), locs)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = lo.Filter(tasks, func(d wdTaskDef, _ int) bool {
+			_, ok := deferred[d.TaskID]
+			return !ok
+		})
+		if len(tasks) == 0 {
+			log.Debugw("leaving WindowPoSt tasks to nodes with local access to their storage", "tasks", len(ids))
+			return nil, nil
+		}
+
+		for i := range tasks {
+			tasks[i].localSectors = locs[tasks[i].TaskID].LocalSectors
+		}
+	}
+
 	// todo fix the block below
 	//  workAdderMutex is held by taskTypeHandler.considerWork, which calls this CanAccept
 	//  te.ResourcesAvailable will try to get that lock again, which will deadlock
@@ -317,9 +435,13 @@ func (t *WdPostTask) CanAccept(ids []harmonytask.TaskID, te *harmonytask.TaskEng
 		return r < 2
 	})
 
-	// Select the one closest to the deadline
+	// Select the one closest to the deadline, then the one with the most
+	// sectors in local storage
 	sort.Slice(tasks, func(i, j int) bool {
-		return tasks[i].dlInfo.Open < tasks[j].dlInfo.Open
+		if tasks[i].dlInfo.Open != tasks[j].dlInfo.Open {
+			return tasks[i].dlInfo.Open < tasks[j].dlInfo.Open
+		}
+		return tasks[i].localSectors > tasks[j].localSectors
 	})
 
 	return &tasks[0].TaskID, nil
@@ -331,6 +453,7 @@ func (t *WdPostTask) TypeDetails() harmonytask.TaskTypeDetails {
 	return harmonytask.TaskTypeDetails{
 		Name:        "WdPost",
 		Max:         t.max,
+		ClusterMax:  t.clusterMax,
 		MaxFailures: 3,
 		Follows:     nil,
 		Cost: resources.Resources{
@@ -356,6 +479,15 @@ func (t *WdPostTask) Adder(taskFunc harmonytask.AddTaskFunc) {
 }
 
 func (t *WdPostTask) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	paused, err := t.pauses.paused(ctx, t.actors)
+	if err != nil {
+		// missing a deadline is worse than proving one which should be paused
+		log.Errorw("getting paused deadlines, proving all of them", "error", err)
+	}
+	if err := t.verifyFailure.updateAlert(ctx, t.actors); err != nil {
+		log.Errorw("updating held proofs alert", "error", err)
+	}
+
 	for _, act := range t.actors {
 		maddr := address.Address(act)
 
@@ -373,36 +505,79 @@ func (t *WdPostTask) processHeadChange(ctx context.Context, revert, apply *types
 			return nil // not proving anything yet
 		}
 
-		partitions, err := t.api.StateMinerPartitions(ctx, maddr, di.Index, apply.Key())
-		if err != nil {
-			return xerrors.Errorf("getting partitions: %w", err)
-		}
+		margin := t.margin(maddr)
 
-		// TODO: Batch Partitions??
+		// With a positive safety margin, proving for the next deadline may
+		// start before it opens. Its partitions can't change anymore at this
+		// point, deadlines are immutable while they are current or next.
+		for _, dl := range []*dline.Info{di, wdpost.NextDeadline(di)} {
+			window := effectiveWindow(dl, margin)
+			if apply.Height() < window.ComputeAt {
+				continue
+			}
+
+			if t.loggedWindows[aid] < dl.Open {
+				t.loggedWindows[aid] = dl.Open
+				log.Infow("WindowPoSt proving window", "miner", maddr, "deadline", dl.Index, "periodStart", dl.PeriodStart,
+					"open", dl.Open, "close", dl.Close, "margin", margin, "computeAt", window.ComputeAt, "submitAt", window.SubmitAt)
+				if _, ok := paused[pausedKey{SpID: aid, Deadline: dl.Index}]; ok {
+					log.Warnw("WindowPoSt is paused for deadline, not proving it", "miner", maddr, "deadline", dl.Index, "close", dl.Close)
+				}
+			}
 
-		for pidx := range partitions {
-			tid := wdTaskIdentity{
-				SpID:               aid,
-				ProvingPeriodStart: di.PeriodStart,
-				DeadlineIndex:      di.Index,
-				PartitionIndex:     uint64(pidx),
+			if _, ok := paused[pausedKey{SpID: aid, Deadline: dl.Index}]; ok {
+				continue
 			}
 
-			tf := t.windowPoStTF.Val(ctx)
-			if tf == nil {
-				return xerrors.Errorf("no task func")
+			if err := t.schedulePartitions(ctx, maddr, aid, dl, apply); err != nil {
+				return err
 			}
+		}
+	}
+
+	return nil
+}
+
+func (t *WdPostTask) schedulePartitions(ctx context.Context, maddr address.Address, aid uint64, di *dline.Info, apply *types.TipSet) error {
+	partitions, err := t.api.StateMinerPartitions(ctx, maddr, di.Index, apply.Key())
+	if err != nil {
+		return xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	// A node may start (or restart) after some partitions in the current
+	// deadline were already proven, either on-chain or by another node
+	// in the cluster; only schedule the ones which still need work.
+	pending, err := t.pendingPartitions(ctx, maddr, aid, di, len(partitions), apply.Key())
+	if err != nil {
+		return xerrors.Errorf("checking pending partitions: %w", err)
+	}
+
+	// TODO: Batch Partitions??
+
+	for _, pidx := range pending {
+		tid := wdTaskIdentity{
+			SpID:               aid,
+			ProvingPeriodStart: di.PeriodStart,
+			DeadlineIndex:      di.Index,
+			PartitionIndex:     pidx,
+		}
 
-			tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
-				return t.addTaskToDB(id, tid, tx)
-			})
+		tf := t.windowPoStTF.Val(ctx)
+		if tf == nil {
+			return xerrors.Errorf("no task func")
 		}
+
+		locs := t.partitionAffinity(ctx, aid, partitions[pidx])
+
+		tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+			return t.addTaskToDB(id, tid, locs, tx)
+		})
 	}
 
 	return nil
 }
 
-func (t *WdPostTask) addTaskToDB(taskId harmonytask.TaskID, taskIdent wdTaskIdentity, tx *harmonydb.Tx) (bool, error) {
+func (t *WdPostTask) addTaskToDB(taskId harmonytask.TaskID, taskIdent wdTaskIdentity, locs map[string]int64, tx *harmonydb.Tx) (bool, error) {
 
 	_, err := tx.Exec(
 		`INSERT INTO wdpost_partition_tasks (
@@ -422,7 +597,47 @@ func (t *WdPostTask) addTaskToDB(taskId harmonytask.TaskID, taskIdent wdTaskIden
 		return false, xerrors.Errorf("insert partition task: %w", err)
 	}
 
+	if t.affinity != nil {
+		if err := t.affinity.record(tx, taskId, locs); err != nil {
+			return false, err
+		}
+	}
+
 	return true, nil
 }
 
+// partitionAffinity returns where the live sectors of a partition are stored,
+// or nil when affinity is disabled. Lookup failures only cost the affinity,
+// they never keep a partition from being scheduled.
+func (t *WdPostTask) partitionAffinity(ctx context.Context, spID uint64, part api.Partition) map[string]int64 {
+	if t.affinity == nil {
+		return nil
+	}
+
+	locs, err := t.affinity.locate(ctx, spID, part.LiveSectors)
+	if err != nil {
+		log.Warnw("looking up partition storage affinity", "sp", spID, "error", err)
+		return nil
+	}
+	return locs
+}
+
+// recordLocality counts the task in the locality metric.
+func (t *WdPostTask) recordLocality(ctx context.Context, maddr address.Address, taskID harmonytask.TaskID) {
+	if t.affinity == nil {
+		return
+	}
+
+	locs, err := t.affinity.localities(ctx, []harmonytask.TaskID{taskID})
+	if err != nil {
+		log.Warnw("getting task locality", "task", taskID, "error", err)
+		return
+	}
+
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(metrics.MinerID, maddr.String()),
+		tag.Upsert(LocalityKey, locs[taskID].String()),
+	}, WdPostMeasures.TaskLocality.M(1))
+}
+
 var _ harmonytask.TaskInterface = &WdPostTask{}
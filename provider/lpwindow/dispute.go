@@ -0,0 +1,356 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner2 "github.com/filecoin-project/go-state-types/builtin/v9/miner"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+)
+
+type DisputeAPI interface {
+	ChainGetTipSet(context.Context, types.TipSetKey) (*types.TipSet, error)
+	ChainGetParentMessages(ctx context.Context, blockCid cid.Cid) ([]api.Message, error)
+	ChainGetParentReceipts(ctx context.Context, blockCid cid.Cid) ([]*types.MessageReceipt, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+}
+
+// DeadlineRecoverer schedules declaring the faults of a deadline recovered,
+// implemented by WdPostRecoverDeclareTask.
+type DeadlineRecoverer interface {
+	RecoverDeadline(ctx context.Context, maddr address.Address, dlIdx uint64, ts *types.TipSet) error
+}
+
+// disputeCatchUpLimit bounds the epochs searched for disputes when the
+// watcher falls behind the head, e.g. after a restart.
+var disputeCatchUpLimit = abi.ChainEpoch(policy.ChainFinality)
+
+// DisputeWatcher looks for successful disputes of the WindowPoSt proofs of
+// the miners in the messages executed in each epoch, walking the tipsets
+// skipped by head changes and, after a restart, since the last epoch
+// searched in wdpost_dispute_watch. A successful dispute marks the sectors
+// of the disputed partitions faulty and penalizes the miner. The watcher
+// records the dispute in wdpost_disputes, raises an alert, and schedules
+// declaring the faults recovered so that the deadline is proven again in its
+// next challenge window. Disputes stay pending in wdpost_disputes until
+// their deadline has no faulty sectors left, the alert is raised while a
+// miner has pending disputes, on every node running the watcher.
+type DisputeWatcher struct {
+	api     DisputeAPI
+	db      harmonydb.Interface
+	al      *alerting.Alerting
+	recover DeadlineRecoverer
+
+	alerts map[address.Address]alerting.AlertType
+
+	// last epoch searched for disputes, -1 until loaded from the database
+	last abi.ChainEpoch
+}
+
+func NewDisputeWatcher(pcs *chainsched.ProviderChainSched, api DisputeAPI, db harmonydb.Interface, al *alerting.Alerting,
+	actors []dtypes.MinerAddress, recover DeadlineRecoverer) (*DisputeWatcher, error) {
+	w := newDisputeWatcher(api, db, al, actors, recover)
+
+	if err := pcs.AddHandler(w.processHeadChange); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func newDisputeWatcher(api DisputeAPI, db harmonydb.Interface, al *alerting.Alerting, actors []dtypes.MinerAddress, recover DeadlineRecoverer) *DisputeWatcher {
+	w := &DisputeWatcher{
+		api:     api,
+		db:      db,
+		al:      al,
+		recover: recover,
+
+		alerts: map[address.Address]alerting.AlertType{},
+		last:   -1,
+	}
+	for _, act := range actors {
+		maddr := address.Address(act)
+		w.alerts[maddr] = al.AddAlertType("lpwindow", "disputed-"+maddr.String())
+	}
+	return w
+}
+
+func (w *DisputeWatcher) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	if apply == nil {
+		return nil
+	}
+
+	if w.last < 0 {
+		last, err := w.loadLast(ctx)
+		if err != nil {
+			return err
+		}
+		w.last = last
+	}
+	if revert != nil && w.last >= revert.Height() {
+		// messages of the reverted tipsets are executed again on the new
+		// chain, recording disputes is idempotent
+		w.last = revert.Height() - 1
+	}
+
+	tss, err := w.unseen(ctx, apply)
+	if err != nil {
+		return err
+	}
+	for _, ts := range tss {
+		if err := w.processTipSet(ctx, ts); err != nil {
+			return xerrors.Errorf("searching epoch %d for disputes: %w", ts.Height(), err)
+		}
+		w.last = ts.Height()
+	}
+
+	if err := w.saveLast(ctx); err != nil {
+		return err
+	}
+
+	return w.checkRecovered(ctx, apply)
+}
+
+// loadLast returns the last epoch searched for disputes of all miners, or
+// 0 when one of them was never searched.
+func (w *DisputeWatcher) loadLast(ctx context.Context) (abi.ChainEpoch, error) {
+	var rows []struct {
+		SpID      uint64         `db:"sp_id"`
+		LastEpoch abi.ChainEpoch `db:"last_epoch"`
+	}
+	if err := w.db.Select(ctx, &rows, `SELECT sp_id, last_epoch FROM wdpost_dispute_watch`); err != nil {
+		return 0, xerrors.Errorf("getting last epoch searched for disputes: %w", err)
+	}
+
+	byMiner := map[address.Address]abi.ChainEpoch{}
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(r.SpID)
+		if err != nil {
+			return 0, err
+		}
+		byMiner[maddr] = r.LastEpoch
+	}
+
+	last := abi.ChainEpoch(-1)
+	for maddr := range w.alerts {
+		e, ok := byMiner[maddr]
+		if !ok {
+			return 0, nil
+		}
+		if last < 0 || e < last {
+			last = e
+		}
+	}
+	if last < 0 {
+		return 0, nil
+	}
+	return last, nil
+}
+
+func (w *DisputeWatcher) saveLast(ctx context.Context) error {
+	for maddr := range w.alerts {
+		spID, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return xerrors.Errorf("getting miner ID: %w", err)
+		}
+		_, err = w.db.Exec(ctx, `INSERT INTO wdpost_dispute_watch (sp_id, last_epoch) VALUES ($1, $2)
+			ON CONFLICT (sp_id) DO UPDATE SET last_epoch = GREATEST(wdpost_dispute_watch.last_epoch, EXCLUDED.last_epoch)`,
+			spID, w.last)
+		if err != nil {
+			return xerrors.Errorf("saving last epoch searched for disputes: %w", err)
+		}
+	}
+	return nil
+}
+
+// unseen returns the tipsets up to apply which weren't searched yet, oldest
+// first. Without a last searched epoch only apply is returned.
+func (w *DisputeWatcher) unseen(ctx context.Context, apply *types.TipSet) ([]*types.TipSet, error) {
+	if w.last <= 0 {
+		return []*types.TipSet{apply}, nil
+	}
+
+	from := w.last
+	if apply.Height()-from > disputeCatchUpLimit {
+		log.Warnw("dispute watcher is behind the head, skipping older epochs", "last", from, "head", apply.Height(), "limit", disputeCatchUpLimit)
+		from = apply.Height() - disputeCatchUpLimit
+	}
+
+	var tss []*types.TipSet
+	for ts := apply; ts.Height() > from; {
+		tss = append(tss, ts)
+		if ts.Height() == 0 {
+			break
+		}
+
+		var err error
+		ts, err = w.api.ChainGetTipSet(ctx, ts.Parents())
+		if err != nil {
+			return nil, xerrors.Errorf("getting parent tipset: %w", err)
+		}
+	}
+
+	for i, j := 0, len(tss)-1; i < j; i, j = i+1, j-1 {
+		tss[i], tss[j] = tss[j], tss[i]
+	}
+	return tss, nil
+}
+
+func (w *DisputeWatcher) processTipSet(ctx context.Context, apply *types.TipSet) error {
+	// messages returned for any block of the tipset are the ones executed
+	// on top of its parent
+	msgs, err := w.api.ChainGetParentMessages(ctx, apply.Cids()[0])
+	if err != nil {
+		return xerrors.Errorf("getting executed messages: %w", err)
+	}
+	receipts, err := w.api.ChainGetParentReceipts(ctx, apply.Cids()[0])
+	if err != nil {
+		return xerrors.Errorf("getting receipts: %w", err)
+	}
+	if len(msgs) != len(receipts) {
+		return xerrors.Errorf("got %d messages but %d receipts", len(msgs), len(receipts))
+	}
+
+	for i, m := range msgs {
+		if m.Message.Method != builtin.MethodsMiner.DisputeWindowedPoSt || !receipts[i].ExitCode.IsSuccess() {
+			continue
+		}
+
+		maddr, err := w.api.StateLookupID(ctx, m.Message.To, apply.Key())
+		if err != nil {
+			return xerrors.Errorf("looking up disputed miner %s: %w", m.Message.To, err)
+		}
+		if _, ours := w.alerts[maddr]; !ours {
+			continue
+		}
+
+		var params miner2.DisputeWindowedPoStParams
+		if err := params.UnmarshalCBOR(bytes.NewReader(m.Message.Params)); err != nil {
+			log.Errorw("decoding dispute params", "miner", maddr, "message", m.Cid, "error", err)
+			continue
+		}
+
+		if err := w.handleDispute(ctx, maddr, m, params, apply); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+func (w *DisputeWatcher) handleDispute(ctx context.Context, maddr address.Address, m api.Message, params miner2.DisputeWindowedPoStParams, apply *types.TipSet) error {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	log.Errorw("WindowPoSt proof disputed on chain, the disputed partitions are faulty until they are proven again",
+		"miner", maddr, "deadline", params.Deadline, "post_index", params.PoStIndex, "disputer", m.Message.From, "message", m.Cid)
+
+	_, err = w.db.Exec(ctx, `INSERT INTO wdpost_disputes (message_cid, sp_id, deadline, post_index, disputer, epoch)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (message_cid) DO NOTHING`,
+		m.Cid.String(), spID, params.Deadline, params.PoStIndex, m.Message.From.String(), apply.Height())
+	if err != nil {
+		return xerrors.Errorf("recording dispute: %w", err)
+	}
+
+	w.al.Raise(w.alerts[maddr], map[string]interface{}{
+		"miner":     maddr.String(),
+		"deadline":  params.Deadline,
+		"postIndex": params.PoStIndex,
+		"disputer":  m.Message.From.String(),
+		"message":   m.Cid.String(),
+		"epoch":     apply.Height(),
+	})
+
+	if w.recover != nil {
+		if err := w.recover.RecoverDeadline(ctx, maddr, params.Deadline, apply); err != nil {
+			return xerrors.Errorf("scheduling recovery of disputed deadline %d: %w", params.Deadline, err)
+		}
+	}
+
+	return nil
+}
+
+// checkRecovered marks the pending disputes whose deadline has no faulty
+// sectors left recovered, and raises or resolves the alerts of the miners
+// depending on whether they have pending disputes left.
+func (w *DisputeWatcher) checkRecovered(ctx context.Context, apply *types.TipSet) error {
+	var pending []struct {
+		SpID     uint64 `db:"sp_id"`
+		Deadline uint64 `db:"deadline"`
+	}
+	err := w.db.Select(ctx, &pending, `SELECT DISTINCT sp_id, deadline FROM wdpost_disputes WHERE recovered_epoch IS NULL`)
+	if err != nil {
+		return xerrors.Errorf("getting pending disputes: %w", err)
+	}
+
+	left := map[address.Address][]uint64{}
+	for _, p := range pending {
+		maddr, err := address.NewIDAddress(p.SpID)
+		if err != nil {
+			return err
+		}
+		if _, ours := w.alerts[maddr]; !ours {
+			continue
+		}
+
+		parts, err := w.api.StateMinerPartitions(ctx, maddr, p.Deadline, apply.Key())
+		if err != nil {
+			return xerrors.Errorf("getting partitions of disputed deadline %d: %w", p.Deadline, err)
+		}
+
+		var faulty uint64
+		for _, part := range parts {
+			n, err := part.FaultySectors.Count()
+			if err != nil {
+				return xerrors.Errorf("counting faulty sectors: %w", err)
+			}
+			faulty += n
+		}
+		if faulty > 0 {
+			left[maddr] = append(left[maddr], p.Deadline)
+			continue
+		}
+
+		_, err = w.db.Exec(ctx, `UPDATE wdpost_disputes SET recovered_epoch = $3
+			WHERE sp_id = $1 AND deadline = $2 AND recovered_epoch IS NULL`, p.SpID, p.Deadline, apply.Height())
+		if err != nil {
+			return xerrors.Errorf("marking disputed deadline %d recovered: %w", p.Deadline, err)
+		}
+	}
+
+	for maddr, alert := range w.alerts {
+		raised := w.al.IsRaised(alert)
+		switch {
+		case len(left[maddr]) > 0 && !raised:
+			// the dispute was seen by another node, or before a restart
+			w.al.Raise(alert, map[string]interface{}{
+				"miner":     maddr.String(),
+				"deadlines": left[maddr],
+				"message":   "disputed deadlines pending recovery",
+				"epoch":     apply.Height(),
+			})
+		case len(left[maddr]) == 0 && raised:
+			w.al.Resolve(alert, map[string]interface{}{
+				"miner":   maddr.String(),
+				"message": "disputed deadlines recovered",
+				"epoch":   apply.Height(),
+			})
+		}
+	}
+	return nil
+}
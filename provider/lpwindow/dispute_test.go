@@ -0,0 +1,170 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin"
+	miner2 "github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/go-state-types/exitcode"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+type fakeDisputeAPI struct {
+	tipsets  map[types.TipSetKey]*types.TipSet
+	msgs     map[cid.Cid][]api.Message
+	receipts map[cid.Cid][]*types.MessageReceipt
+	faulty   bitfield.BitField
+}
+
+func (f *fakeDisputeAPI) ChainGetTipSet(ctx context.Context, tsk types.TipSetKey) (*types.TipSet, error) {
+	ts, ok := f.tipsets[tsk]
+	if !ok {
+		return nil, xerrors.Errorf("tipset %s not found", tsk)
+	}
+	return ts, nil
+}
+
+func (f *fakeDisputeAPI) ChainGetParentMessages(ctx context.Context, blockCid cid.Cid) ([]api.Message, error) {
+	return f.msgs[blockCid], nil
+}
+
+func (f *fakeDisputeAPI) ChainGetParentReceipts(ctx context.Context, blockCid cid.Cid) ([]*types.MessageReceipt, error) {
+	return f.receipts[blockCid], nil
+}
+
+func (f *fakeDisputeAPI) StateLookupID(ctx context.Context, a address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return a, nil
+}
+
+func (f *fakeDisputeAPI) StateMinerPartitions(ctx context.Context, maddr address.Address, dlIdx uint64, tsk types.TipSetKey) ([]api.Partition, error) {
+	return []api.Partition{{FaultySectors: f.faulty}}, nil
+}
+
+type fakeRecoverer struct {
+	deadlines []uint64
+}
+
+func (f *fakeRecoverer) RecoverDeadline(ctx context.Context, maddr address.Address, dlIdx uint64, ts *types.TipSet) error {
+	f.deadlines = append(f.deadlines, dlIdx)
+	return nil
+}
+
+type pendingDispute = struct {
+	SpID     uint64 `db:"sp_id"`
+	Deadline uint64 `db:"deadline"`
+}
+
+type disputeWatch = struct {
+	SpID      uint64         `db:"sp_id"`
+	LastEpoch abi.ChainEpoch `db:"last_epoch"`
+}
+
+func TestDisputeWatcher(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+
+	ours, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	other, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+	disputer, err := address.NewIDAddress(2000)
+	require.NoError(t, err)
+
+	var params bytes.Buffer
+	require.NoError(t, (&miner2.DisputeWindowedPoStParams{Deadline: 7, PoStIndex: 1}).MarshalCBOR(&params))
+	dispute := func(to address.Address, nonce uint64) api.Message {
+		m := &types.Message{To: to, From: disputer, Nonce: nonce, Method: builtin.MethodsMiner.DisputeWindowedPoSt, Params: params.Bytes()}
+		return api.Message{Cid: m.Cid(), Message: m}
+	}
+	ourDispute := dispute(ours, 1)
+
+	// 100 <- 101 (dispute) <- null round <- 103 <- 104 <- 105
+	b := mock.MkBlock(nil, 1, 1)
+	b.Height = 100
+	chain := map[abi.ChainEpoch]*types.TipSet{100: mock.TipSet(b)}
+	parent := chain[100]
+	for _, h := range []abi.ChainEpoch{101, 103, 104, 105} {
+		b := mock.MkBlock(parent, 1, uint64(h))
+		b.Height = h
+		chain[h] = mock.TipSet(b)
+		parent = chain[h]
+	}
+
+	fapi := &fakeDisputeAPI{
+		tipsets: map[types.TipSetKey]*types.TipSet{},
+		msgs: map[cid.Cid][]api.Message{
+			chain[101].Cids()[0]: {dispute(other, 0), ourDispute, dispute(ours, 2)},
+		},
+		receipts: map[cid.Cid][]*types.MessageReceipt{
+			chain[101].Cids()[0]: {{}, {}, {ExitCode: exitcode.ErrIllegalArgument}},
+		},
+		faulty: bitfield.NewFromSet([]uint64{3, 4}),
+	}
+	for _, ts := range chain {
+		fapi.tipsets[ts.Key()] = ts
+	}
+	rec := &fakeRecoverer{}
+	actors := []dtypes.MinerAddress{dtypes.MinerAddress(ours)}
+	w := newDisputeWatcher(fapi, db, al, actors, rec)
+
+	// never searched before, the watcher starts at the head
+	db.ExpectSelect(`FROM wdpost_dispute_watch`)
+	db.ExpectExec(`INSERT INTO wdpost_dispute_watch`).WithArgs(uint64(1000), abi.ChainEpoch(100))
+	db.ExpectSelect(`FROM wdpost_disputes WHERE recovered_epoch IS NULL`)
+	require.NoError(t, w.processHeadChange(ctx, nil, chain[100]))
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// the head skips epoch 101, its dispute is still found and only the
+	// successful dispute of our miner is recorded
+	db.ExpectExec(`INSERT INTO wdpost_disputes`).
+		WithArgs(ourDispute.Cid.String(), uint64(1000), uint64(7), uint64(1), disputer.String(), abi.ChainEpoch(101)).
+		WillReturnCount(1)
+	db.ExpectExec(`INSERT INTO wdpost_dispute_watch`).WithArgs(uint64(1000), abi.ChainEpoch(103))
+	db.ExpectSelect(`FROM wdpost_disputes WHERE recovered_epoch IS NULL`).
+		WillReturnSelect([]pendingDispute{{SpID: 1000, Deadline: 7}})
+	require.NoError(t, w.processHeadChange(ctx, nil, chain[103]))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Equal(t, []uint64{7}, rec.deadlines)
+	require.True(t, al.IsRaised(w.alerts[ours]))
+
+	// after a restart, the search resumes from the saved epoch and the
+	// pending dispute raises the alert again
+	al = alerting.NewAlertingSystem(journal.NilJournal())
+	w = newDisputeWatcher(fapi, db, al, actors, rec)
+	db.ExpectSelect(`FROM wdpost_dispute_watch`).WillReturnSelect([]disputeWatch{{SpID: 1000, LastEpoch: 103}})
+	db.ExpectExec(`INSERT INTO wdpost_dispute_watch`).WithArgs(uint64(1000), abi.ChainEpoch(104))
+	db.ExpectSelect(`FROM wdpost_disputes WHERE recovered_epoch IS NULL`).
+		WillReturnSelect([]pendingDispute{{SpID: 1000, Deadline: 7}})
+	require.NoError(t, w.processHeadChange(ctx, nil, chain[104]))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.Equal(t, []uint64{7}, rec.deadlines)
+	require.True(t, al.IsRaised(w.alerts[ours]))
+
+	// the deadline proven again marks the dispute recovered and resolves
+	// the alert
+	fapi.faulty = bitfield.New()
+	db.ExpectExec(`INSERT INTO wdpost_dispute_watch`).WithArgs(uint64(1000), abi.ChainEpoch(105))
+	db.ExpectSelect(`FROM wdpost_disputes WHERE recovered_epoch IS NULL`).
+		WillReturnSelect([]pendingDispute{{SpID: 1000, Deadline: 7}})
+	db.ExpectExec(`UPDATE wdpost_disputes SET recovered_epoch`).WithArgs(uint64(1000), uint64(7), abi.ChainEpoch(105))
+	require.NoError(t, w.processHeadChange(ctx, nil, chain[105]))
+	require.NoError(t, db.ExpectationsWereMet())
+	require.False(t, al.IsRaised(w.alerts[ours]))
+}
@@ -0,0 +1,96 @@
+package lpwindow
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// maxJournalFaults bounds the number of faults listed in a FaultCheckEvt,
+// faults past it are only counted in the summary.
+const maxJournalFaults = 256
+
+// FaultKind is why a sector failed its provability check.
+type FaultKind string
+
+const (
+	// FaultMissingFile is recorded when the sealed or cache files of the
+	// sector can't be found in any storage path.
+	FaultMissingFile FaultKind = "missing-file"
+	// FaultReadError is recorded when the files exist but couldn't be read in
+	// time, e.g. because they are locked or the storage is too slow.
+	FaultReadError FaultKind = "read-error"
+	// FaultBadProof is recorded when a vanilla proof couldn't be generated
+	// from the sector files, which usually means they are corrupted.
+	FaultBadProof FaultKind = "bad-proof"
+	// FaultOther is recorded for failures which aren't caused by the sector
+	// storage, e.g. errors getting the sector info from chain.
+	FaultOther FaultKind = "other"
+)
+
+// SectorFault is a sector which failed a provability check.
+type SectorFault struct {
+	Sector abi.SectorNumber
+	Kind   FaultKind
+	Reason string
+}
+
+// FaultCheckEvt is the journal event recorded every time the fault tracker
+// checks a set of sectors before proving them.
+type FaultCheckEvt struct {
+	Miner abi.ActorID
+	// Checked is the set of sectors checked.
+	Checked bitfield.BitField
+	// Faults lists up to maxJournalFaults faulty sectors, in ascending order.
+	Faults []SectorFault `json:",omitempty"`
+	// Summary is the number of faulty sectors per kind, always complete.
+	Summary map[FaultKind]int `json:",omitempty"`
+	// Truncated is set when Faults doesn't list every faulty sector.
+	Truncated bool `json:",omitempty"`
+}
+
+// classifyVanillaErr picks the fault kind of a vanilla proof generation error.
+func classifyVanillaErr(ctx context.Context, err error) FaultKind {
+	switch {
+	case errors.Is(err, storiface.ErrSectorNotFound):
+		return FaultMissingFile
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled), ctx.Err() != nil:
+		return FaultReadError
+	default:
+		return FaultBadProof
+	}
+}
+
+// newFaultCheckEvt builds the journal event of a check, listing at most max
+// faults.
+func newFaultCheckEvt(sectors []storiface.SectorRef, faults map[abi.SectorID]SectorFault, max int) *FaultCheckEvt {
+	evt := &FaultCheckEvt{
+		Summary: map[FaultKind]int{},
+	}
+
+	checked := make([]uint64, 0, len(sectors))
+	for _, s := range sectors {
+		evt.Miner = s.ID.Miner
+		checked = append(checked, uint64(s.ID.Number))
+	}
+	evt.Checked = bitfield.NewFromSet(checked)
+
+	sorted := make([]SectorFault, 0, len(faults))
+	for _, f := range faults {
+		evt.Summary[f.Kind]++
+		sorted = append(sorted, f)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Sector < sorted[j].Sector })
+
+	if len(sorted) > max {
+		sorted, evt.Truncated = sorted[:max], true
+	}
+	evt.Faults = sorted
+
+	return evt
+}
@@ -0,0 +1,67 @@
+package lpwindow
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestFaultCheckEvt(t *testing.T) {
+	var sectors []storiface.SectorRef
+	faults := map[abi.SectorID]SectorFault{}
+	for n := abi.SectorNumber(0); n < 1000; n++ {
+		id := abi.SectorID{Miner: 1000, Number: n}
+		sectors = append(sectors, storiface.SectorRef{ID: id})
+
+		switch {
+		case n%10 == 3:
+			faults[id] = SectorFault{Sector: n, Kind: FaultMissingFile, Reason: "gone"}
+		case n%10 == 7:
+			faults[id] = SectorFault{Sector: n, Kind: FaultBadProof, Reason: "corrupted"}
+		}
+	}
+
+	evt := newFaultCheckEvt(sectors, faults, 1000)
+	require.Equal(t, abi.ActorID(1000), evt.Miner)
+	checked, err := evt.Checked.Count()
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, checked)
+	require.Len(t, evt.Faults, 200)
+	require.False(t, evt.Truncated)
+	require.Equal(t, map[FaultKind]int{FaultMissingFile: 100, FaultBadProof: 100}, evt.Summary)
+	require.Equal(t, SectorFault{Sector: 3, Kind: FaultMissingFile, Reason: "gone"}, evt.Faults[0])
+
+	// many faults are summarized
+	evt = newFaultCheckEvt(sectors, faults, 50)
+	require.Len(t, evt.Faults, 50)
+	require.True(t, evt.Truncated)
+	require.Equal(t, map[FaultKind]int{FaultMissingFile: 100, FaultBadProof: 100}, evt.Summary)
+	for i := 1; i < len(evt.Faults); i++ {
+		require.Less(t, evt.Faults[i-1].Sector, evt.Faults[i].Sector)
+	}
+
+	// nothing faulty
+	evt = newFaultCheckEvt(sectors, nil, 50)
+	require.Empty(t, evt.Faults)
+	require.Empty(t, evt.Summary)
+	require.False(t, evt.Truncated)
+}
+
+func TestClassifyVanillaErr(t *testing.T) {
+	ctx := context.Background()
+
+	require.Equal(t, FaultMissingFile, classifyVanillaErr(ctx, xerrors.Errorf("acquire: %w", storiface.ErrSectorNotFound)))
+	require.Equal(t, FaultReadError, classifyVanillaErr(ctx, xerrors.Errorf("reading: %w", context.DeadlineExceeded)))
+	require.Equal(t, FaultBadProof, classifyVanillaErr(ctx, fmt.Errorf("invalid merkle tree")))
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, FaultReadError, classifyVanillaErr(cctx, fmt.Errorf("ffi error")))
+}
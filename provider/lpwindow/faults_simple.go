@@ -12,6 +12,7 @@ import (
 	ffi "github.com/filecoin-project/filecoin-ffi"
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/lotus/journal"
 	"github.com/filecoin-project/lotus/storage/paths"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
@@ -20,20 +21,41 @@ type SimpleFaultTracker struct {
 	storage paths.Store
 	index   paths.SectorIndex
 
+	journal  journal.Journal
+	evtCheck journal.EventType
+
 	parallelCheckLimit    int // todo live config?
 	singleCheckTimeout    time.Duration
 	partitionCheckTimeout time.Duration
+
+	// throttle bounds the sectors checked at once by all CheckProvable
+	// calls, nil without a parallelCheckLimit
+	throttle chan struct{}
 }
 
-func NewSimpleFaultTracker(storage paths.Store, index paths.SectorIndex,
+// NewSimpleFaultTracker creates a fault tracker checking at most
+// parallelCheckLimit sectors at once. The limit is shared by all concurrent
+// checks, so a single tracker used for several miners, or several partitions
+// at once, doesn't multiply the load on storage.
+func NewSimpleFaultTracker(storage paths.Store, index paths.SectorIndex, j journal.Journal,
 	parallelCheckLimit int, singleCheckTimeout time.Duration, partitionCheckTimeout time.Duration) *SimpleFaultTracker {
+	var throttle chan struct{}
+	if parallelCheckLimit > 0 {
+		throttle = make(chan struct{}, parallelCheckLimit)
+	}
+
 	return &SimpleFaultTracker{
 		storage: storage,
 		index:   index,
 
+		journal:  j,
+		evtCheck: j.RegisterEventType("wdpost", "sectors_checked"),
+
 		parallelCheckLimit:    parallelCheckLimit,
 		singleCheckTimeout:    singleCheckTimeout,
 		partitionCheckTimeout: partitionCheckTimeout,
+
+		throttle: throttle,
 	}
 }
 
@@ -46,21 +68,22 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 	}
 
 	var bad = make(map[abi.SectorID]string)
+	var faults = make(map[abi.SectorID]SectorFault)
 	var badLk sync.Mutex
 
 	var postRand abi.PoStRandomness = make([]byte, abi.RandomnessLength)
 	_, _ = rand.Read(postRand)
 	postRand[31] &= 0x3f
 
-	limit := m.parallelCheckLimit
-	if limit <= 0 {
-		limit = len(sectors)
+	throttle := m.throttle
+	if throttle == nil {
+		throttle = make(chan struct{}, len(sectors))
 	}
-	throttle := make(chan struct{}, limit)
 
-	addBad := func(s abi.SectorID, reason string) {
+	addBad := func(s abi.SectorID, kind FaultKind, reason string) {
 		badLk.Lock()
 		bad[s] = reason
+		faults[s] = SectorFault{Sector: s.Number, Kind: kind, Reason: reason}
 		badLk.Unlock()
 	}
 
@@ -77,7 +100,7 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 		select {
 		case throttle <- struct{}{}:
 		case <-ctx.Done():
-			addBad(sector.ID, fmt.Sprintf("waiting for check worker: %s", ctx.Err()))
+			addBad(sector.ID, FaultReadError, fmt.Sprintf("waiting for check worker: %s", ctx.Err()))
 			wg.Done()
 			continue
 		}
@@ -93,7 +116,7 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 			commr, update, err := rg(ctx, sector.ID)
 			if err != nil {
 				log.Warnw("CheckProvable Sector FAULT: getting commR", "sector", sector, "sealed", "err", err)
-				addBad(sector.ID, fmt.Sprintf("getting commR: %s", err))
+				addBad(sector.ID, FaultOther, fmt.Sprintf("getting commR: %s", err))
 				return
 			}
 
@@ -104,13 +127,13 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 
 			locked, err := m.index.StorageTryLock(ctx, sector.ID, toLock, storiface.FTNone)
 			if err != nil {
-				addBad(sector.ID, fmt.Sprintf("tryLock error: %s", err))
+				addBad(sector.ID, FaultReadError, fmt.Sprintf("tryLock error: %s", err))
 				return
 			}
 
 			if !locked {
 				log.Warnw("CheckProvable Sector FAULT: can't acquire read lock", "sector", sector)
-				addBad(sector.ID, fmt.Sprint("can't acquire read lock"))
+				addBad(sector.ID, FaultReadError, fmt.Sprint("can't acquire read lock"))
 				return
 			}
 
@@ -119,7 +142,7 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 			})
 			if err != nil {
 				log.Warnw("CheckProvable Sector FAULT: generating challenges", "sector", sector, "err", err)
-				addBad(sector.ID, fmt.Sprintf("generating fallback challenges: %s", err))
+				addBad(sector.ID, FaultOther, fmt.Sprintf("generating fallback challenges: %s", err))
 				return
 			}
 
@@ -140,7 +163,7 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 			}, pp)
 			if err != nil {
 				log.Warnw("CheckProvable Sector FAULT: generating vanilla proof", "sector", sector, "err", err)
-				addBad(sector.ID, fmt.Sprintf("generating vanilla proof: %s", err))
+				addBad(sector.ID, classifyVanillaErr(vctx, err), fmt.Sprintf("generating vanilla proof: %s", err))
 				return
 			}
 		}(sector)
@@ -148,5 +171,9 @@ func (m *SimpleFaultTracker) CheckProvable(ctx context.Context, pp abi.Registere
 
 	wg.Wait()
 
+	m.journal.RecordEvent(m.evtCheck, func() interface{} {
+		return newFaultCheckEvt(sectors, faults, maxJournalFaults)
+	})
+
 	return bad, nil
 }
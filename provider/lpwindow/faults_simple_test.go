@@ -0,0 +1,98 @@
+package lpwindow
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// checkStore counts the vanilla proofs generated at once.
+type checkStore struct {
+	paths.Store
+
+	lk          sync.Mutex
+	active, max int
+	bad         map[abi.SectorID]bool
+}
+
+func (s *checkStore) GenerateSingleVanillaProof(ctx context.Context, minerID abi.ActorID, si storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
+	s.lk.Lock()
+	s.active++
+	if s.active > s.max {
+		s.max = s.active
+	}
+	s.lk.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	s.lk.Lock()
+	s.active--
+	s.lk.Unlock()
+
+	if s.bad[abi.SectorID{Miner: minerID, Number: si.SectorNumber}] {
+		return nil, xerrors.New("file not found")
+	}
+	return []byte("proof"), nil
+}
+
+type lockingIndex struct {
+	paths.SectorIndex
+}
+
+func (lockingIndex) StorageTryLock(ctx context.Context, sector abi.SectorID, read storiface.SectorFileType, write storiface.SectorFileType) (bool, error) {
+	return true, nil
+}
+
+func TestSimpleFaultTrackerSharedLimit(t *testing.T) {
+	ctx := context.Background()
+
+	bad := abi.SectorID{Miner: 1001, Number: 3}
+	store := &checkStore{bad: map[abi.SectorID]bool{bad: true}}
+	ft := NewSimpleFaultTracker(store, lockingIndex{}, journal.NilJournal(), 2, 0, 0)
+
+	commR, err := cid.Parse("bagboea4b5abcatlxechwbp7kjpjguna6r6q7ejrhe6mdp3lf34pmswn27pkkiekz")
+	require.NoError(t, err)
+	rg := func(ctx context.Context, id abi.SectorID) (cid.Cid, bool, error) {
+		return commR, false, nil
+	}
+
+	miners := []abi.ActorID{1000, 1001}
+	results := make([]map[abi.SectorID]string, len(miners))
+
+	var wg sync.WaitGroup
+	for i, miner := range miners {
+		var sectors []storiface.SectorRef
+		for n := abi.SectorNumber(0); n < 8; n++ {
+			sectors = append(sectors, storiface.SectorRef{
+				ID:        abi.SectorID{Miner: miner, Number: n},
+				ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+			})
+		}
+
+		wg.Add(1)
+		go func(i int, sectors []storiface.SectorRef) {
+			defer wg.Done()
+			res, err := ft.CheckProvable(ctx, abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1, sectors, rg)
+			require.NoError(t, err)
+			results[i] = res
+		}(i, sectors)
+	}
+	wg.Wait()
+
+	// both miners together stay within the limit
+	require.LessOrEqual(t, store.max, 2)
+	require.Empty(t, results[0])
+	require.Len(t, results[1], 1)
+	require.Contains(t, results[1], bad)
+}
@@ -0,0 +1,58 @@
+package lpwindow
+
+import (
+	"math"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// GasLimitBounds adjusts estimated gas limits, see
+// config.LotusProviderGasLimit. The zero value keeps the estimates.
+type GasLimitBounds struct {
+	Multiplier float64
+	Min        int64
+	Max        int64
+}
+
+// apply scales and bounds the gas limit of the estimated msg. When the fee
+// the adjusted limit allows at the gas fee cap of msg is above maxFee, the
+// gas fee cap is lowered so that the fee stays within maxFee; a zero maxFee
+// leaves the fee cap alone. It reports whether the limit was changed.
+func (b GasLimitBounds) apply(msg *types.Message, maxFee abi.TokenAmount) bool {
+	estimated := msg.GasLimit
+
+	limit := estimated
+	if b.Multiplier > 0 && b.Multiplier != 1 {
+		limit = int64(math.Ceil(float64(estimated) * b.Multiplier))
+	}
+	if b.Min > 0 && limit < b.Min {
+		limit = b.Min
+	}
+	if b.Max > 0 && limit > b.Max {
+		limit = b.Max
+	}
+	if limit > build.BlockGasLimit {
+		limit = build.BlockGasLimit
+	}
+
+	if limit == estimated {
+		return false
+	}
+	msg.GasLimit = limit
+
+	feeCap := msg.GasFeeCap
+	if !maxFee.IsZero() && big.Mul(feeCap, big.NewInt(limit)).GreaterThan(maxFee) {
+		msg.GasFeeCap = big.Div(maxFee, big.NewInt(limit))
+		msg.GasPremium = big.Min(msg.GasFeeCap, msg.GasPremium)
+	}
+
+	log.Warnw("adjusted estimated gas limit of WindowPoSt message",
+		"to", msg.To, "estimatedLimit", estimated, "limit", limit,
+		"multiplier", b.Multiplier, "min", b.Min, "max", b.Max,
+		"estimatedFeeCap", feeCap, "feeCap", msg.GasFeeCap, "maxFee", maxFee)
+	return true
+}
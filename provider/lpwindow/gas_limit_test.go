@@ -0,0 +1,92 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// estimateAPI estimates messages with a fixed gas limit
+type estimateAPI struct {
+	MsgPrepAPI
+	worker   address.Address
+	gasLimit int64
+	feeCap   big.Int
+	premium  big.Int
+}
+
+func (e *estimateAPI) StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error) {
+	return api.MinerInfo{Worker: e.worker}, nil
+}
+
+func (e *estimateAPI) GasEstimateMessageGas(ctx context.Context, msg *types.Message, spec *api.MessageSendSpec, tsk types.TipSetKey) (*types.Message, error) {
+	out := *msg
+	out.GasLimit = e.gasLimit
+	out.GasFeeCap = e.feeCap
+	out.GasPremium = e.premium
+	return &out, nil
+}
+
+func (e *estimateAPI) GasEstimateFeeCap(context.Context, *types.Message, int64, types.TipSetKey) (types.BigInt, error) {
+	return e.feeCap, nil
+}
+
+func (e *estimateAPI) GasEstimateGasPremium(context.Context, uint64, address.Address, int64, types.TipSetKey) (types.BigInt, error) {
+	return e.premium, nil
+}
+
+func TestPreparePoStMessageGasLimit(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	worker, err := address.NewIDAddress(100)
+	require.NoError(t, err)
+
+	maxFee := abi.TokenAmount(types.MustParseFIL("5"))
+
+	prepare := func(estimate int64, bounds GasLimitBounds, maxFee abi.TokenAmount) *types.Message {
+		est := &estimateAPI{worker: worker, gasLimit: estimate, feeCap: big.NewInt(200), premium: big.NewInt(100)}
+		msg := &types.Message{To: maddr, Method: builtin.MethodsMiner.SubmitWindowedPoSt, Value: big.Zero()}
+
+		msg, mss, err := preparePoStMessage(est, nil, maddr, msg, maxFee, bounds)
+		require.NoError(t, err)
+		require.Equal(t, maxFee, mss.MaxFee)
+		require.Equal(t, worker, msg.From)
+		return msg
+	}
+
+	// without bounds the estimate is used as is
+	msg := prepare(80_000_000, GasLimitBounds{}, maxFee)
+	require.EqualValues(t, 80_000_000, msg.GasLimit)
+
+	msg = prepare(80_000_000, GasLimitBounds{Multiplier: 1, Min: 10_000_000, Max: 500_000_000}, maxFee)
+	require.EqualValues(t, 80_000_000, msg.GasLimit)
+
+	// the node estimated way too little gas, e.g. against a stale state
+	msg = prepare(1_000, GasLimitBounds{Multiplier: 1, Min: 10_000_000}, maxFee)
+	require.EqualValues(t, 10_000_000, msg.GasLimit)
+	require.Equal(t, big.NewInt(200), msg.GasFeeCap)
+
+	// the node estimated more gas than a block has
+	msg = prepare(90_000_000_000, GasLimitBounds{Multiplier: 1.2, Max: 500_000_000}, maxFee)
+	require.EqualValues(t, 500_000_000, msg.GasLimit)
+
+	msg = prepare(90_000_000_000, GasLimitBounds{Multiplier: 1}, maxFee)
+	require.EqualValues(t, 10_000_000_000, msg.GasLimit, "limit capped at the block gas limit")
+
+	// scaling the limit up doesn't raise the fee above the cap
+	lowMaxFee := abi.NewTokenAmount(100_000_000 * 200)
+	msg = prepare(100_000_000, GasLimitBounds{Multiplier: 1.6}, lowMaxFee)
+	require.EqualValues(t, 160_000_000, msg.GasLimit)
+	require.Equal(t, big.NewInt(125), msg.GasFeeCap)
+	require.Equal(t, big.NewInt(100), msg.GasPremium)
+	require.True(t, msg.RequiredFunds().LessThanEqual(lowMaxFee))
+}
@@ -0,0 +1,80 @@
+package lpwindow
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "wdpost_"
+
+var (
+	DeadlineKey, _     = tag.NewKey("deadline")
+	LocalityKey, _     = tag.NewKey("locality")
+	VanillaCacheKey, _ = tag.NewKey("vanilla_cache")
+)
+
+// WdPostMeasures groups all WindowPoSt task metrics.
+var WdPostMeasures = struct {
+	ReorgRecompute   *stats.Int64Measure
+	EpochsSinceProof *stats.Int64Measure
+	TaskLocality     *stats.Int64Measure
+
+	VerifyFailRecompute *stats.Int64Measure
+	VerifyFailHold      *stats.Int64Measure
+	VerifyFailSubmit    *stats.Int64Measure
+
+	VanillaProofDuration *stats.Float64Measure
+}{
+	ReorgRecompute:   stats.Int64(pre+"reorg_recompute", "Number of proofs discarded and recomputed because a reorg changed their challenge.", stats.UnitDimensionless),
+	EpochsSinceProof: stats.Int64(pre+"epochs_since_proof", "Number of epochs since proofs for a deadline were last submitted.", stats.UnitDimensionless),
+	TaskLocality:     stats.Int64(pre+"task_locality", "Number of partition tasks proven by this node, by whether their sectors are in local storage.", stats.UnitDimensionless),
+
+	VerifyFailRecompute: stats.Int64(pre+"verify_fail_recompute", "Number of proofs which failed local verification and are computed again.", stats.UnitDimensionless),
+	VerifyFailHold:      stats.Int64(pre+"verify_fail_hold", "Number of proofs which failed local verification and are held from submission.", stats.UnitDimensionless),
+	VerifyFailSubmit:    stats.Int64(pre+"verify_fail_submit", "Number of proofs which failed local verification and are submitted anyway.", stats.UnitDimensionless),
+
+	VanillaProofDuration: stats.Float64(pre+"vanilla_proof_ms", "Duration of getting the vanilla proof of a sector with the vanilla proof cache enabled, by whether it was cached.", stats.UnitMilliseconds),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     WdPostMeasures.ReorgRecompute,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.EpochsSinceProof,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID, DeadlineKey},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.TaskLocality,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID, LocalityKey},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.VerifyFailRecompute,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.VerifyFailHold,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.VerifyFailSubmit,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WdPostMeasures.VanillaProofDuration,
+			Aggregation: view.Distribution(1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000),
+			TagKeys:     []tag.Key{metrics.MinerID, VanillaCacheKey},
+		},
+	)
+}
@@ -0,0 +1,108 @@
+package lpwindow
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/actors/policy"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type NetworkVersionAPI interface {
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
+}
+
+// partitionLimiter resolves the maximum number of partitions allowed in a
+// single SubmitWindowedPoSt message from the network version of the chain, so
+// that limit changes in network upgrades are picked up as they activate.
+type partitionLimiter struct {
+	api NetworkVersionAPI
+	// resolve computes the limit of a network version, maxPartitionsPerMsg
+	// outside of tests
+	resolve func(network.Version, abi.RegisteredPoStProof) (int, error)
+
+	lk     sync.Mutex
+	limits map[partitionLimitKey]int
+}
+
+type partitionLimitKey struct {
+	nv  network.Version
+	ppt abi.RegisteredPoStProof
+}
+
+func newPartitionLimiter(api NetworkVersionAPI) *partitionLimiter {
+	return &partitionLimiter{
+		api:     api,
+		resolve: maxPartitionsPerMsg,
+		limits:  map[partitionLimitKey]int{},
+	}
+}
+
+// limit returns the max partitions per message for proof type ppt at tsk.
+func (l *partitionLimiter) limit(ctx context.Context, tsk types.TipSetKey, ppt abi.RegisteredPoStProof) (int, error) {
+	nv, err := l.api.StateNetworkVersion(ctx, tsk)
+	if err != nil {
+		return 0, xerrors.Errorf("getting network version: %w", err)
+	}
+
+	key := partitionLimitKey{nv: nv, ppt: ppt}
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	if lim, ok := l.limits[key]; ok {
+		return lim, nil
+	}
+
+	lim, err := l.resolve(nv, ppt)
+	if err != nil {
+		return 0, err
+	}
+	l.limits[key] = lim
+
+	log.Infow("resolved WindowPoSt partitions per message limit", "networkVersion", nv, "proofType", ppt, "limit", lim)
+	return lim, nil
+}
+
+// maxPartitionsPerMsg returns the number of partitions which fit in a single
+// SubmitWindowedPoSt message at network version nv, bounded both by the
+// number of sectors a message may address and by the number of partitions.
+func maxPartitionsPerMsg(nv network.Version, ppt abi.RegisteredPoStProof) (int, error) {
+	perMsg, err := policy.GetMaxPoStPartitions(nv, ppt)
+	if err != nil {
+		return 0, xerrors.Errorf("getting max partitions per message: %w", err)
+	}
+
+	declMax, err := policy.GetDeclarationsMax(nv)
+	if err != nil {
+		return 0, xerrors.Errorf("getting max declarations: %w", err)
+	}
+	if perMsg > declMax {
+		perMsg = declMax
+	}
+
+	return perMsg, nil
+}
+
+// batchPartitions splits partitions into batches of at most perMsg.
+func batchPartitions(partitions []miner.PoStPartition, perMsg int) [][]miner.PoStPartition {
+	if perMsg < 1 {
+		perMsg = 1
+	}
+
+	var out [][]miner.PoStPartition
+	for len(partitions) > perMsg {
+		out = append(out, partitions[:perMsg])
+		partitions = partitions[perMsg:]
+	}
+	if len(partitions) > 0 {
+		out = append(out, partitions)
+	}
+	return out
+}
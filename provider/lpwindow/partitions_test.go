@@ -0,0 +1,97 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/go-state-types/network"
+
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// nvAPI reports the network version at the epoch of the chain head, which
+// tests move across an upgrade.
+type nvAPI struct {
+	height  abi.ChainEpoch
+	upgrade abi.ChainEpoch
+	before  network.Version
+	after   network.Version
+	calls   int
+}
+
+func (a *nvAPI) StateNetworkVersion(ctx context.Context, tsk types.TipSetKey) (network.Version, error) {
+	a.calls++
+	if a.height >= a.upgrade {
+		return a.after, nil
+	}
+	return a.before, nil
+}
+
+func TestPartitionLimiterUpgrade(t *testing.T) {
+	ctx := context.Background()
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow32GiBV1_1
+	a := &nvAPI{height: 98, upgrade: 100, before: network.Version20, after: network.Version21}
+	l := newPartitionLimiter(a)
+
+	// the upgrade raises the limit
+	var resolved []network.Version
+	l.resolve = func(nv network.Version, _ abi.RegisteredPoStProof) (int, error) {
+		resolved = append(resolved, nv)
+		if nv >= network.Version21 {
+			return 5, nil
+		}
+		return 3, nil
+	}
+
+	limitAt := func(h abi.ChainEpoch) int {
+		a.height = h
+		lim, err := l.limit(ctx, types.EmptyTSK, ppt)
+		require.NoError(t, err)
+		return lim
+	}
+
+	require.Equal(t, 3, limitAt(98))
+	require.Equal(t, 3, limitAt(99))
+	require.Equal(t, 5, limitAt(100))
+	require.Equal(t, 5, limitAt(101))
+
+	// a revert across the upgrade uses the old limit again, without
+	// resolving it again
+	require.Equal(t, 3, limitAt(99))
+	require.Equal(t, []network.Version{network.Version20, network.Version21}, resolved)
+	require.Equal(t, 5, a.calls)
+}
+
+func TestMaxPartitionsPerMsg(t *testing.T) {
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow32GiBV1_1
+
+	// so far every network version allows 3 partitions per message, below
+	// the declarations max
+	lim, err := maxPartitionsPerMsg(network.Version3, ppt)
+	require.NoError(t, err)
+	require.Equal(t, 3, lim)
+
+	lim, err = maxPartitionsPerMsg(network.Version21, ppt)
+	require.NoError(t, err)
+	require.Equal(t, 3, lim)
+}
+
+func TestBatchPartitions(t *testing.T) {
+	parts := make([]miner.PoStPartition, 7)
+	for i := range parts {
+		parts[i].Index = uint64(i)
+	}
+
+	batches := batchPartitions(parts, 3)
+	require.Len(t, batches, 3)
+	require.Len(t, batches[0], 3)
+	require.Len(t, batches[1], 3)
+	require.Equal(t, []miner.PoStPartition{parts[6]}, batches[2])
+
+	require.Len(t, batchPartitions(parts, 7), 1)
+	require.Empty(t, batchPartitions(nil, 3))
+}
@@ -0,0 +1,285 @@
+package lpwindow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type pausedKey struct {
+	SpID     uint64
+	Deadline uint64
+}
+
+type pauseRow struct {
+	SpID     uint64    `db:"sp_id"`
+	Deadline uint64    `db:"deadline_index"`
+	Reason   string    `db:"reason"`
+	PausedBy string    `db:"paused_by"`
+	PausedAt time.Time `db:"paused_at"`
+}
+
+// deadlinePauses tracks the deadlines WindowPoSt is paused for. Pauses are
+// stored in wdpost_paused_deadlines, so they apply to the whole cluster and
+// survive restarts. While a deadline of one of the node's miners is paused an
+// alert is kept raised, as the deadline will be faulted if it stays paused
+// through its window.
+type deadlinePauses struct {
+	db harmonydb.Interface
+	al *alerting.Alerting
+
+	lk     sync.Mutex
+	alerts map[pausedKey]alerting.AlertType
+}
+
+func newDeadlinePauses(db harmonydb.Interface, al *alerting.Alerting) *deadlinePauses {
+	return &deadlinePauses{
+		db:     db,
+		al:     al,
+		alerts: map[pausedKey]alerting.AlertType{},
+	}
+}
+
+func (p *deadlinePauses) pause(ctx context.Context, spID, dlIdx uint64, reason string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	_, err = p.db.Exec(ctx, `INSERT INTO wdpost_paused_deadlines (sp_id, deadline_index, reason, paused_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (sp_id, deadline_index) DO UPDATE SET reason = EXCLUDED.reason, paused_by = EXCLUDED.paused_by, paused_at = CURRENT_TIMESTAMP`,
+		spID, dlIdx, reason, host)
+	if err != nil {
+		return xerrors.Errorf("pausing deadline: %w", err)
+	}
+	return nil
+}
+
+// resume returns false when the deadline wasn't paused.
+func (p *deadlinePauses) resume(ctx context.Context, spID, dlIdx uint64) (bool, error) {
+	n, err := p.db.Exec(ctx, `DELETE FROM wdpost_paused_deadlines WHERE sp_id = $1 AND deadline_index = $2`, spID, dlIdx)
+	if err != nil {
+		return false, xerrors.Errorf("resuming deadline: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (p *deadlinePauses) list(ctx context.Context) ([]api.WdPoStPause, error) {
+	var rows []pauseRow
+	err := p.db.Select(ctx, &rows, `SELECT sp_id, deadline_index, reason, paused_by, paused_at FROM wdpost_paused_deadlines ORDER BY sp_id, deadline_index`)
+	if err != nil {
+		return nil, xerrors.Errorf("listing paused deadlines: %w", err)
+	}
+
+	out := make([]api.WdPoStPause, 0, len(rows))
+	for _, r := range rows {
+		maddr, err := address.NewIDAddress(r.SpID)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, api.WdPoStPause{
+			Miner:    maddr,
+			Deadline: r.Deadline,
+			Reason:   r.Reason,
+			PausedBy: r.PausedBy,
+			PausedAt: r.PausedAt,
+		})
+	}
+	return out, nil
+}
+
+// paused returns the paused deadlines of the given miners, and updates the
+// alerts to match.
+func (p *deadlinePauses) paused(ctx context.Context, actors []dtypes.MinerAddress) (map[pausedKey]struct{}, error) {
+	all, err := p.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ours := make(map[address.Address]struct{}, len(actors))
+	for _, act := range actors {
+		ours[address.Address(act)] = struct{}{}
+	}
+
+	out := map[pausedKey]struct{}{}
+	var pauses []api.WdPoStPause
+	for _, ps := range all {
+		if _, ok := ours[ps.Miner]; !ok {
+			continue
+		}
+		spID, err := address.IDFromAddress(ps.Miner)
+		if err != nil {
+			return nil, err
+		}
+		out[pausedKey{SpID: spID, Deadline: ps.Deadline}] = struct{}{}
+		pauses = append(pauses, ps)
+	}
+
+	p.updateAlerts(pauses)
+	return out, nil
+}
+
+func (p *deadlinePauses) updateAlerts(pauses []api.WdPoStPause) {
+	if p.al == nil {
+		return
+	}
+
+	p.lk.Lock()
+	defer p.lk.Unlock()
+
+	current := make(map[pausedKey]struct{}, len(pauses))
+	for _, ps := range pauses {
+		spID, _ := address.IDFromAddress(ps.Miner)
+		key := pausedKey{SpID: spID, Deadline: ps.Deadline}
+		current[key] = struct{}{}
+
+		at, ok := p.alerts[key]
+		if !ok {
+			at = p.al.AddAlertType("lpwindow", fmt.Sprintf("paused-%s-%d", ps.Miner, ps.Deadline))
+			p.alerts[key] = at
+		}
+		if !p.al.IsRaised(at) {
+			p.al.Raise(at, map[string]interface{}{
+				"miner":    ps.Miner.String(),
+				"deadline": ps.Deadline,
+				"reason":   ps.Reason,
+				"pausedBy": ps.PausedBy,
+				"pausedAt": ps.PausedAt,
+				"message":  "WindowPoSt is paused for this deadline, it will be faulted if it stays paused through its window",
+			})
+		}
+	}
+
+	for key, at := range p.alerts {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		if p.al.IsRaised(at) {
+			p.al.Resolve(at, map[string]interface{}{
+				"deadline": key.Deadline,
+				"message":  "WindowPoSt resumed",
+			})
+		}
+	}
+}
+
+// PauseDeadline stops scheduling WindowPoSt for a deadline of a miner until
+// ResumeDeadline is called. Tasks already scheduled for the deadline are left
+// to run.
+func (t *WdPostTask) PauseDeadline(ctx context.Context, maddr address.Address, dlIdx uint64, reason string) error {
+	spID, err := pausedMinerID(maddr, dlIdx)
+	if err != nil {
+		return err
+	}
+	if err := t.pauses.pause(ctx, spID, dlIdx, reason); err != nil {
+		return err
+	}
+
+	log.Warnw("WindowPoSt paused", "miner", maddr, "deadline", dlIdx, "reason", reason)
+	_, err = t.pauses.paused(ctx, t.actors)
+	return err
+}
+
+// ResumeDeadline resumes WindowPoSt for a paused deadline, and schedules
+// compute for it right away if it is due.
+func (t *WdPostTask) ResumeDeadline(ctx context.Context, maddr address.Address, dlIdx uint64) error {
+	spID, err := pausedMinerID(maddr, dlIdx)
+	if err != nil {
+		return err
+	}
+	wasPaused, err := t.pauses.resume(ctx, spID, dlIdx)
+	if err != nil {
+		return err
+	}
+	if !wasPaused {
+		return xerrors.Errorf("deadline %d of %s isn't paused", dlIdx, maddr)
+	}
+
+	log.Warnw("WindowPoSt resumed", "miner", maddr, "deadline", dlIdx)
+	if _, err := t.pauses.paused(ctx, t.actors); err != nil {
+		return err
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+	di, err := t.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting proving deadline: %w", err)
+	}
+	if !di.PeriodStarted() {
+		return nil
+	}
+
+	margin := t.margin(maddr)
+	for _, dl := range []*dline.Info{di, wdpost.NextDeadline(di)} {
+		if dl.Index != dlIdx || head.Height() < effectiveWindow(dl, margin).ComputeAt {
+			continue
+		}
+		if err := t.schedulePartitions(ctx, maddr, spID, dl, head); err != nil {
+			return xerrors.Errorf("scheduling resumed deadline: %w", err)
+		}
+	}
+	return nil
+}
+
+// PausedDeadlines lists the deadlines WindowPoSt is paused for, for all
+// miners in the cluster.
+func (t *WdPostTask) PausedDeadlines(ctx context.Context) ([]api.WdPoStPause, error) {
+	return t.pauses.list(ctx)
+}
+
+func pausedMinerID(maddr address.Address, dlIdx uint64) (uint64, error) {
+	if dlIdx >= miner.WPoStPeriodDeadlines {
+		return 0, xerrors.Errorf("deadline %d out of range (%d deadlines)", dlIdx, miner.WPoStPeriodDeadlines)
+	}
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return 0, xerrors.Errorf("getting miner ID: %w", err)
+	}
+	return spID, nil
+}
+
+// BlockDeadlinePaused notes that WindowPoSt is paused for the deadline of a
+// compute task. Pausing stops scheduling, compute tasks already queued for the
+// deadline are still claimed.
+const BlockDeadlinePaused harmonytask.BlockCode = "deadline-paused"
+
+// DiagnoseTask adds the WindowPoSt specific reasons to harmonytask.Diagnose
+// for a compute task. It returns nothing for other tasks.
+func DiagnoseTask(ctx context.Context, db harmonydb.Interface, id harmonytask.TaskID) ([]harmonytask.BlockReason, error) {
+	var rows []pauseRow
+	err := db.Select(ctx, &rows, `SELECT p.sp_id, p.deadline_index, p.reason, p.paused_by, p.paused_at
+		FROM wdpost_partition_tasks t
+		JOIN wdpost_paused_deadlines p ON p.sp_id = t.sp_id AND p.deadline_index = t.deadline_index
+		WHERE t.task_id = $1`, id)
+	if err != nil {
+		return nil, xerrors.Errorf("reading deadline pauses: %w", err)
+	}
+
+	var out []harmonytask.BlockReason
+	for _, r := range rows {
+		out = append(out, harmonytask.BlockReason{
+			Code: BlockDeadlinePaused,
+			Detail: fmt.Sprintf("deadline %d of f0%d paused by %s at %s (%s), no new tasks are scheduled for it",
+				r.Deadline, r.SpID, r.PausedBy, r.PausedAt.Format(time.DateTime), r.Reason),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,51 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+func TestDeadlinePausesAlerts(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+	p := newDeadlinePauses(db, al)
+
+	ours, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	actors := []dtypes.MinerAddress{dtypes.MinerAddress(ours)}
+
+	now := time.Now()
+	db.ExpectSelect(`FROM wdpost_paused_deadlines`).WillReturnSelect([]pauseRow{
+		{SpID: 1000, Deadline: 3, Reason: "disk swap", PausedBy: "host-a", PausedAt: now},
+		{SpID: 2000, Deadline: 5, PausedBy: "host-b", PausedAt: now}, // not proven by this node
+	})
+
+	paused, err := p.paused(ctx, actors)
+	require.NoError(t, err)
+	require.Equal(t, map[pausedKey]struct{}{{SpID: 1000, Deadline: 3}: {}}, paused)
+
+	at := p.alerts[pausedKey{SpID: 1000, Deadline: 3}]
+	require.True(t, al.IsRaised(at))
+	require.Len(t, p.alerts, 1)
+
+	// resumed elsewhere in the cluster
+	db.ExpectSelect(`FROM wdpost_paused_deadlines`).WillReturnSelect([]pauseRow{})
+
+	paused, err = p.paused(ctx, actors)
+	require.NoError(t, err)
+	require.Empty(t, paused)
+	require.False(t, al.IsRaised(at))
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
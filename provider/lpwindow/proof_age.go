@@ -0,0 +1,148 @@
+package lpwindow
+
+import (
+	"context"
+	"strconv"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+)
+
+type ProofAgeAPI interface {
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+}
+
+// ProofAgeTracker exports, for every deadline of the miners, the number of
+// epochs since its proofs were last submitted. The submission history is read
+// from wdpost_proofs on every head change, so the values survive restarts and
+// are the same on every node. Deadlines without partitions report 0, deadlines
+// which were never proven by the cluster aren't reported.
+type ProofAgeTracker struct {
+	api    ProofAgeAPI
+	db     harmonydb.Interface
+	actors []dtypes.MinerAddress
+
+	// partition counts per miner and deadline index, refreshed every proving period
+	partitions map[address.Address]*deadlinePartitions
+}
+
+type lastSubmission struct {
+	Deadline    uint64         `db:"deadline"`
+	SubmittedAt abi.ChainEpoch `db:"submitted_at"`
+}
+
+type deadlinePartitions struct {
+	periodStart abi.ChainEpoch
+	counts      map[uint64]int
+}
+
+func NewProofAgeTracker(pcs *chainsched.ProviderChainSched, api ProofAgeAPI, db harmonydb.Interface, actors []dtypes.MinerAddress) (*ProofAgeTracker, error) {
+	t := &ProofAgeTracker{
+		api:    api,
+		db:     db,
+		actors: actors,
+
+		partitions: map[address.Address]*deadlinePartitions{},
+	}
+
+	if err := pcs.AddHandler(t.processHeadChange); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *ProofAgeTracker) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	for _, act := range t.actors {
+		maddr := address.Address(act)
+
+		ages, err := t.proofAges(ctx, maddr, apply)
+		if err != nil {
+			return xerrors.Errorf("computing proof ages of %s: %w", maddr, err)
+		}
+
+		for dl, age := range ages {
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{
+				tag.Upsert(metrics.MinerID, maddr.String()),
+				tag.Upsert(DeadlineKey, strconv.FormatUint(dl, 10)),
+			}, WdPostMeasures.EpochsSinceProof.M(int64(age)))
+		}
+	}
+
+	return nil
+}
+
+// proofAges returns the epochs since the last complete submission for each
+// deadline index which was proven at least once.
+func (t *ProofAgeTracker) proofAges(ctx context.Context, maddr address.Address, ts *types.TipSet) (map[uint64]abi.ChainEpoch, error) {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	// a deadline in a proving period counts as proven once a message was
+	// sent for every partition which had a proof computed
+	var last []lastSubmission
+	err = t.db.Select(ctx, &last, `SELECT deadline, MAX(submit_at_epoch) AS submitted_at FROM (
+			SELECT deadline, MAX(submit_at_epoch) AS submit_at_epoch FROM wdpost_proofs
+				WHERE sp_id = $1
+				GROUP BY deadline, proving_period_start
+				HAVING COUNT(*) = COUNT(message_cid)
+		) proven GROUP BY deadline`, spID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting last submissions: %w", err)
+	}
+
+	counts, err := t.partitionCounts(ctx, maddr, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[uint64]abi.ChainEpoch, len(last))
+	for _, l := range last {
+		age := ts.Height() - l.SubmittedAt
+		if age < 0 || counts[l.Deadline] == 0 {
+			age = 0
+		}
+		out[l.Deadline] = age
+	}
+
+	return out, nil
+}
+
+func (t *ProofAgeTracker) partitionCounts(ctx context.Context, maddr address.Address, ts *types.TipSet) (map[uint64]int, error) {
+	di, err := t.api.StateMinerProvingDeadline(ctx, maddr, ts.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	if p, ok := t.partitions[maddr]; ok && p.periodStart == di.PeriodStart {
+		return p.counts, nil
+	}
+
+	counts := map[uint64]int{}
+	for dl := uint64(0); dl < miner.WPoStPeriodDeadlines; dl++ {
+		parts, err := t.api.StateMinerPartitions(ctx, maddr, dl, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting partitions of deadline %d: %w", dl, err)
+		}
+		counts[dl] = len(parts)
+	}
+
+	t.partitions[maddr] = &deadlinePartitions{periodStart: di.PeriodStart, counts: counts}
+	return counts, nil
+}
@@ -0,0 +1,69 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type proofAgeAPI struct {
+	periodStart abi.ChainEpoch
+	partitions  map[uint64]int
+	calls       int
+}
+
+func (a *proofAgeAPI) StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error) {
+	return wdpost.NewDeadlineInfo(a.periodStart, 0, a.periodStart), nil
+}
+
+func (a *proofAgeAPI) StateMinerPartitions(ctx context.Context, maddr address.Address, dl uint64, tsk types.TipSetKey) ([]api.Partition, error) {
+	a.calls++
+	return make([]api.Partition, a.partitions[dl]), nil
+}
+
+func TestProofAges(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	papi := &proofAgeAPI{periodStart: 10000, partitions: map[uint64]int{0: 2, 1: 1}}
+	db := harmonydb.NewMock()
+	tr := &ProofAgeTracker{api: papi, db: db, partitions: map[address.Address]*deadlinePartitions{}}
+
+	ts := mock.TipSet(mock.MkBlock(nil, 1, 0))
+	head := ts.Height()
+
+	// deadline 2 has no partitions anymore, so it doesn't count as unproven
+	db.ExpectSelect(`FROM wdpost_proofs`).WithArgs(1000).WillReturnSelect([]lastSubmission{
+		{Deadline: 0, SubmittedAt: head - 100},
+		{Deadline: 1, SubmittedAt: head - 3000},
+		{Deadline: 2, SubmittedAt: head - 5000},
+	})
+
+	ages, err := tr.proofAges(context.Background(), maddr, ts)
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]abi.ChainEpoch{0: 100, 1: 3000, 2: 0}, ages)
+
+	// partition counts are cached for the proving period
+	calls := papi.calls
+	db.ExpectSelect(`FROM wdpost_proofs`).WithArgs(1000).WillReturnSelect([]lastSubmission{
+		{Deadline: 1, SubmittedAt: head},
+	})
+
+	ages, err = tr.proofAges(context.Background(), maddr, ts)
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]abi.ChainEpoch{1: 0}, ages)
+	require.Equal(t, calls, papi.calls)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
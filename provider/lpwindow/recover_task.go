@@ -33,7 +33,7 @@ type WdPostRecoverDeclareTask struct {
 	api          WdPostRecoverDeclareTaskApi
 	faultTracker sealer.FaultTracker
 
-	maxDeclareRecoveriesGasFee types.FIL
+	maxDeclareRecoveriesGasFee MaxFeeFunc
 	as                         *ctladdr.AddressSelector
 	actors                     []dtypes.MinerAddress
 
@@ -64,7 +64,7 @@ func NewWdPostRecoverDeclareTask(sender *lpmessage.Sender,
 	as *ctladdr.AddressSelector,
 	pcs *chainsched.ProviderChainSched,
 
-	maxDeclareRecoveriesGasFee types.FIL,
+	maxDeclareRecoveriesGasFee MaxFeeFunc,
 	actors []dtypes.MinerAddress) (*WdPostRecoverDeclareTask, error) {
 	t := &WdPostRecoverDeclareTask{
 		sender:       sender,
@@ -86,7 +86,7 @@ func NewWdPostRecoverDeclareTask(sender *lpmessage.Sender,
 
 func (w *WdPostRecoverDeclareTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
 	log.Debugw("WdPostRecoverDeclareTask.Do()", "taskID", taskID)
-	ctx := context.Background()
+	ctx := harmonytask.TaskContext(context.Background(), taskID)
 
 	var spID, pps, dlIdx, partIdx uint64
 
@@ -186,12 +186,12 @@ func (w *WdPostRecoverDeclareTask) Do(taskID harmonytask.TaskID, stillOwned func
 		Value:  types.NewInt(0),
 	}
 
-	msg, mss, err := preparePoStMessage(w.api, w.as, maddr, msg, abi.TokenAmount(w.maxDeclareRecoveriesGasFee))
+	msg, mss, err := preparePoStMessage(w.api, w.as, maddr, msg, abi.TokenAmount(w.maxDeclareRecoveriesGasFee(maddr, head.Height())), GasLimitBounds{})
 	if err != nil {
 		return false, xerrors.Errorf("sending declare recoveries message: %w", err)
 	}
 
-	mc, err := w.sender.Send(ctx, msg, mss, "declare-recoveries")
+	mc, err := w.sender.Send(ctx, lpmessage.IdempotencyKey(taskID, "declare-recoveries"), msg, mss, "declare-recoveries")
 	if err != nil {
 		return false, xerrors.Errorf("sending declare recoveries message: %w", err)
 	}
@@ -260,43 +260,76 @@ func (w *WdPostRecoverDeclareTask) processHeadChange(ctx context.Context, revert
 			pps = di.NextPeriodStart()
 		}
 
-		partitions, err := w.api.StateMinerPartitions(ctx, maddr, declDeadline, apply.Key())
+		if err := w.scheduleRecoveries(ctx, tf, maddr, aid, pps, declDeadline, apply.Key()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scheduleRecoveries adds a declare recovery task for each partition of the
+// deadline with faulty sectors not declared recovering yet.
+func (w *WdPostRecoverDeclareTask) scheduleRecoveries(ctx context.Context, tf harmonytask.AddTaskFunc, maddr address.Address, aid uint64, pps abi.ChainEpoch, declDeadline uint64, tsk types.TipSetKey) error {
+	partitions, err := w.api.StateMinerPartitions(ctx, maddr, declDeadline, tsk)
+	if err != nil {
+		return xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	for pidx, partition := range partitions {
+		unrecovered, err := bitfield.SubtractBitField(partition.FaultySectors, partition.RecoveringSectors)
+		if err != nil {
+			return xerrors.Errorf("subtracting recovered set from fault set: %w", err)
+		}
+
+		uc, err := unrecovered.Count()
 		if err != nil {
-			return xerrors.Errorf("getting partitions: %w", err)
+			return xerrors.Errorf("counting unrecovered sectors: %w", err)
 		}
 
-		for pidx, partition := range partitions {
-			unrecovered, err := bitfield.SubtractBitField(partition.FaultySectors, partition.RecoveringSectors)
-			if err != nil {
-				return xerrors.Errorf("subtracting recovered set from fault set: %w", err)
-			}
-
-			uc, err := unrecovered.Count()
-			if err != nil {
-				return xerrors.Errorf("counting unrecovered sectors: %w", err)
-			}
-
-			if uc == 0 {
-				log.Debugw("WdPostRecoverDeclareTask.processHeadChange() uc == 0, skipping", "maddr", maddr, "declDeadline", declDeadline, "pidx", pidx)
-				continue
-			}
-
-			tid := wdTaskIdentity{
-				SpID:               aid,
-				ProvingPeriodStart: pps,
-				DeadlineIndex:      declDeadline,
-				PartitionIndex:     uint64(pidx),
-			}
-
-			tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
-				return w.addTaskToDB(id, tid, tx)
-			})
+		if uc == 0 {
+			log.Debugw("WdPostRecoverDeclareTask.processHeadChange() uc == 0, skipping", "maddr", maddr, "declDeadline", declDeadline, "pidx", pidx)
+			continue
 		}
+
+		tid := wdTaskIdentity{
+			SpID:               aid,
+			ProvingPeriodStart: pps,
+			DeadlineIndex:      declDeadline,
+			PartitionIndex:     uint64(pidx),
+		}
+
+		tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+			return w.addTaskToDB(id, tid, tx)
+		})
 	}
 
 	return nil
 }
 
+// RecoverDeadline schedules declaring the faults of the deadline recovered
+// before its next challenge window, instead of waiting until it is two
+// deadlines ahead. Declarations for windows whose fault cutoff passed are
+// dropped by the task.
+func (w *WdPostRecoverDeclareTask) RecoverDeadline(ctx context.Context, maddr address.Address, dlIdx uint64, ts *types.TipSet) error {
+	aid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	di, err := w.api.StateMinerProvingDeadline(ctx, maddr, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	pps := di.PeriodStart
+	if dlIdx <= di.Index {
+		pps = di.NextPeriodStart()
+	}
+
+	return w.scheduleRecoveries(ctx, w.startCheckTF.Val(ctx), maddr, aid, pps, dlIdx, ts.Key())
+}
+
 func (w *WdPostRecoverDeclareTask) addTaskToDB(taskId harmonytask.TaskID, taskIdent wdTaskIdentity, tx *harmonydb.Tx) (bool, error) {
 	_, err := tx.Exec(
 		`INSERT INTO wdpost_recovery_tasks (
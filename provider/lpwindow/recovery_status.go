@@ -0,0 +1,218 @@
+package lpwindow
+
+import (
+	"context"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+)
+
+type RecoveryStatusAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+}
+
+// MinerRecoveries is the fault recovery state of a miner, see RecoveryStatus.
+type MinerRecoveries struct {
+	Miner  address.Address
+	Height abi.ChainEpoch
+
+	// Faulty and Recovering count the sectors of all partitions; recovering
+	// sectors are faulty sectors declared recovered, which are proven again
+	// in the next challenge window of their deadline.
+	Faulty     uint64
+	Recovering uint64
+
+	Partitions []PartitionRecovery
+}
+
+// PartitionRecovery is a partition with faults, or with recoveries declared
+// by the cluster.
+type PartitionRecovery struct {
+	Deadline  uint64
+	Partition uint64
+
+	FaultySectors     []uint64
+	RecoveringSectors []uint64
+
+	Declarations []RecoveryDeclaration
+}
+
+// RecoveryDeclaration is a recovery task of the partition, along with the
+// DeclareFaultsRecovered message the task sent.
+type RecoveryDeclaration struct {
+	TaskID      int64
+	PeriodStart abi.ChainEpoch
+
+	// State is one of:
+	//  - queued: the task waits for a node to run it
+	//  - running: a node is checking the faulty sectors
+	//  - no-message: the task finished without declaring anything, as none
+	//    of the faulty sectors could be read, or the fault cutoff passed
+	//  - pending: the message was sent and isn't executed yet
+	//  - executed: the message was executed successfully
+	//  - failed: the message execution failed
+	State string
+
+	Message    string         `json:",omitempty"`
+	ExecutedAt abi.ChainEpoch `json:",omitempty"`
+	ExitCode   *int64         `json:",omitempty"`
+}
+
+type recoveryRow struct {
+	TaskID      int64          `db:"task_id"`
+	PeriodStart abi.ChainEpoch `db:"proving_period_start"`
+	Deadline    uint64         `db:"deadline_index"`
+	Partition   uint64         `db:"partition_index"`
+
+	Queued    bool    `db:"queued"`
+	OwnerID   *int64  `db:"owner_id"`
+	SignedCid *string `db:"signed_cid"`
+	ExecEpoch *int64  `db:"executed_tsk_epoch"`
+	ExitCode  *int64  `db:"executed_rcpt_exitcode"`
+}
+
+// RecoveryStatus aggregates the faulty and recovering sectors of each
+// partition of the miner, read from chain, with the recovery declarations
+// the cluster made for the current or next proving period, read from
+// wdpost_recovery_tasks and the message tracking tables.
+func RecoveryStatus(ctx context.Context, api RecoveryStatusAPI, db harmonydb.Interface, maddr address.Address) (*MinerRecoveries, error) {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	head, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	di, err := api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	var rows []recoveryRow
+	err = db.Select(ctx, &rows, `SELECT rt.task_id, rt.proving_period_start, rt.deadline_index, rt.partition_index,
+			ht.id IS NOT NULL AS queued, ht.owner_id, ms.signed_cid, mw.executed_tsk_epoch, mw.executed_rcpt_exitcode
+		FROM wdpost_recovery_tasks rt
+			LEFT JOIN harmony_task ht ON ht.id = rt.task_id
+			LEFT JOIN message_sends ms ON ms.idempotency_key = rt.task_id || ':declare-recoveries' AND ms.send_success IS NOT FALSE
+			LEFT JOIN message_waits mw ON mw.signed_message_cid = ms.signed_cid
+		WHERE rt.sp_id = $1 AND rt.proving_period_start >= $2
+		ORDER BY rt.proving_period_start, rt.task_id`, spID, di.PeriodStart)
+	if err != nil {
+		return nil, xerrors.Errorf("reading recovery declarations: %w", err)
+	}
+
+	type partKey struct{ dl, part uint64 }
+	decls := map[partKey][]RecoveryDeclaration{}
+	for _, r := range rows {
+		k := partKey{r.Deadline, r.Partition}
+		decls[k] = append(decls[k], r.declaration())
+	}
+
+	out := &MinerRecoveries{
+		Miner:  maddr,
+		Height: head.Height(),
+	}
+
+	for dl := uint64(0); dl < di.WPoStPeriodDeadlines; dl++ {
+		parts, err := api.StateMinerPartitions(ctx, maddr, dl, head.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting partitions of deadline %d: %w", dl, err)
+		}
+
+		for pi, p := range parts {
+			k := partKey{dl, uint64(pi)}
+
+			faulty, err := sectorList(p.FaultySectors)
+			if err != nil {
+				return nil, xerrors.Errorf("reading faults of deadline %d partition %d: %w", dl, pi, err)
+			}
+			recovering, err := sectorList(p.RecoveringSectors)
+			if err != nil {
+				return nil, xerrors.Errorf("reading recoveries of deadline %d partition %d: %w", dl, pi, err)
+			}
+
+			out.Faulty += uint64(len(faulty))
+			out.Recovering += uint64(len(recovering))
+
+			if len(faulty) == 0 && len(decls[k]) == 0 {
+				continue
+			}
+			out.Partitions = append(out.Partitions, PartitionRecovery{
+				Deadline:          dl,
+				Partition:         uint64(pi),
+				FaultySectors:     faulty,
+				RecoveringSectors: recovering,
+				Declarations:      decls[k],
+			})
+			delete(decls, k)
+		}
+	}
+
+	// declarations of partitions which were compacted away since
+	for k, d := range decls {
+		out.Partitions = append(out.Partitions, PartitionRecovery{
+			Deadline:     k.dl,
+			Partition:    k.part,
+			Declarations: d,
+		})
+	}
+	sort.Slice(out.Partitions, func(i, j int) bool {
+		if out.Partitions[i].Deadline != out.Partitions[j].Deadline {
+			return out.Partitions[i].Deadline < out.Partitions[j].Deadline
+		}
+		return out.Partitions[i].Partition < out.Partitions[j].Partition
+	})
+
+	return out, nil
+}
+
+func (r recoveryRow) declaration() RecoveryDeclaration {
+	d := RecoveryDeclaration{
+		TaskID:      r.TaskID,
+		PeriodStart: r.PeriodStart,
+		ExitCode:    r.ExitCode,
+	}
+	if r.SignedCid != nil {
+		d.Message = *r.SignedCid
+	}
+	if r.ExecEpoch != nil {
+		d.ExecutedAt = abi.ChainEpoch(*r.ExecEpoch)
+	}
+
+	switch {
+	case r.Queued && r.OwnerID != nil:
+		d.State = "running"
+	case r.Queued:
+		d.State = "queued"
+	case r.SignedCid == nil:
+		d.State = "no-message"
+	case r.ExitCode == nil:
+		d.State = "pending"
+	case *r.ExitCode == 0:
+		d.State = "executed"
+	default:
+		d.State = "failed"
+	}
+	return d
+}
+
+func sectorList(bf bitfield.BitField) ([]uint64, error) {
+	if n, err := bf.Count(); err != nil || n == 0 {
+		return nil, err
+	}
+	return bf.All(abi.MaxSectorNumber)
+}
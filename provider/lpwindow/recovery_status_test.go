@@ -0,0 +1,104 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type recoveryStatusAPI struct {
+	head       *types.TipSet
+	partitions map[uint64][]api.Partition
+}
+
+func (a *recoveryStatusAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return a.head, nil
+}
+
+func (a *recoveryStatusAPI) StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error) {
+	return wdpost.NewDeadlineInfo(10000, 0, 10000), nil
+}
+
+func (a *recoveryStatusAPI) StateMinerPartitions(ctx context.Context, maddr address.Address, dl uint64, tsk types.TipSetKey) ([]api.Partition, error) {
+	return a.partitions[dl], nil
+}
+
+func TestRecoveryStatus(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	rapi := &recoveryStatusAPI{
+		head: mock.TipSet(mock.MkBlock(nil, 1, 0)),
+		partitions: map[uint64][]api.Partition{
+			// no faults
+			0: {{}},
+			// faults in the second partition, one sector declared recovered
+			3: {{}, {FaultySectors: bitfield.NewFromSet([]uint64{7, 9}), RecoveringSectors: bitfield.NewFromSet([]uint64{9})}},
+			5: {{FaultySectors: bitfield.NewFromSet([]uint64{20})}},
+		},
+	}
+
+	str := func(s string) *string { return &s }
+	i64 := func(i int64) *int64 { return &i }
+
+	db := harmonydb.NewMock()
+	db.ExpectSelect(`FROM wdpost_recovery_tasks`).WithArgs(uint64(1000), abi.ChainEpoch(10000)).WillReturnSelect([]recoveryRow{
+		{TaskID: 1, PeriodStart: 10000, Deadline: 3, Partition: 1, SignedCid: str("bafymsg1"), ExecEpoch: i64(10050), ExitCode: i64(0)},
+		{TaskID: 2, PeriodStart: 10000, Deadline: 5, Partition: 0, Queued: true},
+		{TaskID: 3, PeriodStart: 10000, Deadline: 5, Partition: 0, Queued: true, OwnerID: i64(4)},
+		{TaskID: 4, PeriodStart: 10000, Deadline: 7, Partition: 0},
+		{TaskID: 5, PeriodStart: 10000, Deadline: 7, Partition: 0, SignedCid: str("bafymsg5")},
+		{TaskID: 6, PeriodStart: 10000, Deadline: 7, Partition: 0, SignedCid: str("bafymsg6"), ExecEpoch: i64(10060), ExitCode: i64(16)},
+	})
+
+	st, err := RecoveryStatus(context.Background(), rapi, db, maddr)
+	require.NoError(t, err)
+	require.NoError(t, db.ExpectationsWereMet())
+
+	require.Equal(t, uint64(3), st.Faulty)
+	require.Equal(t, uint64(1), st.Recovering)
+
+	require.Len(t, st.Partitions, 3)
+
+	p := st.Partitions[0]
+	require.Equal(t, uint64(3), p.Deadline)
+	require.Equal(t, uint64(1), p.Partition)
+	require.Equal(t, []uint64{7, 9}, p.FaultySectors)
+	require.Equal(t, []uint64{9}, p.RecoveringSectors)
+	require.Len(t, p.Declarations, 1)
+	require.Equal(t, "executed", p.Declarations[0].State)
+	require.Equal(t, "bafymsg1", p.Declarations[0].Message)
+	require.Equal(t, abi.ChainEpoch(10050), p.Declarations[0].ExecutedAt)
+
+	p = st.Partitions[1]
+	require.Equal(t, uint64(5), p.Deadline)
+	require.Equal(t, []uint64{20}, p.FaultySectors)
+	require.Nil(t, p.RecoveringSectors)
+	require.Equal(t, []string{"queued", "running"}, declStates(p.Declarations))
+
+	// the partition is gone from chain, declarations are still reported
+	p = st.Partitions[2]
+	require.Equal(t, uint64(7), p.Deadline)
+	require.Nil(t, p.FaultySectors)
+	require.Equal(t, []string{"no-message", "pending", "failed"}, declStates(p.Declarations))
+}
+
+func declStates(d []RecoveryDeclaration) []string {
+	var out []string
+	for _, r := range d {
+		out = append(out, r.State)
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
+	proof7 "github.com/filecoin-project/specs-actors/v7/actors/runtime/proof"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+// ReplayResult is the outcome of recomputing the proof of one partition.
+type ReplayResult struct {
+	Partition uint64
+	// Sectors is the number of sectors the proof was over
+	Sectors int
+	// Skipped are the sectors left out of the proof, because they failed the
+	// provable check or couldn't be read while proving
+	Skipped []abi.SectorNumber
+	Valid   bool
+	Elapsed time.Duration
+	Error   string `json:",omitempty"`
+}
+
+// ReplayDeadline recomputes the proofs for the partitions of a past deadline
+// and verifies them locally, without writing or submitting anything. The
+// deadline is the last one with index dlIdx which opened at or before epoch.
+// Sectors and partitions are taken from the chain state at its challenge
+// epoch, and the proofs use the challenge randomness of that deadline, so
+// they are the proofs the node would have submitted with the storage as it
+// is now.
+func (t *WdPostTask) ReplayDeadline(ctx context.Context, maddr address.Address, dlIdx uint64, epoch abi.ChainEpoch) (*dline.Info, []ReplayResult, error) {
+	if dlIdx >= miner.WPoStPeriodDeadlines {
+		return nil, nil, xerrors.Errorf("deadline %d out of range (%d deadlines)", dlIdx, miner.WPoStPeriodDeadlines)
+	}
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+	if epoch > head.Height() {
+		return nil, nil, xerrors.Errorf("epoch %d is after the chain head %d", epoch, head.Height())
+	}
+
+	at, err := t.api.ChainGetTipSetAfterHeight(ctx, epoch, head.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting tipset at epoch %d: %w", epoch, err)
+	}
+	cur, err := t.api.StateMinerProvingDeadline(ctx, maddr, at.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+
+	di := wdpost.NewDeadlineInfo(cur.PeriodStart, dlIdx, head.Height())
+	if di.Open > epoch {
+		di = wdpost.NewDeadlineInfo(cur.PeriodStart-miner.WPoStProvingPeriod(), dlIdx, head.Height())
+	}
+	if di.Challenge >= head.Height() {
+		return nil, nil, xerrors.Errorf("deadline %d challenge at epoch %d isn't on chain yet (head %d)", dlIdx, di.Challenge, head.Height())
+	}
+
+	ts, err := t.api.ChainGetTipSetAfterHeight(ctx, di.Challenge, head.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting tipset at challenge epoch %d: %w", di.Challenge, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := maddr.MarshalCBOR(buf); err != nil {
+		return nil, nil, xerrors.Errorf("failed to marshal address to cbor: %w", err)
+	}
+	rand, err := t.rand.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), head.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting challenge randomness: %w", err)
+	}
+
+	nv, err := t.api.StateNetworkVersion(ctx, ts.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting network version: %w", err)
+	}
+
+	parts, err := t.api.StateMinerPartitions(ctx, maddr, di.Index, ts.Key())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting partitions: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, nil, xerrors.Errorf("deadline %d had no partitions", dlIdx)
+	}
+
+	out := make([]ReplayResult, 0, len(parts))
+	for pidx, part := range parts {
+		res := ReplayResult{Partition: uint64(pidx)}
+		start := time.Now()
+		if err := t.replayPartition(paths.WithTaskFetchLimit(ctx, t.maxFetches), maddr, abi.ActorID(mid), ts, nv, part, rand, &res); err != nil {
+			res.Error = err.Error()
+		}
+		res.Elapsed = time.Since(start)
+
+		log.Infow("replayed partition proof", "miner", maddr, "deadline", di.Index, "periodStart", di.PeriodStart, "partition", pidx,
+			"valid", res.Valid, "sectors", res.Sectors, "skipped", len(res.Skipped), "elapsed", res.Elapsed, "error", res.Error)
+		out = append(out, res)
+	}
+
+	return di, out, nil
+}
+
+func (t *WdPostTask) replayPartition(ctx context.Context, maddr address.Address, mid abi.ActorID, ts *types.TipSet, nv network.Version,
+	part api.Partition, rand abi.Randomness, res *ReplayResult) error {
+	toProve, err := bitfield.SubtractBitField(part.LiveSectors, part.FaultySectors)
+	if err != nil {
+		return xerrors.Errorf("removing faults from set of sectors to prove: %w", err)
+	}
+	toProve, err = bitfield.MergeBitFields(toProve, part.RecoveringSectors)
+	if err != nil {
+		return xerrors.Errorf("adding recoveries to set of sectors to prove: %w", err)
+	}
+
+	good, err := checkSectors(ctx, t.api, t.faultTracker, maddr, toProve, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("checking sectors: %w", err)
+	}
+	bad, err := bitfield.SubtractBitField(toProve, good)
+	if err != nil {
+		return xerrors.Errorf("toProve - good: %w", err)
+	}
+	if err := bad.ForEach(func(s uint64) error {
+		res.Skipped = append(res.Skipped, abi.SectorNumber(s))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	xsinfos, err := t.sectorsForProof(ctx, maddr, good, part.AllSectors, ts)
+	if err != nil {
+		return xerrors.Errorf("getting sector info: %w", err)
+	}
+	if len(xsinfos) == 0 {
+		return xerrors.Errorf("no sectors to prove")
+	}
+
+	ppt, err := xsinfos[0].SealProof.RegisteredWindowPoStProofByNetworkVersion(nv)
+	if err != nil {
+		return xerrors.Errorf("failed to get window post type: %w", err)
+	}
+
+	proofs, skipped, err := t.generateWindowPoSt(ctx, ppt, mid, xsinfos, append(abi.PoStRandomness{}, rand...))
+	for _, s := range skipped {
+		res.Skipped = append(res.Skipped, s.Number)
+	}
+	if err != nil {
+		return xerrors.Errorf("generating proof: %w", err)
+	}
+	if len(proofs) == 0 {
+		return xerrors.Errorf("received no proofs back from generate window post")
+	}
+
+	skippedSet := make(map[abi.SectorNumber]struct{}, len(skipped))
+	for _, s := range skipped {
+		skippedSet[s.Number] = struct{}{}
+	}
+	sinfos := make([]proof7.SectorInfo, 0, len(xsinfos))
+	for _, xsi := range xsinfos {
+		if _, ok := skippedSet[xsi.SectorNumber]; ok {
+			continue
+		}
+		sinfos = append(sinfos, proof7.SectorInfo{
+			SealProof:    xsi.SealProof,
+			SectorNumber: xsi.SectorNumber,
+			SealedCID:    xsi.SealedCID,
+		})
+	}
+	res.Sectors = len(sinfos)
+
+	res.Valid, err = t.verifier.VerifyWindowPoSt(ctx, proof.WindowPoStVerifyInfo{
+		Randomness:        abi.PoStRandomness(rand),
+		Proofs:            proofs,
+		ChallengedSectors: sinfos,
+		Prover:            mid,
+	})
+	if err != nil {
+		return xerrors.Errorf("verifying proof: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package lpwindow
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type SectorSyncAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateMinerActiveSectors(context.Context, address.Address, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
+}
+
+// Redeclarer rescans local storage paths and declares the sectors found in
+// them in the index, see paths.Local.Redeclare.
+type Redeclarer interface {
+	Redeclare(ctx context.Context, filterId *storiface.ID, dropMissingDecls bool) error
+}
+
+// SectorSync follows the live sector set of the miners on chain, so that
+// sectors sealed by a separate lotus-miner or worker are proven as soon as
+// they are committed. WindowPoSt tasks read the sector set from chain on
+// every deadline, but can only prove sectors the storage index knows about;
+// when new sectors aren't in the index, local storage paths are rescanned to
+// pick up files written to them by other processes.
+type SectorSync struct {
+	api   SectorSyncAPI
+	idx   paths.SectorIndex
+	local Redeclarer
+
+	actors []dtypes.MinerAddress
+
+	// known is the live sector set seen on the last sync, per miner
+	known map[address.Address]map[abi.SectorNumber]struct{}
+}
+
+func NewSectorSync(api SectorSyncAPI, idx paths.SectorIndex, local Redeclarer, actors []dtypes.MinerAddress) *SectorSync {
+	return &SectorSync{
+		api:    api,
+		idx:    idx,
+		local:  local,
+		actors: actors,
+		known:  map[address.Address]map[abi.SectorNumber]struct{}{},
+	}
+}
+
+// Run syncs the sector sets every interval until ctx is cancelled.
+func (s *SectorSync) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(ctx); err != nil {
+			log.Errorw("syncing sector sets", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync reconciles the live sector set of every miner with the last one seen,
+// and with the storage index.
+func (s *SectorSync) Sync(ctx context.Context) error {
+	ts, err := s.api.ChainHead(ctx)
+	if err != nil {
+		return xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	for _, act := range s.actors {
+		maddr := address.Address(act)
+		if err := s.syncMiner(ctx, ts, maddr); err != nil {
+			return xerrors.Errorf("syncing sectors of %s: %w", maddr, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SectorSync) syncMiner(ctx context.Context, ts *types.TipSet, maddr address.Address) error {
+	mi, err := s.api.StateMinerInfo(ctx, maddr, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting miner info: %w", err)
+	}
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return err
+	}
+
+	sectors, err := s.api.StateMinerActiveSectors(ctx, maddr, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	live := make(map[abi.SectorNumber]struct{}, len(sectors))
+	for _, si := range sectors {
+		live[si.SectorNumber] = struct{}{}
+	}
+
+	prev, synced := s.known[maddr]
+	s.known[maddr] = live
+
+	added, removed := diffSectorSets(prev, live)
+	if !synced {
+		log.Infow("loaded live sector set", "miner", maddr, "sectors", len(live))
+	} else {
+		for _, n := range added {
+			log.Infow("discovered new sector", "miner", maddr, "sector", n)
+		}
+		for _, n := range removed {
+			log.Infow("sector removed from live set", "miner", maddr, "sector", n)
+		}
+	}
+
+	missing, err := notIndexed(ctx, s.idx, abi.ActorID(mid), mi.SectorSize, added)
+	if err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	log.Infow("new sectors not in the storage index, rescanning local storage", "miner", maddr, "sectors", len(missing))
+	if err := s.local.Redeclare(ctx, nil, false); err != nil {
+		return xerrors.Errorf("redeclaring local storage: %w", err)
+	}
+
+	missing, err = notIndexed(ctx, s.idx, abi.ActorID(mid), mi.SectorSize, missing)
+	if err != nil {
+		return err
+	}
+	for _, n := range missing {
+		log.Warnw("live sector not found in any storage path, it can't be proven", "miner", maddr, "sector", n)
+	}
+
+	return nil
+}
+
+// notIndexed returns the sectors for which the index has no sealed and cache
+// files.
+func notIndexed(ctx context.Context, idx paths.SectorIndex, mid abi.ActorID, ssize abi.SectorSize, sectors []abi.SectorNumber) ([]abi.SectorNumber, error) {
+	var out []abi.SectorNumber
+	for _, n := range sectors {
+		sid := abi.SectorID{Miner: mid, Number: n}
+
+		found := true
+		for _, ft := range []storiface.SectorFileType{storiface.FTSealed | storiface.FTUpdate, storiface.FTCache | storiface.FTUpdateCache} {
+			si, err := idx.StorageFindSector(ctx, sid, ft, ssize, false)
+			if err != nil {
+				return nil, xerrors.Errorf("finding sector %d in index: %w", n, err)
+			}
+			if len(si) == 0 {
+				found = false
+				break
+			}
+		}
+		if !found {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// diffSectorSets returns the sectors which are in cur but not prev, and the
+// ones which are in prev but not cur, in ascending order.
+func diffSectorSets(prev, cur map[abi.SectorNumber]struct{}) (added, removed []abi.SectorNumber) {
+	for n := range cur {
+		if _, ok := prev[n]; !ok {
+			added = append(added, n)
+		}
+	}
+	for n := range prev {
+		if _, ok := cur[n]; !ok {
+			removed = append(removed, n)
+		}
+	}
+
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed
+}
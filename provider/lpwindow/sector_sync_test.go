@@ -0,0 +1,127 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type syncAPI struct {
+	sectors []abi.SectorNumber
+}
+
+func (a *syncAPI) ChainHead(context.Context) (*types.TipSet, error) {
+	return &types.TipSet{}, nil
+}
+
+func (a *syncAPI) StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error) {
+	return api.MinerInfo{SectorSize: 2048}, nil
+}
+
+func (a *syncAPI) StateMinerActiveSectors(context.Context, address.Address, types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	var out []*miner.SectorOnChainInfo
+	for _, n := range a.sectors {
+		out = append(out, &miner.SectorOnChainInfo{SectorNumber: n})
+	}
+	return out, nil
+}
+
+// syncIndex knows about the sectors in indexed, and moves the ones in
+// onDisk to indexed when local storage is redeclared.
+type syncIndex struct {
+	paths.SectorIndex
+
+	indexed    map[abi.SectorNumber]bool
+	onDisk     map[abi.SectorNumber]bool
+	redeclares int
+}
+
+func (i *syncIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft storiface.SectorFileType, ssize abi.SectorSize, allowFetch bool) ([]storiface.SectorStorageInfo, error) {
+	if i.indexed[s.Number] {
+		return []storiface.SectorStorageInfo{{ID: "local"}}, nil
+	}
+	return nil, nil
+}
+
+func (i *syncIndex) Redeclare(ctx context.Context, filterId *storiface.ID, dropMissingDecls bool) error {
+	i.redeclares++
+	for n := range i.onDisk {
+		i.indexed[n] = true
+	}
+	return nil
+}
+
+func TestSectorSync(t *testing.T) {
+	ctx := context.Background()
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	sapi := &syncAPI{sectors: []abi.SectorNumber{1, 2, 3}}
+	idx := &syncIndex{
+		indexed: map[abi.SectorNumber]bool{1: true, 2: true, 3: true},
+		onDisk:  map[abi.SectorNumber]bool{},
+	}
+	ss := NewSectorSync(sapi, idx, idx, []dtypes.MinerAddress{dtypes.MinerAddress(maddr)})
+
+	// everything indexed, no rescan
+	require.NoError(t, ss.Sync(ctx))
+	require.Equal(t, 0, idx.redeclares)
+
+	// sectors 4 and 5 get sealed elsewhere, 4 is written to local storage
+	// but not yet declared, sector 2 expires
+	sapi.sectors = []abi.SectorNumber{1, 3, 4, 5}
+	idx.onDisk[4] = true
+	require.NoError(t, ss.Sync(ctx))
+	require.Equal(t, 1, idx.redeclares)
+	require.True(t, idx.indexed[4])
+	require.False(t, idx.indexed[5])
+
+	added, removed := diffSectorSets(map[abi.SectorNumber]struct{}{1: {}, 2: {}, 3: {}}, ss.known[maddr])
+	require.Equal(t, []abi.SectorNumber{4, 5}, added)
+	require.Equal(t, []abi.SectorNumber{2}, removed)
+
+	// unchanged set, nothing new to look for
+	require.NoError(t, ss.Sync(ctx))
+	require.Equal(t, 1, idx.redeclares)
+}
+
+func TestCheckSectorStorage(t *testing.T) {
+	ctx := context.Background()
+
+	m1, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	m2, err := address.NewIDAddress(1001)
+	require.NoError(t, err)
+
+	sapi := &syncAPI{sectors: []abi.SectorNumber{1, 2, 3, 4}}
+	idx := &syncIndex{
+		indexed: map[abi.SectorNumber]bool{1: true, 3: true},
+		onDisk:  map[abi.SectorNumber]bool{2: true},
+	}
+
+	missing, err := CheckSectorStorage(ctx, sapi, idx, []dtypes.MinerAddress{dtypes.MinerAddress(m1), dtypes.MinerAddress(m2)})
+	require.NoError(t, err)
+	require.Equal(t, map[address.Address][]abi.SectorNumber{
+		m1: {2, 4},
+		m2: {2, 4},
+	}, missing)
+	// the check only reads the index
+	require.Equal(t, 0, idx.redeclares)
+
+	idx.indexed[2], idx.indexed[4] = true, true
+	missing, err = CheckSectorStorage(ctx, sapi, idx, []dtypes.MinerAddress{dtypes.MinerAddress(m1)})
+	require.NoError(t, err)
+	require.Empty(t, missing)
+}
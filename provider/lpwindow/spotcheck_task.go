@@ -0,0 +1,220 @@
+package lpwindow
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/sealer"
+)
+
+type SpotCheckAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerActiveSectors(context.Context, address.Address, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
+	CheckSectorsAPI
+}
+
+// SpotCheckTask periodically checks a random sample of each miner's active
+// sectors for readability, so that storage problems are found before
+// a WindowPoSt deadline runs into them. Results are recorded in
+// wdpost_spot_checks, and unreadable sectors raise an alert.
+//
+// The check is low priority: it's not accepted while any WindowPoSt work is
+// waiting in the cluster.
+type SpotCheckTask struct {
+	api          SpotCheckAPI
+	db           *harmonydb.DB
+	faultTracker sealer.FaultTracker
+
+	actors   []dtypes.MinerAddress
+	interval time.Duration
+	sample   int
+
+	al *alerting.Alerting
+
+	checkTF promise.Promise[harmonytask.AddTaskFunc]
+}
+
+func NewSpotCheckTask(ctx context.Context, db *harmonydb.DB, api SpotCheckAPI, faultTracker sealer.FaultTracker,
+	al *alerting.Alerting, actors []dtypes.MinerAddress, interval time.Duration, sample int) *SpotCheckTask {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	t := &SpotCheckTask{
+		api:          api,
+		db:           db,
+		faultTracker: faultTracker,
+
+		actors:   actors,
+		interval: interval,
+		sample:   sample,
+
+		al: al,
+	}
+
+	go t.schedule(ctx)
+
+	return t
+}
+
+func (t *SpotCheckTask) schedule(ctx context.Context) {
+	for {
+		period := time.Now().UTC().Truncate(t.interval)
+
+		tf := t.checkTF.Val(ctx)
+		for _, act := range t.actors {
+			spID, err := address.IDFromAddress(address.Address(act))
+			if err != nil {
+				log.Errorw("spot check: getting miner ID", "miner", act, "error", err)
+				continue
+			}
+
+			tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+				n, err := tx.Exec(`INSERT INTO wdpost_spot_check_tasks (task_id, sp_id, period) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, id, spID, period)
+				if err != nil {
+					return false, err
+				}
+
+				// already scheduled by another node
+				return n == 1, nil
+			})
+		}
+
+		select {
+		case <-time.After(time.Until(period.Add(t.interval))):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *SpotCheckTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var spID uint64
+	err = t.db.QueryRow(ctx, `SELECT sp_id FROM wdpost_spot_check_tasks WHERE task_id = $1`, taskID).Scan(&spID)
+	if err != nil {
+		return false, xerrors.Errorf("getting spot check task: %w", err)
+	}
+
+	maddr, err := address.NewIDAddress(spID)
+	if err != nil {
+		return false, err
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	active, err := t.api.StateMinerActiveSectors(ctx, maddr, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("getting active sectors: %w", err)
+	}
+
+	rand.Shuffle(len(active), func(i, j int) { active[i], active[j] = active[j], active[i] })
+	if len(active) > t.sample {
+		active = active[:t.sample]
+	}
+
+	toCheck := bitfield.New()
+	for _, s := range active {
+		toCheck.Set(uint64(s.SectorNumber))
+	}
+
+	good, reasons, err := checkSectorsWithReasons(ctx, t.api, t.faultTracker, maddr, toCheck, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("checking sectors: %w", err)
+	}
+
+	var bad []uint64
+	for _, s := range active {
+		ok, err := good.IsSet(uint64(s.SectorNumber))
+		if err != nil {
+			return false, err
+		}
+
+		var errStr *string
+		if !ok {
+			bad = append(bad, uint64(s.SectorNumber))
+			msg, found := reasons[s.SectorNumber]
+			if !found {
+				// not checked on storage, e.g. terminated since the head was read
+				msg = "sector not found on chain"
+			}
+			errStr = &msg
+		}
+
+		_, err = t.db.Exec(ctx, `INSERT INTO wdpost_spot_checks (sp_id, sector_number, ok, err) VALUES ($1, $2, $3, $4)`,
+			spID, s.SectorNumber, ok, errStr)
+		if err != nil {
+			return false, xerrors.Errorf("recording spot check result: %w", err)
+		}
+	}
+
+	at := t.al.AddAlertType("lpwindow", "spot-check-"+maddr.String())
+
+	if len(bad) > 0 {
+		log.Errorw("spot check found unreadable sectors", "miner", maddr, "checked", len(active), "bad", bad)
+		t.al.Raise(at, map[string]interface{}{
+			"miner":   maddr.String(),
+			"checked": len(active),
+			"bad":     bad,
+		})
+	} else if t.al.IsRaised(at) {
+		t.al.Resolve(at, map[string]interface{}{
+			"miner":   maddr.String(),
+			"checked": len(active),
+		})
+	}
+
+	return true, nil
+}
+
+func (t *SpotCheckTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	// never compete with real proving work
+	var pending int
+	err := t.db.QueryRow(context.Background(), `SELECT COUNT(*) FROM harmony_task WHERE name IN ('WdPost', 'WdPostSubmit', 'WdPostRecover')`).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, nil
+	}
+
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *SpotCheckTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Name:        "WdPostSpotChk",
+		Max:         1,
+		MaxFailures: 3,
+		Cost: resources.Resources{
+			Cpu: 1,
+			Gpu: 0,
+			Ram: 1 << 30,
+		},
+	}
+}
+
+func (t *SpotCheckTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	t.checkTF.Set(taskFunc)
+}
+
+var _ harmonytask.TaskInterface = &SpotCheckTask{}
@@ -0,0 +1,59 @@
+package lpwindow
+
+import (
+	"context"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/paths"
+)
+
+// CheckSectorStorage cross-references the live sector sets of the miners on
+// chain with the storage index, and returns the sectors of each miner which
+// have no sealed or cache files in any declared storage path. Such sectors
+// can't be proven, so their deadlines will be faulted. Miners with all their
+// sectors found are left out.
+func CheckSectorStorage(ctx context.Context, api SectorSyncAPI, idx paths.SectorIndex, actors []dtypes.MinerAddress) (map[address.Address][]abi.SectorNumber, error) {
+	ts, err := api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	out := map[address.Address][]abi.SectorNumber{}
+	for _, act := range actors {
+		maddr := address.Address(act)
+
+		mi, err := api.StateMinerInfo(ctx, maddr, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting miner info of %s: %w", maddr, err)
+		}
+		mid, err := address.IDFromAddress(maddr)
+		if err != nil {
+			return nil, err
+		}
+
+		sectors, err := api.StateMinerActiveSectors(ctx, maddr, ts.Key())
+		if err != nil {
+			return nil, xerrors.Errorf("getting active sectors of %s: %w", maddr, err)
+		}
+
+		nums := make([]abi.SectorNumber, len(sectors))
+		for i, si := range sectors {
+			nums[i] = si.SectorNumber
+		}
+
+		missing, err := notIndexed(ctx, idx, abi.ActorID(mid), mi.SectorSize, nums)
+		if err != nil {
+			return nil, xerrors.Errorf("checking sectors of %s: %w", maddr, err)
+		}
+		if len(missing) > 0 {
+			out[maddr] = missing
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,315 @@
+package lpwindow
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jackc/pgx/v5"
+	"github.com/samber/lo"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	miner2 "github.com/filecoin-project/go-state-types/builtin/v9/miner"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type DirectSubmitAPI interface {
+	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+	MpoolPush(context.Context, *types.SignedMessage) (cid.Cid, error)
+}
+
+// DirectSubmitter pushes WindowPoSt messages straight to the message pool,
+// bypassing the Sender and its bookkeeping in message_sends. It is an
+// emergency path for when the Sender is wedged and a deadline is about to be
+// missed; nothing coordinates it with other nodes, so it must not be used
+// while the regular submit task can still make progress.
+//
+// The message nonce is taken from the message pool. The Sender assigns the
+// greater of the message pool nonce and its own, so regular sends from the
+// same address continue after the direct submission once it is in the pool.
+type DirectSubmitter struct {
+	compute *WdPostTask
+	submit  *WdPostSubmitTask
+	api     DirectSubmitAPI
+	signer  lpmessage.SignerAPI
+	db      *harmonydb.DB
+}
+
+func NewDirectSubmitter(compute *WdPostTask, submit *WdPostSubmitTask, api DirectSubmitAPI, signer lpmessage.SignerAPI, db *harmonydb.DB) *DirectSubmitter {
+	return &DirectSubmitter{
+		compute: compute,
+		submit:  submit,
+		api:     api,
+		signer:  signer,
+		db:      db,
+	}
+}
+
+// DirectSubmitResult is the result of DirectSubmitter.Submit.
+type DirectSubmitResult struct {
+	Msg         *types.SignedMessage
+	PeriodStart abi.ChainEpoch
+	// Recomputed is set when the proof was computed for the submission, rather
+	// than read from wdpost_proofs.
+	Recomputed bool
+}
+
+// Submit proves a partition of the currently open deadline and pushes the
+// proof message to the message pool. The proof computed by the cluster is
+// used unless recompute is set or there is none, in which case it is
+// computed in this process. Either way it is verified before anything is
+// sent, and the submission is recorded in wdpost_direct_submits.
+func (d *DirectSubmitter) Submit(ctx context.Context, maddr address.Address, dlIdx, partIdx uint64, recompute bool) (*DirectSubmitResult, error) {
+	spID, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return nil, xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	head, err := d.compute.api.ChainHead(ctx)
+	if err != nil {
+		return nil, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	cur, err := d.compute.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
+	di := wdpost.NewDeadlineInfo(cur.PeriodStart, dlIdx, head.Height())
+	if !di.IsOpen() {
+		return nil, xerrors.Errorf("deadline %d isn't open (open at epoch %d, close at %d, head %d), proofs for it can't be submitted", dlIdx, di.Open, di.Close, head.Height())
+	}
+
+	deadlines, err := d.compute.api.StateMinerDeadlines(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting deadlines: %w", err)
+	}
+	if di.Index >= uint64(len(deadlines)) {
+		return nil, xerrors.Errorf("deadline %d out of range (%d deadlines)", di.Index, len(deadlines))
+	}
+	proven, err := deadlines[di.Index].PostSubmissions.IsSet(partIdx)
+	if err != nil {
+		return nil, xerrors.Errorf("checking post submissions: %w", err)
+	}
+	if proven {
+		return nil, xerrors.Errorf("partition %d of deadline %d was already proven on chain", partIdx, dlIdx)
+	}
+
+	var params *miner2.SubmitWindowedPoStParams
+	if !recompute {
+		params, err = d.storedProof(ctx, spID, di, partIdx)
+		if err != nil {
+			return nil, err
+		}
+		if params == nil {
+			log.Warnw("no computed proof for partition, computing it here", "miner", maddr, "deadline", dlIdx, "partition", partIdx)
+		}
+	}
+	recomputed := params == nil
+	if recomputed {
+		params, err = d.compute.DoPartition(ctx, head, maddr, di, partIdx)
+		if err != nil {
+			return nil, xerrors.Errorf("computing proof: %w", err)
+		}
+	}
+
+	if err := d.compute.verifyProof(ctx, head, maddr, di, params); err != nil {
+		return nil, xerrors.Errorf("proof failed local verification, not submitting: %w", err)
+	}
+
+	msg, _, err := d.submit.prepareSubmitMessage(head, maddr, di, params)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.From, err = d.api.StateAccountKey(ctx, msg.From, types.EmptyTSK)
+	if err != nil {
+		return nil, xerrors.Errorf("getting key address: %w", err)
+	}
+	msg.Nonce, err = d.api.MpoolGetNonce(ctx, msg.From)
+	if err != nil {
+		return nil, xerrors.Errorf("getting nonce from mpool: %w", err)
+	}
+
+	smsg, err := d.signer.WalletSignMessage(ctx, msg.From, msg)
+	if err != nil {
+		return nil, xerrors.Errorf("signing message: %w", err)
+	}
+
+	log.Warnw("UNSAFE: pushing WindowPoSt message directly to the message pool, bypassing the Sender",
+		"miner", maddr, "deadline", dlIdx, "partition", partIdx, "from", msg.From, "nonce", msg.Nonce, "cid", smsg.Cid(), "recomputed", recomputed)
+
+	if _, err := d.api.MpoolPush(ctx, smsg); err != nil {
+		return nil, xerrors.Errorf("pushing message: %w", err)
+	}
+
+	// the message is out, failing to record it mustn't make the caller retry
+	if err := d.record(ctx, spID, di, params, smsg, recomputed); err != nil {
+		log.Errorw("recording direct WindowPoSt submission", "cid", smsg.Cid(), "error", err)
+	}
+
+	return &DirectSubmitResult{
+		Msg:         smsg,
+		PeriodStart: di.PeriodStart,
+		Recomputed:  recomputed,
+	}, nil
+}
+
+// storedProof returns the proof the cluster computed for the partition, or
+// nil when there is none.
+func (d *DirectSubmitter) storedProof(ctx context.Context, spID uint64, di *dline.Info, partIdx uint64) (*miner2.SubmitWindowedPoStParams, error) {
+	var paramBytes []byte
+	var msgCid sql.NullString
+	err := d.db.QueryRow(ctx, `SELECT proof_params, message_cid FROM wdpost_proofs
+			WHERE sp_id = $1 AND proving_period_start = $2 AND deadline = $3 AND partition = $4`,
+		spID, di.PeriodStart, di.Index, partIdx).Scan(&paramBytes, &msgCid)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, xerrors.Errorf("getting computed proof: %w", err)
+	}
+
+	if msgCid.Valid {
+		return nil, xerrors.Errorf("proof was already sent in message %s, check its status before submitting again", msgCid.String)
+	}
+
+	var params miner2.SubmitWindowedPoStParams
+	if err := params.UnmarshalCBOR(bytes.NewReader(paramBytes)); err != nil {
+		return nil, xerrors.Errorf("unmarshaling computed proof: %w", err)
+	}
+	return &params, nil
+}
+
+func (d *DirectSubmitter) record(ctx context.Context, spID uint64, di *dline.Info, params *miner2.SubmitWindowedPoStParams, smsg *types.SignedMessage, recomputed bool) error {
+	parts := strings.Join(lo.Map(params.Partitions, func(p miner2.PoStPartition, _ int) string {
+		return strconv.FormatUint(p.Index, 10)
+	}), ",")
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	_, err = d.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`INSERT INTO wdpost_direct_submits (sp_id, proving_period_start, deadline, partitions, from_key, nonce, signed_cid, recomputed, submitted_by)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			spID, di.PeriodStart, di.Index, parts, smsg.Message.From.String(), smsg.Message.Nonce, smsg.Cid().String(), recomputed, host)
+		if err != nil {
+			return false, xerrors.Errorf("inserting direct submit: %w", err)
+		}
+
+		for _, p := range params.Partitions {
+			_, err = tx.Exec(`UPDATE wdpost_proofs SET message_cid = $1 WHERE sp_id = $2 AND proving_period_start = $3 AND deadline = $4 AND partition = $5`,
+				smsg.Cid().String(), spID, di.PeriodStart, di.Index, p.Index)
+			if err != nil {
+				return false, xerrors.Errorf("updating wdpost_proofs: %w", err)
+			}
+		}
+		return true, nil
+	})
+	return err
+}
+
+// verifyProof checks a WindowPoSt proof for partitions of the deadline against
+// the sectors they had at ts, with the challenge randomness of the deadline.
+func (t *WdPostTask) verifyProof(ctx context.Context, ts *types.TipSet, maddr address.Address, di *dline.Info, params *miner2.SubmitWindowedPoStParams) error {
+	if len(params.Proofs) == 0 {
+		return xerrors.Errorf("no proofs")
+	}
+
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := maddr.MarshalCBOR(buf); err != nil {
+		return xerrors.Errorf("failed to marshal address to cbor: %w", err)
+	}
+	rand, err := t.rand.StateGetRandomnessFromBeacon(ctx, crypto.DomainSeparationTag_WindowedPoStChallengeSeed, di.Challenge, buf.Bytes(), ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting challenge randomness: %w", err)
+	}
+
+	parts, err := t.api.StateMinerPartitions(ctx, maddr, di.Index, ts.Key())
+	if err != nil {
+		return xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	var sinfos []proof.SectorInfo
+	for _, pp := range params.Partitions {
+		if pp.Index >= uint64(len(parts)) {
+			return xerrors.Errorf("invalid partition %d (deadline has %d partitions)", pp.Index, len(parts))
+		}
+
+		good, err := provenSectors(parts[pp.Index], pp.Skipped)
+		if err != nil {
+			return xerrors.Errorf("partition %d: %w", pp.Index, err)
+		}
+
+		ssi, err := t.sectorsForProof(ctx, maddr, good, parts[pp.Index].AllSectors, ts)
+		if err != nil {
+			return xerrors.Errorf("getting sector info: %w", err)
+		}
+		for _, si := range ssi {
+			sinfos = append(sinfos, proof.SectorInfo{
+				SealProof:    si.SealProof,
+				SectorNumber: si.SectorNumber,
+				SealedCID:    si.SealedCID,
+			})
+		}
+	}
+	if len(sinfos) == 0 {
+		return xerrors.Errorf("no sectors to verify the proof against")
+	}
+
+	correct, err := t.verifier.VerifyWindowPoSt(ctx, proof.WindowPoStVerifyInfo{
+		Randomness:        abi.PoStRandomness(rand),
+		Proofs:            params.Proofs,
+		ChallengedSectors: sinfos,
+		Prover:            abi.ActorID(mid),
+	})
+	if err != nil {
+		return xerrors.Errorf("verifying proof: %w", err)
+	}
+	if !correct {
+		return xerrors.Errorf("proof is invalid")
+	}
+	return nil
+}
+
+// provenSectors returns the sectors of a partition a proof covers when it
+// skips the given sectors: the live, non-faulty sectors along with the ones
+// being recovered, like DoPartition proves.
+func provenSectors(part api.Partition, skipped bitfield.BitField) (bitfield.BitField, error) {
+	toProve, err := bitfield.SubtractBitField(part.LiveSectors, part.FaultySectors)
+	if err != nil {
+		return bitfield.BitField{}, xerrors.Errorf("removing faults from set of sectors to prove: %w", err)
+	}
+	toProve, err = bitfield.MergeBitFields(toProve, part.RecoveringSectors)
+	if err != nil {
+		return bitfield.BitField{}, xerrors.Errorf("adding recoveries to set of sectors to prove: %w", err)
+	}
+	good, err := bitfield.SubtractBitField(toProve, skipped)
+	if err != nil {
+		return bitfield.BitField{}, xerrors.Errorf("removing skipped sectors: %w", err)
+	}
+	return good, nil
+}
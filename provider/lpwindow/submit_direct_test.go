@@ -0,0 +1,34 @@
+package lpwindow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-bitfield"
+
+	"github.com/filecoin-project/lotus/api"
+)
+
+func TestProvenSectors(t *testing.T) {
+	part := api.Partition{
+		AllSectors:        bitfield.NewFromSet([]uint64{1, 2, 3, 4, 5, 6}),
+		LiveSectors:       bitfield.NewFromSet([]uint64{1, 2, 3, 4, 5}),
+		FaultySectors:     bitfield.NewFromSet([]uint64{3, 4}),
+		RecoveringSectors: bitfield.NewFromSet([]uint64{4}),
+	}
+
+	// faulty sectors aren't proven unless they are being recovered
+	good, err := provenSectors(part, bitfield.New())
+	require.NoError(t, err)
+	nums, err := good.All(10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 2, 4, 5}, nums)
+
+	// skipped sectors aren't part of the proof
+	good, err = provenSectors(part, bitfield.NewFromSet([]uint64{2, 4}))
+	require.NoError(t, err)
+	nums, err = good.All(10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 5}, nums)
+}
@@ -3,24 +3,35 @@ package lpwindow
 import (
 	"bytes"
 	"context"
+	"time"
 
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
 	"github.com/filecoin-project/go-state-types/abi"
 	"github.com/filecoin-project/go-state-types/big"
 	"github.com/filecoin-project/go-state-types/builtin"
 	"github.com/filecoin-project/go-state-types/builtin/v9/miner"
 	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+	"github.com/filecoin-project/go-state-types/network"
+	"github.com/filecoin-project/go-state-types/proof"
 
 	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
 	"github.com/filecoin-project/lotus/chain/types"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
 	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/provider/chainsched"
 	"github.com/filecoin-project/lotus/provider/lpmessage"
+	"github.com/filecoin-project/lotus/provider/lprand"
 	"github.com/filecoin-project/lotus/storage/ctladdr"
 	"github.com/filecoin-project/lotus/storage/wdpost"
 )
@@ -34,32 +45,50 @@ type WdPoStSubmitTaskApi interface {
 	StateAccountKey(context.Context, address.Address, types.TipSetKey) (address.Address, error)
 	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
 	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
 	StateGetRandomnessFromTickets(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+	StateGetRandomnessFromBeacon(ctx context.Context, personalization crypto.DomainSeparationTag, randEpoch abi.ChainEpoch, entropy []byte, tsk types.TipSetKey) (abi.Randomness, error)
+	StateNetworkVersion(context.Context, types.TipSetKey) (network.Version, error)
 
 	GasEstimateMessageGas(context.Context, *types.Message, *api.MessageSendSpec, types.TipSetKey) (*types.Message, error)
 	GasEstimateFeeCap(context.Context, *types.Message, int64, types.TipSetKey) (types.BigInt, error)
 	GasEstimateGasPremium(_ context.Context, nblocksincl uint64, sender address.Address, gaslimit int64, tsk types.TipSetKey) (types.BigInt, error)
 }
 
+// MaxFeeFunc returns the fee cap for messages sent on behalf of a miner at
+// the given epoch, see config.LotusProviderFees.FeesAt.
+type MaxFeeFunc func(maddr address.Address, epoch abi.ChainEpoch) types.FIL
+
 type WdPostSubmitTask struct {
 	sender *lpmessage.Sender
-	db     *harmonydb.DB
+	db     harmonydb.Interface
 	api    WdPoStSubmitTaskApi
+	// must match the source of the compute tasks, proofs are rechecked
+	// against it before submitting
+	rand lprand.Source
 
-	maxWindowPoStGasFee types.FIL
+	maxWindowPoStGasFee MaxFeeFunc
+	gasLimit            GasLimitBounds
 	as                  *ctladdr.AddressSelector
+	partLimit           *partitionLimiter
+	wait                lpmessage.WaitStrategy
 
 	submitPoStTF promise.Promise[harmonytask.AddTaskFunc]
 }
 
-func NewWdPostSubmitTask(pcs *chainsched.ProviderChainSched, send *lpmessage.Sender, db *harmonydb.DB, api WdPoStSubmitTaskApi, maxWindowPoStGasFee types.FIL, as *ctladdr.AddressSelector) (*WdPostSubmitTask, error) {
+func NewWdPostSubmitTask(pcs *chainsched.ProviderChainSched, send *lpmessage.Sender, db *harmonydb.DB, api WdPoStSubmitTaskApi, rand lprand.Source, maxWindowPoStGasFee MaxFeeFunc, gasLimit GasLimitBounds, as *ctladdr.AddressSelector, wait lpmessage.WaitStrategy) (*WdPostSubmitTask, error) {
 	res := &WdPostSubmitTask{
 		sender: send,
 		db:     db,
 		api:    api,
+		rand:   rand,
 
 		maxWindowPoStGasFee: maxWindowPoStGasFee,
+		gasLimit:            gasLimit,
 		as:                  as,
+		partLimit:           newPartitionLimiter(api),
+		wait:                wait,
 	}
 
 	if err := pcs.AddHandler(res.processHeadChange); err != nil {
@@ -76,13 +105,13 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 	var deadline uint64
 	var partition uint64
 	var pps, submitAtEpoch, submitByEpoch abi.ChainEpoch
-	var earlyParamBytes []byte
+	var earlyParamBytes, challengeRand []byte
 	var dbTask uint64
 
 	err = w.db.QueryRow(
-		context.Background(), `SELECT sp_id, proving_period_start, deadline, partition, submit_at_epoch, submit_by_epoch, proof_params, submit_task_id
+		context.Background(), `SELECT sp_id, proving_period_start, deadline, partition, submit_at_epoch, submit_by_epoch, proof_params, submit_task_id, challenge_rand
 		FROM wdpost_proofs WHERE submit_task_id = $1`, taskID,
-	).Scan(&spID, &pps, &deadline, &partition, &submitAtEpoch, &submitByEpoch, &earlyParamBytes, &dbTask)
+	).Scan(&spID, &pps, &deadline, &partition, &submitAtEpoch, &submitByEpoch, &earlyParamBytes, &dbTask, &challengeRand)
 	if err != nil {
 		return false, xerrors.Errorf("query post proof: %w", err)
 	}
@@ -91,7 +120,12 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		return false, xerrors.Errorf("taskID mismatch: %d != %d", dbTask, taskID)
 	}
 
-	head, err := w.api.ChainHead(context.Background())
+	ctx, span := startDeadlineSpan(taskID, "WdPostSubmitTask.Do", spID, pps, deadline, partition)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	head, err := w.api.ChainHead(ctx)
 	if err != nil {
 		return false, xerrors.Errorf("getting chain head: %w", err)
 	}
@@ -113,7 +147,111 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 	if err := params.UnmarshalCBOR(bytes.NewReader(earlyParamBytes)); err != nil {
 		return false, xerrors.Errorf("unmarshaling proof message: %w", err)
 	}
+	if len(params.Proofs) == 0 {
+		return false, harmonytask.Terminal(xerrors.Errorf("proof message has no proofs"))
+	}
+
+	perMsg, err := w.partLimit.limit(ctx, head.Key(), params.Proofs[0].PoStProof)
+	if err != nil {
+		return false, err
+	}
+	if len(params.Partitions) > perMsg {
+		// a single proof covers all partitions in the message, it can't be split here
+		return false, harmonytask.Terminal(xerrors.Errorf("proof for %d partitions exceeds the network limit of %d partitions per message", len(params.Partitions), perMsg))
+	}
+
+	maddr, err := address.NewIDAddress(spID)
+	if err != nil {
+		return false, xerrors.Errorf("invalid miner address: %w", err)
+	}
+
+	if challengeRand != nil {
+		changed, err := challengeChanged(ctx, w.rand, maddr, dlInfo, challengeRand, head.Key())
+		if err != nil {
+			return false, xerrors.Errorf("checking challenge: %w", err)
+		}
+		if changed {
+			// submitting would risk a dispute, have the compute task prove the partition again
+			log.Warnw("challenge changed since proof was computed, recomputing", "spID", spID, "deadline", deadline, "partition", partition)
+			if err := w.discardProof(ctx, spID, pps, deadline, partition); err != nil {
+				return false, err
+			}
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.MinerID, maddr.String())}, WdPostMeasures.ReorgRecompute.M(1))
+			return true, nil
+		}
+	}
+
+	msg, mss, err := w.prepareSubmitMessage(head, maddr, dlInfo, &params)
+	if err != nil {
+		return false, err
+	}
+
+	key := lpmessage.IdempotencyKey(taskID, "wdpost")
+	if w.wait != lpmessage.WaitConfirmed {
+		smsg, err := w.sender.Send(ctx, key, msg, mss, "wdpost")
+		if err != nil {
+			return false, xerrors.Errorf("sending proof message: %w", err)
+		}
+		if err := w.setMessageCid(ctx, smsg, spID, pps, deadline, partition); err != nil {
+			// retried, the idempotency key keeps the message from being sent again
+			return false, err
+		}
+		return true, nil
+	}
+
+	// wait until the deadline closes, a proof executed later doesn't count
+	waitEpochs := dlInfo.Close - head.Height() + abi.ChainEpoch(build.MessageConfidence)
+	wctx, cancel := context.WithTimeout(ctx, time.Duration(waitEpochs)*time.Duration(build.BlockDelaySecs)*time.Second)
+	defer cancel()
+
+	smsg, lookup, err := w.sender.SendAndWait(wctx, key, msg, mss, "wdpost")
+	if smsg != cid.Undef {
+		if err := w.setMessageCid(ctx, smsg, spID, pps, deadline, partition); err != nil {
+			return false, err
+		}
+	}
+	if err != nil {
+		return false, xerrors.Errorf("sending proof message: %w", err)
+	}
+	if lookup.Receipt.ExitCode.IsError() {
+		return false, harmonytask.Terminal(xerrors.Errorf("proof message %s failed with exit code %d", lookup.Message, lookup.Receipt.ExitCode))
+	}
+
+	return true, nil
+}
+
+// setMessageCid sets message_cid in the wdpost_proofs entry.
+func (w *WdPostSubmitTask) setMessageCid(ctx context.Context, smsg cid.Cid, spID uint64, pps abi.ChainEpoch, deadline, partition uint64) error {
+	_, err := w.db.Exec(ctx, `UPDATE wdpost_proofs SET message_cid = $1 WHERE sp_id = $2 AND proving_period_start = $3 AND deadline = $4 AND partition = $5`, smsg.String(), spID, pps, deadline, partition)
+	if err != nil {
+		return xerrors.Errorf("updating wdpost_proofs: %w", err)
+	}
+	return nil
+}
 
+// discardProof removes a computed proof along with the compute task record for
+// its partition, so that the compute task schedules the partition again on the
+// next head change.
+func (w *WdPostSubmitTask) discardProof(ctx context.Context, spID uint64, pps abi.ChainEpoch, deadline, partition uint64) error {
+	_, err := w.db.BeginTransaction(ctx, func(tx *harmonydb.Tx) (commit bool, err error) {
+		_, err = tx.Exec(`DELETE FROM wdpost_proofs WHERE sp_id = $1 AND proving_period_start = $2 AND deadline = $3 AND partition = $4`, spID, pps, deadline, partition)
+		if err != nil {
+			return false, xerrors.Errorf("deleting proof: %w", err)
+		}
+
+		_, err = tx.Exec(`DELETE FROM wdpost_partition_tasks WHERE sp_id = $1 AND proving_period_start = $2 AND deadline_index = $3 AND partition_index = $4`, spID, pps, deadline, partition)
+		if err != nil {
+			return false, xerrors.Errorf("deleting partition task: %w", err)
+		}
+
+		return true, nil
+	})
+	return err
+}
+
+// prepareSubmitMessage fills in the chain commit for params and builds the
+// gas-estimated SubmitWindowedPoSt message for the given deadline.
+func (w *WdPostSubmitTask) prepareSubmitMessage(head *types.TipSet, maddr address.Address, dlInfo *dline.Info, params *miner.SubmitWindowedPoStParams) (*types.Message, *api.MessageSendSpec, error) {
 	commEpoch := dlInfo.Challenge
 
 	commRand, err := w.api.StateGetRandomnessFromTickets(context.Background(), crypto.DomainSeparationTag_PoStChainCommit, commEpoch, nil, head.Key())
@@ -121,7 +259,7 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		err = xerrors.Errorf("failed to get chain randomness from tickets for windowPost (epoch=%d): %w", commEpoch, err)
 		log.Errorf("submitPoStMessage failed: %+v", err)
 
-		return false, xerrors.Errorf("getting post commit randomness: %w", err)
+		return nil, nil, xerrors.Errorf("getting post commit randomness: %w", err)
 	}
 
 	params.ChainCommitEpoch = commEpoch
@@ -129,12 +267,7 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 
 	var pbuf bytes.Buffer
 	if err := params.MarshalCBOR(&pbuf); err != nil {
-		return false, xerrors.Errorf("marshaling proof message: %w", err)
-	}
-
-	maddr, err := address.NewIDAddress(spID)
-	if err != nil {
-		return false, xerrors.Errorf("invalid miner address: %w", err)
+		return nil, nil, xerrors.Errorf("marshaling proof message: %w", err)
 	}
 
 	msg := &types.Message{
@@ -144,25 +277,99 @@ func (w *WdPostSubmitTask) Do(taskID harmonytask.TaskID, stillOwned func() bool)
 		Value:  big.Zero(),
 	}
 
-	msg, mss, err := preparePoStMessage(w.api, w.as, maddr, msg, abi.TokenAmount(w.maxWindowPoStGasFee))
+	msg, mss, err := preparePoStMessage(w.api, w.as, maddr, msg, abi.TokenAmount(w.maxWindowPoStGasFee(maddr, head.Height())), w.gasLimit)
 	if err != nil {
-		return false, xerrors.Errorf("preparing proof message: %w", err)
+		return nil, nil, xerrors.Errorf("preparing proof message: %w", err)
 	}
 
-	ctx := context.Background()
-	smsg, err := w.sender.Send(ctx, msg, mss, "wdpost")
+	return msg, mss, nil
+}
+
+// SubmitEstimate is the result of EstimateSubmit.
+type SubmitEstimate struct {
+	// Msg is the message as it would be sent, with the fee capped at MaxFee.
+	// When the deadline needs several messages, this is the first one.
+	Msg *types.Message
+	// Messages is the number of messages the deadline is split into, with at
+	// most PartitionsPerMsg partitions each.
+	Messages         int
+	PartitionsPerMsg int
+	// UncappedFee is the fee the network currently asks for, before MaxFee is applied.
+	UncappedFee abi.TokenAmount
+	MaxFee      abi.TokenAmount
+}
+
+// EstimateSubmit builds the SubmitWindowedPoSt messages for all partitions
+// of a deadline the same way Do does, split by the network limit of
+// partitions per message, and estimates the gas of the first, without sending
+// anything. The proofs are placeholders, so the deadline must be currently
+// open for the miner actor to accept the message during estimation.
+func (w *WdPostSubmitTask) EstimateSubmit(ctx context.Context, maddr address.Address, dlIdx uint64) (*SubmitEstimate, error) {
+	head, err := w.api.ChainHead(ctx)
 	if err != nil {
-		return false, xerrors.Errorf("sending proof message: %w", err)
+		return nil, xerrors.Errorf("getting chain head: %w", err)
 	}
 
-	// set message_cid in the wdpost_proofs entry
+	curr, err := w.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proving deadline: %w", err)
+	}
 
-	_, err = w.db.Exec(ctx, `UPDATE wdpost_proofs SET message_cid = $1 WHERE sp_id = $2 AND proving_period_start = $3 AND deadline = $4 AND partition = $5`, smsg.String(), spID, pps, deadline, partition)
+	mi, err := w.api.StateMinerInfo(ctx, maddr, head.Key())
 	if err != nil {
-		return true, xerrors.Errorf("updating wdpost_proofs: %w", err)
+		return nil, xerrors.Errorf("getting miner info: %w", err)
 	}
 
-	return true, nil
+	parts, err := w.api.StateMinerPartitions(ctx, maddr, dlIdx, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("getting partitions: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, xerrors.Errorf("deadline %d has no partitions", dlIdx)
+	}
+
+	perMsg, err := w.partLimit.limit(ctx, head.Key(), mi.WindowPoStProofType)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []miner.PoStPartition
+	for i := range parts {
+		partitions = append(partitions, miner.PoStPartition{
+			Index:   uint64(i),
+			Skipped: bitfield.New(),
+		})
+	}
+	batches := batchPartitions(partitions, perMsg)
+
+	params := miner.SubmitWindowedPoStParams{
+		Deadline:   dlIdx,
+		Partitions: batches[0],
+		Proofs: []proof.PoStProof{{
+			PoStProof:  mi.WindowPoStProofType,
+			ProofBytes: make([]byte, 192),
+		}},
+	}
+
+	dlInfo := wdpost.NewDeadlineInfo(curr.PeriodStart, dlIdx, head.Height())
+
+	msg, mss, err := w.prepareSubmitMessage(head, maddr, dlInfo, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	feeCap, err := w.api.GasEstimateFeeCap(ctx, msg, 20, head.Key())
+	if err != nil {
+		return nil, xerrors.Errorf("estimating uncapped fee cap: %w", err)
+	}
+
+	return &SubmitEstimate{
+		Msg:              msg,
+		Messages:         len(batches),
+		PartitionsPerMsg: perMsg,
+		UncappedFee:      big.Mul(feeCap, big.NewInt(msg.GasLimit)),
+		MaxFee:           mss.MaxFee,
+	}, nil
 }
 
 func (w *WdPostSubmitTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
@@ -248,7 +455,7 @@ type MsgPrepAPI interface {
 	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
 }
 
-func preparePoStMessage(w MsgPrepAPI, as *ctladdr.AddressSelector, maddr address.Address, msg *types.Message, maxFee abi.TokenAmount) (*types.Message, *api.MessageSendSpec, error) {
+func preparePoStMessage(w MsgPrepAPI, as *ctladdr.AddressSelector, maddr address.Address, msg *types.Message, maxFee abi.TokenAmount, gasLimit GasLimitBounds) (*types.Message, *api.MessageSendSpec, error) {
 	mi, err := w.StateMinerInfo(context.Background(), maddr, types.EmptyTSK)
 	if err != nil {
 		return nil, nil, xerrors.Errorf("error getting miner info: %w", err)
@@ -269,6 +476,7 @@ func preparePoStMessage(w MsgPrepAPI, as *ctladdr.AddressSelector, maddr address
 		return nil, nil, xerrors.Errorf("estimating gas: %w", err)
 	}
 	*msg = *gm
+	gasLimit.apply(msg, maxFee)
 
 	// calculate a more frugal estimation; premium is estimated to guarantee
 	// inclusion within 5 tipsets, and fee cap is estimated for inclusion
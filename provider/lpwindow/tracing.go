@@ -0,0 +1,54 @@
+package lpwindow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.opencensus.io/trace"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+)
+
+// startDeadlineSpan starts a span for work on one WindowPoSt deadline of a
+// miner. The compute tasks of all partitions and the submit tasks run as
+// separate tasks, possibly on different nodes; their spans share a trace
+// derived from the deadline so that tracing shows the whole WindowPoSt run
+// together, next to the harmonytask span of each run.
+func startDeadlineSpan(taskID harmonytask.TaskID, name string, spID uint64, pps abi.ChainEpoch, dlIdx, partIdx uint64) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpanWithRemoteParent(context.Background(), name, deadlineSpanContext(spID, pps, dlIdx))
+	span.AddAttributes(
+		trace.Int64Attribute("task_id", int64(taskID)),
+		trace.Int64Attribute("sp_id", int64(spID)),
+		trace.Int64Attribute("proving_period_start", int64(pps)),
+		trace.Int64Attribute("deadline", int64(dlIdx)),
+		trace.Int64Attribute("partition", int64(partIdx)),
+	)
+
+	return ctx, span
+}
+
+// deadlineSpanContext is the parent of all spans for a deadline. No span with
+// this context is ever recorded.
+func deadlineSpanContext(spID uint64, pps abi.ChainEpoch, dlIdx uint64) trace.SpanContext {
+	var buf [24]byte
+	binary.BigEndian.PutUint64(buf[0:], spID)
+	binary.BigEndian.PutUint64(buf[8:], uint64(pps))
+	binary.BigEndian.PutUint64(buf[16:], dlIdx)
+	h := sha256.Sum256(buf[:])
+
+	var sc trace.SpanContext
+	copy(sc.TraceID[:], h[:16])
+	copy(sc.SpanID[:], h[16:24])
+	sc.TraceOptions = 1 // sampled
+	return sc
+}
+
+func endSpan(span *trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	}
+	span.End()
+}
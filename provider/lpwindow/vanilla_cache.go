@@ -0,0 +1,160 @@
+package lpwindow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// VanillaCache is a paths.Store keeping the vanilla proofs it generates for
+// WindowPoSt challenges in the database for ttl. When a partition is proven
+// again for the same challenges, e.g. after the snark failed or a reorg made
+// another node recompute it, the cached proofs are used instead of reading
+// the challenged nodes from the sector files again.
+//
+// A sector has a single cached proof, for the challenges it was last proven
+// for. The challenges of a sector are derived from the randomness, so a
+// proof generated for new randomness replaces the one cached for the old.
+type VanillaCache struct {
+	paths.Store
+
+	db  harmonydb.Interface
+	ttl time.Duration
+
+	pruneLk   sync.Mutex
+	lastPrune time.Time
+}
+
+func NewVanillaCache(db harmonydb.Interface, stor paths.Store, ttl time.Duration) *VanillaCache {
+	return &VanillaCache{
+		Store: stor,
+		db:    db,
+		ttl:   ttl,
+	}
+}
+
+func (c *VanillaCache) GenerateSingleVanillaProof(ctx context.Context, minerID abi.ActorID, si storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
+	if isWinningPoSt(ppt) {
+		// challenged for a single epoch, never proven again
+		return c.Store.GenerateSingleVanillaProof(ctx, minerID, si, ppt)
+	}
+
+	start := time.Now()
+	key := challengeKey(si, ppt)
+
+	var vanilla []byte
+	err := c.db.QueryRow(ctx, `SELECT proof FROM wdpost_vanilla_cache
+		WHERE sp_id = $1 AND sector_number = $2 AND challenge_key = $3 AND expires_at > CURRENT_TIMESTAMP`,
+		minerID, si.SectorNumber, key).Scan(&vanilla)
+	switch {
+	case err == nil:
+		recordVanilla(ctx, minerID, "hit", start)
+		return vanilla, nil
+	case !errors.Is(err, pgx.ErrNoRows):
+		// the cache is an optimization, proving goes on without it
+		log.Warnw("reading cached vanilla proof", "miner", minerID, "sector", si.SectorNumber, "error", err)
+	}
+
+	vanilla, err = c.Store.GenerateSingleVanillaProof(ctx, minerID, si, ppt)
+	if err != nil || vanilla == nil {
+		return vanilla, err
+	}
+	recordVanilla(ctx, minerID, "miss", start)
+
+	_, err = c.db.Exec(ctx, `INSERT INTO wdpost_vanilla_cache (sp_id, sector_number, challenge_key, proof, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sp_id, sector_number) DO UPDATE
+			SET challenge_key = EXCLUDED.challenge_key, proof = EXCLUDED.proof, expires_at = EXCLUDED.expires_at`,
+		minerID, si.SectorNumber, key, vanilla, time.Now().UTC().Add(c.ttl))
+	if err != nil {
+		log.Warnw("caching vanilla proof", "miner", minerID, "sector", si.SectorNumber, "error", err)
+	}
+
+	c.prune(ctx)
+
+	return vanilla, nil
+}
+
+// prune deletes expired proofs, at most once per ttl. Proofs of sectors which
+// are proven again are replaced before that, these are the proofs of sectors
+// which were removed or are skipped as faulty.
+func (c *VanillaCache) prune(ctx context.Context) {
+	c.pruneLk.Lock()
+	if time.Since(c.lastPrune) < c.ttl {
+		c.pruneLk.Unlock()
+		return
+	}
+	c.lastPrune = time.Now()
+	c.pruneLk.Unlock()
+
+	n, err := c.db.Exec(ctx, `DELETE FROM wdpost_vanilla_cache WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		log.Warnw("pruning vanilla proof cache", "error", err)
+		return
+	}
+	if n > 0 {
+		log.Debugw("pruned vanilla proof cache", "deleted", n)
+	}
+}
+
+// challengeKey identifies the challenges of a sector, along with the sector
+// files they are answered from; an upgraded sector gets a new sealed CID.
+func challengeKey(si storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) []byte {
+	h := sha256.New()
+	var buf [8]byte
+	for _, v := range []uint64{uint64(ppt), uint64(si.SealProof), uint64(si.SectorNumber)} {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	h.Write(si.SealedCID.Bytes())
+	if si.Update {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	for _, v := range si.Challenge {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	return h.Sum(nil)
+}
+
+func isWinningPoSt(ppt abi.RegisteredPoStProof) bool {
+	switch ppt {
+	case abi.RegisteredPoStProof_StackedDrgWinning2KiBV1,
+		abi.RegisteredPoStProof_StackedDrgWinning8MiBV1,
+		abi.RegisteredPoStProof_StackedDrgWinning512MiBV1,
+		abi.RegisteredPoStProof_StackedDrgWinning32GiBV1,
+		abi.RegisteredPoStProof_StackedDrgWinning64GiBV1:
+		return true
+	}
+	return false
+}
+
+func recordVanilla(ctx context.Context, minerID abi.ActorID, result string, start time.Time) {
+	maddr, err := address.NewIDAddress(uint64(minerID))
+	if err != nil {
+		return
+	}
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(metrics.MinerID, maddr.String()),
+		tag.Upsert(VanillaCacheKey, result),
+	}, WdPostMeasures.VanillaProofDuration.M(metrics.SinceInMilliseconds(start)))
+}
+
+var _ paths.Store = &VanillaCache{}
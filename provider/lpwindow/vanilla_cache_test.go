@@ -0,0 +1,104 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type vanillaStore struct {
+	paths.Store
+	reads int
+}
+
+func (s *vanillaStore) GenerateSingleVanillaProof(ctx context.Context, minerID abi.ActorID, si storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
+	s.reads++
+	return []byte{byte(si.SectorNumber), byte(si.Challenge[0])}, nil
+}
+
+func TestVanillaCache(t *testing.T) {
+	ctx := context.Background()
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	si := storiface.PostSectorChallenge{SealProof: abi.RegisteredSealProof_StackedDrg2KiBV1, SectorNumber: 5, Challenge: []uint64{7}}
+	key := challengeKey(si, ppt)
+
+	db := harmonydb.NewMock()
+	stor := &vanillaStore{}
+	c := NewVanillaCache(db, stor, time.Minute)
+
+	// not cached yet: read from storage, stored, and expired proofs pruned
+	db.ExpectQueryRow(`FROM wdpost_vanilla_cache`).WithArgs(abi.ActorID(1000), abi.SectorNumber(5), key)
+	db.ExpectExec(`INSERT INTO wdpost_vanilla_cache`).WithArgs(abi.ActorID(1000), abi.SectorNumber(5), key, []byte{5, 7}, harmonydb.MockAnyArg)
+	db.ExpectExec(`DELETE FROM wdpost_vanilla_cache`)
+
+	vanilla, err := c.GenerateSingleVanillaProof(ctx, 1000, si, ppt)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 7}, vanilla)
+	require.Equal(t, 1, stor.reads)
+
+	// cached: storage isn't read, and pruning waits for the ttl
+	db.ExpectQueryRow(`FROM wdpost_vanilla_cache`).WithArgs(abi.ActorID(1000), abi.SectorNumber(5), key).
+		WillReturnRows([]any{[]byte{5, 7}})
+
+	vanilla, err = c.GenerateSingleVanillaProof(ctx, 1000, si, ppt)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 7}, vanilla)
+	require.Equal(t, 1, stor.reads)
+
+	// new randomness challenges other nodes, the cached proof is replaced
+	newSi := si
+	newSi.Challenge = []uint64{8}
+	newKey := challengeKey(newSi, ppt)
+	require.NotEqual(t, key, newKey)
+
+	db.ExpectQueryRow(`FROM wdpost_vanilla_cache`).WithArgs(abi.ActorID(1000), abi.SectorNumber(5), newKey)
+	db.ExpectExec(`ON CONFLICT (sp_id, sector_number) DO UPDATE`).WithArgs(abi.ActorID(1000), abi.SectorNumber(5), newKey, []byte{5, 8}, harmonydb.MockAnyArg)
+
+	vanilla, err = c.GenerateSingleVanillaProof(ctx, 1000, newSi, ppt)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 8}, vanilla)
+	require.Equal(t, 2, stor.reads)
+
+	// a failing database doesn't fail proving
+	db.ExpectQueryRow(`FROM wdpost_vanilla_cache`).WillReturnError(xerrors.New("db down"))
+	db.ExpectExec(`INSERT INTO wdpost_vanilla_cache`).WillReturnError(xerrors.New("db down"))
+
+	vanilla, err = c.GenerateSingleVanillaProof(ctx, 1000, si, ppt)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 7}, vanilla)
+	require.Equal(t, 3, stor.reads)
+
+	// winning challenges aren't cached
+	vanilla, err = c.GenerateSingleVanillaProof(ctx, 1000, si, abi.RegisteredPoStProof_StackedDrgWinning2KiBV1)
+	require.NoError(t, err)
+	require.Equal(t, []byte{5, 7}, vanilla)
+	require.Equal(t, 4, stor.reads)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestChallengeKey(t *testing.T) {
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1
+	si := storiface.PostSectorChallenge{SealProof: abi.RegisteredSealProof_StackedDrg2KiBV1, SectorNumber: 5, Challenge: []uint64{1, 2}}
+	key := challengeKey(si, ppt)
+	require.Equal(t, key, challengeKey(si, ppt))
+
+	other := si
+	other.Challenge = []uint64{2, 1}
+	require.NotEqual(t, key, challengeKey(other, ppt))
+
+	other = si
+	other.Update = true
+	require.NotEqual(t, key, challengeKey(other, ppt))
+
+	require.NotEqual(t, key, challengeKey(si, abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1))
+}
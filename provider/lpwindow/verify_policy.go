@@ -0,0 +1,220 @@
+package lpwindow
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+// VerifyFailurePolicy is what the compute task does with a WindowPoSt proof
+// which fails local verification.
+type VerifyFailurePolicy string
+
+const (
+	// VerifyFailRecompute fails the task, which is retried and computes the
+	// proof again, until the task runs out of retries.
+	VerifyFailRecompute VerifyFailurePolicy = "recompute"
+	// VerifyFailHold completes the task without storing the proof, so that
+	// the partition isn't submitted, and raises an alert for the operator to
+	// investigate.
+	VerifyFailHold VerifyFailurePolicy = "hold"
+	// VerifyFailSubmit stores the proof for submission anyway. If the proof is
+	// indeed invalid, the submit message fails on chain and the partition
+	// isn't proven; only worth it when local verification is suspected wrong.
+	VerifyFailSubmit VerifyFailurePolicy = "submit"
+)
+
+// ErrProofHeld is returned by DoPartition when the proof failed local
+// verification and VerifyFailHold keeps it from being submitted.
+var ErrProofHeld = xerrors.New("proof held after failing local verification")
+
+// ParseVerifyFailurePolicy parses the Proving.VerifyFailurePolicy config
+// value, empty is VerifyFailRecompute.
+func ParseVerifyFailurePolicy(s string) (VerifyFailurePolicy, error) {
+	switch p := VerifyFailurePolicy(s); p {
+	case "":
+		return VerifyFailRecompute, nil
+	case VerifyFailRecompute, VerifyFailHold, VerifyFailSubmit:
+		return p, nil
+	default:
+		return "", xerrors.Errorf("unknown verify failure policy %q, expected recompute, hold or submit", s)
+	}
+}
+
+// VerifyFailureEvt is the journal event recorded when a WindowPoSt proof
+// fails local verification. Each policy records it with an event type of
+// its own, verify_failed_<policy>.
+type VerifyFailureEvt struct {
+	Miner     abi.ActorID
+	Deadline  uint64
+	Partition uint64
+	Challenge abi.ChainEpoch
+	Policy    VerifyFailurePolicy
+	Error     string
+}
+
+// verifyFailureHandler applies the verify failure policy to the proofs of
+// the compute task. Held proofs are stored in wdpost_verify_holds until a
+// proof of the same partition verifies again, on any node, and every node
+// keeps the alert raised while one of its miners has held proofs.
+type verifyFailureHandler struct {
+	policy VerifyFailurePolicy
+	db     harmonydb.Interface
+
+	journal journal.Journal
+	evtType journal.EventType
+
+	al    *alerting.Alerting
+	alert alerting.AlertType
+}
+
+func newVerifyFailureHandler(policy VerifyFailurePolicy, db harmonydb.Interface, j journal.Journal, al *alerting.Alerting) *verifyFailureHandler {
+	h := &verifyFailureHandler{
+		policy:  policy,
+		db:      db,
+		journal: j,
+		evtType: j.RegisterEventType("wdpost", "verify_failed_"+string(policy)),
+		al:      al,
+	}
+	if al != nil {
+		h.alert = al.AddAlertType("lpwindow", "verify-failure")
+	}
+	return h
+}
+
+// failed handles a proof which failed verification with verr. A nil return
+// means that the proof is to be submitted anyway.
+func (h *verifyFailureHandler) failed(ctx context.Context, maddr address.Address, di *dline.Info, partIdx uint64, verr error) error {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		return xerrors.Errorf("getting miner ID: %w", err)
+	}
+
+	h.journal.RecordEvent(h.evtType, func() interface{} {
+		return &VerifyFailureEvt{
+			Miner:     abi.ActorID(mid),
+			Deadline:  di.Index,
+			Partition: partIdx,
+			Challenge: di.Challenge,
+			Policy:    h.policy,
+			Error:     verr.Error(),
+		}
+	})
+
+	mutators := []tag.Mutator{tag.Upsert(metrics.MinerID, maddr.String())}
+
+	switch h.policy {
+	case VerifyFailHold:
+		_ = stats.RecordWithTags(ctx, mutators, WdPostMeasures.VerifyFailHold.M(1))
+		log.Errorw("WindowPoSt proof failed local verification, holding it", "miner", maddr, "deadline", di.Index, "partition", partIdx, "error", verr)
+		_, err := h.db.Exec(ctx, `INSERT INTO wdpost_verify_holds (sp_id, deadline_index, partition_index, challenge_epoch, err)
+			VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`, mid, di.Index, partIdx, di.Challenge, verr.Error())
+		if err != nil {
+			// the alert is still raised on this node
+			log.Errorw("recording held proof", "miner", maddr, "deadline", di.Index, "partition", partIdx, "error", err)
+		}
+		if h.al != nil {
+			h.al.Raise(h.alert, map[string]interface{}{
+				"miner":     maddr.String(),
+				"deadline":  di.Index,
+				"partition": partIdx,
+				"error":     verr.Error(),
+			})
+		}
+		return xerrors.Errorf("partition %d of deadline %d: %s: %w", partIdx, di.Index, verr, ErrProofHeld)
+	case VerifyFailSubmit:
+		_ = stats.RecordWithTags(ctx, mutators, WdPostMeasures.VerifyFailSubmit.M(1))
+		log.Errorw("WindowPoSt proof failed local verification, submitting it anyway", "miner", maddr, "deadline", di.Index, "partition", partIdx, "error", verr)
+		return nil
+	default:
+		_ = stats.RecordWithTags(ctx, mutators, WdPostMeasures.VerifyFailRecompute.M(1))
+		log.Errorw("WindowPoSt proof failed local verification, recomputing it", "miner", maddr, "deadline", di.Index, "partition", partIdx, "error", verr)
+		return xerrors.Errorf("proof failed local verification: %w", verr)
+	}
+}
+
+// verified clears the held proofs of a partition once its proof verifies
+// again. The alert follows on the next updateAlert.
+func (h *verifyFailureHandler) verified(ctx context.Context, maddr address.Address, di *dline.Info, partIdx uint64) {
+	mid, err := address.IDFromAddress(maddr)
+	if err != nil {
+		log.Errorw("getting miner ID", "miner", maddr, "error", err)
+		return
+	}
+
+	n, err := h.db.Exec(ctx, `DELETE FROM wdpost_verify_holds WHERE sp_id = $1 AND deadline_index = $2 AND partition_index = $3`,
+		mid, di.Index, partIdx)
+	if err != nil {
+		log.Errorw("clearing held proofs", "miner", maddr, "deadline", di.Index, "partition", partIdx, "error", err)
+		return
+	}
+	if n > 0 {
+		log.Infow("WindowPoSt proofs verify again, cleared held proofs", "miner", maddr, "deadline", di.Index, "partition", partIdx, "held", n)
+	}
+}
+
+// updateAlert raises the alert while one of the given miners has held
+// proofs, and resolves it once they are all cleared, whichever node held or
+// cleared them.
+func (h *verifyFailureHandler) updateAlert(ctx context.Context, actors []dtypes.MinerAddress) error {
+	if h.al == nil {
+		return nil
+	}
+
+	var held []struct {
+		SpID      uint64 `db:"sp_id"`
+		Deadline  uint64 `db:"deadline_index"`
+		Partition uint64 `db:"partition_index"`
+		Err       string `db:"err"`
+	}
+	if err := h.db.Select(ctx, &held, `SELECT sp_id, deadline_index, partition_index, err FROM wdpost_verify_holds ORDER BY sp_id, deadline_index, partition_index`); err != nil {
+		return xerrors.Errorf("getting held proofs: %w", err)
+	}
+
+	ours := make(map[address.Address]struct{}, len(actors))
+	for _, act := range actors {
+		ours[address.Address(act)] = struct{}{}
+	}
+
+	var proofs []map[string]interface{}
+	for _, hp := range held {
+		maddr, err := address.NewIDAddress(hp.SpID)
+		if err != nil {
+			return err
+		}
+		if _, ok := ours[maddr]; !ok {
+			continue
+		}
+		proofs = append(proofs, map[string]interface{}{
+			"miner":     maddr.String(),
+			"deadline":  hp.Deadline,
+			"partition": hp.Partition,
+			"error":     hp.Err,
+		})
+	}
+
+	if len(proofs) > 0 {
+		if !h.al.IsRaised(h.alert) {
+			h.al.Raise(h.alert, map[string]interface{}{
+				"held": proofs,
+			})
+		}
+	} else if h.al.IsRaised(h.alert) {
+		h.al.Resolve(h.alert, map[string]string{
+			"message": "WindowPoSt proofs verify again",
+		})
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+// recordingJournal keeps the events recorded, by event type.
+type recordingJournal struct {
+	journal.EventTypeRegistry
+	events map[string][]interface{}
+}
+
+func newRecordingJournal() *recordingJournal {
+	return &recordingJournal{
+		EventTypeRegistry: journal.NewEventTypeRegistry(nil),
+		events:            map[string][]interface{}{},
+	}
+}
+
+func (r *recordingJournal) RecordEvent(evtType journal.EventType, supplier func() interface{}) {
+	r.events[evtType.String()] = append(r.events[evtType.String()], supplier())
+}
+
+func (r *recordingJournal) Close() error {
+	return nil
+}
+
+func TestParseVerifyFailurePolicy(t *testing.T) {
+	p, err := ParseVerifyFailurePolicy("")
+	require.NoError(t, err)
+	require.Equal(t, VerifyFailRecompute, p)
+
+	p, err = ParseVerifyFailurePolicy("hold")
+	require.NoError(t, err)
+	require.Equal(t, VerifyFailHold, p)
+
+	_, err = ParseVerifyFailurePolicy("ignore")
+	require.Error(t, err)
+}
+
+type verifyHold = struct {
+	SpID      uint64 `db:"sp_id"`
+	Deadline  uint64 `db:"deadline_index"`
+	Partition uint64 `db:"partition_index"`
+	Err       string `db:"err"`
+}
+
+func TestVerifyFailureHandler(t *testing.T) {
+	ctx := context.Background()
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	di := &dline.Info{Index: 3, Challenge: 100}
+	verr := xerrors.New("proof is invalid")
+
+	t.Run("recompute", func(t *testing.T) {
+		j := newRecordingJournal()
+		h := newVerifyFailureHandler(VerifyFailRecompute, nil, j, nil)
+
+		err := h.failed(ctx, maddr, di, 1, verr)
+		require.ErrorIs(t, err, verr)
+		require.NotErrorIs(t, err, ErrProofHeld)
+		require.Len(t, j.events["wdpost:verify_failed_recompute"], 1)
+	})
+
+	t.Run("hold", func(t *testing.T) {
+		j := newRecordingJournal()
+		db := harmonydb.NewMock()
+		al := alerting.NewAlertingSystem(journal.NilJournal())
+		h := newVerifyFailureHandler(VerifyFailHold, db, j, al)
+		actors := []dtypes.MinerAddress{dtypes.MinerAddress(maddr)}
+
+		db.ExpectExec(`INSERT INTO wdpost_verify_holds`).WithArgs(uint64(1000), uint64(3), uint64(1), abi.ChainEpoch(100), "proof is invalid")
+		err := h.failed(ctx, maddr, di, 1, verr)
+		require.ErrorIs(t, err, ErrProofHeld)
+		require.True(t, al.IsRaised(h.alert))
+		require.Equal(t, []interface{}{&VerifyFailureEvt{
+			Miner:     1000,
+			Deadline:  3,
+			Partition: 1,
+			Challenge: 100,
+			Policy:    VerifyFailHold,
+			Error:     "proof is invalid",
+		}}, j.events["wdpost:verify_failed_hold"])
+
+		require.NoError(t, db.ExpectationsWereMet())
+
+		// another node follows the held proofs of its miners
+		otherAl := alerting.NewAlertingSystem(journal.NilJournal())
+		other := newVerifyFailureHandler(VerifyFailHold, db, j, otherAl)
+		db.ExpectSelect(`FROM wdpost_verify_holds`).WillReturnSelect([]verifyHold{{SpID: 1000, Deadline: 3, Partition: 1, Err: "proof is invalid"}})
+		require.NoError(t, other.updateAlert(ctx, actors))
+		require.True(t, otherAl.IsRaised(other.alert))
+
+		// held proofs of other miners don't concern it
+		db.ExpectSelect(`FROM wdpost_verify_holds`).WillReturnSelect([]verifyHold{{SpID: 1001, Deadline: 3, Partition: 1, Err: "proof is invalid"}})
+		require.NoError(t, other.updateAlert(ctx, actors))
+		require.False(t, otherAl.IsRaised(other.alert))
+
+		// a good proof of another partition of the miner leaves the held
+		// proof of the bad one in place
+		db.ExpectExec(`DELETE FROM wdpost_verify_holds`).WithArgs(uint64(1000), uint64(3), uint64(2)).WillReturnCount(0)
+		other.verified(ctx, maddr, di, 2)
+		db.ExpectSelect(`FROM wdpost_verify_holds`).WillReturnSelect([]verifyHold{{SpID: 1000, Deadline: 3, Partition: 1, Err: "proof is invalid"}})
+		require.NoError(t, h.updateAlert(ctx, actors))
+		require.True(t, al.IsRaised(h.alert))
+
+		// a proof of the held partition which verifies, on any node, clears
+		// it, resolving the alert of every node
+		db.ExpectExec(`DELETE FROM wdpost_verify_holds`).WithArgs(uint64(1000), uint64(3), uint64(1)).WillReturnCount(1)
+		other.verified(ctx, maddr, di, 1)
+		db.ExpectSelect(`FROM wdpost_verify_holds`)
+		require.NoError(t, h.updateAlert(ctx, actors))
+		require.False(t, al.IsRaised(h.alert))
+		require.NoError(t, db.ExpectationsWereMet())
+	})
+
+	t.Run("submit", func(t *testing.T) {
+		j := newRecordingJournal()
+		h := newVerifyFailureHandler(VerifyFailSubmit, nil, j, nil)
+
+		require.NoError(t, h.failed(ctx, maddr, di, 1, verr))
+		require.Len(t, j.events["wdpost:verify_failed_submit"], 1)
+	})
+}
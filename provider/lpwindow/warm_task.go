@@ -0,0 +1,291 @@
+package lpwindow
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+	"github.com/filecoin-project/lotus/lib/harmony/resources"
+	"github.com/filecoin-project/lotus/lib/promise"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+type WarmAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+	StateMinerSectors(context.Context, address.Address, *bitfield.BitField, types.TipSetKey) ([]*miner.SectorOnChainInfo, error)
+}
+
+// WarmTask makes sure that the sector files needed by the next WindowPoSt
+// deadline are present on this node before the deadline opens. Files which
+// are stored elsewhere in the cluster are optionally fetched ahead of time,
+// files which can't be found anywhere are recorded in wdpost_warm_missing,
+// once per deadline, and raise an alert. The history pruner removes old
+// tasks and records.
+//
+// Fetches only ever copy files, so nothing is removed from the nodes proving
+// the current deadline, and they go through the regular storage reservations,
+// so a fetch is refused instead of filling up space other sectors rely on.
+type WarmTask struct {
+	api   WarmAPI
+	db    harmonydb.Interface
+	local paths.Store
+	stor  paths.Store
+	idx   paths.SectorIndex
+
+	actors []dtypes.MinerAddress
+	fetch  bool
+
+	al *alerting.Alerting
+
+	warmTF promise.Promise[harmonytask.AddTaskFunc]
+}
+
+func NewWarmTask(ctx context.Context, db *harmonydb.DB, api WarmAPI, local, stor paths.Store, idx paths.SectorIndex,
+	al *alerting.Alerting, actors []dtypes.MinerAddress, fetch bool) *WarmTask {
+	t := &WarmTask{
+		api:   api,
+		db:    db,
+		local: local,
+		stor:  stor,
+		idx:   idx,
+
+		actors: actors,
+		fetch:  fetch,
+
+		al: al,
+	}
+
+	go t.schedule(ctx)
+
+	return t
+}
+
+func (t *WarmTask) schedule(ctx context.Context) {
+	tick := time.NewTicker(time.Duration(build.BlockDelaySecs) * time.Second)
+	defer tick.Stop()
+
+	for {
+		head, err := t.api.ChainHead(ctx)
+		if err != nil {
+			log.Errorw("warm: getting chain head", "error", err)
+		}
+
+		tf := t.warmTF.Val(ctx)
+		for _, act := range t.actors {
+			if head == nil {
+				break
+			}
+
+			maddr := address.Address(act)
+			spID, err := address.IDFromAddress(maddr)
+			if err != nil {
+				log.Errorw("warm: getting miner ID", "miner", act, "error", err)
+				continue
+			}
+
+			di, err := t.api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+			if err != nil {
+				log.Errorw("warm: getting proving deadline", "miner", act, "error", err)
+				continue
+			}
+
+			next := wdpost.NextDeadline(di)
+
+			tf(func(id harmonytask.TaskID, tx *harmonydb.Tx) (bool, error) {
+				n, err := tx.Exec(`INSERT INTO wdpost_warm_tasks (task_id, sp_id, proving_period_start, deadline_index) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+					id, spID, next.PeriodStart, next.Index)
+				if err != nil {
+					return false, err
+				}
+
+				// already scheduled by another node
+				return n == 1, nil
+			})
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *WarmTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (done bool, err error) {
+	ctx := context.Background()
+
+	var spID, dlIdx uint64
+	var pps abi.ChainEpoch
+	err = t.db.QueryRow(ctx, `SELECT sp_id, proving_period_start, deadline_index FROM wdpost_warm_tasks WHERE task_id = $1`, taskID).Scan(&spID, &pps, &dlIdx)
+	if err != nil {
+		return false, xerrors.Errorf("getting warm task: %w", err)
+	}
+
+	maddr, err := address.NewIDAddress(spID)
+	if err != nil {
+		return false, err
+	}
+
+	head, err := t.api.ChainHead(ctx)
+	if err != nil {
+		return false, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	di := wdpost.NewDeadlineInfo(pps, dlIdx, head.Height())
+	if di.HasElapsed() {
+		// too late to help
+		return true, nil
+	}
+
+	parts, err := t.api.StateMinerPartitions(ctx, maddr, dlIdx, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("getting partitions: %w", err)
+	}
+
+	var live []bitfield.BitField
+	for _, p := range parts {
+		live = append(live, p.LiveSectors)
+	}
+
+	toWarm, err := bitfield.MultiMerge(live...)
+	if err != nil {
+		return false, xerrors.Errorf("merging live sectors: %w", err)
+	}
+
+	sectors, err := t.api.StateMinerSectors(ctx, maddr, &toWarm, head.Key())
+	if err != nil {
+		return false, xerrors.Errorf("getting sector infos: %w", err)
+	}
+
+	var missing, fetched int
+	for _, info := range sectors {
+		if !stillOwned() {
+			return false, xerrors.Errorf("lost task ownership")
+		}
+
+		ref := storiface.SectorRef{
+			ID: abi.SectorID{
+				Miner:  abi.ActorID(spID),
+				Number: info.SectorNumber,
+			},
+			ProofType: info.SealProof,
+		}
+
+		needed := storiface.FTSealed | storiface.FTCache
+		if info.SectorKeyCID != nil {
+			needed = storiface.FTUpdate | storiface.FTUpdateCache
+		}
+
+		have, _, err := t.local.AcquireSector(ctx, ref, needed, storiface.FTNone, storiface.PathStorage, storiface.AcquireCopy)
+		if err != nil {
+			return false, xerrors.Errorf("checking local sector files: %w", err)
+		}
+
+		var toFetch storiface.SectorFileType
+		for _, ft := range needed.AllSet() {
+			if storiface.PathByType(have, ft) != "" {
+				continue
+			}
+
+			found, err := t.idx.StorageFindSector(ctx, ref.ID, ft, 0, false)
+			if err != nil {
+				return false, xerrors.Errorf("finding sector files: %w", err)
+			}
+
+			if len(found) == 0 {
+				missing++
+				_, err = t.db.Exec(ctx, `INSERT INTO wdpost_warm_missing (sp_id, proving_period_start, deadline_index, sector_number, file_type) VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT (sp_id, proving_period_start, deadline_index, sector_number, file_type) DO UPDATE SET reported_at = EXCLUDED.reported_at`,
+					spID, pps, dlIdx, info.SectorNumber, ft.String())
+				if err != nil {
+					return false, xerrors.Errorf("recording missing file: %w", err)
+				}
+				continue
+			}
+
+			toFetch |= ft
+		}
+
+		if toFetch == storiface.FTNone || !t.fetch {
+			continue
+		}
+
+		if _, _, err := t.stor.AcquireSector(ctx, ref, toFetch, storiface.FTNone, storiface.PathStorage, storiface.AcquireCopy); err != nil {
+			// not fatal, the deadline will fetch on demand
+			log.Warnw("warm: fetching sector files", "miner", maddr, "sector", info.SectorNumber, "types", toFetch.String(), "error", err)
+			continue
+		}
+		fetched++
+	}
+
+	log.Infow("warmed deadline", "miner", maddr, "deadline", dlIdx, "sectors", len(sectors), "fetched", fetched, "missing", missing)
+
+	at := t.al.AddAlertType("lpwindow", "warm-"+maddr.String())
+
+	if missing > 0 {
+		t.al.Raise(at, map[string]interface{}{
+			"miner":    maddr.String(),
+			"deadline": dlIdx,
+			"missing":  missing,
+		})
+	} else if t.al.IsRaised(at) {
+		t.al.Resolve(at, map[string]interface{}{
+			"miner":    maddr.String(),
+			"deadline": dlIdx,
+		})
+	}
+
+	return true, nil
+}
+
+func (t *WarmTask) CanAccept(ids []harmonytask.TaskID, engine *harmonytask.TaskEngine) (*harmonytask.TaskID, error) {
+	// never compete with real proving work
+	var pending int
+	err := t.db.QueryRow(context.Background(), `SELECT COUNT(*) FROM harmony_task WHERE name IN ('WdPost', 'WdPostSubmit', 'WdPostRecover')`).Scan(&pending)
+	if err != nil {
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, nil
+	}
+
+	id := ids[0]
+	return &id, nil
+}
+
+func (t *WarmTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{
+		Name:        "WdPostWarm",
+		Max:         1,
+		MaxFailures: 3,
+		Cost: resources.Resources{
+			Cpu: 1,
+			Gpu: 0,
+			Ram: 128 << 20,
+		},
+	}
+}
+
+func (t *WarmTask) Adder(taskFunc harmonytask.AddTaskFunc) {
+	t.warmTF.Set(taskFunc)
+}
+
+var _ harmonytask.TaskInterface = &WarmTask{}
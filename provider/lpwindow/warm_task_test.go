@@ -0,0 +1,96 @@
+package lpwindow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/storage/paths/mocks"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+type warmAPI struct {
+	head    *types.TipSet
+	sectors []*miner.SectorOnChainInfo
+}
+
+func (a *warmAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return a.head, nil
+}
+
+func (a *warmAPI) StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error) {
+	panic("not used by Do")
+}
+
+func (a *warmAPI) StateMinerPartitions(ctx context.Context, maddr address.Address, dlIdx uint64, tsk types.TipSetKey) ([]api.Partition, error) {
+	live := bitfield.New()
+	for _, s := range a.sectors {
+		live.Set(uint64(s.SectorNumber))
+	}
+	return []api.Partition{{LiveSectors: live}}, nil
+}
+
+func (a *warmAPI) StateMinerSectors(ctx context.Context, maddr address.Address, bf *bitfield.BitField, tsk types.TipSetKey) ([]*miner.SectorOnChainInfo, error) {
+	return a.sectors, nil
+}
+
+func TestWarmRecordsMissingOncePerDeadline(t *testing.T) {
+	const taskID = 7
+	pps := abi.ChainEpoch(1000)
+
+	b := mock.MkBlock(nil, 1, 1)
+	b.Height = pps
+	wapi := &warmAPI{
+		head:    mock.TipSet(b),
+		sectors: []*miner.SectorOnChainInfo{{SectorNumber: 5, SealProof: abi.RegisteredSealProof_StackedDrg32GiBV1_1}},
+	}
+
+	ctrl := gomock.NewController(t)
+	local := mocks.NewMockStore(ctrl)
+	idx := mocks.NewMockSectorIndex(ctrl)
+
+	// the sector files are neither on this node nor anywhere else
+	local.EXPECT().AcquireSector(gomock.Any(), gomock.Any(), storiface.FTSealed|storiface.FTCache, storiface.FTNone, storiface.PathStorage, storiface.AcquireCopy).
+		Return(storiface.SectorPaths{}, storiface.SectorPaths{}, nil).Times(2)
+	idx.EXPECT().StorageFindSector(gomock.Any(), abi.SectorID{Miner: 1000, Number: 5}, gomock.Any(), abi.SectorSize(0), false).
+		Return(nil, nil).Times(4)
+
+	db := harmonydb.NewMock()
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+	task := &WarmTask{api: wapi, db: db, local: local, idx: idx, al: al}
+
+	// warming the deadline twice records each missing file once: the second
+	// run only updates when it was reported
+	for i := 0; i < 2; i++ {
+		db.ExpectQueryRow(`FROM wdpost_warm_tasks WHERE task_id = $1`).WithArgs(taskID).
+			WillReturnRows([]any{uint64(1000), pps, uint64(3)})
+		for _, ft := range []string{"sealed", "cache"} {
+			db.ExpectExec(`INSERT INTO wdpost_warm_missing (sp_id, proving_period_start, deadline_index, sector_number, file_type) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT (sp_id, proving_period_start, deadline_index, sector_number, file_type) DO UPDATE SET reported_at = EXCLUDED.reported_at`).
+				WithArgs(uint64(1000), pps, uint64(3), abi.SectorNumber(5), ft)
+		}
+
+		done, err := task.Do(taskID, func() bool { return true })
+		require.NoError(t, err)
+		require.True(t, done)
+	}
+	require.NoError(t, db.ExpectationsWereMet())
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	require.True(t, al.IsRaised(al.AddAlertType("lpwindow", "warm-"+maddr.String())))
+}
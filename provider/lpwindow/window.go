@@ -0,0 +1,48 @@
+package lpwindow
+
+import (
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+)
+
+// SafetyMarginFunc returns the deadline safety margin of a miner in epochs,
+// see config.ProvingConfig.DeadlineSafetyMargin.
+type SafetyMarginFunc func(maddr address.Address) abi.ChainEpoch
+
+// submitGuardEpochs is the least number of epochs left in a deadline when
+// proofs are submitted, whatever the safety margin, so that the messages have
+// time to land on chain.
+const submitGuardEpochs = abi.ChainEpoch(10)
+
+// provingWindow is when proofs for a deadline are computed and submitted.
+type provingWindow struct {
+	ComputeAt abi.ChainEpoch
+	SubmitAt  abi.ChainEpoch
+	SubmitBy  abi.ChainEpoch
+}
+
+// effectiveWindow shifts the proving window of di by margin epochs, earlier
+// for positive margins. Computing can't start before the challenge epoch is
+// in the chain, submitting can't happen before the deadline opens, and
+// neither is moved closer than submitGuardEpochs to the deadline close.
+func effectiveWindow(di *dline.Info, margin abi.ChainEpoch) provingWindow {
+	w := provingWindow{
+		ComputeAt: di.Open - margin,
+		SubmitBy:  di.Close,
+	}
+
+	if w.ComputeAt <= di.Challenge {
+		w.ComputeAt = di.Challenge + 1
+	}
+	if latest := di.Close - submitGuardEpochs; w.ComputeAt > latest {
+		w.ComputeAt = latest
+	}
+
+	w.SubmitAt = w.ComputeAt
+	if w.SubmitAt < di.Open {
+		w.SubmitAt = di.Open
+	}
+
+	return w
+}
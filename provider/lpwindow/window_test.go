@@ -0,0 +1,37 @@
+package lpwindow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/wdpost"
+)
+
+func TestEffectiveWindow(t *testing.T) {
+	di := wdpost.NewDeadlineInfo(0, 3, 0)
+
+	w := effectiveWindow(di, 0)
+	require.Equal(t, provingWindow{ComputeAt: di.Open, SubmitAt: di.Open, SubmitBy: di.Close}, w)
+
+	// earlier, but not before the challenge is known
+	w = effectiveWindow(di, 5)
+	require.Equal(t, di.Open-5, w.ComputeAt)
+	require.Equal(t, di.Open, w.SubmitAt)
+
+	w = effectiveWindow(di, 1000)
+	require.Equal(t, di.Challenge+1, w.ComputeAt)
+	require.Equal(t, di.Open, w.SubmitAt)
+
+	// later, but never past the submit guard
+	w = effectiveWindow(di, -15)
+	require.Equal(t, di.Open+15, w.ComputeAt)
+	require.Equal(t, di.Open+15, w.SubmitAt)
+
+	w = effectiveWindow(di, -abi.ChainEpoch(1000))
+	require.Equal(t, di.Close-submitGuardEpochs, w.ComputeAt)
+	require.Equal(t, di.Close-submitGuardEpochs, w.SubmitAt)
+	require.Less(t, w.SubmitAt, w.SubmitBy)
+}
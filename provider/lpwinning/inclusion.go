@@ -0,0 +1,145 @@
+package lpwinning
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/provider/chainsched"
+)
+
+// inclusionDepth is how many epochs a submitted block must be below the head
+// before it is reconciled with the canonical chain.
+var inclusionDepth = abi.ChainEpoch(build.MessageConfidence)
+
+type InclusionAPI interface {
+	ChainGetTipSetByHeight(context.Context, abi.ChainEpoch, types.TipSetKey) (*types.TipSet, error)
+}
+
+// InclusionTracker follows the blocks submitted by the WinningPoSt tasks, and
+// once they are inclusionDepth epochs deep records in mining_tasks whether
+// they landed in the canonical chain or were orphaned. Orphaned blocks point
+// at propagation problems rather than proving ones, as their proofs were
+// valid when they were built.
+type InclusionTracker struct {
+	api    InclusionAPI
+	db     harmonydb.Interface
+	actors []dtypes.MinerAddress
+}
+
+type submittedBlock struct {
+	TaskID   int64          `db:"task_id"`
+	SpID     uint64         `db:"sp_id"`
+	Epoch    abi.ChainEpoch `db:"epoch"`
+	MinedCID string         `db:"mined_cid"`
+}
+
+func NewInclusionTracker(pcs *chainsched.ProviderChainSched, api InclusionAPI, db harmonydb.Interface, actors []dtypes.MinerAddress) (*InclusionTracker, error) {
+	t := &InclusionTracker{
+		api:    api,
+		db:     db,
+		actors: actors,
+	}
+
+	if err := pcs.AddHandler(t.processHeadChange); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *InclusionTracker) processHeadChange(ctx context.Context, revert, apply *types.TipSet) error {
+	if len(t.actors) == 0 {
+		return nil
+	}
+
+	spIDs := make([]string, 0, len(t.actors))
+	for _, act := range t.actors {
+		id, err := address.IDFromAddress(address.Address(act))
+		if err != nil {
+			return xerrors.Errorf("getting miner ID: %w", err)
+		}
+		spIDs = append(spIDs, strconv.FormatUint(id, 10))
+	}
+
+	var blocks []submittedBlock
+	err := t.db.Select(ctx, &blocks, `SELECT task_id, sp_id, epoch, mined_cid FROM mining_tasks
+			WHERE won = true AND submitted_at IS NOT NULL AND included IS NULL AND epoch <= $1
+				AND sp_id IN (SELECT unnest(string_to_array($2, ','))::bigint)
+			ORDER BY epoch`, apply.Height()-inclusionDepth, strings.Join(spIDs, ","))
+	if err != nil {
+		return xerrors.Errorf("getting submitted blocks: %w", err)
+	}
+
+	for _, b := range blocks {
+		if err := t.reconcile(ctx, apply, b); err != nil {
+			return xerrors.Errorf("reconciling block mined for epoch %d: %w", b.Epoch, err)
+		}
+	}
+
+	return nil
+}
+
+func (t *InclusionTracker) reconcile(ctx context.Context, head *types.TipSet, b submittedBlock) error {
+	bcid, err := cid.Parse(b.MinedCID)
+	if err != nil {
+		return xerrors.Errorf("parsing mined cid: %w", err)
+	}
+
+	// for a null round this returns the tipset before it, which doesn't contain our block either
+	ts, err := t.api.ChainGetTipSetByHeight(ctx, b.Epoch, head.Key())
+	if err != nil {
+		return xerrors.Errorf("getting tipset at epoch %d: %w", b.Epoch, err)
+	}
+
+	included := false
+	for _, c := range ts.Cids() {
+		if c == bcid {
+			included = true
+			break
+		}
+	}
+
+	n, err := t.db.Exec(ctx, `UPDATE mining_tasks SET included = $2, included_checked_at = CURRENT_TIMESTAMP
+		WHERE task_id = $1 AND included IS NULL`, b.TaskID, included)
+	if err != nil {
+		return xerrors.Errorf("recording block inclusion: %w", err)
+	}
+	if n == 0 {
+		// reconciled by another node
+		return nil
+	}
+
+	maddr, err := address.NewIDAddress(b.SpID)
+	if err != nil {
+		return err
+	}
+
+	outcome := "included"
+	if included {
+		log.Infow("mined block is in the canonical chain", "miner", maddr, "epoch", b.Epoch, "cid", bcid)
+	} else {
+		outcome = "orphaned"
+		log.Warnw("mined block was orphaned", "miner", maddr, "epoch", b.Epoch, "cid", bcid, "tipset", types.LogCids(ts.Cids()), "tipsetHeight", ts.Height())
+	}
+
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{
+		tag.Upsert(metrics.MinerID, maddr.String()),
+		tag.Upsert(OutcomeKey, outcome),
+	}, WinningMeasures.MinedBlocks.M(1))
+
+	return nil
+}
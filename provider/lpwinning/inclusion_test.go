@@ -0,0 +1,57 @@
+package lpwinning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/chain/types/mock"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+type inclusionAPI map[abi.ChainEpoch]*types.TipSet
+
+func (a inclusionAPI) ChainGetTipSetByHeight(ctx context.Context, h abi.ChainEpoch, tsk types.TipSetKey) (*types.TipSet, error) {
+	return a[h], nil
+}
+
+func TestInclusionTracker(t *testing.T) {
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	ours := mock.MkBlock(nil, 1, 1)
+	ours.Height = 100
+	other := mock.MkBlock(nil, 2, 2)
+	other.Height = 101
+
+	chain := inclusionAPI{
+		100: mock.TipSet(ours),
+		101: mock.TipSet(other),
+	}
+
+	db := harmonydb.NewMock()
+	tr := &InclusionTracker{api: chain, db: db, actors: []dtypes.MinerAddress{dtypes.MinerAddress(maddr)}}
+
+	head := mock.TipSet(mock.MkBlock(chain[101], 3, 3))
+	require.Equal(t, abi.ChainEpoch(102), head.Height())
+
+	db.ExpectSelect(`FROM mining_tasks`).WithArgs(head.Height()-inclusionDepth, "1000").WillReturnSelect([]submittedBlock{
+		{TaskID: 1, SpID: 1000, Epoch: 100, MinedCID: ours.Cid().String()},
+		// our block at 101 lost against another one
+		{TaskID: 2, SpID: 1000, Epoch: 101, MinedCID: mock.MkBlock(mock.TipSet(ours), 4, 4).Cid().String()},
+		// already reconciled by another node
+		{TaskID: 3, SpID: 1000, Epoch: 101, MinedCID: ours.Cid().String()},
+	})
+	db.ExpectExec(`UPDATE mining_tasks SET included`).WithArgs(1, true).WillReturnCount(1)
+	db.ExpectExec(`UPDATE mining_tasks SET included`).WithArgs(2, false).WillReturnCount(1)
+	db.ExpectExec(`UPDATE mining_tasks SET included`).WithArgs(3, false).WillReturnCount(0)
+
+	require.NoError(t, tr.processHeadChange(context.Background(), nil, head))
+	require.NoError(t, db.ExpectationsWereMet())
+}
@@ -0,0 +1,328 @@
+package lpwinning
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+// LeaderLease is how long this node considers itself the WinningPoSt block
+// submitter for a miner after it last confirmed that it holds the leader
+// lock. It's also how long the leader shown in winpost_leaders stays valid
+// without being refreshed.
+var LeaderLease = 15 * time.Second
+
+// LeaderRenewInterval is how often the leader checks that it still holds the
+// lock, and followers try to take it.
+var LeaderRenewInterval = 3 * time.Second
+
+// minedBlockChannel is the channel followers announce the blocks they
+// stored for the leader on, with the miner ID as payload.
+const minedBlockChannel = "winpost_mined_block"
+
+// leaderLockBase is the advisory lock key space of WinningPoSt leadership,
+// the key of a miner is leaderLockBase + its ID.
+const leaderLockBase int64 = 0x57696e50 << 32 // "WinP"
+
+// lockSession is the part of harmonydb.LockSession used for leadership.
+type lockSession interface {
+	TryLock(ctx context.Context, key int64) (bool, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// leaderElection keeps track of which miners this node is the WinningPoSt
+// block submitter for. Leadership of a miner is a database advisory lock,
+// held by a session of the node; the database releases it when the session
+// ends, so a new leader takes over as soon as the previous one dies. Any
+// node can compute blocks, only the leader submits them; blocks computed by
+// followers are handed to the leader through mining_tasks.block_msg, see
+// WinPostTask.submitLoop.
+type leaderElection struct {
+	db      harmonydb.Interface
+	connect func(ctx context.Context) (lockSession, error)
+	id      string
+	host    string
+
+	// session is only used by run
+	session lockSession
+
+	lk      sync.Mutex
+	leading map[uint64]time.Time // sp_id -> local lease expiry
+}
+
+func newLeaderElection(db *harmonydb.DB, host string) *leaderElection {
+	return &leaderElection{
+		db: db,
+		connect: func(ctx context.Context) (lockSession, error) {
+			return db.LockSession(ctx)
+		},
+		id:      uuid.New().String(),
+		host:    host,
+		leading: map[uint64]time.Time{},
+	}
+}
+
+// isLeader returns whether this node held the lock of the miner recently.
+func (l *leaderElection) isLeader(spID uint64) bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	return time.Now().Before(l.leading[spID])
+}
+
+// run keeps the leadership of the miners of t up to date until ctx is done,
+// then gives it up.
+func (l *leaderElection) run(ctx context.Context, t *WinPostTask) {
+	defer l.closeSession()
+
+	for {
+		for _, act := range t.actors {
+			maddr := address.Address(act)
+			spID, err := address.IDFromAddress(maddr)
+			if err != nil {
+				log.Errorw("leader election: getting miner ID", "miner", maddr, "error", err)
+				continue
+			}
+
+			was := l.isLeader(spID)
+			leader, err := l.renew(ctx, spID)
+			if err != nil {
+				log.Errorw("leader election: renewing leadership", "miner", maddr, "error", err)
+				continue
+			}
+
+			var v int64
+			if leader {
+				v = 1
+				if !was {
+					// blocks may have been stored while there was no leader
+					t.wakeSubmit()
+				}
+			}
+			_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(metrics.MinerID, maddr.String())}, WinningMeasures.Leader.M(v))
+		}
+
+		select {
+		case <-time.After(LeaderRenewInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// renew takes the lock of the miner, or checks that this node still holds
+// it, returning whether this node is the leader.
+func (l *leaderElection) renew(ctx context.Context, spID uint64) (bool, error) {
+	start := time.Now()
+
+	if l.session == nil {
+		session, err := l.connect(ctx)
+		if err != nil {
+			return false, xerrors.Errorf("opening lock session: %w", err)
+		}
+		l.session = session
+	}
+
+	l.lk.Lock()
+	_, was := l.leading[spID]
+	l.lk.Unlock()
+
+	if was {
+		// the locks are gone with the session, other nodes may hold them now
+		if err := l.session.Ping(ctx); err != nil {
+			l.closeSession()
+			return false, xerrors.Errorf("lock session failed: %w", err)
+		}
+	} else {
+		ok, err := l.session.TryLock(ctx, leaderLockBase+int64(spID))
+		if err != nil {
+			l.closeSession()
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+		log.Infow("became WinningPoSt leader", "sp_id", spID, "host", l.host)
+	}
+
+	l.lk.Lock()
+	l.leading[spID] = start.Add(LeaderLease)
+	l.lk.Unlock()
+
+	// shown by the winning-leaders and cluster commands
+	_, err := l.db.Exec(ctx, `INSERT INTO winpost_leaders (sp_id, leader_id, leader_host, lease_expires)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + INTERVAL '1 MILLISECOND' * $4)
+		ON CONFLICT (sp_id) DO UPDATE
+			SET leader_id = EXCLUDED.leader_id, leader_host = EXCLUDED.leader_host, lease_expires = EXCLUDED.lease_expires`,
+		spID, l.id, l.host, LeaderLease.Milliseconds())
+	if err != nil {
+		log.Warnw("recording WinningPoSt leader", "sp_id", spID, "error", err)
+	}
+
+	return true, nil
+}
+
+// closeSession ends the lock session, giving up the leadership of all
+// miners.
+func (l *leaderElection) closeSession() {
+	if l.session == nil {
+		return
+	}
+	_ = l.session.Close()
+	l.session = nil
+
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	for spID := range l.leading {
+		log.Warnw("lost WinningPoSt leadership", "sp_id", spID)
+		delete(l.leading, spID)
+	}
+}
+
+// submitLoop submits the blocks computed by followers for the miners this
+// node leads, until ctx is done. Followers announce the blocks they store
+// with NOTIFY, which wakes the loop right away; it also checks every
+// LeaderRenewInterval, for announcements which were missed and on databases
+// without LISTEN support.
+func (t *WinPostTask) submitLoop(ctx context.Context) {
+	if err := t.db.Listen(ctx, minedBlockChannel, func(string) { t.wakeSubmit() }); err != nil {
+		log.Warnw("subscribing to blocks mined by other nodes, polling for them", "error", err)
+	}
+
+	for {
+		for _, act := range t.actors {
+			maddr := address.Address(act)
+			spID, err := address.IDFromAddress(maddr)
+			if err != nil {
+				log.Errorw("getting miner ID", "miner", maddr, "error", err)
+				continue
+			}
+			if !t.leader.isLeader(spID) {
+				continue
+			}
+
+			if err := t.submitPending(ctx, spID); err != nil {
+				log.Errorw("submitting blocks mined by other nodes", "miner", maddr, "error", err)
+			}
+		}
+
+		select {
+		case <-t.submitWake:
+		case <-time.After(LeaderRenewInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wakeSubmit makes submitLoop check for pending blocks now.
+func (t *WinPostTask) wakeSubmit() {
+	select {
+	case t.submitWake <- struct{}{}:
+	default:
+	}
+}
+
+// announceBlock tells the leader that a block of the miner was stored for
+// it to submit.
+func (t *WinPostTask) announceBlock(ctx context.Context, spID uint64) {
+	if err := t.db.Notify(ctx, minedBlockChannel, strconv.FormatUint(spID, 10)); err != nil {
+		// the leader finds the block on its next check
+		log.Warnw("announcing mined block to the leader", "sp_id", spID, "error", err)
+	}
+}
+
+// submitPending starts the submission of the blocks for the miner which
+// were computed by follower nodes, and not submitted yet. Submissions wait
+// for the block timestamp, each runs on its own so that they don't hold up
+// each other or the loop.
+func (t *WinPostTask) submitPending(ctx context.Context, spID uint64) error {
+	var pending []struct {
+		TaskID   int64  `db:"task_id"`
+		BlockMsg []byte `db:"block_msg"`
+	}
+	err := t.db.Select(ctx, &pending, `SELECT task_id, block_msg FROM mining_tasks
+		WHERE sp_id = $1 AND won = true AND submitted_at IS NULL AND block_msg IS NOT NULL
+			AND mined_at > CURRENT_TIMESTAMP - INTERVAL '1 MILLISECOND' * $2`,
+		spID, LeaderLease.Milliseconds()*4)
+	if err != nil {
+		return xerrors.Errorf("getting pending blocks: %w", err)
+	}
+
+	for _, p := range pending {
+		var bm types.BlockMsg
+		if err := bm.UnmarshalCBOR(bytes.NewReader(p.BlockMsg)); err != nil {
+			return xerrors.Errorf("decoding block msg: %w", err)
+		}
+
+		go func(taskID int64) {
+			if err := t.submitBlock(ctx, taskID, &bm); err != nil {
+				log.Errorw("submitting block mined by another node", "sp_id", spID, "task", taskID, "error", err)
+			}
+		}(p.TaskID)
+	}
+
+	return nil
+}
+
+// submitBlock submits the block of the mining task unless it was submitted
+// already. Only the leader submits, and only one submission of a task runs
+// on it at a time, so the block is submitted once; submitted_at records when.
+func (t *WinPostTask) submitBlock(ctx context.Context, taskID int64, blockMsg *types.BlockMsg) error {
+	t.submitLk.Lock()
+	if _, ok := t.submitting[taskID]; ok {
+		t.submitLk.Unlock()
+		return nil
+	}
+	t.submitting[taskID] = struct{}{}
+	t.submitLk.Unlock()
+
+	defer func() {
+		t.submitLk.Lock()
+		delete(t.submitting, taskID)
+		t.submitLk.Unlock()
+	}()
+
+	var submitted bool
+	err := t.db.QueryRow(ctx, `SELECT submitted_at IS NOT NULL FROM mining_tasks WHERE task_id = $1`, taskID).Scan(&submitted)
+	if err != nil {
+		return xerrors.Errorf("getting mining task: %w", err)
+	}
+	if submitted {
+		return nil
+	}
+
+	// wait until block timestamp
+	select {
+	case <-time.After(time.Until(time.Unix(int64(blockMsg.Header.Timestamp), 0))):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := t.api.SyncSubmitBlock(ctx, blockMsg); err != nil {
+		return xerrors.Errorf("failed to submit block: %w", err)
+	}
+
+	_, err = t.db.Exec(ctx, `UPDATE mining_tasks SET submitted_at = $2 WHERE task_id = $1`, taskID, time.Now().UTC())
+	if err != nil {
+		return xerrors.Errorf("failed to update mining task: %w", err)
+	}
+
+	log.Infow("mined a block", "tipset", types.LogCids(blockMsg.Header.Parents), "height", blockMsg.Header.Height, "miner", blockMsg.Header.Miner, "cid", blockMsg.Header.Cid())
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package lpwinning
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+// fakeLocks are advisory locks shared by the sessions of several nodes.
+type fakeLocks struct {
+	held map[int64]*fakeSession
+}
+
+type fakeSession struct {
+	locks  *fakeLocks
+	dead   bool
+	closed bool
+}
+
+func (f *fakeLocks) connect(ctx context.Context) (lockSession, error) {
+	return &fakeSession{locks: f}, nil
+}
+
+func (s *fakeSession) TryLock(ctx context.Context, key int64) (bool, error) {
+	if s.dead {
+		return false, xerrors.New("connection reset")
+	}
+	if h, ok := s.locks.held[key]; ok && h != s {
+		return false, nil
+	}
+	s.locks.held[key] = s
+	return true, nil
+}
+
+func (s *fakeSession) Ping(ctx context.Context) error {
+	if s.dead {
+		return xerrors.New("connection reset")
+	}
+	return nil
+}
+
+// Close releases the locks of the session, like the database does when a
+// session ends.
+func (s *fakeSession) Close() error {
+	s.closed = true
+	for k, h := range s.locks.held {
+		if h == s {
+			delete(s.locks.held, k)
+		}
+	}
+	return nil
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	locks := &fakeLocks{held: map[int64]*fakeSession{}}
+	db := harmonydb.NewMock()
+
+	mkNode := func(host string) *leaderElection {
+		return &leaderElection{db: db, connect: locks.connect, id: host, host: host, leading: map[uint64]time.Time{}}
+	}
+	a, b := mkNode("a:1"), mkNode("b:1")
+
+	// a takes the lead, b follows
+	db.ExpectExec(`INSERT INTO winpost_leaders`).WithArgs(uint64(1000), "a:1", "a:1", harmonydb.MockAnyArg)
+	leader, err := a.renew(ctx, 1000)
+	require.NoError(t, err)
+	require.True(t, leader)
+	require.True(t, a.isLeader(1000))
+
+	leader, err = b.renew(ctx, 1000)
+	require.NoError(t, err)
+	require.False(t, leader)
+	require.False(t, b.isLeader(1000))
+
+	// a keeps the lead while its session is alive
+	db.ExpectExec(`INSERT INTO winpost_leaders`).WithArgs(uint64(1000), "a:1", "a:1", harmonydb.MockAnyArg)
+	leader, err = a.renew(ctx, 1000)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	// the session of a fails: the database releases the lock, a gives up
+	// the lead and b takes over
+	aSession := a.session.(*fakeSession)
+	aSession.dead = true
+	_ = aSession.Close()
+
+	leader, err = a.renew(ctx, 1000)
+	require.Error(t, err)
+	require.False(t, leader)
+	require.False(t, a.isLeader(1000))
+	require.Nil(t, a.session)
+
+	db.ExpectExec(`INSERT INTO winpost_leaders`).WithArgs(uint64(1000), "b:1", "b:1", harmonydb.MockAnyArg)
+	leader, err = b.renew(ctx, 1000)
+	require.NoError(t, err)
+	require.True(t, leader)
+
+	// a reconnects, and follows
+	leader, err = a.renew(ctx, 1000)
+	require.NoError(t, err)
+	require.False(t, leader)
+	require.NotNil(t, a.session)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
+
+func TestLeaderElectionRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	locks := &fakeLocks{held: map[int64]*fakeSession{}}
+	db := harmonydb.NewMock()
+
+	defer func(iv time.Duration) { LeaderRenewInterval = iv }(LeaderRenewInterval)
+	LeaderRenewInterval = time.Hour
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+
+	l := &leaderElection{db: db, connect: locks.connect, id: "a:1", host: "a:1", leading: map[uint64]time.Time{}}
+	wt := &WinPostTask{actors: []dtypes.MinerAddress{dtypes.MinerAddress(maddr)}, leader: l, submitWake: make(chan struct{}, 1)}
+
+	db.ExpectExec(`INSERT INTO winpost_leaders`).WithArgs(uint64(1000), "a:1", "a:1", harmonydb.MockAnyArg)
+
+	done := make(chan struct{})
+	go func() {
+		l.run(ctx, wt)
+		close(done)
+	}()
+
+	// taking the lead wakes the submission of the blocks stored meanwhile
+	select {
+	case <-wt.submitWake:
+	case <-time.After(5 * time.Second):
+		t.Fatal("submission not woken")
+	}
+	require.True(t, l.isLeader(1000))
+
+	// the lead is given up on shutdown
+	cancel()
+	<-done
+	require.False(t, l.isLeader(1000))
+	require.Empty(t, locks.held)
+
+	require.NoError(t, db.ExpectationsWereMet())
+}
@@ -0,0 +1,186 @@
+package lpwinning
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "winningpost_"
+
+var (
+	OutcomeKey, _ = tag.NewKey("outcome")
+)
+
+// WinningMeasures groups all WinningPoSt task metrics.
+//
+// Mining attempts are rare events, most epochs a miner isn't elected, so
+// the attempt metrics are cumulative counters, to be read as increases
+// over a time range, and last-value gauges which hold the outcome of the
+// latest attempt until the next one.
+var WinningMeasures = struct {
+	Leader      *stats.Int64Measure
+	MinedBlocks *stats.Int64Measure
+
+	Attempts        *stats.Int64Measure
+	Wins            *stats.Int64Measure
+	Successes       *stats.Int64Measure
+	Timeouts        *stats.Int64Measure
+	ComputeDuration *stats.Float64Measure
+	Eligible        *stats.Int64Measure
+	LastAttempt     *stats.Int64Measure
+	LastWin         *stats.Int64Measure
+}{
+	Leader:      stats.Int64(pre+"leader", "1 if this node submits WinningPoSt blocks for the miner, 0 otherwise.", stats.UnitDimensionless),
+	MinedBlocks: stats.Int64(pre+"mined_blocks", "Number of submitted blocks by whether they were included in the canonical chain or orphaned.", stats.UnitDimensionless),
+
+	Attempts:        stats.Int64(pre+"attempts", "Number of epochs a mining attempt was made for, by outcome.", stats.UnitDimensionless),
+	Wins:            stats.Int64(pre+"wins", "Number of epochs the miner was elected to produce a block.", stats.UnitDimensionless),
+	Successes:       stats.Int64(pre+"successes", "Number of blocks produced, with their WinningPoSt.", stats.UnitDimensionless),
+	Timeouts:        stats.Int64(pre+"timeouts", "Number of blocks produced after the propagation cutoff of their epoch.", stats.UnitDimensionless),
+	ComputeDuration: stats.Float64(pre+"compute_ms", "Time to compute a WinningPoSt proof.", stats.UnitMilliseconds),
+	Eligible:        stats.Int64(pre+"eligible", "1 if the miner was eligible for mining at the latest attempt, 0 otherwise.", stats.UnitDimensionless),
+	LastAttempt:     stats.Int64(pre+"last_attempt_epoch", "Epoch of the latest mining attempt.", stats.UnitDimensionless),
+	LastWin:         stats.Int64(pre+"last_win_epoch", "Epoch of the latest election won.", stats.UnitDimensionless),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     WinningMeasures.Leader,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.MinedBlocks,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID, OutcomeKey},
+		},
+		&view.View{
+			Measure:     WinningMeasures.Attempts,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID, OutcomeKey},
+		},
+		&view.View{
+			Measure:     WinningMeasures.Wins,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.Successes,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.Timeouts,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.ComputeDuration,
+			Aggregation: view.Distribution(100, 250, 500, 1000, 2000, 3000, 5000, 7500, 10000, 15000, 20000, 30000),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Name:        pre + "compute_last_ms",
+			Description: "Time to compute the latest WinningPoSt proof.",
+			Measure:     WinningMeasures.ComputeDuration,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.Eligible,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.LastAttempt,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+		&view.View{
+			Measure:     WinningMeasures.LastWin,
+			Aggregation: view.LastValue(),
+			TagKeys:     []tag.Key{metrics.MinerID},
+		},
+	)
+}
+
+// Attempt outcomes.
+const (
+	attemptMined       = "mined"
+	attemptNoWin       = "no-win"
+	attemptNotEligible = "not-eligible"
+	attemptFailed      = "failed"
+)
+
+// epochMetrics collects what happened in the mining attempt for one epoch,
+// and records it all at once when the attempt ends, so that the gauges and
+// counters of an epoch are exported together.
+type epochMetrics struct {
+	miner address.Address
+	epoch abi.ChainEpoch
+
+	// eligible is nil when the attempt failed before the eligibility check
+	eligible *bool
+	won      bool
+	compute  time.Duration
+	mined    bool
+	late     bool
+}
+
+func (m *epochMetrics) setEligible(eligible bool) {
+	m.eligible = &eligible
+}
+
+func (m *epochMetrics) outcome(err error) string {
+	switch {
+	case err != nil:
+		return attemptFailed
+	case m.mined:
+		return attemptMined
+	case m.eligible != nil && !*m.eligible:
+		return attemptNotEligible
+	default:
+		return attemptNoWin
+	}
+}
+
+// record exports the attempt, err is the error the attempt ended with.
+func (m *epochMetrics) record(ctx context.Context, err error) {
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.MinerID, m.miner.String()))
+
+	ms := []stats.Measurement{
+		WinningMeasures.LastAttempt.M(int64(m.epoch)),
+	}
+	if m.eligible != nil {
+		var v int64
+		if *m.eligible {
+			v = 1
+		}
+		ms = append(ms, WinningMeasures.Eligible.M(v))
+	}
+	if m.won {
+		ms = append(ms, WinningMeasures.Wins.M(1), WinningMeasures.LastWin.M(int64(m.epoch)))
+	}
+	if m.compute > 0 {
+		ms = append(ms, WinningMeasures.ComputeDuration.M(float64(m.compute.Milliseconds())))
+	}
+	if m.mined {
+		ms = append(ms, WinningMeasures.Successes.M(1))
+	}
+	if m.late {
+		ms = append(ms, WinningMeasures.Timeouts.M(1))
+	}
+	stats.Record(ctx, ms...)
+
+	_ = stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(OutcomeKey, m.outcome(err))}, WinningMeasures.Attempts.M(1))
+}
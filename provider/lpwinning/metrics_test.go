@@ -0,0 +1,70 @@
+package lpwinning
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+func TestEpochMetrics(t *testing.T) {
+	require.NoError(t, view.Register(metrics.RegisteredViews()...))
+	defer view.Unregister(metrics.RegisteredViews()...)
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	notEligible := &epochMetrics{miner: maddr, epoch: 10}
+	notEligible.setEligible(false)
+	notEligible.record(ctx, nil)
+
+	noWin := &epochMetrics{miner: maddr, epoch: 11}
+	noWin.setEligible(true)
+	noWin.record(ctx, nil)
+
+	mined := &epochMetrics{miner: maddr, epoch: 12, won: true, compute: 1500 * time.Millisecond, mined: true, late: true}
+	mined.setEligible(true)
+	mined.record(ctx, nil)
+
+	failed := &epochMetrics{miner: maddr, epoch: 13}
+	failed.record(ctx, xerrors.New("no beacon"))
+
+	rows, err := view.RetrieveData(pre + "attempts")
+	require.NoError(t, err)
+	byOutcome := map[string]float64{}
+	for _, r := range rows {
+		for _, tg := range r.Tags {
+			if tg.Key == OutcomeKey {
+				byOutcome[tg.Value] = r.Data.(*view.SumData).Value
+			}
+		}
+	}
+	require.Equal(t, map[string]float64{attemptNotEligible: 1, attemptNoWin: 1, attemptMined: 1, attemptFailed: 1}, byOutcome)
+
+	lastValue := func(name string) float64 {
+		rows, err := view.RetrieveData(name)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		return rows[0].Data.(*view.LastValueData).Value
+	}
+	// gauges hold the latest values, the failed attempt didn't get to the eligibility check
+	require.Equal(t, float64(13), lastValue(pre+"last_attempt_epoch"))
+	require.Equal(t, float64(12), lastValue(pre+"last_win_epoch"))
+	require.Equal(t, float64(1), lastValue(pre+"eligible"))
+	require.Equal(t, float64(1500), lastValue(pre+"compute_last_ms"))
+
+	for _, name := range []string{"wins", "successes", "timeouts"} {
+		rows, err := view.RetrieveData(pre + name)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		require.Equal(t, float64(1), rows[0].Data.(*view.SumData).Value, name)
+	}
+}
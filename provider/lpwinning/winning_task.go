@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -29,6 +30,7 @@ import (
 	"github.com/filecoin-project/lotus/lib/harmony/resources"
 	"github.com/filecoin-project/lotus/lib/promise"
 	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/provider/lprand"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
 
@@ -40,11 +42,21 @@ type WinPostTask struct {
 
 	prover   ProverWinningPoSt
 	verifier storiface.Verifier
+	rand     lprand.Source
 
 	api    WinPostAPI
 	actors []dtypes.MinerAddress
 
 	mineTF promise.Promise[harmonytask.AddTaskFunc]
+
+	leader *leaderElection
+
+	// tasks whose block is being submitted, see submitBlock
+	submitLk   sync.Mutex
+	submitting map[int64]struct{}
+
+	// wakes submitLoop, see wakeSubmit
+	submitWake chan struct{}
 }
 
 type WinPostAPI interface {
@@ -70,18 +82,27 @@ type ProverWinningPoSt interface {
 	GenerateWinningPoSt(ctx context.Context, ppt abi.RegisteredPoStProof, minerID abi.ActorID, sectorInfo []storiface.PostSectorChallenge, randomness abi.PoStRandomness) ([]prooftypes.PoStProof, error)
 }
 
-func NewWinPostTask(max int, db *harmonydb.DB, prover ProverWinningPoSt, verifier storiface.Verifier, api WinPostAPI, actors []dtypes.MinerAddress) *WinPostTask {
+// NewWinPostTask creates the WinningPoSt task; it mines and takes part in
+// the leader election of the actors until ctx is done.
+func NewWinPostTask(ctx context.Context, max int, db *harmonydb.DB, prover ProverWinningPoSt, verifier storiface.Verifier, rand lprand.Source, api WinPostAPI, actors []dtypes.MinerAddress, hostAndPort string) *WinPostTask {
 	t := &WinPostTask{
 		max:      max,
 		db:       db,
 		prover:   prover,
 		verifier: verifier,
+		rand:     rand,
 		api:      api,
 		actors:   actors,
+
+		leader:     newLeaderElection(db, hostAndPort),
+		submitting: map[int64]struct{}{},
+		submitWake: make(chan struct{}, 1),
 	}
 	// TODO: run warmup
 
-	go t.mineBasic(context.TODO())
+	go t.mineBasic(ctx)
+	go t.leader.run(ctx, t)
+	go t.submitLoop(ctx)
 
 	return t
 }
@@ -135,6 +156,11 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 		return false, err
 	}
 
+	em := &epochMetrics{miner: maddr, epoch: abi.ChainEpoch(details.Epoch)}
+	defer func() {
+		em.record(ctx, err)
+	}()
+
 	var bcids []cid.Cid
 	for _, c := range details.BlockCIDs {
 		bcid, err := cid.Parse(c.CID)
@@ -168,7 +194,7 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 	// ensure we have a beacon entry for the epoch we're mining on
 	round := base.epoch()
 
-	_ = retry1(func() (*types.BeaconEntry, error) {
+	_, _ = retry1(ctx, func() (*types.BeaconEntry, error) {
 		return t.api.StateGetBeaconEntry(ctx, round)
 	})
 
@@ -180,11 +206,14 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 		return false, xerrors.Errorf("failed to get mining base info: %w", err)
 	}
 	if mbi == nil {
+		em.setEligible(false)
+
 		// not eligible to mine on this base, we're done here
 		log.Debugw("WinPoSt not eligible to mine on this base", "tipset", types.LogCids(base.TipSet.Cids()))
 		return true, persistNoWin()
 	}
 
+	em.setEligible(mbi.EligibleForMining)
 	if !mbi.EligibleForMining {
 		// slashed or just have no power yet, we're done here
 		log.Debugw("WinPoSt not eligible for mining", "tipset", types.LogCids(base.TipSet.Cids()))
@@ -219,6 +248,7 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 			log.Debugw("WinPoSt not a winner", "tipset", types.LogCids(base.TipSet.Cids()))
 			return true, persistNoWin()
 		}
+		em.won = true
 	}
 
 	// winning PoSt
@@ -230,7 +260,7 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 			return false, err
 		}
 
-		brand, err := lrand.DrawRandomnessFromBase(rbase.Data, crypto.DomainSeparationTag_WinningPoStChallengeSeed, round, buf.Bytes())
+		brand, err := t.rand.DrawFromBeaconEntry(&rbase, crypto.DomainSeparationTag_WinningPoStChallengeSeed, round, buf.Bytes())
 		if err != nil {
 			err = xerrors.Errorf("failed to get randomness for winning post: %w", err)
 			return false, err
@@ -265,7 +295,9 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 			}
 		}
 
+		start := time.Now()
 		wpostProof, err = t.prover.GenerateWinningPoSt(ctx, ppt, abi.ActorID(details.SpID), sectorChallenges, prand)
+		em.compute = time.Since(start)
 		if err != nil {
 			err = xerrors.Errorf("failed to compute winning post proof: %w", err)
 			return false, err
@@ -372,6 +404,9 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 		if err != nil {
 			return false, xerrors.Errorf("failed to create block: %w", err)
 		}
+
+		em.mined = true
+		em.late = time.Now().After(time.Unix(int64(uts), 0).Add(time.Duration(build.PropagationDelaySecs) * time.Second))
 	}
 
 	// persist in db
@@ -381,36 +416,29 @@ func (t *WinPostTask) Do(taskID harmonytask.TaskID, stillOwned func() bool) (don
 			return false, xerrors.Errorf("failed to marshal block header: %w", err)
 		}
 
+		// the full block is kept so that the leader can submit it if it wasn't computed there
+		var bmsg bytes.Buffer
+		if err := blockMsg.MarshalCBOR(&bmsg); err != nil {
+			return false, xerrors.Errorf("failed to marshal block msg: %w", err)
+		}
+
 		_, err = t.db.Exec(ctx, `UPDATE mining_tasks
-            SET won = true, mined_cid = $2, mined_header = $3, mined_at = $4
-            WHERE task_id = $1`, taskID, blockMsg.Header.Cid(), string(bhjson), time.Now().UTC())
+            SET won = true, mined_cid = $2, mined_header = $3, mined_at = $4, block_msg = $5
+            WHERE task_id = $1`, taskID, blockMsg.Header.Cid(), string(bhjson), time.Now().UTC(), bmsg.Bytes())
 		if err != nil {
 			return false, xerrors.Errorf("failed to update mining task: %w", err)
 		}
 	}
 
-	// wait until block timestamp
-	{
-		time.Sleep(time.Until(time.Unix(int64(blockMsg.Header.Timestamp), 0)))
+	if !t.leader.isLeader(details.SpID) {
+		log.Infow("mined a block, handing it to the leader for submission", "height", blockMsg.Header.Height, "miner", maddr, "cid", blockMsg.Header.Cid())
+		t.announceBlock(ctx, details.SpID)
+		return true, nil
 	}
 
 	// submit block!!
-	{
-		if err := t.api.SyncSubmitBlock(ctx, blockMsg); err != nil {
-			return false, xerrors.Errorf("failed to submit block: %w", err)
-		}
-	}
-
-	log.Infow("mined a block", "tipset", types.LogCids(blockMsg.Header.Parents), "height", blockMsg.Header.Height, "miner", maddr, "cid", blockMsg.Header.Cid())
-
-	// persist that we've submitted the block
-	{
-		_, err = t.db.Exec(ctx, `UPDATE mining_tasks
-		SET submitted_at = $2
-		WHERE task_id = $1`, taskID, time.Now().UTC())
-		if err != nil {
-			return false, xerrors.Errorf("failed to update mining task: %w", err)
-		}
+	if err := t.submitBlock(ctx, int64(taskID), blockMsg); err != nil {
+		return false, err
 	}
 
 	return true, nil
@@ -491,12 +519,18 @@ func (t *WinPostTask) mineBasic(ctx context.Context) {
 	var workBase MiningBase
 
 	taskFn := t.mineTF.Val(ctx)
+	if ctx.Err() != nil {
+		return
+	}
 
 	// initialize workbase
 	{
-		head := retry1(func() (*types.TipSet, error) {
+		head, err := retry1(ctx, func() (*types.TipSet, error) {
 			return t.api.ChainHead(ctx)
 		})
+		if err != nil {
+			return
+		}
 
 		workBase = MiningBase{
 			TipSet:      head,
@@ -522,28 +556,41 @@ func (t *WinPostTask) mineBasic(ctx context.Context) {
 		// limit the rate at which we mine blocks to at least EquivocationDelaySecs
 		// this is to prevent races on devnets in catch up mode. Acts as a minimum
 		// delay for the sleep below.
-		time.Sleep(time.Duration(build.EquivocationDelaySecs)*time.Second + time.Second)
+		if !sleepCtx(ctx, time.Duration(build.EquivocationDelaySecs)*time.Second+time.Second) {
+			return
+		}
 
 		// wait for *NEXT* propagation delay
-		time.Sleep(time.Until(workBase.afterPropDelay()))
+		if !sleepCtx(ctx, time.Until(workBase.afterPropDelay())) {
+			return
+		}
 
 		// check current best candidate
-		maybeBase := retry1(func() (*types.TipSet, error) {
+		maybeBase, err := retry1(ctx, func() (*types.TipSet, error) {
 			return t.api.ChainHead(ctx)
 		})
+		if err != nil {
+			return
+		}
 
 		if workBase.TipSet.Equals(maybeBase) {
 			// workbase didn't change in the new round so we have a null round here
 			workBase.AddRounds++
 			log.Debugw("workbase update", "tipset", workBase.TipSet.Cids(), "nulls", workBase.AddRounds, "lastUpdate", time.Since(workBase.ComputeTime), "type", "same-tipset")
 		} else {
-			btsw := retry1(func() (types.BigInt, error) {
+			btsw, err := retry1(ctx, func() (types.BigInt, error) {
 				return t.api.ChainTipSetWeight(ctx, maybeBase.Key())
 			})
+			if err != nil {
+				return
+			}
 
-			ltsw := retry1(func() (types.BigInt, error) {
+			ltsw, err := retry1(ctx, func() (types.BigInt, error) {
 				return t.api.ChainTipSetWeight(ctx, workBase.TipSet.Key())
 			})
+			if err != nil {
+				return
+			}
 
 			if types.BigCmp(btsw, ltsw) <= 0 {
 				// new tipset for some reason has less weight than the old one, assume null round here
@@ -656,15 +703,28 @@ func randTimeOffset(width time.Duration) time.Duration {
 	return val - (width / 2)
 }
 
-func retry1[R any](f func() (R, error)) R {
+// retry1 calls f until it succeeds, or ctx is done.
+func retry1[R any](ctx context.Context, f func() (R, error)) (R, error) {
 	for {
 		r, err := f()
 		if err == nil {
-			return r
+			return r, nil
 		}
 
 		log.Errorw("error in mining loop, retrying", "error", err)
-		time.Sleep(time.Second)
+		if !sleepCtx(ctx, time.Second) {
+			return r, ctx.Err()
+		}
+	}
+}
+
+// sleepCtx waits for d, it returns false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+var pre = "proving_"
+
+var ProofKindKey, _ = tag.NewKey("proof")
+
+// ProvingMeasures groups the metrics of the provers shared by PoSt tasks.
+var ProvingMeasures = struct {
+	GPUFailures     *stats.Int64Measure
+	CPUFallbacks    *stats.Int64Measure
+	RemoteProofs    *stats.Int64Measure
+	RemoteFallbacks *stats.Int64Measure
+}{
+	GPUFailures:     stats.Int64(pre+"gpu_failures", "Number of proofs which failed because the GPU couldn't be used.", stats.UnitDimensionless),
+	CPUFallbacks:    stats.Int64(pre+"cpu_fallbacks", "Number of times proving switched from the GPU to the CPU.", stats.UnitDimensionless),
+	RemoteProofs:    stats.Int64(pre+"remote_proofs", "Number of proofs computed by a remote prover.", stats.UnitDimensionless),
+	RemoteFallbacks: stats.Int64(pre+"remote_fallbacks", "Number of proofs computed locally because the remote prover failed.", stats.UnitDimensionless),
+}
+
+func init() {
+	metrics.RegisterViews(
+		&view.View{
+			Measure:     ProvingMeasures.GPUFailures,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{ProofKindKey},
+		},
+		&view.View{
+			Measure:     ProvingMeasures.CPUFallbacks,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{ProofKindKey},
+		},
+		&view.View{
+			Measure:     ProvingMeasures.RemoteProofs,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{ProofKindKey},
+		},
+		&view.View{
+			Measure:     ProvingMeasures.RemoteFallbacks,
+			Aggregation: view.Sum(),
+			TagKeys:     []tag.Key{ProofKindKey},
+		},
+	)
+}
@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// discoveryParallelism bounds the number of concurrent StateMinerInfo calls
+// made while scanning the miner list.
+const discoveryParallelism = 32
+
+type MinerDiscoveryAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateLookupID(context.Context, address.Address, types.TipSetKey) (address.Address, error)
+	StateListMiners(context.Context, types.TipSetKey) ([]address.Address, error)
+	StateMinerInfo(context.Context, address.Address, types.TipSetKey) (api.MinerInfo, error)
+}
+
+// DiscoverMiners scans all miner actors for those whose owner or worker is
+// the given address. The scan reads the info of every miner on the network,
+// so it's only run on request, never at startup; the result goes into
+// Addresses.MinerAddresses. Miners whose info can't be read are skipped and
+// counted in the returned number.
+func DiscoverMiners(ctx context.Context, full MinerDiscoveryAPI, owner address.Address) (found []address.Address, skipped int, err error) {
+	head, err := full.ChainHead(ctx)
+	if err != nil {
+		return nil, 0, xerrors.Errorf("getting chain head: %w", err)
+	}
+
+	ownerID, err := full.StateLookupID(ctx, owner, head.Key())
+	if err != nil {
+		return nil, 0, xerrors.Errorf("looking up owner ID: %w", err)
+	}
+
+	miners, err := full.StateListMiners(ctx, head.Key())
+	if err != nil {
+		return nil, 0, xerrors.Errorf("listing miners: %w", err)
+	}
+
+	log.Infow("looking up miner address, this can take a while", "owner", owner, "miners", len(miners))
+
+	var lk sync.Mutex
+
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(discoveryParallelism)
+	for _, m := range miners {
+		m := m
+		eg.Go(func() error {
+			mi, err := full.StateMinerInfo(ectx, m, head.Key())
+
+			lk.Lock()
+			defer lk.Unlock()
+
+			if err != nil {
+				// e.g. an actor in an odd state, it can't be ours anyway
+				// when ours is readable
+				log.Debugw("skipping miner", "miner", m, "error", err)
+				skipped++
+				return nil
+			}
+			if mi.Owner == ownerID || mi.Worker == ownerID {
+				found = append(found, m)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].String() < found[j].String() })
+	return found, skipped, nil
+}
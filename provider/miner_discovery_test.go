@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type discoveryAPI struct {
+	infos  map[address.Address]api.MinerInfo
+	broken map[address.Address]bool
+}
+
+func (a *discoveryAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return &types.TipSet{}, nil
+}
+
+func (a *discoveryAPI) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	// the owner is given by its robust address
+	if addr.Protocol() != address.ID {
+		return address.NewIDAddress(100)
+	}
+	return addr, nil
+}
+
+func (a *discoveryAPI) StateListMiners(ctx context.Context, tsk types.TipSetKey) ([]address.Address, error) {
+	var out []address.Address
+	for m := range a.infos {
+		out = append(out, m)
+	}
+	for m := range a.broken {
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func (a *discoveryAPI) StateMinerInfo(ctx context.Context, m address.Address, tsk types.TipSetKey) (api.MinerInfo, error) {
+	if a.broken[m] {
+		return api.MinerInfo{}, xerrors.Errorf("actor state not found")
+	}
+	return a.infos[m], nil
+}
+
+func TestDiscoverMiners(t *testing.T) {
+	id := func(i uint64) address.Address {
+		a, err := address.NewIDAddress(i)
+		require.NoError(t, err)
+		return a
+	}
+
+	owner, err := address.NewSecp256k1Address([]byte("owner key"))
+	require.NoError(t, err)
+
+	full := &discoveryAPI{
+		infos: map[address.Address]api.MinerInfo{
+			id(1000): {Owner: id(100), Worker: id(101)},
+			id(1001): {Owner: id(200), Worker: id(100)},
+			id(1002): {Owner: id(200), Worker: id(201)},
+		},
+		broken: map[address.Address]bool{id(1003): true},
+	}
+
+	// owned and worked for miners are found, a broken actor doesn't fail the scan
+	found, skipped, err := DiscoverMiners(context.Background(), full, owner)
+	require.NoError(t, err)
+	require.Equal(t, []address.Address{id(1000), id(1001)}, found)
+	require.Equal(t, 1, skipped)
+
+	// an address without miners
+	found, _, err = DiscoverMiners(context.Background(), full, id(300))
+	require.NoError(t, err)
+	require.Empty(t, found)
+}
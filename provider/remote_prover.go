@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/storage/sealer"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SnarkProver computes PoSt proofs from the vanilla proofs of the challenged
+// sectors. It is the API of remote proving services, served over JSON-RPC.
+type SnarkProver interface {
+	GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error)
+	GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error)
+}
+
+// RemoteSnarkProver is the JSON-RPC client of a remote SnarkProver.
+type RemoteSnarkProver struct {
+	Internal struct {
+		GenerateWindowPoStWithVanilla  func(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error)
+		GenerateWinningPoStWithVanilla func(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error)
+	}
+}
+
+func (r *RemoteSnarkProver) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	return r.Internal.GenerateWindowPoStWithVanilla(ctx, proofType, minerID, randomness, proofs, partitionIdx)
+}
+
+func (r *RemoteSnarkProver) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	return r.Internal.GenerateWinningPoStWithVanilla(ctx, proofType, minerID, randomness, proofs)
+}
+
+var _ SnarkProver = &RemoteSnarkProver{}
+
+// NewRemoteSnarkProver creates the client of the proving service at addr.
+// Requests are sent over HTTP, so that a service which can't be reached
+// fails the request instead of the startup of the node; websocket
+// addresses are converted.
+func NewRemoteSnarkProver(ctx context.Context, addr string, header http.Header) (*RemoteSnarkProver, jsonrpc.ClientCloser, error) {
+	if strings.HasPrefix(addr, "ws") {
+		addr = "http" + strings.TrimPrefix(addr, "ws")
+	}
+
+	var p RemoteSnarkProver
+	closer, err := jsonrpc.NewMergeClient(ctx, addr, "Filecoin", []interface{}{&p.Internal}, header)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("creating remote prover client: %w", err)
+	}
+	return &p, closer, nil
+}
+
+// RemoteSnarkExec returns the executor of a LocalWorker which sends the
+// vanilla proofs of WindowPoSt and WinningPoSt to the given remote provers
+// for the snark computation, instead of computing it on this node. A nil
+// prover keeps its proof type local. Vanilla proofs are still read by the
+// worker, and a proof the remote prover fails to return within its timeout
+// is computed locally.
+func RemoteSnarkExec(window, winning SnarkProver, windowTimeout, winningTimeout time.Duration) sealer.ExecutorFunc {
+	local := sealer.FFIExec()
+	return func(l *sealer.LocalWorker) (storiface.Storage, error) {
+		s, err := local(l)
+		if err != nil {
+			return nil, err
+		}
+		return &remoteSnarkStorage{
+			Storage:        s,
+			window:         window,
+			winning:        winning,
+			windowTimeout:  windowTimeout,
+			winningTimeout: winningTimeout,
+		}, nil
+	}
+}
+
+type remoteSnarkStorage struct {
+	storiface.Storage
+
+	window  SnarkProver
+	winning SnarkProver
+
+	windowTimeout  time.Duration
+	winningTimeout time.Duration
+}
+
+func (s *remoteSnarkStorage) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	if s.window != nil {
+		rctx, cancel := context.WithTimeout(ctx, s.windowTimeout)
+		p, err := s.window.GenerateWindowPoStWithVanilla(rctx, proofType, minerID, randomness, proofs, partitionIdx)
+		cancel()
+		if err == nil {
+			recordRemoteProof(ctx, "window", true)
+			return p, nil
+		}
+		recordRemoteProof(ctx, "window", false)
+		log.Warnw("remote WindowPoSt proving failed, proving locally", "miner", minerID, "partition", partitionIdx, "error", err)
+	}
+	return s.Storage.GenerateWindowPoStWithVanilla(ctx, proofType, minerID, randomness, proofs, partitionIdx)
+}
+
+func (s *remoteSnarkStorage) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	if s.winning != nil {
+		rctx, cancel := context.WithTimeout(ctx, s.winningTimeout)
+		p, err := s.winning.GenerateWinningPoStWithVanilla(rctx, proofType, minerID, randomness, proofs)
+		cancel()
+		if err == nil {
+			recordRemoteProof(ctx, "winning", true)
+			return p, nil
+		}
+		recordRemoteProof(ctx, "winning", false)
+		log.Warnw("remote WinningPoSt proving failed, proving locally", "miner", minerID, "error", err)
+	}
+	return s.Storage.GenerateWinningPoStWithVanilla(ctx, proofType, minerID, randomness, proofs)
+}
+
+func recordRemoteProof(ctx context.Context, kind string, ok bool) {
+	ctx, _ = tag.New(ctx, tag.Upsert(ProofKindKey, kind))
+	if ok {
+		stats.Record(ctx, ProvingMeasures.RemoteProofs.M(1))
+	} else {
+		stats.Record(ctx, ProvingMeasures.RemoteFallbacks.M(1))
+	}
+}
@@ -0,0 +1,143 @@
+package provider
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-jsonrpc"
+	"github.com/filecoin-project/go-state-types/abi"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// fakeSnarkProver proves by returning the partition and the number of
+// vanilla proofs it was sent. A hanging prover only returns once the
+// request is canceled.
+type fakeSnarkProver struct {
+	calls int
+	fail  bool
+	hang  bool
+}
+
+func (f *fakeSnarkProver) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	f.calls++
+	if f.hang {
+		<-ctx.Done()
+		return prooftypes.PoStProof{}, ctx.Err()
+	}
+	if f.fail {
+		return prooftypes.PoStProof{}, xerrors.New("proving service busy")
+	}
+	return prooftypes.PoStProof{PoStProof: proofType, ProofBytes: []byte{byte(partitionIdx), byte(len(proofs))}}, nil
+}
+
+func (f *fakeSnarkProver) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	f.calls++
+	if f.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.fail {
+		return nil, xerrors.New("proving service busy")
+	}
+	return []prooftypes.PoStProof{{PoStProof: proofType, ProofBytes: []byte{byte(len(proofs))}}}, nil
+}
+
+// fakeLocalStorage stands for ffiwrapper, only the PoSt methods are used.
+type fakeLocalStorage struct {
+	storiface.Storage
+	fakeSnarkProver
+}
+
+func (f *fakeLocalStorage) GenerateWindowPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte, partitionIdx int) (prooftypes.PoStProof, error) {
+	return f.fakeSnarkProver.GenerateWindowPoStWithVanilla(ctx, proofType, minerID, randomness, proofs, partitionIdx)
+}
+
+func (f *fakeLocalStorage) GenerateWinningPoStWithVanilla(ctx context.Context, proofType abi.RegisteredPoStProof, minerID abi.ActorID, randomness abi.PoStRandomness, proofs [][]byte) ([]prooftypes.PoStProof, error) {
+	return f.fakeSnarkProver.GenerateWinningPoStWithVanilla(ctx, proofType, minerID, randomness, proofs)
+}
+
+func TestRemoteSnarkProver(t *testing.T) {
+	ctx := context.Background()
+
+	remote := &fakeSnarkProver{}
+	rpcServer := jsonrpc.NewServer()
+	rpcServer.Register("Filecoin", remote)
+	srv := httptest.NewServer(rpcServer)
+	defer srv.Close()
+
+	// websocket addresses, as given by api info, are sent over HTTP
+	client, closer, err := NewRemoteSnarkProver(ctx, "ws"+srv.URL[len("http"):]+"/rpc/v0", nil)
+	require.NoError(t, err)
+	defer closer()
+
+	local := &fakeLocalStorage{}
+	s := &remoteSnarkStorage{Storage: local, window: client, windowTimeout: time.Minute}
+	vanillas := [][]byte{{1}, {2}, {3}}
+	ppt := abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1
+
+	p, err := s.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, abi.PoStRandomness{7}, vanillas, 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2, 3}, p.ProofBytes)
+	require.Equal(t, 1, remote.calls)
+	require.Equal(t, 0, local.calls)
+
+	// WinningPoSt has no remote prover, it is computed locally
+	wp, err := s.GenerateWinningPoStWithVanilla(ctx, abi.RegisteredPoStProof_StackedDrgWinning2KiBV1, 1000, abi.PoStRandomness{7}, vanillas[:1])
+	require.NoError(t, err)
+	require.Len(t, wp, 1)
+	require.Equal(t, 1, remote.calls)
+	require.Equal(t, 1, local.calls)
+
+	// a remote error falls back on local proving
+	remote.fail = true
+	_, err = s.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, abi.PoStRandomness{7}, vanillas, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, remote.calls)
+	require.Equal(t, 2, local.calls)
+
+	// so does an unreachable one
+	srv.Close()
+	p, err = s.GenerateWindowPoStWithVanilla(ctx, ppt, 1000, abi.PoStRandomness{7}, vanillas, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 3}, p.ProofBytes)
+	require.Equal(t, 2, remote.calls)
+	require.Equal(t, 3, local.calls)
+}
+
+func TestRemoteSnarkProverTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	remote := &fakeSnarkProver{hang: true}
+	local := &fakeLocalStorage{}
+	s := &remoteSnarkStorage{
+		Storage:        local,
+		window:         remote,
+		winning:        remote,
+		windowTimeout:  time.Hour,
+		winningTimeout: 10 * time.Millisecond,
+	}
+	vanillas := [][]byte{{1}, {2}}
+
+	// a remote prover which doesn't answer in time falls back on local proving
+	start := time.Now()
+	wp, err := s.GenerateWinningPoStWithVanilla(ctx, abi.RegisteredPoStProof_StackedDrgWinning2KiBV1, 1000, abi.PoStRandomness{7}, vanillas[:1])
+	require.NoError(t, err)
+	require.Len(t, wp, 1)
+	require.Less(t, time.Since(start), time.Minute)
+	require.Equal(t, 1, remote.calls)
+	require.Equal(t, 1, local.calls)
+
+	s.windowTimeout = 10 * time.Millisecond
+	p, err := s.GenerateWindowPoStWithVanilla(ctx, abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1, 1000, abi.PoStRandomness{7}, vanillas, 3)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3, 2}, p.ProofBytes)
+	require.Equal(t, 2, remote.calls)
+	require.Equal(t, 2, local.calls)
+}
@@ -0,0 +1,325 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	ffi "github.com/filecoin-project/filecoin-ffi"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/dline"
+	prooftypes "github.com/filecoin-project/go-state-types/proof"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/build"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// SafeModeAPI is the chain state read by the safe mode checks.
+type SafeModeAPI interface {
+	ChainHead(context.Context) (*types.TipSet, error)
+	StateMinerProvingDeadline(context.Context, address.Address, types.TipSetKey) (*dline.Info, error)
+	StateMinerDeadlines(context.Context, address.Address, types.TipSetKey) ([]api.Deadline, error)
+	StateMinerPartitions(context.Context, address.Address, uint64, types.TipSetKey) ([]api.Partition, error)
+	StateSectorGetInfo(context.Context, address.Address, abi.SectorNumber, types.TipSetKey) (*miner.SectorOnChainInfo, error)
+}
+
+// PoStProver generates WindowPoSt and WinningPoSt proofs, it is implemented
+// by sealer.LocalWorker.
+type PoStProver interface {
+	GenerateWindowPoStAdv(ctx context.Context, ppt abi.RegisteredPoStProof, mid abi.ActorID, sectors []storiface.PostSectorChallenge, partitionIdx int, randomness abi.PoStRandomness, allowSkip bool) (storiface.WindowPoStResult, error)
+	GenerateWinningPoSt(ctx context.Context, ppt abi.RegisteredPoStProof, mid abi.ActorID, sectors []storiface.PostSectorChallenge, randomness abi.PoStRandomness) ([]prooftypes.PoStProof, error)
+}
+
+// SendGate is held while the safe mode checks haven't passed, it is
+// implemented by lpmessage.Sender.
+type SendGate interface {
+	Hold()
+	Release()
+}
+
+// SafeModeCheck is a health check which must pass before the node sends
+// messages. Check returns what isn't healthy yet.
+type SafeModeCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// SafeModeChecks builds the named checks. "proof" computes a WinningPoSt over
+// a live sector of the first miner which has one, and verifies it. "deadlines"
+// checks that the chain head is current, and that the partitions of the open
+// deadline which are recorded as proven by landed messages are proven on chain.
+func SafeModeChecks(names []string, api SafeModeAPI, db harmonydb.Interface, prover PoStProver, verif storiface.Verifier, miners []dtypes.MinerAddress) ([]SafeModeCheck, error) {
+	checks := make([]SafeModeCheck, 0, len(names))
+	for _, name := range names {
+		var check func(ctx context.Context) error
+		switch name {
+		case "proof":
+			check = proofCheck(api, prover, verif, miners)
+		case "deadlines":
+			check = deadlinesCheck(api, db, miners)
+		default:
+			return nil, xerrors.Errorf("unknown safe mode check %q, expected proof or deadlines", name)
+		}
+		checks = append(checks, SafeModeCheck{Name: name, Check: check})
+	}
+	return checks, nil
+}
+
+// SafeMode holds message sending after startup until all its checks pass.
+// Failing checks are retried, each attempt logs the checks it waits on, and
+// an alert is raised while sending is held.
+type SafeMode struct {
+	gate   SendGate
+	checks []SafeModeCheck
+	retry  time.Duration
+
+	al    *alerting.Alerting
+	alert alerting.AlertType
+}
+
+// NewSafeMode holds the gate right away, so that nothing is sent before Run
+// has checked the node.
+func NewSafeMode(gate SendGate, al *alerting.Alerting, checks []SafeModeCheck, retry time.Duration) *SafeMode {
+	gate.Hold()
+
+	return &SafeMode{
+		gate:   gate,
+		checks: checks,
+		retry:  retry,
+
+		al:    al,
+		alert: al.AddAlertType("provider", "safe-mode"),
+	}
+}
+
+// Run checks the node until all checks pass, then releases the gate. The
+// gate stays held when ctx is cancelled first.
+func (s *SafeMode) Run(ctx context.Context) {
+	pending := s.checks
+	raised := false
+
+	for {
+		var failing []SafeModeCheck
+		var errs []string
+		for _, c := range pending {
+			if err := c.Check(ctx); err != nil {
+				log.Warnw("safe mode: message sending held, waiting on check", "check", c.Name, "error", err)
+				failing = append(failing, c)
+				errs = append(errs, c.Name+": "+err.Error())
+				continue
+			}
+			log.Infow("safe mode: check passed", "check", c.Name)
+		}
+
+		if len(failing) == 0 {
+			break
+		}
+		pending = failing
+
+		if !raised {
+			s.al.Raise(s.alert, map[string]interface{}{
+				"message": "message sending held until the startup health checks pass",
+				"waiting": strings.Join(errs, "; "),
+			})
+			raised = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retry):
+		}
+	}
+
+	s.gate.Release()
+	log.Infow("safe mode: all checks passed, message sending enabled")
+	if raised {
+		s.al.Resolve(s.alert, map[string]string{
+			"message": "startup health checks passed",
+		})
+	}
+}
+
+// staleHeadAge is how far behind the wall clock the chain head may be for
+// the chain state to be trusted.
+var staleHeadAge = 5 * time.Duration(build.BlockDelaySecs) * time.Second
+
+type landedProof struct {
+	Partition uint64 `db:"partition"`
+}
+
+func deadlinesCheck(api SafeModeAPI, db harmonydb.Interface, miners []dtypes.MinerAddress) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting chain head: %w", err)
+		}
+		if age := time.Since(time.Unix(int64(head.MinTimestamp()), 0)); age > staleHeadAge {
+			return xerrors.Errorf("chain head at epoch %d is %s old, the full node isn't in sync", head.Height(), age.Truncate(time.Second))
+		}
+
+		for _, m := range miners {
+			maddr := address.Address(m)
+			spID, err := address.IDFromAddress(maddr)
+			if err != nil {
+				return xerrors.Errorf("getting miner ID: %w", err)
+			}
+
+			di, err := api.StateMinerProvingDeadline(ctx, maddr, head.Key())
+			if err != nil {
+				return xerrors.Errorf("getting proving deadline of %s: %w", maddr, err)
+			}
+			deadlines, err := api.StateMinerDeadlines(ctx, maddr, head.Key())
+			if err != nil {
+				return xerrors.Errorf("getting deadlines of %s: %w", maddr, err)
+			}
+			if di.Index >= uint64(len(deadlines)) {
+				return xerrors.Errorf("miner %s has %d deadlines, the current one is %d", maddr, len(deadlines), di.Index)
+			}
+
+			var landed []landedProof
+			err = db.Select(ctx, &landed, `SELECT p.partition
+				FROM wdpost_proofs p JOIN message_waits w ON w.signed_message_cid = p.message_cid
+				WHERE p.sp_id = $1 AND p.proving_period_start = $2 AND p.deadline = $3
+					AND p.test_task_id IS NULL AND w.executed_rcpt_exitcode = 0`, spID, di.PeriodStart, di.Index)
+			if err != nil {
+				return xerrors.Errorf("reading proofs of the current deadline: %w", err)
+			}
+
+			for _, l := range landed {
+				proven, err := deadlines[di.Index].PostSubmissions.IsSet(l.Partition)
+				if err != nil {
+					return xerrors.Errorf("reading post submissions: %w", err)
+				}
+				if !proven {
+					return xerrors.Errorf("miner %s partition %d of deadline %d is recorded as proven, but isn't proven on chain at epoch %d",
+						maddr, l.Partition, di.Index, head.Height())
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+func proofCheck(api SafeModeAPI, prover PoStProver, verif storiface.Verifier, miners []dtypes.MinerAddress) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		head, err := api.ChainHead(ctx)
+		if err != nil {
+			return xerrors.Errorf("getting chain head: %w", err)
+		}
+
+		for _, m := range miners {
+			maddr := address.Address(m)
+			sn, found, err := firstActiveSector(ctx, api, maddr, head.Key())
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+
+			info, err := api.StateSectorGetInfo(ctx, maddr, sn, head.Key())
+			if err != nil {
+				return xerrors.Errorf("getting info of sector %d: %w", sn, err)
+			}
+			if info == nil {
+				return xerrors.Errorf("sector %d of %s not found", sn, maddr)
+			}
+
+			mid, err := address.IDFromAddress(maddr)
+			if err != nil {
+				return xerrors.Errorf("getting miner ID: %w", err)
+			}
+			return proveAndVerify(ctx, prover, verif, abi.ActorID(mid), info)
+		}
+
+		log.Warnw("safe mode: no miner has live sectors, skipping the proof check")
+		return nil
+	}
+}
+
+// firstActiveSector finds an active sector of the miner to prove.
+func firstActiveSector(ctx context.Context, api SafeModeAPI, maddr address.Address, tsk types.TipSetKey) (abi.SectorNumber, bool, error) {
+	for dl := uint64(0); dl < miner.WPoStPeriodDeadlines; dl++ {
+		parts, err := api.StateMinerPartitions(ctx, maddr, dl, tsk)
+		if err != nil {
+			return 0, false, xerrors.Errorf("getting partitions of deadline %d: %w", dl, err)
+		}
+		for _, p := range parts {
+			empty, err := p.ActiveSectors.IsEmpty()
+			if err != nil {
+				return 0, false, xerrors.Errorf("reading active sectors: %w", err)
+			}
+			if empty {
+				continue
+			}
+			sn, err := p.ActiveSectors.First()
+			if err != nil {
+				return 0, false, xerrors.Errorf("reading active sectors: %w", err)
+			}
+			return abi.SectorNumber(sn), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+func proveAndVerify(ctx context.Context, prover PoStProver, verif storiface.Verifier, mid abi.ActorID, info *miner.SectorOnChainInfo) error {
+	ppt, err := info.SealProof.RegisteredWinningPoStProof()
+	if err != nil {
+		return xerrors.Errorf("mapping sector seal proof type to post proof type: %w", err)
+	}
+
+	prand := make(abi.PoStRandomness, 32)
+	if _, err := rand.Read(prand); err != nil {
+		return xerrors.Errorf("generating randomness: %w", err)
+	}
+	prand[31] &= 0x3f // make into fr
+
+	challenges, err := ffi.GeneratePoStFallbackSectorChallenges(ppt, mid, prand, []abi.SectorNumber{info.SectorNumber})
+	if err != nil {
+		return xerrors.Errorf("generating challenges: %w", err)
+	}
+
+	start := time.Now()
+	proofs, err := prover.GenerateWinningPoSt(ctx, ppt, mid, []storiface.PostSectorChallenge{{
+		SealProof:    info.SealProof,
+		SectorNumber: info.SectorNumber,
+		SealedCID:    info.SealedCID,
+		Challenge:    challenges.Challenges[info.SectorNumber],
+		Update:       info.SectorKeyCID != nil,
+	}}, prand)
+	if err != nil {
+		return xerrors.Errorf("computing proof over sector %d: %w", info.SectorNumber, err)
+	}
+
+	ok, err := verif.VerifyWinningPoSt(ctx, prooftypes.WinningPoStVerifyInfo{
+		Randomness: prand,
+		Proofs:     proofs,
+		ChallengedSectors: []prooftypes.SectorInfo{{
+			SealProof:    info.SealProof,
+			SectorNumber: info.SectorNumber,
+			SealedCID:    info.SealedCID,
+		}},
+		Prover: mid,
+	})
+	if err != nil {
+		return xerrors.Errorf("verifying proof over sector %d: %w", info.SectorNumber, err)
+	}
+	if !ok {
+		return xerrors.Errorf("proof over sector %d of miner %d is invalid", info.SectorNumber, mid)
+	}
+
+	log.Infow("safe mode: computed and verified a proof", "miner", mid, "sector", info.SectorNumber, "took", time.Since(start))
+	return nil
+}
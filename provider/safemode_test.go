@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-bitfield"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/go-state-types/dline"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/miner"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/journal"
+	"github.com/filecoin-project/lotus/journal/alerting"
+	"github.com/filecoin-project/lotus/lib/harmony/harmonydb"
+	"github.com/filecoin-project/lotus/node/modules/dtypes"
+)
+
+type fakeGate struct {
+	held bool
+}
+
+func (g *fakeGate) Hold()    { g.held = true }
+func (g *fakeGate) Release() { g.held = false }
+
+func TestSafeModeHoldsUntilChecksPass(t *testing.T) {
+	al := alerting.NewAlertingSystem(journal.NilJournal())
+	gate := &fakeGate{}
+
+	var proofRuns, deadlineRuns int
+	checks := []SafeModeCheck{
+		{Name: "proof", Check: func(ctx context.Context) error {
+			proofRuns++
+			return nil
+		}},
+		{Name: "deadlines", Check: func(ctx context.Context) error {
+			deadlineRuns++
+			if deadlineRuns < 3 {
+				require.True(t, gate.held)
+				return xerrors.New("not in sync")
+			}
+			return nil
+		}},
+	}
+
+	sm := NewSafeMode(gate, al, checks, time.Millisecond)
+	require.True(t, gate.held)
+
+	sm.Run(context.Background())
+	require.False(t, gate.held)
+	// passed checks aren't run again
+	require.Equal(t, 1, proofRuns)
+	require.Equal(t, 3, deadlineRuns)
+	require.False(t, al.IsRaised(sm.alert))
+
+	t.Run("cancelled", func(t *testing.T) {
+		gate := &fakeGate{}
+		failing := []SafeModeCheck{{Name: "deadlines", Check: func(ctx context.Context) error {
+			return xerrors.New("not in sync")
+		}}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		sm := NewSafeMode(gate, al, failing, time.Hour)
+		sm.Run(ctx)
+		require.True(t, gate.held)
+		require.True(t, al.IsRaised(sm.alert))
+	})
+}
+
+type fakeSafeModeAPI struct {
+	SafeModeAPI
+
+	head      *types.TipSet
+	submitted bitfield.BitField
+}
+
+func (f *fakeSafeModeAPI) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return f.head, nil
+}
+
+func (f *fakeSafeModeAPI) StateMinerProvingDeadline(ctx context.Context, maddr address.Address, tsk types.TipSetKey) (*dline.Info, error) {
+	return &dline.Info{PeriodStart: 1000, Index: 2}, nil
+}
+
+func (f *fakeSafeModeAPI) StateMinerDeadlines(ctx context.Context, maddr address.Address, tsk types.TipSetKey) ([]api.Deadline, error) {
+	dls := make([]api.Deadline, miner.WPoStPeriodDeadlines)
+	for i := range dls {
+		dls[i].PostSubmissions = bitfield.New()
+	}
+	dls[2].PostSubmissions = f.submitted
+	return dls, nil
+}
+
+func TestSafeModeDeadlinesCheck(t *testing.T) {
+	ctx := context.Background()
+	db := harmonydb.NewMock()
+
+	maddr, err := address.NewIDAddress(1000)
+	require.NoError(t, err)
+	dummyCid, err := cid.Parse("bafkqaaa")
+	require.NoError(t, err)
+
+	head := func(at time.Time) *types.TipSet {
+		ts, err := types.NewTipSet([]*types.BlockHeader{{
+			Miner:                 maddr,
+			Height:                1100,
+			Timestamp:             uint64(at.Unix()),
+			Ticket:                &types.Ticket{VRFProof: []byte{1}},
+			ParentStateRoot:       dummyCid,
+			Messages:              dummyCid,
+			ParentMessageReceipts: dummyCid,
+			BlockSig:              &crypto.Signature{Type: crypto.SigTypeBLS},
+			BLSAggregate:          &crypto.Signature{Type: crypto.SigTypeBLS},
+			ParentBaseFee:         abi.NewTokenAmount(0),
+		}})
+		require.NoError(t, err)
+		return ts
+	}
+
+	fapi := &fakeSafeModeAPI{head: head(time.Now()), submitted: bitfield.NewFromSet([]uint64{0})}
+	check := deadlinesCheck(fapi, db, []dtypes.MinerAddress{dtypes.MinerAddress(maddr)})
+
+	// partition 1 landed according to message_waits, but the chain doesn't have it
+	db.ExpectSelect(`FROM wdpost_proofs`).WithArgs(uint64(1000), abi.ChainEpoch(1000), uint64(2)).
+		WillReturnSelect([]landedProof{{Partition: 0}, {Partition: 1}})
+	require.ErrorContains(t, check(ctx), "partition 1 of deadline 2")
+
+	db.ExpectSelect(`FROM wdpost_proofs`).WillReturnSelect([]landedProof{{Partition: 0}})
+	require.NoError(t, check(ctx))
+	require.NoError(t, db.ExpectationsWereMet())
+
+	// a stale head isn't trusted
+	fapi.head = head(time.Now().Add(-time.Hour))
+	require.ErrorContains(t, check(ctx), "isn't in sync")
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/paths"
+)
+
+// StartupRetryBackoff is the wait before the first retry of a startup step,
+// doubled on every retry up to StartupRetryMaxBackoff.
+var (
+	StartupRetryBackoff    = time.Second
+	StartupRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryStartup runs a startup step depending on the database or other nodes,
+// retrying it with backoff so that a short outage, e.g. during a rolling
+// restart, doesn't fail startup. The step is tried at most attempts times, and
+// isn't retried once timeout has passed since the first try. The step must be
+// safe to run again after a partial failure.
+func RetryStartup[T any](ctx context.Context, what string, attempts int, timeout time.Duration, f func() (T, error)) (T, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	start := time.Now()
+	wait := StartupRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		res, err := f()
+		if err == nil {
+			if attempt > 1 {
+				log.Infow("startup step succeeded after retrying", "step", what, "attempts", attempt)
+			}
+			return res, nil
+		}
+
+		if attempts == 1 {
+			return res, xerrors.Errorf("%s: %w", what, err)
+		}
+		if attempt >= attempts {
+			return res, xerrors.Errorf("%s: giving up after %d attempts: %w", what, attempt, err)
+		}
+		if timeout > 0 && time.Since(start)+wait > timeout {
+			return res, xerrors.Errorf("%s: giving up after %d attempts in %s: %w", what, attempt, time.Since(start).Truncate(time.Millisecond), err)
+		}
+
+		log.Warnw("startup step failed, retrying", "step", what, "attempt", attempt, "of", attempts, "wait", wait, "error", err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return res, xerrors.Errorf("%s: %w (last error: %s)", what, ctx.Err(), err)
+		}
+
+		wait *= 2
+		if wait > StartupRetryMaxBackoff {
+			wait = StartupRetryMaxBackoff
+		}
+	}
+}
+
+// LocalStorage opens the local storage paths and declares them, along with
+// the sectors they hold, in the index, retrying as configured in cfg.
+func LocalStorage(ctx context.Context, cfg config.LotusProviderStorageConfig, ls paths.LocalStorage, index paths.SectorIndex, urls []string) (*paths.Local, error) {
+	return RetryStartup(ctx, "declaring local storage", cfg.DeclareRetries+1, time.Duration(cfg.DeclareRetryTimeout), func() (*paths.Local, error) {
+		// attaching paths is idempotent, so paths declared before a failure
+		// are just declared again
+		return paths.NewLocal(ctx, ls, index, urls)
+	})
+}
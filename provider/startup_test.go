@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/node/config"
+	"github.com/filecoin-project/lotus/storage/paths"
+	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// flakyIndex fails the first attaches, like an index whose database is down
+type flakyIndex struct {
+	paths.SectorIndex
+	failures int
+	attaches int
+}
+
+func (f *flakyIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo, st fsutil.FsStat) error {
+	f.attaches++
+	if f.attaches <= f.failures {
+		return xerrors.New("connection refused")
+	}
+	return f.SectorIndex.StorageAttach(ctx, si, st)
+}
+
+func testLocalStorage(t *testing.T) (*paths.BasicLocalStorage, storiface.ID) {
+	root := t.TempDir()
+
+	id := storiface.ID("test-path")
+	mb, err := json.Marshal(storiface.LocalStorageMeta{ID: id, Weight: 1, CanStore: true})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, paths.MetaFile), mb, 0644))
+
+	ls := &paths.BasicLocalStorage{PathToJSON: filepath.Join(t.TempDir(), "storage.json")}
+	require.NoError(t, ls.SetStorage(func(sc *storiface.StorageConfig) {
+		sc.StoragePaths = append(sc.StoragePaths, storiface.LocalPath{Path: root})
+	}))
+	return ls, id
+}
+
+func TestLocalStorageRetry(t *testing.T) {
+	defer func(b time.Duration) { StartupRetryBackoff = b }(StartupRetryBackoff)
+	StartupRetryBackoff = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ls, id := testLocalStorage(t)
+	cfg := config.LotusProviderStorageConfig{DeclareRetries: 3, DeclareRetryTimeout: config.Duration(time.Minute)}
+
+	// transient failures are retried
+	index := &flakyIndex{SectorIndex: paths.NewMemIndex(nil), failures: 2}
+	_, err := LocalStorage(ctx, cfg, ls, index, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, index.attaches)
+
+	si, err := index.StorageInfo(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, id, si.ID)
+
+	// and give up once retries are exhausted
+	index = &flakyIndex{SectorIndex: paths.NewMemIndex(nil), failures: 10}
+	_, err = LocalStorage(ctx, cfg, ls, index, nil)
+	require.ErrorContains(t, err, "giving up after 4 attempts")
+	require.ErrorContains(t, err, "connection refused")
+	require.Equal(t, 4, index.attaches)
+
+	// no retries
+	index = &flakyIndex{SectorIndex: paths.NewMemIndex(nil), failures: 1}
+	_, err = LocalStorage(ctx, config.LotusProviderStorageConfig{}, ls, index, nil)
+	require.ErrorContains(t, err, "declaring local storage: ")
+	require.NotContains(t, err.Error(), "giving up")
+	require.Equal(t, 1, index.attaches)
+}
+
+func TestRetryStartupTimeout(t *testing.T) {
+	defer func(b time.Duration) { StartupRetryBackoff = b }(StartupRetryBackoff)
+	StartupRetryBackoff = 20 * time.Millisecond
+
+	var tries int
+	_, err := RetryStartup(context.Background(), "test", 100, 50*time.Millisecond, func() (struct{}, error) {
+		tries++
+		return struct{}{}, xerrors.New("down")
+	})
+	require.ErrorContains(t, err, "giving up")
+	// waits of 20ms and 40ms don't fit in 50ms
+	require.Equal(t, 2, tries)
+}
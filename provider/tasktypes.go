@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+)
+
+// RestrictTaskTypes keeps the node from claiming the task types which allow
+// doesn't list, when it isn't empty, or which deny lists. Restricted tasks
+// stay registered with harmonytask.AddOnly, so that the node keeps adding
+// tasks of the types, e.g. WindowPoSt compute tasks for its miners, which
+// other nodes then claim.
+func RestrictTaskTypes(tasks []harmonytask.TaskInterface, allow, deny []string) ([]harmonytask.TaskInterface, error) {
+	allowed := map[string]bool{}
+	for _, n := range allow {
+		allowed[n] = true
+	}
+	denied := map[string]bool{}
+	for _, n := range deny {
+		if allowed[n] {
+			return nil, xerrors.Errorf("task type %s is both allowed and denied", n)
+		}
+		denied[n] = true
+	}
+
+	known := map[string]bool{}
+	out := make([]harmonytask.TaskInterface, len(tasks))
+	for i, t := range tasks {
+		name := t.TypeDetails().Name
+		known[name] = true
+
+		if denied[name] || (len(allowed) > 0 && !allowed[name]) {
+			log.Infow("not claiming task type on this node, other nodes run it", "name", name)
+			out[i] = harmonytask.AddOnly(t)
+			continue
+		}
+		out[i] = t
+	}
+
+	for _, names := range [][]string{allow, deny} {
+		for _, n := range names {
+			if !known[n] {
+				// may be a type of a subsystem not enabled here, or a typo
+				log.Warnw("task type in Subsystems.AllowTaskTypes or DenyTaskTypes isn't run by this node", "name", n)
+			}
+		}
+	}
+
+	return out, nil
+}
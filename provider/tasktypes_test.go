@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/lotus/lib/harmony/harmonytask"
+)
+
+type namedTask struct {
+	harmonytask.TaskInterface
+	name string
+}
+
+func (t *namedTask) TypeDetails() harmonytask.TaskTypeDetails {
+	return harmonytask.TaskTypeDetails{Name: t.name}
+}
+
+func TestRestrictTaskTypes(t *testing.T) {
+	tasks := []harmonytask.TaskInterface{
+		&namedTask{name: "WdPost"}, &namedTask{name: "WdPostSubmit"}, &namedTask{name: "SendMessage"},
+	}
+	claimed := func(out []harmonytask.TaskInterface) []string {
+		var names []string
+		for i, tk := range out {
+			// restricted types are wrapped, the others passed through as they are
+			if tk == tasks[i] {
+				names = append(names, tk.TypeDetails().Name)
+			} else {
+				// still registered under the same name, to add tasks
+				require.Equal(t, tasks[i].TypeDetails().Name, tk.TypeDetails().Name)
+			}
+		}
+		return names
+	}
+
+	out, err := RestrictTaskTypes(tasks, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"WdPost", "WdPostSubmit", "SendMessage"}, claimed(out))
+
+	out, err = RestrictTaskTypes(tasks, []string{"WdPost"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"WdPost"}, claimed(out))
+
+	out, err = RestrictTaskTypes(tasks, nil, []string{"WdPost", "WinPost"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"WdPostSubmit", "SendMessage"}, claimed(out))
+
+	_, err = RestrictTaskTypes(tasks, []string{"WdPost"}, []string{"WdPost"})
+	require.Error(t, err)
+}
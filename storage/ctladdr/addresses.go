@@ -2,8 +2,10 @@ package ctladdr
 
 import (
 	"context"
+	"strconv"
 
 	logging "github.com/ipfs/go-log/v2"
+	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-address"
 	"github.com/filecoin-project/go-state-types/abi"
@@ -25,8 +27,98 @@ type NodeApi interface {
 
 type AddressSelector struct {
 	api.AddressConfig
+
+	// Fallback is what AddressFor does when none of the control addresses
+	// for a message has more than minFunds. With the default, FallbackLeastBad,
+	// the worker and owner addresses are candidates like the control
+	// addresses, unless disabled in AddressConfig; picking them over the
+	// control addresses is still a fallback, reported to OnFallback. The
+	// default is kept for lotus-miner, which always selected addresses that
+	// way.
+	Fallback FallbackPolicy
+
+	// OnSelect, if set, is called with every address picked by AddressFor.
+	// It is called synchronously, so it must not block.
+	OnSelect func(Selection)
+	// OnFallback, if set, is called every time the Fallback policy is
+	// applied. It is called synchronously, so it must not block.
+	OnFallback func(FallbackEvent)
+}
+
+// FallbackPolicy tells AddressSelector what to do when no control address
+// is usable.
+type FallbackPolicy string
+
+const (
+	// FallbackLeastBad picks the candidate with the most funds.
+	FallbackLeastBad FallbackPolicy = ""
+	// FallbackError fails the selection.
+	FallbackError FallbackPolicy = "error"
+	// FallbackWorker sends from the worker address.
+	FallbackWorker FallbackPolicy = "worker"
+	// FallbackOwner sends from the owner address.
+	FallbackOwner FallbackPolicy = "owner"
+)
+
+// ParseFallbackPolicy parses a policy name, as used in config.
+func ParseFallbackPolicy(s string) (FallbackPolicy, error) {
+	switch p := FallbackPolicy(s); p {
+	case FallbackLeastBad, FallbackError, FallbackWorker, FallbackOwner:
+		return p, nil
+	default:
+		return "", xerrors.Errorf("unknown address fallback policy %q, expected one of %q, %q, %q or empty", s, FallbackError, FallbackWorker, FallbackOwner)
+	}
+}
+
+// ErrNoUsableAddress is returned by AddressFor under FallbackError, and when
+// the fallback address has no key in the wallet.
+var ErrNoUsableAddress = xerrors.New("no usable address")
+
+// UseName returns a short name of a message use, for logs and alerts.
+func UseName(use api.AddrUse) string {
+	switch use {
+	case api.PreCommitAddr:
+		return "precommit"
+	case api.CommitAddr:
+		return "commit"
+	case api.DealPublishAddr:
+		return "deal-publish"
+	case api.PoStAddr:
+		return "post"
+	case api.TerminateSectorsAddr:
+		return "terminate"
+	default:
+		return strconv.Itoa(int(use))
+	}
+}
+
+// FallbackEvent describes an application of the Fallback policy.
+type FallbackEvent struct {
+	Use    api.AddrUse
+	Policy FallbackPolicy
+	// Addr is the address fallen back to, undefined when the selection
+	// failed.
+	Addr    address.Address
+	Balance abi.TokenAmount
+	// Err is set when no address was selected.
+	Err error
+}
+
+// Selection describes an address picked by AddressFor.
+type Selection struct {
+	Use    api.AddrUse
+	Addr   address.Address
+	Reason string
 }
 
+const (
+	ReasonFunded         = "funded"          // first candidate with enough funds for optimal inclusion
+	ReasonLeastBad       = "least-bad"       // no candidate had enough funds, picked the richest
+	ReasonWorkerDefault  = "worker-default"  // no candidate had more than minFunds, defaulted to the worker
+	ReasonWorkerFallback = "worker-fallback" // no control address had enough funds, FallbackWorker or the worker was the least bad
+	ReasonOwnerFallback  = "owner-fallback"  // no control address had enough funds, FallbackOwner or the owner was the least bad
+)
+
 func (as *AddressSelector) AddressFor(ctx context.Context, a NodeApi, mi api.MinerInfo, use api.AddrUse, goodFunds, minFunds abi.TokenAmount) (address.Address, abi.TokenAmount, error) {
 	if as == nil {
 		// should only happen in some tests
@@ -75,17 +167,171 @@ func (as *AddressSelector) AddressFor(ctx context.Context, a NodeApi, mi api.Min
 		}
 	}
 
-	if len(addrs) == 0 || !as.DisableWorkerFallback {
-		addrs = append(addrs, mi.Worker)
+	var addr address.Address
+	var avail abi.TokenAmount
+	var reason string
+	var err error
+	if as.Fallback == FallbackLeastBad {
+		// without control addresses the worker is the regular sender, not a
+		// fallback
+		workerOnly := len(addrs) == 0
+		if workerOnly || !as.DisableWorkerFallback {
+			addrs = append(addrs, mi.Worker)
+		}
+		if !as.DisableOwnerFallback {
+			addrs = append(addrs, mi.Owner)
+		}
+
+		addr, avail, reason, err = pickAddress(ctx, a, mi, goodFunds, minFunds, addrs)
+		if err == nil && (addr == mi.Owner || (addr == mi.Worker && !workerOnly)) {
+			reason = as.leastBadFallback(mi, use, addr, avail, goodFunds, reason)
+		}
+	} else {
+		// without control addresses the worker is the regular sender, not a
+		// fallback
+		workerOnly := len(addrs) == 0
+		if workerOnly {
+			addrs = append(addrs, mi.Worker)
+		}
+
+		var funded bool
+		addr, avail, funded = scanAddresses(ctx, a, mi, goodFunds, minFunds, addrs)
+		switch {
+		case funded:
+			reason = ReasonFunded
+		case avail.GreaterThan(minFunds):
+			// a candidate can still pay for the message, if not optimally;
+			// the policy only applies when none has more than minFunds
+			log.Warnw("No address had enough funds to for full message Fee, selecting least bad address", "address", addr, "balance", types.FIL(avail), "optimalFunds", types.FIL(goodFunds), "minFunds", types.FIL(minFunds))
+			reason = ReasonLeastBad
+		default:
+			addr, avail, reason, err = as.fallback(ctx, a, mi, use, goodFunds, workerOnly)
+		}
+	}
+	if err == nil && as.OnSelect != nil {
+		as.OnSelect(Selection{
+			Use:    use,
+			Addr:   addr,
+			Reason: reason,
+		})
+	}
+	return addr, avail, err
+}
+
+// fallback applies the Fallback policy, after none of the candidates had
+// more than minFunds.
+func (as *AddressSelector) fallback(ctx context.Context, a NodeApi, mi api.MinerInfo, use api.AddrUse, goodFunds abi.TokenAmount, workerOnly bool) (address.Address, abi.TokenAmount, string, error) {
+	ev := FallbackEvent{Use: use, Policy: as.Fallback, Balance: big.Zero()}
+	notify := func() {
+		if as.OnFallback != nil {
+			as.OnFallback(ev)
+		}
+	}
+
+	var addr address.Address
+	var reason string
+	switch as.Fallback {
+	case FallbackWorker:
+		addr, reason = mi.Worker, ReasonWorkerFallback
+		if workerOnly {
+			reason = ReasonWorkerDefault
+		}
+	case FallbackOwner:
+		addr, reason = mi.Owner, ReasonOwnerFallback
+	case FallbackError:
+		ev.Err = xerrors.Errorf("no control address for %s messages has %s: %w", UseName(use), types.FIL(goodFunds), ErrNoUsableAddress)
+		notify()
+		return address.Undef, big.Zero(), "", ev.Err
+	default:
+		return address.Undef, big.Zero(), "", xerrors.Errorf("unknown address fallback policy %q", as.Fallback)
+	}
+
+	if workerOnly && addr == mi.Worker {
+		// the worker is the only sender, its balance is monitored by other means
+		b, err := a.WalletBalance(ctx, addr)
+		if err != nil {
+			return address.Undef, big.Zero(), "", xerrors.Errorf("checking worker balance: %w", err)
+		}
+		return addr, b, reason, nil
+	}
+
+	ev.Addr = addr
+	b, err := a.WalletBalance(ctx, addr)
+	if err == nil {
+		ev.Balance = b
+		err = checkKey(ctx, a, addr)
+	}
+	if err != nil {
+		ev.Addr = address.Undef
+		ev.Err = xerrors.Errorf("falling back to %s for %s messages: %w", addr, UseName(use), err)
+		notify()
+		return address.Undef, big.Zero(), "", ev.Err
+	}
+
+	log.Warnw("no control address has enough funds, falling back per policy", "use", UseName(use), "policy", as.Fallback, "address", addr, "balance", types.FIL(b), "optimalFunds", types.FIL(goodFunds))
+	notify()
+	return addr, b, reason, nil
+}
+
+// leastBadFallback reports that FallbackLeastBad picked the owner or worker
+// address over the control addresses, and returns the selection reason.
+func (as *AddressSelector) leastBadFallback(mi api.MinerInfo, use api.AddrUse, addr address.Address, avail, goodFunds abi.TokenAmount, reason string) string {
+	if reason != ReasonWorkerDefault {
+		reason = ReasonWorkerFallback
+		if addr == mi.Owner {
+			reason = ReasonOwnerFallback
+		}
+	}
+
+	log.Warnw("no control address has enough funds, falling back to the least bad address", "use", UseName(use), "address", addr, "balance", types.FIL(avail), "optimalFunds", types.FIL(goodFunds))
+	if as.OnFallback != nil {
+		as.OnFallback(FallbackEvent{
+			Use:     use,
+			Policy:  FallbackLeastBad,
+			Addr:    addr,
+			Balance: avail,
+		})
+	}
+	return reason
+}
+
+// checkKey returns an error wrapping ErrNoUsableAddress when the wallet has
+// no key for addr.
+func checkKey(ctx context.Context, a NodeApi, addr address.Address) error {
+	k, err := a.StateAccountKey(ctx, addr, types.EmptyTSK)
+	if err != nil {
+		return xerrors.Errorf("getting account key: %w", err)
+	}
+	have, err := a.WalletHas(ctx, k)
+	if err != nil {
+		return xerrors.Errorf("checking wallet: %w", err)
+	}
+	if !have {
+		return xerrors.Errorf("no key for %s in the wallet: %w", k, ErrNoUsableAddress)
+	}
+	return nil
+}
+
+func pickAddress(ctx context.Context, a NodeApi, mi api.MinerInfo, goodFunds, minFunds abi.TokenAmount, addrs []address.Address) (address.Address, abi.TokenAmount, string, error) {
+	leastBad, bestAvail, funded := scanAddresses(ctx, a, mi, goodFunds, minFunds, addrs)
+	if funded {
+		return leastBad, bestAvail, ReasonFunded, nil
 	}
-	if !as.DisableOwnerFallback {
-		addrs = append(addrs, mi.Owner)
+
+	log.Warnw("No address had enough funds to for full message Fee, selecting least bad address", "address", leastBad, "balance", types.FIL(bestAvail), "optimalFunds", types.FIL(goodFunds), "minFunds", types.FIL(minFunds))
+
+	reason := ReasonLeastBad
+	if bestAvail.Equals(minFunds) && leastBad == mi.Worker {
+		reason = ReasonWorkerDefault
 	}
 
-	return pickAddress(ctx, a, mi, goodFunds, minFunds, addrs)
+	return leastBad, bestAvail, reason, nil
 }
 
-func pickAddress(ctx context.Context, a NodeApi, mi api.MinerInfo, goodFunds, minFunds abi.TokenAmount, addrs []address.Address) (address.Address, abi.TokenAmount, error) {
+// scanAddresses returns the first of addrs with goodFunds and a key in the
+// wallet, with funded set. Otherwise it returns the one with the most funds
+// above minFunds, or the worker when there is none.
+func scanAddresses(ctx context.Context, a NodeApi, mi api.MinerInfo, goodFunds, minFunds abi.TokenAmount, addrs []address.Address) (address.Address, abi.TokenAmount, bool) {
 	leastBad := mi.Worker
 	bestAvail := minFunds
 
@@ -110,13 +356,11 @@ func pickAddress(ctx context.Context, a NodeApi, mi api.MinerInfo, goodFunds, mi
 		}
 
 		if maybeUseAddress(ctx, a, addr, goodFunds, &leastBad, &bestAvail) {
-			return leastBad, bestAvail, nil
+			return leastBad, bestAvail, true
 		}
 	}
 
-	log.Warnw("No address had enough funds to for full message Fee, selecting least bad address", "address", leastBad, "balance", types.FIL(bestAvail), "optimalFunds", types.FIL(goodFunds), "minFunds", types.FIL(minFunds))
-
-	return leastBad, bestAvail, nil
+	return leastBad, bestAvail, false
 }
 
 func maybeUseAddress(ctx context.Context, a NodeApi, addr address.Address, goodFunds abi.TokenAmount, leastBad *address.Address, bestAvail *abi.TokenAmount) bool {
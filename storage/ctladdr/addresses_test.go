@@ -0,0 +1,181 @@
+package ctladdr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+
+	"github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+type balanceNode struct {
+	balances map[address.Address]abi.TokenAmount
+}
+
+func (n *balanceNode) WalletBalance(ctx context.Context, addr address.Address) (types.BigInt, error) {
+	if b, ok := n.balances[addr]; ok {
+		return b, nil
+	}
+	return big.Zero(), nil
+}
+
+func (n *balanceNode) WalletHas(ctx context.Context, addr address.Address) (bool, error) {
+	return true, nil
+}
+
+func (n *balanceNode) StateAccountKey(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func (n *balanceNode) StateLookupID(ctx context.Context, addr address.Address, tsk types.TipSetKey) (address.Address, error) {
+	return addr, nil
+}
+
+func mustIDAddr(t *testing.T, id uint64) address.Address {
+	a, err := address.NewIDAddress(id)
+	require.NoError(t, err)
+	return a
+}
+
+func TestAddressForFallbackBands(t *testing.T) {
+	ctx := context.Background()
+	owner, worker, ctl := mustIDAddr(t, 100), mustIDAddr(t, 101), mustIDAddr(t, 102)
+	mi := api.MinerInfo{Owner: owner, Worker: worker, ControlAddresses: []address.Address{ctl}}
+
+	goodFunds, minFunds := big.NewInt(100), big.NewInt(10)
+	ownerFunds, workerFunds := big.NewInt(1000), big.NewInt(500)
+
+	bands := []struct {
+		name    string
+		balance abi.TokenAmount
+		// addr and reason are what is selected under every policy, unset when
+		// the policy applies
+		addr   address.Address
+		reason string
+	}{
+		{name: "good funds", balance: goodFunds, addr: ctl, reason: ReasonFunded},
+		{name: "between min and good funds", balance: big.NewInt(50), addr: ctl, reason: ReasonLeastBad},
+		{name: "min funds", balance: minFunds},
+		{name: "below min funds", balance: big.NewInt(1)},
+	}
+
+	policies := []struct {
+		policy FallbackPolicy
+		addr   address.Address
+		avail  abi.TokenAmount
+		reason string
+	}{
+		{policy: FallbackError},
+		{policy: FallbackWorker, addr: worker, avail: workerFunds, reason: ReasonWorkerFallback},
+		{policy: FallbackOwner, addr: owner, avail: ownerFunds, reason: ReasonOwnerFallback},
+	}
+
+	for _, band := range bands {
+		for _, p := range policies {
+			t.Run(band.name+"/"+string(p.policy), func(t *testing.T) {
+				node := &balanceNode{balances: map[address.Address]abi.TokenAmount{
+					owner:  ownerFunds,
+					worker: workerFunds,
+					ctl:    band.balance,
+				}}
+
+				var sel []Selection
+				var fell []FallbackEvent
+				as := &AddressSelector{
+					AddressConfig: api.AddressConfig{CommitControl: []address.Address{ctl}},
+					Fallback:      p.policy,
+					OnSelect:      func(s Selection) { sel = append(sel, s) },
+					OnFallback:    func(e FallbackEvent) { fell = append(fell, e) },
+				}
+
+				addr, avail, err := as.AddressFor(ctx, node, mi, api.CommitAddr, goodFunds, minFunds)
+
+				if band.reason != "" {
+					require.NoError(t, err)
+					require.Equal(t, band.addr, addr)
+					require.Equal(t, band.balance, avail)
+					require.Equal(t, []Selection{{Use: api.CommitAddr, Addr: band.addr, Reason: band.reason}}, sel)
+					require.Empty(t, fell)
+					return
+				}
+
+				require.Len(t, fell, 1)
+				if p.policy == FallbackError {
+					require.True(t, xerrors.Is(err, ErrNoUsableAddress))
+					require.Empty(t, sel)
+					require.Error(t, fell[0].Err)
+					return
+				}
+
+				require.NoError(t, err)
+				require.Equal(t, p.addr, addr)
+				require.Equal(t, p.avail, avail)
+				require.Equal(t, []Selection{{Use: api.CommitAddr, Addr: p.addr, Reason: p.reason}}, sel)
+				require.Equal(t, p.addr, fell[0].Addr)
+				require.NoError(t, fell[0].Err)
+			})
+		}
+	}
+}
+
+func TestAddressForLeastBadFallback(t *testing.T) {
+	ctx := context.Background()
+	owner, worker, ctl := mustIDAddr(t, 100), mustIDAddr(t, 101), mustIDAddr(t, 102)
+	mi := api.MinerInfo{Owner: owner, Worker: worker, ControlAddresses: []address.Address{ctl}}
+
+	goodFunds, minFunds := big.NewInt(100), big.NewInt(10)
+
+	cases := []struct {
+		name    string
+		config  api.AddressConfig
+		balance abi.TokenAmount // of ctl
+		addr    address.Address
+		reason  string
+		fell    bool
+	}{
+		{name: "funded control address", config: api.AddressConfig{CommitControl: []address.Address{ctl}}, balance: goodFunds, addr: ctl, reason: ReasonFunded},
+		{name: "worker over control address", config: api.AddressConfig{CommitControl: []address.Address{ctl}}, balance: big.NewInt(1), addr: worker, reason: ReasonWorkerFallback, fell: true},
+		{name: "owner over control address", config: api.AddressConfig{CommitControl: []address.Address{ctl}, DisableWorkerFallback: true}, balance: big.NewInt(1), addr: owner, reason: ReasonOwnerFallback, fell: true},
+		{name: "worker without control addresses", balance: big.NewInt(1), addr: worker, reason: ReasonFunded},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node := &balanceNode{balances: map[address.Address]abi.TokenAmount{
+				owner:  big.NewInt(1000),
+				worker: big.NewInt(500),
+				ctl:    c.balance,
+			}}
+
+			var sel []Selection
+			var fell []FallbackEvent
+			as := &AddressSelector{
+				AddressConfig: c.config,
+				OnSelect:      func(s Selection) { sel = append(sel, s) },
+				OnFallback:    func(e FallbackEvent) { fell = append(fell, e) },
+			}
+
+			addr, _, err := as.AddressFor(ctx, node, mi, api.CommitAddr, goodFunds, minFunds)
+			require.NoError(t, err)
+			require.Equal(t, c.addr, addr)
+			require.Equal(t, []Selection{{Use: api.CommitAddr, Addr: c.addr, Reason: c.reason}}, sel)
+
+			if !c.fell {
+				require.Empty(t, fell)
+				return
+			}
+			require.Len(t, fell, 1)
+			require.Equal(t, FallbackLeastBad, fell[0].Policy)
+			require.Equal(t, c.addr, fell[0].Addr)
+			require.Equal(t, node.balances[c.addr], fell[0].Balance)
+			require.NoError(t, fell[0].Err)
+		})
+	}
+}
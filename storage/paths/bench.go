@@ -0,0 +1,197 @@
+package paths
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+const benchSeqChunk = 1 << 20
+
+// benchLatencySamples bounds the random read latencies kept for the
+// percentiles, a fast drive does millions of reads in a long benchmark.
+var benchLatencySamples = 1 << 14
+
+// BenchOpts configures Local.Bench.
+type BenchOpts struct {
+	// Duration of each of the sequential and the random read phases
+	Duration time.Duration
+	// BlockSize of the random reads
+	BlockSize int64
+}
+
+// Bench measures the read performance of a local path, reading its sealed
+// and update files like proving does: first sequentially, then in small
+// blocks at random offsets. The benchmark only reads, and each phase stops
+// after opts.Duration. Reads may be served from the page cache, e.g. for
+// files read recently, which overstates the throughput of the drive.
+func (st *Local) Bench(ctx context.Context, id storiface.ID, opts BenchOpts) (storiface.PathBench, error) {
+	st.localLk.RLock()
+	p, ok := st.paths[id]
+	st.localLk.RUnlock()
+	if !ok {
+		return storiface.PathBench{}, xerrors.Errorf("path with ID %s isn't opened", id)
+	}
+
+	res, err := benchDir(ctx, p.local, opts)
+	if err != nil {
+		return storiface.PathBench{}, xerrors.Errorf("benchmarking %s: %w", p.local, err)
+	}
+	res.ID = id
+	return res, nil
+}
+
+type benchFile struct {
+	f    *os.File
+	size int64
+}
+
+func benchDir(ctx context.Context, dir string, opts BenchOpts) (storiface.PathBench, error) {
+	if opts.Duration <= 0 || opts.BlockSize <= 0 {
+		return storiface.PathBench{}, xerrors.Errorf("bench duration and block size must be positive")
+	}
+
+	var files []benchFile
+	defer func() {
+		for _, bf := range files {
+			_ = bf.f.Close()
+		}
+	}()
+	for _, ft := range []storiface.SectorFileType{storiface.FTSealed, storiface.FTUpdate} {
+		ents, err := os.ReadDir(filepath.Join(dir, ft.String()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return storiface.PathBench{}, xerrors.Errorf("listing %s files: %w", ft, err)
+		}
+		for _, ent := range ents {
+			if !ent.Type().IsRegular() {
+				continue
+			}
+			f, err := os.Open(filepath.Join(dir, ft.String(), ent.Name()))
+			if err != nil {
+				return storiface.PathBench{}, xerrors.Errorf("opening %s file: %w", ft, err)
+			}
+			st, err := f.Stat()
+			if err != nil {
+				_ = f.Close()
+				return storiface.PathBench{}, xerrors.Errorf("stat %s file: %w", ft, err)
+			}
+			if st.Size() < opts.BlockSize {
+				_ = f.Close()
+				continue
+			}
+			files = append(files, benchFile{f: f, size: st.Size()})
+		}
+	}
+	if len(files) == 0 {
+		return storiface.PathBench{}, xerrors.Errorf("no sealed or update files to read")
+	}
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	res := storiface.PathBench{
+		Files:         len(files),
+		RandBlockSize: opts.BlockSize,
+	}
+
+	// sequential reads, going through the files from a random one on
+	buf := make([]byte, benchSeqChunk)
+	start := time.Now()
+	first := rng.Intn(len(files))
+seq:
+	for i := 0; i < len(files); i++ {
+		r := io.NewSectionReader(files[(first+i)%len(files)].f, 0, files[(first+i)%len(files)].size)
+		for {
+			if ctx.Err() != nil {
+				return storiface.PathBench{}, context.Cause(ctx)
+			}
+			if time.Since(start) >= opts.Duration {
+				break seq
+			}
+
+			n, err := r.Read(buf)
+			res.SeqBytes += int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return storiface.PathBench{}, xerrors.Errorf("sequential read: %w", err)
+			}
+		}
+	}
+	res.SeqTime = time.Since(start)
+
+	// random reads of aligned blocks
+	buf = make([]byte, opts.BlockSize)
+	lats := newLatencySample(rng, benchLatencySamples)
+	start = time.Now()
+	for time.Since(start) < opts.Duration {
+		if ctx.Err() != nil {
+			return storiface.PathBench{}, context.Cause(ctx)
+		}
+
+		bf := files[rng.Intn(len(files))]
+		off := rng.Int63n(bf.size/opts.BlockSize) * opts.BlockSize
+
+		readStart := time.Now()
+		if _, err := bf.f.ReadAt(buf, off); err != nil && err != io.EOF {
+			return storiface.PathBench{}, xerrors.Errorf("random read: %w", err)
+		}
+		lats.add(time.Since(readStart))
+	}
+	res.RandTime = time.Since(start)
+	res.RandReads = lats.count
+
+	if lats.count > 0 {
+		sort.Slice(lats.kept, func(i, j int) bool { return lats.kept[i] < lats.kept[j] })
+		res.LatencyAvg = lats.total / time.Duration(lats.count)
+		res.LatencyP50 = lats.kept[len(lats.kept)/2]
+		res.LatencyP99 = lats.kept[len(lats.kept)*99/100]
+		res.LatencyMax = lats.max
+	}
+
+	return res, nil
+}
+
+// latencySample keeps a uniform random sample of at most size latencies out
+// of all those added (reservoir sampling), the percentiles are taken from
+// it. The count, total and maximum cover all latencies.
+type latencySample struct {
+	rng  *rand.Rand
+	size int
+	kept []time.Duration
+
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+func newLatencySample(rng *rand.Rand, size int) *latencySample {
+	return &latencySample{rng: rng, size: size, kept: make([]time.Duration, 0, size)}
+}
+
+func (s *latencySample) add(lat time.Duration) {
+	s.count++
+	s.total += lat
+	if lat > s.max {
+		s.max = lat
+	}
+
+	if len(s.kept) < s.size {
+		s.kept = append(s.kept, lat)
+		return
+	}
+	// the i-th latency replaces a kept one with probability size/i
+	if i := s.rng.Int63n(s.count); i < int64(s.size) {
+		s.kept[i] = lat
+	}
+}
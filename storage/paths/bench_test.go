@@ -0,0 +1,79 @@
+package paths
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestBenchDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	opts := BenchOpts{Duration: 100 * time.Millisecond, BlockSize: 4 << 10}
+
+	_, err := benchDir(ctx, dir, opts)
+	require.Error(t, err, "no files to read")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sealed"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "update"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sealed", "s-t01000-1"), make([]byte, 3<<20), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "update", "s-t01000-2"), make([]byte, 1<<20), 0644))
+	// smaller than a block, not read
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sealed", "s-t01000-3"), make([]byte, 10), 0644))
+
+	res, err := benchDir(ctx, dir, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Files)
+	// small files are read completely well within the duration
+	require.Equal(t, int64(4<<20), res.SeqBytes)
+	require.Positive(t, res.SeqThroughput())
+
+	require.Positive(t, res.RandReads)
+	require.Equal(t, int64(4<<10), res.RandBlockSize)
+	require.GreaterOrEqual(t, res.RandTime, opts.Duration)
+	require.LessOrEqual(t, res.LatencyP50, res.LatencyP99)
+	require.LessOrEqual(t, res.LatencyP99, res.LatencyMax)
+	require.Positive(t, res.RandIOPS())
+}
+
+func TestLatencySample(t *testing.T) {
+	s := newLatencySample(rand.New(rand.NewSource(1)), 100)
+	for i := 1; i <= 10000; i++ {
+		s.add(time.Duration(i))
+	}
+
+	// only the sample is kept, it is spread over all the latencies added
+	require.Len(t, s.kept, 100)
+	require.EqualValues(t, 10000, s.count)
+	require.Equal(t, time.Duration(10000*10001/2), s.total)
+	require.Equal(t, time.Duration(10000), s.max)
+
+	var late int
+	for _, l := range s.kept {
+		if l > 5000 {
+			late++
+		}
+	}
+	require.Greater(t, late, 25)
+	require.Less(t, late, 75)
+}
+
+func TestBenchDirAbort(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sealed"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sealed", "s-t01000-1"), make([]byte, 1<<20), 0644))
+
+	// the cause of the cancellation is returned
+	abort := xerrors.New("proving task claimed")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(abort)
+
+	_, err := benchDir(ctx, dir, BenchOpts{Duration: time.Minute, BlockSize: 4 << 10})
+	require.ErrorIs(t, err, abort)
+}
@@ -0,0 +1,131 @@
+package paths
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/xerrors"
+)
+
+// Content encodings supported for sector file transfers, in order of
+// preference.
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// CompressMinSize is the size below which files are sent uncompressed, the
+// ratio gained on small files doesn't pay for the encoder setup.
+var CompressMinSize int64 = 1 << 20
+
+// compressProbeSize is the amount of data compressed from the beginning of a
+// file to estimate the ratio for the whole file.
+var compressProbeSize = 1 << 20
+
+// compressMaxRatio is the compressed/original size ratio above which the
+// probe considers a file incompressible.
+const compressMaxRatio = 0.9
+
+// acceptEncoding returns the Accept-Encoding header value requesting the
+// supported encodings.
+func acceptEncoding() string {
+	return EncodingZstd + ", " + EncodingGzip
+}
+
+// negotiateEncoding picks the preferred supported encoding from an
+// Accept-Encoding header, or "" if the client accepts none of them.
+func negotiateEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		enc := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		ok := true
+		for _, p := range fields[1:] {
+			q, has := strings.CutPrefix(strings.TrimSpace(p), "q=")
+			if !has {
+				continue
+			}
+			if v, err := strconv.ParseFloat(q, 64); err != nil || v == 0 {
+				ok = false
+			}
+		}
+		accepted[enc] = ok
+	}
+
+	for _, enc := range []string{EncodingZstd, EncodingGzip} {
+		if accepted[enc] {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+// compressWriter wraps w with an encoder for enc. Closing the returned writer
+// flushes the encoder, but doesn't close w.
+func compressWriter(w io.Writer, enc string) (io.WriteCloser, error) {
+	switch enc {
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	case EncodingGzip:
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	default:
+		return nil, xerrors.Errorf("unsupported content encoding: '%s'", enc)
+	}
+}
+
+// decompressReader wraps r with a decoder for enc.
+func decompressReader(r io.Reader, enc string) (io.ReadCloser, error) {
+	switch enc {
+	case EncodingZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, xerrors.Errorf("unsupported content encoding: '%s'", enc)
+	}
+}
+
+// worthCompressing estimates whether compressing the file at path with enc
+// saves enough to be worth the CPU, by compressing a sample from its start.
+func worthCompressing(path string, size int64, enc string) (bool, error) {
+	if size < CompressMinSize {
+		return false, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close() // nolint
+
+	var out bytes.Buffer
+	cw, err := compressWriter(&out, enc)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := io.CopyN(cw, f, int64(compressProbeSize))
+	if err != nil && err != io.EOF {
+		return false, xerrors.Errorf("reading probe: %w", err)
+	}
+	if err := cw.Close(); err != nil {
+		return false, err
+	}
+
+	if n == 0 {
+		return false, nil
+	}
+
+	return float64(out.Len())/float64(n) < compressMaxRatio, nil
+}
@@ -0,0 +1,120 @@
+package paths
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	require.Equal(t, EncodingZstd, negotiateEncoding("gzip, zstd"))
+	require.Equal(t, EncodingGzip, negotiateEncoding("gzip, deflate"))
+	require.Equal(t, EncodingGzip, negotiateEncoding("zstd;q=0, gzip;q=0.5"))
+	require.Equal(t, "", negotiateEncoding("identity"))
+	require.Equal(t, "", negotiateEncoding(""))
+}
+
+func TestCompressRoundtrip(t *testing.T) {
+	data := compressibleData(4 << 20)
+
+	for _, enc := range []string{EncodingZstd, EncodingGzip} {
+		var buf bytes.Buffer
+		cw, err := compressWriter(&buf, enc)
+		require.NoError(t, err)
+		_, err = cw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, cw.Close())
+		require.Less(t, buf.Len(), len(data), enc)
+
+		dr, err := decompressReader(&buf, enc)
+		require.NoError(t, err)
+		out, err := io.ReadAll(dr)
+		require.NoError(t, err)
+		require.NoError(t, dr.Close())
+		require.Equal(t, data, out, enc)
+	}
+}
+
+func TestWorthCompressing(t *testing.T) {
+	dir := t.TempDir()
+
+	random := filepath.Join(dir, "random")
+	buf := make([]byte, 4<<20)
+	_, _ = rand.Read(buf)
+	require.NoError(t, os.WriteFile(random, buf, 0644))
+
+	compressible := filepath.Join(dir, "compressible")
+	require.NoError(t, os.WriteFile(compressible, compressibleData(4<<20), 0644))
+
+	small := filepath.Join(dir, "small")
+	require.NoError(t, os.WriteFile(small, compressibleData(1024), 0644))
+
+	for _, enc := range []string{EncodingZstd, EncodingGzip} {
+		ok, err := worthCompressing(random, 4<<20, enc)
+		require.NoError(t, err)
+		require.False(t, ok, enc)
+
+		ok, err = worthCompressing(compressible, 4<<20, enc)
+		require.NoError(t, err)
+		require.True(t, ok, enc)
+
+		ok, err = worthCompressing(small, 1024, enc)
+		require.NoError(t, err)
+		require.False(t, ok, enc)
+	}
+}
+
+// compressibleData mimics cache file content: mostly zero padding with some
+// random data mixed in.
+func compressibleData(n int) []byte {
+	data := make([]byte, n)
+	for i := 0; i < n; i += 4096 {
+		end := i + 512
+		if end > n {
+			end = n
+		}
+		_, _ = rand.Read(data[i:end])
+	}
+	return data
+}
+
+func BenchmarkCompress(b *testing.B) {
+	random := make([]byte, 16<<20)
+	_, _ = rand.Read(random)
+
+	inputs := map[string][]byte{
+		"random":       random,
+		"compressible": compressibleData(16 << 20),
+	}
+
+	for name, data := range inputs {
+		for _, enc := range []string{EncodingZstd, EncodingGzip} {
+			b.Run(name+"/"+enc, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+
+				var out int
+				for i := 0; i < b.N; i++ {
+					var buf bytes.Buffer
+					cw, err := compressWriter(&buf, enc)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if _, err := cw.Write(data); err != nil {
+						b.Fatal(err)
+					}
+					if err := cw.Close(); err != nil {
+						b.Fatal(err)
+					}
+					out = buf.Len()
+				}
+
+				b.ReportMetric(float64(out)/float64(len(data)), "ratio")
+			})
+		}
+	}
+}
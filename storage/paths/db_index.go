@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"net/url"
 	gopath "path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
@@ -115,6 +118,32 @@ func splitString(str string) []string {
 	return strings.Split(str, ",")
 }
 
+// joinFileCosts encodes file costs as type:cost pairs separated by commas.
+func joinFileCosts(costs map[string]uint64) string {
+	pairs := make([]string, 0, len(costs))
+	for typ, cost := range costs {
+		pairs = append(pairs, fmt.Sprintf("%s:%d", typ, cost))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func splitFileCosts(str string) map[string]uint64 {
+	costs := map[string]uint64{}
+	for _, pair := range splitString(str) {
+		typ, cost, ok := strings.Cut(pair, ":")
+		if !ok {
+			continue
+		}
+		c, err := strconv.ParseUint(cost, 10, 64)
+		if err != nil {
+			continue
+		}
+		costs[typ] = c
+	}
+	return costs
+}
+
 func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo, st fsutil.FsStat) error {
 	var allow, deny = make([]string, 0, len(si.AllowTypes)), make([]string, 0, len(si.DenyTypes))
 
@@ -165,8 +194,30 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 		}
 		deny = append(deny, typ)
 	}
+	var costs map[string]uint64
+	for typ, cost := range si.FileCosts {
+		if _, err := storiface.TypeFromString(typ); err != nil {
+			hasConfigIssues = true
+
+			if dbi.alerting != nil {
+				dbi.alerting.Raise(dbi.pathAlerts[si.ID], map[string]interface{}{
+					"message":   "bad path type in FileCosts",
+					"path":      string(si.ID),
+					"path_type": typ,
+					"error":     err.Error(),
+				})
+			}
+
+			continue
+		}
+		if costs == nil {
+			costs = map[string]uint64{}
+		}
+		costs[typ] = cost
+	}
 	si.AllowTypes = allow
 	si.DenyTypes = deny
+	si.FileCosts = costs
 
 	if dbi.alerting != nil && !hasConfigIssues && dbi.alerting.IsRaised(dbi.pathAlerts[si.ID]) {
 		dbi.alerting.Resolve(dbi.pathAlerts[si.ID], map[string]string{
@@ -201,7 +252,7 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 			currUrls = union(currUrls, si.URLs)
 
 			_, err = dbi.harmonyDB.Exec(ctx,
-				"UPDATE storage_path set urls=$1, weight=$2, max_storage=$3, can_seal=$4, can_store=$5, groups=$6, allow_to=$7, allow_types=$8, deny_types=$9 WHERE storage_id=$10",
+				"UPDATE storage_path set urls=$1, weight=$2, max_storage=$3, can_seal=$4, can_store=$5, groups=$6, allow_to=$7, allow_types=$8, deny_types=$9, read_only=$10, file_costs=$11 WHERE storage_id=$12",
 				strings.Join(currUrls, ","),
 				si.Weight,
 				si.MaxStorage,
@@ -211,6 +262,8 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 				strings.Join(si.AllowTo, ","),
 				strings.Join(si.AllowTypes, ","),
 				strings.Join(si.DenyTypes, ","),
+				si.ReadOnly,
+				joinFileCosts(si.FileCosts),
 				si.ID)
 			if err != nil {
 				return false, xerrors.Errorf("storage attach UPDATE fails: %v", err)
@@ -221,8 +274,9 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 
 		// Insert storage id
 		_, err = dbi.harmonyDB.Exec(ctx,
-			"INSERT INTO storage_path "+
-				"Values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)",
+			"INSERT INTO storage_path (storage_id, urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types, "+
+				"capacity, available, fs_available, reserved, used, last_heartbeat, read_only, file_costs) "+
+				"Values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)",
 			si.ID,
 			strings.Join(si.URLs, ","),
 			si.Weight,
@@ -238,7 +292,9 @@ func (dbi *DBIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 			st.FSAvailable,
 			st.Reserved,
 			st.Used,
-			time.Now())
+			time.Now(),
+			si.ReadOnly,
+			joinFileCosts(si.FileCosts))
 		if err != nil {
 			return false, xerrors.Errorf("StorageAttach insert fails: %v", err)
 		}
@@ -313,18 +369,22 @@ func (dbi *DBIndex) StorageReportHealth(ctx context.Context, id storiface.ID, re
 	var canSeal, canStore bool
 	err := dbi.harmonyDB.QueryRow(ctx,
 		"SELECT can_seal, can_store FROM storage_path WHERE storage_id=$1", id).Scan(&canSeal, &canStore)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return xerrors.Errorf("health report for unknown storage %s: %w", id, errStorageNotAttached)
+	}
 	if err != nil {
 		return xerrors.Errorf("Querying for storage id %s fails with err %v", id, err)
 	}
 
 	_, err = dbi.harmonyDB.Exec(ctx,
-		"UPDATE storage_path set capacity=$1, available=$2, fs_available=$3, reserved=$4, used=$5, last_heartbeat=$6",
+		"UPDATE storage_path set capacity=$1, available=$2, fs_available=$3, reserved=$4, used=$5, last_heartbeat=$6 WHERE storage_id=$7",
 		report.Stat.Capacity,
 		report.Stat.Available,
 		report.Stat.FSAvailable,
 		report.Stat.Reserved,
 		report.Stat.Used,
-		time.Now())
+		time.Now(),
+		id)
 	if err != nil {
 		return xerrors.Errorf("updating storage health in DB fails with err: %v", err)
 	}
@@ -553,6 +613,7 @@ func (dbi *DBIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft st
 					  	deny_types
 				FROM storage_path 
 				WHERE can_seal=true 
+				  and not read_only
 				  and available >= $1 
 				  and NOW()-last_heartbeat < $2 
 				  and heartbeat_err is null`,
@@ -624,14 +685,16 @@ func (dbi *DBIndex) StorageInfo(ctx context.Context, id storiface.ID) (storiface
 		MaxStorage uint64
 		CanSeal    bool
 		CanStore   bool
+		ReadOnly   bool
 		Groups     string
 		AllowTo    string
 		AllowTypes string
 		DenyTypes  string
+		FileCosts  string
 	}
 
 	err := dbi.harmonyDB.Select(ctx, &qResults,
-		"SELECT urls, weight, max_storage, can_seal, can_store, groups, allow_to, allow_types, deny_types "+
+		"SELECT urls, weight, max_storage, can_seal, can_store, read_only, groups, allow_to, allow_types, deny_types, file_costs "+
 			"FROM storage_path WHERE storage_id=$1", string(id))
 	if err != nil {
 		return storiface.StorageInfo{}, xerrors.Errorf("StorageInfo query fails: %v", err)
@@ -644,10 +707,12 @@ func (dbi *DBIndex) StorageInfo(ctx context.Context, id storiface.ID) (storiface
 	sinfo.MaxStorage = qResults[0].MaxStorage
 	sinfo.CanSeal = qResults[0].CanSeal
 	sinfo.CanStore = qResults[0].CanStore
+	sinfo.ReadOnly = qResults[0].ReadOnly
 	sinfo.Groups = splitString(qResults[0].Groups)
 	sinfo.AllowTo = splitString(qResults[0].AllowTo)
 	sinfo.AllowTypes = splitString(qResults[0].AllowTypes)
 	sinfo.DenyTypes = splitString(qResults[0].DenyTypes)
+	sinfo.FileCosts = splitFileCosts(qResults[0].FileCosts)
 
 	return sinfo, nil
 }
@@ -695,6 +760,7 @@ func (dbi *DBIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sec
 						 WHERE available >= $1
 						 and NOW()-last_heartbeat < $2 
 						 and heartbeat_err is null
+						 and not read_only
 						 and ($3 and can_seal = TRUE or $4 and can_store = TRUE)
 						order by (available::numeric * weight) desc`,
 		spaceReq,
@@ -0,0 +1,123 @@
+package paths
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// EvictionCandidate is a sector file which may be removed from a path to
+// make space for other files.
+type EvictionCandidate struct {
+	storiface.Decl
+	Size uint64
+}
+
+// PlanEviction picks the files to remove from the path described by si to
+// free at least need bytes. Files are picked by the cost hints of the path,
+// cheapest to get back first, and among equal costs the largest first so
+// that as few files as possible are removed. An error is returned when
+// evicting all candidates wouldn't free enough space.
+func PlanEviction(si storiface.StorageInfo, candidates []EvictionCandidate, need uint64) ([]EvictionCandidate, error) {
+	if need == 0 {
+		return nil, nil
+	}
+
+	ordered := make([]EvictionCandidate, len(candidates))
+	copy(ordered, candidates)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		ci, cj := ordered[i].SectorFileType.Cost(si.FileCosts), ordered[j].SectorFileType.Cost(si.FileCosts)
+		if ci != cj {
+			return ci < cj
+		}
+		return ordered[i].Size > ordered[j].Size
+	})
+
+	var freed uint64
+	for i, c := range ordered {
+		freed += c.Size
+		if freed >= need {
+			return ordered[:i+1], nil
+		}
+	}
+
+	return nil, xerrors.Errorf("evicting all %d candidate files frees %d bytes, need %d", len(candidates), freed, need)
+}
+
+// evictFor removes sector files from the path id which are also stored in
+// other paths, so that they can be fetched back, until need bytes are freed
+// as planned by PlanEviction. Files of the sector being reserved for, and of
+// sectors with a reservation in the path, are kept. It returns the bytes freed, zero when there is nothing to
+// evict. Must be called with localLk held.
+func (st *Local) evictFor(ctx context.Context, id storiface.ID, p *path, keep abi.SectorID, need uint64) (uint64, error) {
+	decls, err := st.index.StorageList(ctx)
+	if err != nil {
+		return 0, xerrors.Errorf("listing stored sectors: %w", err)
+	}
+
+	copies := map[storiface.Decl]int{}
+	for _, ds := range decls {
+		for _, d := range ds {
+			for _, ft := range d.SectorFileType.AllSet() {
+				copies[storiface.Decl{SectorID: d.SectorID, SectorFileType: ft}]++
+			}
+		}
+	}
+
+	var candidates []EvictionCandidate
+	for _, d := range decls[id] {
+		if _, reserved := p.reservations[d.SectorID]; reserved || d.SectorID == keep {
+			continue
+		}
+		for _, ft := range d.SectorFileType.AllSet() {
+			decl := storiface.Decl{SectorID: d.SectorID, SectorFileType: ft}
+			if copies[decl] < 2 {
+				continue
+			}
+
+			used, err := st.localStorage.DiskUsage(p.sectorPath(d.SectorID, ft))
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.Warnw("getting disk usage of eviction candidate", "sector", d.SectorID, "type", ft, "error", err)
+				}
+				continue
+			}
+			candidates = append(candidates, EvictionCandidate{Decl: decl, Size: uint64(used)})
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	si, err := st.index.StorageInfo(ctx, id)
+	if err != nil {
+		return 0, xerrors.Errorf("getting storage info: %w", err)
+	}
+
+	plan, err := PlanEviction(si, candidates, need)
+	if err != nil {
+		return 0, err
+	}
+
+	var freed uint64
+	for _, c := range plan {
+		if err := st.index.StorageDropSector(ctx, id, c.SectorID, c.SectorFileType); err != nil {
+			return freed, xerrors.Errorf("dropping evicted sector from index: %w", err)
+		}
+
+		spath := p.sectorPath(c.SectorID, c.SectorFileType)
+		log.Infow("evicting sector file copy", "path", spath, "size", c.Size)
+		if err := os.RemoveAll(spath); err != nil {
+			return freed, xerrors.Errorf("removing evicted sector file %s: %w", spath, err)
+		}
+		freed += c.Size
+	}
+
+	return freed, nil
+}
@@ -0,0 +1,146 @@
+package paths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestPlanEviction(t *testing.T) {
+	file := func(n abi.SectorNumber, ft storiface.SectorFileType, size uint64) EvictionCandidate {
+		return EvictionCandidate{
+			Decl: storiface.Decl{SectorID: abi.SectorID{Miner: 1000, Number: n}, SectorFileType: ft},
+			Size: size,
+		}
+	}
+
+	sealed := file(1, storiface.FTSealed, 32<<30)
+	smallCache := file(1, storiface.FTCache, 1<<20)
+	bigCache := file(2, storiface.FTCache, 64<<20)
+	unsealed := file(2, storiface.FTUnsealed, 32<<30)
+	candidates := []EvictionCandidate{sealed, smallCache, unsealed, bigCache}
+
+	t.Run("default costs", func(t *testing.T) {
+		// cache files go first, the largest one alone is enough
+		plan, err := PlanEviction(storiface.StorageInfo{}, candidates, 32<<20)
+		require.NoError(t, err)
+		require.Equal(t, []EvictionCandidate{bigCache}, plan)
+
+		// then unsealed before sealed
+		plan, err = PlanEviction(storiface.StorageInfo{}, candidates, 1<<30)
+		require.NoError(t, err)
+		require.Equal(t, []EvictionCandidate{bigCache, smallCache, unsealed}, plan)
+	})
+
+	t.Run("declared costs", func(t *testing.T) {
+		// unsealed copies are kept on this path, sealed files are cheap to fetch
+		si := storiface.StorageInfo{FileCosts: map[string]uint64{"unsealed": 1000, "sealed": 5}}
+		plan, err := PlanEviction(si, candidates, 1<<30)
+		require.NoError(t, err)
+		require.Equal(t, []EvictionCandidate{sealed}, plan)
+	})
+
+	t.Run("not enough", func(t *testing.T) {
+		_, err := PlanEviction(storiface.StorageInfo{}, candidates, 1<<40)
+		require.Error(t, err)
+
+		plan, err := PlanEviction(storiface.StorageInfo{}, candidates, 0)
+		require.NoError(t, err)
+		require.Empty(t, plan)
+	})
+}
+
+// fileSizeLocalStorage reports the size of files as their disk usage.
+type fileSizeLocalStorage struct {
+	sizedLocalStorage
+}
+
+func (t *fileSizeLocalStorage) DiskUsage(path string) (int64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, os.ErrNotExist
+	}
+	return fi.Size(), nil
+}
+
+func TestReserveEvicts(t *testing.T) {
+	ctx := context.TODO()
+
+	statTimeout := StatTimeout
+	StatTimeout = 0
+	t.Cleanup(func() { StatTimeout = statTimeout })
+
+	tstor := &fileSizeLocalStorage{sizedLocalStorage{
+		TestingLocalStorage: TestingLocalStorage{root: t.TempDir()},
+		available:           map[string]int64{},
+	}}
+
+	index := NewMemIndex(nil)
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	fullID, err := tstor.init("full", 1, 0)
+	require.NoError(t, err)
+	otherID, err := tstor.init("other", 1, 0)
+	require.NoError(t, err)
+
+	fullPath := filepath.Join(tstor.root, "full")
+	tstor.available[fullPath] = pathSize
+	tstor.available[filepath.Join(tstor.root, "other")] = pathSize
+	require.NoError(t, st.OpenPath(ctx, fullPath))
+	require.NoError(t, st.OpenPath(ctx, filepath.Join(tstor.root, "other")))
+
+	sector := func(n abi.SectorNumber) abi.SectorID {
+		return abi.SectorID{Miner: 1000, Number: n}
+	}
+	store := func(n abi.SectorNumber, ft storiface.SectorFileType, size int, copied bool) string {
+		p := filepath.Join(fullPath, ft.String(), storiface.SectorName(sector(n)))
+		require.NoError(t, os.WriteFile(p, make([]byte, size), 0644))
+		require.NoError(t, index.StorageDeclareSector(ctx, fullID, sector(n), ft, true))
+		if copied {
+			require.NoError(t, index.StorageDeclareSector(ctx, otherID, sector(n), ft, false))
+		}
+		return p
+	}
+	for _, ft := range storiface.PathTypes {
+		require.NoError(t, os.MkdirAll(filepath.Join(fullPath, ft.String()), 0755))
+	}
+
+	sid := storiface.SectorRef{ID: sector(1), ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1_1}
+	need := int64(storiface.FSOverheadSeal[storiface.FTCache]) * 2048 / storiface.FSOverheadDen
+
+	ownCache := store(1, storiface.FTCache, 5000, true)    // of the sector being reserved for
+	cache := store(2, storiface.FTCache, 1500, true)       // cheapest, copied
+	unsealed := store(4, storiface.FTUnsealed, 2000, true) // copied, but more expensive
+	sealed := store(3, storiface.FTSealed, 5000, false)    // the only copy
+
+	tstor.available[fullPath] = need - 1000
+
+	done, err := st.Reserve(ctx, sid, storiface.FTCache, storiface.SectorPaths{Cache: string(fullID)}, storiface.FSOverheadSeal)
+	require.NoError(t, err)
+	done()
+
+	require.NoFileExists(t, cache)
+	require.FileExists(t, ownCache)
+	require.FileExists(t, unsealed)
+	require.FileExists(t, sealed)
+
+	found, err := index.StorageFindSector(ctx, sector(2), storiface.FTCache, 0, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, otherID, found[0].ID)
+
+	// only files with another copy are evicted, the sealed file isn't
+	tstor.available[fullPath] = need - 6000
+	_, err = st.Reserve(ctx, sid, storiface.FTCache, storiface.SectorPaths{Cache: string(fullID)}, storiface.FSOverheadSeal)
+	requireTempAllocErr(t, err)
+	require.FileExists(t, unsealed)
+	require.FileExists(t, sealed)
+}
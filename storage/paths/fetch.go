@@ -9,12 +9,14 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"go.opencensus.io/stats"
 	"golang.org/x/xerrors"
 
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/storage/sealer/tarutil"
 )
 
-func fetch(ctx context.Context, url, outname string, header http.Header) (rerr error) {
+func fetch(ctx context.Context, client *http.Client, url, outname string, header http.Header) (rerr error) {
 	log.Infof("Fetch %s -> %s", url, outname)
 
 	req, err := http.NewRequest("GET", url, nil)
@@ -24,7 +26,7 @@ func fetch(ctx context.Context, url, outname string, header http.Header) (rerr e
 	req.Header = header
 	req = req.WithContext(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return xerrors.Errorf("do request: %w", err)
 	}
@@ -40,6 +42,7 @@ func fetch(ctx context.Context, url, outname string, header http.Header) (rerr e
 		took := time.Now().Sub(start)
 		mibps := float64(bytes) / 1024 / 1024 * float64(time.Second) / float64(took)
 		log.Infow("Fetch done", "url", url, "out", outname, "took", took.Round(time.Millisecond), "bytes", bytes, "MiB/s", mibps, "err", rerr)
+		stats.Record(ctx, metrics.StorageFetchBytes.M(bytes))
 	}()
 
 	mediatype, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
@@ -47,20 +50,39 @@ func fetch(ctx context.Context, url, outname string, header http.Header) (rerr e
 		return xerrors.Errorf("parse media type: %w", err)
 	}
 
+	var body io.Reader = resp.Body
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		dr, err := decompressReader(resp.Body, enc)
+		if err != nil {
+			return xerrors.Errorf("decompressing response: %w", err)
+		}
+		defer dr.Close() // nolint
+		body = dr
+	}
+
 	if err := os.RemoveAll(outname); err != nil {
 		return xerrors.Errorf("removing dest: %w", err)
 	}
 
+	defer func() {
+		// don't leave a truncated or corrupt file behind
+		if rerr != nil {
+			if err := os.RemoveAll(outname); err != nil {
+				log.Errorw("removing partial fetch", "out", outname, "error", err)
+			}
+		}
+	}()
+
 	switch mediatype {
 	case "application/x-tar":
-		bytes, err = tarutil.ExtractTar(resp.Body, outname, make([]byte, CopyBuf))
+		bytes, err = tarutil.ExtractTar(body, outname, make([]byte, CopyBuf))
 		return err
 	case "application/octet-stream":
 		f, err := os.Create(outname)
 		if err != nil {
 			return err
 		}
-		bytes, err = io.CopyBuffer(f, resp.Body, make([]byte, CopyBuf))
+		bytes, err = io.CopyBuffer(f, body, make([]byte, CopyBuf))
 		if err != nil {
 			f.Close() // nolint
 			return err
@@ -85,7 +107,7 @@ func FetchWithTemp(ctx context.Context, urls []string, dest string, header http.
 			return "", xerrors.Errorf("removing dest: %w", err)
 		}
 
-		err = fetch(ctx, url, tempDest, header)
+		err = fetch(ctx, http.DefaultClient, url, tempDest, header)
 		if err != nil {
 			merr = multierror.Append(merr, xerrors.Errorf("fetch error %s -> %s: %w", url, tempDest, err))
 			continue
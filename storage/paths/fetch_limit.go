@@ -0,0 +1,48 @@
+package paths
+
+import (
+	"context"
+
+	"go.opencensus.io/stats"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/metrics"
+)
+
+type taskFetchLimitKey struct{}
+
+// WithTaskFetchLimit returns a context under which Remote runs at most limit
+// fetches of whole sector files at once, on top of the fetch limit of the
+// Remote. It is meant to be set once per task, so that a single task can't
+// take all of the Remote fetch slots and overload the storage it reads from.
+// Remote reads and remote vanilla proofs, which only move small amounts of
+// data, aren't capped. A limit of 0 or less disables the cap.
+func WithTaskFetchLimit(ctx context.Context, limit int) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, taskFetchLimitKey{}, make(chan struct{}, limit))
+}
+
+// acquireTaskFetch waits for a slot under the task fetch limit of ctx, if
+// there is one. The returned func releases the slot. It must be called before
+// taking a slot of the Remote limit, so that waiting tasks don't hold those.
+func acquireTaskFetch(ctx context.Context) (func(), error) {
+	sem, ok := ctx.Value(taskFetchLimitKey{}).(chan struct{})
+	if !ok {
+		return func() {}, nil
+	}
+
+	if len(sem) >= cap(sem) {
+		log.Debugw("throttling fetch, task fetch limit reached", "limit", cap(sem))
+	}
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, xerrors.Errorf("context error while waiting for task fetch limiter: %w", ctx.Err())
+	}
+
+	stats.Record(ctx, metrics.StorageTaskFetches.M(int64(len(sem))))
+	return func() { <-sem }, nil
+}
@@ -0,0 +1,98 @@
+package paths
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-state-types/abi"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestTaskFetchLimit(t *testing.T) {
+	const taskLimit = 3
+	const sectors = 40
+
+	var inFlight, peak atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte("sector"))
+	}))
+	defer srv.Close()
+
+	r := NewRemote(nil, nil, nil, 10, nil)
+	dir := t.TempDir()
+
+	var runs atomic.Int64
+	fetchAll := func(ctx context.Context) {
+		run := runs.Add(1)
+		var wg sync.WaitGroup
+		for i := 0; i < sectors; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				out := filepath.Join(dir, fmt.Sprintf("s-%d-%d", run, i))
+				require.NoError(t, r.fetchThrottled(ctx, srv.URL, out, storiface.FTSealed))
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	fetchAll(WithTaskFetchLimit(context.Background(), taskLimit))
+	require.LessOrEqual(t, peak.Load(), int64(taskLimit))
+	require.Greater(t, peak.Load(), int64(1))
+
+	// the cap is per task
+	peak.Store(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fetchAll(WithTaskFetchLimit(context.Background(), taskLimit))
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, peak.Load(), int64(2*taskLimit))
+	require.Greater(t, peak.Load(), int64(taskLimit))
+
+	// no limit set
+	peak.Store(0)
+	fetchAll(context.Background())
+	require.Greater(t, peak.Load(), int64(taskLimit))
+
+	// remote vanilla proofs aren't capped
+	peak.Store(0)
+	stores := []storiface.SectorStorageInfo{{ID: "remote", BaseURLs: []string{srv.URL}}}
+	ctx := WithTaskFetchLimit(context.Background(), taskLimit)
+	for i := 0; i < sectors; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := r.remoteVanillaProof(ctx, stores, 1000, storiface.PostSectorChallenge{SectorNumber: abi.SectorNumber(i)}, abi.RegisteredPoStProof_StackedDrgWindow2KiBV1_1)
+			require.NoError(t, err)
+			require.Equal(t, []byte("sector"), p)
+		}(i)
+	}
+	wg.Wait()
+	require.Greater(t, peak.Load(), int64(taskLimit))
+}
@@ -2,7 +2,9 @@ package paths
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -25,8 +27,7 @@ var log = logging.Logger("stores")
 var _ PartialFileHandler = &DefaultPartialFileHandler{}
 
 // DefaultPartialFileHandler is the default implementation of the PartialFileHandler interface.
-// This is probably the only implementation we'll ever use because the purpose of the
-// interface to is to mock out partial file related functionality during testing.
+// It is registered as "default", see RegisterPartialFileHandler.
 type DefaultPartialFileHandler struct{}
 
 func (d *DefaultPartialFileHandler) OpenPartialFile(maxPieceSize abi.PaddedPieceSize, path string) (*partialfile.PartialFile, error) {
@@ -48,6 +49,11 @@ func (d *DefaultPartialFileHandler) Close(pf *partialfile.PartialFile) error {
 type FetchHandler struct {
 	Local     Store
 	PfHandler PartialFileHandler
+
+	// Compress lists the file types which are sent compressed when the
+	// client accepts a supported encoding. Ranged reads and files which
+	// don't compress well are always sent as-is.
+	Compress storiface.SectorFileType
 }
 
 func (handler *FetchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) { // /remote/
@@ -110,22 +116,19 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		ProofType: 0,
 	}
 
-	paths, _, err := handler.Local.AcquireSector(r.Context(), si, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	path, err := handler.acquireLocal(r.Context(), si, ft)
+	if err == errSectorFileNotFound {
+		http.Error(w, fmt.Sprintf("%s of sector %s not found in local storage", ft, id), http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Errorf("AcquireSector: %+v", err)
+		log.Errorf("%+v", err)
 		w.WriteHeader(500)
 		return
 	}
 
 	// TODO: reserve local storage here
 
-	path := storiface.PathByType(paths, ft)
-	if path == "" {
-		log.Error("acquired path was empty")
-		w.WriteHeader(500)
-		return
-	}
-
 	stat, err := os.Stat(path)
 	if err != nil {
 		log.Errorf("os.Stat: %+v", err)
@@ -133,6 +136,11 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	var enc string
+	if _, ranged := r.Header["Range"]; !ranged && handler.Compress&ft != 0 {
+		enc = negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+
 	if stat.IsDir() {
 		if _, has := r.Header["Range"]; has {
 			log.Error("Range not supported on directories")
@@ -141,22 +149,82 @@ func (handler *FetchHandler) remoteGetSector(w http.ResponseWriter, r *http.Requ
 		}
 
 		w.Header().Set("Content-Type", "application/x-tar")
+
+		var out io.Writer = w
+		if enc != "" {
+			cw, err := compressWriter(w, enc)
+			if err != nil {
+				log.Errorf("%+v", err)
+				w.WriteHeader(500)
+				return
+			}
+			defer func() {
+				if err := cw.Close(); err != nil {
+					log.Errorf("closing encoder: %+v", err)
+				}
+			}()
+
+			w.Header().Set("Content-Encoding", enc)
+			out = cw
+		}
+
 		w.WriteHeader(200)
 
-		err := tarutil.TarDirectory(path, w, make([]byte, CopyBuf))
+		err := tarutil.TarDirectory(path, out, make([]byte, CopyBuf))
 		if err != nil {
 			log.Errorf("send tar: %+v", err)
 			return
 		}
 	} else {
 		w.Header().Set("Content-Type", "application/octet-stream")
-		// will do a ranged read over the file at the given path if the caller has asked for a ranged read in the request headers.
-		http.ServeFile(w, r, path)
+
+		if enc != "" {
+			worth, err := worthCompressing(path, stat.Size(), enc)
+			if err != nil {
+				log.Warnf("probing compression of %s: %+v", path, err)
+			}
+			if !worth {
+				enc = ""
+			}
+		}
+
+		if enc == "" {
+			// will do a ranged read over the file at the given path if the caller has asked for a ranged read in the request headers.
+			http.ServeFile(w, r, path)
+		} else if err := serveCompressed(w, path, enc); err != nil {
+			log.Errorf("send compressed: %+v", err)
+			return
+		}
 	}
 
 	log.Debugf("served sector file/dir, sectorID=%+v, fileType=%s, path=%s", id, ft, path)
 }
 
+func serveCompressed(w http.ResponseWriter, path, enc string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		w.WriteHeader(500)
+		return err
+	}
+	defer f.Close() // nolint
+
+	cw, err := compressWriter(w, enc)
+	if err != nil {
+		w.WriteHeader(500)
+		return err
+	}
+
+	w.Header().Set("Content-Encoding", enc)
+	w.WriteHeader(200)
+
+	if _, err := io.CopyBuffer(cw, f, make([]byte, CopyBuf)); err != nil {
+		_ = cw.Close()
+		return err
+	}
+
+	return cw.Close()
+}
+
 func (handler *FetchHandler) remoteDeleteSector(w http.ResponseWriter, r *http.Request) {
 	log.Infof("SERVE DELETE %s", r.URL)
 	vars := mux.Vars(r)
@@ -245,16 +313,13 @@ func (handler *FetchHandler) remoteGetAllocated(w http.ResponseWriter, r *http.R
 
 	// get the path of the local Unsealed file for the given sector.
 	// return error if we do NOT have it.
-	paths, _, err := handler.Local.AcquireSector(r.Context(), si, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
-	if err != nil {
-		log.Errorf("AcquireSector: %+v", err)
-		w.WriteHeader(500)
+	path, err := handler.acquireLocal(r.Context(), si, ft)
+	if err == errSectorFileNotFound {
+		http.Error(w, fmt.Sprintf("%s of sector %s not found in local storage", ft, id), http.StatusNotFound)
 		return
 	}
-
-	path := storiface.PathByType(paths, ft)
-	if path == "" {
-		log.Error("acquired path was empty")
+	if err != nil {
+		log.Errorf("%+v", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -312,6 +377,22 @@ func (handler *FetchHandler) generateSingleVanillaProof(w http.ResponseWriter, r
 	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(vanilla))
 }
 
+var errSectorFileNotFound = xerrors.New("sector file not found in local storage")
+
+// acquireLocal returns the path of a sector file in whichever local storage
+// path of the node holds it, or errSectorFileNotFound.
+func (handler *FetchHandler) acquireLocal(ctx context.Context, si storiface.SectorRef, ft storiface.SectorFileType) (string, error) {
+	paths, _, err := handler.Local.AcquireSector(ctx, si, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	if err != nil {
+		return "", xerrors.Errorf("AcquireSector: %w", err)
+	}
+	if path := storiface.PathByType(paths, ft); path != "" {
+		return path, nil
+	}
+
+	return "", errSectorFileNotFound
+}
+
 func FileTypeFromString(t string) (storiface.SectorFileType, error) {
 	switch t {
 	case storiface.FTUnsealed.String():
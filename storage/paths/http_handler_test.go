@@ -111,7 +111,7 @@ func TestRemoteGetAllocated(t *testing.T) {
 			},
 		},
 		"fails when unsealed sector file is not found locally": {
-			expectedStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusNotFound,
 			storeFnc: func(l *mocks.MockStore) {
 
 				l.EXPECT().AcquireSector(gomock.Any(), expectedSectorRef, storiface.FTUnsealed,
@@ -311,14 +311,15 @@ func TestRemoteGetSector(t *testing.T) {
 			noResponseBytes:    true,
 		},
 		"fails when acquired sector file path is empty": {
-			expectedStatusCode: http.StatusInternalServerError,
+			expectedStatusCode: http.StatusNotFound,
 			storeFnc: func(l *mocks.MockStore, _ string) {
 
 				l.EXPECT().AcquireSector(gomock.Any(), expectedSectorRef, storiface.FTUnsealed,
 					storiface.FTNone, storiface.PathStorage, storiface.AcquireMove).Return(storiface.SectorPaths{},
 					storiface.SectorPaths{}, nil).Times(1)
 			},
-			noResponseBytes: true,
+			noResponseBytes:     true,
+			expectedContentType: "text/plain; charset=utf-8",
 		},
 		"fails when acquired file does not exist": {
 			expectedStatusCode: http.StatusInternalServerError,
@@ -406,8 +407,8 @@ func TestRemoteGetSector(t *testing.T) {
 			}
 
 			handler := &paths.FetchHandler{
-				lstore,
-				pfhandler,
+				Local:     lstore,
+				PfHandler: pfhandler,
 			}
 
 			// run http server
@@ -26,6 +26,10 @@ import (
 var HeartbeatInterval = 10 * time.Second
 var SkippedHeartbeatThresh = HeartbeatInterval * 5
 
+// errStorageNotAttached is returned from StorageReportHealth when the index
+// has no record of the storage path, e.g. after it was detached.
+var errStorageNotAttached = xerrors.New("storage path not attached")
+
 //go:generate go run github.com/golang/mock/mockgen -destination=mocks/index.go -package=mocks . SectorIndex
 
 type SectorIndex interface { // part of storage-miner api
@@ -166,8 +170,30 @@ func (i *MemIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 		}
 		deny = append(deny, typ)
 	}
+	var costs map[string]uint64
+	for typ, cost := range si.FileCosts {
+		if _, err := storiface.TypeFromString(typ); err != nil {
+			hasConfigIssues = true
+
+			if i.alerting != nil {
+				i.alerting.Raise(i.pathAlerts[si.ID], map[string]interface{}{
+					"message":   "bad path type in FileCosts",
+					"path":      string(si.ID),
+					"path_type": typ,
+					"error":     err.Error(),
+				})
+			}
+
+			continue
+		}
+		if costs == nil {
+			costs = map[string]uint64{}
+		}
+		costs[typ] = cost
+	}
 	si.AllowTypes = allow
 	si.DenyTypes = deny
+	si.FileCosts = costs
 
 	if i.alerting != nil && !hasConfigIssues && i.alerting.IsRaised(i.pathAlerts[si.ID]) {
 		i.alerting.Resolve(i.pathAlerts[si.ID], map[string]string{
@@ -202,10 +228,12 @@ func (i *MemIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo,
 		i.stores[si.ID].info.MaxStorage = si.MaxStorage
 		i.stores[si.ID].info.CanSeal = si.CanSeal
 		i.stores[si.ID].info.CanStore = si.CanStore
+		i.stores[si.ID].info.ReadOnly = si.ReadOnly
 		i.stores[si.ID].info.Groups = si.Groups
 		i.stores[si.ID].info.AllowTo = si.AllowTo
 		i.stores[si.ID].info.AllowTypes = allow
 		i.stores[si.ID].info.DenyTypes = deny
+		i.stores[si.ID].info.FileCosts = costs
 
 		return nil
 	}
@@ -313,7 +341,7 @@ func (i *MemIndex) StorageReportHealth(ctx context.Context, id storiface.ID, rep
 
 	ent, ok := i.stores[id]
 	if !ok {
-		return xerrors.Errorf("health report for unknown storage: %s", id)
+		return xerrors.Errorf("health report for unknown storage %s: %w", id, errStorageNotAttached)
 	}
 
 	ent.fsi = report.Stat
@@ -488,7 +516,7 @@ func (i *MemIndex) StorageFindSector(ctx context.Context, s abi.SectorID, ft sto
 		}
 
 		for id, st := range i.stores {
-			if !st.info.CanSeal {
+			if !st.info.CanSeal || st.info.ReadOnly {
 				continue
 			}
 
@@ -603,6 +631,9 @@ func (i *MemIndex) StorageBestAlloc(ctx context.Context, allocate storiface.Sect
 		if (pathType == storiface.PathStorage) && !p.info.CanStore {
 			continue
 		}
+		if p.info.ReadOnly {
+			continue
+		}
 
 		if spaceReq > uint64(p.fsi.Available) {
 			log.Debugf("not allocating on %s, out of space (available: %d, need: %d)", p.info.ID, p.fsi.Available, spaceReq)
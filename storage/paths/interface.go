@@ -14,6 +14,13 @@ import (
 //go:generate go run github.com/golang/mock/mockgen -destination=mocks/pf.go -package=mocks . PartialFileHandler
 
 // PartialFileHandler helps mock out the partial file functionality during testing.
+// Alternative implementations can be plugged in with RegisterPartialFileHandler.
+//
+// Implementations must be safe for concurrent use, as the same handler serves
+// all local reads and remote fetches. A *partialfile.PartialFile returned by
+// OpenPartialFile is used by a single caller at a time, and that caller always
+// calls Close on it once done, including on errors from the other methods.
+// Readers returned by Reader must stay valid until Close is called.
 type PartialFileHandler interface {
 	// OpenPartialFile opens and returns a partial file at the given path and also verifies it has the given
 	// size
@@ -3,13 +3,19 @@ package paths
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"math/bits"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"golang.org/x/xerrors"
 
 	ffi "github.com/filecoin-project/filecoin-ffi"
@@ -17,6 +23,7 @@ import (
 	"github.com/filecoin-project/go-state-types/proof"
 
 	"github.com/filecoin-project/lotus/lib/result"
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
 )
@@ -42,11 +49,19 @@ type Local struct {
 	paths map[storiface.ID]*path
 
 	localLk sync.RWMutex
+
+	heartbeatInterval atomic.Int64 // time.Duration
+	// unhealthy holds the paths for which the last health report to the
+	// index failed
+	unhealthy map[storiface.ID]bool
 }
 
 type path struct {
 	local      string // absolute local path
 	maxStorage uint64
+	minFree    uint64
+	readOnly   bool
+	groups     []string
 
 	reserved     int64
 	reservations map[abi.SectorID]storiface.SectorFileType
@@ -103,6 +118,13 @@ func (p *path) stat(ls LocalStorage) (fsutil.FsStat, error) {
 		stat.Available = 0
 	}
 
+	// space below the floor is never available, so it also doesn't get
+	// advertised to the index
+	stat.Available -= int64(p.minFree)
+	if stat.Available < 0 {
+		stat.Available = 0
+	}
+
 	if p.maxStorage > 0 {
 		used, err := ls.DiskUsage(p.local)
 		if err != nil {
@@ -142,7 +164,10 @@ func NewLocal(ctx context.Context, ls LocalStorage, index SectorIndex, urls []st
 		urls:         urls,
 
 		paths: map[storiface.ID]*path{},
+
+		unhealthy: map[storiface.ID]bool{},
 	}
+	l.heartbeatInterval.Store(int64(HeartbeatInterval))
 	return l, l.open(ctx)
 }
 
@@ -170,6 +195,9 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 		local: p,
 
 		maxStorage:   meta.MaxStorage,
+		minFree:      meta.MinFreeSpace,
+		readOnly:     meta.ReadOnly,
+		groups:       meta.Groups,
 		reserved:     0,
 		reservations: map[abi.SectorID]storiface.SectorFileType{},
 	}
@@ -186,10 +214,12 @@ func (st *Local) OpenPath(ctx context.Context, p string) error {
 		MaxStorage: meta.MaxStorage,
 		CanSeal:    meta.CanSeal,
 		CanStore:   meta.CanStore,
+		ReadOnly:   meta.ReadOnly,
 		Groups:     meta.Groups,
 		AllowTo:    meta.AllowTo,
 		AllowTypes: meta.AllowTypes,
 		DenyTypes:  meta.DenyTypes,
+		FileCosts:  meta.FileCosts,
 	}, fst)
 	if err != nil {
 		return xerrors.Errorf("declaring storage in index: %w", err)
@@ -219,6 +249,7 @@ func (st *Local) ClosePath(ctx context.Context, id storiface.ID) error {
 	}
 
 	delete(st.paths, id)
+	delete(st.unhealthy, id)
 
 	return nil
 }
@@ -276,15 +307,22 @@ func (st *Local) Redeclare(ctx context.Context, filterId *storiface.ID, dropMiss
 			MaxStorage: meta.MaxStorage,
 			CanSeal:    meta.CanSeal,
 			CanStore:   meta.CanStore,
+			ReadOnly:   meta.ReadOnly,
 			Groups:     meta.Groups,
 			AllowTo:    meta.AllowTo,
 			AllowTypes: meta.AllowTypes,
 			DenyTypes:  meta.DenyTypes,
+			FileCosts:  meta.FileCosts,
 		}, fst)
 		if err != nil {
 			return xerrors.Errorf("redeclaring storage in index: %w", err)
 		}
 
+		if p.readOnly != meta.ReadOnly {
+			log.Infow("storage path read-only mode changed", "id", id, "path", p.local, "readOnly", meta.ReadOnly)
+			p.readOnly = meta.ReadOnly
+		}
+
 		if err := st.declareSectors(ctx, p.local, meta.ID, meta.CanStore, dropMissingDecls); err != nil {
 			return xerrors.Errorf("redeclaring sectors: %w", err)
 		}
@@ -293,6 +331,43 @@ func (st *Local) Redeclare(ctx context.Context, filterId *storiface.ID, dropMiss
 	return nil
 }
 
+// SetReadOnly switches the read-only mode of a local path, persisting it in
+// the path metadata, and redeclares the path so that the index stops (or
+// resumes) placing new files in it.
+func (st *Local) SetReadOnly(ctx context.Context, id storiface.ID, readOnly bool) error {
+	st.localLk.RLock()
+	p, ok := st.paths[id]
+	st.localLk.RUnlock()
+	if !ok {
+		return xerrors.Errorf("path with ID %s isn't opened", id)
+	}
+
+	mpath := filepath.Join(p.local, MetaFile)
+	mb, err := os.ReadFile(mpath)
+	if err != nil {
+		return xerrors.Errorf("reading storage metadata for %s: %w", p.local, err)
+	}
+
+	var meta storiface.LocalStorageMeta
+	if err := json.Unmarshal(mb, &meta); err != nil {
+		return xerrors.Errorf("unmarshalling storage metadata for %s: %w", p.local, err)
+	}
+
+	if meta.ReadOnly != readOnly {
+		meta.ReadOnly = readOnly
+
+		mb, err = json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			return xerrors.Errorf("marshalling storage metadata: %w", err)
+		}
+		if err := os.WriteFile(mpath, mb, 0644); err != nil {
+			return xerrors.Errorf("writing storage metadata for %s: %w", p.local, err)
+		}
+	}
+
+	return st.Redeclare(ctx, &id, false)
+}
+
 func (st *Local) declareSectors(ctx context.Context, p string, id storiface.ID, primary, dropMissing bool) error {
 	indexed := map[storiface.Decl]struct{}{}
 	if dropMissing {
@@ -360,11 +435,18 @@ func (st *Local) declareSectors(ctx context.Context, p string, id storiface.ID,
 	return nil
 }
 
-func (st *Local) reportHealth(ctx context.Context) {
-	// randomize interval by ~10%
-	interval := (HeartbeatInterval*100_000 + time.Duration(rand.Int63n(10_000))) / 100_000
+// SetHeartbeatInterval sets how often the health of local paths is reported
+// to the index, HeartbeatInterval by default. Paths which miss heartbeats for
+// SkippedHeartbeatThresh aren't used by other nodes.
+func (st *Local) SetHeartbeatInterval(interval time.Duration) {
+	st.heartbeatInterval.Store(int64(interval))
+}
 
+func (st *Local) reportHealth(ctx context.Context) {
 	for {
+		// randomize interval by ~10%
+		interval := (time.Duration(st.heartbeatInterval.Load())*100_000 + time.Duration(rand.Int63n(10_000))) / 100_000
+
 		select {
 		case <-time.After(interval):
 		case <-ctx.Done():
@@ -392,9 +474,107 @@ func (st *Local) reportStorage(ctx context.Context) {
 	st.localLk.RUnlock()
 
 	for id, report := range toReport {
-		if err := st.index.StorageReportHealth(ctx, id, report); err != nil {
+		err := st.index.StorageReportHealth(ctx, id, report)
+		if err != nil && !errors.Is(err, errStorageNotAttached) {
 			log.Warnf("error reporting storage health for %s (%+v): %+v", id, report, err)
+			st.setUnhealthy(id, true)
+			continue
 		}
+
+		// the registration lapsed, or heartbeats failed (e.g. the index
+		// database was unreachable), so sector declarations may be out of
+		// date too; attach the path again and redeclare its sectors
+		if err != nil || st.setUnhealthy(id, false) {
+			log.Warnw("re-registering storage path in index", "id", id, "error", err)
+
+			id := id
+			if err := st.Redeclare(ctx, &id, false); err != nil {
+				log.Errorw("re-registering storage path", "id", id, "error", err)
+				st.setUnhealthy(id, true)
+				continue
+			}
+		}
+
+		ctx, _ := tag.New(ctx, tag.Upsert(metrics.StorageID, string(id)))
+		stats.Record(ctx, metrics.StorageLastHeartbeat.M(time.Now().Unix()))
+	}
+}
+
+// setUnhealthy records the heartbeat state of a path, returning the previous
+// state.
+func (st *Local) setUnhealthy(id storiface.ID, unhealthy bool) bool {
+	st.localLk.Lock()
+	defer st.localLk.Unlock()
+
+	was := st.unhealthy[id]
+	if unhealthy {
+		st.unhealthy[id] = true
+	} else {
+		delete(st.unhealthy, id)
+	}
+	return was
+}
+
+// ReportMetrics records usage of each local path as metrics every interval,
+// until ctx is cancelled. Values come from the same accounting used for
+// reservations and health reports.
+func (st *Local) ReportMetrics(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		st.recordMetrics(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (st *Local) recordMetrics(ctx context.Context) {
+	type pathUsage struct {
+		stat         fsutil.FsStat
+		groups       string
+		reservations int
+	}
+
+	st.localLk.RLock()
+
+	usage := map[storiface.ID]pathUsage{}
+	for id, p := range st.paths {
+		stat, err := p.stat(st.localStorage)
+		if err != nil {
+			log.Warnw("getting storage stat for metrics", "id", id, "error", err)
+			continue
+		}
+
+		groups := append([]string{}, p.groups...)
+		sort.Strings(groups)
+
+		usage[id] = pathUsage{
+			stat:         stat,
+			groups:       strings.Join(groups, ","),
+			reservations: len(p.reservations),
+		}
+	}
+
+	st.localLk.RUnlock()
+
+	for id, u := range usage {
+		ctx, _ := tag.New(ctx,
+			tag.Upsert(metrics.StorageID, string(id)),
+			tag.Upsert(metrics.StorageGroup, u.groups),
+		)
+
+		stats.Record(ctx,
+			metrics.LocalPathCapacityBytes.M(u.stat.Capacity),
+			metrics.LocalPathUsedBytes.M(u.stat.Capacity-u.stat.FSAvailable),
+			metrics.LocalPathAvailableBytes.M(u.stat.Available),
+			metrics.LocalPathReservedBytes.M(u.stat.Reserved),
+			metrics.LocalPathReservations.M(int64(u.reservations)),
+		)
 	}
 }
 
@@ -433,7 +613,16 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 		overhead := int64(overheadTab[fileType]) * int64(ssize) / storiface.FSOverheadDen
 
 		if stat.Available < overhead {
-			return nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), only %d available", overhead, p.local, id, stat.Available))
+			// make room by removing files which can be fetched back
+			freed, err := st.evictFor(ctx, id, p, sid.ID, uint64(overhead-stat.Available))
+			if err != nil {
+				log.Warnw("evicting sector files to reserve space", "path", p.local, "need", overhead-stat.Available, "error", err)
+			}
+			stat.Available += int64(freed)
+		}
+
+		if stat.Available < overhead {
+			return nil, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("can't reserve %d bytes in '%s' (id:%s), only %d available (keeping %d bytes free)", overhead, p.local, id, stat.Available, p.minFree))
 		}
 
 		p.reserved += overhead
@@ -459,6 +648,34 @@ func (st *Local) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 	return done, nil
 }
 
+// hasSpaceFor checks if p can currently fit a new file of the given type.
+// Must be called with localLk held.
+func (st *Local) hasSpaceFor(p *path, fileType storiface.SectorFileType, ssize abi.SectorSize, pathType storiface.PathType) bool {
+	var spaceReq uint64
+	var err error
+	if pathType == storiface.PathSealing {
+		spaceReq, err = fileType.SealSpaceUse(ssize)
+	} else {
+		spaceReq, err = fileType.StoreSpaceUse(ssize)
+	}
+	if err != nil {
+		log.Warnw("estimating required space", "type", fileType, "error", err)
+		return false
+	}
+
+	stat, err := p.stat(st.localStorage)
+	if err != nil {
+		log.Warnw("checking free space", "path", p.local, "error", err)
+		return false
+	}
+
+	if uint64(stat.Available) < spaceReq {
+		log.Debugw("not allocating, out of space", "path", p.local, "available", stat.Available, "need", spaceReq, "minFree", p.minFree)
+		return false
+	}
+	return true
+}
+
 func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, error) {
 	if existing|allocate != existing^allocate {
 		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.New("can't both find and allocate a sector")
@@ -517,6 +734,7 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 
 		var best string
 		var bestID storiface.ID
+		var noSpace int
 
 		for _, si := range sis {
 			p, ok := st.paths[si.ID]
@@ -536,11 +754,21 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 				continue
 			}
 
+			// the index may not have seen the last redeclare yet
+			if si.ReadOnly || p.readOnly {
+				continue
+			}
+
 			if !fileType.Allowed(si.AllowTypes, si.DenyTypes) {
 				continue
 			}
 
-			// TODO: Check free space
+			// the index only learns about free space on heartbeats, so check
+			// locally before committing to a path
+			if !st.hasSpaceFor(p, fileType, ssize, pathType) {
+				noSpace++
+				continue
+			}
 
 			best = p.sectorPath(sid.ID, fileType)
 			bestID = si.ID
@@ -548,6 +776,9 @@ func (st *Local) AcquireSector(ctx context.Context, sid storiface.SectorRef, exi
 		}
 
 		if best == "" {
+			if noSpace > 0 {
+				return storiface.SectorPaths{}, storiface.SectorPaths{}, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("couldn't find a suitable path for a sector: %d path(s) don't have enough free space", noSpace))
+			}
 			return storiface.SectorPaths{}, storiface.SectorPaths{}, storiface.Err(storiface.ErrTempAllocateSpace, xerrors.Errorf("couldn't find a suitable path for a sector"))
 		}
 
@@ -3,12 +3,20 @@ package paths
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/go-state-types/abi"
 
 	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
@@ -93,3 +101,304 @@ func TestLocalStorage(t *testing.T) {
 
 	// TODO: put more things here
 }
+
+// sizedLocalStorage reports a separate, mutable amount of free space for each path
+type sizedLocalStorage struct {
+	TestingLocalStorage
+	available map[string]int64
+}
+
+func (t *sizedLocalStorage) Stat(path string) (fsutil.FsStat, error) {
+	return fsutil.FsStat{
+		Capacity:    pathSize,
+		Available:   t.available[path],
+		FSAvailable: t.available[path],
+	}, nil
+}
+
+func (t *sizedLocalStorage) init(subpath string, weight, minFree uint64) (storiface.ID, error) {
+	path := filepath.Join(t.root, subpath)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return "", err
+	}
+
+	meta := &storiface.LocalStorageMeta{
+		ID:           storiface.ID(uuid.New().String()),
+		Weight:       weight,
+		CanSeal:      true,
+		CanStore:     true,
+		MinFreeSpace: minFree,
+	}
+
+	mb, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return meta.ID, os.WriteFile(filepath.Join(path, MetaFile), mb, 0644)
+}
+
+func TestLocalMinFreeSpace(t *testing.T) {
+	ctx := context.TODO()
+
+	const minFree = 4 << 20
+
+	// free space changes between calls, don't serve it from the stat cache
+	statTimeout := StatTimeout
+	StatTimeout = 0
+	t.Cleanup(func() { StatTimeout = statTimeout })
+
+	tstor := &sizedLocalStorage{
+		TestingLocalStorage: TestingLocalStorage{root: t.TempDir()},
+		available:           map[string]int64{},
+	}
+
+	index := NewMemIndex(nil)
+
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	// the full path is preferred by weight, so the index will offer it first
+	fullID, err := tstor.init("full", 10, minFree)
+	require.NoError(t, err)
+	roomyID, err := tstor.init("roomy", 1, minFree)
+	require.NoError(t, err)
+
+	fullPath := filepath.Join(tstor.root, "full")
+	roomyPath := filepath.Join(tstor.root, "roomy")
+	tstor.available[fullPath] = pathSize
+	tstor.available[roomyPath] = pathSize
+
+	require.NoError(t, st.OpenPath(ctx, fullPath))
+	require.NoError(t, st.OpenPath(ctx, roomyPath))
+
+	// the full path fills up between heartbeats, it is now just above the floor
+	tstor.available[fullPath] = minFree + 1<<10
+
+	sid := storiface.SectorRef{
+		ID:        abi.SectorID{Miner: 1000, Number: 1},
+		ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+	}
+
+	// reserving directly in the full path would breach the floor
+	_, err = st.Reserve(ctx, sid, storiface.FTCache, storiface.SectorPaths{Cache: string(fullID)}, storiface.FSOverheadSeal)
+	requireTempAllocErr(t, err)
+
+	// allocation skips the full path even though the index still ranks it first
+	_, ids, err := st.AcquireSector(ctx, sid, storiface.FTNone, storiface.FTCache, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.Equal(t, string(roomyID), ids.Cache)
+
+	// once the other path is full too, allocation fails with a temporary error
+	tstor.available[roomyPath] = minFree
+	_, _, err = st.AcquireSector(ctx, sid, storiface.FTNone, storiface.FTCache, storiface.PathSealing, storiface.AcquireMove)
+	requireTempAllocErr(t, err)
+}
+
+func TestLocalReadOnly(t *testing.T) {
+	ctx := context.TODO()
+
+	tstor := &sizedLocalStorage{
+		TestingLocalStorage: TestingLocalStorage{root: t.TempDir()},
+		available:           map[string]int64{},
+	}
+
+	index := NewMemIndex(nil)
+
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	// the old path is preferred by weight
+	oldID, err := tstor.init("old", 10, 0)
+	require.NoError(t, err)
+	newID, err := tstor.init("new", 1, 0)
+	require.NoError(t, err)
+
+	oldPath := filepath.Join(tstor.root, "old")
+	newPath := filepath.Join(tstor.root, "new")
+	tstor.available[oldPath] = pathSize
+	tstor.available[newPath] = pathSize
+
+	require.NoError(t, st.OpenPath(ctx, oldPath))
+	require.NoError(t, st.OpenPath(ctx, newPath))
+
+	sector := func(n abi.SectorNumber) storiface.SectorRef {
+		return storiface.SectorRef{
+			ID:        abi.SectorID{Miner: 1000, Number: n},
+			ProofType: abi.RegisteredSealProof_StackedDrg2KiBV1_1,
+		}
+	}
+
+	// a sector lands in the old path
+	_, ids, err := st.AcquireSector(ctx, sector(1), storiface.FTNone, storiface.FTCache, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.Equal(t, string(oldID), ids.Cache)
+	require.NoError(t, index.StorageDeclareSector(ctx, oldID, sector(1).ID, storiface.FTCache, true))
+
+	require.NoError(t, st.SetReadOnly(ctx, oldID, true))
+
+	si, err := index.StorageInfo(ctx, oldID)
+	require.NoError(t, err)
+	require.True(t, si.ReadOnly)
+
+	// the mode is persisted in the path metadata
+	mb, err := os.ReadFile(filepath.Join(oldPath, MetaFile))
+	require.NoError(t, err)
+	var meta storiface.LocalStorageMeta
+	require.NoError(t, json.Unmarshal(mb, &meta))
+	require.True(t, meta.ReadOnly)
+
+	// new files go to the other path
+	_, ids, err = st.AcquireSector(ctx, sector(2), storiface.FTNone, storiface.FTCache, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.Equal(t, string(newID), ids.Cache)
+
+	best, err := index.StorageBestAlloc(ctx, storiface.FTCache, 2048, storiface.PathStorage)
+	require.NoError(t, err)
+	require.Len(t, best, 1)
+	require.Equal(t, newID, best[0].ID)
+
+	// the read-only path isn't a fetch destination either
+	found, err := index.StorageFindSector(ctx, sector(2).ID, storiface.FTCache, 2048, true)
+	require.NoError(t, err)
+	for _, f := range found {
+		require.NotEqual(t, oldID, f.ID)
+	}
+
+	// but the sector in it can still be read and fetched from
+	paths, ids, err := st.AcquireSector(ctx, sector(1), storiface.FTCache, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.Equal(t, string(oldID), ids.Cache)
+	require.Equal(t, filepath.Join(oldPath, storiface.FTCache.String(), storiface.SectorName(sector(1).ID)), paths.Cache)
+
+	found, err = index.StorageFindSector(ctx, sector(1).ID, storiface.FTCache, 2048, false)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, oldID, found[0].ID)
+
+	// leaving read-only mode takes effect right away
+	require.NoError(t, st.SetReadOnly(ctx, oldID, false))
+	_, ids, err = st.AcquireSector(ctx, sector(3), storiface.FTNone, storiface.FTCache, storiface.PathSealing, storiface.AcquireMove)
+	require.NoError(t, err)
+	require.Equal(t, string(oldID), ids.Cache)
+}
+
+func requireTempAllocErr(t *testing.T, err error) {
+	var cerr *storiface.CallError
+	require.True(t, errors.As(err, &cerr), "expected a storage call error, got %v", err)
+	require.Equal(t, storiface.ErrTempAllocateSpace, cerr.Code)
+}
+
+// blipIndex fails health reports while down is set, simulating a transient
+// outage of the index database.
+type blipIndex struct {
+	*MemIndex
+	down    bool
+	attachs int
+}
+
+func (b *blipIndex) StorageReportHealth(ctx context.Context, id storiface.ID, report storiface.HealthReport) error {
+	if b.down {
+		return xerrors.New("connection refused")
+	}
+	return b.MemIndex.StorageReportHealth(ctx, id, report)
+}
+
+func (b *blipIndex) StorageAttach(ctx context.Context, si storiface.StorageInfo, st fsutil.FsStat) error {
+	b.attachs++
+	return b.MemIndex.StorageAttach(ctx, si, st)
+}
+
+func TestLocalHeartbeatReregisters(t *testing.T) {
+	ctx := context.TODO()
+
+	tstor := &TestingLocalStorage{
+		root: t.TempDir(),
+	}
+	index := &blipIndex{MemIndex: NewMemIndex(nil)}
+
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, tstor.init("1"))
+	require.NoError(t, st.OpenPath(ctx, filepath.Join(tstor.root, "1")))
+	require.Equal(t, 1, index.attachs)
+
+	var id storiface.ID
+	for pid := range st.paths {
+		id = pid
+	}
+
+	// healthy heartbeat doesn't touch the registration
+	st.reportStorage(ctx)
+	require.Equal(t, 1, index.attachs)
+
+	// the database goes away for a while
+	index.down = true
+	st.reportStorage(ctx)
+	require.True(t, st.unhealthy[id])
+
+	// once it's back the path is registered again
+	index.down = false
+	st.reportStorage(ctx)
+	require.False(t, st.unhealthy[id])
+	require.Equal(t, 2, index.attachs)
+
+	// the registration lapsed entirely
+	index.lk.Lock()
+	delete(index.stores, id)
+	index.lk.Unlock()
+
+	st.reportStorage(ctx)
+	require.Equal(t, 3, index.attachs)
+	_, err = index.StorageInfo(ctx, id)
+	require.NoError(t, err)
+}
+
+func TestFetchHandlerAcrossPaths(t *testing.T) {
+	ctx := context.TODO()
+
+	tstor := &TestingLocalStorage{
+		root: t.TempDir(),
+	}
+	index := NewMemIndex(nil)
+
+	st, err := NewLocal(ctx, tstor, index, nil)
+	require.NoError(t, err)
+
+	// the sealed file is in the first path, the unsealed one in the second
+	sector := storiface.SectorName(abi.SectorID{Miner: 123, Number: 123})
+	for sub, ft := range map[string]storiface.SectorFileType{"1": storiface.FTSealed, "2": storiface.FTUnsealed} {
+		require.NoError(t, tstor.init(sub))
+		dir := filepath.Join(tstor.root, sub, ft.String())
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, sector), []byte(ft.String()), 0644))
+		require.NoError(t, st.OpenPath(ctx, filepath.Join(tstor.root, sub)))
+	}
+
+	ts := httptest.NewServer(&FetchHandler{Local: st})
+	defer ts.Close()
+
+	get := func(ft storiface.SectorFileType) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("%s/remote/%s/%s", ts.URL, ft, sector))
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		bz, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(bz)
+	}
+
+	code, body := get(storiface.FTSealed)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "sealed", body)
+
+	code, body = get(storiface.FTUnsealed)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "unsealed", body)
+
+	code, body = get(storiface.FTCache)
+	require.Equal(t, http.StatusNotFound, code)
+	require.Contains(t, body, "not found in local storage")
+}
@@ -0,0 +1,580 @@
+package paths
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opencensus.io/stats"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/metrics"
+	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// ObjectStoreScheme is the URL scheme of object store paths in the index,
+// s3://bucket/prefix.
+const ObjectStoreScheme = "s3"
+
+// ObjectStoreRescanInterval is how often object stores are listed for
+// sector files added to or removed from the bucket.
+var ObjectStoreRescanInterval = time.Hour
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+func isObjectURL(u string) bool {
+	return strings.HasPrefix(u, ObjectStoreScheme+"://")
+}
+
+// ObjectStore reads sector files from a bucket of an S3-compatible object
+// store. It only reads, files fetched from it are never removed from the
+// bucket, and the path is never picked to place new files in.
+//
+// Compared to fetching from another storage node over HTTP, a fetch from an
+// object store pays a request per object, so cache directories cost one
+// request per file on top of a listing, and first-byte latency of a bucket is
+// usually much higher than of a node on the local network. Object stores also
+// can't generate vanilla proofs or answer partial reads, so files have to be
+// fetched in full to local storage before they can be proven from; the Remote
+// does so when proving a sector only held by object stores, which needs local
+// storage paths able to store the files. They are best used as a fallback
+// copy of long-term sealed sectors, with the Weight of the path kept below the
+// weight of storage nodes holding the same files.
+type ObjectStore struct {
+	cfg      storiface.ObjectStorePath
+	endpoint *url.URL
+	client   *http.Client
+
+	now func() time.Time
+}
+
+func NewObjectStore(cfg storiface.ObjectStorePath) (*ObjectStore, error) {
+	if cfg.ID == "" {
+		return nil, xerrors.Errorf("object store path ID not set")
+	}
+	if cfg.Bucket == "" {
+		return nil, xerrors.Errorf("object store path %s: bucket not set", cfg.ID)
+	}
+	ep, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, xerrors.Errorf("object store path %s: parsing endpoint: %w", cfg.ID, err)
+	}
+	if ep.Scheme != "http" && ep.Scheme != "https" {
+		return nil, xerrors.Errorf("object store path %s: endpoint %q must be an http or https URL", cfg.ID, cfg.Endpoint)
+	}
+	if (cfg.AccessKey == "") != (cfg.SecretKey == "") {
+		return nil, xerrors.Errorf("object store path %s: both AccessKey and SecretKey must be set, or neither", cfg.ID)
+	}
+	if _, err := objectAllowTypes(cfg.AllowTypes); err != nil {
+		return nil, xerrors.Errorf("object store path %s: AllowTypes: %w", cfg.ID, err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	return &ObjectStore{
+		cfg:      cfg,
+		endpoint: ep,
+		client:   http.DefaultClient,
+		now:      time.Now,
+	}, nil
+}
+
+// ID is the ID of the storage path of the object store.
+func (o *ObjectStore) ID() storiface.ID {
+	return o.cfg.ID
+}
+
+// BaseURL is the URL of the path in the index, file URLs are built from it
+// like for other paths.
+func (o *ObjectStore) BaseURL() string {
+	u := url.URL{Scheme: ObjectStoreScheme, Host: o.cfg.Bucket, Path: "/" + o.cfg.Prefix}
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// owns tells if the file URL is of this object store.
+func (o *ObjectStore) owns(u string) bool {
+	return strings.HasPrefix(u, o.BaseURL()+"/")
+}
+
+// key is the object key of a file URL.
+func (o *ObjectStore) key(u string) (string, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", xerrors.Errorf("parsing object url: %w", err)
+	}
+	if pu.Scheme != ObjectStoreScheme || pu.Host != o.cfg.Bucket {
+		return "", xerrors.Errorf("url %s isn't in bucket %s", u, o.cfg.Bucket)
+	}
+	return strings.TrimPrefix(pu.Path, "/"), nil
+}
+
+// Attach adds the path to the index, and declares the sectors found in the
+// bucket in it. The path isn't added while the bucket can't be listed.
+func (o *ObjectStore) Attach(ctx context.Context, index SectorIndex) error {
+	if _, _, err := o.list(ctx, o.listPrefix(), 1, ""); err != nil {
+		return xerrors.Errorf("object store path %s unreachable: %w", o.cfg.ID, err)
+	}
+
+	err := index.StorageAttach(ctx, storiface.StorageInfo{
+		ID:         o.cfg.ID,
+		URLs:       []string{o.BaseURL()},
+		Weight:     o.cfg.Weight,
+		CanSeal:    false,
+		CanStore:   false,
+		ReadOnly:   true,
+		Groups:     o.cfg.Groups,
+		AllowTypes: o.cfg.AllowTypes,
+	}, fsutil.FsStat{})
+	if err != nil {
+		return xerrors.Errorf("attaching object store path %s: %w", o.cfg.ID, err)
+	}
+
+	return o.rescan(ctx, index)
+}
+
+// Run attaches the path to the index, then keeps it healthy and rescans the
+// bucket every ObjectStoreRescanInterval, until ctx is cancelled. An
+// unreachable bucket doesn't stop the node: attaching is retried every
+// HeartbeatInterval, and the path isn't offered for fetches until it
+// succeeds.
+func (o *ObjectStore) Run(ctx context.Context, index SectorIndex) {
+	for {
+		err := o.Attach(ctx, index)
+		if err == nil {
+			break
+		}
+		log.Errorw("attaching object store, retrying", "id", o.cfg.ID, "retry", HeartbeatInterval, "error", err)
+
+		select {
+		case <-time.After(HeartbeatInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	rescan := time.NewTicker(ObjectStoreRescanInterval)
+	defer rescan.Stop()
+
+	for {
+		select {
+		case <-heartbeat.C:
+			// object stores have no capacity to report, heartbeats are skipped
+			// while the bucket can't be listed so that the path turns unhealthy
+			if _, _, err := o.list(ctx, o.listPrefix(), 1, ""); err != nil {
+				log.Warnw("object store unreachable", "id", o.cfg.ID, "error", err)
+				continue
+			}
+			if err := index.StorageReportHealth(ctx, o.cfg.ID, storiface.HealthReport{}); err != nil {
+				log.Errorw("reporting object store health", "id", o.cfg.ID, "error", err)
+			}
+		case <-rescan.C:
+			if err := o.rescan(ctx, index); err != nil {
+				log.Errorw("rescanning object store", "id", o.cfg.ID, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rescan declares the sector files in the bucket, and drops the declarations
+// of files which are gone.
+func (o *ObjectStore) rescan(ctx context.Context, index SectorIndex) error {
+	found, err := o.Sectors(ctx)
+	if err != nil {
+		return err
+	}
+
+	declared, err := index.StorageList(ctx)
+	if err != nil {
+		return xerrors.Errorf("listing index: %w", err)
+	}
+
+	have := map[storiface.Decl]struct{}{}
+	for _, d := range declared[o.cfg.ID] {
+		have[d] = struct{}{}
+	}
+
+	for _, d := range found {
+		if _, ok := have[d]; ok {
+			delete(have, d)
+			continue
+		}
+		if err := index.StorageDeclareSector(ctx, o.cfg.ID, d.SectorID, d.SectorFileType, false); err != nil {
+			return xerrors.Errorf("declaring sector %d (%s): %w", d.Number, d.SectorFileType, err)
+		}
+	}
+	for d := range have {
+		if err := index.StorageDropSector(ctx, o.cfg.ID, d.SectorID, d.SectorFileType); err != nil {
+			return xerrors.Errorf("dropping sector %d (%s): %w", d.Number, d.SectorFileType, err)
+		}
+	}
+
+	log.Infow("scanned object store", "id", o.cfg.ID, "files", len(found), "removed", len(have))
+	return nil
+}
+
+// objectAllowTypes parses the AllowTypes of an object store, all types are
+// allowed when none are listed.
+func objectAllowTypes(types []string) (storiface.SectorFileType, error) {
+	if len(types) == 0 {
+		return storiface.FTAll, nil
+	}
+	var allowed storiface.SectorFileType
+	for _, t := range types {
+		ft, err := storiface.TypeFromString(t)
+		if err != nil {
+			return 0, err
+		}
+		allowed |= ft
+	}
+	return allowed, nil
+}
+
+func (o *ObjectStore) listPrefix() string {
+	if o.cfg.Prefix == "" {
+		return ""
+	}
+	return o.cfg.Prefix + "/"
+}
+
+// Sectors lists the sector files in the bucket.
+func (o *ObjectStore) Sectors(ctx context.Context) ([]storiface.Decl, error) {
+	allowed, err := objectAllowTypes(o.cfg.AllowTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := o.listPrefix()
+	seen := map[storiface.Decl]struct{}{}
+	var out []storiface.Decl
+
+	var token string
+	for {
+		keys, next, err := o.list(ctx, prefix, 1000, token)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			// type/s-t01000-1, or type/s-t01000-1/file for cache directories
+			parts := strings.SplitN(strings.TrimPrefix(k, prefix), "/", 3)
+			if len(parts) < 2 {
+				continue
+			}
+			ft, err := storiface.TypeFromString(parts[0])
+			if err != nil || ft&allowed == 0 {
+				continue
+			}
+			sid, err := storiface.ParseSectorID(parts[1])
+			if err != nil {
+				continue
+			}
+
+			d := storiface.Decl{SectorID: sid, SectorFileType: ft}
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+			out = append(out, d)
+		}
+
+		if next == "" {
+			return out, nil
+		}
+		token = next
+	}
+}
+
+// Fetch downloads the file at the object URL to outname. Cache directories
+// are fetched object by object into the outname directory.
+func (o *ObjectStore) Fetch(ctx context.Context, u, outname string, fileType storiface.SectorFileType) (rerr error) {
+	key, err := o.key(u)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Fetch %s -> %s", u, outname)
+
+	start := time.Now()
+	var bytes int64
+	defer func() {
+		took := time.Since(start)
+		mibps := float64(bytes) / 1024 / 1024 * float64(time.Second) / float64(took)
+		log.Infow("Fetch done", "url", u, "out", outname, "took", took.Round(time.Millisecond), "bytes", bytes, "MiB/s", mibps, "err", rerr)
+		stats.Record(ctx, metrics.StorageFetchBytes.M(bytes))
+	}()
+
+	if err := os.RemoveAll(outname); err != nil {
+		return xerrors.Errorf("removing dest: %w", err)
+	}
+	defer func() {
+		// don't leave a partial fetch behind
+		if rerr != nil {
+			if err := os.RemoveAll(outname); err != nil {
+				log.Errorw("removing partial fetch", "out", outname, "error", err)
+			}
+		}
+	}()
+
+	if fileType != storiface.FTCache && fileType != storiface.FTUpdateCache {
+		bytes, err = o.download(ctx, key, outname)
+		return err
+	}
+
+	if err := os.MkdirAll(outname, 0755); err != nil { // nolint
+		return xerrors.Errorf("creating cache dir: %w", err)
+	}
+
+	var token string
+	var files int
+	for {
+		keys, next, err := o.list(ctx, key+"/", 1000, token)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			name := strings.TrimPrefix(k, key+"/")
+			if name == "" || strings.Contains(name, "/") {
+				// cache directories are flat
+				continue
+			}
+			n, err := o.download(ctx, k, filepath.Join(outname, name))
+			if err != nil {
+				return err
+			}
+			bytes += n
+			files++
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if files == 0 {
+		return xerrors.Errorf("no objects under %s: %w", key, storiface.ErrSectorNotFound)
+	}
+	return nil
+}
+
+func (o *ObjectStore) download(ctx context.Context, key, outname string) (int64, error) {
+	resp, err := o.do(ctx, "/"+key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint
+
+	f, err := os.Create(outname)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.CopyBuffer(f, resp.Body, make([]byte, CopyBuf))
+	if err != nil {
+		f.Close() // nolint
+		return n, xerrors.Errorf("reading object %s: %w", key, err)
+	}
+	return n, f.Close()
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// list returns the keys of up to max objects under prefix, and the token to
+// continue the listing with, empty when there are no more objects.
+func (o *ObjectStore) list(ctx context.Context, prefix string, max int, token string) ([]string, string, error) {
+	query := map[string]string{
+		"list-type": "2",
+		"max-keys":  fmt.Sprint(max),
+		"prefix":    prefix,
+	}
+	if token != "" {
+		query["continuation-token"] = token
+	}
+
+	resp, err := o.do(ctx, "/", query)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close() // nolint
+
+	var res listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, "", xerrors.Errorf("decoding object listing: %w", err)
+	}
+
+	keys := make([]string, len(res.Contents))
+	for i, c := range res.Contents {
+		keys[i] = c.Key
+	}
+	if !res.IsTruncated {
+		return keys, "", nil
+	}
+	return keys, res.NextContinuationToken, nil
+}
+
+// do sends a signed GET request for the object path, / for the bucket.
+func (o *ObjectStore) do(ctx context.Context, objPath string, query map[string]string) (*http.Response, error) {
+	u := *o.endpoint
+	p := objPath
+	if o.cfg.PathStyle {
+		p = "/" + o.cfg.Bucket + objPath
+	} else {
+		u.Host = o.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(o.endpoint.Path, "/") + p
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %w", err)
+	}
+	if o.cfg.AccessKey != "" {
+		signV4(req, o.cfg.AccessKey, o.cfg.SecretKey, o.cfg.Region, o.now())
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("do request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close() // nolint
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, xerrors.Errorf("object %s: %w", objPath, storiface.ErrSectorNotFound)
+		}
+		return nil, xerrors.Errorf("non-200 code: %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// signV4 signs the request with AWS signature version 4, the payload is left
+// unsigned unless the request has an x-amz-content-sha256 header already.
+func signV4(req *http.Request, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// sign the host, the range and all x-amz- headers
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonPath := req.URL.EscapedPath()
+	if canonPath == "" {
+		canonPath = "/"
+	}
+	canonRequest := strings.Join([]string{
+		req.Method,
+		canonPath,
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	reqHash := sha256.Sum256([]byte(canonRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by key, as signed requests need it.
+func canonicalQuery(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = awsEscape(k, true) + "=" + awsEscape(query[k], true)
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the RFC 3986 unreserved
+// characters, as AWS signatures expect, slashes are kept unless encodeSlash.
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectStore returns the object store the file URL is in, nil when it isn't
+// configured on this node.
+func (r *Remote) objectStore(u string) *ObjectStore {
+	for _, o := range r.objectStores {
+		if o.owns(u) {
+			return o
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package paths
+
+import (
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultPartialFileHandlerName is the registered name of DefaultPartialFileHandler.
+const DefaultPartialFileHandlerName = "default"
+
+var (
+	pfHandlersLk sync.Mutex
+	pfHandlers   = map[string]func() (PartialFileHandler, error){
+		DefaultPartialFileHandlerName: func() (PartialFileHandler, error) {
+			return &DefaultPartialFileHandler{}, nil
+		},
+	}
+)
+
+// RegisterPartialFileHandler makes a PartialFileHandler implementation
+// selectable by name in the node config. It's meant to be called from init
+// functions, and panics if the name is already taken.
+func RegisterPartialFileHandler(name string, newHandler func() (PartialFileHandler, error)) {
+	pfHandlersLk.Lock()
+	defer pfHandlersLk.Unlock()
+
+	if _, ok := pfHandlers[name]; ok {
+		panic("partial file handler already registered: " + name)
+	}
+	pfHandlers[name] = newHandler
+}
+
+// NewPartialFileHandler creates the PartialFileHandler registered under name.
+// An empty name selects the default handler.
+func NewPartialFileHandler(name string) (PartialFileHandler, error) {
+	if name == "" {
+		name = DefaultPartialFileHandlerName
+	}
+
+	pfHandlersLk.Lock()
+	newHandler, ok := pfHandlers[name]
+	pfHandlersLk.Unlock()
+
+	if !ok {
+		return nil, xerrors.Errorf("unknown partial file handler %q, known handlers: %v", name, partialFileHandlerNames())
+	}
+
+	return newHandler()
+}
+
+func partialFileHandlerNames() []string {
+	pfHandlersLk.Lock()
+	defer pfHandlersLk.Unlock()
+
+	names := make([]string, 0, len(pfHandlers))
+	for n := range pfHandlers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -17,10 +17,13 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 	"golang.org/x/xerrors"
 
 	"github.com/filecoin-project/go-state-types/abi"
 
+	"github.com/filecoin-project/lotus/metrics"
 	"github.com/filecoin-project/lotus/storage/sealer/fsutil"
 	"github.com/filecoin-project/lotus/storage/sealer/partialfile"
 	"github.com/filecoin-project/lotus/storage/sealer/storiface"
@@ -45,6 +48,12 @@ type Remote struct {
 	fetching map[abi.SectorID]chan struct{}
 
 	pfHandler PartialFileHandler
+
+	client *http.Client
+
+	compress storiface.SectorFileType
+
+	objectStores []*ObjectStore
 }
 
 func (r *Remote) RemoveCopies(ctx context.Context, s abi.SectorID, typ storiface.SectorFileType) error {
@@ -89,9 +98,32 @@ func NewRemote(local Store, index SectorIndex, auth http.Header, fetchLimit int,
 
 		fetching:  map[abi.SectorID]chan struct{}{},
 		pfHandler: pfHandler,
+
+		client: http.DefaultClient,
 	}
 }
 
+// SetHTTPClient replaces the HTTP client used for requests to other storage
+// nodes (fetches, stat/allocation checks, vanilla proofs, removals). It must
+// be called before the Remote is used. http.DefaultClient is used by default.
+func (r *Remote) SetHTTPClient(c *http.Client) {
+	r.client = c
+}
+
+// SetCompressTypes sets the file types requested compressed when fetching
+// from other nodes. Nodes which don't support compression send the files
+// as-is. It must be called before the Remote is used.
+func (r *Remote) SetCompressTypes(ft storiface.SectorFileType) {
+	r.compress = ft
+}
+
+// AddObjectStore makes the files of an object store path fetchable by this
+// node. Object store paths are only fetched from, the other operations of
+// the Remote skip them. It must be called before the Remote is used.
+func (r *Remote) AddObjectStore(o *ObjectStore) {
+	r.objectStores = append(r.objectStores, o)
+}
+
 func (r *Remote) AcquireSector(ctx context.Context, s storiface.SectorRef, existing storiface.SectorFileType, allocate storiface.SectorFileType, pathType storiface.PathType, op storiface.AcquireMode) (storiface.SectorPaths, storiface.SectorPaths, error) {
 	if existing|allocate != existing^allocate {
 		return storiface.SectorPaths{}, storiface.SectorPaths{}, xerrors.New("can't both find and allocate a sector")
@@ -188,7 +220,7 @@ func (r *Remote) AcquireSector(ctx context.Context, s storiface.SectorRef, exist
 			continue
 		}
 
-		if op == storiface.AcquireMove {
+		if op == storiface.AcquireMove && !isObjectURL(url) {
 			id := storiface.ID(storageID)
 			if err := r.deleteFromRemote(ctx, url, []storiface.ID{id}); err != nil {
 				log.Warnf("deleting sector %v from %s (delete %s): %+v", s, storageID, url, err)
@@ -239,7 +271,7 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 				return "", xerrors.Errorf("removing dest: %w", err)
 			}
 
-			err = r.fetchThrottled(ctx, url, tempDest)
+			err = r.fetchThrottled(ctx, url, tempDest, fileType)
 			if err != nil {
 				merr = multierror.Append(merr, xerrors.Errorf("fetch error %s (storage %s) -> %s: %w", url, info.ID, tempDest, err))
 				// fetching failed, remove temp file
@@ -263,7 +295,22 @@ func (r *Remote) acquireFromRemote(ctx context.Context, s abi.SectorID, fileType
 	return "", xerrors.Errorf("failed to acquire sector %v from remote (tried %v): %w", s, si, merr)
 }
 
-func (r *Remote) fetchThrottled(ctx context.Context, url, outname string) (rerr error) {
+func (r *Remote) fetchThrottled(ctx context.Context, url, outname string, fileType storiface.SectorFileType) (rerr error) {
+	ctx, span := trace.StartSpan(ctx, "Remote.fetch")
+	span.AddAttributes(trace.StringAttribute("url", url), trace.StringAttribute("file_type", fileType.String()))
+	defer func() {
+		if rerr != nil {
+			span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: rerr.Error()})
+		}
+		span.End()
+	}()
+
+	release, err := acquireTaskFetch(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	if len(r.limit) >= cap(r.limit) {
 		log.Infof("Throttling fetch, %d already running", len(r.limit))
 	}
@@ -279,7 +326,28 @@ func (r *Remote) fetchThrottled(ctx context.Context, url, outname string) (rerr
 		return xerrors.Errorf("context error while waiting for fetch limiter: %w", ctx.Err())
 	}
 
-	return fetch(ctx, url, outname, r.auth)
+	header := r.auth.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if r.compress&fileType != 0 {
+		header.Set("Accept-Encoding", acceptEncoding())
+	} else {
+		// stop the transport from asking for gzip on its own
+		header.Set("Accept-Encoding", "identity")
+	}
+
+	ctx, _ = tag.New(ctx, tag.Upsert(metrics.FileType, fileType.String()))
+
+	if isObjectURL(url) {
+		o := r.objectStore(url)
+		if o == nil {
+			return xerrors.Errorf("object store of %s isn't configured on this node", url)
+		}
+		return o.Fetch(ctx, url, outname, fileType)
+	}
+
+	return fetch(ctx, r.client, url, outname, header)
 }
 
 func (r *Remote) checkAllocated(ctx context.Context, url string, spt abi.RegisteredSealProof, offset, size abi.PaddedPieceSize) (bool, error) {
@@ -291,7 +359,7 @@ func (r *Remote) checkAllocated(ctx context.Context, url string, spt abi.Registe
 	req.Header = r.auth.Clone()
 	req = req.WithContext(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return false, xerrors.Errorf("do request: %w", err)
 	}
@@ -339,6 +407,10 @@ storeLoop:
 			}
 		}
 		for _, url := range info.URLs {
+			if isObjectURL(url) {
+				// object stores are read-only, files stay in the bucket
+				continue
+			}
 			if err := r.deleteFromRemote(ctx, url, keepIn); err != nil {
 				log.Warnf("remove %s: %+v", url, err)
 				continue
@@ -364,7 +436,7 @@ func (r *Remote) deleteFromRemote(ctx context.Context, url string, keepIn storif
 	req.Header = r.auth
 	req = req.WithContext(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return xerrors.Errorf("do request: %w", err)
 	}
@@ -396,6 +468,10 @@ func (r *Remote) FsStat(ctx context.Context, id storiface.ID) (fsutil.FsStat, er
 	if len(si.URLs) == 0 {
 		return fsutil.FsStat{}, xerrors.Errorf("no known URLs for remote storage %s", id)
 	}
+	if isObjectURL(si.URLs[0]) {
+		// object stores have no capacity limit to report
+		return fsutil.FsStat{}, nil
+	}
 
 	rl, err := url.Parse(si.URLs[0])
 	if err != nil {
@@ -411,7 +487,7 @@ func (r *Remote) FsStat(ctx context.Context, id storiface.ID) (fsutil.FsStat, er
 	req.Header = r.auth
 	req = req.WithContext(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return fsutil.FsStat{}, xerrors.Errorf("do request: %w", err)
 	}
@@ -466,7 +542,7 @@ func (r *Remote) readRemote(ctx context.Context, url string, offset, size abi.Pa
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
 	req = req.WithContext(ctx)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := r.client.Do(req)
 	if err != nil {
 		return nil, xerrors.Errorf("do request: %w", err)
 	}
@@ -544,6 +620,9 @@ func (r *Remote) CheckIsUnsealed(ctx context.Context, s storiface.SectorRef, off
 
 	for _, info := range si {
 		for _, url := range info.URLs {
+			if isObjectURL(url) {
+				continue
+			}
 			ok, err := r.checkAllocated(ctx, url, s.ProofType, offset, size)
 			if err != nil {
 				log.Warnw("check if remote has piece", "url", url, "error", err)
@@ -712,6 +791,9 @@ func (r *Remote) Reader(ctx context.Context, s storiface.SectorRef, offset, size
 	var lastErr error
 	for _, info := range si {
 		for _, url := range info.URLs {
+			if isObjectURL(url) {
+				continue
+			}
 			// checkAllocated makes a JSON RPC query to a remote worker to determine if it has
 			// unsealed piece in their unsealed sector file.
 			ok, err := r.checkAllocated(ctx, url, s.ProofType, offset, size)
@@ -751,15 +833,25 @@ func (r *Remote) Reserve(ctx context.Context, sid storiface.SectorRef, ft storif
 	}, nil
 }
 
+// GenerateSingleVanillaProof generates the proof locally when all the files
+// of the sector are in local storage. Otherwise the sealed and cache files are
+// resolved separately, and the remote stores holding both are asked for the
+// proof in turn. Sector files are only fetched for proving when object stores
+// are the only ones holding both, as they can't generate proofs; the fetched
+// copy is declared, so later proofs of the sector are local. A sector split
+// across stores fails with an error naming where each file type is.
 func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.ActorID, sinfo storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
 	p, err := r.local.GenerateSingleVanillaProof(ctx, minerID, sinfo, ppt)
 	if err != errPathNotFound {
 		return p, err
 	}
 
-	sid := abi.SectorID{
-		Miner:  minerID,
-		Number: sinfo.SectorNumber,
+	sr := storiface.SectorRef{
+		ID: abi.SectorID{
+			Miner:  minerID,
+			Number: sinfo.SectorNumber,
+		},
+		ProofType: sinfo.SealProof,
 	}
 
 	ft := storiface.FTSealed | storiface.FTCache
@@ -767,11 +859,42 @@ func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 		ft = storiface.FTUpdate | storiface.FTUpdateCache
 	}
 
-	si, err := r.index.StorageFindSector(ctx, sid, ft, 0, false)
+	localPaths, _, err := r.local.AcquireSector(ctx, sr, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireMove)
 	if err != nil {
-		return nil, xerrors.Errorf("finding sector %d failed: %w", sid, err)
+		return nil, xerrors.Errorf("acquire local sector: %w", err)
 	}
 
+	var local storiface.SectorFileType
+	stores := map[storiface.SectorFileType][]storiface.SectorStorageInfo{}
+	for _, t := range ft.AllSet() {
+		if storiface.PathByType(localPaths, t) != "" {
+			local |= t
+		}
+
+		si, err := r.index.StorageFindSector(ctx, sr.ID, t, 0, false)
+		if err != nil {
+			return nil, xerrors.Errorf("finding sector %d failed: %w", sr.ID, err)
+		}
+		stores[t] = si
+	}
+
+	plan, err := planVanillaProof(ft, local, stores)
+	if err != nil {
+		return nil, xerrors.Errorf("generating vanilla proof for sector %d: %w", sr.ID.Number, err)
+	}
+
+	if plan.Fetch {
+		if _, _, err := r.AcquireSector(ctx, sr, ft, storiface.FTNone, storiface.PathStorage, storiface.AcquireCopy); err != nil {
+			return nil, xerrors.Errorf("fetching sector %d from object store for proving: %w", sr.ID.Number, err)
+		}
+		return r.local.GenerateSingleVanillaProof(ctx, minerID, sinfo, ppt)
+	}
+
+	return r.remoteVanillaProof(ctx, plan.Remote, minerID, sinfo, ppt)
+}
+
+// remoteVanillaProof asks the stores in si for the vanilla proof of a sector.
+func (r *Remote) remoteVanillaProof(ctx context.Context, si []storiface.SectorStorageInfo, minerID abi.ActorID, sinfo storiface.PostSectorChallenge, ppt abi.RegisteredPoStProof) ([]byte, error) {
 	requestParams := SingleVanillaParams{
 		Miner:     minerID,
 		Sector:    sinfo,
@@ -784,6 +907,10 @@ func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 
 	for _, info := range si {
 		for _, u := range info.BaseURLs {
+			if isObjectURL(u) {
+				// object stores can't compute proofs, the files have to be fetched first
+				continue
+			}
 			url := fmt.Sprintf("%s/vanilla/single", u)
 
 			req, err := http.NewRequest("POST", url, strings.NewReader(string(jreq)))
@@ -796,7 +923,7 @@ func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 			}
 			req = req.WithContext(ctx)
 
-			resp, err := http.DefaultClient.Do(req)
+			resp, err := r.client.Do(req)
 			if err != nil {
 				return nil, xerrors.Errorf("do request: %w", err)
 			}
@@ -831,7 +958,7 @@ func (r *Remote) GenerateSingleVanillaProof(ctx context.Context, minerID abi.Act
 		}
 	}
 
-	return nil, xerrors.Errorf("sector not found")
+	return nil, xerrors.Errorf("generating vanilla proof for sector %d: %w", sinfo.SectorNumber, storiface.ErrSectorNotFound)
 }
 
 var _ Store = &Remote{}
@@ -0,0 +1,81 @@
+package paths
+
+import (
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+// vanillaPlan is where the vanilla proof of a sector is generated when its
+// files aren't all in local storage. Each file type is resolved on its own, so
+// that a sector with its sealed file in one place and its cache in another is
+// reported as split rather than as missing.
+type vanillaPlan struct {
+	// Remote are the stores holding all the files, which can generate the
+	// proof without any sector data being transferred. They are tried in
+	// order.
+	Remote []storiface.SectorStorageInfo
+
+	// Fetch is set when only object stores hold all the files. Object stores
+	// can't generate proofs, so the files are fetched to local storage and
+	// proven there.
+	Fetch bool
+}
+
+// planVanillaProof resolves the stores able to prove the file types in ft,
+// given the types found in local storage and the stores holding each type per
+// the index. It fails when no single store holds all the types.
+//
+// Stores which are only reachable as object stores aren't in Remote; when
+// they are the only ones holding all the types, the plan is to fetch.
+func planVanillaProof(ft, local storiface.SectorFileType, stores map[storiface.SectorFileType][]storiface.SectorStorageInfo) (vanillaPlan, error) {
+	var plan vanillaPlan
+
+	types := ft.AllSet()
+	holds := map[storiface.ID]int{}
+	for _, t := range types {
+		for _, si := range stores[t] {
+			holds[si.ID]++
+		}
+	}
+	added := map[storiface.ID]struct{}{}
+	var objectStores bool
+	for _, t := range types {
+		for _, si := range stores[t] {
+			if _, ok := added[si.ID]; ok || holds[si.ID] < len(types) {
+				continue
+			}
+			added[si.ID] = struct{}{}
+			if objectOnly(si) {
+				objectStores = true
+				continue
+			}
+			plan.Remote = append(plan.Remote, si)
+		}
+	}
+
+	if len(plan.Remote) == 0 && objectStores {
+		plan.Fetch = true
+		return plan, nil
+	}
+	if len(plan.Remote) == 0 {
+		where := map[storiface.SectorFileType][]storiface.ID{}
+		for _, t := range types {
+			for _, si := range stores[t] {
+				where[t] = append(where[t], si.ID)
+			}
+		}
+		return vanillaPlan{}, xerrors.Errorf("no store holds all of sector files %s (local %s, remote %v): %w", ft, local, where, storiface.ErrSectorNotFound)
+	}
+	return plan, nil
+}
+
+// objectOnly returns whether the store is only reachable as an object store.
+func objectOnly(si storiface.SectorStorageInfo) bool {
+	for _, u := range si.BaseURLs {
+		if !isObjectURL(u) {
+			return false
+		}
+	}
+	return len(si.BaseURLs) > 0
+}
@@ -0,0 +1,70 @@
+package paths
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+
+	"github.com/filecoin-project/lotus/storage/sealer/storiface"
+)
+
+func TestPlanVanillaProof(t *testing.T) {
+	ft := storiface.FTSealed | storiface.FTCache
+	a := storiface.SectorStorageInfo{ID: "a"}
+	b := storiface.SectorStorageInfo{ID: "b"}
+	c := storiface.SectorStorageInfo{ID: "c"}
+
+	// sealed file on a remote store, cache local: the sector isn't fetched,
+	// so it can't be proven
+	_, err := planVanillaProof(ft, storiface.FTCache, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {a},
+	})
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound))
+
+	// sealed and cache on different remote stores, neither can prove alone
+	_, err = planVanillaProof(ft, storiface.FTNone, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {a},
+		storiface.FTCache:  {b},
+	})
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound))
+	require.ErrorContains(t, err, "no store holds all")
+
+	// only the stores with both files prove, each of them in turn
+	plan, err := planVanillaProof(ft, storiface.FTCache, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {b, a, c},
+		storiface.FTCache:  {c, a},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []storiface.SectorStorageInfo{a, c}, plan.Remote)
+
+	// cache nowhere to be found
+	_, err = planVanillaProof(ft, storiface.FTNone, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {a},
+	})
+	require.True(t, xerrors.Is(err, storiface.ErrSectorNotFound))
+}
+
+func TestPlanVanillaProofObjectStore(t *testing.T) {
+	ft := storiface.FTSealed | storiface.FTCache
+	node := storiface.SectorStorageInfo{ID: "node", BaseURLs: []string{"http://node/remote"}}
+	bucket := storiface.SectorStorageInfo{ID: "bucket", BaseURLs: []string{"s3://sectors/archive"}}
+
+	// only a bucket holds the sector: fetch it
+	plan, err := planVanillaProof(ft, storiface.FTNone, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {bucket},
+		storiface.FTCache:  {bucket},
+	})
+	require.NoError(t, err)
+	require.True(t, plan.Fetch)
+	require.Empty(t, plan.Remote)
+
+	// a node holding the sector proves it, the bucket is skipped
+	plan, err = planVanillaProof(ft, storiface.FTNone, map[storiface.SectorFileType][]storiface.SectorStorageInfo{
+		storiface.FTSealed: {bucket, node},
+		storiface.FTCache:  {bucket, node},
+	})
+	require.NoError(t, err)
+	require.False(t, plan.Fetch)
+	require.Equal(t, []storiface.SectorStorageInfo{node}, plan.Remote)
+}
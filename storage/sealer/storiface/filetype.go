@@ -51,6 +51,19 @@ var FsOverheadFinalized = map[SectorFileType]int{
 	FTCache:       1,
 }
 
+// DefaultFileCosts are the cost hints of sector file types in paths which
+// don't declare their own. A cost hints how expensive a file is to get back
+// once removed from a path, relative to the other types: sealed and update
+// files are the result of sealing, cache files are small and quickly
+// refetched.
+var DefaultFileCosts = map[SectorFileType]uint64{
+	FTUnsealed:    50,
+	FTSealed:      100,
+	FTCache:       10,
+	FTUpdate:      100,
+	FTUpdateCache: 10,
+}
+
 type SectorFileType int
 
 func TypeFromString(s string) (SectorFileType, error) {
@@ -170,6 +183,23 @@ func (t SectorFileType) Allowed(allowTypes []string, denyTypes []string) bool {
 	return t.SubAllowed(allowTypes, denyTypes) == 0
 }
 
+// Cost returns the cost hint of the file type given the costs declared by a
+// path, keyed by file type name. Types without a declared cost use
+// DefaultFileCosts, for more than one type the highest cost is returned.
+func (t SectorFileType) Cost(costs map[string]uint64) uint64 {
+	var cost uint64
+	for _, ft := range t.AllSet() {
+		c, ok := costs[ft.String()]
+		if !ok {
+			c = DefaultFileCosts[ft]
+		}
+		if c > cost {
+			cost = c
+		}
+	}
+	return cost
+}
+
 func (t SectorFileType) StoreSpaceUse(ssize abi.SectorSize) (uint64, error) {
 	var need uint64
 	for _, pathType := range PathTypes {
@@ -40,8 +40,8 @@ type StorageInfo struct {
 	// ID is the UUID of the storage path
 	ID ID
 
-	// URLs for remote access
-	URLs []string // TODO: Support non-http transports
+	// URLs for remote access, s3:// URLs for object store paths
+	URLs []string
 
 	// Storage path weight; higher number means that the path will be preferred more often
 	Weight uint64
@@ -57,6 +57,10 @@ type StorageInfo struct {
 	// CanStore is true when the path is allowed to be used for long-term storage
 	CanStore bool
 
+	// ReadOnly is true when no new files are to be placed in the path. Sectors
+	// already in it can still be read and fetched.
+	ReadOnly bool
+
 	// Groups is the list of path groups this path belongs to
 	Groups []Group
 
@@ -86,6 +90,10 @@ type StorageInfo struct {
 	// - "update-cache"
 	// Any other value will generate a warning and be ignored.
 	DenyTypes []string
+
+	// FileCosts are the cost hints of file types in the path, keyed by file
+	// type name, see SectorFileType.Cost.
+	FileCosts map[string]uint64
 }
 
 type HealthReport struct {
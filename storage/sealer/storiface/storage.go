@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/ipfs/go-cid"
 
@@ -161,12 +162,48 @@ type SecDataHttpHeader struct {
 // StorageConfig .lotusstorage/storage.json
 type StorageConfig struct {
 	StoragePaths []LocalPath
+	ObjectStores []ObjectStorePath `json:",omitempty"`
 }
 
 type LocalPath struct {
 	Path string
 }
 
+// ObjectStorePath is a read-only storage path backed by a bucket of an
+// S3-compatible object store. Sector files are objects named like the files in
+// local paths, under Prefix: [Prefix/]sealed/s-t01000-1, and the files of cache
+// directories are objects under [Prefix/]cache/s-t01000-1/. Nodes with the
+// object store configured declare the sectors found in the bucket to the
+// index, and fetch files from it when they are not in local storage.
+type ObjectStorePath struct {
+	// ID of the storage path in the index, the same on all nodes configuring
+	// the object store
+	ID ID
+
+	// Endpoint is the base URL of the S3 API, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	// Region requests are signed for, us-east-1 when empty
+	Region string
+	Bucket string
+	Prefix string
+	// PathStyle addresses the bucket as Endpoint/Bucket instead of as a
+	// subdomain of Endpoint, needed by most self-hosted object stores
+	PathStyle bool
+
+	// AccessKey and SecretKey are the credentials requests are signed with,
+	// requests are anonymous when both are empty
+	AccessKey string
+	SecretKey string
+
+	// A high weight means files are more likely to be fetched from this path
+	Weight uint64
+	// List of storage groups this path belongs to
+	Groups []string
+	// AllowTypes lists the file types declared from the bucket, all types
+	// when empty
+	AllowTypes []string
+}
+
 // LocalStorageMeta [path]/sectorstore.json
 type LocalStorageMeta struct {
 	ID ID
@@ -184,6 +221,16 @@ type LocalStorageMeta struct {
 	// (0 = unlimited)
 	MaxStorage uint64
 
+	// ReadOnly paths keep serving the sectors they hold, locally and to
+	// fetches, but no new files are placed in them. It can be toggled at
+	// runtime by editing this file and redeclaring the path.
+	ReadOnly bool
+
+	// MinFreeSpace specifies the number of bytes which must always stay free on
+	// the filesystem. Allocations and fetches which would go below it are refused.
+	// (0 = no floor)
+	MinFreeSpace uint64
+
 	// List of storage groups this path belongs to
 	Groups []string
 
@@ -214,4 +261,50 @@ type LocalStorageMeta struct {
 	// - "update-cache"
 	// Any other value will generate a warning and be ignored.
 	DenyTypes []string
+
+	// FileCosts declares how expensive files of each type are to get back
+	// once removed from this path, keyed by the file type names above. Files
+	// with higher costs are kept local in preference, ones with lower costs
+	// are evicted first. Types not listed use storiface.DefaultFileCosts:
+	// sealed and update 100, unsealed 50, cache and update-cache 10.
+	FileCosts map[string]uint64 `json:",omitempty"`
+}
+
+// PathBench is the result of a read benchmark of a local storage path, see
+// paths.Local.Bench.
+type PathBench struct {
+	ID ID
+	// Files is the number of sealed and update files the reads were spread over
+	Files int
+
+	// SeqBytes were read sequentially, in large chunks, in SeqTime
+	SeqBytes int64
+	SeqTime  time.Duration
+
+	// RandReads of RandBlockSize bytes were made at random offsets, one at a
+	// time, in RandTime
+	RandReads     int64
+	RandBlockSize int64
+	RandTime      time.Duration
+
+	LatencyAvg time.Duration
+	LatencyP50 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+}
+
+// SeqThroughput returns the sequential read throughput, in bytes/s.
+func (b PathBench) SeqThroughput() float64 {
+	if b.SeqTime <= 0 {
+		return 0
+	}
+	return float64(b.SeqBytes) / b.SeqTime.Seconds()
+}
+
+// RandIOPS returns the random reads completed per second.
+func (b PathBench) RandIOPS() float64 {
+	if b.RandTime <= 0 {
+		return 0
+	}
+	return float64(b.RandReads) / b.RandTime.Seconds()
 }